@@ -1,38 +1,242 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"simstack/internal/artifacts"
+	"simstack/internal/config"
+	"simstack/internal/headless"
+	"simstack/internal/logging"
+	"simstack/internal/orchestrator"
+	"simstack/internal/runstore"
 	"simstack/internal/server"
+	"simstack/internal/statsd"
+	"simstack/internal/tlscert"
+	"simstack/internal/tracing"
 )
 
 func main() {
-	addr := getEnv("SIMSTACK_ADDR", ":8080")
+	configFile := flag.String("config", "", "YAML or JSON settings `file`, which environment variables override; or set SIMSTACK_CONFIG")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [--config FILE]          serve the API\n       %[1]s [--config FILE] run ...  run one goal in-process; run --help for its flags\n\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() > 0 && flag.Arg(0) != "run" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	// Through the environment, so that reloads read the same file
+	if *configFile != "" {
+		_ = os.Setenv("SIMSTACK_CONFIG", *configFile)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("startup: %v", err)
+	}
+	// The log package's output goes through the same handler
+	slog.SetDefault(logging.New(os.Stderr, cfg.LogFormat))
+	// One attribute per setting, so JSON logs keep them apart
+	var settings []any
+	for _, s := range config.Settings(cfg) {
+		settings = append(settings, slog.String(s.Setting, s.Value))
+	}
+	slog.Info("configuration", settings...)
+
+	// "run" runs one goal here and exits, for CI: no server, no WebSocket
+	if flag.Arg(0) == "run" {
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		code := headless.Main(ctx, cfg, flag.Args()[1:], os.Stdout, os.Stderr)
+		stop()
+		os.Exit(code)
+	}
 
-	srv := server.NewServer()
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		log.Fatalf("startup: %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = shutdownTracing(ctx)
+	}()
+
+	var opts []orchestrator.Option
+	stats, err := statsd.New(cfg.Statsd)
+	if err != nil {
+		log.Fatalf("startup: statsd: %v", err)
+	}
+	if stats != nil {
+		defer stats.Close()
+		opts = append(opts, orchestrator.WithMetricsSink(stats))
+		log.Printf("pushing metrics to statsd at %s", cfg.Statsd.Addr)
+	}
+	switch cfg.RunStore {
+	case "sqlite":
+		store, err := runstore.OpenSQLite(context.Background(), cfg.SQLitePath)
+		if err != nil {
+			log.Fatalf("startup: %v", err)
+		}
+		defer store.Close()
+		opts = append(opts, orchestrator.WithRunStore(store))
+		log.Printf("run history in %s", cfg.SQLitePath)
+	case "postgres":
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		store, err := runstore.OpenPostgres(ctx, cfg.PostgresDSN)
+		cancel()
+		if err != nil {
+			log.Fatalf("startup: %v", err)
+		}
+		defer store.Close()
+		opts = append(opts, orchestrator.WithRunStore(store))
+		log.Printf("run history in postgres")
+	}
+
+	if cfg.ArtifactDir != "" {
+		store, err := artifacts.NewDisk(cfg.ArtifactDir, artifacts.DiskOptions{
+			MaxArtifactBytes: cfg.ArtifactMaxBytes,
+			BudgetBytes:      cfg.ArtifactDiskBytes,
+			Retention:        cfg.ArtifactRetention,
+		})
+		if err != nil {
+			log.Fatalf("startup: %v", err)
+		}
+		go store.Run(context.Background(), cfg.ArtifactGCInterval)
+		opts = append(opts, orchestrator.WithArtifactStore(store))
+		log.Printf("artifacts in %s", cfg.ArtifactDir)
+	}
+
+	srv := server.NewServer(cfg, opts...)
+
+	// A mistyped model silently degrades every run to fallback planning;
+	// LLM_STRICT_MODEL=true refuses to start instead of warning
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	err = srv.CheckModel(ctx, cfg.StrictModel)
+	cancel()
+	if err != nil {
+		log.Fatalf("startup: %v", err)
+	}
+
+	// Probe the simulators in the background so /readyz and the dashboard
+	// see one go down before a run does
+	go srv.PollSimulators(context.Background())
+
+	// With SIMSTACK_TLS_CERT and SIMSTACK_TLS_KEY the backend serves HTTPS
+	// itself, picking up renewed certificates without a restart
+	var cert *tlscert.Certificate
+	if cfg.TLSCert != "" {
+		cert, err = tlscert.Load(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			log.Fatalf("startup: %v", err)
+		}
+		if cfg.TLSReloadInterval > 0 {
+			go cert.Watch(context.Background(), cfg.TLSReloadInterval)
+		}
+	}
+
+	// SIGHUP reloads what can change without a restart, like
+	// POST /api/admin/reload, and the TLS certificate
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if cert != nil {
+				if err := cert.Reload(); err != nil {
+					log.Printf("reload: %v; keeping the previous certificate", err)
+				}
+			}
+			report, err := srv.Reload()
+			if err != nil {
+				log.Printf("reload: %v", err)
+				continue
+			}
+			log.Printf("configuration reloaded:\n%s", report)
+		}
+	}()
 
 	httpServer := &http.Server{
-		Addr:              addr,
+		Addr:              cfg.Addr,
 		Handler:           srv.Router,
 		ReadHeaderTimeout: 10 * time.Second,
 		ReadTimeout:       0,
 		WriteTimeout:      0,
 		IdleTimeout:       120 * time.Second,
 	}
+	if cert != nil {
+		httpServer.TLSConfig = tlscert.Config(cert)
+	}
+	// Plain HTTP on SIMSTACK_TLS_REDIRECT_ADDR only sends clients to HTTPS
+	var redirectServer *http.Server
+	if cfg.TLSRedirectAddr != "" {
+		redirectServer = &http.Server{
+			Addr:              cfg.TLSRedirectAddr,
+			Handler:           server.RedirectHTTPS(cfg.Addr),
+			ReadHeaderTimeout: 10 * time.Second,
+			IdleTimeout:       120 * time.Second,
+		}
+	}
 
-	log.Printf("SimStack backend listening on %s", addr)
-	if err := httpServer.ListenAndServe(); err != nil {
+	// SIGINT or SIGTERM stops taking requests and gives the runs in flight
+	// SIMSTACK_SHUTDOWN_GRACE to finish; a second signal exits at once
+	stopCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	serveErr := make(chan error, 2)
+	go func() {
+		if cert != nil {
+			log.Printf("SimStack backend listening on %s (HTTPS)", cfg.Addr)
+			// The certificate comes from TLSConfig, not the arguments
+			serveErr <- httpServer.ListenAndServeTLS("", "")
+			return
+		}
+		log.Printf("SimStack backend listening on %s", cfg.Addr)
+		serveErr <- httpServer.ListenAndServe()
+	}()
+	if redirectServer != nil {
+		go func() {
+			log.Printf("redirecting HTTP on %s to HTTPS", cfg.TLSRedirectAddr)
+			serveErr <- redirectServer.ListenAndServe()
+		}()
+	}
+	select {
+	case err := <-serveErr:
 		log.Fatalf("server error: %v", err)
+	case <-stopCtx.Done():
 	}
-}
+	stop()
 
-func getEnv(key, def string) string {
-	v := os.Getenv(key)
-	if v == "" {
-		return def
+	// Fail readiness first, and keep serving while the load balancers
+	// notice, so they stop sending new runs before the listener closes
+	srv.Unready()
+	if cfg.ShutdownDelay > 0 {
+		log.Printf("shutting down; not ready, taking requests for %s more", cfg.ShutdownDelay)
+		time.Sleep(cfg.ShutdownDelay)
+	}
+	log.Printf("shutting down; waiting up to %s for runs in flight", cfg.ShutdownGrace)
+	ctx, cancel = context.WithTimeout(context.Background(), cfg.ShutdownGrace)
+	defer cancel()
+	// Closing the listener and draining the runs go together: streaming
+	// handlers only return once their run ends
+	httpDone := make(chan error, 1)
+	go func() { httpDone <- httpServer.Shutdown(ctx) }()
+	if redirectServer != nil {
+		_ = redirectServer.Close()
+	}
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("shutdown: runs still going after %s were canceled", cfg.ShutdownGrace)
+	}
+	if err := <-httpDone; err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		log.Printf("shutdown: %v", err)
 	}
-	return v
+	log.Printf("shutdown complete")
 }
@@ -0,0 +1,18 @@
+// Command simstack-cli drives a SimStack backend from scripts: submit runs,
+// tail their events, and fetch results. Run it without arguments for usage.
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+
+	"simstack/internal/cli"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	code := cli.Main(ctx, os.Args[1:], os.Stdout, os.Stderr, os.Getenv)
+	stop()
+	os.Exit(code)
+}
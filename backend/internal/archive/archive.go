@@ -0,0 +1,584 @@
+// Package archive moves run history between stores: Export writes the runs of
+// a RunStore, optionally with their artifacts, as a tar.gz or JSONL stream;
+// Import reads one back into another store. Both work one run at a time, so
+// neither holds the whole history in memory.
+package archive
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"simstack/internal/artifacts"
+	"simstack/internal/runstore"
+	"simstack/internal/types"
+)
+
+// SchemaVersion is written into every archive. Import refuses archives from
+// a newer version and upgrades older ones.
+const SchemaVersion = 1
+
+// Formats Export can write.
+const (
+	FormatTar   = "tar"
+	FormatJSONL = "jsonl"
+)
+
+// Conflict policies for runs whose ID is already in the store.
+const (
+	OnConflictSkip  = "skip"
+	OnConflictRemap = "remap"
+)
+
+var (
+	ErrFormat  = errors.New("archive: malformed archive")
+	ErrVersion = errors.New("archive: unsupported schema version")
+)
+
+// pageSize is how many runs Export asks the store for at a time.
+const pageSize = 100
+
+// Header opens every archive.
+type Header struct {
+	SchemaVersion int       `json:"schema_version"`
+	ExportedAt    time.Time `json:"exported_at"`
+	Artifacts     bool      `json:"artifacts"`
+}
+
+// Entry is one run with what the run record alone does not carry.
+type Entry struct {
+	Run            types.RunRecord        `json:"run"`
+	GoalEmbedding  []float64              `json:"goal_embedding,omitempty"`
+	EmbeddingSpace string                 `json:"embedding_space,omitempty"`
+	LLMCalls       []types.LLMAuditRecord `json:"llm_calls,omitempty"`
+}
+
+// line is one JSONL record; exactly one field is set. The end record lets
+// Import tell a complete stream from a truncated one.
+type line struct {
+	Header *Header `json:"header,omitempty"`
+	Run    *Entry  `json:"run,omitempty"`
+	End    *end    `json:"end,omitempty"`
+}
+
+type end struct {
+	Runs int `json:"runs"`
+}
+
+// Filter selects the runs to export. Zero fields match everything.
+type Filter struct {
+	Status string
+	// Runs started in [Since, Until)
+	Since time.Time
+	Until time.Time
+}
+
+func (f Filter) match(run types.RunRecord) bool {
+	return (f.Since.IsZero() || !run.StartedAt.Before(f.Since)) && (f.Until.IsZero() || run.StartedAt.Before(f.Until))
+}
+
+// ExportOptions configure Export.
+type ExportOptions struct {
+	Format string
+	Filter Filter
+	// Include artifact content; only the tar format carries it
+	Artifacts bool
+}
+
+// Export writes the runs matching opts, newest first, to w. Runs that start
+// while the export is underway are left out.
+func Export(ctx context.Context, w io.Writer, store runstore.RunStore, arts artifacts.Store, opts ExportOptions) (int, error) {
+	var enc encoder
+	switch opts.Format {
+	case FormatTar, "":
+		enc = newTarEncoder(w)
+	case FormatJSONL:
+		if opts.Artifacts {
+			return 0, fmt.Errorf("archive: artifacts need the %s format", FormatTar)
+		}
+		enc = &jsonlEncoder{enc: json.NewEncoder(w)}
+	default:
+		return 0, fmt.Errorf("archive: unknown format %q", opts.Format)
+	}
+	started := time.Now().UTC()
+	if err := enc.header(Header{SchemaVersion: SchemaVersion, ExportedAt: started, Artifacts: opts.Artifacts && arts != nil}); err != nil {
+		return 0, err
+	}
+
+	// Runs saved meanwhile shift the pages; seen drops the repeats
+	seen := map[string]bool{}
+	count := 0
+	for offset := 0; ; offset += pageSize {
+		page, err := store.List(ctx, runstore.ListOptions{Limit: pageSize, Offset: offset, Status: opts.Filter.Status})
+		if err != nil {
+			return count, err
+		}
+		for _, run := range page {
+			if seen[run.ID] || run.StartedAt.After(started) || !opts.Filter.match(run) {
+				continue
+			}
+			seen[run.ID] = true
+			calls, err := store.LLMCalls(ctx, run.ID)
+			if err != nil && !errors.Is(err, runstore.ErrNotFound) {
+				return count, err
+			}
+			entry := Entry{Run: run, GoalEmbedding: run.GoalEmbedding, EmbeddingSpace: run.EmbeddingSpace, LLMCalls: calls}
+			if err := enc.run(entry); err != nil {
+				return count, err
+			}
+			if opts.Artifacts && arts != nil {
+				if err := exportArtifacts(ctx, enc, arts, run); err != nil {
+					return count, err
+				}
+			}
+			count++
+		}
+		// Newest first: past Since, nothing older can match
+		if len(page) < pageSize || (!opts.Filter.Since.IsZero() && page[len(page)-1].StartedAt.Before(opts.Filter.Since)) {
+			break
+		}
+	}
+	return count, enc.close(count)
+}
+
+// exportArtifacts writes the content of every artifact the run lists that
+// the store still has; expired ones are skipped.
+func exportArtifacts(ctx context.Context, enc encoder, arts artifacts.Store, run types.RunRecord) error {
+	for _, a := range runArtifacts(run) {
+		variant := a.Origin.VariantID
+		if variant == "" {
+			variant = "run"
+		}
+		stored, data, err := arts.Get(ctx, run.ID, variant, a.Name)
+		if errors.Is(err, artifacts.ErrNotFound) || errors.Is(err, artifacts.ErrExpired) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := enc.artifact(run.ID, variant, stored.Name, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runArtifacts lists the run's artifacts once each, from the manifest and
+// the results.
+func runArtifacts(run types.RunRecord) []types.Artifact {
+	var all []types.Artifact
+	if run.Manifest != nil {
+		all = append(all, run.Manifest.Artifacts...)
+	}
+	for _, r := range run.Results {
+		all = append(all, r.Artifacts...)
+	}
+	seen := map[string]bool{}
+	out := all[:0]
+	for _, a := range all {
+		key := a.Origin.VariantID + "/" + a.Name
+		if !seen[key] {
+			seen[key] = true
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+type encoder interface {
+	header(Header) error
+	run(Entry) error
+	artifact(runID, variantID, name string, data []byte) error
+	close(runs int) error
+}
+
+type jsonlEncoder struct {
+	enc *json.Encoder
+}
+
+func (j *jsonlEncoder) header(h Header) error { return j.enc.Encode(line{Header: &h}) }
+func (j *jsonlEncoder) run(e Entry) error     { return j.enc.Encode(line{Run: &e}) }
+func (j *jsonlEncoder) close(runs int) error  { return j.enc.Encode(line{End: &end{Runs: runs}}) }
+
+func (j *jsonlEncoder) artifact(string, string, string, []byte) error { return nil }
+
+// tarEncoder lays the archive out as header.json, then runs/<id>.json for
+// each run followed by its artifacts/<id>/<variant>/<name>.
+type tarEncoder struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func newTarEncoder(w io.Writer) *tarEncoder {
+	gz := gzip.NewWriter(w)
+	return &tarEncoder{gz: gz, tw: tar.NewWriter(gz)}
+}
+
+func (t *tarEncoder) file(name string, data []byte) error {
+	if err := t.tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), ModTime: time.Now()}); err != nil {
+		return err
+	}
+	_, err := t.tw.Write(data)
+	return err
+}
+
+func (t *tarEncoder) header(h Header) error {
+	b, _ := json.Marshal(h)
+	return t.file("header.json", b)
+}
+
+func (t *tarEncoder) run(e Entry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return t.file("runs/"+e.Run.ID+".json", b)
+}
+
+func (t *tarEncoder) artifact(runID, variantID, name string, data []byte) error {
+	return t.file(strings.Join([]string{"artifacts", runID, variantID, name}, "/"), data)
+}
+
+func (t *tarEncoder) close(int) error {
+	if err := t.tw.Close(); err != nil {
+		return err
+	}
+	return t.gz.Close()
+}
+
+// ImportOptions configure Import.
+type ImportOptions struct {
+	// OnConflictSkip (the default) or OnConflictRemap
+	OnConflict string
+	// Check the archive and report what would happen without writing
+	DryRun bool
+}
+
+// Report says what Import did, or would do on a dry run, with each run.
+type Report struct {
+	SchemaVersion int         `json:"schema_version"`
+	DryRun        bool        `json:"dry_run"`
+	Imported      int         `json:"imported"`
+	Remapped      int         `json:"remapped"`
+	Skipped       int         `json:"skipped"`
+	Invalid       int         `json:"invalid"`
+	Artifacts     int         `json:"artifacts"`
+	Runs          []RunReport `json:"runs"`
+}
+
+// RunReport is the fate of one archived run.
+type RunReport struct {
+	ID string `json:"id"`
+	// Set when the run was remapped
+	NewID string `json:"new_id,omitempty"`
+	// "import", "remap", "skip" or "invalid"
+	Action string `json:"action"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Import reads an archive written by Export, gzip-compressed tar or JSONL,
+// from r into store and arts (which may be nil to drop artifacts). The
+// report covers every run read before any error.
+func Import(ctx context.Context, r io.Reader, store runstore.RunStore, arts artifacts.Store, opts ImportOptions) (Report, error) {
+	if opts.OnConflict == "" {
+		opts.OnConflict = OnConflictSkip
+	}
+	if opts.OnConflict != OnConflictSkip && opts.OnConflict != OnConflictRemap {
+		return Report{}, fmt.Errorf("archive: unknown conflict policy %q", opts.OnConflict)
+	}
+	im := &importer{store: store, arts: arts, opts: opts, report: Report{DryRun: opts.DryRun, Runs: []RunReport{}}, ids: map[string]string{}, taken: map[string]bool{}}
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(2)
+	var err error
+	if len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		err = im.readTar(ctx, br)
+	} else {
+		err = im.readJSONL(ctx, br)
+	}
+	return im.report, err
+}
+
+type importer struct {
+	store  runstore.RunStore
+	arts   artifacts.Store
+	opts   ImportOptions
+	report Report
+	// Archived run ID to the ID it was stored under; skipped and invalid
+	// runs map to ""
+	ids map[string]string
+	// IDs claimed during this import, for dry runs that never save them
+	taken map[string]bool
+	// Artifacts listed by the run being read, by variant/name
+	listed map[string]types.Artifact
+}
+
+func (im *importer) checkHeader(h Header) error {
+	im.report.SchemaVersion = h.SchemaVersion
+	if h.SchemaVersion < 1 {
+		return fmt.Errorf("%w: missing schema version", ErrFormat)
+	}
+	if h.SchemaVersion > SchemaVersion {
+		return fmt.Errorf("%w: archive is v%d, this build reads up to v%d", ErrVersion, h.SchemaVersion, SchemaVersion)
+	}
+	return nil
+}
+
+func (im *importer) readJSONL(ctx context.Context, r io.Reader) error {
+	dec := json.NewDecoder(r)
+	var l line
+	if err := dec.Decode(&l); err != nil || l.Header == nil {
+		return fmt.Errorf("%w: expected a header first", ErrFormat)
+	}
+	if err := im.checkHeader(*l.Header); err != nil {
+		return err
+	}
+	runs := 0
+	for {
+		var l line
+		if err := dec.Decode(&l); err == io.EOF {
+			return fmt.Errorf("%w: stream ends without its end record; it may be truncated", ErrFormat)
+		} else if err != nil {
+			return fmt.Errorf("%w: %v", ErrFormat, err)
+		}
+		switch {
+		case l.Run != nil:
+			runs++
+			if err := im.run(ctx, *l.Run); err != nil {
+				return err
+			}
+		case l.End != nil:
+			if l.End.Runs != runs {
+				return fmt.Errorf("%w: end record counts %d runs, read %d", ErrFormat, l.End.Runs, runs)
+			}
+			return nil
+		default:
+			return fmt.Errorf("%w: unexpected record", ErrFormat)
+		}
+	}
+}
+
+func (im *importer) readTar(ctx context.Context, r io.Reader) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+	tr := tar.NewReader(gz)
+	first := true
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			if first {
+				return fmt.Errorf("%w: empty archive", ErrFormat)
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrFormat, err)
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		parts := strings.Split(h.Name, "/")
+		switch {
+		case first:
+			if h.Name != "header.json" {
+				return fmt.Errorf("%w: expected header.json first, got %s", ErrFormat, h.Name)
+			}
+			var hdr Header
+			if err := json.NewDecoder(tr).Decode(&hdr); err != nil {
+				return fmt.Errorf("%w: header.json: %v", ErrFormat, err)
+			}
+			if err := im.checkHeader(hdr); err != nil {
+				return err
+			}
+			first = false
+		case len(parts) == 2 && parts[0] == "runs":
+			var e Entry
+			if err := json.NewDecoder(tr).Decode(&e); err != nil {
+				return fmt.Errorf("%w: %s: %v", ErrFormat, h.Name, err)
+			}
+			if err := im.run(ctx, e); err != nil {
+				return err
+			}
+		case len(parts) == 4 && parts[0] == "artifacts":
+			if h.Size > artifacts.MaxSize {
+				return fmt.Errorf("%w: %s is %d bytes, cap %d", ErrFormat, h.Name, h.Size, artifacts.MaxSize)
+			}
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return fmt.Errorf("%w: %s: %v", ErrFormat, h.Name, err)
+			}
+			if err := im.artifact(ctx, parts[1], parts[2], parts[3], data); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// run imports one entry, resolving an ID collision by the conflict policy.
+func (im *importer) run(ctx context.Context, e Entry) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	orig := e.Run.ID
+	im.listed = nil
+	if reason := validate(e.Run); reason != "" {
+		im.ids[orig] = ""
+		im.report.Invalid++
+		im.report.Runs = append(im.report.Runs, RunReport{ID: orig, Action: "invalid", Reason: reason})
+		return nil
+	}
+	exists, err := im.exists(ctx, orig)
+	if err != nil {
+		return err
+	}
+	rep := RunReport{ID: orig, Action: "import"}
+	id := orig
+	if exists {
+		if im.opts.OnConflict == OnConflictSkip {
+			im.ids[orig] = ""
+			im.report.Skipped++
+			im.report.Runs = append(im.report.Runs, RunReport{ID: orig, Action: "skip", Reason: "ID already in the store"})
+			return nil
+		}
+		if id, err = im.freshID(ctx); err != nil {
+			return err
+		}
+		remap(&e, id)
+		rep.Action, rep.NewID = "remap", id
+		im.report.Remapped++
+	} else {
+		im.report.Imported++
+	}
+	im.ids[orig] = id
+	im.taken[id] = true
+	im.report.Runs = append(im.report.Runs, rep)
+
+	im.listed = map[string]types.Artifact{}
+	for _, a := range runArtifacts(e.Run) {
+		im.listed[a.Origin.VariantID+"/"+a.Name] = a
+	}
+	if im.opts.DryRun {
+		return nil
+	}
+	e.Run.GoalEmbedding, e.Run.EmbeddingSpace = e.GoalEmbedding, e.EmbeddingSpace
+	if err := im.store.Save(ctx, e.Run); err != nil {
+		return fmt.Errorf("archive: save %s: %w", id, err)
+	}
+	for _, call := range e.LLMCalls {
+		call.RunID = id
+		if err := im.store.AppendLLMCall(ctx, call); err != nil {
+			return fmt.Errorf("archive: save llm calls of %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// artifact stores content for the run just imported, under the run's new
+// ID; content for skipped runs or artifacts the run never listed is dropped.
+func (im *importer) artifact(ctx context.Context, runID, variantID, name string, data []byte) error {
+	id := im.ids[runID]
+	if id == "" || im.arts == nil {
+		return nil
+	}
+	key := variantID + "/" + name
+	if variantID == "run" {
+		key = "/" + name
+	}
+	a, ok := im.listed[key]
+	if !ok {
+		return nil
+	}
+	im.report.Artifacts++
+	if im.opts.DryRun {
+		return nil
+	}
+	a.Origin.RunID = id
+	if _, err := im.arts.Put(ctx, a, data); err != nil {
+		return fmt.Errorf("archive: artifact %s of %s: %w", name, id, err)
+	}
+	return nil
+}
+
+func (im *importer) exists(ctx context.Context, id string) (bool, error) {
+	if im.taken[id] {
+		return true, nil
+	}
+	_, err := im.store.Get(ctx, id)
+	if errors.Is(err, runstore.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// freshID picks an unused ID of the form the engine gives runs.
+func (im *importer) freshID(ctx context.Context) (string, error) {
+	for {
+		id := runstore.NewRunID()
+		taken, err := im.exists(ctx, id)
+		if err != nil || !taken {
+			return id, err
+		}
+	}
+}
+
+// validate returns why a run cannot be imported, or "".
+func validate(run types.RunRecord) string {
+	switch {
+	case run.ID == "":
+		return "missing id"
+	case artifacts.SanitizeName(run.ID) != run.ID:
+		return "id is not a safe path element"
+	case run.StartedAt.IsZero():
+		return "missing started_at"
+	}
+	return ""
+}
+
+// remap moves the entry to id, recording the ID it had in its manifest and
+// pointing its artifacts at their new location.
+func remap(e *Entry, id string) {
+	orig := e.Run.ID
+	e.Run.ID = id
+	if e.Run.Manifest == nil {
+		e.Run.Manifest = &types.RunManifest{}
+	} else {
+		m := *e.Run.Manifest
+		e.Run.Manifest = &m
+	}
+	e.Run.Manifest.RunID = id
+	if e.Run.Manifest.OriginalID == "" {
+		e.Run.Manifest.OriginalID = orig
+	}
+	e.Run.Manifest.Artifacts = moveArtifacts(e.Run.Manifest.Artifacts, id)
+	results := make([]types.SimulationResult, len(e.Run.Results))
+	for i, r := range e.Run.Results {
+		r.Artifacts = moveArtifacts(r.Artifacts, id)
+		results[i] = r
+	}
+	if e.Run.Results != nil {
+		e.Run.Results = results
+	}
+}
+
+func moveArtifacts(list []types.Artifact, runID string) []types.Artifact {
+	if list == nil {
+		return nil
+	}
+	out := make([]types.Artifact, len(list))
+	for i, a := range list {
+		a.Origin.RunID = runID
+		if a.StorageRef != "" {
+			a.StorageRef = artifacts.Ref(a.Origin, a.Name)
+		}
+		out[i] = a
+	}
+	return out
+}
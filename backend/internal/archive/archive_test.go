@@ -0,0 +1,259 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"simstack/internal/artifacts"
+	"simstack/internal/runstore"
+	"simstack/internal/types"
+)
+
+var base = time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+
+// seed fills a store with three runs; run-2 has results, artifacts, an
+// embedding and LLM calls.
+func seed(t *testing.T) (*runstore.Memory, *artifacts.Memory) {
+	t.Helper()
+	ctx := context.Background()
+	store, arts := runstore.NewMemory(), artifacts.NewMemory(0)
+	for i := 1; i <= 3; i++ {
+		finished := base.Add(time.Duration(i)*time.Hour + time.Minute)
+		run := types.RunRecord{
+			ID: fmt.Sprintf("run-%d", i), Goal: fmt.Sprintf("goal %d", i), Status: "completed",
+			StartedAt: base.Add(time.Duration(i) * time.Hour), FinishedAt: &finished,
+			Manifest: &types.RunManifest{RunID: fmt.Sprintf("run-%d", i), Model: "m"},
+		}
+		if i == 2 {
+			run.Status = "failed"
+			var kept []types.Artifact
+			for _, o := range []types.ArtifactOrigin{{RunID: "run-2", VariantID: "p-v1", Tool: "queue"}, {RunID: "run-2"}} {
+				data := []byte(`{"variant":"` + o.VariantID + `"}`)
+				a, _ := artifacts.New(o, "response.json", "application/json", data)
+				a, err := arts.Put(ctx, a, data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				kept = append(kept, a)
+			}
+			run.Results = []types.SimulationResult{{VariantID: "p-v1", Tool: "queue", Metrics: map[string]float64{"wait": 2}, Artifacts: kept[:1], Status: types.ResultComplete}}
+			run.Manifest.Artifacts = kept
+			run.GoalEmbedding, run.EmbeddingSpace = []float64{0.5, -0.25}, "trigram"
+			_ = store.Save(ctx, run)
+			for _, phase := range []string{"plan", "analysis"} {
+				_ = store.AppendLLMCall(ctx, types.LLMAuditRecord{RunID: "run-2", Phase: phase, Time: base, TotalTokens: 10})
+			}
+			continue
+		}
+		_ = store.Save(ctx, run)
+	}
+	return store, arts
+}
+
+func export(t *testing.T, store runstore.RunStore, arts artifacts.Store, opts ExportOptions) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if _, err := Export(context.Background(), &buf, store, arts, opts); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// diff reports how the runs of two stores differ, artifacts included.
+func diff(t *testing.T, want, got runstore.RunStore, wantArts, gotArts artifacts.Store) {
+	t.Helper()
+	ctx := context.Background()
+	runs, _ := want.List(ctx, runstore.ListOptions{})
+	if n, _ := got.List(ctx, runstore.ListOptions{}); len(n) != len(runs) {
+		t.Fatalf("expected %d runs, got %d", len(runs), len(n))
+	}
+	for _, w := range runs {
+		g, err := got.Get(ctx, w.ID)
+		if err != nil {
+			t.Errorf("%s missing: %v", w.ID, err)
+			continue
+		}
+		wj, _ := json.Marshal(w)
+		gj, _ := json.Marshal(g)
+		if string(wj) != string(gj) || !reflect.DeepEqual(w.GoalEmbedding, g.GoalEmbedding) || w.EmbeddingSpace != g.EmbeddingSpace {
+			t.Errorf("%s changed:\nwant %s\n got %s", w.ID, wj, gj)
+		}
+		wc, _ := want.LLMCalls(ctx, w.ID)
+		gc, _ := got.LLMCalls(ctx, w.ID)
+		if !reflect.DeepEqual(wc, gc) {
+			t.Errorf("%s llm calls changed: %v vs %v", w.ID, wc, gc)
+		}
+		if wantArts == nil {
+			continue
+		}
+		for _, a := range runArtifacts(w) {
+			variant := a.Origin.VariantID
+			if variant == "" {
+				variant = "run"
+			}
+			_, wd, _ := wantArts.Get(ctx, w.ID, variant, a.Name)
+			ga, gd, err := gotArts.Get(ctx, w.ID, variant, a.Name)
+			if err != nil || !bytes.Equal(wd, gd) || ga.SHA256 != a.SHA256 {
+				t.Errorf("artifact %s/%s/%s changed: %q vs %q (%v)", w.ID, variant, a.Name, wd, gd, err)
+			}
+		}
+	}
+}
+
+func TestRoundTripTar(t *testing.T) {
+	store, arts := seed(t)
+	archive := export(t, store, arts, ExportOptions{Format: FormatTar, Artifacts: true})
+
+	dst, dstArts := runstore.NewMemory(), artifacts.NewMemory(0)
+	report, err := Import(context.Background(), bytes.NewReader(archive), dst, dstArts, ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Imported != 3 || report.Artifacts != 2 || report.SchemaVersion != SchemaVersion {
+		t.Errorf("unexpected report %+v", report)
+	}
+	diff(t, store, dst, arts, dstArts)
+}
+
+func TestRoundTripJSONL(t *testing.T) {
+	store, _ := seed(t)
+	archive := export(t, store, nil, ExportOptions{Format: FormatJSONL})
+	if lines := strings.Count(string(archive), "\n"); lines != 5 {
+		t.Errorf("expected header, three runs and end, got %d lines", lines)
+	}
+	dst := runstore.NewMemory()
+	if _, err := Import(context.Background(), bytes.NewReader(archive), dst, nil, ImportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	diff(t, store, dst, nil, nil)
+
+	if _, err := Export(context.Background(), &bytes.Buffer{}, store, nil, ExportOptions{Format: FormatJSONL, Artifacts: true}); err == nil {
+		t.Error("expected artifacts to need the tar format")
+	}
+}
+
+func TestExportFilter(t *testing.T) {
+	store, _ := seed(t)
+	ids := func(opts ExportOptions) []string {
+		opts.Format = FormatJSONL
+		dst := runstore.NewMemory()
+		_, _ = Import(context.Background(), bytes.NewReader(export(t, store, nil, opts)), dst, nil, ImportOptions{})
+		runs, _ := dst.List(context.Background(), runstore.ListOptions{})
+		var out []string
+		for _, r := range runs {
+			out = append(out, r.ID)
+		}
+		return out
+	}
+	if got := ids(ExportOptions{Filter: Filter{Status: "completed"}}); !reflect.DeepEqual(got, []string{"run-3", "run-1"}) {
+		t.Errorf("status filter: got %v", got)
+	}
+	if got := ids(ExportOptions{Filter: Filter{Since: base.Add(2 * time.Hour), Until: base.Add(3 * time.Hour)}}); !reflect.DeepEqual(got, []string{"run-2"}) {
+		t.Errorf("time filter: got %v", got)
+	}
+}
+
+func TestImportConflicts(t *testing.T) {
+	ctx := context.Background()
+	store, arts := seed(t)
+	archive := export(t, store, arts, ExportOptions{Artifacts: true})
+
+	report, err := Import(ctx, bytes.NewReader(archive), store, arts, ImportOptions{OnConflict: OnConflictSkip})
+	if err != nil || report.Skipped != 3 || report.Imported != 0 || report.Artifacts != 0 {
+		t.Errorf("expected every run skipped, got %+v %v", report, err)
+	}
+
+	report, err = Import(ctx, bytes.NewReader(archive), store, arts, ImportOptions{OnConflict: OnConflictRemap})
+	if err != nil || report.Remapped != 3 || report.Artifacts != 2 {
+		t.Fatalf("expected every run remapped, got %+v %v", report, err)
+	}
+	var moved RunReport
+	for _, r := range report.Runs {
+		if r.ID == "run-2" {
+			moved = r
+		}
+	}
+	// Remapped IDs take the engine's form, run-<nanos>-<hex>
+	if moved.Action != "remap" || !regexp.MustCompile(`^run-\d+-[0-9a-f]{8}$`).MatchString(moved.NewID) {
+		t.Fatalf("unexpected run report %+v", moved)
+	}
+	run, err := store.Get(ctx, moved.NewID)
+	if err != nil || run.Manifest.OriginalID != "run-2" || run.Manifest.RunID != moved.NewID || run.Goal != "goal 2" {
+		t.Fatalf("expected the remapped run to record its origin, got %+v %v", run.Manifest, err)
+	}
+	a := run.Results[0].Artifacts[0]
+	if a.Origin.RunID != moved.NewID || !strings.Contains(a.StorageRef, moved.NewID) {
+		t.Errorf("expected the artifact moved with the run, got %+v", a)
+	}
+	if _, data, err := arts.Get(ctx, moved.NewID, "p-v1", "response.json"); err != nil || !strings.Contains(string(data), "p-v1") {
+		t.Errorf("expected the artifact content under the new ID, got %q %v", data, err)
+	}
+	if calls, _ := store.LLMCalls(ctx, moved.NewID); len(calls) != 2 || calls[0].RunID != moved.NewID {
+		t.Errorf("expected the audit trail under the new ID, got %v", calls)
+	}
+	if orig, _ := store.Get(ctx, "run-2"); orig.Manifest.OriginalID != "" {
+		t.Error("the original run was modified")
+	}
+}
+
+func TestImportDryRun(t *testing.T) {
+	ctx := context.Background()
+	store, arts := seed(t)
+	archive := export(t, store, arts, ExportOptions{Artifacts: true})
+	dst, dstArts := runstore.NewMemory(), artifacts.NewMemory(0)
+	_ = dst.Save(ctx, types.RunRecord{ID: "run-1", Goal: "local", StartedAt: base})
+
+	report, err := Import(ctx, bytes.NewReader(archive), dst, dstArts, ImportOptions{OnConflict: OnConflictRemap, DryRun: true})
+	if err != nil || !report.DryRun || report.Imported != 2 || report.Remapped != 1 || report.Artifacts != 2 {
+		t.Errorf("unexpected dry-run report %+v %v", report, err)
+	}
+	if runs, _ := dst.List(ctx, runstore.ListOptions{}); len(runs) != 1 || runs[0].Goal != "local" {
+		t.Errorf("dry run wrote to the store: %v", runs)
+	}
+	if _, _, err := dstArts.Get(ctx, "run-2", "p-v1", "response.json"); !errors.Is(err, artifacts.ErrNotFound) {
+		t.Errorf("dry run wrote an artifact: %v", err)
+	}
+}
+
+func TestImportRejects(t *testing.T) {
+	ctx := context.Background()
+	store, _ := seed(t)
+	good := string(export(t, store, nil, ExportOptions{Format: FormatJSONL}))
+	lines := strings.SplitAfter(good, "\n")
+
+	for name, c := range map[string]struct {
+		input string
+		want  error
+	}{
+		"newer version":  {strings.Replace(good, `"schema_version":1`, `"schema_version":2`, 1), ErrVersion},
+		"no header":      {strings.Join(lines[1:], ""), ErrFormat},
+		"truncated":      {strings.Join(lines[:3], ""), ErrFormat},
+		"miscounted":     {strings.Join(append(lines[:2:2], lines[4:]...), ""), ErrFormat},
+		"not an archive": {"hello", ErrFormat},
+		"corrupt gzip":   {"\x1f\x8bnot really", ErrFormat},
+	} {
+		if _, err := Import(ctx, strings.NewReader(c.input), runstore.NewMemory(), nil, ImportOptions{}); !errors.Is(err, c.want) {
+			t.Errorf("%s: expected %v, got %v", name, c.want, err)
+		}
+	}
+
+	// Runs that cannot be stored are reported, not imported
+	bad := lines[0] + `{"run":{"run":{"id":"../etc","goal":"g","status":"completed","started_at":"2026-03-01T09:00:00Z"}}}` + "\n" +
+		`{"run":{"run":{"id":"run-9","goal":"g","status":"completed","started_at":"0001-01-01T00:00:00Z"}}}` + "\n" + `{"end":{"runs":2}}` + "\n"
+	dst := runstore.NewMemory()
+	report, err := Import(ctx, strings.NewReader(bad), dst, nil, ImportOptions{})
+	if err != nil || report.Invalid != 2 || report.Runs[0].Action != "invalid" || report.Runs[1].Reason != "missing started_at" {
+		t.Errorf("expected both runs invalid, got %+v %v", report, err)
+	}
+	if _, err := Import(ctx, strings.NewReader(good), dst, nil, ImportOptions{OnConflict: "overwrite"}); err == nil {
+		t.Error("expected an unknown conflict policy refused")
+	}
+}
@@ -0,0 +1,168 @@
+// Package artifacts builds and keeps the files a run produces alongside its
+// metrics: raw simulator responses, logs, reports.
+package artifacts
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"simstack/internal/types"
+)
+
+// MaxSize caps a single artifact's content.
+const MaxSize = 8 << 20
+
+// maxNameLen caps a sanitized name, leaving room for the extension.
+const maxNameLen = 128
+
+var (
+	ErrNotFound = errors.New("artifacts: artifact not found")
+	ErrTooLarge = errors.New("artifacts: content exceeds size cap")
+	ErrBadName  = errors.New("artifacts: empty artifact name")
+)
+
+// New describes data as an artifact of origin. The name is sanitized, data
+// over MaxSize is refused, and the hash, size and creation time are filled
+// in. StorageRef stays empty until a Store keeps it.
+func New(origin types.ArtifactOrigin, name, contentType string, data []byte) (types.Artifact, error) {
+	clean := SanitizeName(name)
+	if clean == "" {
+		return types.Artifact{}, ErrBadName
+	}
+	if len(data) > MaxSize {
+		return types.Artifact{}, fmt.Errorf("%w: %s is %d bytes, cap %d", ErrTooLarge, clean, len(data), MaxSize)
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	sum := sha256.Sum256(data)
+	return types.Artifact{
+		Name:        clean,
+		ContentType: contentType,
+		SizeBytes:   int64(len(data)),
+		SHA256:      hex.EncodeToString(sum[:]),
+		Origin:      origin,
+		CreatedAt:   time.Now().UTC(),
+	}, nil
+}
+
+// SanitizeName reduces name to a safe file name: its last path element, with
+// anything but letters, digits, '.', '-' and '_' replaced by '_', no leading
+// dots and at most maxNameLen bytes. It returns "" if nothing usable is left.
+func SanitizeName(name string) string {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	clean := strings.TrimLeft(b.String(), ".")
+	if len(clean) > maxNameLen {
+		ext := path.Ext(clean)
+		if len(ext) > 16 {
+			ext = ""
+		}
+		clean = clean[:maxNameLen-len(ext)] + ext
+	}
+	if strings.Trim(clean, "_") == "" {
+		return ""
+	}
+	return clean
+}
+
+// Ref is the download path of an artifact, which is also its StorageRef.
+func Ref(origin types.ArtifactOrigin, name string) string {
+	variant := origin.VariantID
+	if variant == "" {
+		variant = "run"
+	}
+	return "/api/runs/" + url.PathEscape(origin.RunID) + "/artifacts/" + url.PathEscape(variant) + "/" + url.PathEscape(name)
+}
+
+// Store keeps artifact content. Put sets the artifact's StorageRef; Get finds
+// it again by run, variant ("run" for run-wide artifacts) and name.
+type Store interface {
+	Put(ctx context.Context, a types.Artifact, data []byte) (types.Artifact, error)
+	Get(ctx context.Context, runID, variantID, name string) (types.Artifact, []byte, error)
+}
+
+// Memory is an in-process Store holding at most limit bytes; the oldest
+// artifacts are dropped to make room.
+type Memory struct {
+	mu    sync.Mutex
+	limit int64
+	size  int64
+	items map[string]entry
+	order []string
+}
+
+type entry struct {
+	artifact types.Artifact
+	data     []byte
+}
+
+// NewMemory returns a Memory store capped at limit bytes (MaxSize*8 if limit
+// is not positive).
+func NewMemory(limit int64) *Memory {
+	if limit <= 0 {
+		limit = MaxSize * 8
+	}
+	return &Memory{limit: limit, items: map[string]entry{}}
+}
+
+func (m *Memory) Put(ctx context.Context, a types.Artifact, data []byte) (types.Artifact, error) {
+	if int64(len(data)) > m.limit {
+		return a, fmt.Errorf("%w: %s is %d bytes, store holds %d", ErrTooLarge, a.Name, len(data), m.limit)
+	}
+	a.StorageRef = Ref(a.Origin, a.Name)
+	key := a.StorageRef
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if old, ok := m.items[key]; ok {
+		m.size -= int64(len(old.data))
+		m.drop(key)
+	}
+	for m.size+int64(len(data)) > m.limit && len(m.order) > 0 {
+		oldest := m.order[0]
+		m.size -= int64(len(m.items[oldest].data))
+		m.drop(oldest)
+	}
+	m.items[key] = entry{artifact: a, data: data}
+	m.order = append(m.order, key)
+	m.size += int64(len(data))
+	return a, nil
+}
+
+func (m *Memory) Get(ctx context.Context, runID, variantID, name string) (types.Artifact, []byte, error) {
+	key := Ref(types.ArtifactOrigin{RunID: runID, VariantID: variantID}, name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.items[key]
+	if !ok {
+		return types.Artifact{}, nil, ErrNotFound
+	}
+	return e.artifact, e.data, nil
+}
+
+func (m *Memory) drop(key string) {
+	delete(m.items, key)
+	for i, k := range m.order {
+		if k == key {
+			m.order = append(m.order[:i], m.order[i+1:]...)
+			break
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package artifacts
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"simstack/internal/types"
+)
+
+func TestNewHashesContent(t *testing.T) {
+	a, err := New(types.ArtifactOrigin{RunID: "run-1", VariantID: "v1", Tool: "queue"}, "queue-response.json", "application/json", []byte("abc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// SHA-256 of "abc"
+	if a.SHA256 != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+		t.Errorf("unexpected hash %s", a.SHA256)
+	}
+	if a.SizeBytes != 3 || a.ContentType != "application/json" || a.CreatedAt.IsZero() || a.StorageRef != "" {
+		t.Errorf("unexpected artifact %+v", a)
+	}
+
+	if a, _ := New(types.ArtifactOrigin{}, "blob", "", nil); a.ContentType != "application/octet-stream" {
+		t.Errorf("expected octet-stream default, got %q", a.ContentType)
+	}
+	if _, err := New(types.ArtifactOrigin{}, "big.bin", "", make([]byte, MaxSize+1)); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("expected ErrTooLarge, got %v", err)
+	}
+	if _, err := New(types.ArtifactOrigin{}, "../..", "", nil); !errors.Is(err, ErrBadName) {
+		t.Errorf("expected ErrBadName, got %v", err)
+	}
+}
+
+func TestSanitizeName(t *testing.T) {
+	cases := map[string]string{
+		"queue-response.json":             "queue-response.json",
+		"../../etc/passwd":                "passwd",
+		`..\..\boot.ini`:                  "boot.ini",
+		".hidden":                         "hidden",
+		"wait times (p95).csv":            "wait_times__p95_.csv",
+		"série.txt":                       "s_rie.txt",
+		"/":                               "",
+		"..":                              "",
+		"???":                             "",
+		strings.Repeat("a", 300) + ".log": strings.Repeat("a", maxNameLen-4) + ".log",
+	}
+	for in, want := range cases {
+		if got := SanitizeName(in); got != want {
+			t.Errorf("SanitizeName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(10)
+	origin := types.ArtifactOrigin{RunID: "run 1", VariantID: "v1"}
+	put := func(name, data string) types.Artifact {
+		a, err := New(origin, name, "text/plain", []byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a, err = m.Put(ctx, a, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		return a
+	}
+
+	a := put("a.txt", "123456")
+	if a.StorageRef != "/api/runs/run%201/artifacts/v1/a.txt" {
+		t.Errorf("unexpected storage ref %q", a.StorageRef)
+	}
+	got, data, err := m.Get(ctx, "run 1", "v1", "a.txt")
+	if err != nil || string(data) != "123456" || got.SHA256 != a.SHA256 {
+		t.Errorf("unexpected get: %+v %q %v", got, data, err)
+	}
+
+	// Over the 10 byte limit: the oldest artifact makes room
+	put("b.txt", "12345")
+	if _, _, err := m.Get(ctx, "run 1", "v1", "a.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected a.txt evicted, got %v", err)
+	}
+	if _, _, err := m.Get(ctx, "run 1", "v1", "b.txt"); err != nil {
+		t.Errorf("expected b.txt kept, got %v", err)
+	}
+	if _, err := m.Put(ctx, types.Artifact{Name: "c"}, make([]byte, 11)); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("expected ErrTooLarge, got %v", err)
+	}
+}
@@ -0,0 +1,441 @@
+package artifacts
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"simstack/internal/types"
+)
+
+// ErrExpired is returned for artifacts the garbage collector deleted; the
+// content is gone for good.
+var ErrExpired = errors.New("artifacts: artifact expired")
+
+// expiredLog records deleted artifacts under the root, so download links keep
+// answering "expired" after a restart. Run directories cannot start with a
+// dot, so it never collides with one.
+const expiredLog = ".expired.jsonl"
+
+// tempPrefix starts in-progress writes. Sanitized names never start with a
+// dot and never contain '~', so neither artifacts nor sidecars match it.
+const tempPrefix = ".~"
+
+// DiskOptions bound a Disk store. Zero values pick the defaults.
+type DiskOptions struct {
+	// Largest single artifact; MaxSize by default
+	MaxArtifactBytes int64
+	// Total content kept before the oldest artifacts go; 1 GiB by default
+	BudgetBytes int64
+	// Runs whose last artifact is older than this are deleted; 0 keeps them
+	// until the budget needs the room
+	Retention time.Duration
+}
+
+// Expiry says when and why an artifact was deleted.
+type Expiry struct {
+	Ref       string    `json:"ref"`
+	ExpiredAt time.Time `json:"expired_at"`
+	// "retention" or "budget"
+	Reason string `json:"reason"`
+}
+
+// Disk is a Store under a directory, laid out as run/variant/name with each
+// artifact's metadata in a hidden sidecar next to it. Writes go to a temp file
+// renamed into place, so readers never see a partial artifact. Collect
+// enforces the retention period and the disk budget, oldest first, skipping
+// runs reported active.
+type Disk struct {
+	root string
+	opts DiskOptions
+
+	// Held shared while Put writes files, exclusively while Collect prunes
+	// the directories it emptied
+	dirs sync.RWMutex
+
+	mu      sync.Mutex
+	items   map[string]diskEntry
+	size    int64
+	expired map[string]Expiry
+	active  func(runID string) bool
+}
+
+type diskEntry struct {
+	artifact types.Artifact
+	path     string
+}
+
+// NewDisk opens (creating if needed) the store at dir and indexes the
+// artifacts already there. Leftover temp files from interrupted writes are
+// removed.
+func NewDisk(dir string, opts DiskOptions) (*Disk, error) {
+	if opts.MaxArtifactBytes <= 0 || opts.MaxArtifactBytes > MaxSize {
+		opts.MaxArtifactBytes = MaxSize
+	}
+	if opts.BudgetBytes <= 0 {
+		opts.BudgetBytes = 1 << 30
+	}
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("artifacts: %w", err)
+	}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fmt.Errorf("artifacts: %w", err)
+	}
+	d := &Disk{root: root, opts: opts, items: map[string]diskEntry{}, expired: map[string]Expiry{}}
+	if err := d.index(); err != nil {
+		return nil, fmt.Errorf("artifacts: index %s: %w", root, err)
+	}
+	if err := d.loadExpired(); err != nil {
+		return nil, fmt.Errorf("artifacts: read %s: %w", expiredLog, err)
+	}
+	return d, nil
+}
+
+// SetActive tells the collector which runs are still in flight; their
+// artifacts are never deleted.
+func (d *Disk) SetActive(active func(runID string) bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.active = active
+}
+
+// locate maps run, variant and name to a path under the root. Each must
+// already be a clean single path element, so nothing can point outside.
+func (d *Disk) locate(runID, variantID, name string) (string, error) {
+	if variantID == "" {
+		variantID = "run"
+	}
+	for _, seg := range []string{runID, variantID, name} {
+		if seg == "" || SanitizeName(seg) != seg {
+			return "", fmt.Errorf("%w: unsafe path element %q", ErrBadName, seg)
+		}
+	}
+	p := filepath.Join(d.root, runID, variantID, name)
+	if rel, err := filepath.Rel(d.root, p); err != nil || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("%w: %q escapes the store", ErrBadName, p)
+	}
+	return p, nil
+}
+
+func (d *Disk) Put(ctx context.Context, a types.Artifact, data []byte) (types.Artifact, error) {
+	size := int64(len(data))
+	if limit := min(d.opts.MaxArtifactBytes, d.opts.BudgetBytes); size > limit {
+		return a, fmt.Errorf("%w: %s is %d bytes, cap %d", ErrTooLarge, a.Name, size, limit)
+	}
+	p, err := d.locate(a.Origin.RunID, a.Origin.VariantID, a.Name)
+	if err != nil {
+		return a, err
+	}
+	a.StorageRef = Ref(a.Origin, a.Name)
+	a.SizeBytes = size
+
+	meta, err := json.Marshal(a)
+	if err != nil {
+		return a, err
+	}
+	d.dirs.RLock()
+	err = os.MkdirAll(filepath.Dir(p), 0o755)
+	if err == nil {
+		err = writeAtomic(p, data)
+	}
+	if err == nil {
+		if err = writeAtomic(sidecar(p), meta); err != nil {
+			os.Remove(p)
+		}
+	}
+	d.dirs.RUnlock()
+	if err != nil {
+		return a, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if old, ok := d.items[a.StorageRef]; ok {
+		d.size -= old.artifact.SizeBytes
+	}
+	d.items[a.StorageRef] = diskEntry{artifact: a, path: p}
+	d.size += size
+	delete(d.expired, a.StorageRef)
+	d.enforceBudget(time.Now().UTC())
+	return a, nil
+}
+
+func (d *Disk) Get(ctx context.Context, runID, variantID, name string) (types.Artifact, []byte, error) {
+	if _, err := d.locate(runID, variantID, name); err != nil {
+		return types.Artifact{}, nil, ErrNotFound
+	}
+	ref := Ref(types.ArtifactOrigin{RunID: runID, VariantID: variantID}, name)
+	d.mu.Lock()
+	e, ok := d.items[ref]
+	exp, gone := d.expired[ref]
+	d.mu.Unlock()
+	if gone {
+		return types.Artifact{}, nil, fmt.Errorf("%w: %s deleted %s (%s)", ErrExpired, name, exp.ExpiredAt.Format(time.RFC3339), exp.Reason)
+	}
+	if !ok {
+		return types.Artifact{}, nil, ErrNotFound
+	}
+	data, err := os.ReadFile(e.path)
+	if errors.Is(err, os.ErrNotExist) {
+		// Collected between the lookup and the read
+		return types.Artifact{}, nil, ErrExpired
+	}
+	if err != nil {
+		return types.Artifact{}, nil, err
+	}
+	return e.artifact, data, nil
+}
+
+// Expired reports whether the artifact behind ref (its StorageRef) was
+// deleted by the collector.
+func (d *Disk) Expired(ref string) (Expiry, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	exp, ok := d.expired[ref]
+	return exp, ok
+}
+
+// Size is the content currently kept, in bytes.
+func (d *Disk) Size() int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.size
+}
+
+// Collect deletes the artifacts of inactive runs past the retention period,
+// then the oldest remaining ones until the store fits its budget. It returns
+// what was deleted.
+func (d *Disk) Collect(now time.Time) []Expiry {
+	d.dirs.Lock()
+	defer d.dirs.Unlock()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	defer d.prune()
+	var out []Expiry
+	if d.opts.Retention > 0 {
+		last := map[string]time.Time{}
+		for _, e := range d.items {
+			run := e.artifact.Origin.RunID
+			if e.artifact.CreatedAt.After(last[run]) {
+				last[run] = e.artifact.CreatedAt
+			}
+		}
+		for ref, e := range d.items {
+			run := e.artifact.Origin.RunID
+			if now.Sub(last[run]) > d.opts.Retention && !d.isActive(run) {
+				out = append(out, d.remove(ref, now, "retention"))
+			}
+		}
+		sort.Slice(out, func(i, j int) bool { return out[i].Ref < out[j].Ref })
+	}
+	return append(out, d.enforceBudget(now)...)
+}
+
+// Run collects now and then every interval until ctx is done.
+func (d *Disk) Run(ctx context.Context, interval time.Duration) {
+	collect := func(now time.Time) {
+		if gone := d.Collect(now.UTC()); len(gone) > 0 {
+			log.Printf("artifacts: collected %d artifacts, %d bytes kept", len(gone), d.Size())
+		}
+	}
+	collect(time.Now())
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			collect(now)
+		}
+	}
+}
+
+// enforceBudget deletes the oldest artifacts of inactive runs until the store
+// fits. d.mu must be held.
+func (d *Disk) enforceBudget(now time.Time) []Expiry {
+	if d.size <= d.opts.BudgetBytes {
+		return nil
+	}
+	refs := make([]string, 0, len(d.items))
+	for ref := range d.items {
+		refs = append(refs, ref)
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		a, b := d.items[refs[i]].artifact, d.items[refs[j]].artifact
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return refs[i] < refs[j]
+	})
+	var out []Expiry
+	for _, ref := range refs {
+		if d.size <= d.opts.BudgetBytes {
+			break
+		}
+		if d.isActive(d.items[ref].artifact.Origin.RunID) {
+			continue
+		}
+		out = append(out, d.remove(ref, now, "budget"))
+	}
+	return out
+}
+
+func (d *Disk) isActive(runID string) bool {
+	return d.active != nil && d.active(runID)
+}
+
+// remove deletes an artifact and records its expiry. d.mu must be held.
+func (d *Disk) remove(ref string, now time.Time, reason string) Expiry {
+	e := d.items[ref]
+	if err := os.Remove(e.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("artifacts: remove %s: %v", e.path, err)
+	}
+	os.Remove(sidecar(e.path))
+
+	delete(d.items, ref)
+	d.size -= e.artifact.SizeBytes
+	exp := Expiry{Ref: ref, ExpiredAt: now, Reason: reason}
+	d.expired[ref] = exp
+	if err := d.appendExpired(exp); err != nil {
+		log.Printf("artifacts: record expiry of %s: %v", ref, err)
+	}
+	return exp
+}
+
+// prune removes run and variant directories left empty. d.dirs must be held
+// exclusively, so no Put is about to write into one.
+func (d *Disk) prune() {
+	runs, _ := os.ReadDir(d.root)
+	for _, run := range runs {
+		if !run.IsDir() {
+			continue
+		}
+		variants, _ := os.ReadDir(filepath.Join(d.root, run.Name()))
+		for _, v := range variants {
+			// Fails, harmlessly, unless the directory is empty
+			os.Remove(filepath.Join(d.root, run.Name(), v.Name()))
+		}
+		os.Remove(filepath.Join(d.root, run.Name()))
+	}
+}
+
+func (d *Disk) appendExpired(exp Expiry) error {
+	f, err := os.OpenFile(filepath.Join(d.root, expiredLog), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	line, _ := json.Marshal(exp)
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// loadExpired reads the expiry log, dropping records for artifacts that have
+// since been written again.
+func (d *Disk) loadExpired() error {
+	f, err := os.Open(filepath.Join(d.root, expiredLog))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var exp Expiry
+		if json.Unmarshal(sc.Bytes(), &exp) != nil || exp.Ref == "" {
+			continue
+		}
+		if _, ok := d.items[exp.Ref]; !ok {
+			d.expired[exp.Ref] = exp
+		}
+	}
+	return sc.Err()
+}
+
+// index walks run/variant/name under the root, rebuilding the in-memory
+// view from the sidecars.
+func (d *Disk) index() error {
+	return filepath.WalkDir(d.root, func(p string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(d.root, p)
+		depth := len(strings.Split(rel, string(filepath.Separator)))
+		base := entry.Name()
+		switch {
+		case rel == ".":
+			return nil
+		case entry.IsDir():
+			if depth > 2 {
+				return filepath.SkipDir
+			}
+			return nil
+		case strings.HasPrefix(base, tempPrefix):
+			return os.Remove(p)
+		case depth != 3 || strings.HasPrefix(base, "."):
+			return nil
+		}
+		meta, err := os.ReadFile(sidecar(p))
+		if err != nil {
+			// Written without its sidecar; the write never completed
+			log.Printf("artifacts: dropping %s without metadata", p)
+			return os.Remove(p)
+		}
+		var a types.Artifact
+		if err := json.Unmarshal(meta, &a); err != nil || a.StorageRef == "" {
+			log.Printf("artifacts: dropping %s with unreadable metadata", p)
+			os.Remove(sidecar(p))
+			return os.Remove(p)
+		}
+		d.items[a.StorageRef] = diskEntry{artifact: a, path: p}
+		d.size += a.SizeBytes
+		return nil
+	})
+}
+
+func sidecar(p string) string {
+	return filepath.Join(filepath.Dir(p), "."+filepath.Base(p)+".json")
+}
+
+// writeAtomic writes data to a temp file beside p, syncs it and renames it
+// over p.
+func writeAtomic(p string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(p), tempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), p); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package artifacts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"simstack/internal/types"
+)
+
+func openDisk(t *testing.T, dir string, opts DiskOptions) *Disk {
+	t.Helper()
+	d, err := NewDisk(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// putAt stores data as run/variant/name, created at the given time.
+func putAt(t *testing.T, d *Disk, run, variant, name, data string, at time.Time) types.Artifact {
+	t.Helper()
+	a, err := New(types.ArtifactOrigin{RunID: run, VariantID: variant}, name, "text/plain", []byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.CreatedAt = at
+	a, err = d.Put(context.Background(), a, []byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestDiskRoundTripAndReopen(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	d := openDisk(t, dir, DiskOptions{})
+	a := putAt(t, d, "run-1", "", "report.txt", "hello", time.Now().UTC())
+	if a.StorageRef != "/api/runs/run-1/artifacts/run/report.txt" {
+		t.Errorf("unexpected ref %q", a.StorageRef)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "run-1", "run", "report.txt")); err != nil {
+		t.Errorf("expected the run/variant/name layout: %v", err)
+	}
+
+	// A crashed write leaves a temp file behind; reopening clears it
+	stray := filepath.Join(dir, "run-1", "run", tempPrefix+"123")
+	_ = os.WriteFile(stray, []byte("partial"), 0o644)
+
+	d = openDisk(t, dir, DiskOptions{})
+	got, data, err := d.Get(ctx, "run-1", "run", "report.txt")
+	if err != nil || string(data) != "hello" || got.SHA256 != a.SHA256 || got.ContentType != "text/plain" {
+		t.Errorf("expected the artifact after reopening, got %+v %q %v", got, data, err)
+	}
+	if _, err := os.Stat(stray); !os.IsNotExist(err) {
+		t.Errorf("expected the temp file removed, got %v", err)
+	}
+	if d.Size() != 5 {
+		t.Errorf("expected 5 bytes indexed, got %d", d.Size())
+	}
+	if _, _, err := d.Get(ctx, "run-1", "run", "other.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestDiskRefusesTraversal(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	dir := filepath.Join(root, "store")
+	_ = os.WriteFile(filepath.Join(root, "secret"), []byte("s"), 0o644)
+	d := openDisk(t, dir, DiskOptions{})
+
+	for _, o := range []types.ArtifactOrigin{
+		{RunID: "..", VariantID: "v1"},
+		{RunID: "../..", VariantID: "v1"},
+		{RunID: "run-1", VariantID: "../../.."},
+		{RunID: "run/../..", VariantID: "v1"},
+		{RunID: `..\..`, VariantID: "v1"},
+		{RunID: "", VariantID: "v1"},
+		{RunID: ".hidden", VariantID: "v1"},
+	} {
+		a := types.Artifact{Name: "x.txt", Origin: o}
+		if _, err := d.Put(ctx, a, []byte("x")); !errors.Is(err, ErrBadName) {
+			t.Errorf("Put with origin %+v: expected ErrBadName, got %v", o, err)
+		}
+	}
+	// Names that skipped New are checked too
+	if _, err := d.Put(ctx, types.Artifact{Name: "../secret", Origin: types.ArtifactOrigin{RunID: "run-1"}}, []byte("x")); !errors.Is(err, ErrBadName) {
+		t.Errorf("expected ErrBadName for a raw name, got %v", err)
+	}
+	for _, p := range [][3]string{{"..", "..", "secret"}, {"run-1", "..", "secret"}, {"..", "store", "secret"}, {"run-1", "run", "../../../secret"}} {
+		if _, _, err := d.Get(ctx, p[0], p[1], p[2]); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get %v: expected ErrNotFound, got %v", p, err)
+		}
+	}
+	if b, _ := os.ReadFile(filepath.Join(root, "secret")); string(b) != "s" {
+		t.Error("file outside the store changed")
+	}
+	entries, _ := os.ReadDir(root)
+	if len(entries) != 2 {
+		t.Errorf("expected nothing written outside the store, got %d entries", len(entries))
+	}
+}
+
+func TestDiskConcurrentWrites(t *testing.T) {
+	ctx := context.Background()
+	d := openDisk(t, t.TempDir(), DiskOptions{})
+	var wg sync.WaitGroup
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// Half the writers share a name, racing on one file
+			name := fmt.Sprintf("out-%d.txt", i)
+			if i%2 == 0 {
+				name = "shared.txt"
+			}
+			data := strings.Repeat("x", 100)
+			a, _ := New(types.ArtifactOrigin{RunID: "run-1", VariantID: fmt.Sprintf("v%d", i%4)}, name, "", []byte(data))
+			if _, err := d.Put(ctx, a, []byte(data)); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	// 20 distinct names across v1 and v3, plus shared.txt in v0 and v2
+	if d.Size() != 22*100 {
+		t.Errorf("expected 2200 bytes, got %d", d.Size())
+	}
+	for _, v := range []string{"v0", "v2"} {
+		if _, data, err := d.Get(ctx, "run-1", v, "shared.txt"); err != nil || len(data) != 100 {
+			t.Errorf("expected an intact shared artifact in %s, got %d bytes, %v", v, len(data), err)
+		}
+	}
+}
+
+func TestDiskBudgetEvictsOldestFirst(t *testing.T) {
+	ctx := context.Background()
+	d := openDisk(t, t.TempDir(), DiskOptions{BudgetBytes: 30})
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	putAt(t, d, "run-2", "v1", "b.txt", strings.Repeat("b", 10), base.Add(2*time.Minute))
+	putAt(t, d, "run-1", "v1", "a.txt", strings.Repeat("a", 10), base.Add(time.Minute))
+	putAt(t, d, "run-3", "v1", "c.txt", strings.Repeat("c", 10), base.Add(3*time.Minute))
+	if d.Size() != 30 {
+		t.Fatalf("expected the store full at 30 bytes, got %d", d.Size())
+	}
+	// run-1 is still going, so its older artifact survives
+	d.SetActive(func(run string) bool { return run == "run-1" })
+
+	putAt(t, d, "run-4", "v1", "d.txt", strings.Repeat("d", 15), base.Add(4*time.Minute))
+	for _, c := range []struct {
+		run, name string
+		kept      bool
+	}{{"run-1", "a.txt", true}, {"run-2", "b.txt", false}, {"run-3", "c.txt", false}, {"run-4", "d.txt", true}} {
+		_, _, err := d.Get(ctx, c.run, "v1", c.name)
+		if c.kept && err != nil {
+			t.Errorf("expected %s kept, got %v", c.name, err)
+		}
+		if !c.kept && !errors.Is(err, ErrExpired) {
+			t.Errorf("expected %s expired, got %v", c.name, err)
+		}
+	}
+	if exp, ok := d.Expired("/api/runs/run-2/artifacts/v1/b.txt"); !ok || exp.Reason != "budget" {
+		t.Errorf("expected a budget expiry record, got %+v %v", exp, ok)
+	}
+	if d.Size() != 25 {
+		t.Errorf("expected 25 bytes kept, got %d", d.Size())
+	}
+
+	if _, err := d.Put(ctx, types.Artifact{Name: "big.bin", Origin: types.ArtifactOrigin{RunID: "run-5"}}, make([]byte, 31)); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("expected ErrTooLarge over the budget, got %v", err)
+	}
+}
+
+func TestDiskRetention(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	d := openDisk(t, dir, DiskOptions{Retention: time.Hour})
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	putAt(t, d, "run-old", "v1", "a.txt", "a", now.Add(-3*time.Hour))
+	putAt(t, d, "run-old", "v2", "b.txt", "b", now.Add(-2*time.Hour))
+	// The run's newest artifact is recent, so the old one stays with it
+	putAt(t, d, "run-mixed", "v1", "a.txt", "a", now.Add(-3*time.Hour))
+	putAt(t, d, "run-mixed", "v1", "b.txt", "b", now.Add(-time.Minute))
+	putAt(t, d, "run-active", "v1", "a.txt", "a", now.Add(-5*time.Hour))
+	d.SetActive(func(run string) bool { return run == "run-active" })
+
+	gone := d.Collect(now)
+	var refs []string
+	for _, e := range gone {
+		refs = append(refs, e.Ref)
+		if e.Reason != "retention" || !e.ExpiredAt.Equal(now) {
+			t.Errorf("unexpected expiry %+v", e)
+		}
+	}
+	if want := []string{"/api/runs/run-old/artifacts/v1/a.txt", "/api/runs/run-old/artifacts/v2/b.txt"}; !reflect.DeepEqual(refs, want) {
+		t.Errorf("expected %v collected, got %v", want, refs)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "run-old")); !os.IsNotExist(err) {
+		t.Errorf("expected the emptied run directory removed, got %v", err)
+	}
+	if _, _, err := d.Get(ctx, "run-active", "v1", "a.txt"); err != nil {
+		t.Errorf("expected the active run kept, got %v", err)
+	}
+
+	// Expiry survives a restart, and writing the artifact again clears it
+	d = openDisk(t, dir, DiskOptions{Retention: time.Hour})
+	d.SetActive(func(run string) bool { return run == "run-active" })
+	if _, _, err := d.Get(ctx, "run-old", "v1", "a.txt"); !errors.Is(err, ErrExpired) {
+		t.Errorf("expected ErrExpired after reopening, got %v", err)
+	}
+	putAt(t, d, "run-old", "v1", "a.txt", "again", now)
+	if _, data, err := d.Get(ctx, "run-old", "v1", "a.txt"); err != nil || string(data) != "again" {
+		t.Errorf("expected the rewritten artifact, got %q %v", data, err)
+	}
+	if len(d.Collect(now)) != 0 {
+		t.Error("expected nothing more to collect")
+	}
+}
@@ -0,0 +1,268 @@
+// Package cassette records LLM HTTP interactions to JSON files and replays
+// them, so tests and demos get authentic provider payloads without a network
+// connection or API key.
+package cassette
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+type Mode string
+
+const (
+	ModeRecord Mode = "record"
+	ModeReplay Mode = "replay"
+)
+
+// ErrNoInteraction is returned in replay mode for a request the cassette has
+// no recording of.
+var ErrNoInteraction = errors.New("cassette: no recorded interaction for request")
+
+// Normalizer rewrites a request body before it is hashed, so volatile values
+// (timestamps, generated IDs) don't break matching. Stored bodies are not
+// normalized.
+type Normalizer func(body []byte) []byte
+
+var volatile = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	{regexp.MustCompile(`\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:\d{2})`), "<timestamp>"},
+	{regexp.MustCompile(`\b(plan|run)-\d{9,}\b`), "$1-<id>"},
+}
+
+// DefaultNormalizer blanks RFC 3339 timestamps and plan/run IDs.
+func DefaultNormalizer(body []byte) []byte {
+	for _, v := range volatile {
+		body = v.re.ReplaceAll(body, []byte(v.repl))
+	}
+	return body
+}
+
+// Interaction is one recorded request/response pair. Authorization headers
+// are never stored.
+type Interaction struct {
+	Key      string   `json:"key"`
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+type Request struct {
+	Method string          `json:"method"`
+	Path   string          `json:"path"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+type Response struct {
+	Status      int             `json:"status"`
+	ContentType string          `json:"content_type,omitempty"`
+	Body        json.RawMessage `json:"body"`
+}
+
+type file struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Recorder is an http.RoundTripper that records to or replays from one
+// cassette file. Identical requests replay in recorded order, repeating the
+// last recording once exhausted.
+type Recorder struct {
+	path string
+	mode Mode
+	next http.RoundTripper
+
+	// Normalize is applied to request bodies before hashing
+	Normalize Normalizer
+	// PassThrough sends unmatched replay requests to the real transport
+	// instead of failing
+	PassThrough bool
+
+	mu       sync.Mutex
+	recorded []Interaction
+	byKey    map[string][]Interaction
+	served   map[string]int
+}
+
+// New opens the cassette at path. Replay mode requires the file to exist;
+// record mode starts a fresh cassette and writes it after every interaction.
+func New(path string, mode Mode, next http.RoundTripper) (*Recorder, error) {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	r := &Recorder{
+		path:      path,
+		mode:      mode,
+		next:      next,
+		Normalize: DefaultNormalizer,
+		byKey:     map[string][]Interaction{},
+		served:    map[string]int{},
+	}
+	switch mode {
+	case ModeRecord:
+	case ModeReplay:
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var f file
+		if err := json.Unmarshal(b, &f); err != nil {
+			return nil, fmt.Errorf("cassette %s: %w", path, err)
+		}
+		for _, in := range f.Interactions {
+			r.byKey[in.Key] = append(r.byKey[in.Key], in)
+		}
+	default:
+		return nil, fmt.Errorf("cassette: unknown mode %q", mode)
+	}
+	return r, nil
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	key := r.key(req.Method, req.URL.Path, body)
+
+	if r.mode == ModeReplay {
+		if in, ok := r.nextRecording(key); ok {
+			return in.Response.toHTTP(req), nil
+		}
+		if !r.PassThrough {
+			return nil, fmt.Errorf("%w: %s %s (key %s)", ErrNoInteraction, req.Method, req.URL.Path, key[:12])
+		}
+		return r.next.RoundTrip(req)
+	}
+
+	resp, err := r.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	in := Interaction{
+		Key:     key,
+		Request: Request{Method: req.Method, Path: req.URL.Path, Body: asJSON(body)},
+		Response: Response{
+			Status:      resp.StatusCode,
+			ContentType: resp.Header.Get("Content-Type"),
+			Body:        asJSON(respBody),
+		},
+	}
+	if err := r.append(in); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (r *Recorder) nextRecording(key string) (Interaction, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	recs := r.byKey[key]
+	if len(recs) == 0 {
+		return Interaction{}, false
+	}
+	i := r.served[key]
+	if i >= len(recs) {
+		i = len(recs) - 1
+	}
+	r.served[key]++
+	return recs[i], true
+}
+
+func (r *Recorder) append(in Interaction) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.recorded = append(r.recorded, in)
+
+	b, err := json.MarshalIndent(file{Interactions: r.recorded}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(r.path, append(b, '\n'), 0o644)
+}
+
+// key hashes the method, path and normalized, canonicalized body. The host is
+// left out so a cassette replays against any base URL.
+func (r *Recorder) key(method, path string, body []byte) string {
+	if r.Normalize != nil {
+		body = r.Normalize(body)
+	}
+	var v any
+	if json.Unmarshal(body, &v) == nil {
+		body, _ = json.Marshal(v)
+	}
+	sum := sha256.Sum256([]byte(method + " " + path + "\n" + string(body)))
+	return hex.EncodeToString(sum[:])
+}
+
+func (resp Response) toHTTP(req *http.Request) *http.Response {
+	body := []byte(resp.Body)
+	var s string
+	if json.Unmarshal(body, &s) == nil {
+		// Non-JSON bodies (SSE streams, error pages) are stored as strings
+		body = []byte(s)
+	} else {
+		// Undo the cassette file's indentation
+		var buf bytes.Buffer
+		if json.Compact(&buf, body) == nil {
+			body = buf.Bytes()
+		}
+	}
+	header := http.Header{}
+	if resp.ContentType != "" {
+		header.Set("Content-Type", resp.ContentType)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", resp.Status, http.StatusText(resp.Status)),
+		StatusCode:    resp.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// asJSON keeps JSON bodies readable in the cassette and stores anything else
+// as a JSON string.
+func asJSON(b []byte) json.RawMessage {
+	if len(b) == 0 {
+		return nil
+	}
+	if json.Valid(b) {
+		var buf bytes.Buffer
+		if json.Compact(&buf, b) == nil {
+			return buf.Bytes()
+		}
+	}
+	s, _ := json.Marshal(string(b))
+	return s
+}
@@ -0,0 +1,93 @@
+package cassette
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func post(t *testing.T, rt http.RoundTripper, url, body string) (*http.Response, error) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer sk-secret")
+	return (&http.Client{Transport: rt}).Do(req)
+}
+
+func TestRecordThenReplay(t *testing.T) {
+	var hits int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"n": ` + string(rune('0'+hits)) + `}`))
+	}))
+	defer srv.Close()
+	path := filepath.Join(t.TempDir(), "c.json")
+
+	rec, err := New(path, ModeRecord, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range []string{
+		`{"prompt": "at 2026-01-02T03:04:05Z for plan-1760000000000000000"}`,
+		`{"prompt": "at 2026-01-02T03:04:05Z for plan-1760000000000000000"}`,
+		`{"prompt": "other"}`,
+	} {
+		resp, err := post(t, rec, srv.URL+"/v1/chat/completions", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	raw, _ := os.ReadFile(path)
+	if strings.Contains(string(raw), "sk-secret") {
+		t.Error("cassette must not store credentials")
+	}
+
+	replay, err := New(path, ModeReplay, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Timestamps and generated IDs differ but normalize to the same key;
+	// repeated requests replay in recorded order, then repeat the last
+	var got []string
+	for _, body := range []string{
+		`{"prompt": "at 2026-10-16T11:58:41.123Z for plan-1790000000000000001"}`,
+		`{"prompt": "at 2026-10-16T11:58:42+02:00 for plan-1790000000000000002"}`,
+		`{"prompt": "at 2026-10-16T11:58:43Z for plan-1790000000000000003"}`,
+		`{ "prompt":"other" }`,
+	} {
+		resp, err := post(t, replay, "http://elsewhere.invalid/v1/chat/completions", body)
+		if err != nil {
+			t.Fatalf("replay %s: %v", body, err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		got = append(got, string(b))
+	}
+	if want := `{"n":1},{"n":2},{"n":2},{"n":3}`; strings.Join(got, ",") != want {
+		t.Errorf("expected %s, got %s", want, strings.Join(got, ","))
+	}
+	if hits != 3 {
+		t.Errorf("replay must not reach the server, got %d hits", hits)
+	}
+
+	if _, err := post(t, replay, srv.URL+"/v1/chat/completions", `{"prompt": "new"}`); !errors.Is(err, ErrNoInteraction) {
+		t.Errorf("expected ErrNoInteraction for unmatched request, got %v", err)
+	}
+	replay.PassThrough = true
+	resp, err := post(t, replay, srv.URL+"/v1/chat/completions", `{"prompt": "new"}`)
+	if err != nil || resp.StatusCode != http.StatusOK || hits != 4 {
+		t.Errorf("expected pass-through to the server, got %v (hits %d)", err, hits)
+	}
+}
+
+func TestReplayMissingCassette(t *testing.T) {
+	if _, err := New(filepath.Join(t.TempDir(), "missing.json"), ModeReplay, nil); err == nil {
+		t.Error("expected error for a missing cassette")
+	}
+}
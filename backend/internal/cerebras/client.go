@@ -4,10 +4,13 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
-	"os"
 	"strings"
+	"sync/atomic"
 	"time"
+
+	"simstack/internal/transport"
 )
 
 type OpenAIChatRequest struct {
@@ -17,11 +20,70 @@ type OpenAIChatRequest struct {
 	Tools       []Tool        `json:"tools,omitempty"`
 	ToolChoice  interface{}   `json:"tool_choice,omitempty"`
 	Stream      bool          `json:"stream,omitempty"`
+
+	MaxTokens        int      `json:"max_tokens,omitempty"`
+	Stop             []string `json:"stop,omitempty"`
+	TopP             float32  `json:"top_p,omitempty"`
+	FrequencyPenalty float32  `json:"frequency_penalty,omitempty"`
+	PresencePenalty  float32  `json:"presence_penalty,omitempty"`
+	N                int      `json:"n,omitempty"`
+
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+
+	// Seed asks the provider for best-effort deterministic sampling
+	Seed *int64 `json:"seed,omitempty"`
+}
+
+// MarshalJSON sends temperature even when it is 0 on seeded requests, where
+// determinism depends on it; otherwise a zero temperature is left to the
+// provider's default.
+func (r OpenAIChatRequest) MarshalJSON() ([]byte, error) {
+	type plain OpenAIChatRequest
+	if r.Seed == nil {
+		return json.Marshal(plain(r))
+	}
+	return json.Marshal(struct {
+		plain
+		Temperature float32 `json:"temperature"`
+	}{plain(r), r.Temperature})
+}
+
+// Validate rejects sampling values no provider would accept.
+func (r OpenAIChatRequest) Validate() error {
+	switch {
+	case r.MaxTokens < 0:
+		return fmt.Errorf("cerebras: max_tokens must not be negative, got %d", r.MaxTokens)
+	case r.N < 0:
+		return fmt.Errorf("cerebras: n must not be negative, got %d", r.N)
+	case r.TopP < 0 || r.TopP > 1:
+		return fmt.Errorf("cerebras: top_p must be within [0, 1], got %v", r.TopP)
+	case r.FrequencyPenalty < -2 || r.FrequencyPenalty > 2:
+		return fmt.Errorf("cerebras: frequency_penalty must be within [-2, 2], got %v", r.FrequencyPenalty)
+	case r.PresencePenalty < -2 || r.PresencePenalty > 2:
+		return fmt.Errorf("cerebras: presence_penalty must be within [-2, 2], got %v", r.PresencePenalty)
+	case len(r.Stop) > 4:
+		return fmt.Errorf("cerebras: at most 4 stop sequences are allowed, got %d", len(r.Stop))
+	}
+	return nil
 }
 
 type ChatMessage struct {
-	Role    string      `json:"role"`
-	Content interface{} `json:"content"`
+	Role       string      `json:"role"`
+	Content    interface{} `json:"content"`
+	Name       string      `json:"name,omitempty"`
+	ToolCalls  []ToolCall  `json:"tool_calls,omitempty"`
+	ToolCallID string      `json:"tool_call_id,omitempty"`
+}
+
+type ToolCall struct {
+	ID       string           `json:"id"`
+	Type     string           `json:"type"`
+	Function ToolCallFunction `json:"function"`
+}
+
+type ToolCallFunction struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
 }
 
 type Tool struct {
@@ -36,41 +98,228 @@ type Function struct {
 }
 
 type Client struct {
-	http  *http.Client
-	url   string
-	token string
+	http         *http.Client
+	base         string
+	url          string
+	token        string
+	maxToolIters int
+
+	// Until when, in Unix nanoseconds, calls skip response_format since the
+	// provider rejected it; see responseFormatMemory
+	noResponseFormatUntil atomic.Int64
+	// Set once the provider has rejected seed; later calls skip it
+	noSeed atomic.Bool
+
+	hook             Hook
+	hookContentLimit int
+
+	limiter *Limiter
+
+	offline atomic.Bool
+
+	// Absolute cap per call; see callContext
+	ceiling time.Duration
+
+	// Gateway extras applied to every request; see decorate
+	headers    []extraHeader
+	query      map[string]string
+	authHeader string
+	authScheme string
 }
 
-func New() *Client {
-	base := os.Getenv("CEREBRAS_API_BASE")
-	if base == "" {
-		base = "https://api.cerebras.ai/v1"
+// APIError is returned when the provider answers with a non-2xx status.
+type APIError struct {
+	Provider   string
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	provider := e.Provider
+	if provider == "" {
+		provider = "cerebras"
 	}
+	return fmt.Sprintf("%s error: %s", provider, e.Status)
+}
+
+// SetTransport swaps the HTTP transport.
+func (c *Client) SetTransport(rt http.RoundTripper) {
+	c.http = &http.Client{Transport: rt}
+}
+
+// NewClient builds a client for any OpenAI-compatible chat completions API.
+func NewClient(baseURL, token string) *Client {
+	base := strings.TrimRight(baseURL, "/")
 	return &Client{
-		http:  &http.Client{Timeout: 60 * time.Second},
-		url:   strings.TrimRight(base, "/") + "/chat/completions",
-		token: os.Getenv("CEREBRAS_API_KEY"),
+		// No http.Client timeout: deadlines come from the call context
+		http:         &http.Client{Transport: transport.SharedLLM()},
+		ceiling:      defaultCeiling,
+		base:         base,
+		url:          base + "/chat/completions",
+		token:        token,
+		maxToolIters: defaultMaxToolIterations,
 	}
 }
 
+func (c *Client) Name() string {
+	return "cerebras"
+}
+
 func (c *Client) Chat(ctx context.Context, req OpenAIChatRequest) (map[string]any, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	if err := checkFit(req); err != nil {
+		return nil, err
+	}
+	c.skipRejected(&req)
+	out, err := c.do(ctx, req)
+	for err != nil && c.stripRejected(&req, err) {
+		out, err = c.do(ctx, req)
+	}
+	return out, err
+}
+
+// responseFormatMemory is how long calls skip response_format after the
+// provider rejected it, before trying it again: a gateway may be fixed, or
+// routed to a model that takes it.
+const responseFormatMemory = 10 * time.Minute
+
+// SupportsResponseFormat reports whether response_format is being sent.
+func (c *Client) SupportsResponseFormat() bool {
+	return time.Now().UnixNano() >= c.noResponseFormatUntil.Load()
+}
+
+// SupportsSeed reports whether seed is still being sent.
+func (c *Client) SupportsSeed() bool {
+	return !c.noSeed.Load()
+}
+
+// skipRejected drops optional fields the provider has already rejected.
+func (c *Client) skipRejected(req *OpenAIChatRequest) {
+	if req.ResponseFormat != nil && !c.SupportsResponseFormat() {
+		req.ResponseFormat = nil
+	}
+	if req.Seed != nil && c.noSeed.Load() {
+		req.Seed = nil
+	}
+}
+
+// stripRejected removes the optional field a 400/422 most likely objects to
+// and reports whether the request changed, so the caller can retry. A seed
+// named in the error goes first, then response_format when the error names
+// it, or else seed. Any other bad request, like a context overflow or an
+// unknown model, keeps response_format.
+func (c *Client) stripRejected(req *OpenAIChatRequest, err error) bool {
+	if !rejectsParameter(err) {
+		return false
+	}
+	body := strings.ToLower(err.(*APIError).Body)
+	switch {
+	case req.Seed != nil && strings.Contains(body, "seed"):
+		c.noSeed.Store(true)
+		req.Seed = nil
+	case req.ResponseFormat != nil && (strings.Contains(body, "response_format") || strings.Contains(body, "json_schema")):
+		c.noResponseFormatUntil.Store(time.Now().Add(responseFormatMemory).UnixNano())
+		req.ResponseFormat = nil
+	case req.Seed != nil:
+		c.noSeed.Store(true)
+		req.Seed = nil
+	default:
+		return false
+	}
+	return true
+}
+
+func rejectsParameter(err error) bool {
+	apiErr, ok := err.(*APIError)
+	return ok && (apiErr.StatusCode == http.StatusBadRequest || apiErr.StatusCode == http.StatusUnprocessableEntity)
+}
+
+func (c *Client) do(ctx context.Context, req OpenAIChatRequest) (map[string]any, error) {
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+
 	b, _ := json.Marshal(req)
 	httpReq, _ := http.NewRequestWithContext(ctx, http.MethodPost, c.url, strings.NewReader(string(b)))
-	httpReq.Header.Set("Content-Type", "application/json")
-	if c.token != "" {
-		httpReq.Header.Set("Authorization", "Bearer "+c.token)
+
+	release, err := c.limiter.Acquire(ctx)
+	if err != nil {
+		return nil, explainTimeout(ctx, err)
 	}
-	resp, err := c.http.Do(httpReq)
+	defer release()
+
+	hook := c.hookFor(ctx)
+	start := time.Now()
+	out, err := c.decode(c.send(httpReq, hook, req))
+	err = explainTimeout(ctx, err)
+	if hook != nil {
+		if err != nil {
+			hook.OnError(c.errorEvent(req, err, start))
+		} else {
+			hook.OnResponse(c.responseEvent(req, out, start))
+		}
+	}
+	return out, err
+}
+
+func (c *Client) decode(resp *http.Response, err error) (map[string]any, error) {
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode >= 300 {
-		return nil, fmt.Errorf("cerebras error: %s", resp.Status)
-	}
 	var out map[string]any
 	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
-		return nil, err
+		// Reading the body is bounded by the same deadline as the headers
+		return nil, WrapTransportError(err)
 	}
 	return out, nil
 }
+
+// send sets auth headers and performs the request. When a hook is given it is
+// notified just before the request goes out.
+func (c *Client) send(httpReq *http.Request, hook Hook, req OpenAIChatRequest) (*http.Response, error) {
+	if c.offline.Load() || IsOffline(httpReq.Context()) {
+		return nil, ErrOffline
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	c.decorate(httpReq)
+	if hook != nil {
+		hook.OnRequest(c.requestEvent(httpReq, req))
+	}
+	resp, err := c.http.Do(httpReq)
+	if err != nil {
+		return nil, WrapTransportError(err)
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, &APIError{StatusCode: resp.StatusCode, Status: resp.Status, Body: string(body)}
+	}
+	return resp, nil
+}
+
+// FinishLength is the finish_reason of a completion cut off by max_tokens.
+const FinishLength = "length"
+
+// FinishReason returns the first choice's finish_reason, or "" if absent.
+func FinishReason(resp map[string]any) string {
+	choices, ok := resp["choices"].([]interface{})
+	if !ok || len(choices) == 0 {
+		return ""
+	}
+	choice, _ := choices[0].(map[string]interface{})
+	reason, _ := choice["finish_reason"].(string)
+	return reason
+}
+
+// MessageContent returns the text content of the first choice in a chat response.
+func MessageContent(resp map[string]any) (string, bool) {
+	msg, ok := firstMessage(resp)
+	if !ok {
+		return "", false
+	}
+	content, ok := msg["content"].(string)
+	return content, ok && content != ""
+}
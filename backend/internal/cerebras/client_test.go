@@ -0,0 +1,129 @@
+package cerebras
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestChatRequestOmitsUnsetFields(t *testing.T) {
+	b, err := json.Marshal(OpenAIChatRequest{Model: "m", Messages: []ChatMessage{{Role: "user", Content: "hi"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"max_tokens", "stop", "top_p", "frequency_penalty", "presence_penalty", `"n"`, "response_format", "temperature", "tools"} {
+		if strings.Contains(string(b), field) {
+			t.Errorf("unset field %s should be omitted: %s", field, b)
+		}
+	}
+
+	b, _ = json.Marshal(OpenAIChatRequest{Model: "m", MaxTokens: 256, Stop: []string{"}"}, TopP: 0.9, N: 1})
+	var got map[string]any
+	_ = json.Unmarshal(b, &got)
+	if got["max_tokens"] != 256.0 || got["n"] != 1.0 || got["top_p"] == nil {
+		t.Errorf("set fields missing from %s", b)
+	}
+	if stop, _ := got["stop"].([]any); len(stop) != 1 || stop[0] != "}" {
+		t.Errorf("unexpected stop %v", got["stop"])
+	}
+}
+
+func TestChatRequestValidate(t *testing.T) {
+	bad := []OpenAIChatRequest{
+		{MaxTokens: -1},
+		{N: -2},
+		{TopP: 1.5},
+		{FrequencyPenalty: 3},
+		{PresencePenalty: -2.5},
+		{Stop: []string{"a", "b", "c", "d", "e"}},
+	}
+	for _, req := range bad {
+		if err := req.Validate(); err == nil {
+			t.Errorf("expected validation error for %+v", req)
+		}
+	}
+	if err := (OpenAIChatRequest{MaxTokens: 100, TopP: 1, PresencePenalty: -2}).Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// Invalid requests never reach the network
+	c := NewClient("http://127.0.0.1:0", "")
+	if _, err := c.Chat(context.Background(), OpenAIChatRequest{MaxTokens: -5}); err == nil || !strings.Contains(err.Error(), "max_tokens") {
+		t.Errorf("expected max_tokens error, got %v", err)
+	}
+}
+
+func TestOfflineRefusesCalls(t *testing.T) {
+	var hits int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "key")
+	req := OpenAIChatRequest{Model: "m", Messages: []ChatMessage{{Role: "user", Content: "hi"}}}
+	if _, err := c.Chat(WithOffline(context.Background()), req); !errors.Is(err, ErrOffline) {
+		t.Errorf("expected ErrOffline for offline context, got %v", err)
+	}
+	c.SetOffline(true)
+	if _, err := c.Chat(context.Background(), req); !errors.Is(err, ErrOffline) {
+		t.Errorf("expected ErrOffline for offline client, got %v", err)
+	}
+	if _, err := c.Models(context.Background()); !errors.Is(err, ErrOffline) {
+		t.Errorf("expected ErrOffline listing models, got %v", err)
+	}
+	if hits != 0 {
+		t.Errorf("offline client made %d requests", hits)
+	}
+	if Category(ErrOffline) != "offline" {
+		t.Errorf("unexpected category %q", Category(ErrOffline))
+	}
+}
+
+func TestSeededRequestSendsZeroTemperature(t *testing.T) {
+	seed := int64(7)
+	b, _ := json.Marshal(OpenAIChatRequest{Model: "m", Seed: &seed})
+	var got map[string]any
+	_ = json.Unmarshal(b, &got)
+	if got["seed"] != 7.0 || got["temperature"] != 0.0 {
+		t.Errorf("seeded request must carry seed and temperature 0: %s", b)
+	}
+}
+
+func TestChatRetriesWithoutSeed(t *testing.T) {
+	var seeded, unseeded int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if _, ok := req["seed"]; ok {
+			seeded++
+			http.Error(w, `{"error":"unknown field: seed"}`, http.StatusBadRequest)
+			return
+		}
+		if req["response_format"] == nil {
+			t.Error("response_format should survive a seed rejection")
+		}
+		unseeded++
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"choices": []any{map[string]any{"message": map[string]any{"content": "{}"}}},
+		})
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "key")
+	seed := int64(1)
+	req := OpenAIChatRequest{Model: "m", Seed: &seed, ResponseFormat: JSONObjectFormat()}
+	if _, err := c.Chat(context.Background(), req); err != nil {
+		t.Fatalf("expected retry to succeed, got %v", err)
+	}
+	if _, err := c.Chat(context.Background(), req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if seeded != 1 || unseeded != 2 || c.SupportsSeed() || !c.SupportsResponseFormat() {
+		t.Errorf("expected seed stripped once and remembered, got %d/%d", seeded, unseeded)
+	}
+}
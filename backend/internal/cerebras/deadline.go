@@ -0,0 +1,87 @@
+package cerebras
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Limits that can bound a call, reported by TimeoutError.
+const (
+	LimitCaller = "caller deadline"
+	LimitCall   = "per-call timeout"
+	LimitClient = "client ceiling"
+)
+
+// defaultCeiling is the absolute cap on any single call. It only exists so a
+// caller that forgets a deadline can't hang forever; real limits come from
+// the caller's context and WithCallTimeout.
+const defaultCeiling = 10 * time.Minute
+
+// TimeoutError says which limit ended a call. It is wrapped in a CallError
+// categorized as ErrTimeout.
+type TimeoutError struct {
+	Limit   string
+	Timeout time.Duration
+	Err     error
+}
+
+func (e *TimeoutError) Error() string {
+	if e.Timeout > 0 {
+		return fmt.Sprintf("%s of %s exceeded: %v", e.Limit, e.Timeout, e.Err)
+	}
+	return fmt.Sprintf("%s exceeded: %v", e.Limit, e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+type callTimeoutKey struct{}
+
+// WithCallTimeout bounds calls made with ctx to d, on top of any deadline ctx
+// already has. The tighter of the two wins.
+func WithCallTimeout(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, callTimeoutKey{}, d)
+}
+
+// SetCeiling sets the absolute per-call cap (0 removes it).
+func (c *Client) SetCeiling(d time.Duration) {
+	c.ceiling = d
+}
+
+// callContext derives the context for one call: its deadline is the earliest
+// of the caller's deadline, the per-call timeout and the client ceiling.
+// When one of the latter two binds, it is recorded as the context's cause.
+func (c *Client) callContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	limit, d := "", time.Duration(0)
+	if t, ok := ctx.Value(callTimeoutKey{}).(time.Duration); ok && t > 0 {
+		limit, d = LimitCall, t
+	}
+	if c.ceiling > 0 && (d == 0 || c.ceiling < d) {
+		limit, d = LimitClient, c.ceiling
+	}
+	if d == 0 {
+		return context.WithCancel(ctx)
+	}
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Add(d).Before(deadline) {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeoutCause(ctx, d, &TimeoutError{Limit: limit, Timeout: d, Err: context.DeadlineExceeded})
+}
+
+// explainTimeout rewrites a timeout from a call made with callCtx so the
+// error names the limit that fired. Other errors pass through unchanged.
+func explainTimeout(callCtx context.Context, err error) error {
+	if err == nil || !errors.Is(callCtx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+	limit := &TimeoutError{Limit: LimitCaller}
+	var cause *TimeoutError
+	if errors.As(context.Cause(callCtx), &cause) {
+		limit = &TimeoutError{Limit: cause.Limit, Timeout: cause.Timeout}
+	}
+	limit.Err = err
+	return &CallError{Category: ErrTimeout, Err: limit}
+}
@@ -0,0 +1,108 @@
+package cerebras
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// slowServer delays the response headers by headerDelay, then sends half a
+// body and stalls for bodyDelay before finishing it.
+func slowServer(t *testing.T, headerDelay, bodyDelay time.Duration) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Drain the body so the server notices the client hanging up
+		io.Copy(io.Discard, r.Body)
+		select {
+		case <-time.After(headerDelay):
+		case <-r.Context().Done():
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"choices":[{"message":`))
+		w.(http.Flusher).Flush()
+		select {
+		case <-time.After(bodyDelay):
+		case <-r.Context().Done():
+			return
+		}
+		w.Write([]byte(`{"role":"assistant","content":"ok"}}]}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func timeoutLimit(t *testing.T, err error) string {
+	t.Helper()
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected ErrTimeout, got %v", err)
+	}
+	var te *TimeoutError
+	if !errors.As(err, &te) {
+		t.Fatalf("expected a TimeoutError, got %v", err)
+	}
+	return te.Limit
+}
+
+func TestCallDeadlineLimits(t *testing.T) {
+	req := OpenAIChatRequest{Model: "m", Messages: []ChatMessage{{Role: "user", Content: "hi"}}}
+	cases := []struct {
+		name        string
+		headerDelay time.Duration
+		bodyDelay   time.Duration
+		caller      time.Duration
+		call        time.Duration
+		ceiling     time.Duration
+		want        string
+	}{
+		{"caller wins", time.Second, 0, 50 * time.Millisecond, 0, time.Minute, LimitCaller},
+		{"per-call wins", time.Second, 0, time.Minute, 50 * time.Millisecond, time.Minute, LimitCall},
+		{"ceiling wins", time.Second, 0, time.Minute, time.Minute, 50 * time.Millisecond, LimitClient},
+		{"body read bounded", 0, time.Second, time.Minute, 50 * time.Millisecond, time.Minute, LimitCall},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := NewClient(slowServer(t, tc.headerDelay, tc.bodyDelay).URL, "key")
+			c.SetCeiling(tc.ceiling)
+			ctx, cancel := context.WithTimeout(context.Background(), tc.caller)
+			defer cancel()
+			if tc.call > 0 {
+				ctx = WithCallTimeout(ctx, tc.call)
+			}
+			start := time.Now()
+			_, err := c.Chat(ctx, req)
+			if got := timeoutLimit(t, err); got != tc.want {
+				t.Errorf("expected %q to fire, got %q (%v)", tc.want, got, err)
+			}
+			if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+				t.Errorf("call took %s, deadline not enforced", elapsed)
+			}
+		})
+	}
+}
+
+func TestCallOutlivesOldClientTimeout(t *testing.T) {
+	// Without a per-call limit, only the caller's deadline and the ceiling apply
+	c := NewClient(slowServer(t, 20*time.Millisecond, 20*time.Millisecond).URL, "key")
+	if c.http.Timeout != 0 {
+		t.Errorf("expected no http.Client timeout, got %s", c.http.Timeout)
+	}
+	req := OpenAIChatRequest{Model: "m", Messages: []ChatMessage{{Role: "user", Content: "hi"}}}
+	if _, err := c.Chat(context.Background(), req); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestStreamRespectsCallTimeout(t *testing.T) {
+	c := NewClient(slowServer(t, time.Second, 0).URL, "key")
+	ctx := WithCallTimeout(context.Background(), 50*time.Millisecond)
+	req := OpenAIChatRequest{Model: "m", Messages: []ChatMessage{{Role: "user", Content: "hi"}}}
+	_, err := c.ChatStream(ctx, req, func(string) {})
+	if got := timeoutLimit(t, err); got != LimitCall {
+		t.Errorf("expected per-call timeout, got %q", got)
+	}
+}
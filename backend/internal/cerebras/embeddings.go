@@ -0,0 +1,84 @@
+package cerebras
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// maxEmbeddingBatch caps inputs per /embeddings request; larger requests are
+// split and the results stitched back together in input order.
+const maxEmbeddingBatch = 64
+
+type EmbeddingRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type EmbeddingResponse struct {
+	Model string         `json:"model"`
+	Data  []Embedding    `json:"data"`
+	Usage EmbeddingUsage `json:"usage"`
+}
+
+type Embedding struct {
+	Index     int       `json:"index"`
+	Embedding []float64 `json:"embedding"`
+}
+
+type EmbeddingUsage struct {
+	PromptTokens int `json:"prompt_tokens"`
+	TotalTokens  int `json:"total_tokens"`
+}
+
+// Embeddings calls the provider's /embeddings endpoint, batching inputs and
+// summing usage across batches.
+func (c *Client) Embeddings(ctx context.Context, req EmbeddingRequest) (EmbeddingResponse, error) {
+	out := EmbeddingResponse{Model: req.Model, Data: make([]Embedding, 0, len(req.Input))}
+	for start := 0; start < len(req.Input); start += maxEmbeddingBatch {
+		end := min(start+maxEmbeddingBatch, len(req.Input))
+		batch, err := c.embedBatch(ctx, EmbeddingRequest{Model: req.Model, Input: req.Input[start:end]})
+		if err != nil {
+			return EmbeddingResponse{}, err
+		}
+		for _, d := range batch.Data {
+			d.Index += start
+			out.Data = append(out.Data, d)
+		}
+		if batch.Model != "" {
+			out.Model = batch.Model
+		}
+		out.Usage.PromptTokens += batch.Usage.PromptTokens
+		out.Usage.TotalTokens += batch.Usage.TotalTokens
+	}
+	return out, nil
+}
+
+func (c *Client) embedBatch(ctx context.Context, req EmbeddingRequest) (out EmbeddingResponse, err error) {
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+	defer func() { err = explainTimeout(ctx, err) }()
+
+	release, err := c.limiter.Acquire(ctx)
+	if err != nil {
+		return EmbeddingResponse{}, err
+	}
+	defer release()
+
+	b, _ := json.Marshal(req)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.base+"/embeddings", bytes.NewReader(b))
+	if err != nil {
+		return EmbeddingResponse{}, err
+	}
+	resp, err := c.send(httpReq, nil, OpenAIChatRequest{})
+	if err != nil {
+		return EmbeddingResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return EmbeddingResponse{}, WrapTransportError(err)
+	}
+	return out, nil
+}
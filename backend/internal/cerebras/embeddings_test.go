@@ -0,0 +1,52 @@
+package cerebras
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEmbeddingsBatches(t *testing.T) {
+	var batches []int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/embeddings" {
+			http.NotFound(w, r)
+			return
+		}
+		var req EmbeddingRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		batches = append(batches, len(req.Input))
+
+		resp := EmbeddingResponse{Model: req.Model, Usage: EmbeddingUsage{PromptTokens: len(req.Input), TotalTokens: len(req.Input)}}
+		for i, in := range req.Input {
+			resp.Data = append(resp.Data, Embedding{Index: i, Embedding: []float64{float64(len(in))}})
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	defer srv.Close()
+
+	inputs := make([]string, 150)
+	for i := range inputs {
+		inputs[i] = string(make([]byte, i))
+	}
+	out, err := NewClient(srv.URL, "").Embeddings(context.Background(), EmbeddingRequest{Model: "emb", Input: inputs})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(batches) != 3 || batches[0] != 64 || batches[2] != 22 {
+		t.Errorf("unexpected batch sizes %v", batches)
+	}
+	if len(out.Data) != 150 {
+		t.Fatalf("expected 150 embeddings, got %d", len(out.Data))
+	}
+	for i, d := range out.Data {
+		if d.Index != i || d.Embedding[0] != float64(i) {
+			t.Fatalf("embedding %d out of order: %+v", i, d)
+		}
+	}
+	if out.Usage.TotalTokens != 150 {
+		t.Errorf("expected usage summed across batches, got %+v", out.Usage)
+	}
+}
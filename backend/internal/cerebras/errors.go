@@ -0,0 +1,90 @@
+package cerebras
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// Error categories. Every error returned by a chat call matches exactly one of
+// these with errors.Is, alongside the underlying context error where relevant.
+var (
+	ErrTimeout    = errors.New("llm: timeout")
+	ErrCanceled   = errors.New("llm: canceled")
+	ErrConnection = errors.New("llm: connection failed")
+	ErrAPI        = errors.New("llm: provider error")
+)
+
+// CallError tags a transport failure with its category while keeping the
+// original error (and any context error it wraps) reachable.
+type CallError struct {
+	Category error
+	Err      error
+}
+
+func (e *CallError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CallError) Unwrap() []error {
+	return []error{e.Category, e.Err}
+}
+
+func (e *APIError) Is(target error) bool {
+	return target == ErrAPI
+}
+
+// WrapTransportError categorizes an error from http.Client.Do.
+func WrapTransportError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &CallError{Category: categorize(err), Err: err}
+}
+
+func categorize(err error) error {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		return ErrCanceled
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, ErrRateLimited):
+		return ErrTimeout
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ErrTimeout
+	default:
+		return ErrConnection
+	}
+}
+
+// Classify returns the category sentinel for err, or nil for nil. Errors not
+// produced by the client (e.g. a bare context error) are categorized the same way.
+func Classify(err error) error {
+	if err == nil {
+		return nil
+	}
+	for _, cat := range []error{ErrCanceled, ErrTimeout, ErrConnection, ErrAPI} {
+		if errors.Is(err, cat) {
+			return cat
+		}
+	}
+	return categorize(err)
+}
+
+// Category is the short name of err's category for logs, metrics and events.
+func Category(err error) string {
+	if errors.Is(err, ErrOffline) {
+		return "offline"
+	}
+	switch Classify(err) {
+	case ErrCanceled:
+		return "canceled"
+	case ErrTimeout:
+		return "timeout"
+	case ErrConnection:
+		return "connection"
+	case ErrAPI:
+		return "api"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,79 @@
+package cerebras
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClassifyErrors(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"internal"}`, http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	// Grab a free port and close it so connections are refused
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refusedURL := "http://" + ln.Addr().String()
+	_ = ln.Close()
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancelExpired := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancelExpired()
+
+	cases := []struct {
+		name     string
+		ctx      context.Context
+		url      string
+		category error
+		ctxErr   error
+	}{
+		{"canceled", canceled, slow.URL, ErrCanceled, context.Canceled},
+		{"deadline", expired, slow.URL, ErrTimeout, context.DeadlineExceeded},
+		{"refused", context.Background(), refusedURL, ErrConnection, nil},
+		{"server error", context.Background(), failing.URL, ErrAPI, nil},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewClient(tc.url, "").Chat(tc.ctx, OpenAIChatRequest{Model: "m"})
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if got := Classify(err); got != tc.category {
+				t.Errorf("expected %v, got %v (%v)", tc.category, got, err)
+			}
+			if tc.ctxErr != nil && !errors.Is(err, tc.ctxErr) {
+				t.Errorf("expected errors.Is(err, %v) to hold for %v", tc.ctxErr, err)
+			}
+		})
+	}
+}
+
+func TestClassifyAPIErrorKeepsStatus(t *testing.T) {
+	err := error(&APIError{StatusCode: 500, Status: "500 Internal Server Error", Body: "boom"})
+	if Category(err) != "api" {
+		t.Errorf("expected api category, got %q", Category(err))
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Body != "boom" {
+		t.Error("APIError should stay reachable")
+	}
+	if Classify(nil) != nil || Category(nil) != "" {
+		t.Error("nil error should have no category")
+	}
+}
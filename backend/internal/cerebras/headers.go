@@ -0,0 +1,117 @@
+package cerebras
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// extraHeader is a header sent on every request (or one call). Sensitive
+// values are redacted from hook events.
+type extraHeader struct {
+	name      string
+	value     string
+	sensitive bool
+}
+
+// SetHeader adds a header to every request, replacing any earlier value.
+func (c *Client) SetHeader(name, value string, sensitive bool) {
+	c.headers = setHeader(c.headers, extraHeader{name, value, sensitive})
+}
+
+// SetQueryParam adds a query parameter to every request URL.
+func (c *Client) SetQueryParam(key, value string) {
+	if c.query == nil {
+		c.query = map[string]string{}
+	}
+	c.query[key] = value
+}
+
+// SetAuthHeader changes how the API key is sent, for gateways that expect
+// e.g. "api-key: <key>" instead of "Authorization: Bearer <key>". An empty
+// scheme sends the bare key.
+func (c *Client) SetAuthHeader(name, scheme string) {
+	c.authHeader = name
+	c.authScheme = scheme
+}
+
+type callHeadersKey struct{}
+type callQueryKey struct{}
+
+// WithHeader adds a header to calls made with ctx, overriding a client-wide
+// header of the same name.
+func WithHeader(ctx context.Context, name, value string, sensitive bool) context.Context {
+	prev, _ := ctx.Value(callHeadersKey{}).([]extraHeader)
+	headers := setHeader(append([]extraHeader(nil), prev...), extraHeader{name, value, sensitive})
+	return context.WithValue(ctx, callHeadersKey{}, headers)
+}
+
+// WithQueryParam adds a query parameter to calls made with ctx, overriding a
+// client-wide parameter of the same name.
+func WithQueryParam(ctx context.Context, key, value string) context.Context {
+	prev, _ := ctx.Value(callQueryKey{}).(map[string]string)
+	query := make(map[string]string, len(prev)+1)
+	for k, v := range prev {
+		query[k] = v
+	}
+	query[key] = value
+	return context.WithValue(ctx, callQueryKey{}, query)
+}
+
+func setHeader(headers []extraHeader, h extraHeader) []extraHeader {
+	for i := range headers {
+		if strings.EqualFold(headers[i].name, h.name) {
+			headers[i] = h
+			return headers
+		}
+	}
+	return append(headers, h)
+}
+
+// decorate applies auth, client-wide and per-call headers and query
+// parameters to an outgoing request.
+func (c *Client) decorate(httpReq *http.Request) {
+	ctx := httpReq.Context()
+	if c.token != "" {
+		name, value := c.authHeader, c.token
+		if name == "" {
+			name, value = "Authorization", "Bearer "+c.token
+		} else if c.authScheme != "" {
+			value = c.authScheme + " " + c.token
+		}
+		httpReq.Header.Set(name, value)
+	}
+	callHeaders, _ := ctx.Value(callHeadersKey{}).([]extraHeader)
+	for _, h := range append(append([]extraHeader(nil), c.headers...), callHeaders...) {
+		httpReq.Header.Set(h.name, h.value)
+	}
+
+	callQuery, _ := ctx.Value(callQueryKey{}).(map[string]string)
+	if len(c.query) == 0 && len(callQuery) == 0 {
+		return
+	}
+	q := httpReq.URL.Query()
+	for k, v := range c.query {
+		q.Set(k, v)
+	}
+	for k, v := range callQuery {
+		q.Set(k, v)
+	}
+	httpReq.URL.RawQuery = q.Encode()
+}
+
+// sensitiveHeader reports whether a header's value must not be logged: the
+// auth header always, plus any header set as sensitive.
+func (c *Client) sensitiveHeader(ctx context.Context, name string) bool {
+	if strings.EqualFold(name, "Authorization") || (c.authHeader != "" && strings.EqualFold(name, c.authHeader)) {
+		return true
+	}
+	callHeaders, _ := ctx.Value(callHeadersKey{}).([]extraHeader)
+	sensitive := false
+	for _, h := range append(append([]extraHeader(nil), c.headers...), callHeaders...) {
+		if strings.EqualFold(h.name, name) {
+			sensitive = h.sensitive
+		}
+	}
+	return sensitive
+}
@@ -0,0 +1,123 @@
+package cerebras
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// gatewayServer answers chat, streaming and model requests, recording the
+// headers and query of each by path.
+func gatewayServer(t *testing.T) (*httptest.Server, func(path string) (http.Header, url.Values)) {
+	var mu sync.Mutex
+	headers := map[string]http.Header{}
+	queries := map[string]url.Values{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.URL.Path
+		if r.Header.Get("Accept") == "text/event-stream" {
+			key += "#stream"
+		}
+		mu.Lock()
+		headers[key] = r.Header.Clone()
+		queries[key] = r.URL.Query()
+		mu.Unlock()
+
+		switch {
+		case strings.HasSuffix(key, "#stream"):
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"ok\"}}]}\n\ndata: [DONE]\n\n"))
+		case r.URL.Path == "/models":
+			json.NewEncoder(w).Encode(map[string]any{"data": []any{map[string]any{"id": "m"}}})
+		default:
+			json.NewEncoder(w).Encode(map[string]any{
+				"choices": []any{map[string]any{"message": map[string]any{"content": "ok"}}},
+			})
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func(path string) (http.Header, url.Values) {
+		mu.Lock()
+		defer mu.Unlock()
+		return headers[path], queries[path]
+	}
+}
+
+func TestGatewayHeadersAndQuery(t *testing.T) {
+	srv, seen := gatewayServer(t)
+	c := NewClient(srv.URL, "gw-key")
+	c.SetHeader("X-Org-ID", "acme", false)
+	c.SetHeader("X-Cost-Center", "42", false)
+	c.SetQueryParam("api-version", "2024-06-01")
+	c.SetAuthHeader("api-key", "")
+
+	req := OpenAIChatRequest{Model: "m", Messages: []ChatMessage{{Role: "user", Content: "hi"}}}
+	ctx := WithQueryParam(WithHeader(context.Background(), "X-Cost-Center", "99", false), "trace", "t1")
+	if _, err := c.Chat(ctx, req); err != nil {
+		t.Fatalf("chat: %v", err)
+	}
+	if _, err := c.ChatStream(context.Background(), req, func(string) {}); err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	if _, err := c.Models(context.Background()); err != nil {
+		t.Fatalf("models: %v", err)
+	}
+
+	for _, path := range []string{"/chat/completions", "/chat/completions#stream", "/models"} {
+		h, q := seen(path)
+		if h == nil {
+			t.Errorf("%s: no request seen", path)
+			continue
+		}
+		if h.Get("X-Org-ID") != "acme" || q.Get("api-version") != "2024-06-01" {
+			t.Errorf("%s: missing gateway extras, headers %v query %v", path, h, q)
+		}
+		if h.Get("api-key") != "gw-key" || h.Get("Authorization") != "" {
+			t.Errorf("%s: expected key in api-key header only, got %v", path, h)
+		}
+	}
+
+	h, q := seen("/chat/completions")
+	if h.Get("X-Cost-Center") != "99" || q.Get("trace") != "t1" {
+		t.Errorf("per-call overrides not applied: headers %v query %v", h, q)
+	}
+	h, q = seen("/models")
+	if h.Get("X-Cost-Center") != "42" || q.Has("trace") {
+		t.Errorf("per-call overrides leaked into other calls: headers %v query %v", h, q)
+	}
+}
+
+func TestSensitiveHeadersExcludedFromHook(t *testing.T) {
+	srv, _ := gatewayServer(t)
+	c := NewClient(srv.URL, "gw-key")
+	c.SetAuthHeader("X-Gateway-Auth", "Token")
+	c.SetHeader("X-Org-ID", "acme", false)
+	c.SetHeader("X-Session", "sess-secret", true)
+	hook := &recordingHook{}
+	c.SetHook(hook, 0)
+
+	ctx := WithHeader(context.Background(), "X-Call-Secret", "call-secret", true)
+	req := OpenAIChatRequest{Model: "m", Messages: []ChatMessage{{Role: "user", Content: "token sess-secret"}}}
+	if _, err := c.Chat(ctx, req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ev := hook.requests[0]
+	for _, name := range []string{"X-Gateway-Auth", "X-Session", "X-Call-Secret"} {
+		if _, ok := ev.Headers[name]; ok {
+			t.Errorf("sensitive header %s leaked into hook", name)
+		}
+	}
+	if ev.Headers["X-Org-Id"] != "acme" {
+		t.Errorf("expected plain header in hook, got %v", ev.Headers)
+	}
+	b, _ := json.Marshal(ev)
+	for _, secret := range []string{"gw-key", "sess-secret", "call-secret"} {
+		if strings.Contains(string(b), secret) {
+			t.Errorf("%s leaked into request event: %s", secret, b)
+		}
+	}
+}
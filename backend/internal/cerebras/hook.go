@@ -0,0 +1,248 @@
+package cerebras
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Hook observes chat traffic for debugging. Events are sanitized copies: the
+// Authorization header and API key never appear, and message content is
+// truncated, so a hook can neither leak secrets nor alter the real request.
+type Hook interface {
+	OnRequest(ev RequestEvent)
+	OnResponse(ev ResponseEvent)
+	OnError(ev ErrorEvent)
+}
+
+type LoggedMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type RequestEvent struct {
+	Time     time.Time         `json:"time"`
+	URL      string            `json:"url"`
+	Model    string            `json:"model"`
+	Stream   bool              `json:"stream,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	Messages []LoggedMessage   `json:"messages"`
+}
+
+type ResponseEvent struct {
+	Time     time.Time      `json:"time"`
+	Model    string         `json:"model"`
+	Status   int            `json:"status"`
+	Duration time.Duration  `json:"duration_ns"`
+	Content  string         `json:"content"`
+	Usage    map[string]any `json:"usage,omitempty"`
+}
+
+type ErrorEvent struct {
+	Time     time.Time     `json:"time"`
+	Model    string        `json:"model"`
+	Status   int           `json:"status,omitempty"`
+	Duration time.Duration `json:"duration_ns"`
+	Error    string        `json:"error"`
+}
+
+const defaultHookContentLimit = 512
+
+type hookKey struct{}
+
+// WithHook attaches a hook to ctx so that only calls made with that context
+// (e.g. a single debug run) are observed.
+func WithHook(ctx context.Context, h Hook) context.Context {
+	return context.WithValue(ctx, hookKey{}, h)
+}
+
+// SetHook installs a client-wide hook. maxContent caps logged message content
+// (0 keeps the default).
+func (c *Client) SetHook(h Hook, maxContent int) {
+	c.hook = h
+	if maxContent > 0 {
+		c.hookContentLimit = maxContent
+	}
+}
+
+// hookFor returns the hooks that apply to ctx, or nil so callers can skip
+// building events entirely.
+func (c *Client) hookFor(ctx context.Context) Hook {
+	ctxHook, _ := ctx.Value(hookKey{}).(Hook)
+	switch {
+	case c.hook == nil:
+		return ctxHook
+	case ctxHook == nil:
+		return c.hook
+	default:
+		return multiHook{c.hook, ctxHook}
+	}
+}
+
+type multiHook []Hook
+
+func (m multiHook) OnRequest(ev RequestEvent) {
+	for _, h := range m {
+		h.OnRequest(ev)
+	}
+}
+
+func (m multiHook) OnResponse(ev ResponseEvent) {
+	for _, h := range m {
+		h.OnResponse(ev)
+	}
+}
+
+func (m multiHook) OnError(ev ErrorEvent) {
+	for _, h := range m {
+		h.OnError(ev)
+	}
+}
+
+func (c *Client) requestEvent(httpReq *http.Request, req OpenAIChatRequest) RequestEvent {
+	headers := make(map[string]string, len(httpReq.Header))
+	for k, v := range httpReq.Header {
+		if c.sensitiveHeader(httpReq.Context(), k) {
+			continue
+		}
+		headers[k] = c.redact(strings.Join(v, ","))
+	}
+	messages := make([]LoggedMessage, 0, len(req.Messages))
+	for _, m := range req.Messages {
+		messages = append(messages, LoggedMessage{Role: m.Role, Content: c.sanitize(fmt.Sprint(m.Content))})
+	}
+	return RequestEvent{
+		Time:     time.Now(),
+		URL:      httpReq.URL.String(),
+		Model:    req.Model,
+		Stream:   req.Stream,
+		Headers:  headers,
+		Messages: messages,
+	}
+}
+
+func (c *Client) responseEvent(req OpenAIChatRequest, out map[string]any, start time.Time) ResponseEvent {
+	content, _ := MessageContent(out)
+	usage, _ := out["usage"].(map[string]any)
+	return ResponseEvent{
+		Time:     time.Now(),
+		Model:    req.Model,
+		Status:   http.StatusOK,
+		Duration: time.Since(start),
+		Content:  c.sanitize(content),
+		Usage:    usage,
+	}
+}
+
+func (c *Client) errorEvent(req OpenAIChatRequest, err error, start time.Time) ErrorEvent {
+	ev := ErrorEvent{
+		Time:     time.Now(),
+		Model:    req.Model,
+		Duration: time.Since(start),
+		Error:    c.sanitize(err.Error()),
+	}
+	if apiErr, ok := err.(*APIError); ok {
+		ev.Status = apiErr.StatusCode
+		if apiErr.Body != "" {
+			ev.Error = c.sanitize(err.Error() + ": " + apiErr.Body)
+		}
+	}
+	return ev
+}
+
+// sanitize redacts the API key and truncates to the hook content limit.
+func (c *Client) sanitize(s string) string {
+	s = c.redact(s)
+	limit := c.hookContentLimit
+	if limit <= 0 {
+		limit = defaultHookContentLimit
+	}
+	if len(s) > limit {
+		return s[:limit] + fmt.Sprintf("…[%d bytes truncated]", len(s)-limit)
+	}
+	return s
+}
+
+func (c *Client) redact(s string) string {
+	if c.token != "" {
+		s = strings.ReplaceAll(s, c.token, "[REDACTED]")
+	}
+	for _, h := range c.headers {
+		if h.sensitive && h.value != "" {
+			s = strings.ReplaceAll(s, h.value, "[REDACTED]")
+		}
+	}
+	return s
+}
+
+// SlogHook logs chat traffic through a structured logger at debug level.
+type SlogHook struct {
+	Logger *slog.Logger
+}
+
+func (h SlogHook) logger() *slog.Logger {
+	if h.Logger == nil {
+		return slog.Default()
+	}
+	return h.Logger
+}
+
+func (h SlogHook) OnRequest(ev RequestEvent) {
+	h.logger().Debug("llm request", "url", ev.URL, "model", ev.Model, "stream", ev.Stream, "messages", ev.Messages)
+}
+
+func (h SlogHook) OnResponse(ev ResponseEvent) {
+	h.logger().Debug("llm response", "model", ev.Model, "status", ev.Status, "duration", ev.Duration, "content", ev.Content, "usage", ev.Usage)
+}
+
+func (h SlogHook) OnError(ev ErrorEvent) {
+	h.logger().Warn("llm error", "model", ev.Model, "status", ev.Status, "duration", ev.Duration, "error", ev.Error)
+}
+
+// JSONLHook appends one JSON object per event to a writer.
+type JSONLHook struct {
+	mu sync.Mutex
+	w  io.Writer
+	c  io.Closer
+}
+
+func NewJSONLHook(w io.Writer) *JSONLHook {
+	return &JSONLHook{w: w}
+}
+
+// OpenJSONLHook appends events to the file at path, creating it if needed.
+func OpenJSONLHook(path string) (*JSONLHook, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONLHook{w: f, c: f}, nil
+}
+
+func (h *JSONLHook) OnRequest(ev RequestEvent)   { h.write("request", ev) }
+func (h *JSONLHook) OnResponse(ev ResponseEvent) { h.write("response", ev) }
+func (h *JSONLHook) OnError(ev ErrorEvent)       { h.write("error", ev) }
+
+func (h *JSONLHook) Close() error {
+	if h.c == nil {
+		return nil
+	}
+	return h.c.Close()
+}
+
+func (h *JSONLHook) write(kind string, ev any) {
+	b, err := json.Marshal(map[string]any{"event": kind, "data": ev})
+	if err != nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, _ = h.w.Write(append(b, '\n'))
+}
@@ -0,0 +1,108 @@
+package cerebras
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type recordingHook struct {
+	requests  []RequestEvent
+	responses []ResponseEvent
+	errors    []ErrorEvent
+}
+
+func (h *recordingHook) OnRequest(ev RequestEvent)   { h.requests = append(h.requests, ev) }
+func (h *recordingHook) OnResponse(ev ResponseEvent) { h.responses = append(h.responses, ev) }
+func (h *recordingHook) OnError(ev ErrorEvent)       { h.errors = append(h.errors, ev) }
+
+func TestHookRedactsAndTruncates(t *testing.T) {
+	const key = "csk-secret-123"
+	var gotAuth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"choices": []any{map[string]any{"message": map[string]any{"content": strings.Repeat("x", 100)}}},
+		})
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, key)
+	hook := &recordingHook{}
+	c.SetHook(hook, 20)
+
+	req := OpenAIChatRequest{Model: "m", Messages: []ChatMessage{{Role: "user", Content: "my key is " + key}}}
+	if _, err := c.Chat(context.Background(), req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gotAuth != "Bearer "+key {
+		t.Errorf("hook must not alter the real request, got auth %q", gotAuth)
+	}
+	if len(hook.requests) != 1 || len(hook.responses) != 1 {
+		t.Fatalf("expected one request and response event, got %d/%d", len(hook.requests), len(hook.responses))
+	}
+
+	ev := hook.requests[0]
+	if _, ok := ev.Headers["Authorization"]; ok {
+		t.Error("Authorization header leaked into hook")
+	}
+	b, _ := json.Marshal(ev)
+	if bytes.Contains(b, []byte(key)) {
+		t.Errorf("API key leaked into request event: %s", b)
+	}
+	if !strings.HasPrefix(ev.Messages[0].Content, "my key is [REDACTED]") {
+		t.Errorf("expected redacted content, got %q", ev.Messages[0].Content)
+	}
+	if resp := hook.responses[0].Content; !strings.HasPrefix(resp, strings.Repeat("x", 20)+"…") {
+		t.Errorf("expected truncated response content, got %q", resp)
+	}
+	if req.Messages[0].Content != "my key is "+key {
+		t.Error("caller's request was mutated")
+	}
+}
+
+func TestHookFromContextSeesErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid key tok-abc", http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "tok-abc")
+	hook := &recordingHook{}
+	ctx := WithHook(context.Background(), hook)
+	if _, err := c.Chat(ctx, OpenAIChatRequest{Model: "m"}); err == nil {
+		t.Fatal("expected error")
+	}
+	if len(hook.errors) != 1 || hook.errors[0].Status != http.StatusUnauthorized {
+		t.Fatalf("expected one 401 error event, got %+v", hook.errors)
+	}
+	if strings.Contains(hook.errors[0].Error, "tok-abc") {
+		t.Errorf("API key leaked into error event: %q", hook.errors[0].Error)
+	}
+
+	// Calls without the hook in their context aren't observed
+	_, _ = c.Chat(context.Background(), OpenAIChatRequest{Model: "m"})
+	if len(hook.requests) != 1 {
+		t.Errorf("expected context-scoped hook, got %d requests", len(hook.requests))
+	}
+}
+
+func TestJSONLHookWritesLines(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewJSONLHook(&buf)
+	h.OnRequest(RequestEvent{Model: "m"})
+	h.OnError(ErrorEvent{Model: "m", Error: "boom"})
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d", len(lines))
+	}
+	var first map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil || first["event"] != "request" {
+		t.Errorf("unexpected first line %q", lines[0])
+	}
+}
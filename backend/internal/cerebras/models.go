@@ -0,0 +1,54 @@
+package cerebras
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// ModelInfo describes one model served by the provider.
+type ModelInfo struct {
+	ID      string `json:"id"`
+	OwnedBy string `json:"owned_by,omitempty"`
+	Created int64  `json:"created,omitempty"`
+	// Context window from the local table; 0 when unknown
+	ContextWindow int `json:"context_window,omitempty"`
+}
+
+// Models lists the models the provider serves via GET /models.
+func (c *Client) Models(ctx context.Context) (models []ModelInfo, err error) {
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+	defer func() { err = explainTimeout(ctx, err) }()
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, c.base+"/models", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.send(httpReq, nil, OpenAIChatRequest{})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var out struct {
+		Data []ModelInfo `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	for i := range out.Data {
+		out.Data[i].ContextWindow, _ = ContextWindow(out.Data[i].ID)
+	}
+	return out.Data, nil
+}
+
+// HasModel reports whether id is among models.
+func HasModel(models []ModelInfo, id string) bool {
+	for _, m := range models {
+		if m.ID == id {
+			return true
+		}
+	}
+	return false
+}
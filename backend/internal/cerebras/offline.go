@@ -0,0 +1,28 @@
+package cerebras
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrOffline is returned instead of contacting the provider while offline
+// mode is on, so stray call sites fail loudly rather than leak requests.
+var ErrOffline = errors.New("llm call refused: offline mode")
+
+type offlineKey struct{}
+
+// WithOffline marks ctx so any LLM call made with it is refused.
+func WithOffline(ctx context.Context) context.Context {
+	return context.WithValue(ctx, offlineKey{}, true)
+}
+
+// IsOffline reports whether ctx was marked by WithOffline.
+func IsOffline(ctx context.Context) bool {
+	on, _ := ctx.Value(offlineKey{}).(bool)
+	return on
+}
+
+// SetOffline refuses every call made through the client while on.
+func (c *Client) SetOffline(on bool) {
+	c.offline.Store(on)
+}
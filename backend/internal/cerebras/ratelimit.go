@@ -0,0 +1,171 @@
+package cerebras
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrRateLimited = errors.New("cerebras: client rate limit exceeded")
+
+// Limiter is a token bucket (requests per minute) combined with a cap on
+// concurrent in-flight requests. One limiter is shared by every call made
+// through the client it is attached to.
+type Limiter struct {
+	mu       sync.Mutex
+	interval time.Duration // time to earn one token
+	burst    float64
+	tokens   float64
+	last     time.Time
+
+	sem chan struct{}
+
+	delayed  atomic.Int64
+	rejected atomic.Int64
+}
+
+type LimiterStats struct {
+	Delayed  int64 `json:"delayed"`
+	Rejected int64 `json:"rejected"`
+}
+
+// NewLimiter allows rpm requests per minute, bursting up to burst (rpm when
+// zero), and at most maxConcurrent in flight. Zero rpm or maxConcurrent
+// disables that limit.
+func NewLimiter(rpm, burst, maxConcurrent int) *Limiter {
+	l := &Limiter{last: time.Now()}
+	l.SetLimits(rpm, burst, maxConcurrent)
+	return l
+}
+
+// SetLimits replaces the limits, taking the same arguments as NewLimiter.
+// Calls already holding a concurrency slot release it to the limit they
+// acquired it under; the token bucket keeps what it has earned, up to the new
+// burst.
+func (l *Limiter) SetLimits(rpm, burst, maxConcurrent int) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	wasLimited := l.interval > 0
+	l.interval, l.burst = 0, 0
+	if rpm > 0 {
+		if burst <= 0 {
+			burst = rpm
+		}
+		if !wasLimited {
+			l.tokens = float64(burst)
+		}
+		l.interval = time.Minute / time.Duration(rpm)
+		l.burst = float64(burst)
+		l.tokens = min(l.tokens, l.burst)
+	}
+	l.sem = nil
+	if maxConcurrent > 0 {
+		l.sem = make(chan struct{}, maxConcurrent)
+	}
+}
+
+// Acquire blocks until the call may proceed. If the caller's deadline would
+// pass before a token is available the call is rejected up front rather than
+// left waiting. The returned release must be called when the request finishes.
+func (l *Limiter) Acquire(ctx context.Context) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	if err := l.waitToken(ctx); err != nil {
+		return nil, err
+	}
+	l.mu.Lock()
+	sem := l.sem
+	l.mu.Unlock()
+	if sem == nil {
+		return func() {}, nil
+	}
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, nil
+	default:
+	}
+	l.delayed.Add(1)
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, nil
+	case <-ctx.Done():
+		l.rejected.Add(1)
+		return nil, WrapTransportError(ctx.Err())
+	}
+}
+
+func (l *Limiter) waitToken(ctx context.Context) error {
+	l.mu.Lock()
+	if l.interval == 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	l.tokens += float64(now.Sub(l.last)) / float64(l.interval)
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	// Reserve a token now (possibly going negative) so concurrent waiters queue up in order
+	l.tokens--
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens * float64(l.interval))
+	}
+	l.mu.Unlock()
+
+	if wait == 0 {
+		return nil
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+		l.giveBack()
+		l.rejected.Add(1)
+		return ErrRateLimited
+	}
+
+	l.delayed.Add(1)
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.giveBack()
+		l.rejected.Add(1)
+		return WrapTransportError(ctx.Err())
+	}
+}
+
+func (l *Limiter) giveBack() {
+	l.mu.Lock()
+	l.tokens++
+	l.mu.Unlock()
+}
+
+func (l *Limiter) Stats() LimiterStats {
+	if l == nil {
+		return LimiterStats{}
+	}
+	return LimiterStats{Delayed: l.delayed.Load(), Rejected: l.rejected.Load()}
+}
+
+// SetLimiter attaches a limiter shared by all calls through this client.
+func (c *Client) SetLimiter(l *Limiter) {
+	c.limiter = l
+}
+
+func (c *Client) LimiterStats() LimiterStats {
+	return c.limiter.Stats()
+}
+
+// SetLimits changes the limits of the client's limiter; a client without one
+// stays unlimited.
+func (c *Client) SetLimits(rpm, burst, maxConcurrent int) {
+	c.limiter.SetLimits(rpm, burst, maxConcurrent)
+}
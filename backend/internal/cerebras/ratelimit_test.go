@@ -0,0 +1,164 @@
+package cerebras
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLimiterSpacesBurst(t *testing.T) {
+	// 1200 rpm = one request every 50ms once the burst of 2 is spent
+	l := NewLimiter(1200, 2, 0)
+
+	start := time.Now()
+	var mu sync.Mutex
+	var times []time.Duration
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := l.Acquire(context.Background())
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			release()
+			mu.Lock()
+			times = append(times, time.Since(start))
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	var last time.Duration
+	for _, d := range times {
+		if d > last {
+			last = d
+		}
+	}
+	// Three calls beyond the burst need at least ~150ms of spacing
+	if last < 140*time.Millisecond {
+		t.Errorf("expected burst to be spread out, last call after %v", last)
+	}
+	if got := l.Stats().Delayed; got != 3 {
+		t.Errorf("expected 3 delayed calls, got %d", got)
+	}
+}
+
+func TestLimiterRejectsPastDeadline(t *testing.T) {
+	l := NewLimiter(60, 1, 0) // one per second
+	if _, err := l.Acquire(context.Background()); err != nil {
+		t.Fatalf("first call should pass: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := l.Acquire(ctx)
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected ErrRateLimited, got %v", err)
+	}
+	if time.Since(start) > 40*time.Millisecond {
+		t.Error("waiter that can't make its deadline should fail fast, not hang")
+	}
+	if l.Stats().Rejected != 1 {
+		t.Errorf("expected 1 rejected call, got %d", l.Stats().Rejected)
+	}
+}
+
+func TestLimiterConcurrencyCapHonoursContext(t *testing.T) {
+	l := NewLimiter(0, 0, 1)
+	release, err := l.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	if _, err := l.Acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline error while cap is held, got %v", err)
+	}
+}
+
+func TestClientSharesLimiter(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"ok"}}]}`))
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "")
+	c.SetLimiter(NewLimiter(60, 1, 0))
+
+	if _, err := c.Chat(context.Background(), OpenAIChatRequest{Model: "m"}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := c.Chat(ctx, OpenAIChatRequest{Model: "m"}); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected second call to be throttled, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("throttled call should not reach the provider, got %d calls", calls)
+	}
+	if c.LimiterStats().Rejected != 1 {
+		t.Errorf("expected rejection to be counted, got %+v", c.LimiterStats())
+	}
+}
+
+func TestLimiterSetLimits(t *testing.T) {
+	l := NewLimiter(0, 0, 1)
+	release, err := l.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The held slot belongs to the old limit; the new one has room for two
+	l.SetLimits(0, 0, 2)
+	var held []func()
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		r, err := l.Acquire(ctx)
+		cancel()
+		if err != nil {
+			t.Fatalf("call %d under the raised limit: %v", i, err)
+		}
+		held = append(held, r)
+	}
+	release()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := l.Acquire(ctx); err == nil {
+		t.Error("expected a third call to wait for the new limit")
+	}
+	for _, r := range held {
+		r()
+	}
+
+	// Lifting the limits leaves nothing to wait for; setting a rate starts
+	// with a full burst
+	l.SetLimits(0, 0, 0)
+	for i := 0; i < 5; i++ {
+		if _, err := l.Acquire(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.SetLimits(60, 2, 0)
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	for i := 0; i < 2; i++ {
+		if _, err := l.Acquire(ctx); err != nil {
+			t.Fatalf("call %d within the burst: %v", i, err)
+		}
+	}
+	if _, err := l.Acquire(ctx); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("expected the call past the burst rejected, got %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+package cerebras
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSchemaFor(t *testing.T) {
+	type item struct {
+		Name  string   `json:"name"`
+		Score float64  `json:"score"`
+		Tags  []string `json:"tags,omitempty"`
+	}
+	schema := SchemaFor(struct {
+		Items []item `json:"items"`
+	}{})
+
+	items := schema["properties"].(map[string]any)["items"].(map[string]any)
+	if items["type"] != "array" {
+		t.Fatalf("expected array, got %v", items["type"])
+	}
+	elem := items["items"].(map[string]any)
+	required := elem["required"].([]string)
+	if len(required) != 2 || required[0] != "name" || required[1] != "score" {
+		t.Errorf("unexpected required fields: %v", required)
+	}
+	if elem["properties"].(map[string]any)["score"].(map[string]any)["type"] != "number" {
+		t.Error("score should be a number")
+	}
+}
+
+func TestChatSendsResponseFormat(t *testing.T) {
+	var got OpenAIChatRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"choices": []any{map[string]any{"message": map[string]any{"content": `{"ok":true}`}}},
+		})
+	}))
+	defer srv.Close()
+
+	c := newTestClient(srv.URL)
+	resp, err := c.Chat(context.Background(), OpenAIChatRequest{Model: "m", ResponseFormat: JSONSchemaFormat("out", struct{}{})})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.ResponseFormat == nil || got.ResponseFormat.Type != "json_schema" || got.ResponseFormat.JSONSchema.Name != "out" {
+		t.Errorf("response_format not sent: %+v", got.ResponseFormat)
+	}
+	if content, _ := MessageContent(resp); content != `{"ok":true}` {
+		t.Errorf("unexpected content %q", content)
+	}
+}
+
+func TestChatRetriesWithoutResponseFormat(t *testing.T) {
+	var withFormat, withoutFormat int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req OpenAIChatRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if req.ResponseFormat != nil {
+			withFormat++
+			http.Error(w, `{"error":"unsupported parameter: response_format"}`, http.StatusBadRequest)
+			return
+		}
+		withoutFormat++
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"choices": []any{map[string]any{"message": map[string]any{"content": "{}"}}},
+		})
+	}))
+	defer srv.Close()
+
+	c := newTestClient(srv.URL)
+	req := OpenAIChatRequest{Model: "m", ResponseFormat: JSONObjectFormat()}
+	if _, err := c.Chat(context.Background(), req); err != nil {
+		t.Fatalf("expected retry to succeed, got %v", err)
+	}
+	if withFormat != 1 || withoutFormat != 1 {
+		t.Errorf("expected one rejected and one retried call, got %d/%d", withFormat, withoutFormat)
+	}
+	if c.SupportsResponseFormat() {
+		t.Error("client should remember the provider rejected response_format")
+	}
+
+	// Later calls skip the parameter up front
+	if _, err := c.Chat(context.Background(), req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if withFormat != 1 || withoutFormat != 2 {
+		t.Errorf("expected parameter to be stripped, got %d/%d", withFormat, withoutFormat)
+	}
+
+	// Once the rejection is forgotten, the parameter is tried again
+	c.noResponseFormatUntil.Store(time.Now().Add(-time.Second).UnixNano())
+	if _, err := c.Chat(context.Background(), req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if withFormat != 2 || withoutFormat != 3 {
+		t.Errorf("expected response_format tried again, got %d/%d", withFormat, withoutFormat)
+	}
+}
+
+// A bad request that doesn't name response_format, like a context overflow,
+// is the caller's to see; response_format stays on for the next call.
+func TestChatKeepsResponseFormatOnOtherBadRequests(t *testing.T) {
+	var calls, withFormat int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req OpenAIChatRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		calls++
+		if req.ResponseFormat != nil {
+			withFormat++
+		}
+		if calls == 1 {
+			http.Error(w, `{"error":"context length exceeded"}`, http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"choices": []any{map[string]any{"message": map[string]any{"content": "{}"}}},
+		})
+	}))
+	defer srv.Close()
+
+	c := newTestClient(srv.URL)
+	req := OpenAIChatRequest{Model: "m", ResponseFormat: JSONObjectFormat()}
+	if _, err := c.Chat(context.Background(), req); err == nil {
+		t.Fatal("expected the bad request returned")
+	}
+	if calls != 1 || !c.SupportsResponseFormat() {
+		t.Errorf("expected no retry and response_format kept, got %d calls", calls)
+	}
+	if _, err := c.Chat(context.Background(), req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if withFormat != 2 {
+		t.Errorf("expected response_format on both calls, got %d", withFormat)
+	}
+}
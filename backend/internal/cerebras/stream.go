@@ -0,0 +1,120 @@
+package cerebras
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ChatStream sends req with stream enabled, calling onDelta for each content
+// fragment as it arrives. The fragments are reassembled into a regular
+// (non-streaming) response so callers can treat both paths the same.
+func (c *Client) ChatStream(ctx context.Context, req OpenAIChatRequest, onDelta func(string)) (map[string]any, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	if err := checkFit(req); err != nil {
+		return nil, err
+	}
+	req.Stream = true
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+	c.skipRejected(&req)
+	b, _ := json.Marshal(req)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, strings.NewReader(string(b)))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Accept", "text/event-stream")
+
+	release, err := c.limiter.Acquire(ctx)
+	if err != nil {
+		return nil, explainTimeout(ctx, err)
+	}
+	defer release()
+
+	hook := c.hookFor(ctx)
+	start := time.Now()
+	resp, err := c.send(httpReq, hook, req)
+	var out map[string]any
+	if err == nil {
+		out, err = readStream(resp, onDelta)
+	}
+	err = explainTimeout(ctx, err)
+	if hook != nil {
+		if err != nil {
+			hook.OnError(c.errorEvent(req, err, start))
+		} else {
+			hook.OnResponse(c.responseEvent(req, out, start))
+		}
+	}
+	return out, err
+}
+
+func readStream(resp *http.Response, onDelta func(string)) (map[string]any, error) {
+	defer resp.Body.Close()
+
+	var content strings.Builder
+	var model, finish string
+	var usage map[string]any
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			break
+		}
+		var chunk struct {
+			Model   string         `json:"model"`
+			Usage   map[string]any `json:"usage"`
+			Choices []struct {
+				Delta struct {
+					Content string `json:"content"`
+				} `json:"delta"`
+				FinishReason string `json:"finish_reason"`
+			} `json:"choices"`
+		}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			continue
+		}
+		if chunk.Model != "" {
+			model = chunk.Model
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+		for _, ch := range chunk.Choices {
+			if ch.Delta.Content != "" {
+				content.WriteString(ch.Delta.Content)
+				if onDelta != nil {
+					onDelta(ch.Delta.Content)
+				}
+			}
+			if ch.FinishReason != "" {
+				finish = ch.FinishReason
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	out := map[string]any{
+		"model": model,
+		"choices": []any{map[string]any{
+			"message":       map[string]any{"role": "assistant", "content": content.String()},
+			"finish_reason": finish,
+		}},
+	}
+	if usage != nil {
+		out["usage"] = usage
+	}
+	return out, nil
+}
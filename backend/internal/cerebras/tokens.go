@@ -0,0 +1,102 @@
+package cerebras
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrContextWindow = errors.New("cerebras: prompt exceeds model context window")
+
+// countTokens is the chars/4 heuristic by default; building with the tiktoken
+// tag swaps in a real BPE tokenizer (see tokens_tiktoken.go).
+var countTokens = func(s string) int {
+	return (len(s) + 3) / 4
+}
+
+// Chat formatting adds a few tokens per message (role markers, separators) and
+// a few to prime the assistant reply.
+const (
+	tokensPerMessage = 4
+	tokensPerReply   = 3
+)
+
+// Context windows by model. Keys are matched as prefixes so tagged names like
+// "llama3.1:latest" resolve too; longer keys win.
+var contextWindows = map[string]int{
+	"llama3.1-8b":   8192,
+	"llama3.1-70b":  8192,
+	"llama-3.3-70b": 65536,
+	"llama3.1":      131072,
+	"qwen2.5":       32768,
+	"gpt-4o":        128000,
+	"gpt-4o-mini":   128000,
+	"gpt-4.1":       1047576,
+	"gpt-3.5-turbo": 16385,
+}
+
+func EstimateTokens(s string) int {
+	return countTokens(s)
+}
+
+// EstimatePromptTokens estimates the prompt size of a chat request.
+func EstimatePromptTokens(req OpenAIChatRequest) int {
+	n := tokensPerReply
+	for _, m := range req.Messages {
+		n += tokensPerMessage + countTokens(m.Role) + countTokens(fmt.Sprint(m.Content))
+	}
+	return n
+}
+
+// ContextWindow returns the context size for model, if known.
+func ContextWindow(model string) (int, bool) {
+	best, window := "", 0
+	for prefix, w := range contextWindows {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(best) {
+			best, window = prefix, w
+		}
+	}
+	return window, best != ""
+}
+
+// Fit describes how a request's estimated prompt compares to the model window.
+type Fit struct {
+	PromptTokens int
+	MaxTokens    int
+	Window       int
+	Known        bool
+}
+
+// Available is the prompt budget left once the completion allowance is reserved.
+func (f Fit) Available() int {
+	return f.Window - f.MaxTokens
+}
+
+// Fits reports whether the prompt fits; unknown models always fit.
+func (f Fit) Fits() bool {
+	return !f.Known || f.PromptTokens <= f.Available()
+}
+
+// obviouslyExceeds allows for estimator error before refusing a request outright.
+func (f Fit) obviouslyExceeds() bool {
+	return f.Known && float64(f.PromptTokens)*0.9 > float64(f.Available())
+}
+
+func EstimateFit(req OpenAIChatRequest) Fit {
+	window, known := ContextWindow(req.Model)
+	return Fit{
+		PromptTokens: EstimatePromptTokens(req),
+		MaxTokens:    req.MaxTokens,
+		Window:       window,
+		Known:        known,
+	}
+}
+
+func checkFit(req OpenAIChatRequest) error {
+	fit := EstimateFit(req)
+	if fit.obviouslyExceeds() {
+		return fmt.Errorf("%w: ~%d prompt tokens, %d available for %s (window %d, max_tokens %d)",
+			ErrContextWindow, fit.PromptTokens, fit.Available(), req.Model, fit.Window, fit.MaxTokens)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package cerebras
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"strings"
+	"testing"
+)
+
+type usageFixture struct {
+	Name         string        `json:"name"`
+	PromptTokens int           `json:"prompt_tokens"`
+	Messages     []ChatMessage `json:"messages"`
+}
+
+func loadUsageFixtures(t *testing.T) []usageFixture {
+	t.Helper()
+	b, err := os.ReadFile("testdata/usage_fixtures.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var f struct {
+		Cases []usageFixture `json:"cases"`
+	}
+	if err := json.Unmarshal(b, &f); err != nil {
+		t.Fatal(err)
+	}
+	return f.Cases
+}
+
+func TestEstimatePromptTokensWithinTolerance(t *testing.T) {
+	for _, fx := range loadUsageFixtures(t) {
+		est := EstimatePromptTokens(OpenAIChatRequest{Messages: fx.Messages})
+		relErr := math.Abs(float64(est-fx.PromptTokens)) / float64(fx.PromptTokens)
+		if relErr > 0.35 {
+			t.Errorf("%s: estimate %d vs recorded %d (%.0f%% off)", fx.Name, est, fx.PromptTokens, relErr*100)
+		}
+	}
+}
+
+func TestContextWindowPrefixMatch(t *testing.T) {
+	if w, ok := ContextWindow("llama3.1:latest"); !ok || w != 131072 {
+		t.Errorf("expected ollama tag to resolve, got %d %v", w, ok)
+	}
+	if w, _ := ContextWindow("llama3.1-8b"); w != 8192 {
+		t.Errorf("longest prefix should win, got %d", w)
+	}
+	if _, ok := ContextWindow("mystery-model"); ok {
+		t.Error("unknown model should not resolve")
+	}
+}
+
+func TestEstimateFitAndRefusal(t *testing.T) {
+	small := OpenAIChatRequest{Model: "llama3.1-8b", Messages: []ChatMessage{{Role: "user", Content: "hi"}}, MaxTokens: 512}
+	if fit := EstimateFit(small); !fit.Known || !fit.Fits() || fit.Available() != 8192-512 {
+		t.Errorf("unexpected fit %+v", fit)
+	}
+
+	huge := OpenAIChatRequest{
+		Model:     "llama3.1-8b",
+		Messages:  []ChatMessage{{Role: "user", Content: strings.Repeat("variant 42; ", 6000)}},
+		MaxTokens: 1024,
+	}
+	if EstimateFit(huge).Fits() {
+		t.Fatal("expected oversized prompt not to fit")
+	}
+	c := NewClient("http://127.0.0.1:0", "")
+	if _, err := c.Chat(context.Background(), huge); !errors.Is(err, ErrContextWindow) {
+		t.Fatalf("expected ErrContextWindow, got %v", err)
+	}
+
+	// Unknown models are never refused
+	huge.Model = "mystery-model"
+	if !EstimateFit(huge).Fits() {
+		t.Error("unknown model should be assumed to fit")
+	}
+}
@@ -0,0 +1,21 @@
+//go:build tiktoken
+
+package cerebras
+
+import (
+	"github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+)
+
+// Real BPE counts using cl100k_base with the embedded (offline) vocabulary.
+// Llama's tokenizer differs, but this is far closer than chars/4.
+func init() {
+	tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader())
+	enc, err := tiktoken.GetEncoding("cl100k_base")
+	if err != nil {
+		return
+	}
+	countTokens = func(s string) int {
+		return len(enc.Encode(s, nil, nil))
+	}
+}
@@ -0,0 +1,115 @@
+package cerebras
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ToolHandler executes a tool call requested by the model. The returned value is
+// JSON-encoded into the tool result message; a returned error is reported back
+// to the model as a tool error instead of aborting the conversation.
+type ToolHandler func(ctx context.Context, args map[string]any) (any, error)
+
+const defaultMaxToolIterations = 8
+
+var ErrToolIterations = errors.New("cerebras: tool call iteration limit reached")
+
+// RunTools drives a tool-calling conversation: it sends req, executes any
+// tool_calls in the reply with the matching handler, appends the results and
+// calls the API again until the model answers with plain content.
+func (c *Client) RunTools(ctx context.Context, req OpenAIChatRequest, handlers map[string]ToolHandler) (map[string]any, error) {
+	maxIters := c.maxToolIters
+	if maxIters <= 0 {
+		maxIters = defaultMaxToolIterations
+	}
+
+	messages := append([]ChatMessage(nil), req.Messages...)
+	for i := 0; i < maxIters; i++ {
+		req.Messages = messages
+		resp, err := c.Chat(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+
+		msg, ok := firstMessage(resp)
+		if !ok {
+			return nil, fmt.Errorf("cerebras: response has no message")
+		}
+		calls := parseToolCalls(msg)
+		if len(calls) == 0 {
+			return resp, nil
+		}
+
+		messages = append(messages, ChatMessage{Role: "assistant", Content: msg["content"], ToolCalls: calls})
+		for _, call := range calls {
+			messages = append(messages, ChatMessage{
+				Role:       "tool",
+				Name:       call.Function.Name,
+				ToolCallID: call.ID,
+				Content:    c.invokeTool(ctx, call, handlers),
+			})
+		}
+	}
+	return nil, ErrToolIterations
+}
+
+func (c *Client) invokeTool(ctx context.Context, call ToolCall, handlers map[string]ToolHandler) string {
+	handler, ok := handlers[call.Function.Name]
+	if !ok {
+		return toolError(fmt.Errorf("unknown tool %q", call.Function.Name))
+	}
+
+	args := map[string]any{}
+	if call.Function.Arguments != "" {
+		if err := json.Unmarshal([]byte(call.Function.Arguments), &args); err != nil {
+			return toolError(fmt.Errorf("invalid arguments: %w", err))
+		}
+	}
+
+	out, err := handler(ctx, args)
+	if err != nil {
+		return toolError(err)
+	}
+	b, err := json.Marshal(out)
+	if err != nil {
+		return toolError(err)
+	}
+	return string(b)
+}
+
+func toolError(err error) string {
+	b, _ := json.Marshal(map[string]string{"error": err.Error()})
+	return string(b)
+}
+
+func firstMessage(resp map[string]any) (map[string]any, bool) {
+	choices, ok := resp["choices"].([]interface{})
+	if !ok || len(choices) == 0 {
+		return nil, false
+	}
+	choice, ok := choices[0].(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	message, ok := choice["message"].(map[string]interface{})
+	return message, ok
+}
+
+func parseToolCalls(message map[string]any) []ToolCall {
+	raw, ok := message["tool_calls"]
+	if !ok || raw == nil {
+		return nil
+	}
+	// Round-trip through JSON rather than walking the generic map by hand
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return nil
+	}
+	var calls []ToolCall
+	if err := json.Unmarshal(b, &calls); err != nil {
+		return nil
+	}
+	return calls
+}
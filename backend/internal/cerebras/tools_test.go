@@ -0,0 +1,132 @@
+package cerebras
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestClient(url string) *Client {
+	return &Client{
+		http:         &http.Client{Timeout: 5 * time.Second},
+		url:          url,
+		maxToolIters: defaultMaxToolIterations,
+	}
+}
+
+func toolCallResponse(id, name, args string) map[string]any {
+	return map[string]any{
+		"choices": []any{map[string]any{
+			"message": map[string]any{
+				"role":    "assistant",
+				"content": nil,
+				"tool_calls": []any{map[string]any{
+					"id":       id,
+					"type":     "function",
+					"function": map[string]any{"name": name, "arguments": args},
+				}},
+			},
+		}},
+	}
+}
+
+func TestRunToolsTwoTurn(t *testing.T) {
+	var calls int
+	var toolMsg ChatMessage
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		var req OpenAIChatRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if calls == 1 {
+			_ = json.NewEncoder(w).Encode(toolCallResponse("call-1", "validate_variant", `{"arrival_rate": 10}`))
+			return
+		}
+		toolMsg = req.Messages[len(req.Messages)-1]
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"choices": []any{map[string]any{"message": map[string]any{"role": "assistant", "content": "done"}}},
+		})
+	}))
+	defer srv.Close()
+
+	var gotArgs map[string]any
+	handlers := map[string]ToolHandler{
+		"validate_variant": func(ctx context.Context, args map[string]any) (any, error) {
+			gotArgs = args
+			return map[string]bool{"valid": true}, nil
+		},
+	}
+
+	resp, err := newTestClient(srv.URL).RunTools(context.Background(), OpenAIChatRequest{Model: "m"}, handlers)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 API calls, got %d", calls)
+	}
+	if gotArgs["arrival_rate"] != 10.0 {
+		t.Errorf("handler got wrong args: %v", gotArgs)
+	}
+	if toolMsg.Role != "tool" || toolMsg.ToolCallID != "call-1" || toolMsg.Content != `{"valid":true}` {
+		t.Errorf("unexpected tool message: %+v", toolMsg)
+	}
+	if msg, _ := firstMessage(resp); msg["content"] != "done" {
+		t.Errorf("expected final content, got %v", msg)
+	}
+}
+
+func TestRunToolsHandlerError(t *testing.T) {
+	var toolMsg ChatMessage
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		var req OpenAIChatRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if calls == 1 {
+			_ = json.NewEncoder(w).Encode(toolCallResponse("call-1", "lookup_historical_run", `{}`))
+			return
+		}
+		toolMsg = req.Messages[len(req.Messages)-1]
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"choices": []any{map[string]any{"message": map[string]any{"role": "assistant", "content": "ok"}}},
+		})
+	}))
+	defer srv.Close()
+
+	handlers := map[string]ToolHandler{
+		"lookup_historical_run": func(ctx context.Context, args map[string]any) (any, error) {
+			return nil, errors.New("not found")
+		},
+	}
+	if _, err := newTestClient(srv.URL).RunTools(context.Background(), OpenAIChatRequest{Model: "m"}, handlers); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if toolMsg.Content != `{"error":"not found"}` {
+		t.Errorf("expected tool error fed back, got %v", toolMsg.Content)
+	}
+}
+
+func TestRunToolsIterationCap(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		_ = json.NewEncoder(w).Encode(toolCallResponse("call", "loop", `{}`))
+	}))
+	defer srv.Close()
+
+	c := newTestClient(srv.URL)
+	c.maxToolIters = 3
+	handlers := map[string]ToolHandler{
+		"loop": func(ctx context.Context, args map[string]any) (any, error) { return "again", nil },
+	}
+	_, err := c.RunTools(context.Background(), OpenAIChatRequest{Model: "m"}, handlers)
+	if !errors.Is(err, ErrToolIterations) {
+		t.Fatalf("expected ErrToolIterations, got %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("expected 3 API calls, got %d", calls)
+	}
+}
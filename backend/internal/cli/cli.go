@@ -0,0 +1,613 @@
+// Package cli is simstack-cli: submit runs, follow their events, and read
+// results, simulators and exports from a backend over its HTTP API.
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"simstack/internal/types"
+)
+
+// Exit codes.
+const (
+	ExitOK = 0
+	// The run failed, or the backend refused the request
+	ExitFailed = 1
+	ExitUsage  = 2
+)
+
+const usage = `usage: simstack-cli [--addr URL] <command> [flags]
+
+commands:
+  run         submit a run (--goal or --file), optionally --follow its events
+  status      show a run's status
+  results     print a run's results as a table, --csv or --json
+  cancel      stop an in-flight run
+  export      write a compose, .env or parameter file for a run's winner
+              (--run) or for a goal and parameters
+  simulators  show simulator latency, errors and breaker state
+
+The backend address comes from --addr or SIMSTACK_ADDR (default
+http://localhost:8080); SIMSTACK_API_KEY is sent as a bearer token.
+`
+
+// client talks to one backend.
+type client struct {
+	base   *url.URL
+	apiKey string
+	http   *http.Client
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// Main runs the CLI with args (without the program name) and returns the
+// exit code. getenv supplies SIMSTACK_ADDR and SIMSTACK_API_KEY.
+func Main(ctx context.Context, args []string, stdout, stderr io.Writer, getenv func(string) string) int {
+	fs := flag.NewFlagSet("simstack-cli", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() { fmt.Fprint(stderr, usage) }
+	addr := fs.String("addr", getenv("SIMSTACK_ADDR"), "backend URL")
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return ExitUsage
+	}
+	base, err := baseURL(*addr)
+	if err != nil {
+		fmt.Fprintf(stderr, "simstack-cli: %v\n", err)
+		return ExitUsage
+	}
+	c := &client{base: base, apiKey: getenv("SIMSTACK_API_KEY"), http: &http.Client{}, stdout: stdout, stderr: stderr}
+
+	commands := map[string]func(context.Context, []string) int{
+		"run":        c.run,
+		"status":     c.status,
+		"results":    c.results,
+		"cancel":     c.cancel,
+		"export":     c.export,
+		"simulators": c.simulators,
+	}
+	cmd, ok := commands[fs.Arg(0)]
+	if !ok {
+		fmt.Fprintf(stderr, "simstack-cli: unknown command %q\n\n%s", fs.Arg(0), usage)
+		return ExitUsage
+	}
+	return cmd(ctx, fs.Args()[1:])
+}
+
+// baseURL accepts a URL or a listen address like ":8080" or "host:8080".
+func baseURL(addr string) (*url.URL, error) {
+	switch {
+	case addr == "":
+		addr = "http://localhost:8080"
+	case strings.HasPrefix(addr, ":"):
+		addr = "http://localhost" + addr
+	case !strings.Contains(addr, "://"):
+		addr = "http://" + addr
+	}
+	u, err := url.Parse(addr)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("backend address %q is not an http(s) URL", addr)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	return u, nil
+}
+
+func (c *client) url(path string) string {
+	u := *c.base
+	u.Path += path
+	return u.String()
+}
+
+// do sends a request and decodes a JSON answer into out, when out is set.
+// Non-2xx answers become errors carrying the backend's message.
+func (c *client) do(ctx context.Context, method, path string, body any, out any) error {
+	resp, err := c.send(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// yamlBody is a request body sent as the YAML it is, rather than encoded
+// as JSON.
+type yamlBody []byte
+
+func (c *client) send(ctx context.Context, method, path string, body any) (*http.Response, error) {
+	var r io.Reader
+	contentType := "application/json"
+	switch b := body.(type) {
+	case nil:
+	case yamlBody:
+		r, contentType = bytes.NewReader(b), "application/x-yaml"
+	default:
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.url(path), r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	c.authorize(req.Header)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, &httpError{status: resp.StatusCode, msg: errorMessage(msg)}
+	}
+	return resp, nil
+}
+
+func (c *client) authorize(h http.Header) {
+	if c.apiKey != "" {
+		h.Set("Authorization", "Bearer "+c.apiKey)
+	}
+}
+
+type httpError struct {
+	status int
+	msg    string
+}
+
+func (e *httpError) Error() string {
+	return fmt.Sprintf("backend answered %d: %s", e.status, e.msg)
+}
+
+// errorMessage returns the message of an error response: the envelope's
+// code and message, or the body as sent by older backends.
+func errorMessage(body []byte) string {
+	var envelope struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &envelope) == nil && envelope.Error.Message != "" {
+		return envelope.Error.Message + " (" + envelope.Error.Code + ")"
+	}
+	return strings.TrimSpace(string(body))
+}
+
+// fail prints err and returns the exit code for it.
+func (c *client) fail(err error) int {
+	fmt.Fprintf(c.stderr, "simstack-cli: %v\n", err)
+	return ExitFailed
+}
+
+// params collects repeated --param key=value flags. Values that parse as
+// JSON (numbers, booleans, arrays) keep their type; the rest are strings.
+type params map[string]any
+
+func (p params) String() string { return fmt.Sprint(map[string]any(p)) }
+
+func (p params) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok || k == "" {
+		return fmt.Errorf("expected key=value, got %q", s)
+	}
+	var parsed any
+	if json.Unmarshal([]byte(v), &parsed) == nil {
+		p[k] = parsed
+	} else {
+		p[k] = v
+	}
+	return nil
+}
+
+func (c *client) run(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	file := fs.String("file", "", "RunRequest as a JSON or YAML file; flags override its fields")
+	fs.StringVar(file, "f", "", "shorthand for --file")
+	goal := fs.String("goal", "", "what to optimize")
+	model := fs.String("model", "", "model override")
+	offline := fs.Bool("offline", false, "never contact the LLM")
+	reproducible := fs.Bool("reproducible", false, "pin temperatures and seed the LLM")
+	noCache := fs.Bool("no-cache", false, "simulate every variant afresh, bypassing the response cache")
+	ordered := fs.Bool("ordered", false, "send result events in variant order")
+	follow := fs.Bool("follow", false, "tail the run's events until it finishes")
+	asJSON := fs.Bool("json", false, "with --follow, print events as JSON lines")
+	ps := params{}
+	fs.Var(ps, "param", "parameter key=value (repeatable)")
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+
+	req := map[string]any{}
+	// A YAML file goes to the backend as YAML, so its errors point into it
+	var source yamlBody
+	if *file != "" {
+		var err error
+		if req, source, err = readRequest(*file); err != nil {
+			fmt.Fprintf(c.stderr, "simstack-cli: %v\n", err)
+			return ExitUsage
+		}
+	}
+	if *goal != "" {
+		req["goal"] = *goal
+	}
+	if *model != "" {
+		req["model"] = *model
+	}
+	if *offline {
+		req["offline"] = true
+	}
+	if *reproducible {
+		req["reproducible"] = true
+	}
+	if *noCache {
+		req["no_cache"] = true
+	}
+	if *ordered {
+		req["ordered_results"] = true
+	}
+	if len(ps) > 0 {
+		merged, _ := req["parameters"].(map[string]any)
+		if merged == nil {
+			merged = map[string]any{}
+		}
+		for k, v := range ps {
+			merged[k] = v
+		}
+		req["parameters"] = merged
+	}
+	if g, _ := req["goal"].(string); g == "" {
+		fmt.Fprintln(c.stderr, "simstack-cli: run needs --goal or a --file with a goal")
+		return ExitUsage
+	}
+	var body any = req
+	if source != nil {
+		body = source
+		if *goal != "" || *model != "" || *offline || *reproducible || *noCache || *ordered || len(ps) > 0 {
+			// Flags override the file's fields: send the merged request
+			encoded, err := yaml.Marshal(req)
+			if err != nil {
+				return c.fail(err)
+			}
+			body = yamlBody(encoded)
+		}
+	}
+
+	// Subscribe before submitting so the first events are not missed
+	var events *eventStream
+	if *follow {
+		var err error
+		if events, err = c.subscribe(ctx); err != nil {
+			return c.fail(fmt.Errorf("connect to events: %w", err))
+		}
+		defer events.Close()
+	}
+	var started struct {
+		RunID    string   `json:"run_id"`
+		Warnings []string `json:"warnings"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/api/run", body, &started); err != nil {
+		return c.fail(err)
+	}
+	for _, w := range started.Warnings {
+		fmt.Fprintf(c.stderr, "warning: %s\n", w)
+	}
+	if !*follow || !*asJSON {
+		fmt.Fprintln(c.stdout, started.RunID)
+	}
+	if !*follow {
+		return ExitOK
+	}
+	return c.tail(events, started.RunID, *asJSON)
+}
+
+// readRequest loads a RunRequest file, YAML or JSON (a YAML subset), as the
+// generic map the backend's schema validates. For a .yaml or .yml file it
+// also returns the file itself.
+func readRequest(path string) (map[string]any, yamlBody, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var req map[string]any
+	if err := yaml.Unmarshal(b, &req); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if req == nil {
+		req = map[string]any{}
+	}
+	// Typed decode catches misspelled types before the backend does
+	var typed types.RunRequest
+	if b, err := json.Marshal(req); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	} else if err := json.Unmarshal(b, &typed); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return req, b, nil
+	}
+	return req, nil, nil
+}
+
+// tail prints events until the run is done, fails or is cancelled. A run
+// fails on an error event, or when no variant produced metrics; a cancelled
+// run counts as failed too.
+func (c *client) tail(events *eventStream, runID string, asJSON bool) int {
+	results, succeeded := 0, 0
+	for {
+		raw, ev, err := events.Next()
+		if err != nil {
+			return c.fail(fmt.Errorf("event stream: %w", err))
+		}
+		// Events of other runs that say whose they are are skipped
+		if id := eventRunID(ev); id != "" && runID != "" && id != runID {
+			continue
+		}
+		if asJSON {
+			// One event per line, however the backend formatted it
+			var line bytes.Buffer
+			if json.Compact(&line, raw) != nil {
+				line.Reset()
+				line.Write(raw)
+			}
+			fmt.Fprintln(c.stdout, line.String())
+		} else {
+			fmt.Fprintln(c.stdout, render(ev))
+		}
+		// An error or cancellation that doesn't say which run it is about may
+		// be another run's, so only the followed run's end the tail
+		ours := runID == "" || eventRunID(ev) == runID
+		switch p := ev.Payload.(type) {
+		case types.ResultEvent:
+			if ev.Type == types.EventSimComplete {
+				results++
+				if p.Status != types.ResultFailed {
+					succeeded++
+				}
+			}
+		case types.ErrorEvent:
+			if ours {
+				return ExitFailed
+			}
+		case types.CancelledEvent:
+			if ours {
+				fmt.Fprintln(c.stderr, "simstack-cli: the run was cancelled")
+				return ExitFailed
+			}
+		case types.DoneEvent:
+			if results > 0 && succeeded == 0 {
+				fmt.Fprintln(c.stderr, "simstack-cli: every variant failed")
+				return ExitFailed
+			}
+			return ExitOK
+		}
+	}
+}
+
+// eventRunID is the run an event belongs to, where it says.
+func eventRunID(ev types.WSEvent) string {
+	if ev.RunID != "" {
+		return ev.RunID
+	}
+	// Older backends only name the run in these payloads
+	switch p := ev.Payload.(type) {
+	case types.DoneEvent:
+		return p.RunID
+	case types.ManifestEvent:
+		return p.RunID
+	case types.CancelledEvent:
+		return p.RunID
+	}
+	return ""
+}
+
+func (c *client) status(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	asJSON := fs.Bool("json", false, "print the run record as JSON")
+	id, ok := parseWithID(fs, args)
+	if !ok {
+		return ExitUsage
+	}
+	var run types.RunRecord
+	if err := c.do(ctx, http.MethodGet, "/api/runs/"+url.PathEscape(id), nil, &run); err != nil {
+		return c.fail(err)
+	}
+	if *asJSON {
+		return c.printJSON(run)
+	}
+	fmt.Fprintf(c.stdout, "run:      %s\nstatus:   %s\ngoal:     %s\nstarted:  %s\n", run.ID, run.Status, run.Goal, run.StartedAt.Format(time.RFC3339))
+	if run.FinishedAt != nil {
+		fmt.Fprintf(c.stdout, "finished: %s (%s)\n", run.FinishedAt.Format(time.RFC3339), run.FinishedAt.Sub(run.StartedAt).Round(time.Millisecond))
+	}
+	if run.PlanID != "" {
+		fmt.Fprintf(c.stdout, "plan:     %s, %d results\n", run.PlanID, len(run.Results))
+	}
+	if run.Winner != "" {
+		fmt.Fprintf(c.stdout, "winner:   %s\n", run.Winner)
+	}
+	return ExitOK
+}
+
+func (c *client) results(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("results", flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	asCSV := fs.Bool("csv", false, "print CSV")
+	asJSON := fs.Bool("json", false, "print the results as JSON")
+	id, ok := parseWithID(fs, args)
+	if !ok {
+		return ExitUsage
+	}
+	var run types.RunRecord
+	if err := c.do(ctx, http.MethodGet, "/api/runs/"+url.PathEscape(id), nil, &run); err != nil {
+		return c.fail(err)
+	}
+	switch {
+	case *asJSON:
+		return c.printJSON(run.Results)
+	case *asCSV:
+		if err := writeResultsCSV(c.stdout, run.Results); err != nil {
+			return c.fail(err)
+		}
+	default:
+		writeResultsTable(c.stdout, run.Results, run.Winner)
+	}
+	return ExitOK
+}
+
+func (c *client) cancel(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("cancel", flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	id, ok := parseWithID(fs, args)
+	if !ok {
+		return ExitUsage
+	}
+	if err := c.do(ctx, http.MethodPost, "/api/run/"+url.PathEscape(id)+"/cancel", nil, nil); err != nil {
+		return c.fail(err)
+	}
+	fmt.Fprintf(c.stdout, "cancelling %s\n", id)
+	return ExitOK
+}
+
+func (c *client) export(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	goal := fs.String("goal", "", "goal the export is for")
+	runID := fs.String("run", "", "export this run's winning variant")
+	variant := fs.String("variant", "", "with --run, export this variant instead of the winner")
+	format := fs.String("format", "compose", "export format: compose, env, json-params or script")
+	backend := fs.Bool("backend", false, "with compose, also run the backend against the simulators")
+	profiles := fs.Bool("profiles", false, "with --run, export the Pareto front as compose profiles, one per variant")
+	top := fs.Int("top", 0, "with --run, export the best N variants as compose profiles")
+	tolerance := fs.Float64("tolerance", 0, "with script, the relative difference a headline metric may show (default 0.05)")
+	out := fs.String("out", "", "file to write (default: the name the backend suggests; - for stdout)")
+	ps := params{}
+	fs.Var(ps, "param", "parameter key=value (repeatable)")
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+	switch *format {
+	case "compose", "env", "json-params", "script":
+	default:
+		fmt.Fprintf(c.stderr, "simstack-cli: unknown export format %q (compose, env, json-params or script)\n", *format)
+		return ExitUsage
+	}
+	resp, err := c.send(ctx, http.MethodPost, "/api/export", types.ExportRequest{Goal: *goal, Parameters: ps, Format: *format, RunID: *runID, VariantID: *variant, IncludeBackend: *backend, Profiles: *profiles, TopK: *top, Tolerance: *tolerance})
+	if err != nil {
+		return c.fail(err)
+	}
+	defer resp.Body.Close()
+
+	path := *out
+	if path == "" {
+		path = map[string]string{"compose": "docker-compose.yml", "env": "simstack.env", "json-params": "simstack.params.json", "script": "reproduce.sh"}[*format]
+		if _, p, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil && p["filename"] != "" {
+			path = filepath.Base(p["filename"])
+		}
+	}
+	if path == "-" {
+		_, err = io.Copy(c.stdout, resp.Body)
+	} else {
+		mode := os.FileMode(0o644)
+		if *format == "script" {
+			mode = 0o755
+		}
+		err = writeFile(path, resp.Body, mode)
+		if err == nil {
+			fmt.Fprintf(c.stdout, "wrote %s\n", path)
+		}
+	}
+	if err != nil {
+		return c.fail(err)
+	}
+	return ExitOK
+}
+
+func writeFile(path string, r io.Reader, mode os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (c *client) simulators(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("simulators", flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	asJSON := fs.Bool("json", false, "print the stats as JSON")
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+	var body struct {
+		Window     string                 `json:"window"`
+		Simulators []types.SimulatorStats `json:"simulators"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/api/simulators", nil, &body); err != nil {
+		return c.fail(err)
+	}
+	if *asJSON {
+		return c.printJSON(body)
+	}
+	writeSimulatorsTable(c.stdout, body.Simulators)
+	return ExitOK
+}
+
+// parseWithID parses flags around the single run ID argument.
+func parseWithID(fs *flag.FlagSet, args []string) (string, bool) {
+	// Accept the ID before or after the flags
+	var id string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		id, args = args[0], args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		return "", false
+	}
+	if id == "" && fs.NArg() == 1 {
+		id = fs.Arg(0)
+	} else if fs.NArg() != 0 {
+		id = ""
+	}
+	if id == "" {
+		fmt.Fprintf(fs.Output(), "usage: simstack-cli %s <run-id> [flags]\n", fs.Name())
+		return "", false
+	}
+	return id, true
+}
+
+func (c *client) printJSON(v any) int {
+	enc := json.NewEncoder(c.stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return c.fail(err)
+	}
+	return ExitOK
+}
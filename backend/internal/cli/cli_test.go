@@ -0,0 +1,311 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"gopkg.in/yaml.v3"
+
+	"simstack/internal/types"
+)
+
+// fixture reads one of the backend's golden event files.
+func fixture(t *testing.T, typ string) []byte {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("..", "types", "testdata", "events", typ+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// fakeBackend answers the API from canned data and, once a run is
+// submitted, plays script's event fixtures to the connected /ws client.
+// Script entries starting with "{" are sent as they are.
+type fakeBackend struct {
+	*httptest.Server
+	conns chan *websocket.Conn
+	// Last run request body, as sent and decoded, its Content-Type, and the
+	// Authorization header
+	body        string
+	request     map[string]any
+	contentType string
+	auth        string
+}
+
+func newFakeBackend(t *testing.T, script ...string) *fakeBackend {
+	f := &fakeBackend{conns: make(chan *websocket.Conn, 1)}
+	events := make([][]byte, len(script))
+	for i, typ := range script {
+		if strings.HasPrefix(typ, "{") {
+			events[i] = []byte(typ)
+			continue
+		}
+		events[i] = fixture(t, typ)
+	}
+	finished := time.Date(2026, 1, 2, 3, 5, 0, 0, time.UTC)
+	run := types.RunRecord{
+		ID: "run-1", Goal: "reduce wait", Status: "completed", StartedAt: finished.Add(-time.Minute), FinishedAt: &finished,
+		PlanID: "plan-1", Winner: "plan-1-v2",
+		Results: []types.SimulationResult{
+			{VariantID: "plan-1-v1", Tool: "composite", Status: types.ResultComplete, DurationMs: 1500, Metrics: map[string]float64{"queue_avg_wait_time_min": 4.2, "cost": 10}},
+			{VariantID: "plan-1-v2", Tool: "composite", Status: types.ResultPartial, DurationMs: 900, Metrics: map[string]float64{"queue_avg_wait_time_min": 2.5},
+				Timing: &types.VariantTiming{QueueMs: 3, Calls: []types.ToolTiming{{Tool: "queue", DurationMs: 850, Attempts: 1}, {Tool: "traffic", DurationMs: 40, Attempts: 1, Failed: true}}, WallMs: 903}},
+		},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		f.auth = r.Header.Get("Authorization")
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		f.conns <- conn
+	})
+	mux.HandleFunc("POST /api/run", func(w http.ResponseWriter, r *http.Request) {
+		f.auth = r.Header.Get("Authorization")
+		f.contentType = r.Header.Get("Content-Type")
+		b, _ := io.ReadAll(r.Body)
+		f.body = string(b)
+		// YAML decodes to JSON's types by way of JSON
+		var v any
+		if yaml.Unmarshal(b, &v) == nil {
+			b, _ = json.Marshal(v)
+		}
+		f.request = nil
+		_ = json.Unmarshal(b, &f.request)
+		_ = json.NewEncoder(w).Encode(map[string]any{"status": "started", "run_id": "run-1"})
+		go func() {
+			select {
+			case conn := <-f.conns:
+				for _, ev := range events {
+					_ = conn.WriteMessage(websocket.TextMessage, ev)
+				}
+			case <-time.After(5 * time.Second):
+			}
+		}()
+	})
+	mux.HandleFunc("GET /api/runs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("id") != "run-1" {
+			http.Error(w, "run not found", http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(run)
+	})
+	mux.HandleFunc("POST /api/run/{id}/cancel", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+		io.WriteString(w, `{"error": {"code": "run_finished", "message": "run already finished"}}`)
+	})
+	mux.HandleFunc("POST /api/export", func(w http.ResponseWriter, r *http.Request) {
+		var req types.ExportRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if req.Format == "env" {
+			w.Header().Set("Content-Disposition", "attachment; filename=simstack-"+req.RunID+".env")
+			io.WriteString(w, "# run: "+req.RunID+"\n")
+			return
+		}
+		w.Header().Set("Content-Disposition", "attachment; filename=docker-compose-simstack.yml")
+		io.WriteString(w, "services: {} # "+req.Goal)
+		if req.IncludeBackend {
+			io.WriteString(w, " with backend")
+		}
+	})
+	mux.HandleFunc("GET /api/simulators", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"window": types.StatsSinceStart, "simulators": []types.SimulatorStats{
+			{Tool: "queue", Calls: 10, Errors: 1, ErrorRate: 0.1, P50Ms: 12, P95Ms: 40, P99Ms: 55, Breaker: types.BreakerClosed},
+		}})
+	})
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+// cli runs the CLI against the fake backend.
+func (f *fakeBackend) cli(t *testing.T, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	env := map[string]string{"SIMSTACK_ADDR": f.URL, "SIMSTACK_API_KEY": "secret"}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	code := Main(ctx, args, &stdout, &stderr, func(k string) string { return env[k] })
+	return code, stdout.String(), stderr.String()
+}
+
+func TestRunFollow(t *testing.T) {
+	f := newFakeBackend(t, "plan", "sim_start", "sim_complete", "result", "analysis", "manifest", "done")
+	code, out, errOut := f.cli(t, "run", "--goal", "reduce wait", "--param", "staff=20", "--param", "shift=night", "--offline", "--follow")
+	if code != ExitOK {
+		t.Fatalf("expected success, got %d: %s", code, errOut)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 8 || lines[0] != "run-1" {
+		t.Fatalf("expected the run ID and seven events, got %q", out)
+	}
+	for i, want := range []string{"plan plan-1: 1 variants", "variant plan-1-v1 started", "variant plan-1-v1 finished partial in 1500ms", "variant plan-1-v1 result: queue_avg_wait_time_min=4.2", "analysis: winner plan-1-v1", "manifest: model", "done: run run-1"} {
+		if !strings.Contains(lines[i+1], want) {
+			t.Errorf("line %d: expected %q in %q", i+1, want, lines[i+1])
+		}
+	}
+	params, _ := f.request["parameters"].(map[string]any)
+	if f.request["goal"] != "reduce wait" || f.request["offline"] != true || params["staff"] != 20.0 || params["shift"] != "night" {
+		t.Errorf("unexpected request %v", f.request)
+	}
+	if f.auth != "Bearer secret" {
+		t.Errorf("expected the API key sent, got %q", f.auth)
+	}
+}
+
+func TestRunFollowJSON(t *testing.T) {
+	f := newFakeBackend(t, "plan", "done")
+	code, out, _ := f.cli(t, "run", "--goal", "g", "--follow", "--json")
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if code != ExitOK || len(lines) != 2 {
+		t.Fatalf("expected two JSON lines, got %d %q", code, out)
+	}
+	for _, l := range lines {
+		if _, err := types.DecodeEvent([]byte(l)); err != nil {
+			t.Errorf("line is not an event: %v", err)
+		}
+	}
+}
+
+func TestRunFollowFails(t *testing.T) {
+	f := newFakeBackend(t, "plan", "fallback", "error")
+	code, out, _ := f.cli(t, "run", "--goal", "g", "--follow")
+	if code != ExitFailed || !strings.Contains(out, "error: ") || !strings.Contains(out, "fallback in plan (timeout)") {
+		t.Errorf("expected a failed run, got %d %q", code, out)
+	}
+}
+
+func TestRunFollowIgnoresOtherRunsErrors(t *testing.T) {
+	f := newFakeBackend(t, "plan",
+		`{"v": 2, "type": "error", "ts": "2026-01-02T03:04:05Z", "run_id": "run-2", "payload": {"error": "run-2 failed"}}`,
+		`{"v": 2, "type": "error", "ts": "2026-01-02T03:04:05Z", "payload": {"error": "someone's run failed"}}`,
+		"done")
+	code, out, errOut := f.cli(t, "run", "--goal", "g", "--follow")
+	if code != ExitOK || strings.Contains(out, "run-2 failed") || !strings.Contains(out, "done: run run-1") {
+		t.Errorf("expected other runs' errors not to end the tail, got %d %q %q", code, out, errOut)
+	}
+}
+
+func TestRunFollowCancelled(t *testing.T) {
+	f := newFakeBackend(t, "plan", "sim_complete", "cancelled")
+	code, out, errOut := f.cli(t, "run", "--goal", "g", "--follow")
+	if code != ExitFailed || !strings.Contains(out, "cancelled: run run-1 after 2 variants finished") || !strings.Contains(errOut, "cancelled") {
+		t.Errorf("expected a cancelled run to end the stream as failed, got %d %q %q", code, out, errOut)
+	}
+}
+
+func TestRunFromFile(t *testing.T) {
+	f := newFakeBackend(t)
+	path := filepath.Join(t.TempDir(), "run.yaml")
+	scenario := "# Lunch rush\ngoal: from file\nparameters:\n  staff: 12\nreproducible: true\n"
+	_ = os.WriteFile(path, []byte(scenario), 0o644)
+	code, out, _ := f.cli(t, "run", "--file", path, "--param", "staff=14")
+	params, _ := f.request["parameters"].(map[string]any)
+	if code != ExitOK || strings.TrimSpace(out) != "run-1" || f.request["goal"] != "from file" || params["staff"] != 14.0 || f.request["reproducible"] != true {
+		t.Errorf("unexpected run %d %q %v", code, out, f.request)
+	}
+	if f.contentType != "application/x-yaml" {
+		t.Errorf("expected a YAML file sent as YAML, got %s", f.contentType)
+	}
+
+	// Without overrides the file goes as it is, so errors point into it
+	if code, _, _ := f.cli(t, "run", "-f", path); code != ExitOK || f.body != scenario || f.contentType != "application/x-yaml" {
+		t.Errorf("expected the file sent unchanged, got %d %s %q", code, f.contentType, f.body)
+	}
+	jsonPath := filepath.Join(t.TempDir(), "run.json")
+	_ = os.WriteFile(jsonPath, []byte(`{"goal": "from json"}`), 0o644)
+	if code, _, _ := f.cli(t, "run", "-f", jsonPath); code != ExitOK || f.request["goal"] != "from json" || f.contentType != "application/json" {
+		t.Errorf("expected a JSON file sent as JSON, got %d %s %v", code, f.contentType, f.request)
+	}
+
+	_ = os.WriteFile(path, []byte("goal: [not, a, string]\n"), 0o644)
+	if code, _, _ := f.cli(t, "run", "--file", path); code != ExitUsage {
+		t.Errorf("expected a mistyped file refused, got %d", code)
+	}
+	if code, _, _ := f.cli(t, "run"); code != ExitUsage {
+		t.Errorf("expected a missing goal refused, got %d", code)
+	}
+}
+
+func TestStatusAndResults(t *testing.T) {
+	f := newFakeBackend(t)
+	code, out, _ := f.cli(t, "status", "run-1")
+	if code != ExitOK || !strings.Contains(out, "status:   completed") || !strings.Contains(out, "winner:   plan-1-v2") || !strings.Contains(out, "(1m0s)") {
+		t.Errorf("unexpected status %d %q", code, out)
+	}
+	if code, _, errOut := f.cli(t, "status", "run-9"); code != ExitFailed || !strings.Contains(errOut, "404") {
+		t.Errorf("expected an unknown run to fail, got %d %q", code, errOut)
+	}
+
+	code, out, _ = f.cli(t, "results", "run-1", "--csv")
+	want := "variant,tool,status,duration_ms,queued_ms,queue_call_ms,traffic_call_ms,cost,queue_avg_wait_time_min\n" +
+		"plan-1-v1,composite,complete,1500,,,,10,4.2\n" +
+		"plan-1-v2,composite,partial,900,3,850,40,,2.5\n"
+	if code != ExitOK || out != want {
+		t.Errorf("unexpected CSV %d:\n%s", code, out)
+	}
+	code, out, _ = f.cli(t, "results", "run-1")
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if code != ExitOK || len(lines) != 3 || !strings.HasPrefix(lines[2], "*plan-1-v2") || !strings.Contains(lines[2], "-") {
+		t.Errorf("unexpected table %d:\n%s", code, out)
+	}
+}
+
+func TestCancelExportSimulators(t *testing.T) {
+	f := newFakeBackend(t)
+	if code, _, errOut := f.cli(t, "cancel", "run-1"); code != ExitFailed || !strings.Contains(errOut, "409: run already finished (run_finished)") {
+		t.Errorf("expected the backend's refusal, got %d %q", code, errOut)
+	}
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "compose.yml")
+	if code, _, errOut := f.cli(t, "export", "--goal", "g", "--out", out); code != ExitOK {
+		t.Fatalf("export failed: %s", errOut)
+	}
+	if b, _ := os.ReadFile(out); string(b) != "services: {} # g" {
+		t.Errorf("unexpected export %q", b)
+	}
+	if code, yml, _ := f.cli(t, "export", "--goal", "g", "--backend", "--out", "-"); code != ExitOK || yml != "services: {} # g with backend" {
+		t.Errorf("expected the backend requested, got %d %q", code, yml)
+	}
+	if code, env, _ := f.cli(t, "export", "--run", "run-1", "--format", "env", "--out", "-"); code != ExitOK || env != "# run: run-1\n" {
+		t.Errorf("unexpected env export %d %q", code, env)
+	}
+	if code, _, _ := f.cli(t, "export", "--format", "k8s"); code != ExitUsage {
+		t.Errorf("expected k8s refused, got %d", code)
+	}
+
+	code, table, _ := f.cli(t, "simulators")
+	if code != ExitOK || !strings.Contains(table, "queue") || !strings.Contains(table, "10.0%") || !strings.Contains(table, "closed") {
+		t.Errorf("unexpected simulators %d %q", code, table)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	var stderr bytes.Buffer
+	noEnv := func(string) string { return "" }
+	if code := Main(context.Background(), nil, io.Discard, &stderr, noEnv); code != ExitUsage || !strings.Contains(stderr.String(), "commands:") {
+		t.Errorf("expected usage, got %d %q", code, stderr.String())
+	}
+	if code := Main(context.Background(), []string{"frobnicate"}, io.Discard, io.Discard, noEnv); code != ExitUsage {
+		t.Errorf("expected an unknown command refused, got %d", code)
+	}
+	for addr, want := range map[string]string{"": "http://localhost:8080", ":9000": "http://localhost:9000", "backend:8080": "http://backend:8080", "https://sim.example.com/": "https://sim.example.com"} {
+		if u, err := baseURL(addr); err != nil || u.String() != want {
+			t.Errorf("baseURL(%q) = %v %v, want %s", addr, u, err, want)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"simstack/internal/types"
+)
+
+// eventStream is a subscription to the backend's /ws events.
+type eventStream struct {
+	conn *websocket.Conn
+}
+
+// subscribe connects to /ws, negotiating the current envelope version.
+func (c *client) subscribe(ctx context.Context) (*eventStream, error) {
+	u := *c.base
+	u.Scheme = map[string]string{"http": "ws", "https": "wss"}[u.Scheme]
+	u.Path += "/ws"
+	u.RawQuery = "v=" + strconv.Itoa(types.EventVersion)
+	h := http.Header{}
+	c.authorize(h)
+	conn, resp, err := websocket.DefaultDialer.DialContext(ctx, u.String(), h)
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("%w (HTTP %d)", err, resp.StatusCode)
+		}
+		return nil, err
+	}
+	return &eventStream{conn: conn}, nil
+}
+
+// Next returns the next event, raw and decoded.
+func (s *eventStream) Next() ([]byte, types.WSEvent, error) {
+	_, msg, err := s.conn.ReadMessage()
+	if err != nil {
+		return nil, types.WSEvent{}, err
+	}
+	ev, err := types.DecodeEvent(msg)
+	return msg, ev, err
+}
+
+func (s *eventStream) Close() error {
+	return s.conn.Close()
+}
+
+// render is the one-line, human-readable form of an event.
+func render(ev types.WSEvent) string {
+	var line string
+	switch p := ev.Payload.(type) {
+	case types.PlanEvent:
+		source := "fallback"
+		if p.LLM {
+			source = "llm " + p.Model
+		}
+		line = fmt.Sprintf("plan %s: %d variants (%s)", p.PlanID, len(p.Variants), source)
+	case types.ProgressEvent:
+		line = fmt.Sprintf("variant %s started", p.VariantID)
+	case types.ResultEvent:
+		verb := "result"
+		if ev.Type == types.EventSimComplete {
+			verb = "finished"
+		}
+		line = fmt.Sprintf("variant %s %s", p.VariantID, verb)
+		if p.Status != "" {
+			line += " " + string(p.Status)
+		}
+		if p.DurationMs > 0 {
+			line += fmt.Sprintf(" in %dms", p.DurationMs)
+		}
+		if m := formatMetrics(p.Metrics); m != "" {
+			line += ": " + m
+		}
+	case types.AnalysisEvent:
+		line = fmt.Sprintf("analysis: winner %s (confidence %.2f)", orNone(p.Winner), p.Confidence)
+		if !p.LLM {
+			line += " [heuristic]"
+		}
+		if p.Recommendation != "" {
+			line += ": " + p.Recommendation
+		}
+	case types.ManifestEvent:
+		line = fmt.Sprintf("manifest: model %s, %d LLM calls", orNone(p.Model), len(p.LLMCalls))
+		if p.CostUSD != nil {
+			line += fmt.Sprintf(", about $%.4f", *p.CostUSD)
+		}
+	case types.DoneEvent:
+		line = fmt.Sprintf("done: run %s, plan %s", p.RunID, p.PlanID)
+	case types.CancelledEvent:
+		line = fmt.Sprintf("cancelled: run %s after %d variants finished", p.RunID, p.Completed)
+	case types.FallbackEvent:
+		line = fmt.Sprintf("fallback in %s (%s)", p.Stage, p.Category)
+		if p.Error != "" {
+			line += ": " + p.Error
+		}
+	case types.BudgetExhaustedEvent:
+		line = fmt.Sprintf("LLM budget exhausted in %s: %dms of %dms, %d of %d tokens", p.Stage, p.SpentMs, p.TimeBudgetMs, p.Tokens, p.TokenBudget)
+	case types.ErrorEvent:
+		line = "error: " + p.Error
+	default:
+		line = fmt.Sprintf("%s: %v", ev.Type, ev.Payload)
+	}
+	if t, err := time.Parse(time.RFC3339Nano, ev.Timestamp); err == nil {
+		line = t.Local().Format("15:04:05") + " " + line
+	}
+	return line
+}
+
+// formatMetrics lists metrics by name, name=value.
+func formatMetrics(m map[string]float64) string {
+	names := make([]string, 0, len(m))
+	for k := range m {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, k := range names {
+		parts[i] = k + "=" + strconv.FormatFloat(m[k], 'g', 6, 64)
+	}
+	return strings.Join(parts, " ")
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
@@ -0,0 +1,151 @@
+package cli
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+
+	"simstack/internal/types"
+)
+
+// metricNames is every metric any result reports, sorted.
+func metricNames(results []types.SimulationResult) []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, r := range results {
+		for k := range r.Metrics {
+			if !seen[k] {
+				seen[k] = true
+				names = append(names, k)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// timedTools is every simulator any result's timing breakdown has a call
+// to, sorted, and whether any result has a breakdown.
+func timedTools(results []types.SimulationResult) ([]string, bool) {
+	seen := map[string]bool{}
+	var tools []string
+	timed := false
+	for _, r := range results {
+		if r.Timing == nil {
+			continue
+		}
+		timed = true
+		for _, c := range r.Timing.Calls {
+			if !seen[c.Tool] {
+				seen[c.Tool] = true
+				tools = append(tools, c.Tool)
+			}
+		}
+	}
+	sort.Strings(tools)
+	return tools, timed
+}
+
+// resultRows is the header and one row per result; missing metrics are
+// empty. With timing breakdowns, the time spent queued and in each
+// simulator's calls follow the duration.
+func resultRows(results []types.SimulationResult) [][]string {
+	names := metricNames(results)
+	tools, timed := timedTools(results)
+	header := []string{"variant", "tool", "status", "duration_ms"}
+	if timed {
+		header = append(header, "queued_ms")
+		for _, tool := range tools {
+			header = append(header, tool+"_call_ms")
+		}
+	}
+	rows := [][]string{append(header, names...)}
+	for _, r := range results {
+		row := []string{r.VariantID, r.Tool, string(r.Status), strconv.FormatInt(r.DurationMs, 10)}
+		if timed {
+			row = append(row, timingCells(r.Timing, tools)...)
+		}
+		for _, k := range names {
+			v, ok := r.Metrics[k]
+			if ok {
+				row = append(row, strconv.FormatFloat(v, 'g', -1, 64))
+			} else {
+				row = append(row, "")
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// timingCells is the queue time and each tool's call time of t; empty
+// where t has none.
+func timingCells(t *types.VariantTiming, tools []string) []string {
+	cells := make([]string, 1+len(tools))
+	if t == nil {
+		return cells
+	}
+	cells[0] = strconv.FormatInt(t.QueueMs, 10)
+	for i, tool := range tools {
+		var ms int64
+		called := false
+		for _, c := range t.Calls {
+			if c.Tool == tool {
+				ms += c.DurationMs
+				called = true
+			}
+		}
+		if called {
+			cells[i+1] = strconv.FormatInt(ms, 10)
+		}
+	}
+	return cells
+}
+
+func writeResultsCSV(w io.Writer, results []types.SimulationResult) error {
+	cw := csv.NewWriter(w)
+	if err := cw.WriteAll(resultRows(results)); err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+// writeResultsTable aligns the results in columns, marking the winner.
+func writeResultsTable(w io.Writer, results []types.SimulationResult, winner string) {
+	if len(results) == 0 {
+		fmt.Fprintln(w, "no results")
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for i, row := range resultRows(results) {
+		mark := " "
+		if i > 0 && winner != "" && results[i-1].VariantID == winner {
+			mark = "*"
+		}
+		fmt.Fprint(tw, mark)
+		for _, cell := range row {
+			if cell == "" {
+				cell = "-"
+			}
+			fmt.Fprint(tw, cell, "\t")
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+}
+
+func writeSimulatorsTable(w io.Writer, stats []types.SimulatorStats) {
+	if len(stats) == 0 {
+		fmt.Fprintln(w, "no simulator calls yet")
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "tool\tcalls\terrors\terror_rate\tp50_ms\tp95_ms\tp99_ms\tbreaker\t")
+	for _, s := range stats {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f%%\t%.0f\t%.0f\t%.0f\t%s\t\n", s.Tool, s.Calls, s.Errors, s.ErrorRate*100, s.P50Ms, s.P95Ms, s.P99Ms, s.Breaker)
+	}
+	tw.Flush()
+}
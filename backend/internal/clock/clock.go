@@ -0,0 +1,106 @@
+// Package clock lets code that reads the time or waits on it run against a
+// clock tests control, instead of sleeping through real timeouts.
+package clock
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Clock tells the time and makes timers. Real is the wall clock; tests use
+// testsupport.FakeClock.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+	After(d time.Duration) <-chan time.Time
+	// AfterFunc calls f in its own goroutine once d has passed.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a time.Timer made by a Clock. C is nil for AfterFunc timers.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Ticker is a time.Ticker made by a Clock.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real is the wall clock.
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return realTimer{time.AfterFunc(d, f)}
+}
+
+type realTimer struct{ *time.Timer }
+
+func (t realTimer) C() <-chan time.Time { return t.Timer.C }
+
+type realTicker struct{ *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.Ticker.C }
+
+// Or returns c, or Real when c is nil.
+func Or(c Clock) Clock {
+	if c == nil {
+		return Real
+	}
+	return c
+}
+
+// WithTimeout is context.WithTimeout with d measured on c.
+func WithTimeout(ctx context.Context, c Clock, d time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := c.(realClock); ok || c == nil {
+		return context.WithTimeout(ctx, d)
+	}
+	deadline := c.Now().Add(d)
+	if parent, ok := ctx.Deadline(); ok && parent.Before(deadline) {
+		// The parent's deadline comes first and will cancel ctx itself
+		return context.WithCancel(ctx)
+	}
+	inner, cancel := context.WithCancelCause(ctx)
+	t := c.AfterFunc(d, func() { cancel(context.DeadlineExceeded) })
+	return &timeoutCtx{Context: inner, deadline: deadline}, func() {
+		t.Stop()
+		cancel(context.Canceled)
+	}
+}
+
+// timeoutCtx reports the deadline of a context WithTimeout cancels on a
+// Clock other than Real, and its expiry as context.DeadlineExceeded.
+type timeoutCtx struct {
+	context.Context
+	deadline time.Time
+}
+
+func (c *timeoutCtx) Deadline() (time.Time, bool) {
+	return c.deadline, true
+}
+
+func (c *timeoutCtx) Err() error {
+	err := c.Context.Err()
+	if err != nil && errors.Is(context.Cause(c.Context), context.DeadlineExceeded) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
@@ -0,0 +1,54 @@
+package clock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// manualClock fires its one AfterFunc only when a test calls expire.
+type manualClock struct {
+	realClock
+	now    time.Time
+	expire func()
+}
+
+func (c *manualClock) Now() time.Time { return c.now }
+
+func (c *manualClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.expire = f
+	return realTimer{time.NewTimer(time.Hour)}
+}
+
+func TestWithTimeoutOnClock(t *testing.T) {
+	c := &manualClock{now: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}
+	ctx, cancel := WithTimeout(context.Background(), c, time.Minute)
+	defer cancel()
+	if d, ok := ctx.Deadline(); !ok || !d.Equal(c.now.Add(time.Minute)) {
+		t.Errorf("expected the deadline a minute on the clock, got %v %v", d, ok)
+	}
+	if ctx.Err() != nil {
+		t.Fatalf("expected ctx live before the clock gets there, got %v", ctx.Err())
+	}
+	c.expire()
+	<-ctx.Done()
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		t.Errorf("expected the deadline exceeded, got %v", ctx.Err())
+	}
+
+	ctx, cancel = WithTimeout(context.Background(), c, time.Minute)
+	cancel()
+	if !errors.Is(ctx.Err(), context.Canceled) {
+		t.Errorf("expected a canceled ctx, got %v", ctx.Err())
+	}
+
+	// An earlier parent deadline stands
+	parent, cancelParent := context.WithDeadline(context.Background(), c.now.Add(time.Second))
+	defer cancelParent()
+	ctx, cancel = WithTimeout(parent, c, time.Minute)
+	defer cancel()
+	if d, _ := ctx.Deadline(); !d.Equal(c.now.Add(time.Second)) {
+		t.Errorf("expected the parent's deadline, got %v", d)
+	}
+}
@@ -0,0 +1,314 @@
+// Package compose builds docker-compose files that bring up the simulators,
+// and optionally the backend in front of them, configured with a variant's
+// parameters.
+package compose
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"simstack/internal/paramfile"
+)
+
+// File is the subset of the compose specification exports use.
+type File struct {
+	Services map[string]Service `yaml:"services"`
+	Networks map[string]Network `yaml:"networks"`
+}
+
+type Network struct {
+	Driver string `yaml:"driver,omitempty"`
+}
+
+type Service struct {
+	Image         string                    `yaml:"image"`
+	ContainerName string                    `yaml:"container_name,omitempty"`
+	Profiles      []string                  `yaml:"profiles,omitempty"`
+	Restart       string                    `yaml:"restart,omitempty"`
+	Ports         []Port                    `yaml:"ports,omitempty"`
+	Environment   map[string]string         `yaml:"environment,omitempty"`
+	Healthcheck   *Healthcheck              `yaml:"healthcheck,omitempty"`
+	DependsOn     map[string]Dependency     `yaml:"depends_on,omitempty"`
+	Deploy        *Deploy                   `yaml:"deploy,omitempty"`
+	Networks      map[string]ServiceNetwork `yaml:"networks,omitempty"`
+	Labels        map[string]string         `yaml:"labels,omitempty"`
+}
+
+// ServiceNetwork is a service's membership of a network.
+type ServiceNetwork struct {
+	// Other names the service is reachable by
+	Aliases []string `yaml:"aliases,omitempty"`
+}
+
+// Port is a HOST:CONTAINER mapping. It is written quoted, as YAML 1.1
+// parsers read unquoted digits and colons as a base-60 number.
+type Port string
+
+func (p Port) MarshalYAML() (any, error) {
+	return &yaml.Node{Kind: yaml.ScalarNode, Style: yaml.DoubleQuotedStyle, Value: string(p)}, nil
+}
+
+type Healthcheck struct {
+	Test        []string `yaml:"test"`
+	Interval    string   `yaml:"interval"`
+	Timeout     string   `yaml:"timeout"`
+	Retries     int      `yaml:"retries"`
+	StartPeriod string   `yaml:"start_period"`
+}
+
+// Dependency is a long-form depends_on entry. A dependency that isn't
+// Required only draws a warning when its profile isn't enabled.
+type Dependency struct {
+	Condition string `yaml:"condition"`
+	Required  *bool  `yaml:"required,omitempty"`
+}
+
+type Deploy struct {
+	Resources Resources `yaml:"resources"`
+}
+
+type Resources struct {
+	Limits Limits `yaml:"limits"`
+}
+
+type Limits struct {
+	CPUs   string `yaml:"cpus"`
+	Memory string `yaml:"memory"`
+}
+
+// Simulator is how a simulator's image is run.
+type Simulator struct {
+	Tool  string
+	Image string
+	// Published on the host, as in the repository's docker-compose.yml
+	HostPort int
+}
+
+// Simulators is the registry of simulator images, in the order of the
+// backend's default simulator URLs.
+var Simulators = []Simulator{
+	{Tool: "queue", Image: "simstack/queue:latest", HostPort: 8101},
+	{Tool: "traffic", Image: "simstack/traffic:latest", HostPort: 8102},
+	{Tool: "resource", Image: "simstack/resource:latest", HostPort: 8103},
+}
+
+const (
+	// SimulatorPort is where every simulator listens in its container.
+	SimulatorPort = 8000
+	BackendPort   = 8080
+	BackendImage  = "simstack/backend:latest"
+	// NetworkName is the network every service joins.
+	NetworkName = "simstack"
+)
+
+// BaseProfile is the profile of the services every variant shares.
+const BaseProfile = "base"
+
+// Options are what Build exports.
+type Options struct {
+	// The variant's parameters; each simulator's go in its environment
+	Params paramfile.Config
+	// Variants, when set, are exported side by side instead of Params, each
+	// under its own profile; Params then only names the run
+	Variants []Profile
+	// Limits of every service
+	CPUs        float64
+	MemoryBytes int64
+	// Also run the backend, pointed at the exported simulators
+	Backend bool
+}
+
+// Profile is a variant exported under a compose profile.
+type Profile struct {
+	Name   string
+	Params paramfile.Config
+}
+
+var profileInvalid = regexp.MustCompile(`[^a-z0-9_.-]+`)
+
+// ProfileName derives a variant's profile from its ID, without the plan ID
+// prefix variant IDs carry: v3 for plan-17-v3. The name is valid as a compose
+// profile and depends on nothing else, so it is the same in every export.
+func ProfileName(planID, variantID string) string {
+	name := variantID
+	if planID != "" {
+		name = strings.TrimPrefix(name, planID+"-")
+	}
+	name = strings.Trim(profileInvalid.ReplaceAllString(strings.ToLower(name), "-"), "_.-")
+	if name == "" {
+		name = "variant"
+	}
+	if len(name) < 2 || name == BaseProfile {
+		name = "v-" + name
+	}
+	return name
+}
+
+// Build returns the compose file for o.
+func Build(o Options) File {
+	f := File{
+		Services: map[string]Service{},
+		Networks: map[string]Network{NetworkName: {Driver: "bridge"}},
+	}
+	deploy := &Deploy{Resources: Resources{Limits: Limits{
+		CPUs:   strconv.FormatFloat(o.CPUs, 'f', -1, 64),
+		Memory: memory(o.MemoryBytes),
+	}}}
+	backend := Service{
+		Image:   BackendImage,
+		Restart: "unless-stopped",
+		Ports:   []Port{Port(fmt.Sprintf("%d:%d", BackendPort, BackendPort))},
+		Environment: map[string]string{
+			"SIMSTACK_ADDR":    fmt.Sprintf(":%d", BackendPort),
+			"CEREBRAS_API_KEY": "${CEREBRAS_API_KEY}",
+		},
+		Healthcheck: healthcheck("wget", "-q", "-O", "/dev/null", fmt.Sprintf("http://localhost:%d/healthz", BackendPort)),
+		DependsOn:   map[string]Dependency{},
+		Deploy:      deploy,
+		Networks:    map[string]ServiceNetwork{NetworkName: {}},
+		Labels:      labels(o.Params.RunID, ""),
+	}
+	for _, sim := range Simulators {
+		backend.Environment[strings.ToUpper(sim.Tool)+"_SIMULATOR_URL"] = fmt.Sprintf("http://%s:%d", sim.Tool, SimulatorPort)
+	}
+
+	if len(o.Variants) == 0 {
+		for _, sim := range Simulators {
+			f.Services[sim.Tool] = simulator(sim, o.Params, deploy)
+			backend.DependsOn[sim.Tool] = Dependency{Condition: "service_healthy"}
+		}
+		backend.Labels = labels(o.Params.RunID, o.Params.VariantID)
+	} else {
+		// Each variant's simulators answer to the tool's name on the network,
+		// so the shared backend reaches whichever variant's profile is enabled.
+		optional := false
+		backend.Profiles = []string{BaseProfile}
+		for _, p := range o.Variants {
+			backend.Profiles = append(backend.Profiles, p.Name)
+			for _, sim := range Simulators {
+				svc := simulator(sim, p.Params, deploy)
+				name := sim.Tool + "-" + p.Name
+				svc.ContainerName = "simstack-" + p.Name + "-" + sim.Tool
+				svc.Profiles = []string{p.Name}
+				svc.Networks = map[string]ServiceNetwork{NetworkName: {Aliases: []string{sim.Tool}}}
+				f.Services[name] = svc
+				backend.DependsOn[name] = Dependency{Condition: "service_healthy", Required: &optional}
+			}
+		}
+	}
+	if o.Backend {
+		f.Services["backend"] = backend
+	}
+	return f
+}
+
+// simulator is sim's service, with params' parameters for it in its
+// environment.
+func simulator(sim Simulator, params paramfile.Config, deploy *Deploy) Service {
+	var env map[string]string
+	for name, v := range params.Tools[sim.Tool] {
+		if env == nil {
+			env = map[string]string{}
+		}
+		env[paramfile.Key(sim.Tool, name)] = escape(paramfile.Value(v))
+	}
+	return Service{
+		Image:       sim.Image,
+		Restart:     "unless-stopped",
+		Ports:       []Port{Port(fmt.Sprintf("%d:%d", sim.HostPort, SimulatorPort))},
+		Environment: env,
+		Healthcheck: healthcheck("python", "-c", fmt.Sprintf("import urllib.request; urllib.request.urlopen('http://localhost:%d/healthz', timeout=3)", SimulatorPort)),
+		Deploy:      deploy,
+		Networks:    map[string]ServiceNetwork{NetworkName: {}},
+		Labels:      labels(params.RunID, params.VariantID),
+	}
+}
+
+func labels(runID, variantID string) map[string]string {
+	l := map[string]string{}
+	if runID != "" {
+		l["io.simstack.run"] = runID
+	}
+	if variantID != "" {
+		l["io.simstack.variant"] = variantID
+	}
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
+
+// Marshal writes f as YAML after a comment naming where o's parameters came
+// from.
+func Marshal(f File, o Options) ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString("# SimStack winning configuration\n")
+	for _, line := range [][2]string{{"run", o.Params.RunID}, {"variant", o.Params.VariantID}, {"goal", o.Params.Goal}} {
+		if line[1] != "" {
+			fmt.Fprintf(&b, "# %s: %s\n", line[0], strings.Join(strings.Fields(line[1]), " "))
+		}
+	}
+	writeOther(&b, "", o.Params.Other)
+	if len(o.Variants) > 0 {
+		fmt.Fprintf(&b, "# run one variant with docker compose --profile <profile> up (%s alone starts only the backend)\n", BaseProfile)
+	}
+	for _, p := range o.Variants {
+		fmt.Fprintf(&b, "# profile %s: variant %s\n", p.Name, p.Params.VariantID)
+		writeOther(&b, p.Name+" ", p.Params.Other)
+	}
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	if err := enc.Encode(f); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func writeOther(b *bytes.Buffer, prefix string, other map[string]any) {
+	if len(other) == 0 {
+		return
+	}
+	names := make([]string, 0, len(other))
+	for k := range other {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(b, "# %snot taken by any simulator: %s\n", prefix, strings.Join(names, ", "))
+}
+
+func healthcheck(cmd ...string) *Healthcheck {
+	return &Healthcheck{
+		Test:        append([]string{"CMD"}, cmd...),
+		Interval:    "10s",
+		Timeout:     "5s",
+		Retries:     5,
+		StartPeriod: "10s",
+	}
+}
+
+// memory writes n bytes in the largest unit that divides it.
+func memory(n int64) string {
+	for _, u := range []struct {
+		suffix string
+		size   int64
+	}{{"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}} {
+		if n >= u.size && n%u.size == 0 {
+			return strconv.FormatInt(n/u.size, 10) + u.suffix
+		}
+	}
+	return strconv.FormatInt(n, 10) + "b"
+}
+
+// escape keeps compose from interpolating variables in a parameter value.
+func escape(s string) string {
+	return strings.ReplaceAll(s, "$", "$$")
+}
@@ -0,0 +1,185 @@
+package compose
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"simstack/internal/paramfile"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files")
+
+func sampleOptions(backend bool) Options {
+	return Options{
+		Params: paramfile.Config{
+			RunID: "run-1", VariantID: "plan-1-v2", Goal: "reduce ER wait time by 20%",
+			Tools: map[string]map[string]any{
+				"queue":    {"arrival_rate": 4.5, "service_rate": 6.0},
+				"resource": {"staff": 25.0, "shifts": "night"},
+				"traffic":  {"density": 0.4, "signal_timing": "$PEAK"},
+			},
+			Other: map[string]any{"budget": 1000.0},
+		},
+		CPUs:        0.5,
+		MemoryBytes: 256 << 20,
+		Backend:     backend,
+	}
+}
+
+func TestComposeGolden(t *testing.T) {
+	for name, backend := range map[string]bool{"simulators.yml": false, "stack.yml": true} {
+		opts := sampleOptions(backend)
+		got, err := Marshal(Build(opts), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join("testdata", name)
+		if *update {
+			if err := os.WriteFile(path, got, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		want, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s differs from the golden file (rerun with -update to accept):\n%s", name, got)
+		}
+	}
+}
+
+// The output reads back as a stack compose can start: every service is
+// healthchecked on the declared network, and the backend waits for healthy
+// simulators it can reach by name.
+func TestComposeRoundTrip(t *testing.T) {
+	opts := sampleOptions(true)
+	b, err := Marshal(Build(opts), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var f File
+	if err := yaml.Unmarshal(b, &f); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Services) != len(Simulators)+1 {
+		t.Fatalf("expected the simulators and the backend, got %d services", len(f.Services))
+	}
+	for name, svc := range f.Services {
+		if svc.Healthcheck == nil || svc.Healthcheck.Test[0] != "CMD" || !strings.Contains(strings.Join(svc.Healthcheck.Test, " "), "/healthz") {
+			t.Errorf("%s: expected a /healthz check, got %+v", name, svc.Healthcheck)
+		}
+		if len(svc.Ports) != 1 || svc.Restart != "unless-stopped" || svc.Deploy == nil || svc.Deploy.Resources.Limits.Memory != "256M" || svc.Deploy.Resources.Limits.CPUs != "0.5" {
+			t.Errorf("%s: unexpected ports, restart or limits %+v", name, svc)
+		}
+		for n := range svc.Networks {
+			if _, ok := f.Networks[n]; !ok {
+				t.Errorf("%s: network %s is not declared", name, n)
+			}
+		}
+		for dep, d := range svc.DependsOn {
+			if _, ok := f.Services[dep]; !ok || d.Condition != "service_healthy" {
+				t.Errorf("%s: bad dependency on %s %+v", name, dep, d)
+			}
+		}
+	}
+	backend := f.Services["backend"]
+	if len(backend.DependsOn) != len(Simulators) || backend.Environment["QUEUE_SIMULATOR_URL"] != "http://queue:8000" {
+		t.Errorf("unexpected backend %+v", backend)
+	}
+	if q := f.Services["queue"]; q.Ports[0] != "8101:8000" || q.Environment["QUEUE_ARRIVAL_RATE"] != "4.5" || q.Labels["io.simstack.variant"] != "plan-1-v2" {
+		t.Errorf("unexpected queue service %+v", q)
+	}
+	if v := f.Services["traffic"].Environment["TRAFFIC_SIGNAL_TIMING"]; v != "$$PEAK" {
+		t.Errorf("expected the value escaped from interpolation, got %q", v)
+	}
+
+	opts.Backend = false
+	if f := Build(opts); len(f.Services) != len(Simulators) {
+		t.Errorf("expected only the simulators without the backend, got %d", len(f.Services))
+	}
+}
+
+func TestMemory(t *testing.T) {
+	for n, want := range map[int64]string{256 << 20: "256M", 2 << 30: "2G", 1536 << 10: "1536K", 6<<20 + 1: "6291457b"} {
+		if got := memory(n); got != want {
+			t.Errorf("memory(%d) = %s, want %s", n, got, want)
+		}
+	}
+}
+
+func TestComposeProfiles(t *testing.T) {
+	variant := func(id string, arrival float64) Profile {
+		return Profile{Name: ProfileName("plan-1", id), Params: paramfile.Config{RunID: "run-1", VariantID: id, Tools: map[string]map[string]any{
+			"queue":    {"arrival_rate": arrival, "service_rate": 6.0},
+			"resource": {"staff": 25.0},
+		}}}
+	}
+	opts := Options{Params: paramfile.Config{RunID: "run-1"}, Variants: []Profile{variant("plan-1-v2", 4.5), variant("plan-1-v3", 5.0)}, CPUs: 1, MemoryBytes: 1 << 30, Backend: true}
+	b, err := Marshal(Build(opts), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var f File
+	if err := yaml.Unmarshal(b, &f); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Services) != 2*len(Simulators)+1 {
+		t.Fatalf("expected each variant's simulators and the backend, got %d services", len(f.Services))
+	}
+	for _, p := range []string{"v2", "v3"} {
+		for _, sim := range Simulators {
+			svc, ok := f.Services[sim.Tool+"-"+p]
+			if !ok || !reflect.DeepEqual(svc.Profiles, []string{p}) || svc.ContainerName != "simstack-"+p+"-"+sim.Tool ||
+				!reflect.DeepEqual(svc.Networks[NetworkName].Aliases, []string{sim.Tool}) {
+				t.Errorf("unexpected %s service for %s: %+v", sim.Tool, p, svc)
+			}
+		}
+	}
+	backend := f.Services["backend"]
+	if !reflect.DeepEqual(backend.Profiles, []string{BaseProfile, "v2", "v3"}) || len(backend.DependsOn) != 2*len(Simulators) || *backend.DependsOn["queue-v3"].Required {
+		t.Errorf("unexpected backend %+v", backend)
+	}
+
+	// Only the parameter that differs differs
+	for _, sim := range Simulators {
+		v2, v3 := f.Services[sim.Tool+"-v2"].Environment, f.Services[sim.Tool+"-v3"].Environment
+		for k := range v2 {
+			if differs := v2[k] != v3[k]; differs != (k == "QUEUE_ARRIVAL_RATE") {
+				t.Errorf("%s: %s is %q and %q", sim.Tool, k, v2[k], v3[k])
+			}
+		}
+		if len(v2) != len(v3) {
+			t.Errorf("%s: environments name different parameters %v %v", sim.Tool, v2, v3)
+		}
+	}
+
+	// One variant is still a complete stack
+	opts.Variants = opts.Variants[:1]
+	f = Build(opts)
+	if len(f.Services) != len(Simulators)+1 || !reflect.DeepEqual(f.Services["backend"].Profiles, []string{BaseProfile, "v2"}) {
+		t.Errorf("unexpected single-variant file %+v", f.Services)
+	}
+}
+
+func TestProfileName(t *testing.T) {
+	for _, c := range []struct{ plan, variant, want string }{
+		{"plan-17", "plan-17-v3", "v3"},
+		{"plan-17", "other-plan-v3", "other-plan-v3"},
+		{"", "Plan 9 / Variant #2", "plan-9-variant-2"},
+		{"p", "p-7", "v-7"},
+		{"p", "p-base", "v-base"},
+		{"p", "p-!!", "variant"},
+	} {
+		if got := ProfileName(c.plan, c.variant); got != c.want {
+			t.Errorf("ProfileName(%q, %q) = %q, want %q", c.plan, c.variant, got, c.want)
+		}
+	}
+}
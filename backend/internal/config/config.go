@@ -0,0 +1,906 @@
+// Package config reads the backend's settings from the environment, and the
+// config file it names, once, at startup. Everything else receives a
+// Config; nothing reads the environment after Load.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"simstack/internal/artifacts"
+	"simstack/internal/cassette"
+	"simstack/internal/llm"
+	"simstack/internal/metrics"
+	"simstack/internal/notify"
+	"simstack/internal/pricing"
+	"simstack/internal/statsd"
+	"simstack/internal/tracing"
+)
+
+// Config is every setting the backend takes. See env.template for the
+// variables behind each field.
+type Config struct {
+	// HTTP server
+	Addr string
+	// Origins allowed by CORS and the WebSocket upgrade, besides the
+	// backend's own: exact origins, "*.example.com" forms matching any
+	// subdomain (optionally behind a scheme), or "*" for any. Unset, it is
+	// "*" on a loopback address and empty otherwise
+	CORSOrigins []string
+	// Keys accepted by /api and /ws, from SIMSTACK_API_KEYS and the lines of
+	// SIMSTACK_API_KEYS_FILE; with none, both are open
+	APIKeys []string
+	// Skip the API key check, for local development
+	AuthDisabled bool
+	// Reject unknown top-level run request fields instead of warning
+	StrictRequests bool
+	// Largest run, replay or export request body taken, in bytes (0 = no
+	// limit)
+	MaxBodyBytes int64
+	// PEM certificate (with any intermediates) and key files; with both set
+	// the backend serves HTTPS on Addr
+	TLSCert string
+	TLSKey  string
+	// Plain HTTP listen address redirecting to HTTPS; empty runs none
+	TLSRedirectAddr string
+	// How often the certificate files are checked for a renewal (0 = only
+	// on reload)
+	TLSReloadInterval time.Duration
+	// How long SIGTERM waits for runs in flight before canceling them
+	ShutdownGrace time.Duration
+	// How long SIGTERM fails readiness before the listener closes, for load
+	// balancers to notice
+	ShutdownDelay time.Duration
+	// Log line format, "text" or "json"
+	LogFormat string
+
+	// Simulator base URLs by tool name, and how long calls may take
+	SimulatorURLs         map[string]string
+	SimulatorTimeout      time.Duration
+	VariantTimeout        time.Duration
+	SimulatorMaxIdleConns int
+	// Largest simulator response read, in bytes; longer ones fail the call
+	SimulatorMaxResponseBytes int64
+	// Backup instances by tool name, in the order calls fail over to them
+	// while the primary's breaker is open
+	SimulatorBackupURLs map[string][]string
+	// Shared secrets by tool name; calls to a tool with one are signed (see
+	// package simsign)
+	SimulatorSecrets map[string]string
+	// Consecutive failures that open a simulator's circuit breaker (0 never
+	// opens it), and how long it stays open
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	// Ping each simulator a run uses before dispatching its variants, so the
+	// first variant doesn't pay for connection setup and cold starts
+	SimulatorWarmup bool
+	// Background health probes (0 interval disables them): how often, how
+	// long each may take, the latency past which a simulator is degraded, the
+	// failures in a row that make it down, and the longest backoff once down
+	HealthInterval   time.Duration
+	HealthTimeout    time.Duration
+	HealthSlow       time.Duration
+	HealthDownAfter  int
+	HealthMaxBackoff time.Duration
+	// How long GET /healthz/deep answers from its last check before probing
+	// again
+	DeepHealthTTL time.Duration
+	// Simulator response cache: how long responses of stochastic calls are
+	// kept, how many responses at most (0 disables it), and the simulators
+	// whose answers depend only on their parameters, kept until evicted
+	SimulatorCacheTTL           time.Duration
+	SimulatorCacheMaxEntries    int
+	SimulatorCacheDeterministic []string
+
+	// LLM provider; its rate limits and call ceiling live here too
+	LLM llm.Config
+	// Refuse to start when the provider doesn't serve the configured model
+	StrictModel bool
+	// Ask for schema-constrained JSON via response_format
+	StructuredOutput bool
+	// Model chains per call site; empty falls back to ModelChain, then to
+	// LLM.Model
+	ModelChain         string
+	PlanModelChain     string
+	AnalysisModelChain string
+	// Model and default temperature of each phase: planning, and the critic's
+	// analysis; an empty model uses LLM.Model
+	PlannerModel       string
+	CriticModel        string
+	PlannerTemperature float64
+	CriticTemperature  float64
+	ModelTimeout       time.Duration
+	// Models runs may select; empty allows any
+	AllowedModels []string
+	// Follow-up calls allowed to finish a plan cut off by max_tokens
+	MaxContinuations int
+	// How long planning waits on the LLM before racing the fallback grid
+	// against it (0 waits for the LLM)
+	PlanSoftDeadline time.Duration
+	// Longest the planning and analysis phases may take; the run's LLM
+	// budget can only shorten them
+	PlanTimeout     time.Duration
+	AnalysisTimeout time.Duration
+	// Longest goal and constraints text a prompt takes, in characters; the
+	// rest is cut off with a marker
+	GoalMaxChars       int
+	ConstraintMaxChars int
+	// Default per-run LLM budget (zero = unlimited)
+	TimeBudget  time.Duration
+	TokenBudget int
+	// Dollars per million tokens by model, for cost estimates
+	Pricing pricing.Table
+
+	// Never contact the LLM
+	Offline bool
+	// Keep sanitized prompt text in audit records
+	AuditPrompts bool
+	// Where debug runs log LLM traffic; empty logs to stderr
+	LLMLogDir string
+	// Record or replay LLM traffic from a cassette file
+	Cassette            string
+	CassetteMode        cassette.Mode
+	CassettePassThrough bool
+	// Keep raw simulator responses as artifacts, in memory up to the cap
+	CaptureArtifacts    bool
+	ArtifactMemoryBytes int64
+	// Keep artifacts on disk under ArtifactDir instead, collected once their
+	// run is older than ArtifactRetention or the directory outgrows its budget
+	ArtifactDir        string
+	ArtifactMaxBytes   int64
+	ArtifactDiskBytes  int64
+	ArtifactRetention  time.Duration
+	ArtifactGCInterval time.Duration
+	// Completed runs kept in the /metrics history
+	MetricsHistory int
+	// Metrics charted as metric_distribution events per run (0 sends none),
+	// and the histogram bucket count (0 lets Freedman–Diaconis choose)
+	DistributionMetrics int
+	DistributionBuckets int
+	// Chat webhooks told about every finished run, besides a run's own, and
+	// the message template (notify.DefaultTemplate when empty); failed posts
+	// are retried NotifyRetries times
+	SlackWebhookURL   string
+	DiscordWebhookURL string
+	NotifyTemplate    string
+	NotifyRetries     int
+	// Hosts a run's own webhooks may name although they are on the
+	// private network; any other private address is refused
+	NotifyAllowedHosts []string
+	// Base URL notifications link runs under
+	PublicURL string
+	// Finished runs kept in memory, by age and count; evicted runs are read
+	// from the run store when it persists them, and are gone otherwise
+	RunRetention   time.Duration
+	RunMaxResident int
+	// How long a finished run's live results stay available to followers
+	RunReplayGrace time.Duration
+	// How long a run may go without progress before it is failed as stalled
+	// (0 = never)
+	RunStallTimeout time.Duration
+	// Longest a run may take end to end before it is canceled; longer than
+	// PlanTimeout and AnalysisTimeout together
+	RunTimeout time.Duration
+	// How long an Idempotency-Key on POST /api/run keeps naming the run it
+	// started (0 = keys are ignored)
+	IdempotencyWindow time.Duration
+	// Runs in flight at once, and runs waiting for a slot beyond which
+	// POST /api/run answers 429
+	MaxRuns       int
+	RunQueueDepth int
+	// Run and export requests each client, by API key or else by address,
+	// may make a minute (0 doesn't limit them), bursting up to
+	// RateLimitBurst (RateLimitRPM when 0)
+	RateLimitRPM   int
+	RateLimitBurst int
+	// Resource limits of each service in exported compose files
+	ExportCPUs        float64
+	ExportMemoryBytes int64
+	// Where run history lives: "memory" (lost on restart), "sqlite" at
+	// SQLitePath, or "postgres" at PostgresDSN, shared between replicas
+	RunStore    string
+	SQLitePath  string
+	PostgresDSN string
+	// Redis whose pub/sub shares WebSocket events between replicas; empty
+	// keeps them in this process
+	RedisURL string
+	// OTLP trace export; an empty endpoint keeps tracing off
+	Tracing tracing.Config
+	// StatsD/DogStatsD push; an empty address keeps it off
+	Statsd statsd.Config
+	// Files read besides the environment: SIMSTACK_CONFIG, under it, and
+	// SIMSTACK_ENV_FILE, over it
+	ConfigFile string
+	EnvFile    string
+}
+
+// Error lists every problem Load found, so one restart fixes them all.
+type Error struct {
+	Problems []string
+}
+
+func (e *Error) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Load reads the configuration from the environment and validates it. The
+// returned Config is filled in even when err is an *Error.
+//
+// When SIMSTACK_CONFIG names a YAML or JSON file (see readConfigFile), its
+// settings apply where the environment leaves a variable unset or empty.
+// When SIMSTACK_ENV_FILE names a .env file, its variables take precedence
+// over the process environment. Both files are read on every Load, so a
+// reload sees edits to them.
+func Load() (Config, error) {
+	var problems []string
+	configPath, envPath := os.Getenv("SIMSTACK_CONFIG"), os.Getenv("SIMSTACK_ENV_FILE")
+	var fileVars, envFileVars map[string]string
+	if configPath != "" {
+		var fileProblems []string
+		fileVars, fileProblems = readConfigFile(configPath)
+		for _, p := range fileProblems {
+			problems = append(problems, "SIMSTACK_CONFIG: "+p)
+		}
+	}
+	if envPath != "" {
+		var err error
+		if envFileVars, err = readEnvFile(envPath); err != nil {
+			problems = append(problems, "SIMSTACK_ENV_FILE: "+err.Error())
+		}
+	}
+	cfg, err := load(func(key string) (string, bool) {
+		if v, ok := envFileVars[key]; ok {
+			return v, true
+		}
+		// Compose files pass unset variables on as empty ones
+		if v, ok := os.LookupEnv(key); ok && strings.TrimSpace(v) != "" {
+			return v, true
+		}
+		v, ok := fileVars[key]
+		return v, ok
+	})
+	cfg.ConfigFile, cfg.EnvFile = configPath, envPath
+	if len(problems) > 0 {
+		var cfgErr *Error
+		if errors.As(err, &cfgErr) {
+			problems = append(problems, cfgErr.Problems...)
+		}
+		return cfg, &Error{Problems: problems}
+	}
+	return cfg, err
+}
+
+func load(lookup func(string) (string, bool)) (Config, error) {
+	env := &reader{lookup: lookup}
+	// SIMSTACK_DB alone is enough to keep run history in SQLite there
+	runStore, sqlitePath := "memory", "simstack.db"
+	if db := env.str("SIMSTACK_DB", ""); db != "" {
+		runStore, sqlitePath = "sqlite", db
+	}
+	cfg := Config{
+		Addr:           env.str("SIMSTACK_ADDR", ":8080"),
+		CORSOrigins:    env.list("SIMSTACK_CORS_ORIGINS", ""),
+		APIKeys:        env.list("SIMSTACK_API_KEYS", ""),
+		AuthDisabled:   env.boolean("SIMSTACK_AUTH_DISABLED", false),
+		StrictRequests: env.boolean("SIMSTACK_STRICT_REQUESTS", false),
+		MaxBodyBytes:   int64(env.integer("SIMSTACK_MAX_BODY_BYTES", 1<<20)),
+		ShutdownGrace:  env.duration("SIMSTACK_SHUTDOWN_GRACE", 25*time.Second),
+		ShutdownDelay:  env.duration("SIMSTACK_SHUTDOWN_DELAY", 0),
+		LogFormat:      env.str("SIMSTACK_LOG_FORMAT", "text"),
+
+		TLSCert:           env.str("SIMSTACK_TLS_CERT", ""),
+		TLSKey:            env.str("SIMSTACK_TLS_KEY", ""),
+		TLSRedirectAddr:   env.str("SIMSTACK_TLS_REDIRECT_ADDR", ""),
+		TLSReloadInterval: env.duration("SIMSTACK_TLS_RELOAD_INTERVAL", time.Minute),
+
+		SimulatorURLs: map[string]string{
+			"queue":    env.str("QUEUE_SIMULATOR_URL", "http://localhost:8101"),
+			"traffic":  env.str("TRAFFIC_SIMULATOR_URL", "http://localhost:8102"),
+			"resource": env.str("RESOURCE_SIMULATOR_URL", "http://localhost:8103"),
+		},
+		SimulatorBackupURLs: map[string][]string{
+			"queue":    env.list("QUEUE_SIMULATOR_URL_FALLBACK", ""),
+			"traffic":  env.list("TRAFFIC_SIMULATOR_URL_FALLBACK", ""),
+			"resource": env.list("RESOURCE_SIMULATOR_URL_FALLBACK", ""),
+		},
+		SimulatorSecrets: map[string]string{
+			"queue":    env.str("QUEUE_SIMULATOR_SECRET", ""),
+			"traffic":  env.str("TRAFFIC_SIMULATOR_SECRET", ""),
+			"resource": env.str("RESOURCE_SIMULATOR_SECRET", ""),
+		},
+		SimulatorTimeout:          env.duration("SIMULATOR_TIMEOUT", 45*time.Second),
+		VariantTimeout:            env.duration("SIMULATOR_VARIANT_TIMEOUT", 3*time.Minute),
+		SimulatorMaxIdleConns:     env.integer("SIMULATOR_MAX_IDLE_CONNS", 64),
+		SimulatorMaxResponseBytes: int64(env.integer("SIMULATOR_MAX_RESPONSE_BYTES", 1<<20)),
+		BreakerThreshold:          env.integer("SIMULATOR_BREAKER_THRESHOLD", 5),
+		BreakerCooldown:           env.duration("SIMULATOR_BREAKER_COOLDOWN", 30*time.Second),
+		SimulatorWarmup:           env.boolean("SIMULATOR_WARMUP", true),
+		HealthInterval:            env.duration("SIMULATOR_HEALTH_INTERVAL", 10*time.Second),
+		HealthTimeout:             env.duration("SIMULATOR_HEALTH_TIMEOUT", 2*time.Second),
+		HealthSlow:                env.duration("SIMULATOR_HEALTH_SLOW", time.Second),
+		HealthDownAfter:           env.integer("SIMULATOR_HEALTH_DOWN_AFTER", 3),
+		HealthMaxBackoff:          env.duration("SIMULATOR_HEALTH_MAX_BACKOFF", 2*time.Minute),
+		DeepHealthTTL:             env.duration("SIMSTACK_DEEP_HEALTH_TTL", 5*time.Second),
+
+		SimulatorCacheTTL:           env.duration("SIMULATOR_CACHE_TTL", 10*time.Minute),
+		SimulatorCacheMaxEntries:    env.integer("SIMULATOR_CACHE_MAX_ENTRIES", 10000),
+		SimulatorCacheDeterministic: env.list("SIMULATOR_CACHE_DETERMINISTIC", "queue,traffic,resource"),
+
+		LLM:                llm.ConfigFrom(env.get),
+		StrictModel:        env.boolean("LLM_STRICT_MODEL", false),
+		StructuredOutput:   env.boolean("CEREBRAS_STRUCTURED_OUTPUT", true),
+		ModelChain:         env.str("LLM_MODEL_CHAIN", ""),
+		PlanModelChain:     env.str("LLM_PLAN_MODEL_CHAIN", ""),
+		AnalysisModelChain: env.str("LLM_ANALYSIS_MODEL_CHAIN", ""),
+		PlannerModel:       env.str("LLM_PLANNER_MODEL", ""),
+		CriticModel:        env.str("LLM_CRITIC_MODEL", ""),
+		PlannerTemperature: env.float("LLM_PLANNER_TEMPERATURE", 0.7),
+		CriticTemperature:  env.float("LLM_CRITIC_TEMPERATURE", 0.3),
+		ModelTimeout:       env.duration("LLM_MODEL_TIMEOUT", 0),
+		AllowedModels:      env.list("LLM_ALLOWED_MODELS", ""),
+		MaxContinuations:   env.integer("LLM_MAX_CONTINUATIONS", 2),
+		PlanSoftDeadline:   env.duration("LLM_PLAN_SOFT_DEADLINE", 15*time.Second),
+		PlanTimeout:        env.duration("LLM_PLAN_TIMEOUT", 90*time.Second),
+		AnalysisTimeout:    env.duration("LLM_ANALYSIS_TIMEOUT", 60*time.Second),
+		GoalMaxChars:       env.integer("LLM_GOAL_MAX_CHARS", 2000),
+		ConstraintMaxChars: env.integer("LLM_CONSTRAINTS_MAX_CHARS", 2000),
+		TimeBudget:         env.duration("LLM_TIME_BUDGET", 120*time.Second),
+		TokenBudget:        env.integer("LLM_TOKEN_BUDGET", 0),
+
+		Offline:             env.boolean("SIMSTACK_OFFLINE", false),
+		AuditPrompts:        env.boolean("SIMSTACK_AUDIT_PROMPTS", false),
+		LLMLogDir:           env.str("SIMSTACK_LLM_LOG_DIR", ""),
+		Cassette:            env.str("LLM_CASSETTE", ""),
+		CassetteMode:        cassette.Mode(env.str("LLM_CASSETTE_MODE", string(cassette.ModeReplay))),
+		CassettePassThrough: env.boolean("LLM_CASSETTE_PASSTHROUGH", false),
+		CaptureArtifacts:    env.boolean("SIMSTACK_CAPTURE_ARTIFACTS", true),
+		ArtifactMemoryBytes: int64(env.integer("SIMSTACK_ARTIFACT_MEMORY_BYTES", 0)),
+		ArtifactDir:         env.str("SIMSTACK_ARTIFACT_DIR", ""),
+		ArtifactMaxBytes:    int64(env.integer("SIMSTACK_ARTIFACT_MAX_BYTES", 0)),
+		ArtifactDiskBytes:   int64(env.integer("SIMSTACK_ARTIFACT_DISK_BYTES", 1<<30)),
+		ArtifactRetention:   env.duration("SIMSTACK_ARTIFACT_RETENTION", 7*24*time.Hour),
+		ArtifactGCInterval:  env.duration("SIMSTACK_ARTIFACT_GC_INTERVAL", 10*time.Minute),
+		MetricsHistory:      env.integer("SIMSTACK_METRICS_HISTORY", 100),
+		DistributionMetrics: env.integer("SIMSTACK_DISTRIBUTION_METRICS", 8),
+		DistributionBuckets: env.integer("SIMSTACK_DISTRIBUTION_BUCKETS", 0),
+		RunRetention:        env.duration("SIMSTACK_RUN_RETENTION", 0),
+		RunMaxResident:      env.integer("SIMSTACK_RUN_MAX_RESIDENT", 1000),
+		RunReplayGrace:      env.duration("SIMSTACK_RUN_REPLAY_GRACE", 30*time.Second),
+		RunStallTimeout:     env.duration("SIMSTACK_RUN_STALL_TIMEOUT", 5*time.Minute),
+		RunTimeout:          env.duration("SIMSTACK_RUN_TIMEOUT", 10*time.Minute),
+		IdempotencyWindow:   env.duration("SIMSTACK_IDEMPOTENCY_WINDOW", 24*time.Hour),
+		MaxRuns:             env.integer("SIMSTACK_MAX_RUNS", 3),
+		RunQueueDepth:       env.integer("SIMSTACK_RUN_QUEUE_DEPTH", 20),
+		RateLimitRPM:        env.integer("SIMSTACK_RATE_LIMIT_RPM", 30),
+		RateLimitBurst:      env.integer("SIMSTACK_RATE_LIMIT_BURST", 10),
+		ExportCPUs:          env.float("SIMSTACK_EXPORT_CPUS", 0.5),
+		ExportMemoryBytes:   int64(env.integer("SIMSTACK_EXPORT_MEMORY_BYTES", 256<<20)),
+		RunStore:            env.str("SIMSTACK_RUN_STORE", runStore),
+		SQLitePath:          env.str("SIMSTACK_SQLITE_PATH", sqlitePath),
+		PostgresDSN:         env.str("SIMSTACK_POSTGRES_DSN", ""),
+		RedisURL:            env.str("SIMSTACK_REDIS_URL", ""),
+
+		SlackWebhookURL:    env.str("SIMSTACK_SLACK_WEBHOOK_URL", ""),
+		DiscordWebhookURL:  env.str("SIMSTACK_DISCORD_WEBHOOK_URL", ""),
+		NotifyTemplate:     env.str("SIMSTACK_NOTIFY_TEMPLATE", ""),
+		NotifyRetries:      env.integer("SIMSTACK_NOTIFY_RETRIES", 3),
+		NotifyAllowedHosts: env.list("SIMSTACK_NOTIFY_ALLOWED_HOSTS", ""),
+		PublicURL:          env.str("SIMSTACK_PUBLIC_URL", "http://localhost:8080"),
+
+		Tracing: tracing.Config{
+			Endpoint:    env.str("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", ""),
+			ServiceName: env.str("OTEL_SERVICE_NAME", "simstack-backend"),
+			SampleRatio: env.float("OTEL_TRACES_SAMPLER_ARG", 1),
+		},
+		Statsd: statsd.Config{
+			Addr:           env.str("SIMSTACK_STATSD_ADDR", ""),
+			Prefix:         env.str("SIMSTACK_STATSD_PREFIX", "simstack."),
+			Tags:           env.boolean("SIMSTACK_STATSD_TAGS", true),
+			FlushInterval:  env.duration("SIMSTACK_STATSD_FLUSH_INTERVAL", time.Second),
+			MaxPacketBytes: env.integer("SIMSTACK_STATSD_MAX_PACKET_BYTES", statsd.DefaultMaxPacketBytes),
+		},
+	}
+	// Any origin may call a backend only reachable from this machine
+	if cfg.CORSOrigins == nil && loopback(cfg.Addr) {
+		cfg.CORSOrigins = []string{"*"}
+	}
+	// Browsers send a page's http(s) origin, WebSockets included, so a
+	// ws:// or wss:// origin means the matching http:// or https:// one
+	for i, origin := range cfg.CORSOrigins {
+		if rest, ok := strings.CutPrefix(origin, "wss://"); ok {
+			cfg.CORSOrigins[i] = "https://" + rest
+		} else if rest, ok := strings.CutPrefix(origin, "ws://"); ok {
+			cfg.CORSOrigins[i] = "http://" + rest
+		}
+	}
+	if path := env.str("SIMSTACK_API_KEYS_FILE", ""); path != "" {
+		keys, err := readKeysFile(path)
+		if err != nil {
+			env.problems = append(env.problems, "SIMSTACK_API_KEYS_FILE: "+err.Error())
+		}
+		cfg.APIKeys = append(cfg.APIKeys, keys...)
+	}
+	// The general endpoint is a base URL; the signal path goes after it
+	if base := env.str("OTEL_EXPORTER_OTLP_ENDPOINT", ""); cfg.Tracing.Endpoint == "" && base != "" {
+		cfg.Tracing.Endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+	}
+	// The SDK's own switches turn tracing off whatever the endpoint. Of the
+	// exporters listed only otlp exists here; the others are left to a
+	// startup warning rather than refused
+	otlp := false
+	for _, exporter := range env.list("OTEL_TRACES_EXPORTER", "otlp") {
+		switch exporter = strings.ToLower(exporter); exporter {
+		case "otlp":
+			otlp = true
+		case "none":
+		default:
+			cfg.Tracing.Unsupported = append(cfg.Tracing.Unsupported, exporter)
+		}
+	}
+	if !otlp {
+		cfg.Tracing.Endpoint = ""
+	}
+	if env.boolean("OTEL_SDK_DISABLED", false) {
+		cfg.Tracing.Endpoint = ""
+	}
+	// llm.ConfigFrom ignores malformed numbers; read them again to report them
+	cfg.LLM.RPM = env.integer("LLM_RPM", 0)
+	cfg.LLM.Burst = env.integer("LLM_BURST", 0)
+	cfg.LLM.MaxConcurrent = env.integer("LLM_MAX_CONCURRENT", 0)
+	cfg.LLM.Ceiling = env.duration("LLM_CLIENT_CEILING", 0)
+	cfg.LLM.Offline = cfg.Offline
+	table, err := pricing.Parse(env.str("LLM_PRICING", ""), pricing.Default())
+	if err != nil {
+		env.problems = append(env.problems, "LLM_PRICING: "+err.Error())
+		table = pricing.Default()
+	}
+	cfg.Pricing = table
+
+	problems := append(env.problems, cfg.Validate()...)
+	if len(problems) > 0 {
+		return cfg, &Error{Problems: problems}
+	}
+	return cfg, nil
+}
+
+// minSecretLen is the shortest simulator signing secret accepted.
+const minSecretLen = 16
+
+// Validate checks the settings against each other and for sane ranges,
+// returning every problem found.
+func (c Config) Validate() []string {
+	var problems []string
+	fail := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if c.Addr == "" {
+		fail("SIMSTACK_ADDR is empty")
+	}
+	if c.MaxBodyBytes < 0 {
+		fail("SIMSTACK_MAX_BODY_BYTES must not be negative, got %d", c.MaxBodyBytes)
+	}
+	if c.ShutdownGrace < 0 {
+		fail("SIMSTACK_SHUTDOWN_GRACE must not be negative, got %s", c.ShutdownGrace)
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		fail("SIMSTACK_TLS_CERT and SIMSTACK_TLS_KEY must be set together")
+	}
+	if c.TLSRedirectAddr != "" && c.TLSCert == "" {
+		fail("SIMSTACK_TLS_REDIRECT_ADDR needs SIMSTACK_TLS_CERT and SIMSTACK_TLS_KEY")
+	}
+	if c.TLSReloadInterval < 0 {
+		fail("SIMSTACK_TLS_RELOAD_INTERVAL must not be negative, got %s", c.TLSReloadInterval)
+	}
+	if c.ShutdownDelay < 0 {
+		fail("SIMSTACK_SHUTDOWN_DELAY must not be negative, got %s", c.ShutdownDelay)
+	}
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		fail("SIMSTACK_LOG_FORMAT must be text or json, got %q", c.LogFormat)
+	}
+	for _, origin := range c.CORSOrigins {
+		if origin != "*" && !isHTTPURL(origin) && !isOriginPattern(origin) {
+			fail("SIMSTACK_CORS_ORIGINS: %q is not \"*\", an http(s) or ws(s) origin or a *.domain pattern", origin)
+		}
+	}
+	// Never name the key itself
+	for _, key := range c.APIKeys {
+		if len(key) < minSecretLen || !isToken(key) {
+			fail("SIMSTACK_API_KEYS: every key must be at least %d characters of letters, digits and !#$%%&'*+-.^_`|~", minSecretLen)
+			break
+		}
+	}
+	for _, tool := range []string{"queue", "traffic", "resource"} {
+		if u := c.SimulatorURLs[tool]; !isHTTPURL(u) {
+			fail("%s_SIMULATOR_URL: %q is not an http(s) URL", strings.ToUpper(tool), u)
+		}
+		for _, u := range c.SimulatorBackupURLs[tool] {
+			if !isHTTPURL(u) {
+				fail("%s_SIMULATOR_URL_FALLBACK: %q is not an http(s) URL", strings.ToUpper(tool), u)
+			}
+		}
+		if s := c.SimulatorSecrets[tool]; s != "" && len(s) < minSecretLen {
+			fail("%s_SIMULATOR_SECRET must be at least %d characters", strings.ToUpper(tool), minSecretLen)
+		}
+	}
+	if c.SimulatorTimeout <= 0 {
+		fail("SIMULATOR_TIMEOUT must be positive, got %s", c.SimulatorTimeout)
+	}
+	if c.VariantTimeout < c.SimulatorTimeout {
+		fail("SIMULATOR_VARIANT_TIMEOUT (%s) must be at least SIMULATOR_TIMEOUT (%s)", c.VariantTimeout, c.SimulatorTimeout)
+	}
+	if c.SimulatorMaxIdleConns < 1 || c.SimulatorMaxIdleConns > 10000 {
+		fail("SIMULATOR_MAX_IDLE_CONNS must be between 1 and 10000, got %d", c.SimulatorMaxIdleConns)
+	}
+	if c.SimulatorMaxResponseBytes < 1 {
+		fail("SIMULATOR_MAX_RESPONSE_BYTES must be positive, got %d", c.SimulatorMaxResponseBytes)
+	}
+	if c.BreakerThreshold < 0 {
+		fail("SIMULATOR_BREAKER_THRESHOLD must not be negative, got %d", c.BreakerThreshold)
+	}
+	if c.BreakerCooldown <= 0 {
+		fail("SIMULATOR_BREAKER_COOLDOWN must be positive, got %s", c.BreakerCooldown)
+	}
+	if c.HealthInterval < 0 {
+		fail("SIMULATOR_HEALTH_INTERVAL must not be negative, got %s", c.HealthInterval)
+	}
+	if c.HealthInterval > 0 {
+		if c.HealthTimeout <= 0 {
+			fail("SIMULATOR_HEALTH_TIMEOUT must be positive, got %s", c.HealthTimeout)
+		}
+		if c.HealthSlow < 0 {
+			fail("SIMULATOR_HEALTH_SLOW must not be negative, got %s", c.HealthSlow)
+		}
+		if c.HealthDownAfter < 1 {
+			fail("SIMULATOR_HEALTH_DOWN_AFTER must be at least 1, got %d", c.HealthDownAfter)
+		}
+		if c.HealthMaxBackoff < c.HealthInterval {
+			fail("SIMULATOR_HEALTH_MAX_BACKOFF must be at least SIMULATOR_HEALTH_INTERVAL, got %s", c.HealthMaxBackoff)
+		}
+	}
+	if c.DeepHealthTTL < 0 {
+		fail("SIMSTACK_DEEP_HEALTH_TTL must not be negative, got %s", c.DeepHealthTTL)
+	}
+	if c.SimulatorCacheTTL < 0 {
+		fail("SIMULATOR_CACHE_TTL must not be negative, got %s", c.SimulatorCacheTTL)
+	}
+	if c.SimulatorCacheMaxEntries < 0 {
+		fail("SIMULATOR_CACHE_MAX_ENTRIES must not be negative, got %d", c.SimulatorCacheMaxEntries)
+	}
+
+	if !llm.KnownProvider(c.LLM.Provider) && c.LLM.BaseURL == "" {
+		fail("LLM_PROVIDER %q needs LLM_API_BASE", c.LLM.Provider)
+	}
+	if c.LLM.BaseURL != "" && !isHTTPURL(c.LLM.BaseURL) {
+		fail("LLM_API_BASE: %q is not an http(s) URL", c.LLM.BaseURL)
+	}
+	if c.LLM.APIKey == "" && c.needsAPIKey() {
+		fail("LLM_API_KEY (or CEREBRAS_API_KEY) is required unless SIMSTACK_OFFLINE=true")
+	}
+	if c.LLM.Model == "" {
+		fail("LLM_MODEL is empty and provider %q has no default", c.LLM.Provider)
+	}
+	for _, n := range []struct {
+		key   string
+		value int
+	}{
+		{"LLM_RPM", c.LLM.RPM},
+		{"LLM_BURST", c.LLM.Burst},
+		{"LLM_MAX_CONCURRENT", c.LLM.MaxConcurrent},
+		{"LLM_TOKEN_BUDGET", c.TokenBudget},
+	} {
+		if n.value < 0 {
+			fail("%s must not be negative, got %d", n.key, n.value)
+		}
+	}
+	for _, m := range []struct{ key, model string }{
+		{"LLM_PLANNER_MODEL", c.PlannerModel},
+		{"LLM_CRITIC_MODEL", c.CriticModel},
+	} {
+		if m.model != "" && len(c.AllowedModels) > 0 && !slices.Contains(c.AllowedModels, m.model) {
+			fail("%s %q is not in LLM_ALLOWED_MODELS", m.key, m.model)
+		}
+	}
+	for _, t := range []struct {
+		key   string
+		value float64
+	}{
+		{"LLM_PLANNER_TEMPERATURE", c.PlannerTemperature},
+		{"LLM_CRITIC_TEMPERATURE", c.CriticTemperature},
+	} {
+		if t.value < 0 || t.value > 2 {
+			fail("%s must be between 0 and 2, got %g", t.key, t.value)
+		}
+	}
+	if c.MaxContinuations < 0 || c.MaxContinuations > 10 {
+		fail("LLM_MAX_CONTINUATIONS must be between 0 and 10, got %d", c.MaxContinuations)
+	}
+	if c.PlanSoftDeadline < 0 {
+		fail("LLM_PLAN_SOFT_DEADLINE must not be negative, got %s", c.PlanSoftDeadline)
+	}
+	if c.PlanTimeout <= 0 {
+		fail("LLM_PLAN_TIMEOUT must be positive, got %s", c.PlanTimeout)
+	} else if c.PlanSoftDeadline >= c.PlanTimeout {
+		fail("LLM_PLAN_SOFT_DEADLINE (%s) must be less than LLM_PLAN_TIMEOUT (%s)", c.PlanSoftDeadline, c.PlanTimeout)
+	}
+	if c.AnalysisTimeout <= 0 {
+		fail("LLM_ANALYSIS_TIMEOUT must be positive, got %s", c.AnalysisTimeout)
+	}
+	if c.GoalMaxChars < 1 {
+		fail("LLM_GOAL_MAX_CHARS must be at least 1, got %d", c.GoalMaxChars)
+	}
+	if c.ConstraintMaxChars < 1 {
+		fail("LLM_CONSTRAINTS_MAX_CHARS must be at least 1, got %d", c.ConstraintMaxChars)
+	}
+	if c.ModelTimeout < 0 {
+		fail("LLM_MODEL_TIMEOUT must not be negative, got %s", c.ModelTimeout)
+	}
+	for _, p := range c.Pricing.Validate() {
+		fail("LLM_PRICING: %s", p)
+	}
+	if c.TimeBudget < 0 {
+		fail("LLM_TIME_BUDGET must not be negative, got %s", c.TimeBudget)
+	}
+	if c.LLM.Ceiling < 0 {
+		fail("LLM_CLIENT_CEILING must not be negative, got %s", c.LLM.Ceiling)
+	}
+
+	if c.Cassette != "" && c.CassetteMode != cassette.ModeRecord && c.CassetteMode != cassette.ModeReplay {
+		fail("LLM_CASSETTE_MODE must be %q or %q, got %q", cassette.ModeRecord, cassette.ModeReplay, c.CassetteMode)
+	}
+	if c.MetricsHistory < 1 || c.MetricsHistory > 100000 {
+		fail("SIMSTACK_METRICS_HISTORY must be between 1 and 100000, got %d", c.MetricsHistory)
+	}
+	if c.DistributionMetrics < 0 {
+		fail("SIMSTACK_DISTRIBUTION_METRICS must not be negative, got %d", c.DistributionMetrics)
+	}
+	if c.DistributionBuckets < 0 || c.DistributionBuckets > metrics.MaxBuckets {
+		fail("SIMSTACK_DISTRIBUTION_BUCKETS must be between 0 and %d, got %d", metrics.MaxBuckets, c.DistributionBuckets)
+	}
+	// The URLs are credentials, so they aren't repeated
+	if c.SlackWebhookURL != "" && !isHTTPURL(c.SlackWebhookURL) {
+		fail("SIMSTACK_SLACK_WEBHOOK_URL must be an http(s) URL")
+	}
+	if c.DiscordWebhookURL != "" && !isHTTPURL(c.DiscordWebhookURL) {
+		fail("SIMSTACK_DISCORD_WEBHOOK_URL must be an http(s) URL")
+	}
+	if _, err := notify.ParseTemplate(c.NotifyTemplate); err != nil {
+		fail("SIMSTACK_NOTIFY_TEMPLATE is invalid: %v", err)
+	}
+	if c.NotifyRetries < 0 {
+		fail("SIMSTACK_NOTIFY_RETRIES must not be negative, got %d", c.NotifyRetries)
+	}
+	if !isHTTPURL(c.PublicURL) {
+		fail("SIMSTACK_PUBLIC_URL must be an http(s) URL, got %q", c.PublicURL)
+	}
+	if c.RunRetention < 0 {
+		fail("SIMSTACK_RUN_RETENTION must not be negative, got %s", c.RunRetention)
+	}
+	if c.RunMaxResident < 0 {
+		fail("SIMSTACK_RUN_MAX_RESIDENT must not be negative, got %d", c.RunMaxResident)
+	}
+	if c.RunReplayGrace < 0 {
+		fail("SIMSTACK_RUN_REPLAY_GRACE must not be negative, got %s", c.RunReplayGrace)
+	}
+	if c.RunStallTimeout < 0 {
+		fail("SIMSTACK_RUN_STALL_TIMEOUT must not be negative, got %s", c.RunStallTimeout)
+	}
+	if c.RunTimeout <= 0 {
+		fail("SIMSTACK_RUN_TIMEOUT must be positive, got %s", c.RunTimeout)
+	} else if phases := c.PlanTimeout + c.AnalysisTimeout; c.RunTimeout <= phases {
+		fail("SIMSTACK_RUN_TIMEOUT (%s) must be longer than LLM_PLAN_TIMEOUT and LLM_ANALYSIS_TIMEOUT together (%s)", c.RunTimeout, phases)
+	}
+	if c.IdempotencyWindow < 0 {
+		fail("SIMSTACK_IDEMPOTENCY_WINDOW must not be negative, got %s", c.IdempotencyWindow)
+	}
+	if c.MaxRuns < 1 {
+		fail("SIMSTACK_MAX_RUNS must be at least 1, got %d", c.MaxRuns)
+	}
+	if c.RunQueueDepth < 0 {
+		fail("SIMSTACK_RUN_QUEUE_DEPTH must not be negative, got %d", c.RunQueueDepth)
+	}
+	if c.RateLimitRPM < 0 {
+		fail("SIMSTACK_RATE_LIMIT_RPM must not be negative, got %d", c.RateLimitRPM)
+	}
+	if c.RateLimitBurst < 0 {
+		fail("SIMSTACK_RATE_LIMIT_BURST must not be negative, got %d", c.RateLimitBurst)
+	}
+	if c.ExportCPUs <= 0 {
+		fail("SIMSTACK_EXPORT_CPUS must be positive, got %g", c.ExportCPUs)
+	}
+	// Docker refuses smaller memory limits
+	if c.ExportMemoryBytes < 6<<20 {
+		fail("SIMSTACK_EXPORT_MEMORY_BYTES must be at least %d, got %d", 6<<20, c.ExportMemoryBytes)
+	}
+	if c.ArtifactMemoryBytes < 0 {
+		fail("SIMSTACK_ARTIFACT_MEMORY_BYTES must not be negative, got %d", c.ArtifactMemoryBytes)
+	}
+	if c.ArtifactDir != "" {
+		if info, err := os.Stat(c.ArtifactDir); err == nil && !info.IsDir() {
+			fail("SIMSTACK_ARTIFACT_DIR: %q is not a directory", c.ArtifactDir)
+		}
+		if c.ArtifactMaxBytes < 0 || c.ArtifactMaxBytes > artifacts.MaxSize {
+			fail("SIMSTACK_ARTIFACT_MAX_BYTES must be between 0 and %d, got %d", artifacts.MaxSize, c.ArtifactMaxBytes)
+		}
+		if c.ArtifactDiskBytes < 1 {
+			fail("SIMSTACK_ARTIFACT_DISK_BYTES must be positive, got %d", c.ArtifactDiskBytes)
+		}
+		if c.ArtifactRetention < 0 {
+			fail("SIMSTACK_ARTIFACT_RETENTION must not be negative, got %s", c.ArtifactRetention)
+		}
+		if c.ArtifactGCInterval < time.Second {
+			fail("SIMSTACK_ARTIFACT_GC_INTERVAL must be at least 1s, got %s", c.ArtifactGCInterval)
+		}
+	}
+	switch c.RunStore {
+	case "memory":
+	case "sqlite":
+		if info, err := os.Stat(filepath.Dir(c.SQLitePath)); err != nil || !info.IsDir() {
+			fail("SIMSTACK_SQLITE_PATH: directory of %q does not exist", c.SQLitePath)
+		} else if info, err := os.Stat(c.SQLitePath); err == nil && info.IsDir() {
+			fail("SIMSTACK_SQLITE_PATH: %q is a directory", c.SQLitePath)
+		}
+	case "postgres":
+		if c.PostgresDSN == "" {
+			fail("SIMSTACK_POSTGRES_DSN is required when SIMSTACK_RUN_STORE=postgres")
+		}
+	default:
+		fail("SIMSTACK_RUN_STORE must be \"memory\", \"sqlite\" or \"postgres\", got %q", c.RunStore)
+	}
+	// The URL may hold a password, so it isn't repeated
+	if u, err := url.Parse(c.RedisURL); c.RedisURL != "" && (err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") || u.Hostname() == "") {
+		fail("SIMSTACK_REDIS_URL must be a redis:// or rediss:// URL")
+	}
+	if c.Tracing.Endpoint != "" && !isHTTPURL(c.Tracing.Endpoint) {
+		fail("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT: %q is not an http(s) URL", c.Tracing.Endpoint)
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		fail("OTEL_TRACES_SAMPLER_ARG must be between 0 and 1, got %g", c.Tracing.SampleRatio)
+	}
+	if c.Statsd.Addr != "" {
+		if _, _, err := net.SplitHostPort(c.Statsd.Addr); err != nil {
+			fail("SIMSTACK_STATSD_ADDR must be host:port, got %q", c.Statsd.Addr)
+		}
+	}
+	if c.Statsd.FlushInterval <= 0 {
+		fail("SIMSTACK_STATSD_FLUSH_INTERVAL must be positive, got %s", c.Statsd.FlushInterval)
+	}
+	// The most a UDP datagram carries
+	if c.Statsd.MaxPacketBytes < 64 || c.Statsd.MaxPacketBytes > 65507 {
+		fail("SIMSTACK_STATSD_MAX_PACKET_BYTES must be between 64 and 65507, got %d", c.Statsd.MaxPacketBytes)
+	}
+	return problems
+}
+
+// needsAPIKey reports whether the configuration will send authenticated
+// requests to a hosted provider.
+func (c Config) needsAPIKey() bool {
+	if c.Offline || c.LLM.Provider == "ollama" {
+		return false
+	}
+	// Replayed cassettes answer without reaching the provider
+	return c.Cassette == "" || c.CassetteMode != cassette.ModeReplay || c.CassettePassThrough
+}
+
+// isOriginPattern reports whether s is a subdomain wildcard such as
+// "*.example.com" or "https://*.example.com".
+func isOriginPattern(s string) bool {
+	scheme, host, found := strings.Cut(s, "://")
+	if !found {
+		scheme, host = "", s
+	}
+	if scheme != "" && scheme != "http" && scheme != "https" {
+		return false
+	}
+	domain, ok := strings.CutPrefix(host, "*.")
+	return ok && domain != "" && !strings.ContainsAny(domain, "*/")
+}
+
+// loopback reports whether the listen address addr only takes connections
+// from this machine.
+func loopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// reader reads variables, treating empty values as unset and collecting a
+// problem for every malformed one.
+type reader struct {
+	lookup   func(string) (string, bool)
+	problems []string
+}
+
+func (r *reader) get(key string) string {
+	v, _ := r.lookup(key)
+	return v
+}
+
+func (r *reader) str(key, def string) string {
+	if v := strings.TrimSpace(r.get(key)); v != "" {
+		return v
+	}
+	return def
+}
+
+func (r *reader) boolean(key string, def bool) bool {
+	v := r.str(key, "")
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		r.problems = append(r.problems, fmt.Sprintf("%s: %q is not true or false", key, v))
+		return def
+	}
+	return b
+}
+
+func (r *reader) integer(key string, def int) int {
+	v := r.str(key, "")
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		r.problems = append(r.problems, fmt.Sprintf("%s: %q is not an integer", key, v))
+		return def
+	}
+	return n
+}
+
+func (r *reader) duration(key string, def time.Duration) time.Duration {
+	v := r.str(key, "")
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		r.problems = append(r.problems, fmt.Sprintf("%s: %q is not a duration like 30s or 2m", key, v))
+		return def
+	}
+	return d
+}
+
+func (r *reader) float(key string, def float64) float64 {
+	v := r.str(key, "")
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		r.problems = append(r.problems, fmt.Sprintf("%s: %q is not a number", key, v))
+		return def
+	}
+	return f
+}
+
+// list reads a comma-separated list, dropping empty entries.
+func (r *reader) list(key, def string) []string {
+	var out []string
+	for _, part := range strings.Split(r.str(key, def), ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
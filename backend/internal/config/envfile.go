@@ -0,0 +1,55 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// readEnvFile reads KEY=VALUE lines as .env files write them: blank lines and
+// # comments are skipped, an "export " prefix is allowed, single-quoted
+// values are literal and double-quoted ones take \\ \" \$ \` \n and \r
+// escapes.
+func readEnvFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	vars := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", path, n)
+		}
+		value, err := envFileValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s: %w", path, n, key, err)
+		}
+		vars[key] = value
+	}
+	return vars, scanner.Err()
+}
+
+var envFileEscapes = strings.NewReplacer(`\\`, `\`, `\"`, `"`, `\$`, "$", "\\`", "`", `\n`, "\n", `\r`, "\r")
+
+func envFileValue(v string) (string, error) {
+	if v == "" || (v[0] != '\'' && v[0] != '"') {
+		return v, nil
+	}
+	if len(v) < 2 || v[len(v)-1] != v[0] {
+		return "", fmt.Errorf("unterminated quote")
+	}
+	if v[0] == '\'' {
+		return v[1 : len(v)-1], nil
+	}
+	return envFileEscapes.Replace(v[1 : len(v)-1]), nil
+}
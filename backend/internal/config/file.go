@@ -0,0 +1,152 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fileSections are the top-level keys of a config file and the prefix of
+// the variables their settings stand for. The simulators section is keyed
+// by simulator name instead.
+var fileSections = map[string]string{
+	"simstack":  "SIMSTACK_",
+	"simulator": "SIMULATOR_",
+	"llm":       "LLM_",
+	"cerebras":  "CEREBRAS_",
+	"otel":      "OTEL_",
+}
+
+// readConfigFile reads a YAML file, or JSON, whose settings stand for the
+// variables named by their path: a section's prefix, then each key upper
+// cased and joined with underscores, so that
+//
+//	simstack:
+//	  tls: {cert: /etc/tls/cert.pem}
+//	simulators:
+//	  queue: {url: "http://queue:8101", secret: ...}
+//	llm:
+//	  plan_timeout: 90s
+//
+// sets SIMSTACK_TLS_CERT, QUEUE_SIMULATOR_URL, QUEUE_SIMULATOR_SECRET and
+// LLM_PLAN_TIMEOUT. Lists are joined with commas, and null leaves a
+// variable unset. Every key that names no setting is a problem.
+func readConfigFile(path string) (map[string]string, []string) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, []string{err.Error()}
+	}
+	defer f.Close()
+	var doc yaml.Node
+	if err := yaml.NewDecoder(f).Decode(&doc); err != nil {
+		if errors.Is(err, io.EOF) {
+			return map[string]string{}, nil
+		}
+		return nil, []string{err.Error()}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, []string{fmt.Sprintf("expected sections of settings, got a %s", kindName(root))}
+	}
+
+	vars, paths := map[string]string{}, map[string]string{}
+	var problems []string
+	for i := 0; i < len(root.Content); i += 2 {
+		section, value := root.Content[i].Value, root.Content[i+1]
+		if prefix, ok := fileSections[section]; ok {
+			problems = append(problems, flatten(value, section, prefix, vars, paths)...)
+			continue
+		}
+		if section != "simulators" {
+			problems = append(problems, fmt.Sprintf("line %d: unknown section %q", root.Content[i].Line, section))
+			continue
+		}
+		if value.Kind != yaml.MappingNode {
+			problems = append(problems, fmt.Sprintf("line %d: simulators: expected settings per simulator name", value.Line))
+			continue
+		}
+		for j := 0; j < len(value.Content); j += 2 {
+			name := value.Content[j].Value
+			problems = append(problems, flatten(value.Content[j+1], "simulators."+name, envName(name)+"_SIMULATOR_", vars, paths)...)
+		}
+	}
+
+	known := knownVariables()
+	for key := range vars {
+		if !known[key] {
+			problems = append(problems, fmt.Sprintf("%s (%s) isn't a setting", paths[key], key))
+		}
+	}
+	sort.Strings(problems)
+	return vars, problems
+}
+
+// flatten adds the variables node sets under prefix to vars, and its key
+// path, such as "llm.plan_timeout", to paths.
+func flatten(node *yaml.Node, path, prefix string, vars, paths map[string]string) []string {
+	name := strings.TrimSuffix(prefix, "_")
+	if node.Kind == yaml.ScalarNode || node.Kind == yaml.SequenceNode {
+		paths[name] = path
+	}
+	switch node.Kind {
+	case yaml.AliasNode:
+		return flatten(node.Alias, path, prefix, vars, paths)
+	case yaml.ScalarNode:
+		if node.Tag != "!!null" {
+			vars[name] = node.Value
+		}
+		return nil
+	case yaml.SequenceNode:
+		items := make([]string, 0, len(node.Content))
+		for _, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				return []string{fmt.Sprintf("line %d: %s: expected a list of values", node.Line, path)}
+			}
+			items = append(items, item.Value)
+		}
+		vars[name] = strings.Join(items, ",")
+		return nil
+	case yaml.MappingNode:
+		var problems []string
+		for i := 0; i < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			problems = append(problems, flatten(node.Content[i+1], path+"."+key, prefix+envName(key)+"_", vars, paths)...)
+		}
+		return problems
+	}
+	return []string{fmt.Sprintf("line %d: %s: unexpected %s", node.Line, path, kindName(node))}
+}
+
+// envName is key as a variable name: upper case, dashes as underscores.
+func envName(key string) string {
+	return strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+}
+
+func kindName(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return "value"
+	case yaml.SequenceNode:
+		return "list"
+	case yaml.MappingNode:
+		return "mapping"
+	}
+	return "document"
+}
+
+// knownVariables are the variables load reads, found by loading once with
+// none set.
+var knownVariables = sync.OnceValue(func() map[string]bool {
+	known := map[string]bool{}
+	_, _ = load(func(key string) (string, bool) {
+		known[key] = true
+		return "", false
+	})
+	return known
+})
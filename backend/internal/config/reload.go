@@ -0,0 +1,235 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+)
+
+// hot are the settings a running backend takes on reload, by field path.
+// Everything else is read once at startup and needs a restart.
+var hot = map[string]bool{
+	"CORSOrigins":         true,
+	"APIKeys":             true,
+	"SimulatorURLs":       true,
+	"SimulatorBackupURLs": true,
+	"SimulatorSecrets":    true,
+	"SimulatorTimeout":    true,
+	"VariantTimeout":      true,
+	"SimulatorWarmup":     true,
+	"PlanSoftDeadline":    true,
+	"PlanTimeout":         true,
+	"AnalysisTimeout":     true,
+	"RunTimeout":          true,
+	"DistributionMetrics": true,
+	"DistributionBuckets": true,
+	"SlackWebhookURL":     true,
+	"DiscordWebhookURL":   true,
+	"NotifyTemplate":      true,
+	"NotifyRetries":       true,
+	"NotifyAllowedHosts":  true,
+	"LLM.RPM":             true,
+	"LLM.Burst":           true,
+	"LLM.MaxConcurrent":   true,
+	"ExportCPUs":          true,
+	"ExportMemoryBytes":   true,
+	"IdempotencyWindow":   true,
+	"DeepHealthTTL":       true,
+}
+
+// secret are the settings whose values reload reports and Settings don't
+// show.
+var secret = map[string]bool{
+	"APIKeys":     true,
+	"LLM.APIKey":  true,
+	"LLM.Headers": true,
+	"PostgresDSN": true,
+	// May carry a Redis password
+	"RedisURL": true,
+	// Simulator signing secrets
+	"SimulatorSecrets": true,
+	// Webhook URLs carry their credentials in the path
+	"SlackWebhookURL":   true,
+	"DiscordWebhookURL": true,
+	// Gateways may take their key as a query parameter
+	"LLM.QueryParams": true,
+}
+
+// Hot reports whether a running backend can take a change to setting, a
+// field path such as "LLM.RPM", without a restart.
+func Hot(setting string) bool {
+	return hot[setting]
+}
+
+// Change is a setting that differs between two configurations.
+type Change struct {
+	// Field path, e.g. "SimulatorURLs" or "LLM.RPM"
+	Setting string `json:"setting"`
+	Old     string `json:"old"`
+	New     string `json:"new"`
+	// Why a change wasn't applied
+	Reason string `json:"reason,omitempty"`
+}
+
+// Report is what a reload did with each changed setting.
+type Report struct {
+	Applied  []Change `json:"applied"`
+	Rejected []Change `json:"rejected"`
+}
+
+func (r Report) String() string {
+	if len(r.Applied)+len(r.Rejected) == 0 {
+		return "configuration unchanged"
+	}
+	var b strings.Builder
+	for _, c := range r.Applied {
+		fmt.Fprintf(&b, "applied %s: %s -> %s\n", c.Setting, c.Old, c.New)
+	}
+	for _, c := range r.Rejected {
+		fmt.Fprintf(&b, "not applied %s: %s -> %s (%s)\n", c.Setting, c.Old, c.New, c.Reason)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// Diff lists the settings that differ between old and next, in
+// Config field order. Secret values are masked.
+func Diff(old, next Config) []Change {
+	var changes []Change
+	diff(reflect.ValueOf(old), reflect.ValueOf(next), "", &changes)
+	return changes
+}
+
+func diff(old, next reflect.Value, prefix string, changes *[]Change) {
+	for i := 0; i < old.NumField(); i++ {
+		field := old.Type().Field(i)
+		path := prefix + field.Name
+		o, n := old.Field(i), next.Field(i)
+		switch field.Type.Kind() {
+		case reflect.Struct:
+			diff(o, n, path+".", changes)
+			continue
+		case reflect.Interface, reflect.Func:
+			// Set by code rather than the environment
+			continue
+		}
+		if reflect.DeepEqual(o.Interface(), n.Interface()) {
+			continue
+		}
+		c := Change{Setting: path, Old: fmt.Sprint(o.Interface()), New: fmt.Sprint(n.Interface())}
+		if secret[path] {
+			c.Old, c.New = "(hidden)", "(hidden)"
+		}
+		*changes = append(*changes, c)
+	}
+}
+
+// Setting is one setting's value in effect.
+type Setting struct {
+	// Field path, e.g. "SimulatorURLs" or "LLM.RPM"
+	Setting string `json:"setting"`
+	Value   string `json:"value"`
+}
+
+// Settings lists every setting of c in Config field order, as Diff shows
+// them: secrets that are set read "(hidden)".
+func Settings(c Config) []Setting {
+	var out []Setting
+	settings(reflect.ValueOf(c), "", &out)
+	return out
+}
+
+func settings(v reflect.Value, prefix string, out *[]Setting) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		path := prefix + field.Name
+		f := v.Field(i)
+		switch field.Type.Kind() {
+		case reflect.Struct:
+			settings(f, path+".", out)
+			continue
+		case reflect.Interface, reflect.Func:
+			continue
+		}
+		s := Setting{Setting: path, Value: fmt.Sprint(f.Interface())}
+		if secret[path] && !f.IsZero() {
+			s.Value = "(hidden)"
+		}
+		*out = append(*out, s)
+	}
+}
+
+// Secrets returns the values of c's secret settings, the ones Settings
+// hides, for scrubbing from anything kept: each string, list item and map
+// value, and the password of any that is a URL with one.
+func Secrets(c Config) []string {
+	var out []string
+	add := func(s string) {
+		if s == "" {
+			return
+		}
+		out = append(out, s)
+		if u, err := url.Parse(s); err == nil && u.User != nil {
+			if password, ok := u.User.Password(); ok && password != "" {
+				out = append(out, password)
+			}
+		}
+	}
+	secrets(reflect.ValueOf(c), "", add)
+	return out
+}
+
+func secrets(v reflect.Value, prefix string, add func(string)) {
+	for i := 0; i < v.NumField(); i++ {
+		path := prefix + v.Type().Field(i).Name
+		f := v.Field(i)
+		switch {
+		case f.Kind() == reflect.Struct:
+			secrets(f, path+".", add)
+		case !secret[path]:
+		case f.Kind() == reflect.String:
+			add(f.String())
+		case f.Kind() == reflect.Slice && f.Type().Elem().Kind() == reflect.String:
+			for j := 0; j < f.Len(); j++ {
+				add(f.Index(j).String())
+			}
+		case f.Kind() == reflect.Map && f.Type().Elem().Kind() == reflect.String:
+			for _, k := range f.MapKeys() {
+				add(f.MapIndex(k).String())
+			}
+		}
+	}
+}
+
+// Compare sorts the changes from old to next into those a running backend
+// applies and those that need a restart.
+func Compare(old, next Config) Report {
+	r := Report{Applied: []Change{}, Rejected: []Change{}}
+	for _, c := range Diff(old, next) {
+		if Hot(c.Setting) {
+			r.Applied = append(r.Applied, c)
+		} else {
+			c.Reason = "requires a restart"
+			r.Rejected = append(r.Rejected, c)
+		}
+	}
+	return r
+}
+
+// Apply returns active with next's hot settings; everything else keeps its
+// active value.
+func Apply(active, next Config) Config {
+	active.CORSOrigins = next.CORSOrigins
+	active.APIKeys = next.APIKeys
+	active.SimulatorURLs = next.SimulatorURLs
+	active.SimulatorBackupURLs = next.SimulatorBackupURLs
+	active.SimulatorSecrets = next.SimulatorSecrets
+	active.SimulatorTimeout = next.SimulatorTimeout
+	active.VariantTimeout = next.VariantTimeout
+	active.LLM.RPM = next.LLM.RPM
+	active.LLM.Burst = next.LLM.Burst
+	active.LLM.MaxConcurrent = next.LLM.MaxConcurrent
+	active.ExportCPUs = next.ExportCPUs
+	active.ExportMemoryBytes = next.ExportMemoryBytes
+	return active
+}
@@ -0,0 +1,179 @@
+// Package eventbus carries the engine's events to every sink that wants
+// them, such as the WebSocket hub, without any one sink holding up the
+// engine or the others.
+package eventbus
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+// Bus hands each published event to its subscribers. Every subscriber gets
+// events in the order they were published.
+type Bus struct {
+	// Held while an event is queued for every subscriber, so concurrent
+	// publishers' events reach every subscriber in the same order
+	mu   sync.Mutex
+	subs []*subscription
+}
+
+type subscription struct {
+	name string
+	sink func(v any)
+	// Nil for a direct subscriber, which Publish calls itself
+	queue   chan any
+	done    chan struct{}
+	dropped atomic.Int64
+	// Whether the latest event was dropped, so a backlog is logged once
+	lagging atomic.Bool
+}
+
+// New returns a bus with no subscribers.
+func New() *Bus {
+	return &Bus{}
+}
+
+// Direct returns a bus that hands every event straight to sink, for sinks
+// that never block, such as a test's recorder.
+func Direct(sink func(v any)) *Bus {
+	b := New()
+	b.SubscribeDirect("direct", sink)
+	return b
+}
+
+// Subscribe delivers events published from now on to sink from a goroutine
+// of its own, through a queue of buffer events. While the queue is full,
+// events are dropped for this subscriber alone. A sink that panics misses
+// that event and keeps getting the rest. The returned func unsubscribes.
+func (b *Bus) Subscribe(name string, buffer int, sink func(v any)) (unsubscribe func()) {
+	s := &subscription{name: name, sink: sink, queue: make(chan any, buffer), done: make(chan struct{})}
+	go s.run()
+	return b.add(s)
+}
+
+// SubscribeDirect has Publish hand events to sink itself, before it returns.
+// sink must neither block nor publish.
+func (b *Bus) SubscribeDirect(name string, sink func(v any)) (unsubscribe func()) {
+	return b.add(&subscription{name: name, sink: sink, done: make(chan struct{})})
+}
+
+func (b *Bus) add(s *subscription) func() {
+	b.mu.Lock()
+	b.subs = append(b.subs, s)
+	b.mu.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			for i, sub := range b.subs {
+				if sub == s {
+					b.subs = append(b.subs[:i:i], b.subs[i+1:]...)
+					break
+				}
+			}
+			close(s.done)
+		})
+	}
+}
+
+// Publish hands v to every subscriber, waiting on none but the direct ones.
+// Publishing to a nil bus does nothing.
+func (b *Bus) Publish(v any) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, s := range b.subs {
+		if s.queue == nil {
+			s.deliver(v)
+			continue
+		}
+		select {
+		case s.queue <- v:
+			s.lagging.Store(false)
+		default:
+			s.dropped.Add(1)
+			if !s.lagging.Swap(true) {
+				log.Printf("event sink %s is falling behind; dropping events until it catches up", s.name)
+			}
+		}
+	}
+}
+
+// Dropped returns how many events the subscriber name has missed for being
+// behind.
+func (b *Bus) Dropped(name string) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var n int64
+	for _, s := range b.subs {
+		if s.name == name {
+			n += s.dropped.Load()
+		}
+	}
+	return n
+}
+
+// flushMark is the point in a subscriber's queue that Flush waits for it
+// to reach.
+type flushMark chan struct{}
+
+// Flush waits until every subscriber has been handed the events published
+// before it, or until ctx is done. Publishers wait meanwhile.
+func (b *Bus) Flush(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	var marks []flushMark
+	for _, s := range b.subs {
+		if s.queue == nil {
+			continue
+		}
+		mark := make(flushMark)
+		select {
+		case s.queue <- mark:
+			marks = append(marks, mark)
+		case <-ctx.Done():
+			b.mu.Unlock()
+			return ctx.Err()
+		}
+	}
+	b.mu.Unlock()
+	for _, mark := range marks {
+		select {
+		case <-mark:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (s *subscription) run() {
+	for {
+		select {
+		case v := <-s.queue:
+			if mark, ok := v.(flushMark); ok {
+				close(mark)
+				continue
+			}
+			s.deliver(v)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *subscription) deliver(v any) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("event sink %s panicked: %v", s.name, r)
+		}
+	}()
+	s.sink(v)
+}
@@ -0,0 +1,166 @@
+package eventbus
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// collector records what a sink got and signals once it has want events.
+type collector struct {
+	mu   sync.Mutex
+	got  []any
+	want int
+	full chan struct{}
+}
+
+func newCollector(want int) *collector {
+	return &collector{want: want, full: make(chan struct{})}
+}
+
+func (c *collector) sink(v any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.got = append(c.got, v)
+	if len(c.got) == c.want {
+		close(c.full)
+	}
+}
+
+func (c *collector) wait(t *testing.T) []any {
+	t.Helper()
+	select {
+	case <-c.full:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected %d events, got %d", c.want, len(c.got))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]any(nil), c.got...)
+}
+
+func TestSubscribersGetEventsInOrder(t *testing.T) {
+	b := New()
+	first, second := newCollector(100), newCollector(100)
+	b.Subscribe("first", 200, first.sink)
+	b.Subscribe("second", 200, second.sink)
+	for i := 0; i < 100; i++ {
+		b.Publish(i)
+	}
+	for name, c := range map[string]*collector{"first": first, "second": second} {
+		for i, v := range c.wait(t) {
+			if v != i {
+				t.Fatalf("%s: got event %v at %d", name, v, i)
+			}
+		}
+	}
+}
+
+func TestSlowSubscriberDropsAlone(t *testing.T) {
+	b := New()
+	block := make(chan struct{})
+	defer close(block)
+	b.Subscribe("slow", 1, func(any) { <-block })
+	fast := newCollector(50)
+	b.Subscribe("fast", 50, fast.sink)
+
+	published := make(chan struct{})
+	go func() {
+		for i := 0; i < 50; i++ {
+			b.Publish(i)
+		}
+		close(published)
+	}()
+	select {
+	case <-published:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a blocked sink not to hold up Publish")
+	}
+	if got := fast.wait(t); len(got) != 50 {
+		t.Errorf("expected the fast sink to get every event, got %d", len(got))
+	}
+	// One event is being handled and one is queued; the rest were dropped
+	if n := b.Dropped("slow"); n < 48 {
+		t.Errorf("expected the slow sink to drop what it could not keep up with, dropped %d", n)
+	}
+	if n := b.Dropped("fast"); n != 0 {
+		t.Errorf("expected the fast sink to drop nothing, dropped %d", n)
+	}
+}
+
+func TestPanickingSinkKeepsGettingEvents(t *testing.T) {
+	b := New()
+	c := newCollector(2)
+	b.Subscribe("flaky", 10, func(v any) {
+		if v == "boom" {
+			panic("sink failed")
+		}
+		c.sink(v)
+	})
+	direct := newCollector(2)
+	b.SubscribeDirect("direct", func(v any) {
+		if v == "boom" {
+			panic("sink failed")
+		}
+		direct.sink(v)
+	})
+	for _, v := range []any{"a", "boom", "b"} {
+		b.Publish(v)
+	}
+	for name, c := range map[string]*collector{"queued": c, "direct": direct} {
+		if got := c.wait(t); got[0] != "a" || got[1] != "b" {
+			t.Errorf("%s: expected the events around the panic, got %v", name, got)
+		}
+	}
+}
+
+func TestFlushWaitsForQueuedEvents(t *testing.T) {
+	b := New()
+	var mu sync.Mutex
+	var got []any
+	b.Subscribe("slow", 10, func(v any) {
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, v)
+	})
+	for i := 0; i < 5; i++ {
+		b.Publish(i)
+	}
+	if err := b.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != 5 {
+		t.Errorf("expected every event delivered before Flush returned, got %v", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	release := make(chan struct{})
+	defer close(release)
+	b.Subscribe("stuck", 1, func(any) { <-release })
+	b.Publish("blocks the stuck sink")
+	b.Publish("fills its queue")
+	if err := b.Flush(ctx); err == nil {
+		t.Error("expected a done context to end the wait")
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	b := New()
+	var got []any
+	unsubscribe := b.SubscribeDirect("direct", func(v any) { got = append(got, v) })
+	b.Publish(1)
+	unsubscribe()
+	unsubscribe()
+	b.Publish(2)
+	if len(got) != 1 || got[0] != 1 {
+		t.Errorf("expected only the event before unsubscribing, got %v", got)
+	}
+
+	var nilBus *Bus
+	nilBus.Publish(3) // Must not panic
+}
@@ -0,0 +1,483 @@
+// Package grafana builds Grafana (10+) dashboard JSON models of a run's
+// results. The run's figures are embedded as CSV queries of the TestData
+// data source, so the dashboard imports and renders with no live
+// connection; time-series artifacts are the exception and are read from
+// SimStack itself through the Infinity data source (see TimeSeriesDataSource).
+package grafana
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"simstack/internal/metrics"
+	"simstack/internal/types"
+)
+
+// SchemaVersion is the dashboard schema written, Grafana 10.0's; newer
+// Grafana versions migrate it on import.
+const SchemaVersion = 38
+
+// Data sources the dashboard asks for on import.
+const (
+	// Holds the embedded run data
+	SnapshotDataSource = "grafana-testdata-datasource"
+	// Fetches time-series artifacts from SimStack: an Infinity data source
+	// whose URL is the SimStack base URL, so artifact storage refs resolve
+	// against it
+	TimeSeriesDataSource = "yesoreyeram-infinity-datasource"
+)
+
+// Time-series artifacts are CSV with a header, an RFC 3339 timestamp first
+// and one numeric column per series.
+const timeSeriesContentType = "text/csv"
+
+// Panel caps keep large runs importable and readable; anything left out is
+// listed in the summary panel.
+const (
+	maxBarCharts       = 8
+	maxBarsPerChart    = 25
+	maxTimeSeriesPanel = 8
+)
+
+// Dashboard is the importable dashboard model, with the __inputs and
+// __requires sections of Grafana's "export for sharing" format.
+type Dashboard struct {
+	Inputs        []Input     `json:"__inputs"`
+	Requires      []Require   `json:"__requires"`
+	ID            *int        `json:"id"`
+	UID           string      `json:"uid"`
+	Title         string      `json:"title"`
+	Description   string      `json:"description,omitempty"`
+	Tags          []string    `json:"tags"`
+	Timezone      string      `json:"timezone"`
+	Editable      bool        `json:"editable"`
+	SchemaVersion int         `json:"schemaVersion"`
+	Version       int         `json:"version"`
+	Time          TimeRange   `json:"time"`
+	Panels        []Panel     `json:"panels"`
+	Templating    Templating  `json:"templating"`
+	Annotations   Annotations `json:"annotations"`
+}
+
+// Input is a data source picked by whoever imports the dashboard.
+type Input struct {
+	Name     string `json:"name"`
+	Label    string `json:"label"`
+	Type     string `json:"type"`
+	PluginID string `json:"pluginId"`
+}
+
+type Require struct {
+	Type    string `json:"type"`
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type TimeRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type Templating struct {
+	List []any `json:"list"`
+}
+
+type Annotations struct {
+	List []any `json:"list"`
+}
+
+type Panel struct {
+	ID          int            `json:"id"`
+	Type        string         `json:"type"`
+	Title       string         `json:"title"`
+	Description string         `json:"description,omitempty"`
+	GridPos     GridPos        `json:"gridPos"`
+	Datasource  *DataSourceRef `json:"datasource,omitempty"`
+	Targets     []Target       `json:"targets,omitempty"`
+	FieldConfig FieldConfig    `json:"fieldConfig"`
+	// One of the *Options types, matching Type
+	Options any `json:"options"`
+}
+
+type GridPos struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+type DataSourceRef struct {
+	Type string `json:"type"`
+	UID  string `json:"uid"`
+}
+
+// Target is a panel query. TestData queries use ScenarioID and CSVContent;
+// Infinity queries use the rest.
+type Target struct {
+	RefID      string         `json:"refId"`
+	Datasource *DataSourceRef `json:"datasource"`
+
+	ScenarioID string `json:"scenarioId,omitempty"`
+	CSVContent string `json:"csvContent,omitempty"`
+
+	Type       string      `json:"type,omitempty"`
+	Source     string      `json:"source,omitempty"`
+	Parser     string      `json:"parser,omitempty"`
+	Format     string      `json:"format,omitempty"`
+	URL        string      `json:"url,omitempty"`
+	URLOptions *URLOptions `json:"url_options,omitempty"`
+}
+
+type URLOptions struct {
+	Method string `json:"method"`
+}
+
+type FieldConfig struct {
+	Defaults  FieldDefaults `json:"defaults"`
+	Overrides []any         `json:"overrides"`
+}
+
+type FieldDefaults struct {
+	Unit string `json:"unit,omitempty"`
+}
+
+type Legend struct {
+	DisplayMode string `json:"displayMode"`
+	Placement   string `json:"placement"`
+	ShowLegend  bool   `json:"showLegend"`
+}
+
+type TableOptions struct {
+	ShowHeader bool     `json:"showHeader"`
+	CellHeight string   `json:"cellHeight"`
+	SortBy     []SortBy `json:"sortBy"`
+}
+
+type SortBy struct {
+	DisplayName string `json:"displayName"`
+	Desc        bool   `json:"desc"`
+}
+
+type BarChartOptions struct {
+	XField      string `json:"xField"`
+	Orientation string `json:"orientation"`
+	ShowValue   string `json:"showValue"`
+	Legend      Legend `json:"legend"`
+}
+
+type StatOptions struct {
+	ReduceOptions ReduceOptions `json:"reduceOptions"`
+	TextMode      string        `json:"textMode"`
+	ColorMode     string        `json:"colorMode"`
+	GraphMode     string        `json:"graphMode"`
+	JustifyMode   string        `json:"justifyMode"`
+}
+
+type ReduceOptions struct {
+	Values bool     `json:"values"`
+	Calcs  []string `json:"calcs"`
+	Fields string   `json:"fields"`
+}
+
+type TimeSeriesOptions struct {
+	Legend Legend `json:"legend"`
+}
+
+type TextOptions struct {
+	Mode    string `json:"mode"`
+	Content string `json:"content"`
+}
+
+var (
+	snapshotDS   = &DataSourceRef{Type: SnapshotDataSource, UID: "${DS_TESTDATA}"}
+	timeSeriesDS = &DataSourceRef{Type: TimeSeriesDataSource, UID: "${DS_SIMSTACK}"}
+	validUID     = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,40}$`)
+)
+
+// Build returns the dashboard of run. catalog supplies each metric's unit
+// and direction.
+func Build(run types.RunRecord, catalog *metrics.Catalog) Dashboard {
+	b := &builder{}
+	names := metricNames(run.Results)
+	series := timeSeriesArtifacts(run.Results)
+
+	b.add(Panel{
+		Type: "stat", Title: "Winner", Datasource: snapshotDS,
+		Description: "The variant the analysis picked.",
+		GridPos:     GridPos{W: 6, H: 6},
+		Targets:     []Target{snapshot("winner\n" + orNone(run.Winner) + "\n")},
+		Options: StatOptions{
+			ReduceOptions: ReduceOptions{Values: true, Calcs: []string{}, Fields: "/^winner$/"},
+			TextMode:      "value", ColorMode: "none", GraphMode: "none", JustifyMode: "center",
+		},
+	})
+	summary := b.add(Panel{Type: "text", Title: "Run", GridPos: GridPos{X: 6, W: 18, H: 6}})
+
+	b.add(Panel{
+		Type: "table", Title: "Variants", Datasource: snapshotDS,
+		Description: "Every variant's status, duration and metrics.",
+		GridPos:     GridPos{W: 24, H: min(4+len(run.Results), 16)},
+		Targets:     []Target{snapshot(resultsCSV(run.Results, names))},
+		Options:     TableOptions{ShowHeader: true, CellHeight: "sm", SortBy: []SortBy{}},
+	})
+
+	var omitted []string
+	for i, name := range names {
+		if i == maxBarCharts {
+			omitted = append(omitted, fmt.Sprintf("%d more metrics without a bar chart: %s", len(names)-i, strings.Join(names[i:], ", ")))
+			break
+		}
+		def, _ := catalog.Lookup(name)
+		bars, dropped := barCSV(run.Results, name, def.Direction)
+		p := Panel{
+			Type: "barchart", Title: name + " by variant", Datasource: snapshotDS,
+			Description: describe(def, dropped),
+			GridPos:     GridPos{W: 12, H: 8},
+			Targets:     []Target{snapshot(bars)},
+			Options:     BarChartOptions{XField: "variant", Orientation: "auto", ShowValue: "auto", Legend: Legend{DisplayMode: "list", Placement: "bottom"}},
+		}
+		p.FieldConfig.Defaults.Unit = unit(def.Unit)
+		b.add(p)
+	}
+
+	for i, a := range series {
+		if i == maxTimeSeriesPanel {
+			omitted = append(omitted, fmt.Sprintf("%d more time series without a panel", len(series)-i))
+			break
+		}
+		b.add(Panel{
+			Type: "timeseries", Title: a.Origin.VariantID + " " + a.Name, Datasource: timeSeriesDS,
+			GridPos: GridPos{W: 12, H: 8},
+			Targets: []Target{{
+				RefID: "A", Datasource: timeSeriesDS,
+				Type: "csv", Source: "url", Parser: "backend", Format: "timeseries",
+				URL: a.StorageRef, URLOptions: &URLOptions{Method: "GET"},
+			}},
+			Options: TimeSeriesOptions{Legend: Legend{DisplayMode: "list", Placement: "bottom", ShowLegend: true}},
+		})
+	}
+
+	b.panels[summary].Options = TextOptions{Mode: "markdown", Content: summaryText(run, omitted)}
+
+	to := time.Now().UTC()
+	if run.FinishedAt != nil {
+		to = *run.FinishedAt
+	}
+	d := Dashboard{
+		Inputs: []Input{{Name: "DS_TESTDATA", Label: "TestData", Type: "datasource", PluginID: SnapshotDataSource}},
+		Requires: []Require{
+			{Type: "grafana", ID: "grafana", Name: "Grafana", Version: "10.0.0"},
+			{Type: "datasource", ID: SnapshotDataSource, Name: "TestData", Version: "1.0.0"},
+		},
+		UID:           uid(run.ID),
+		Title:         "SimStack run " + run.ID,
+		Description:   run.Goal,
+		Tags:          []string{"simstack"},
+		Timezone:      "utc",
+		Editable:      true,
+		SchemaVersion: SchemaVersion,
+		Version:       1,
+		Time:          TimeRange{From: run.StartedAt.UTC().Format(time.RFC3339), To: to.UTC().Format(time.RFC3339)},
+		Panels:        b.panels,
+		Templating:    Templating{List: []any{}},
+		Annotations:   Annotations{List: []any{}},
+	}
+	if len(series) > 0 {
+		d.Inputs = append(d.Inputs, Input{Name: "DS_SIMSTACK", Label: "SimStack (Infinity)", Type: "datasource", PluginID: TimeSeriesDataSource})
+		d.Requires = append(d.Requires, Require{Type: "datasource", ID: TimeSeriesDataSource, Name: "Infinity", Version: "2.0.0"})
+	}
+	return d
+}
+
+// builder numbers panels and lays them out left to right, wrapping at the
+// dashboard's 24 columns.
+type builder struct {
+	panels []Panel
+	x, y   int
+	rowH   int
+}
+
+// add places p and returns its index.
+func (b *builder) add(p Panel) int {
+	if b.x+p.GridPos.W > 24 {
+		b.x, b.y, b.rowH = 0, b.y+b.rowH, 0
+	}
+	p.ID = len(b.panels) + 1
+	p.GridPos.X, p.GridPos.Y = b.x, b.y
+	p.FieldConfig.Overrides = []any{}
+	if p.Datasource != nil {
+		for i := range p.Targets {
+			p.Targets[i].Datasource = p.Datasource
+		}
+	}
+	b.x += p.GridPos.W
+	b.rowH = max(b.rowH, p.GridPos.H)
+	b.panels = append(b.panels, p)
+	return len(b.panels) - 1
+}
+
+func snapshot(csvContent string) Target {
+	return Target{RefID: "A", Datasource: snapshotDS, ScenarioID: "csv_content", CSVContent: csvContent}
+}
+
+// metricNames is every metric any result reports, sorted.
+func metricNames(results []types.SimulationResult) []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, r := range results {
+		for k := range r.Metrics {
+			if !seen[k] {
+				seen[k] = true
+				names = append(names, k)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func resultsCSV(results []types.SimulationResult, names []string) string {
+	rows := [][]string{append([]string{"variant", "tool", "status", "duration_ms"}, names...)}
+	for _, r := range results {
+		row := []string{r.VariantID, r.Tool, string(r.Status), strconv.FormatInt(r.DurationMs, 10)}
+		for _, k := range names {
+			if v, ok := r.Metrics[k]; ok {
+				row = append(row, formatFloat(v))
+			} else {
+				row = append(row, "")
+			}
+		}
+		rows = append(rows, row)
+	}
+	return writeCSV(rows)
+}
+
+// barCSV lists the variants reporting metric, best first, keeping the best
+// maxBarsPerChart; dropped counts the rest.
+func barCSV(results []types.SimulationResult, metric string, dir types.MetricDirection) (string, int) {
+	type bar struct {
+		variant string
+		value   float64
+	}
+	var bars []bar
+	for _, r := range results {
+		if v, ok := r.Metrics[metric]; ok {
+			bars = append(bars, bar{r.VariantID, v})
+		}
+	}
+	sort.SliceStable(bars, func(i, j int) bool {
+		if dir == types.LowerIsBetter {
+			return bars[i].value < bars[j].value
+		}
+		return bars[i].value > bars[j].value
+	})
+	dropped := max(len(bars)-maxBarsPerChart, 0)
+	rows := [][]string{{"variant", metric}}
+	for _, b := range bars[:len(bars)-dropped] {
+		rows = append(rows, []string{b.variant, formatFloat(b.value)})
+	}
+	return writeCSV(rows), dropped
+}
+
+func describe(def types.MetricDef, dropped int) string {
+	var parts []string
+	if d := strings.TrimSpace(def.Description); d != "" {
+		// Catalog descriptions are phrases; the panel reads as sentences
+		if !strings.HasSuffix(d, ".") && !strings.HasSuffix(d, "?") && !strings.HasSuffix(d, "!") {
+			d += "."
+		}
+		parts = append(parts, d)
+	}
+	if def.Direction == types.LowerIsBetter {
+		parts = append(parts, "Lower is better.")
+	} else {
+		parts = append(parts, "Higher is better.")
+	}
+	if dropped > 0 {
+		parts = append(parts, fmt.Sprintf("Only the best %d variants are shown; %d more are in the table.", maxBarsPerChart, dropped))
+	}
+	return strings.Join(parts, " ")
+}
+
+// timeSeriesArtifacts are the stored CSV artifacts of the run's results.
+func timeSeriesArtifacts(results []types.SimulationResult) []types.Artifact {
+	var out []types.Artifact
+	for _, r := range results {
+		for _, a := range r.Artifacts {
+			if t, _, err := mime.ParseMediaType(a.ContentType); err == nil && t == timeSeriesContentType && a.StorageRef != "" {
+				out = append(out, a)
+			}
+		}
+	}
+	return out
+}
+
+func summaryText(run types.RunRecord, omitted []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "**Goal:** %s\n\n", run.Goal)
+	fmt.Fprintf(&b, "**Run:** `%s` · **Status:** %s · **Variants:** %d", run.ID, run.Status, len(run.Results))
+	if run.PlanID != "" {
+		fmt.Fprintf(&b, " · **Plan:** `%s`", run.PlanID)
+	}
+	b.WriteString("\n")
+	if rec, ok := run.Analysis["recommendation"].(string); ok && rec != "" {
+		fmt.Fprintf(&b, "\n%s\n", rec)
+	}
+	for _, o := range omitted {
+		fmt.Fprintf(&b, "\n_%s._\n", o)
+	}
+	return b.String()
+}
+
+// unit maps catalog units onto Grafana's unit IDs; the rest show as suffixes.
+func unit(u string) string {
+	switch u {
+	case "":
+		return ""
+	case "min":
+		return "m"
+	case "ratio":
+		return "percentunit"
+	case "km/h":
+		return "velocitykmh"
+	}
+	return "suffix:" + u
+}
+
+// uid is the run's dashboard UID, stable across exports so re-importing
+// replaces the dashboard.
+func uid(runID string) string {
+	if u := "simstack-" + runID; validUID.MatchString(u) {
+		return u
+	}
+	sum := sha256.Sum256([]byte(runID))
+	return "simstack-" + hex.EncodeToString(sum[:])[:16]
+}
+
+func writeCSV(rows [][]string) string {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	_ = w.WriteAll(rows)
+	return buf.String()
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
@@ -0,0 +1,173 @@
+package grafana
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"simstack/internal/metrics"
+	"simstack/internal/types"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files")
+
+var (
+	started  = time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	finished = started.Add(90 * time.Second)
+	catalog  = metrics.New(
+		types.MetricDef{Name: "queue_avg_wait_time_min", Unit: "min", Direction: types.LowerIsBetter, Description: "Average time a customer waits before service"},
+		types.MetricDef{Name: "queue_utilization", Unit: "ratio", Direction: types.HigherIsBetter},
+	)
+)
+
+func sampleRun() types.RunRecord {
+	series := types.Artifact{
+		Name: "wait-series.csv", ContentType: "text/csv; charset=utf-8",
+		Origin:     types.ArtifactOrigin{RunID: "run-1", VariantID: "plan-1-v2", Tool: "queue"},
+		StorageRef: "/api/runs/run-1/artifacts/plan-1-v2/wait-series.csv",
+	}
+	response := types.Artifact{Name: "queue-response.json", ContentType: "application/json", StorageRef: "/api/runs/run-1/artifacts/plan-1-v1/queue-response.json"}
+	return types.RunRecord{
+		ID: "run-1", Goal: "reduce ER wait time by 20%", Status: "completed",
+		StartedAt: started, FinishedAt: &finished, PlanID: "plan-1", Winner: "plan-1-v2",
+		Results: []types.SimulationResult{
+			{VariantID: "plan-1-v1", Tool: "queue", Status: types.ResultComplete, DurationMs: 1200, Metrics: map[string]float64{"queue_avg_wait_time_min": 6.5, "queue_utilization": 0.71}, Artifacts: []types.Artifact{response}},
+			{VariantID: "plan-1-v2", Tool: "queue", Status: types.ResultComplete, DurationMs: 1350, Metrics: map[string]float64{"queue_avg_wait_time_min": 4.25, "queue_utilization": 0.83, "cost": 1200}, Artifacts: []types.Artifact{series}},
+			{VariantID: "plan-1-v3", Tool: "queue", Status: types.ResultFailed, DurationMs: 30000},
+		},
+		Analysis: map[string]any{"recommendation": "Staff 25 nurses on the night shift."},
+	}
+}
+
+func TestDashboardGolden(t *testing.T) {
+	got, err := json.MarshalIndent(Build(sampleRun(), catalog), "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = append(got, '\n')
+	path := filepath.Join("testdata", "dashboard.json")
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run with -update to create)", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("dashboard changed:\n got: %s\nwant: %s", got, want)
+	}
+	checkImportable(t, Build(sampleRun(), catalog))
+}
+
+func TestDashboardCapsPanels(t *testing.T) {
+	run := sampleRun()
+	run.Results = nil
+	for i := 0; i < 40; i++ {
+		r := types.SimulationResult{VariantID: fmt.Sprintf("v%02d", i), Tool: "composite", Status: types.ResultComplete, Metrics: map[string]float64{}}
+		for m := 0; m < 12; m++ {
+			r.Metrics[fmt.Sprintf("m%02d_wait", m)] = float64(i)
+		}
+		r.Artifacts = []types.Artifact{{Name: "series.csv", ContentType: "text/csv", Origin: types.ArtifactOrigin{VariantID: r.VariantID}, StorageRef: "/api/runs/run-1/artifacts/" + r.VariantID + "/series.csv"}}
+		run.Results = append(run.Results, r)
+	}
+	d := Build(run, catalog)
+	checkImportable(t, d)
+
+	count := map[string]int{}
+	for _, p := range d.Panels {
+		count[p.Type]++
+	}
+	if count["barchart"] != maxBarCharts || count["timeseries"] != maxTimeSeriesPanel || len(d.Panels) != 3+maxBarCharts+maxTimeSeriesPanel {
+		t.Errorf("expected capped panels, got %v", count)
+	}
+	for _, p := range d.Panels {
+		switch p.Type {
+		case "barchart":
+			// Names guess lower-is-better, so the lowest values are kept
+			rows := strings.Split(strings.TrimSpace(p.Targets[0].CSVContent), "\n")
+			if len(rows) != maxBarsPerChart+1 || !strings.HasPrefix(rows[1], "v00,") || !strings.Contains(p.Description, "15 more") {
+				t.Errorf("%s: expected the best %d variants, got %d rows (%s)", p.Title, maxBarsPerChart, len(rows)-1, p.Description)
+			}
+		case "table":
+			if rows := strings.Count(p.Targets[0].CSVContent, "\n"); rows != 41 {
+				t.Errorf("expected every variant in the table, got %d rows", rows)
+			}
+		case "text":
+			content := p.Options.(TextOptions).Content
+			if !strings.Contains(content, "4 more metrics") || !strings.Contains(content, "32 more time series") {
+				t.Errorf("expected the summary to list what was left out, got %q", content)
+			}
+		}
+	}
+}
+
+func TestDashboardUID(t *testing.T) {
+	if got := uid("run-1776000000000000000"); got != "simstack-run-1776000000000000000" {
+		t.Errorf("unexpected uid %q", got)
+	}
+	long := uid(strings.Repeat("x", 60))
+	if !validUID.MatchString(long) || long != uid(strings.Repeat("x", 60)) || long == uid(strings.Repeat("y", 60)) {
+		t.Errorf("expected a stable, valid uid for long IDs, got %q", long)
+	}
+}
+
+func TestPanelDescriptionAndUnit(t *testing.T) {
+	for desc, want := range map[string]string{
+		"Average wait":  "Average wait. Lower is better.",
+		"Average wait.": "Average wait. Lower is better.",
+		"":              "Lower is better.",
+	} {
+		if got := describe(types.MetricDef{Description: desc, Direction: types.LowerIsBetter}, 0); got != want {
+			t.Errorf("describe(%q) = %q, want %q", desc, got, want)
+		}
+	}
+	for u, want := range map[string]string{"": "", "min": "m", "patients": "suffix:patients"} {
+		if got := unit(u); got != want {
+			t.Errorf("unit(%q) = %q, want %q", u, got, want)
+		}
+	}
+}
+
+// checkImportable checks what Grafana's importer relies on: unique panel
+// IDs, panels inside the 24-column grid without overlap, and every data
+// source declared as an input.
+func checkImportable(t *testing.T, d Dashboard) {
+	t.Helper()
+	inputs := map[string]bool{}
+	for _, in := range d.Inputs {
+		inputs["${"+in.Name+"}"] = true
+	}
+	ids := map[int]bool{}
+	for i, p := range d.Panels {
+		if ids[p.ID] || p.ID == 0 {
+			t.Errorf("panel %q: duplicate id %d", p.Title, p.ID)
+		}
+		ids[p.ID] = true
+		g := p.GridPos
+		if g.X < 0 || g.W <= 0 || g.H <= 0 || g.X+g.W > 24 {
+			t.Errorf("panel %q outside the grid: %+v", p.Title, g)
+		}
+		for _, q := range d.Panels[:i] {
+			h := q.GridPos
+			if g.X < h.X+h.W && h.X < g.X+g.W && g.Y < h.Y+h.H && h.Y < g.Y+g.H {
+				t.Errorf("panels %q and %q overlap", p.Title, q.Title)
+			}
+		}
+		for _, q := range p.Targets {
+			if q.Datasource == nil || !inputs[q.Datasource.UID] {
+				t.Errorf("panel %q queries an undeclared data source %+v", p.Title, q.Datasource)
+			}
+		}
+		if p.Options == nil {
+			t.Errorf("panel %q has no options", p.Title)
+		}
+	}
+}
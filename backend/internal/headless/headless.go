@@ -0,0 +1,233 @@
+// Package headless runs one goal through the orchestrator in this process,
+// without the HTTP server or a WebSocket client: the backend's `run`
+// subcommand, for CI. Events go to stdout as JSON lines while the run goes;
+// the results can be written to a file once it is done.
+package headless
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+
+	"simstack/internal/config"
+	"simstack/internal/eventbus"
+	"simstack/internal/orchestrator"
+	"simstack/internal/schema"
+	"simstack/internal/types"
+)
+
+// Exit codes, as simstack-cli's.
+const (
+	ExitOK = 0
+	// The run failed, was cancelled or timed out, or every variant failed
+	ExitFailed = 1
+	ExitUsage  = 2
+)
+
+const usage = `usage: server [--config FILE] run (--goal GOAL | --file FILE) [flags]
+
+Runs the goal once, in this process, with the backend's configuration, and
+prints its events to stdout as JSON lines. Exits 1 when the run fails, is
+cancelled or times out, or when every variant failed.
+
+flags:
+`
+
+// Main runs the subcommand with args (after "run") against a new engine
+// built from cfg and opts, and returns the exit code.
+func Main(ctx context.Context, cfg config.Config, args []string, stdout, stderr io.Writer, opts ...orchestrator.Option) int {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		fs.PrintDefaults()
+	}
+	file := fs.String("file", "", "RunRequest as a JSON or YAML file; flags override its fields")
+	goal := fs.String("goal", "", "what to optimize")
+	model := fs.String("model", "", "model override")
+	offline := fs.Bool("offline", false, "never contact the LLM")
+	reproducible := fs.Bool("reproducible", false, "pin temperatures and seed the LLM")
+	output := fs.String("output", "", "write the run's results here as JSON once it is done")
+	timeout := fs.Duration("timeout", cfg.RunTimeout, "cancel the run after this long, SIMSTACK_RUN_TIMEOUT by default (0 waits for it)")
+	constraints, params := pairs{}, pairs{}
+	fs.Var(constraints, "constraints", "constraint key=value (repeatable)")
+	fs.Var(params, "param", "parameter key=value (repeatable)")
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+
+	req := map[string]any{}
+	if *file != "" {
+		var err error
+		if req, err = readRequest(*file); err != nil {
+			fmt.Fprintf(stderr, "run: %v\n", err)
+			return ExitUsage
+		}
+	}
+	if *goal != "" {
+		req["goal"] = *goal
+	}
+	if *model != "" {
+		req["model"] = *model
+	}
+	if *offline {
+		req["offline"] = true
+	}
+	if *reproducible {
+		req["reproducible"] = true
+	}
+	merge(req, "constraints", constraints)
+	merge(req, "parameters", params)
+	if g, _ := req["goal"].(string); g == "" {
+		fmt.Fprintln(stderr, "run: needs --goal or a --file with a goal")
+		return ExitUsage
+	}
+
+	// Validated as the API validates it; a misspelled field is an error
+	body, err := json.Marshal(req)
+	if err != nil {
+		fmt.Fprintf(stderr, "run: %v\n", err)
+		return ExitUsage
+	}
+	warnings, err := schema.ValidateRunRequest(body, true)
+	if err != nil {
+		fmt.Fprintf(stderr, "run: %v\n", err)
+		return ExitUsage
+	}
+	for _, w := range warnings {
+		fmt.Fprintf(stderr, "warning: %s\n", w)
+	}
+	var run types.RunRequest
+	if err := json.Unmarshal(body, &run); err != nil {
+		fmt.Fprintf(stderr, "run: %v\n", err)
+		return ExitUsage
+	}
+
+	// Direct, so every event is printed by the time the run returns
+	var mu sync.Mutex
+	enc := json.NewEncoder(stdout)
+	bus := eventbus.Direct(func(v any) {
+		mu.Lock()
+		defer mu.Unlock()
+		_ = enc.Encode(v)
+	})
+	engine := orchestrator.NewEngine(bus, append([]orchestrator.Option{orchestrator.WithConfig(cfg)}, opts...)...)
+	if err := engine.ValidateRequest(ctx, run); err != nil {
+		fmt.Fprintf(stderr, "run: %v\n", err)
+		return ExitUsage
+	}
+
+	runCtx := ctx
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	id := orchestrator.NewRunID()
+	runErr := engine.RunWithID(runCtx, id, run)
+	timedOut := errors.Is(runCtx.Err(), context.DeadlineExceeded)
+
+	// The run's own context may be done; reading back what it saved isn't
+	results, resultsErr := engine.RunResults(context.Background(), id)
+	if resultsErr == nil && *output != "" {
+		resultsErr = writeResults(*output, results)
+	}
+	// Why the run failed comes first; a run that failed early may have
+	// saved nothing to read back
+	switch {
+	case timedOut:
+		fmt.Fprintf(stderr, "run: %s timed out after %s\n", id, *timeout)
+	case runErr != nil:
+		fmt.Fprintf(stderr, "run: %s: %v\n", id, runErr)
+	}
+	if resultsErr != nil {
+		fmt.Fprintf(stderr, "run: %s: results: %v\n", id, resultsErr)
+		return ExitFailed
+	}
+	switch {
+	case timedOut, runErr != nil:
+		return ExitFailed
+	case !anySucceeded(results.Results):
+		fmt.Fprintf(stderr, "run: %s: every variant failed\n", id)
+		return ExitFailed
+	}
+	return ExitOK
+}
+
+// pairs collects repeated key=value flags. Values that parse as JSON
+// (numbers, booleans, arrays) keep their type; the rest are strings.
+type pairs map[string]any
+
+func (p pairs) String() string { return fmt.Sprint(map[string]any(p)) }
+
+func (p pairs) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok || k == "" {
+		return fmt.Errorf("expected key=value, got %q", s)
+	}
+	var parsed any
+	if json.Unmarshal([]byte(v), &parsed) == nil {
+		p[k] = parsed
+	} else {
+		p[k] = v
+	}
+	return nil
+}
+
+// merge adds p to the object req[field], which flags override.
+func merge(req map[string]any, field string, p pairs) {
+	if len(p) == 0 {
+		return
+	}
+	merged, _ := req[field].(map[string]any)
+	if merged == nil {
+		merged = map[string]any{}
+	}
+	for k, v := range p {
+		merged[k] = v
+	}
+	req[field] = merged
+}
+
+// readRequest loads a RunRequest file, YAML or JSON (a YAML subset).
+func readRequest(path string) (map[string]any, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var req map[string]any
+	if err := yaml.Unmarshal(b, &req); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if req == nil {
+		req = map[string]any{}
+	}
+	return req, nil
+}
+
+func writeResults(path string, results types.RunResults) error {
+	b, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0o644)
+}
+
+// anySucceeded reports whether a variant produced results, or there were
+// none to produce.
+func anySucceeded(results []types.SimulationResult) bool {
+	for _, r := range results {
+		if r.Status != types.ResultFailed {
+			return true
+		}
+	}
+	return len(results) == 0
+}
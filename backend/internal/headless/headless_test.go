@@ -0,0 +1,109 @@
+package headless
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"simstack/internal/config"
+	"simstack/internal/orchestrator"
+	"simstack/internal/testsupport"
+	"simstack/internal/types"
+)
+
+// run runs the subcommand against simulators all served by sim.
+func run(t *testing.T, sim http.HandlerFunc, args ...string) (code int, stdout, stderr string) {
+	t.Helper()
+	srv := httptest.NewServer(sim)
+	t.Cleanup(srv.Close)
+	cfg, _ := config.Load()
+	cfg.Offline = true
+	cfg.SimulatorWarmup = false
+	cfg.SimulatorURLs = map[string]string{"queue": srv.URL, "traffic": srv.URL, "resource": srv.URL}
+	var out, errOut bytes.Buffer
+	code = Main(context.Background(), cfg, args, &out, &errOut, orchestrator.WithChatClient(testsupport.NewFakeChat(), "m"))
+	return code, out.String(), errOut.String()
+}
+
+func ok(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprint(w, `{"metrics": {"utilization": 0.5}}`)
+}
+
+func TestRun(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "results.json")
+	code, stdout, stderr := run(t, ok, "--goal", "reduce queue wait", "--constraints", "budget=5000", "--output", output)
+	if code != ExitOK {
+		t.Fatalf("expected success, got %d: %s", code, stderr)
+	}
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(strings.NewReader(stdout))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var ev types.WSEvent
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			t.Fatalf("expected one event per line, got %q: %v", scanner.Text(), err)
+		}
+		seen[ev.Type] = true
+	}
+	for _, want := range []string{types.EventPlan, types.EventResult, types.EventDone} {
+		if !seen[want] {
+			t.Errorf("expected a %s event among %v", want, seen)
+		}
+	}
+
+	b, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results types.RunResults
+	if err := json.Unmarshal(b, &results); err != nil || !results.Complete || len(results.Results) == 0 {
+		t.Errorf("expected the complete results written, got %+v (%v)", results, err)
+	}
+}
+
+func TestRunFails(t *testing.T) {
+	down := func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusInternalServerError)
+	}
+	if code, _, stderr := run(t, down, "--goal", "reduce queue wait"); code != ExitFailed || !strings.Contains(stderr, "every variant failed") {
+		t.Errorf("expected failed variants to fail the command, got %d: %s", code, stderr)
+	}
+
+	// Well past the timeout, yet short enough that closing the server
+	// doesn't wait long on its handlers
+	slow := func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+	}
+	if code, _, stderr := run(t, slow, "--goal", "reduce queue wait", "--timeout", "100ms"); code != ExitFailed || !strings.Contains(stderr, "timed out after 100ms") {
+		t.Errorf("expected the timeout to fail the command, got %d: %s", code, stderr)
+	}
+}
+
+func TestRunUsage(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"--goal", "g", "--constraints", "budget"},
+		{"--goal", "g", "--param", "arrival_rate=2", "--bogus"},
+	} {
+		if code, _, _ := run(t, ok, args...); code != ExitUsage {
+			t.Errorf("%v: expected a usage error, got %d", args, code)
+		}
+	}
+
+	file := filepath.Join(t.TempDir(), "run.yaml")
+	if err := os.WriteFile(file, []byte("goal: reduce wait\ngoall: typo\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if code, _, stderr := run(t, ok, "--file", file); code != ExitUsage || !strings.Contains(stderr, "goall") {
+		t.Errorf("expected a misspelled field refused, got %d: %s", code, stderr)
+	}
+}
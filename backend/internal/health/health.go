@@ -0,0 +1,226 @@
+// Package health probes the simulators in the background and caches what it
+// finds, so readiness checks and dashboards learn about a simulator going
+// down before a run's variants do.
+package health
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"simstack/internal/types"
+)
+
+// Path is where every simulator answers health probes.
+const Path = "/healthz"
+
+// Options tune the poller.
+type Options struct {
+	// Between probes of a healthy simulator
+	Interval time.Duration
+	// Of each probe
+	Timeout time.Duration
+	// Successful probes slower than this leave a simulator degraded
+	Slow time.Duration
+	// Failed probes in a row that make a simulator down; fewer leave it
+	// degraded
+	DownAfter int
+	// Longest wait between probes of a down simulator, which doubles from
+	// Interval with each further failure
+	MaxBackoff time.Duration
+}
+
+// Poller probes each simulator's Path and keeps the outcome. Its methods
+// are safe for concurrent use; Snapshot never waits for a probe.
+type Poller struct {
+	client  *http.Client
+	targets func() map[string]string
+	opts    Options
+	// Called, outside any lock, when a simulator's status changes
+	notify func(types.SimulatorStatusEvent)
+	now    func() time.Time
+
+	mu    sync.RWMutex
+	state map[string]*target
+}
+
+type target struct {
+	health types.SimulatorHealth
+	// When the simulator is next due a probe
+	next time.Time
+}
+
+// New returns a poller probing the simulators targets returns, by tool name,
+// each time it polls. A tool whose URL changes starts over as unknown.
+func New(client *http.Client, targets func() map[string]string, opts Options, notify func(types.SimulatorStatusEvent)) *Poller {
+	if opts.DownAfter < 1 {
+		opts.DownAfter = 1
+	}
+	if opts.MaxBackoff < opts.Interval {
+		opts.MaxBackoff = opts.Interval
+	}
+	return &Poller{client: client, targets: targets, opts: opts, notify: notify, now: time.Now, state: map[string]*target{}}
+}
+
+// Run polls now and then every Interval until ctx is done, probing the
+// simulators that are due.
+func (p *Poller) Run(ctx context.Context) {
+	if p.opts.Interval <= 0 {
+		return
+	}
+	p.poll(ctx)
+	t := time.NewTicker(p.opts.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			p.poll(ctx)
+		}
+	}
+}
+
+// poll probes every due simulator at once and waits for them all.
+func (p *Poller) poll(ctx context.Context) {
+	now := p.now()
+	var due []types.SimulatorHealth
+	p.mu.Lock()
+	targets := p.targets()
+	for tool := range p.state {
+		if _, ok := targets[tool]; !ok {
+			delete(p.state, tool)
+		}
+	}
+	for tool, url := range targets {
+		t, ok := p.state[tool]
+		if !ok || t.health.URL != url {
+			t = &target{health: types.SimulatorHealth{Tool: tool, URL: url, Status: types.HealthUnknown, Since: now.UTC()}}
+			p.state[tool] = t
+		}
+		if !now.Before(t.next) {
+			due = append(due, t.health)
+		}
+	}
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, h := range due {
+		wg.Add(1)
+		go func(h types.SimulatorHealth) {
+			defer wg.Done()
+			latency, err := p.probe(ctx, h.URL)
+			if ctx.Err() != nil {
+				// Shutting down; the failure is ours, not the simulator's
+				return
+			}
+			p.record(h.Tool, h.URL, latency, err)
+		}(h)
+	}
+	wg.Wait()
+}
+
+func (p *Poller) probe(ctx context.Context, url string) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.opts.Timeout)
+	defer cancel()
+	return Probe(ctx, p.client, url)
+}
+
+// Probe requests the Path of the simulator at url once and reports how long
+// it took to answer, and an error unless it answered 2xx. ctx bounds it.
+func Probe(ctx context.Context, client *http.Client, url string) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(url, "/")+Path, nil)
+	if err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return time.Since(start), err
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+	latency := time.Since(start)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return latency, fmt.Errorf("health check returned %s", resp.Status)
+	}
+	return latency, nil
+}
+
+// record folds a probe's outcome into tool's status, schedules its next
+// probe and reports a change of status.
+func (p *Poller) record(tool, url string, latency time.Duration, err error) {
+	now := p.now()
+	p.mu.Lock()
+	t, ok := p.state[tool]
+	if !ok || t.health.URL != url {
+		// Removed or repointed while the probe was out
+		p.mu.Unlock()
+		return
+	}
+	h := &t.health
+	previous := h.Status
+	h.CheckedAt = now.UTC()
+	h.LatencyMs = latency.Milliseconds()
+	h.Error = ""
+	next := p.opts.Interval
+	switch {
+	case err != nil:
+		h.ConsecutiveFailures++
+		h.Error = err.Error()
+		h.Status = types.HealthDegraded
+		if h.ConsecutiveFailures >= p.opts.DownAfter {
+			h.Status = types.HealthDown
+			next = p.backoff(h.ConsecutiveFailures - p.opts.DownAfter)
+		}
+	case p.opts.Slow > 0 && latency > p.opts.Slow:
+		h.ConsecutiveFailures = 0
+		h.Status = types.HealthDegraded
+	default:
+		h.ConsecutiveFailures = 0
+		h.Status = types.HealthUp
+	}
+	t.next = now.Add(next)
+	changed := h.Status != previous
+	if changed {
+		h.Since = now.UTC()
+	}
+	event := types.SimulatorStatusEvent{SimulatorHealth: *h, Previous: previous}
+	p.mu.Unlock()
+	if changed && p.notify != nil {
+		p.notify(event)
+	}
+}
+
+// backoff is the wait before the next probe of a simulator that has failed
+// n times since it went down.
+func (p *Poller) backoff(n int) time.Duration {
+	d := p.opts.Interval
+	for i := 0; i < n && d < p.opts.MaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, p.opts.MaxBackoff)
+}
+
+// Snapshot returns every simulator's cached status, by tool name. Simulators
+// not probed yet are unknown.
+func (p *Poller) Snapshot() []types.SimulatorHealth {
+	targets := p.targets()
+	p.mu.RLock()
+	out := make([]types.SimulatorHealth, 0, len(targets))
+	for tool, url := range targets {
+		if t, ok := p.state[tool]; ok && t.health.URL == url {
+			out = append(out, t.health)
+		} else {
+			out = append(out, types.SimulatorHealth{Tool: tool, URL: url, Status: types.HealthUnknown})
+		}
+	}
+	p.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Tool < out[j].Tool })
+	return out
+}
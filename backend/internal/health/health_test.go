@@ -0,0 +1,123 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"simstack/internal/types"
+)
+
+// flapping is a fake simulator whose health the test switches.
+type flapping struct {
+	healthy atomic.Bool
+	probes  atomic.Int64
+	*httptest.Server
+}
+
+func newFlapping(t *testing.T) *flapping {
+	f := &flapping{}
+	f.healthy.Store(true)
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.probes.Add(1)
+		if r.URL.Path != Path || !f.healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func TestTransitionsFireOnlyOnChange(t *testing.T) {
+	sim := newFlapping(t)
+	var transitions []string
+	p := New(sim.Client(), func() map[string]string { return map[string]string{"queue": sim.URL} },
+		Options{Interval: time.Second, Timeout: time.Second, DownAfter: 2, MaxBackoff: 4 * time.Second},
+		func(ev types.SimulatorStatusEvent) {
+			transitions = append(transitions, string(ev.Previous)+">"+string(ev.Status))
+		})
+	clock := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	p.now = func() time.Time { return clock }
+	poll := func(healthy bool) {
+		sim.healthy.Store(healthy)
+		p.poll(context.Background())
+		clock = clock.Add(time.Second)
+	}
+
+	poll(true)
+	poll(true)
+	poll(true)
+	poll(false) // degraded
+	poll(false) // down
+	poll(false) // still down, next probe 2s later
+	poll(false) // not due
+	poll(false) // still down, next probe 4s later
+	poll(true)  // not due
+	poll(true)  // not due
+	poll(true)  // not due
+	poll(true)  // up again
+	poll(true)
+
+	want := []string{"unknown>up", "up>degraded", "degraded>down", "down>up"}
+	if !reflect.DeepEqual(transitions, want) {
+		t.Errorf("transitions %v, want %v", transitions, want)
+	}
+	if got := sim.probes.Load(); got != 9 {
+		t.Errorf("expected a down simulator probed less often, got %d probes in 13 polls", got)
+	}
+
+	h := p.Snapshot()
+	if len(h) != 1 || h[0].Status != types.HealthUp || h[0].ConsecutiveFailures != 0 || h[0].Error != "" {
+		t.Errorf("unexpected cached status %+v", h)
+	}
+	if sim.probes.Load() != 9 {
+		t.Error("Snapshot must not probe")
+	}
+}
+
+func TestDegradedAndUnknown(t *testing.T) {
+	sim := newFlapping(t)
+	targets := map[string]string{"queue": sim.URL, "traffic": "http://127.0.0.1:1"}
+	p := New(sim.Client(), func() map[string]string { return targets }, Options{Interval: time.Second, Timeout: time.Second, Slow: time.Nanosecond, DownAfter: 1}, nil)
+
+	if h := p.Snapshot(); h[0].Status != types.HealthUnknown || h[1].Status != types.HealthUnknown {
+		t.Errorf("expected unknown before any probe, got %+v", h)
+	}
+	p.poll(context.Background())
+	h := p.Snapshot()
+	if h[0].Tool != "queue" || h[0].Status != types.HealthDegraded || h[1].Status != types.HealthDown || h[1].Error == "" {
+		t.Errorf("expected a slow queue and an unreachable traffic simulator, got %+v", h)
+	}
+
+	// A repointed simulator starts over
+	targets = map[string]string{"queue": sim.URL + "/"}
+	if h := p.Snapshot(); len(h) != 1 || h[0].Status != types.HealthUnknown {
+		t.Errorf("expected the repointed simulator unknown, got %+v", h)
+	}
+}
+
+func TestRunStopsWithContext(t *testing.T) {
+	sim := newFlapping(t)
+	p := New(sim.Client(), func() map[string]string { return map[string]string{"queue": sim.URL} }, Options{Interval: time.Millisecond, Timeout: time.Second}, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx)
+		close(done)
+	}()
+	for sim.probes.Load() < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after its context was canceled")
+	}
+}
@@ -0,0 +1,117 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"simstack/internal/cerebras"
+)
+
+// ChainEntry is one model in a fallback chain with its own attempt timeout
+// (zero means the caller's context is the only deadline).
+type ChainEntry struct {
+	Model   string
+	Timeout time.Duration
+}
+
+// ModelChain is an ordered list of models tried in turn until one answers.
+type ModelChain []ChainEntry
+
+// ChainResult reports which model answered and what it took to get there.
+type ChainResult struct {
+	Model     string
+	LatencyMs int64
+	Failed    []string
+}
+
+// ParseModelChain parses "model-a,model-b@30s" into a chain. Entries without
+// an explicit @timeout use defTimeout.
+func ParseModelChain(s string, defTimeout time.Duration) ModelChain {
+	var chain ModelChain
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		entry := ChainEntry{Model: part, Timeout: defTimeout}
+		if model, timeout, ok := strings.Cut(part, "@"); ok {
+			if d, err := time.ParseDuration(timeout); err == nil {
+				entry = ChainEntry{Model: strings.TrimSpace(model), Timeout: d}
+			}
+		}
+		chain = append(chain, entry)
+	}
+	return chain
+}
+
+func (c ModelChain) Models() []string {
+	models := make([]string, 0, len(c))
+	for _, e := range c {
+		models = append(models, e.Model)
+	}
+	return models
+}
+
+// Chat sends req to each model in the chain until one succeeds. Only
+// retryable failures (timeouts, rate limits, 5xx, network errors) move on to
+// the next model; anything else is returned immediately.
+func (c ModelChain) Chat(ctx context.Context, p ChatClient, req cerebras.OpenAIChatRequest) (map[string]any, ChainResult, error) {
+	var result ChainResult
+	start := time.Now()
+
+	if len(c) == 0 {
+		c = ModelChain{{Model: req.Model}}
+	}
+
+	var lastErr error
+	for _, entry := range c {
+		if ctx.Err() != nil {
+			break
+		}
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if entry.Timeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, entry.Timeout)
+		}
+		req.Model = entry.Model
+		resp, err := p.Chat(attemptCtx, req)
+		cancel()
+		if err == nil {
+			result.Model = entry.Model
+			result.LatencyMs = time.Since(start).Milliseconds()
+			return resp, result, nil
+		}
+
+		lastErr = err
+		result.Failed = append(result.Failed, entry.Model)
+		if ctx.Err() != nil || !Retryable(err) {
+			break
+		}
+	}
+	result.LatencyMs = time.Since(start).Milliseconds()
+	if lastErr == nil {
+		lastErr = ctx.Err()
+	}
+	return nil, result, lastErr
+}
+
+// Retryable reports whether a failed call is worth retrying on another model.
+// Cancellation never is: the caller has given up.
+func Retryable(err error) bool {
+	switch cerebras.Classify(err) {
+	case cerebras.ErrTimeout, cerebras.ErrConnection:
+		return true
+	case cerebras.ErrAPI:
+		var apiErr *cerebras.APIError
+		if !errors.As(err, &apiErr) {
+			return false
+		}
+		return apiErr.StatusCode == http.StatusTooManyRequests ||
+			apiErr.StatusCode == http.StatusRequestTimeout ||
+			apiErr.StatusCode >= 500
+	default:
+		return false
+	}
+}
@@ -0,0 +1,134 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"simstack/internal/cerebras"
+)
+
+// scriptedProvider answers per model from a script instead of calling out.
+type scriptedProvider struct {
+	script map[string]func(ctx context.Context) error
+	calls  []string
+}
+
+func (p *scriptedProvider) Name() string { return "scripted" }
+
+func (p *scriptedProvider) Chat(ctx context.Context, req cerebras.OpenAIChatRequest) (map[string]any, error) {
+	p.calls = append(p.calls, req.Model)
+	if fn, ok := p.script[req.Model]; ok {
+		if err := fn(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return map[string]any{"model": req.Model}, nil
+}
+
+func (p *scriptedProvider) ChatStream(ctx context.Context, req cerebras.OpenAIChatRequest, onDelta func(string)) (map[string]any, error) {
+	return p.Chat(ctx, req)
+}
+
+func (p *scriptedProvider) Models(ctx context.Context) ([]cerebras.ModelInfo, error) {
+	return nil, nil
+}
+
+func TestParseModelChain(t *testing.T) {
+	chain := ParseModelChain("llama3.1-8b, llama-3.3-70b@45s,", 10*time.Second)
+	if len(chain) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(chain))
+	}
+	if chain[0] != (ChainEntry{Model: "llama3.1-8b", Timeout: 10 * time.Second}) {
+		t.Errorf("unexpected first entry %+v", chain[0])
+	}
+	if chain[1] != (ChainEntry{Model: "llama-3.3-70b", Timeout: 45 * time.Second}) {
+		t.Errorf("unexpected second entry %+v", chain[1])
+	}
+}
+
+func TestModelChainFallsBack(t *testing.T) {
+	p := &scriptedProvider{script: map[string]func(context.Context) error{
+		"llama3.1-8b": func(context.Context) error {
+			return &cerebras.APIError{StatusCode: http.StatusServiceUnavailable, Status: "503 Service Unavailable"}
+		},
+	}}
+	chain := ModelChain{{Model: "llama3.1-8b"}, {Model: "llama-3.3-70b"}}
+
+	resp, result, err := chain.Chat(context.Background(), p, cerebras.OpenAIChatRequest{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp["model"] != "llama-3.3-70b" || result.Model != "llama-3.3-70b" {
+		t.Errorf("expected second model to answer, got %v / %+v", resp, result)
+	}
+	if len(result.Failed) != 1 || result.Failed[0] != "llama3.1-8b" {
+		t.Errorf("expected first model recorded as failed, got %v", result.Failed)
+	}
+}
+
+func TestModelChainPerModelTimeout(t *testing.T) {
+	p := &scriptedProvider{script: map[string]func(context.Context) error{
+		"slow": func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	}}
+	chain := ModelChain{{Model: "slow", Timeout: 20 * time.Millisecond}, {Model: "fast"}}
+
+	_, result, err := chain.Chat(context.Background(), p, cerebras.OpenAIChatRequest{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Model != "fast" {
+		t.Errorf("expected fast model after timeout, got %q", result.Model)
+	}
+}
+
+func TestModelChainStopsOnNonRetryable(t *testing.T) {
+	p := &scriptedProvider{script: map[string]func(context.Context) error{
+		"a": func(context.Context) error {
+			return &cerebras.APIError{StatusCode: http.StatusUnauthorized, Status: "401 Unauthorized"}
+		},
+	}}
+	chain := ModelChain{{Model: "a"}, {Model: "b"}}
+
+	_, _, err := chain.Chat(context.Background(), p, cerebras.OpenAIChatRequest{})
+	var apiErr *cerebras.APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected API error, got %v", err)
+	}
+	if len(p.calls) != 1 {
+		t.Errorf("expected chain to stop after non-retryable error, got calls %v", p.calls)
+	}
+}
+
+func TestModelChainExhausted(t *testing.T) {
+	fail := func(context.Context) error {
+		return &cerebras.APIError{StatusCode: http.StatusTooManyRequests, Status: "429 Too Many Requests"}
+	}
+	p := &scriptedProvider{script: map[string]func(context.Context) error{"a": fail, "b": fail}}
+
+	_, result, err := ModelChain{{Model: "a"}, {Model: "b"}}.Chat(context.Background(), p, cerebras.OpenAIChatRequest{})
+	if err == nil {
+		t.Fatal("expected error once chain is exhausted")
+	}
+	if len(result.Failed) != 2 || result.Model != "" {
+		t.Errorf("unexpected result %+v", result)
+	}
+}
+
+func TestModelChainNeverRetriesCanceled(t *testing.T) {
+	p := &scriptedProvider{script: map[string]func(context.Context) error{
+		"a": func(context.Context) error { return cerebras.WrapTransportError(context.Canceled) },
+	}}
+	_, _, err := ModelChain{{Model: "a"}, {Model: "b"}}.Chat(context.Background(), p, cerebras.OpenAIChatRequest{})
+	if !errors.Is(err, cerebras.ErrCanceled) {
+		t.Fatalf("expected canceled error, got %v", err)
+	}
+	if len(p.calls) != 1 {
+		t.Errorf("canceled calls must not fall through the chain, got calls %v", p.calls)
+	}
+}
@@ -0,0 +1,97 @@
+package llm
+
+import (
+	"context"
+	"strings"
+
+	"simstack/internal/cerebras"
+)
+
+const continuePrompt = "Your previous reply was cut off. Continue exactly where it stopped: output only the remaining text, without repeating anything already written and without commentary or code fences."
+
+// minOverlap is the shortest repeated prefix trimmed when stitching; shorter
+// matches (e.g. "}]") are as likely to be legitimate as repeated.
+const minOverlap = 16
+
+// ChatFunc sends one chat request.
+type ChatFunc func(ctx context.Context, req cerebras.OpenAIChatRequest) (map[string]any, error)
+
+// Continue finishes a completion that stopped on max_tokens. While the latest
+// piece ends with finish_reason "length" and fewer than max continuations have
+// been made, it re-sends req with the partial answer as an assistant turn and
+// asks the model to carry on, then stitches the pieces together. It returns
+// resp rewritten to hold the stitched content, the last finish_reason and
+// summed usage, plus how many continuations were made. An error from a
+// continuation call is returned with whatever was stitched so far.
+func Continue(ctx context.Context, chat ChatFunc, req cerebras.OpenAIChatRequest, resp map[string]any, max int) (map[string]any, int, error) {
+	content, _ := cerebras.MessageContent(resp)
+	finish := cerebras.FinishReason(resp)
+	usage := usageOf(resp)
+
+	n := 0
+	for ; finish == cerebras.FinishLength && n < max; n++ {
+		next := req
+		next.Messages = append(append([]cerebras.ChatMessage{}, req.Messages...),
+			cerebras.ChatMessage{Role: "assistant", Content: content},
+			cerebras.ChatMessage{Role: "user", Content: continuePrompt},
+		)
+		// A partial JSON document can't satisfy a schema; ask for plain text
+		next.ResponseFormat = nil
+
+		part, err := chat(ctx, next)
+		if err != nil {
+			return stitched(resp, content, finish, usage), n, err
+		}
+		piece, _ := cerebras.MessageContent(part)
+		content = Stitch(content, piece)
+		finish = cerebras.FinishReason(part)
+		addUsage(usage, usageOf(part))
+	}
+	return stitched(resp, content, finish, usage), n, nil
+}
+
+// Stitch appends piece to content, dropping a repeated overlap of at least
+// minOverlap bytes where the model restated the tail of what it had written.
+func Stitch(content, piece string) string {
+	for k := min(len(content), len(piece)); k >= minOverlap; k-- {
+		if strings.HasSuffix(content, piece[:k]) {
+			return content + piece[k:]
+		}
+	}
+	return content + piece
+}
+
+func stitched(resp map[string]any, content, finish string, usage map[string]any) map[string]any {
+	out := make(map[string]any, len(resp))
+	for k, v := range resp {
+		out[k] = v
+	}
+	out["choices"] = []any{map[string]any{
+		"message":       map[string]any{"role": "assistant", "content": content},
+		"finish_reason": finish,
+	}}
+	if len(usage) > 0 {
+		out["usage"] = usage
+	}
+	return out
+}
+
+func usageOf(resp map[string]any) map[string]any {
+	out := map[string]any{}
+	if usage, ok := resp["usage"].(map[string]any); ok {
+		for k, v := range usage {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+func addUsage(total, part map[string]any) {
+	for _, k := range []string{"prompt_tokens", "completion_tokens", "total_tokens"} {
+		a, _ := total[k].(float64)
+		b, _ := part[k].(float64)
+		if a+b > 0 {
+			total[k] = a + b
+		}
+	}
+}
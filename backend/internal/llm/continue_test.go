@@ -0,0 +1,55 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"simstack/internal/cerebras"
+	"simstack/internal/testsupport"
+)
+
+func TestStitch(t *testing.T) {
+	cases := []struct {
+		content, piece, want string
+	}{
+		{`{"a": [1, 2`, `, 3]}`, `{"a": [1, 2, 3]}`},
+		{`{"a": "x"}]`, `}]`, `{"a": "x"}]}]`},
+		{`{"variants": [{"id": "v1", "queue"`, `{"id": "v1", "queue": {}}]}`, `{"variants": [{"id": "v1", "queue": {}}]}`},
+		{"", `{"a": 1}`, `{"a": 1}`},
+	}
+	for _, tc := range cases {
+		if got := Stitch(tc.content, tc.piece); got != tc.want {
+			t.Errorf("Stitch(%q, %q) = %q, want %q", tc.content, tc.piece, got, tc.want)
+		}
+	}
+}
+
+func TestContinueBounded(t *testing.T) {
+	fake := testsupport.NewFakeChat(testsupport.Truncated("b"), testsupport.Truncated("c"), testsupport.Truncated("d"))
+	req := cerebras.OpenAIChatRequest{Model: "m", Messages: []cerebras.ChatMessage{{Role: "user", Content: "go"}}, ResponseFormat: cerebras.JSONObjectFormat()}
+
+	resp, n, err := Continue(context.Background(), fake.Chat, req, testsupport.Truncated("a").Response, 2)
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 continuations, got %d (%v)", n, err)
+	}
+	if content, _ := cerebras.MessageContent(resp); content != "abc" || cerebras.FinishReason(resp) != cerebras.FinishLength {
+		t.Errorf("unexpected stitched response %v", resp)
+	}
+	if total := resp["usage"].(map[string]any)["total_tokens"]; total != 450.0 {
+		t.Errorf("expected summed usage, got %v", total)
+	}
+	second := fake.Requests[1]
+	if len(second.Messages) != 3 || second.Messages[1].Content != "ab" || second.ResponseFormat != nil {
+		t.Errorf("continuation should resend the partial answer without response_format: %+v", second)
+	}
+
+	failing := testsupport.NewFakeChat(testsupport.Error(errors.New("boom")))
+	resp, n, err = Continue(context.Background(), failing.Chat, req, testsupport.Truncated("a").Response, 2)
+	if err == nil || n != 0 {
+		t.Errorf("expected error after 0 continuations, got %d (%v)", n, err)
+	}
+	if content, _ := cerebras.MessageContent(resp); content != "a" {
+		t.Errorf("expected partial content kept, got %q", content)
+	}
+}
@@ -0,0 +1,149 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"simstack/internal/cerebras"
+)
+
+// Embedder turns texts into vectors comparable with Cosine. Name identifies
+// the vector space: vectors from differently named embedders must not be compared.
+type Embedder interface {
+	Name() string
+	Embed(ctx context.Context, texts []string) ([][]float64, error)
+}
+
+// EmbeddingsAPI is implemented by providers with an embeddings endpoint.
+type EmbeddingsAPI interface {
+	Embeddings(ctx context.Context, req cerebras.EmbeddingRequest) (cerebras.EmbeddingResponse, error)
+}
+
+// NewEmbedder uses the provider's embeddings endpoint when it has one and a
+// model is configured, degrading to trigram similarity otherwise.
+func NewEmbedder(p ChatClient, model string) Embedder {
+	api, ok := p.(EmbeddingsAPI)
+	if !ok || model == "" {
+		return TrigramEmbedder{}
+	}
+	return &fallbackEmbedder{primary: &apiEmbedder{api: api, model: model}, fallback: TrigramEmbedder{}}
+}
+
+type apiEmbedder struct {
+	api   EmbeddingsAPI
+	model string
+}
+
+func (e *apiEmbedder) Name() string {
+	return "api:" + e.model
+}
+
+func (e *apiEmbedder) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+	resp, err := e.api.Embeddings(ctx, cerebras.EmbeddingRequest{Model: e.model, Input: texts})
+	if err != nil {
+		return nil, err
+	}
+	out := make([][]float64, len(texts))
+	for _, d := range resp.Data {
+		if d.Index >= 0 && d.Index < len(out) {
+			out[d.Index] = d.Embedding
+		}
+	}
+	return out, nil
+}
+
+// fallbackEmbedder switches permanently to the fallback once the provider
+// shows it has no embeddings endpoint, so the vector space stays stable.
+type fallbackEmbedder struct {
+	primary     Embedder
+	fallback    Embedder
+	unsupported atomic.Bool
+}
+
+func (e *fallbackEmbedder) Name() string {
+	if e.unsupported.Load() {
+		return e.fallback.Name()
+	}
+	return e.primary.Name()
+}
+
+func (e *fallbackEmbedder) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+	if e.unsupported.Load() {
+		return e.fallback.Embed(ctx, texts)
+	}
+	out, err := e.primary.Embed(ctx, texts)
+	if err != nil && endpointMissing(err) {
+		log.Printf("embeddings endpoint unavailable, using trigram similarity: %v", err)
+		e.unsupported.Store(true)
+		return e.fallback.Embed(ctx, texts)
+	}
+	return out, err
+}
+
+func endpointMissing(err error) bool {
+	var apiErr *cerebras.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.StatusCode {
+	case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return true
+	}
+	return false
+}
+
+// TrigramEmbedder hashes character trigrams into a fixed-size count vector, so
+// Cosine over its output is trigram text similarity. Needs no provider.
+type TrigramEmbedder struct{}
+
+const trigramDims = 512
+
+func (TrigramEmbedder) Name() string {
+	return "trigram"
+}
+
+func (TrigramEmbedder) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+	out := make([][]float64, len(texts))
+	for i, t := range texts {
+		out[i] = trigramVector(t)
+	}
+	return out, nil
+}
+
+func trigramVector(text string) []float64 {
+	v := make([]float64, trigramDims)
+	// Pad words so short words and word boundaries still produce trigrams
+	for _, word := range strings.Fields(strings.ToLower(text)) {
+		runes := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(runes); i++ {
+			h := fnv.New32a()
+			_, _ = h.Write([]byte(string(runes[i : i+3])))
+			v[h.Sum32()%trigramDims]++
+		}
+	}
+	return v
+}
+
+// Cosine returns the cosine similarity of a and b, or 0 when either is empty,
+// zero, or the lengths differ.
+func Cosine(a, b []float64) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += a[i] * b[i]
+		na += a[i] * a[i]
+		nb += b[i] * b[i]
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
@@ -0,0 +1,76 @@
+package llm
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"testing"
+
+	"simstack/internal/cerebras"
+)
+
+func TestCosine(t *testing.T) {
+	cases := []struct {
+		a, b []float64
+		want float64
+	}{
+		{[]float64{1, 0}, []float64{1, 0}, 1},
+		{[]float64{1, 0}, []float64{0, 1}, 0},
+		{[]float64{1, 2, 3}, []float64{-1, -2, -3}, -1},
+		{[]float64{3, 4}, []float64{4, 3}, 24.0 / 25.0},
+		{[]float64{0, 0}, []float64{1, 1}, 0},
+		{[]float64{1}, []float64{1, 2}, 0},
+		{nil, nil, 0},
+	}
+	for _, tc := range cases {
+		if got := Cosine(tc.a, tc.b); math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("Cosine(%v, %v) = %v, want %v", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestTrigramSimilarityRanksRelatedGoals(t *testing.T) {
+	vecs, _ := TrigramEmbedder{}.Embed(context.Background(), []string{
+		"reduce ER wait time by 20%",
+		"Reduce emergency room wait times",
+		"optimize traffic signal timing downtown",
+	})
+	related, unrelated := Cosine(vecs[0], vecs[1]), Cosine(vecs[0], vecs[2])
+	if related <= unrelated {
+		t.Errorf("expected related goals to score higher: %.3f vs %.3f", related, unrelated)
+	}
+	if self := Cosine(vecs[0], vecs[0]); math.Abs(self-1) > 1e-9 {
+		t.Errorf("expected self-similarity 1, got %v", self)
+	}
+}
+
+type missingEmbeddings struct{ calls int }
+
+func (m *missingEmbeddings) Chat(ctx context.Context, req cerebras.OpenAIChatRequest) (map[string]any, error) {
+	return nil, nil
+}
+
+func (m *missingEmbeddings) Embeddings(ctx context.Context, req cerebras.EmbeddingRequest) (cerebras.EmbeddingResponse, error) {
+	m.calls++
+	return cerebras.EmbeddingResponse{}, &cerebras.APIError{StatusCode: http.StatusNotFound, Status: "404 Not Found"}
+}
+
+func TestEmbedderDegradesToTrigram(t *testing.T) {
+	api := &missingEmbeddings{}
+	e := NewEmbedder(api, "text-embedding-3-small")
+	if e.Name() != "api:text-embedding-3-small" {
+		t.Errorf("unexpected name %q", e.Name())
+	}
+
+	vecs, err := e.Embed(context.Background(), []string{"a goal"})
+	if err != nil || len(vecs) != 1 || len(vecs[0]) != trigramDims {
+		t.Fatalf("expected trigram vectors after 404, got %v %v", vecs, err)
+	}
+	if e.Name() != "trigram" {
+		t.Errorf("expected embedder to report trigram space, got %q", e.Name())
+	}
+	_, _ = e.Embed(context.Background(), []string{"another"})
+	if api.calls != 1 {
+		t.Errorf("expected the missing endpoint to be tried once, got %d", api.calls)
+	}
+}
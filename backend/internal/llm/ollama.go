@@ -0,0 +1,307 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"simstack/internal/cerebras"
+	"simstack/internal/transport"
+)
+
+// Ollama adapts Ollama's native /api/chat endpoint to the OpenAI response shape.
+type Ollama struct {
+	http    *http.Client
+	base    string
+	limiter *cerebras.Limiter
+	offline atomic.Bool
+}
+
+func NewOllama(baseURL string) *Ollama {
+	return &Ollama{
+		http: &http.Client{Timeout: 120 * time.Second, Transport: transport.SharedLLM()},
+		base: strings.TrimRight(baseURL, "/"),
+	}
+}
+
+func (o *Ollama) SetTransport(rt http.RoundTripper) {
+	o.http = &http.Client{Timeout: o.http.Timeout, Transport: rt}
+}
+
+// SetOffline refuses every call with cerebras.ErrOffline while on.
+func (o *Ollama) SetOffline(on bool) {
+	o.offline.Store(on)
+}
+
+func (o *Ollama) refuse(ctx context.Context) bool {
+	return o.offline.Load() || cerebras.IsOffline(ctx)
+}
+
+func (o *Ollama) Name() string {
+	return "ollama"
+}
+
+type ollamaMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type ollamaChatRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+	Format   any             `json:"format,omitempty"`
+	Options  map[string]any  `json:"options,omitempty"`
+}
+
+type ollamaChatResponse struct {
+	Model           string        `json:"model"`
+	Message         ollamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	DoneReason      string        `json:"done_reason"`
+	PromptEvalCount int           `json:"prompt_eval_count"`
+	EvalCount       int           `json:"eval_count"`
+}
+
+func (o *Ollama) LimiterStats() cerebras.LimiterStats {
+	return o.limiter.Stats()
+}
+
+// SetLimits changes the limits of the provider's limiter; one without a
+// limiter stays unlimited.
+func (o *Ollama) SetLimits(rpm, burst, maxConcurrent int) {
+	o.limiter.SetLimits(rpm, burst, maxConcurrent)
+}
+
+func (o *Ollama) Chat(ctx context.Context, req cerebras.OpenAIChatRequest) (map[string]any, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	release, err := o.limiter.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	resp, err := o.post(ctx, o.toNative(req, false))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var out ollamaChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	return toOpenAI(req, out, out.Message.Content), nil
+}
+
+func (o *Ollama) ChatStream(ctx context.Context, req cerebras.OpenAIChatRequest, onDelta func(string)) (map[string]any, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	release, err := o.limiter.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	resp, err := o.post(ctx, o.toNative(req, true))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// Streaming responses are newline-delimited JSON objects, the last with done=true
+	var content strings.Builder
+	var last ollamaChatResponse
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var chunk ollamaChatResponse
+		if err := json.Unmarshal(scanner.Bytes(), &chunk); err != nil {
+			continue
+		}
+		if chunk.Message.Content != "" {
+			content.WriteString(chunk.Message.Content)
+			if onDelta != nil {
+				onDelta(chunk.Message.Content)
+			}
+		}
+		last = chunk
+		if chunk.Done {
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return toOpenAI(req, last, content.String()), nil
+}
+
+func (o *Ollama) Models(ctx context.Context) ([]cerebras.ModelInfo, error) {
+	if o.refuse(ctx) {
+		return nil, cerebras.ErrOffline
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, o.base+"/api/tags", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := o.http.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("ollama error: %s", resp.Status)
+	}
+
+	var out struct {
+		Models []struct {
+			Name       string    `json:"name"`
+			ModifiedAt time.Time `json:"modified_at"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	models := make([]cerebras.ModelInfo, 0, len(out.Models))
+	for _, m := range out.Models {
+		info := cerebras.ModelInfo{ID: m.Name, OwnedBy: "ollama"}
+		if !m.ModifiedAt.IsZero() {
+			info.Created = m.ModifiedAt.Unix()
+		}
+		info.ContextWindow, _ = cerebras.ContextWindow(m.Name)
+		models = append(models, info)
+	}
+	return models, nil
+}
+
+func (o *Ollama) toNative(req cerebras.OpenAIChatRequest, stream bool) ollamaChatRequest {
+	native := ollamaChatRequest{
+		Model:   req.Model,
+		Stream:  stream,
+		Options: map[string]any{"temperature": req.Temperature},
+	}
+	// Sampling options live under options with Ollama's own names
+	if req.MaxTokens > 0 {
+		native.Options["num_predict"] = req.MaxTokens
+	}
+	if len(req.Stop) > 0 {
+		native.Options["stop"] = req.Stop
+	}
+	if req.TopP > 0 {
+		native.Options["top_p"] = req.TopP
+	}
+	if req.FrequencyPenalty != 0 {
+		native.Options["frequency_penalty"] = req.FrequencyPenalty
+	}
+	if req.PresencePenalty != 0 {
+		native.Options["presence_penalty"] = req.PresencePenalty
+	}
+	for _, m := range req.Messages {
+		native.Messages = append(native.Messages, ollamaMessage{Role: m.Role, Content: fmt.Sprint(m.Content)})
+	}
+	// Ollama takes the schema (or "json") directly in format
+	if rf := req.ResponseFormat; rf != nil {
+		if rf.JSONSchema != nil {
+			native.Format = rf.JSONSchema.Schema
+		} else {
+			native.Format = "json"
+		}
+	}
+	return native
+}
+
+func (o *Ollama) post(ctx context.Context, body ollamaChatRequest) (*http.Response, error) {
+	return o.do(ctx, "/api/chat", body)
+}
+
+func (o *Ollama) do(ctx context.Context, path string, body any) (*http.Response, error) {
+	if o.refuse(ctx) {
+		return nil, cerebras.ErrOffline
+	}
+	b, _ := json.Marshal(body)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, o.base+path, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	resp, err := o.http.Do(httpReq)
+	if err != nil {
+		return nil, cerebras.WrapTransportError(err)
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, &cerebras.APIError{Provider: "ollama", StatusCode: resp.StatusCode, Status: resp.Status, Body: strings.TrimSpace(string(msg))}
+	}
+	return resp, nil
+}
+
+func toOpenAI(req cerebras.OpenAIChatRequest, out ollamaChatResponse, content string) map[string]any {
+	// Older Ollama builds omit the eval counts, so fall back to an estimate
+	prompt, completion := out.PromptEvalCount, out.EvalCount
+	if prompt == 0 {
+		for _, m := range req.Messages {
+			prompt += EstimateTokens(fmt.Sprint(m.Content))
+		}
+	}
+	if completion == 0 {
+		completion = EstimateTokens(content)
+	}
+	finish := out.DoneReason
+	if finish == "" {
+		finish = "stop"
+	}
+	return map[string]any{
+		"model": out.Model,
+		"choices": []any{map[string]any{
+			"message":       map[string]any{"role": "assistant", "content": content},
+			"finish_reason": finish,
+		}},
+		"usage": map[string]any{
+			"prompt_tokens":     float64(prompt),
+			"completion_tokens": float64(completion),
+			"total_tokens":      float64(prompt + completion),
+		},
+	}
+}
+
+// Embeddings adapts Ollama's /api/embed to the OpenAI embeddings shape.
+func (o *Ollama) Embeddings(ctx context.Context, req cerebras.EmbeddingRequest) (cerebras.EmbeddingResponse, error) {
+	release, err := o.limiter.Acquire(ctx)
+	if err != nil {
+		return cerebras.EmbeddingResponse{}, err
+	}
+	defer release()
+
+	resp, err := o.do(ctx, "/api/embed", map[string]any{"model": req.Model, "input": req.Input})
+	if err != nil {
+		return cerebras.EmbeddingResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	var out struct {
+		Model           string      `json:"model"`
+		Embeddings      [][]float64 `json:"embeddings"`
+		PromptEvalCount int         `json:"prompt_eval_count"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return cerebras.EmbeddingResponse{}, err
+	}
+	res := cerebras.EmbeddingResponse{Model: out.Model}
+	for i, v := range out.Embeddings {
+		res.Data = append(res.Data, cerebras.Embedding{Index: i, Embedding: v})
+	}
+	res.Usage.PromptTokens = out.PromptEvalCount
+	res.Usage.TotalTokens = out.PromptEvalCount
+	return res, nil
+}
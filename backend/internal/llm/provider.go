@@ -0,0 +1,276 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"simstack/internal/cerebras"
+)
+
+// ChatClient is the narrow surface the engine depends on. *cerebras.Client
+// satisfies it as-is, and tests can substitute a scripted fake.
+type ChatClient interface {
+	Chat(ctx context.Context, req cerebras.OpenAIChatRequest) (map[string]any, error)
+}
+
+// ChatProvider is an LLM backend speaking (or adapted to) the OpenAI chat
+// completions shape. Responses are always returned in that shape, including a
+// usage block, regardless of what the backend natively returns.
+type ChatProvider interface {
+	ChatClient
+	Name() string
+	ChatStream(ctx context.Context, req cerebras.OpenAIChatRequest, onDelta func(string)) (map[string]any, error)
+	ModelLister
+}
+
+// ModelLister is implemented by providers that can enumerate their models.
+type ModelLister interface {
+	Models(ctx context.Context) ([]cerebras.ModelInfo, error)
+}
+
+var (
+	_ ChatClient   = (*cerebras.Client)(nil)
+	_ ChatProvider = (*cerebras.Client)(nil)
+	_ ChatProvider = (*Ollama)(nil)
+)
+
+type Config struct {
+	Provider string
+	BaseURL  string
+	APIKey   string
+	Model    string
+
+	// Model for goal embeddings; empty means trigram similarity only
+	EmbeddingModel string
+
+	// Client-side rate limits shared by every call through the provider
+	RPM           int
+	Burst         int
+	MaxConcurrent int
+
+	// Transport overrides the shared LLM transport when set
+	Transport http.RoundTripper
+
+	// Offline makes the provider refuse every call with cerebras.ErrOffline
+	Offline bool
+
+	// Upper bound on any one call when neither caller nor call sets one;
+	// zero keeps the client default
+	Ceiling time.Duration
+
+	// Gateway extras for OpenAI-compatible providers: headers and query
+	// parameters sent on every request, and the header carrying the API key
+	Headers          map[string]string
+	SensitiveHeaders []string
+	QueryParams      map[string]string
+	AuthHeader       string
+	AuthScheme       string
+}
+
+// LimitReporter is implemented by providers with a client-side rate limiter.
+type LimitReporter interface {
+	LimiterStats() cerebras.LimiterStats
+}
+
+// LimitSetter is implemented by providers whose client-side limits can change
+// while they are in use.
+type LimitSetter interface {
+	SetLimits(rpm, burst, maxConcurrent int)
+}
+
+var defaultModels = map[string]string{
+	"cerebras": "llama3.1-8b",
+	"openai":   "gpt-4o-mini",
+	"ollama":   "llama3.1",
+}
+
+// Cerebras serves no embeddings, so it has no default here.
+var defaultEmbeddingModels = map[string]string{
+	"openai": "text-embedding-3-small",
+	"ollama": "nomic-embed-text",
+}
+
+// ConfigFrom reads LLM_PROVIDER and the matching base URL, key and model
+// through getenv, as config.Load does. The CEREBRAS_* variables keep
+// working for the default provider.
+func ConfigFrom(getenv func(string) string) Config {
+	env := envReader(getenv)
+	cfg := Config{
+		Provider: strings.ToLower(env.get("LLM_PROVIDER", "cerebras")),
+		BaseURL:  getenv("LLM_API_BASE"),
+		APIKey:   getenv("LLM_API_KEY"),
+		Model:    getenv("LLM_MODEL"),
+
+		RPM:           env.int("LLM_RPM"),
+		Burst:         env.int("LLM_BURST"),
+		MaxConcurrent: env.int("LLM_MAX_CONCURRENT"),
+
+		Headers:          env.pairs("LLM_HEADERS"),
+		SensitiveHeaders: env.list("LLM_SENSITIVE_HEADERS"),
+		QueryParams:      env.pairs("LLM_QUERY_PARAMS"),
+		AuthHeader:       getenv("LLM_AUTH_HEADER"),
+		AuthScheme:       getenv("LLM_AUTH_SCHEME"),
+	}
+	cfg.Ceiling, _ = time.ParseDuration(getenv("LLM_CLIENT_CEILING"))
+	if cfg.Provider == "cerebras" {
+		if cfg.BaseURL == "" {
+			cfg.BaseURL = getenv("CEREBRAS_API_BASE")
+		}
+		if cfg.APIKey == "" {
+			cfg.APIKey = getenv("CEREBRAS_API_KEY")
+		}
+		if cfg.Model == "" {
+			cfg.Model = getenv("CEREBRAS_MODEL")
+		}
+	}
+	if cfg.Model == "" {
+		cfg.Model = defaultModels[cfg.Provider]
+	}
+	cfg.EmbeddingModel = env.get("LLM_EMBEDDING_MODEL", defaultEmbeddingModels[cfg.Provider])
+	return cfg
+}
+
+// KnownProvider reports whether provider works without an explicit base URL.
+func KnownProvider(provider string) bool {
+	switch provider {
+	case "", "cerebras", "openai", "ollama":
+		return true
+	}
+	return false
+}
+
+func New(cfg Config) (ChatProvider, error) {
+	// Always present, even unlimited, so the limits can be set later
+	limiter := cerebras.NewLimiter(cfg.RPM, cfg.Burst, cfg.MaxConcurrent)
+
+	openAICompatible := func(base string) *cerebras.Client {
+		c := cerebras.NewClient(base, cfg.APIKey)
+		c.SetLimiter(limiter)
+		c.SetOffline(cfg.Offline)
+		if cfg.Ceiling > 0 {
+			c.SetCeiling(cfg.Ceiling)
+		}
+		if cfg.Transport != nil {
+			c.SetTransport(cfg.Transport)
+		}
+		for name, value := range cfg.Headers {
+			c.SetHeader(name, value, containsFold(cfg.SensitiveHeaders, name))
+		}
+		for key, value := range cfg.QueryParams {
+			c.SetQueryParam(key, value)
+		}
+		if cfg.AuthHeader != "" {
+			c.SetAuthHeader(cfg.AuthHeader, cfg.AuthScheme)
+		}
+		return c
+	}
+
+	switch cfg.Provider {
+	case "", "cerebras":
+		return openAICompatible(orDefault(cfg.BaseURL, "https://api.cerebras.ai/v1")), nil
+	case "openai":
+		return &openAIProvider{Client: openAICompatible(orDefault(cfg.BaseURL, "https://api.openai.com/v1")), name: "openai"}, nil
+	case "ollama":
+		o := NewOllama(orDefault(cfg.BaseURL, "http://localhost:11434"))
+		o.limiter = limiter
+		o.SetOffline(cfg.Offline)
+		if cfg.Transport != nil {
+			o.SetTransport(cfg.Transport)
+		}
+		return o, nil
+	default:
+		// Anything else is assumed to be an OpenAI-compatible gateway
+		if cfg.BaseURL == "" {
+			return nil, fmt.Errorf("llm: provider %q needs LLM_API_BASE", cfg.Provider)
+		}
+		return &openAIProvider{Client: openAICompatible(cfg.BaseURL), name: cfg.Provider}, nil
+	}
+}
+
+// openAIProvider reuses the Cerebras client, which is plain OpenAI-compatible
+// HTTP, under a different name.
+type openAIProvider struct {
+	*cerebras.Client
+	name string
+}
+
+func (p *openAIProvider) Name() string {
+	return p.name
+}
+
+// NameOf returns the provider name for a client, or "llm" if it has none.
+func NameOf(c ChatClient) string {
+	if named, ok := c.(interface{ Name() string }); ok {
+		return named.Name()
+	}
+	return "llm"
+}
+
+// EstimateTokens estimates token count for backends that don't report usage.
+func EstimateTokens(s string) int {
+	return cerebras.EstimateTokens(s)
+}
+
+func orDefault(v, def string) string {
+	if v == "" {
+		return def
+	}
+	return v
+}
+
+// envReader reads configuration variables through a getenv function.
+type envReader func(string) string
+
+func (getenv envReader) int(key string) int {
+	n, _ := strconv.Atoi(getenv(key))
+	return n
+}
+
+func (getenv envReader) get(key, def string) string {
+	v := getenv(key)
+	if v == "" {
+		return def
+	}
+	return v
+}
+
+// list reads a comma-separated list, dropping empty entries.
+func (getenv envReader) list(key string) []string {
+	var out []string
+	for _, part := range strings.Split(getenv(key), ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// pairs reads comma-separated name=value pairs, e.g.
+// "X-Org-ID=acme,X-Cost-Center=42".
+func (getenv envReader) pairs(key string) map[string]string {
+	var out map[string]string
+	for _, part := range getenv.list(key) {
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			continue
+		}
+		if out == nil {
+			out = map[string]string{}
+		}
+		out[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return out
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
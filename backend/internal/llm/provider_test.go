@@ -0,0 +1,154 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"simstack/internal/cerebras"
+)
+
+// fixtureServer replays recorded provider responses from testdata.
+func fixtureServer(t *testing.T, routes map[string]string) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.URL.Path
+		if r.Method == http.MethodPost {
+			var body struct {
+				Stream bool `json:"stream"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			if body.Stream {
+				key += "#stream"
+			}
+		}
+		name, ok := routes[key]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		b, err := os.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Errorf("read fixture: %v", err)
+			return
+		}
+		_, _ = w.Write(b)
+	}))
+}
+
+var openAIRoutes = map[string]string{
+	"/v1/chat/completions":        "openai_chat.json",
+	"/v1/chat/completions#stream": "openai_stream.txt",
+	"/v1/models":                  "openai_models.json",
+}
+
+var ollamaRoutes = map[string]string{
+	"/api/chat":        "ollama_chat.json",
+	"/api/chat#stream": "ollama_stream.ndjson",
+	"/api/tags":        "ollama_tags.json",
+}
+
+func TestProviderConformance(t *testing.T) {
+	cases := []struct {
+		provider string
+		routes   map[string]string
+		suffix   string
+		models   []string
+	}{
+		{"cerebras", openAIRoutes, "/v1", []string{"llama3.1-8b", "llama3.1-70b"}},
+		{"openai", openAIRoutes, "/v1", []string{"llama3.1-8b", "llama3.1-70b"}},
+		{"vllm", openAIRoutes, "/v1", []string{"llama3.1-8b", "llama3.1-70b"}},
+		{"ollama", ollamaRoutes, "", []string{"llama3.1:latest", "qwen2.5:7b"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.provider, func(t *testing.T) {
+			srv := fixtureServer(t, tc.routes)
+			defer srv.Close()
+
+			p, err := New(Config{Provider: tc.provider, BaseURL: srv.URL + tc.suffix, Model: "m"})
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			if p.Name() != tc.provider {
+				t.Errorf("expected name %q, got %q", tc.provider, p.Name())
+			}
+
+			req := cerebras.OpenAIChatRequest{
+				Model:    "m",
+				Messages: []cerebras.ChatMessage{{Role: "user", Content: "Create 3 test variants."}},
+			}
+			resp, err := p.Chat(context.Background(), req)
+			if err != nil {
+				t.Fatalf("Chat: %v", err)
+			}
+			assertConforms(t, resp)
+
+			var deltas []string
+			streamed, err := p.ChatStream(context.Background(), req, func(d string) { deltas = append(deltas, d) })
+			if err != nil {
+				t.Fatalf("ChatStream: %v", err)
+			}
+			assertConforms(t, streamed)
+			if strings.Join(deltas, "") != `{"variants": []}` {
+				t.Errorf("unexpected deltas %q", deltas)
+			}
+
+			models, err := p.Models(context.Background())
+			if err != nil {
+				t.Fatalf("Models: %v", err)
+			}
+			ids := make([]string, 0, len(models))
+			for _, m := range models {
+				ids = append(ids, m.ID)
+			}
+			if strings.Join(ids, ",") != strings.Join(tc.models, ",") {
+				t.Errorf("expected models %v, got %v", tc.models, models)
+			}
+		})
+	}
+}
+
+func assertConforms(t *testing.T, resp map[string]any) {
+	t.Helper()
+	content, ok := cerebras.MessageContent(resp)
+	if !ok || content != `{"variants": []}` {
+		t.Errorf("unexpected content %q", content)
+	}
+	usage, ok := resp["usage"].(map[string]any)
+	if !ok {
+		t.Fatalf("response has no usage block: %v", resp)
+	}
+	if total, _ := usage["total_tokens"].(float64); total <= 0 {
+		t.Errorf("expected positive total_tokens, got %v", usage["total_tokens"])
+	}
+}
+
+func TestConfigFrom(t *testing.T) {
+	env := map[string]string{"LLM_PROVIDER": "Ollama"}
+	getenv := func(key string) string { return env[key] }
+	cfg := ConfigFrom(getenv)
+	if cfg.Provider != "ollama" || cfg.Model != "llama3.1" {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+
+	env["LLM_PROVIDER"] = "gateway"
+	if _, err := New(ConfigFrom(getenv)); err == nil {
+		t.Error("expected error for unknown provider without base URL")
+	}
+
+	env["LLM_HEADERS"] = "X-Org-ID=acme, X-Cost-Center=42,broken"
+	env["LLM_QUERY_PARAMS"] = "api-version=2024-06-01"
+	cfg = ConfigFrom(getenv)
+	if cfg.Headers["X-Org-ID"] != "acme" || cfg.Headers["X-Cost-Center"] != "42" || len(cfg.Headers) != 2 {
+		t.Errorf("unexpected headers %v", cfg.Headers)
+	}
+	if cfg.QueryParams["api-version"] != "2024-06-01" {
+		t.Errorf("unexpected query params %v", cfg.QueryParams)
+	}
+}
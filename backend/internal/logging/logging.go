@@ -0,0 +1,138 @@
+// Package logging carries request and run identifiers in contexts into
+// structured logs, so that one run can be followed from the request that
+// started it through planning, its simulators and the critic. Code logs with
+// slog's Context functions; Handler adds what the context carries.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+)
+
+// Longest X-Request-ID taken from a client
+const maxRequestID = 128
+
+type attrsKey struct{}
+type requestIDKey struct{}
+
+// With returns ctx with args, alternating keys and values as for
+// slog.Logger.Info, added to every record logged with it.
+func With(ctx context.Context, args ...any) context.Context {
+	if len(args) == 0 {
+		return ctx
+	}
+	prev, _ := ctx.Value(attrsKey{}).([]slog.Attr)
+	r := slog.Record{}
+	r.Add(args...)
+	attrs := make([]slog.Attr, len(prev), len(prev)+r.NumAttrs())
+	copy(attrs, prev)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+	return context.WithValue(ctx, attrsKey{}, attrs)
+}
+
+// WithRequestID returns ctx carrying request ID id, which its records are
+// logged with as request_id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return With(context.WithValue(ctx, requestIDKey{}, id), "request_id", id)
+}
+
+// RequestID returns the request ID ctx carries, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Detach returns a background context carrying ctx's request ID and
+// attributes, for work that outlives the request, such as a run.
+func Detach(ctx context.Context) context.Context {
+	out := context.Background()
+	if id := RequestID(ctx); id != "" {
+		out = context.WithValue(out, requestIDKey{}, id)
+	}
+	if attrs, ok := ctx.Value(attrsKey{}).([]slog.Attr); ok {
+		out = context.WithValue(out, attrsKey{}, attrs)
+	}
+	return out
+}
+
+// NewRequestID returns a random request ID.
+func NewRequestID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ValidRequestID reports whether a client's X-Request-ID can be kept: up to
+// 128 letters, digits and -_.:, so that it is safe in logs and headers.
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestID {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Handler wraps h, adding to each record the attributes of the context it
+// was logged with.
+func Handler(h slog.Handler) slog.Handler {
+	return contextHandler{h}
+}
+
+// New returns a logger writing format, "json" or "text", to w through
+// Handler.
+func New(w io.Writer, format string) *slog.Logger {
+	var h slog.Handler = slog.NewTextHandler(w, nil)
+	if format == "json" {
+		h = slog.NewJSONHandler(w, nil)
+	}
+	return slog.New(Handler(h))
+}
+
+type contextHandler struct {
+	slog.Handler
+}
+
+// Handle adds ctx's attributes to r, but for those r has a key of already:
+// a record naming its run_id explicitly is not given the context's too.
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	attrs, _ := ctx.Value(attrsKey{}).([]slog.Attr)
+	if len(attrs) == 0 {
+		return h.Handler.Handle(ctx, r)
+	}
+	own := make(map[string]bool, r.NumAttrs())
+	r.Attrs(func(a slog.Attr) bool {
+		own[a.Key] = true
+		return true
+	})
+	r = r.Clone()
+	for _, a := range attrs {
+		if !own[a.Key] {
+			r.AddAttrs(a)
+		}
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,62 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestContextAttrs(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, "json")
+	ctx := With(WithRequestID(context.Background(), "req-1"), "run_id", "run-1")
+	run := Detach(ctx)
+	if RequestID(run) != "req-1" || run.Done() != nil {
+		t.Fatalf("expected a background context keeping the request ID, got %q", RequestID(run))
+	}
+
+	logger.InfoContext(With(run, "variant_id", "v1"), "simulator error", "tool", "queue")
+	logger.InfoContext(ctx, "saved", "run_id", "run-2")
+	logger.Info("no context")
+	var lines []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var m map[string]any
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, m)
+	}
+	if l := lines[0]; l["request_id"] != "req-1" || l["run_id"] != "run-1" || l["variant_id"] != "v1" || l["tool"] != "queue" {
+		t.Errorf("expected the context's attributes added, got %v", l)
+	}
+	if !strings.Contains(buf.String(), `"run_id":"run-2"`) || strings.Count(buf.String(), `"run_id"`) != 2 {
+		t.Errorf("expected the record's own run_id to win, got %s", buf.String())
+	}
+	if _, ok := lines[2]["request_id"]; ok {
+		t.Errorf("expected no request ID without a context, got %v", lines[2])
+	}
+
+	buf.Reset()
+	slog.New(Handler(slog.NewTextHandler(&buf, nil))).WarnContext(ctx, "slow")
+	if !strings.Contains(buf.String(), "request_id=req-1") {
+		t.Errorf("expected text output to carry the request ID, got %s", buf.String())
+	}
+}
+
+func TestValidRequestID(t *testing.T) {
+	for id, want := range map[string]bool{
+		"abc-123_x.y:z":          true,
+		NewRequestID():           true,
+		"":                       false,
+		"has space":              false,
+		"line\nbreak":            false,
+		strings.Repeat("a", 129): false,
+	} {
+		if ValidRequestID(id) != want {
+			t.Errorf("%q: expected valid=%v", id, want)
+		}
+	}
+}
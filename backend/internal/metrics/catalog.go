@@ -0,0 +1,115 @@
+// Package metrics knows which way each simulator metric improves, so scoring
+// and analysis don't have to guess from metric names, and keeps the
+// performance history of recent runs.
+package metrics
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"simstack/internal/types"
+)
+
+// builtin covers every metric the bundled queue, traffic and resource
+// simulators emit.
+var builtin = []types.MetricDef{
+	{Name: "queue_avg_wait_time_min", Unit: "min", Direction: types.LowerIsBetter, Description: "Average time a customer waits before service"},
+	{Name: "queue_avg_queue_length", Unit: "customers", Direction: types.LowerIsBetter, Description: "Average number of customers waiting"},
+	{Name: "queue_utilization", Unit: "ratio", Direction: types.HigherIsBetter, Description: "Share of time servers are busy"},
+	{Name: "traffic_avg_speed_kmh", Unit: "km/h", Direction: types.HigherIsBetter, Description: "Average vehicle speed"},
+	{Name: "traffic_throughput_veh_per_hr", Unit: "veh/h", Direction: types.HigherIsBetter, Description: "Vehicles passing per hour"},
+	{Name: "resource_coverage_units", Unit: "units", Direction: types.HigherIsBetter, Description: "Staffed coverage across shifts"},
+	{Name: "resource_satisfaction", Unit: "ratio", Direction: types.HigherIsBetter, Description: "Staff satisfaction with the schedule"},
+}
+
+// lowerHints mark metric names that are usually better low. Anything else is
+// assumed better high.
+var lowerHints = []string{"wait", "latency", "delay", "queue_length", "cost", "error", "drop"}
+
+// Catalog maps metric names to definitions. It is safe for concurrent use.
+type Catalog struct {
+	mu      sync.RWMutex
+	defs    map[string]types.MetricDef
+	guessed map[string]bool
+}
+
+// New returns a catalog holding defs.
+func New(defs ...types.MetricDef) *Catalog {
+	c := &Catalog{defs: map[string]types.MetricDef{}, guessed: map[string]bool{}}
+	c.Register(defs...)
+	return c
+}
+
+var defaultCatalog = New(builtin...)
+
+// Default returns the shared catalog, seeded with the built-in simulators'
+// metrics.
+func Default() *Catalog {
+	return defaultCatalog
+}
+
+// Register adds or replaces definitions, e.g. ones a simulator reports about
+// its own metrics. Definitions without a valid direction are ignored.
+func (c *Catalog) Register(defs ...types.MetricDef) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, d := range defs {
+		if d.Name == "" || (d.Direction != types.HigherIsBetter && d.Direction != types.LowerIsBetter) {
+			continue
+		}
+		c.defs[d.Name] = d
+		delete(c.guessed, d.Name)
+	}
+}
+
+// Lookup returns the definition for name. Unknown names get a direction
+// guessed from the name, logged once per name; known reports whether the
+// catalog had it.
+func (c *Catalog) Lookup(name string) (def types.MetricDef, known bool) {
+	c.mu.RLock()
+	def, known = c.defs[name]
+	logged := c.guessed[name]
+	c.mu.RUnlock()
+	if known {
+		return def, true
+	}
+
+	def = types.MetricDef{Name: name, Direction: guessDirection(name)}
+	if !logged {
+		c.mu.Lock()
+		c.guessed[name] = true
+		c.mu.Unlock()
+		log.Printf("metric %q not in catalog, guessing %s is better", name, def.Direction)
+	}
+	return def, false
+}
+
+// Direction is shorthand for Lookup(name).Direction.
+func (c *Catalog) Direction(name string) types.MetricDirection {
+	def, _ := c.Lookup(name)
+	return def.Direction
+}
+
+// Defs returns every registered definition sorted by name.
+func (c *Catalog) Defs() []types.MetricDef {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	out := make([]types.MetricDef, 0, len(c.defs))
+	for _, d := range c.defs {
+		out = append(out, d)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func guessDirection(name string) types.MetricDirection {
+	lower := strings.ToLower(name)
+	for _, hint := range lowerHints {
+		if strings.Contains(lower, hint) {
+			return types.LowerIsBetter
+		}
+	}
+	return types.HigherIsBetter
+}
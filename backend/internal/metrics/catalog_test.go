@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"testing"
+
+	"simstack/internal/types"
+)
+
+func TestDefaultCatalogContents(t *testing.T) {
+	want := map[string]types.MetricDirection{
+		"queue_avg_wait_time_min":       types.LowerIsBetter,
+		"queue_avg_queue_length":        types.LowerIsBetter,
+		"queue_utilization":             types.HigherIsBetter,
+		"traffic_avg_speed_kmh":         types.HigherIsBetter,
+		"traffic_throughput_veh_per_hr": types.HigherIsBetter,
+		"resource_coverage_units":       types.HigherIsBetter,
+		"resource_satisfaction":         types.HigherIsBetter,
+	}
+	defs := Default().Defs()
+	if len(defs) != len(want) {
+		t.Errorf("expected %d built-in metrics, got %d", len(want), len(defs))
+	}
+	for _, d := range defs {
+		if dir, ok := want[d.Name]; !ok || d.Direction != dir {
+			t.Errorf("unexpected definition %+v", d)
+		}
+		if d.Unit == "" || d.Description == "" {
+			t.Errorf("%s is missing unit or description", d.Name)
+		}
+	}
+}
+
+func TestLookupFallsBackToGuess(t *testing.T) {
+	c := New()
+	cases := map[string]types.MetricDirection{
+		"queue_p95_wait":     types.LowerIsBetter,
+		"api_latency_ms":     types.LowerIsBetter,
+		"fleet_cost_usd":     types.LowerIsBetter,
+		"traffic_flow_score": types.HigherIsBetter,
+	}
+	for name, want := range cases {
+		def, known := c.Lookup(name)
+		if known || def.Direction != want {
+			t.Errorf("%s: expected guessed %s, got %s (known=%v)", name, want, def.Direction, known)
+		}
+	}
+
+	c.Register(types.MetricDef{Name: "fleet_cost_usd", Direction: types.HigherIsBetter})
+	if def, known := c.Lookup("fleet_cost_usd"); !known || def.Direction != types.HigherIsBetter {
+		t.Errorf("registered definition should override the guess, got %+v", def)
+	}
+	c.Register(types.MetricDef{Name: "bogus", Direction: "sideways"})
+	if _, known := c.Lookup("bogus"); known {
+		t.Error("definitions without a valid direction must be ignored")
+	}
+}
@@ -0,0 +1,202 @@
+package metrics
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"simstack/internal/types"
+)
+
+// Sink receives instrumentation as it happens, such as a StatsD client or
+// the Prometheus collectors behind /metrics. Tags are "key:value" pairs.
+type Sink interface {
+	Count(name string, n int64, tags ...string)
+	Timing(name string, d time.Duration, tags ...string)
+	// Observe records one sample of a distribution, such as a rate
+	Observe(name string, v float64, tags ...string)
+}
+
+// Sinks passes instrumentation on to each of its sinks in turn.
+type Sinks []Sink
+
+// Count implements Sink.
+func (s Sinks) Count(name string, n int64, tags ...string) {
+	for _, sink := range s {
+		sink.Count(name, n, tags...)
+	}
+}
+
+// Timing implements Sink.
+func (s Sinks) Timing(name string, d time.Duration, tags ...string) {
+	for _, sink := range s {
+		sink.Timing(name, d, tags...)
+	}
+}
+
+// Observe implements Sink.
+func (s Sinks) Observe(name string, v float64, tags ...string) {
+	for _, sink := range s {
+		sink.Observe(name, v, tags...)
+	}
+}
+
+// Counters tallies run lifecycle events for the life of the process, and
+// passes them on to its Sink if it has one. Every counter is atomic, so it
+// is safe for concurrent use.
+type Counters struct {
+	since time.Time
+	sink  Sink
+
+	RunsStarted       atomic.Int64
+	RunsCompleted     atomic.Int64
+	RunsFailed        atomic.Int64
+	RunsCanceled      atomic.Int64
+	PlanningFallbacks atomic.Int64
+	VariantsExecuted  atomic.Int64
+	UnpricedLLMCalls  atomic.Int64
+	// Finished runs dropped from memory, and live results released after
+	// their grace period
+	RunsEvicted           atomic.Int64
+	ReplayBuffersReleased atomic.Int64
+	// Simulator calls answered from the response cache, and cacheable calls
+	// that had to be made
+	SimulatorCacheHits   atomic.Int64
+	SimulatorCacheMisses atomic.Int64
+
+	costBits          atomic.Uint64 // float64 dollars
+	simulatorFailures sync.Map      // tool -> *atomic.Int64
+}
+
+// NewCounters returns zeroed counters starting now.
+func NewCounters() *Counters {
+	return &Counters{since: time.Now().UTC()}
+}
+
+// SetSink passes events on to sink as well. It must be called before the
+// counters are shared.
+func (c *Counters) SetSink(sink Sink) {
+	c.sink = sink
+}
+
+func (c *Counters) count(name string, n int64, tags ...string) {
+	if c.sink != nil {
+		c.sink.Count(name, n, tags...)
+	}
+}
+
+func (c *Counters) timing(name string, d time.Duration, tags ...string) {
+	if c.sink != nil {
+		c.sink.Timing(name, d, tags...)
+	}
+}
+
+func (c *Counters) observe(name string, v float64, tags ...string) {
+	if c.sink != nil {
+		c.sink.Observe(name, v, tags...)
+	}
+}
+
+// RunStarted counts a run starting.
+func (c *Counters) RunStarted() {
+	c.RunsStarted.Add(1)
+	c.count("run.started", 1)
+}
+
+// RunFinished counts a finished run by how it ended: completed, failed or
+// canceled.
+func (c *Counters) RunFinished(status string) {
+	switch status {
+	case "canceled":
+		c.RunsCanceled.Add(1)
+	case "failed":
+		c.RunsFailed.Add(1)
+	default:
+		c.RunsCompleted.Add(1)
+	}
+	c.count("run.completed", 1, "status:"+status)
+}
+
+// PlannerLatency records how long a run took to plan.
+func (c *Counters) PlannerLatency(d time.Duration) {
+	c.timing("planner.latency", d)
+}
+
+// PlanningFellBack counts a run planned without the LLM although it was
+// online.
+func (c *Counters) PlanningFellBack() {
+	c.PlanningFallbacks.Add(1)
+	c.count("planner.fallback", 1)
+}
+
+// PlannerThroughput records the tokens per second of a run's planner
+// calls. Runs whose planner made no successful call are left out.
+func (c *Counters) PlannerThroughput(tokensPerSec float64) {
+	if tokensPerSec > 0 {
+		c.observe("planner.tokens_per_second", tokensPerSec)
+	}
+}
+
+// SimulatorCalled records a call to tool that took d and failed with err,
+// if it did.
+func (c *Counters) SimulatorCalled(tool string, d time.Duration, err error) {
+	outcome := "success"
+	if err != nil {
+		outcome = "failure"
+		c.SimulatorFailed(tool)
+	}
+	if c.sink == nil {
+		return // Spare building the tags on every call
+	}
+	c.timing("simulator.call.duration", d, "tool:"+tool, "outcome:"+outcome)
+}
+
+// LLMTokens counts the tokens an LLM call for phase used.
+func (c *Counters) LLMTokens(phase string, n int) {
+	if n > 0 {
+		c.count("llm.tokens", int64(n), "phase:"+phase)
+	}
+}
+
+// SimulatorFailed counts a failed call to tool.
+func (c *Counters) SimulatorFailed(tool string) {
+	n, _ := c.simulatorFailures.LoadOrStore(tool, new(atomic.Int64))
+	n.(*atomic.Int64).Add(1)
+}
+
+// AddCost adds an LLM call's estimated cost in dollars.
+func (c *Counters) AddCost(usd float64) {
+	for {
+		old := c.costBits.Load()
+		if c.costBits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+usd)) {
+			return
+		}
+	}
+}
+
+// Snapshot returns the current counts. WSClients and RunsResident are left
+// for the callers that own the connections and the runs.
+func (c *Counters) Snapshot() types.RunCounters {
+	out := types.RunCounters{
+		Since:                 c.since,
+		RunsStarted:           c.RunsStarted.Load(),
+		RunsCompleted:         c.RunsCompleted.Load(),
+		RunsFailed:            c.RunsFailed.Load(),
+		RunsCanceled:          c.RunsCanceled.Load(),
+		PlanningFallbacks:     c.PlanningFallbacks.Load(),
+		VariantsExecuted:      c.VariantsExecuted.Load(),
+		CostUSD:               math.Float64frombits(c.costBits.Load()),
+		UnpricedLLMCalls:      c.UnpricedLLMCalls.Load(),
+		RunsEvicted:           c.RunsEvicted.Load(),
+		ReplayBuffersReleased: c.ReplayBuffersReleased.Load(),
+		SimulatorCacheHits:    c.SimulatorCacheHits.Load(),
+		SimulatorCacheMisses:  c.SimulatorCacheMisses.Load(),
+		SimulatorFailures:     map[string]int64{},
+	}
+	c.simulatorFailures.Range(func(tool, n any) bool {
+		out.SimulatorFailures[tool.(string)] = n.(*atomic.Int64).Load()
+		return true
+	})
+	return out
+}
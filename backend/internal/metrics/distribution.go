@@ -0,0 +1,165 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+
+	"simstack/internal/types"
+)
+
+// How a distribution's bucket edges were chosen
+const (
+	BinFreedmanDiaconis = "freedman_diaconis"
+	// Freedman–Diaconis needs a nonzero interquartile range; without one
+	// Sturges' rule picks the bucket count
+	BinSturges = "sturges"
+	BinFixed   = "fixed"
+)
+
+// MaxBuckets bounds a histogram whatever the binning, so a payload stays
+// small however the values spread.
+const MaxBuckets = 50
+
+// Sample is one variant's value of a metric.
+type Sample struct {
+	VariantID string
+	Value     float64
+}
+
+// Distribution summarizes samples of metric for charting. A positive
+// buckets fixes the bucket count; otherwise the Freedman–Diaconis rule
+// picks the bucket width. Either way there are at most MaxBuckets, and
+// values that are all equal share one bucket. NaN and infinite samples are
+// left out. Quantiles interpolate linearly between the closest ranks.
+func Distribution(metric string, samples []Sample, buckets int) types.MetricDistribution {
+	d := types.MetricDistribution{Metric: metric, Binning: BinFixed, Buckets: []types.HistogramBucket{}, Variants: []types.VariantPosition{}}
+	if buckets <= 0 {
+		d.Binning = BinFreedmanDiaconis
+	}
+	var kept []Sample
+	for _, s := range samples {
+		if !math.IsNaN(s.Value) && !math.IsInf(s.Value, 0) {
+			kept = append(kept, s)
+		}
+	}
+	if len(kept) == 0 {
+		return d
+	}
+
+	values := make([]float64, len(kept))
+	sum := 0.0
+	for i, s := range kept {
+		values[i] = s.Value
+		sum += s.Value
+	}
+	sort.Float64s(values)
+	n := len(values)
+	d.Count = n
+	d.Min, d.Max = values[0], values[n-1]
+	d.Mean = sum / float64(n)
+	d.Median = quantile(values, 0.5)
+	d.P95 = quantile(values, 0.95)
+
+	k := 1
+	if d.Max > d.Min {
+		k = buckets
+		if k <= 0 {
+			k = freedmanDiaconis(values)
+			if k == 0 {
+				d.Binning = BinSturges
+				k = int(math.Ceil(math.Log2(float64(n)))) + 1
+			}
+		}
+		k = max(1, min(k, MaxBuckets))
+	}
+	width := (d.Max - d.Min) / float64(k)
+	for i := 0; i < k; i++ {
+		b := types.HistogramBucket{Lower: d.Min + float64(i)*width, Upper: d.Min + float64(i+1)*width}
+		if i == k-1 {
+			b.Upper = d.Max
+		}
+		d.Buckets = append(d.Buckets, b)
+	}
+
+	for _, s := range kept {
+		i := bucketOf(d.Buckets, s.Value, d.Min, width)
+		d.Buckets[i].Count++
+		d.Variants = append(d.Variants, types.VariantPosition{
+			VariantID:  s.VariantID,
+			Value:      s.Value,
+			Bucket:     i,
+			Percentile: percentileRank(values, s.Value),
+		})
+	}
+	return d
+}
+
+// freedmanDiaconis returns the bucket count for a width of twice the
+// interquartile range over the cube root of the count, or 0 when the
+// interquartile range is zero.
+func freedmanDiaconis(sorted []float64) int {
+	iqr := quantile(sorted, 0.75) - quantile(sorted, 0.25)
+	if iqr <= 0 {
+		return 0
+	}
+	width := 2 * iqr / math.Cbrt(float64(len(sorted)))
+	return int(math.Ceil((sorted[len(sorted)-1] - sorted[0]) / width))
+}
+
+// bucketOf returns the index of the bucket holding v, checked against the
+// edges themselves so rounding in the division can't misplace a value that
+// sits on one.
+func bucketOf(buckets []types.HistogramBucket, v, lo, width float64) int {
+	i := 0
+	if width > 0 {
+		i = min(int((v-lo)/width), len(buckets)-1)
+	}
+	for i > 0 && v < buckets[i].Lower {
+		i--
+	}
+	for i < len(buckets)-1 && v >= buckets[i+1].Lower {
+		i++
+	}
+	return i
+}
+
+// quantile interpolates the q quantile of sorted, which must not be empty.
+func quantile(sorted []float64, q float64) float64 {
+	pos := q * float64(len(sorted)-1)
+	lo := int(math.Floor(pos))
+	if lo >= len(sorted)-1 {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[lo] + (pos-float64(lo))*(sorted[lo+1]-sorted[lo])
+}
+
+// percentileRank is the share of the other values below v, ties counting
+// half; a lone value sits at 50.
+func percentileRank(sorted []float64, v float64) float64 {
+	if len(sorted) == 1 {
+		return 50
+	}
+	below := sort.SearchFloat64s(sorted, v)
+	equal := sort.SearchFloat64s(sorted, math.Nextafter(v, math.Inf(1))) - below
+	return 100 * (float64(below) + 0.5*float64(equal-1)) / float64(len(sorted)-1)
+}
+
+// TopMetrics picks at most n of the metrics in samples to chart: those the
+// most variants report, by name among equals.
+func TopMetrics(samples map[string][]Sample, n int) []string {
+	names := make([]string, 0, len(samples))
+	for name := range samples {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := len(samples[names[i]]), len(samples[names[j]])
+		if a != b {
+			return a > b
+		}
+		return names[i] < names[j]
+	})
+	if len(names) > n {
+		names = names[:max(n, 0)]
+	}
+	return names
+}
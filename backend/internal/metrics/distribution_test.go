@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+
+	"simstack/internal/types"
+)
+
+func samples(values ...float64) []Sample {
+	out := make([]Sample, len(values))
+	for i, v := range values {
+		out[i] = Sample{VariantID: fmt.Sprintf("v%d", i+1), Value: v}
+	}
+	return out
+}
+
+func counts(d types.MetricDistribution) []int {
+	out := make([]int, len(d.Buckets))
+	for i, b := range d.Buckets {
+		out[i] = b.Count
+	}
+	return out
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestDistributionFreedmanDiaconis(t *testing.T) {
+	// IQR 7.75-3.25 = 4.5, width 9/cbrt(10) ≈ 4.18, so 3 buckets of 3
+	d := Distribution("queue_avg_wait_time_min", samples(10, 9, 8, 7, 6, 5, 4, 3, 2, 1), 0)
+	if d.Binning != BinFreedmanDiaconis || d.Count != 10 || d.Min != 1 || d.Max != 10 {
+		t.Fatalf("unexpected distribution %+v", d)
+	}
+	if !near(d.Mean, 5.5) || !near(d.Median, 5.5) || !near(d.P95, 9.55) {
+		t.Errorf("mean %v, median %v, p95 %v; want 5.5, 5.5, 9.55", d.Mean, d.Median, d.P95)
+	}
+	want := []types.HistogramBucket{{Lower: 1, Upper: 4, Count: 3}, {Lower: 4, Upper: 7, Count: 3}, {Lower: 7, Upper: 10, Count: 4}}
+	if !reflect.DeepEqual(d.Buckets, want) {
+		t.Errorf("buckets %+v, want %+v", d.Buckets, want)
+	}
+	// Positions keep the given order; v1 holds the largest value
+	first, last := d.Variants[0], d.Variants[9]
+	if first.VariantID != "v1" || first.Bucket != 2 || first.Percentile != 100 || last.Bucket != 0 || last.Percentile != 0 {
+		t.Errorf("unexpected positions %+v and %+v", first, last)
+	}
+	if v4 := d.Variants[3]; v4.Value != 7 || v4.Bucket != 2 {
+		t.Errorf("expected a value on an edge in the bucket it starts, got %+v", v4)
+	}
+}
+
+func TestDistributionFixedAndFallbacks(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  []float64
+		buckets int
+		binning string
+		counts  []int
+	}{
+		{"fixed count", []float64{0, 1, 2, 3}, 2, BinFixed, []int{2, 2}},
+		{"fixed count capped", []float64{0, 1}, 1000, BinFixed, append(append([]int{1}, make([]int, MaxBuckets-2)...), 1)},
+		// Quartiles equal, so Sturges: ceil(log2 5)+1 = 4 buckets
+		{"zero IQR", []float64{1, 1, 1, 1, 5}, 0, BinSturges, []int{4, 0, 0, 1}},
+		{"all equal", []float64{3, 3, 3}, 0, BinFreedmanDiaconis, []int{3}},
+		{"all equal fixed", []float64{3, 3, 3}, 5, BinFixed, []int{3}},
+		{"single variant", []float64{42}, 0, BinFreedmanDiaconis, []int{1}},
+		{"no finite values", []float64{math.NaN(), math.Inf(1)}, 0, BinFreedmanDiaconis, []int{}},
+	}
+	for _, tt := range tests {
+		d := Distribution("m", samples(tt.values...), tt.buckets)
+		if d.Binning != tt.binning || !reflect.DeepEqual(counts(d), tt.counts) {
+			t.Errorf("%s: got %s %v, want %s %v", tt.name, d.Binning, counts(d), tt.binning, tt.counts)
+		}
+		for _, b := range d.Buckets {
+			if math.IsNaN(b.Lower) || math.IsNaN(b.Upper) || b.Upper < b.Lower {
+				t.Errorf("%s: bad bucket edges %+v", tt.name, b)
+			}
+		}
+		for _, p := range d.Variants {
+			if math.IsNaN(p.Percentile) || p.Bucket < 0 || p.Bucket >= len(d.Buckets) {
+				t.Errorf("%s: bad position %+v", tt.name, p)
+			}
+		}
+	}
+
+	lone := Distribution("m", samples(42), 0)
+	if lone.Mean != 42 || lone.Median != 42 || lone.P95 != 42 || lone.Buckets[0] != (types.HistogramBucket{Lower: 42, Upper: 42, Count: 1}) || lone.Variants[0].Percentile != 50 {
+		t.Errorf("unexpected single-variant distribution %+v", lone)
+	}
+	tied := Distribution("m", samples(3, 3, 3), 0)
+	if tied.Variants[1].Percentile != 50 {
+		t.Errorf("expected tied variants at the 50th percentile, got %+v", tied.Variants)
+	}
+}
+
+func TestTopMetrics(t *testing.T) {
+	s := map[string][]Sample{
+		"b": samples(1, 2, 3),
+		"a": samples(1, 2, 3),
+		"c": samples(1),
+		"d": samples(1, 2, 3, 4),
+	}
+	if got := TopMetrics(s, 3); !reflect.DeepEqual(got, []string{"d", "a", "b"}) {
+		t.Errorf("got %v", got)
+	}
+	if got := TopMetrics(s, 0); len(got) != 0 {
+		t.Errorf("expected no metrics, got %v", got)
+	}
+}
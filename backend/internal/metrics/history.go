@@ -0,0 +1,105 @@
+package metrics
+
+import (
+	"sort"
+	"sync"
+
+	"simstack/internal/types"
+)
+
+// History keeps the performance records of the most recent runs, dropping the
+// oldest past its limit. It is safe for concurrent use.
+type History struct {
+	mu      sync.RWMutex
+	limit   int
+	records []types.RunMetrics // oldest first
+}
+
+// NewHistory returns a history holding up to limit records (100 if limit is
+// not positive).
+func NewHistory(limit int) *History {
+	if limit <= 0 {
+		limit = 100
+	}
+	return &History{limit: limit}
+}
+
+// Record adds a completed run's record.
+func (h *History) Record(m types.RunMetrics) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.records = append(h.records, m)
+	if over := len(h.records) - h.limit; over > 0 {
+		h.records = append(h.records[:0:0], h.records[over:]...)
+	}
+}
+
+// Latest returns up to n records, newest first; n <= 0 returns them all.
+func (h *History) Latest(n int) []types.RunMetrics {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if n <= 0 || n > len(h.records) {
+		n = len(h.records)
+	}
+	out := make([]types.RunMetrics, 0, n)
+	for i := len(h.records) - 1; i >= 0 && len(out) < n; i-- {
+		out = append(out, h.records[i])
+	}
+	return out
+}
+
+// Get returns the record of run id, if it is still in the history.
+func (h *History) Get(id string) (types.RunMetrics, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for i := len(h.records) - 1; i >= 0; i-- {
+		if h.records[i].RunID == id {
+			return h.records[i], true
+		}
+	}
+	return types.RunMetrics{}, false
+}
+
+// Aggregates summarizes every record in the history.
+func (h *History) Aggregates() types.MetricsAggregates {
+	return Aggregate(h.Latest(0))
+}
+
+// Aggregate averages records and takes the nearest-rank 95th percentile of
+// planner latency.
+func Aggregate(records []types.RunMetrics) types.MetricsAggregates {
+	agg := types.MetricsAggregates{Runs: len(records)}
+	if len(records) == 0 {
+		return agg
+	}
+	planner := make([]int64, 0, len(records))
+	var sumPlanner, sumSim, sumAnalysis, sumTotal int64
+	var sumRate float64
+	for _, r := range records {
+		planner = append(planner, r.PlannerMs)
+		sumPlanner += r.PlannerMs
+		sumSim += r.SimulationPhaseMs
+		sumAnalysis += r.AnalysisMs
+		sumTotal += r.TotalMs
+		sumRate += r.TokensPerSecond
+		agg.TotalTokens += r.TotalTokens
+		agg.FailedLLMCalls += r.FailedLLMCalls
+		agg.FailedVariants += r.FailedVariants
+		if r.CostUSD != nil {
+			agg.TotalCostUSD += *r.CostUSD
+		} else {
+			agg.UnpricedRuns++
+		}
+	}
+	n := float64(len(records))
+	agg.AvgPlannerMs = float64(sumPlanner) / n
+	agg.AvgSimulationMs = float64(sumSim) / n
+	agg.AvgAnalysisMs = float64(sumAnalysis) / n
+	agg.AvgTotalMs = float64(sumTotal) / n
+	agg.AvgTokensPerSecond = sumRate / n
+
+	sort.Slice(planner, func(i, j int) bool { return planner[i] < planner[j] })
+	rank := (95*len(planner) + 99) / 100 // ceil(0.95 n)
+	agg.P95PlannerMs = planner[rank-1]
+	return agg
+}
@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"fmt"
+	"testing"
+
+	"simstack/internal/types"
+)
+
+func TestHistoryOrderingAndBound(t *testing.T) {
+	h := NewHistory(3)
+	for i := 1; i <= 5; i++ {
+		h.Record(types.RunMetrics{RunID: fmt.Sprintf("run-%d", i), PhaseTimings: types.PhaseTimings{PlannerMs: int64(i)}})
+	}
+
+	latest := h.Latest(0)
+	if len(latest) != 3 || latest[0].RunID != "run-5" || latest[2].RunID != "run-3" {
+		t.Errorf("expected run-5..run-3 newest first, got %+v", latest)
+	}
+	if two := h.Latest(2); len(two) != 2 || two[1].RunID != "run-4" {
+		t.Errorf("expected the two newest, got %+v", two)
+	}
+	if _, ok := h.Get("run-1"); ok {
+		t.Error("run-1 should have been dropped past the limit")
+	}
+	if m, ok := h.Get("run-4"); !ok || m.PlannerMs != 4 {
+		t.Errorf("expected run-4's record, got %+v %v", m, ok)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	var records []types.RunMetrics
+	cost := 0.25
+	for i := 1; i <= 20; i++ {
+		var runCost *float64
+		if i%4 != 0 {
+			runCost = &cost
+		}
+		records = append(records, types.RunMetrics{
+			CostUSD:         runCost,
+			PhaseTimings:    types.PhaseTimings{PlannerMs: int64(i * 10), SimulationPhaseMs: 100, AnalysisMs: int64(i), TotalMs: 200},
+			TokensPerSecond: float64(i),
+			TotalTokens:     50,
+			FailedVariants:  i % 2,
+		})
+	}
+	agg := Aggregate(records)
+	// Planner latencies 10..200: mean 105, nearest-rank p95 is the 19th
+	if agg.Runs != 20 || agg.AvgPlannerMs != 105 || agg.P95PlannerMs != 190 {
+		t.Errorf("unexpected planner aggregates %+v", agg)
+	}
+	if agg.AvgSimulationMs != 100 || agg.AvgAnalysisMs != 10.5 || agg.AvgTotalMs != 200 || agg.AvgTokensPerSecond != 10.5 {
+		t.Errorf("unexpected averages %+v", agg)
+	}
+	if agg.TotalTokens != 1000 || agg.FailedVariants != 10 || agg.TotalCostUSD != 3.75 || agg.UnpricedRuns != 5 {
+		t.Errorf("unexpected totals %+v", agg)
+	}
+
+	if one := Aggregate(records[:1]); one.P95PlannerMs != 10 || one.AvgPlannerMs != 10 {
+		t.Errorf("a single run is its own p95, got %+v", one)
+	}
+	if empty := Aggregate(nil); empty != (types.MetricsAggregates{}) {
+		t.Errorf("expected zero aggregates, got %+v", empty)
+	}
+}
@@ -0,0 +1,50 @@
+package metrics
+
+import "simstack/internal/types"
+
+// ParetoFront returns the IDs, in order, of the results no other result
+// dominates: one dominates another when it reports every metric the other
+// does, none worse and at least one better. Failed results and results
+// without metrics are never on the front.
+func (c *Catalog) ParetoFront(results []types.SimulationResult) []string {
+	var candidates []types.SimulationResult
+	for _, r := range results {
+		if r.Status != types.ResultFailed && len(r.Metrics) > 0 {
+			candidates = append(candidates, r)
+		}
+	}
+	var front []string
+	for i, r := range candidates {
+		dominated := false
+		for j, other := range candidates {
+			if i != j && c.dominates(other, r) {
+				dominated = true
+				break
+			}
+		}
+		if !dominated {
+			front = append(front, r.VariantID)
+		}
+	}
+	return front
+}
+
+func (c *Catalog) dominates(a, b types.SimulationResult) bool {
+	better := false
+	for name, bv := range b.Metrics {
+		av, ok := a.Metrics[name]
+		if !ok {
+			return false
+		}
+		if c.Direction(name) == types.LowerIsBetter {
+			av, bv = -av, -bv
+		}
+		if av < bv {
+			return false
+		}
+		if av > bv {
+			better = true
+		}
+	}
+	return better
+}
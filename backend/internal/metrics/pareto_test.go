@@ -0,0 +1,29 @@
+package metrics
+
+import (
+	"reflect"
+	"testing"
+
+	"simstack/internal/types"
+)
+
+func TestParetoFront(t *testing.T) {
+	result := func(id string, status types.ResultStatus, wait, util float64) types.SimulationResult {
+		return types.SimulationResult{VariantID: id, Status: status, Metrics: map[string]float64{"queue_avg_wait_time_min": wait, "queue_utilization": util}}
+	}
+	results := []types.SimulationResult{
+		result("v1", types.ResultComplete, 5, 0.7),
+		result("v2", types.ResultComplete, 3, 0.6), // trades utilization for wait
+		result("v3", types.ResultComplete, 6, 0.6), // worse than v1 on both
+		result("v4", types.ResultFailed, 1, 0.9),
+		result("v5", types.ResultPartial, 5, 0.7), // ties v1
+		{VariantID: "v6", Status: types.ResultPartial, Metrics: map[string]float64{"queue_avg_wait_time_min": 2}},
+	}
+	// v6 reports less than v2 does, so neither dominates the other
+	if got := Default().ParetoFront(results); !reflect.DeepEqual(got, []string{"v1", "v2", "v5", "v6"}) {
+		t.Errorf("unexpected front %v", got)
+	}
+	if got := Default().ParetoFront(nil); got != nil {
+		t.Errorf("expected no front without results, got %v", got)
+	}
+}
@@ -0,0 +1,85 @@
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrPrivateTarget refuses a webhook given with a run that points inside
+// the network: at a loopback, link-local or private address.
+var ErrPrivateTarget = errors.New("webhook points at a private address")
+
+// CheckURL checks a webhook URL given with a run: it must be http(s), and
+// unless its host is one of allowed, it must not name a private address
+// outright. Names resolving to one are refused when posting.
+func CheckURL(raw string, allowed []string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("must be an http(s) URL")
+	}
+	if hostAllowed(u, allowed) {
+		return nil
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrPrivateTarget
+	}
+	if ip, err := netip.ParseAddr(host); err == nil && !public(ip) {
+		return ErrPrivateTarget
+	}
+	return nil
+}
+
+// hostAllowed reports whether u's host is one of allowed.
+func hostAllowed(u *url.URL, allowed []string) bool {
+	for _, h := range allowed {
+		if strings.EqualFold(h, u.Hostname()) {
+			return true
+		}
+	}
+	return false
+}
+
+// public reports whether ip is an address webhooks given with a run may
+// reach.
+func public(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast() && !ip.IsUnspecified()
+}
+
+// guarded returns a copy of client that only connects to public addresses,
+// checked once the name is resolved so DNS can't lead it inside. It goes
+// direct, ignoring any proxy, so the address checked is the webhook's own.
+func guarded(client *http.Client) *http.Client {
+	t, ok := client.Transport.(*http.Transport)
+	if !ok {
+		t = http.DefaultTransport.(*http.Transport)
+	}
+	t = t.Clone()
+	t.Proxy = nil
+	t.DialContext = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: publicOnly}).DialContext
+	c := *client
+	c.Transport = t
+	return &c
+}
+
+// publicOnly is a net.Dialer Control refusing private addresses.
+func publicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil || !public(ip) {
+		return fmt.Errorf("%w: %s", ErrPrivateTarget, host)
+	}
+	return nil
+}
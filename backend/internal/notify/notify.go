@@ -0,0 +1,324 @@
+// Package notify posts a readable summary of each finished run to chat
+// webhooks: Slack as blocks, Discord as an embed. Delivery happens in the
+// background, with retries, so a slow or failing webhook never holds up a
+// run.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Platforms
+const (
+	Slack   = "slack"
+	Discord = "discord"
+)
+
+// DefaultTemplate renders a message's text, the body under its title.
+const DefaultTemplate = `Goal: {{.Goal}}{{if .Winner}}
+Winner: {{.Winner}}{{end}}`
+
+// Message is what a notification says about a finished run.
+type Message struct {
+	RunID string
+	Goal  string
+	// completed, failed or canceled
+	Status string
+	Winner string
+	// The winner's headline metrics
+	Metrics []Metric
+	// Estimated LLM cost; nil when unknown
+	CostUSD *float64
+	// Where to read the whole run
+	URL string
+}
+
+// Metric is one of the winner's metrics next to the run's baseline for it,
+// the median across variants.
+type Metric struct {
+	Name     string
+	Unit     string
+	Value    float64
+	Baseline float64
+}
+
+// Delta renders the metric's change from its baseline, e.g. "-12.5% vs
+// median", or "" when the baseline is zero.
+func (m Metric) Delta() string {
+	if m.Baseline == 0 {
+		return ""
+	}
+	pct := (m.Value - m.Baseline) / math.Abs(m.Baseline) * 100
+	return fmt.Sprintf("%+.1f%% vs median", pct)
+}
+
+// Target is a webhook to notify.
+type Target struct {
+	Platform string
+	URL      string
+	// Given with the run rather than configured, so posted to only when it
+	// resolves to a public address or its host is in Options.AllowedHosts
+	FromRun bool
+}
+
+// Options shape delivery. Template is a text/template over Message; empty
+// uses DefaultTemplate.
+type Options struct {
+	Template string
+	// Further attempts after a failed post, Backoff apart and doubling
+	Retries int
+	Backoff time.Duration
+	// Hosts that webhooks given with a run may name even though they are
+	// on the private network
+	AllowedHosts []string
+}
+
+// ParseTemplate checks a message template.
+func ParseTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		text = DefaultTemplate
+	}
+	return template.New("message").Option("missingkey=error").Parse(text)
+}
+
+// Notifier posts messages to webhooks. It is safe for concurrent use.
+type Notifier struct {
+	client *http.Client
+	// A copy of client for webhooks given with a run, reaching only public
+	// addresses
+	guarded *http.Client
+	wg      sync.WaitGroup
+}
+
+// New returns a notifier posting with client.
+func New(client *http.Client) *Notifier {
+	return &Notifier{client: client, guarded: guarded(client)}
+}
+
+// Send posts msg to each target in the background and returns at once.
+// Failures are logged.
+func (n *Notifier) Send(msg Message, targets []Target, opts Options) {
+	if len(targets) == 0 {
+		return
+	}
+	text, err := Render(msg, opts.Template)
+	if err != nil {
+		log.Printf("notification for %s not sent: %v", msg.RunID, err)
+		return
+	}
+	for _, t := range targets {
+		body, err := Payload(t.Platform, msg, text)
+		if err != nil {
+			log.Printf("notification for %s not sent: %v", msg.RunID, err)
+			continue
+		}
+		client := n.client
+		if t.FromRun {
+			if u, err := url.Parse(t.URL); err != nil || !hostAllowed(u, opts.AllowedHosts) {
+				client = n.guarded
+			}
+		}
+		n.wg.Add(1)
+		go func(t Target) {
+			defer n.wg.Done()
+			if err := n.post(client, t.URL, body, opts); err != nil {
+				log.Printf("%s notification for %s failed: %v", t.Platform, msg.RunID, err)
+			}
+		}(t)
+	}
+}
+
+// Wait blocks until every notification sent so far is delivered or given
+// up on.
+func (n *Notifier) Wait() {
+	n.wg.Wait()
+}
+
+// Render executes the message template over msg.
+func Render(msg Message, text string) (string, error) {
+	tmpl, err := ParseTemplate(text)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, msg); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// post delivers body, retrying connection failures, 429s and 5xx answers.
+func (n *Notifier) post(client *http.Client, url string, body []byte, opts Options) error {
+	wait := opts.Backoff
+	var err error
+	for attempt := 0; ; attempt++ {
+		var retryAfter time.Duration
+		retryAfter, err = postOnce(client, url, body)
+		if err == nil || attempt >= opts.Retries || retryAfter < 0 {
+			return err
+		}
+		time.Sleep(max(wait, retryAfter))
+		wait *= 2
+	}
+}
+
+// postOnce returns how long the webhook asked to be left alone, or -1 when
+// the failure is permanent.
+func postOnce(client *http.Client, url string, body []byte) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return -1, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if errors.Is(err, ErrPrivateTarget) {
+		return -1, err
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return 0, nil
+	}
+	err = fmt.Errorf("webhook returned %s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+		return -1, err
+	}
+	secs, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+	return min(time.Duration(secs)*time.Second, 30*time.Second), err
+}
+
+// Payload builds platform's webhook body for msg, with text as its body.
+func Payload(platform string, msg Message, text string) ([]byte, error) {
+	var payload any
+	switch platform {
+	case Slack:
+		payload = slackPayload(msg, text)
+	case Discord:
+		payload = discordPayload(msg, text)
+	default:
+		return nil, fmt.Errorf("unknown notification platform %q", platform)
+	}
+	// Slack links are written <url|text>, so the brackets stay as they are
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(payload); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(b.Bytes(), []byte("\n")), nil
+}
+
+func title(msg Message) string {
+	return "SimStack run " + msg.Status
+}
+
+func (m Metric) value() string {
+	v := strconv.FormatFloat(m.Value, 'f', -1, 64)
+	if m.Unit != "" && m.Unit != "ratio" {
+		v += " " + m.Unit
+	}
+	if d := m.Delta(); d != "" {
+		v += " (" + d + ")"
+	}
+	return v
+}
+
+func cost(msg Message) string {
+	if msg.CostUSD == nil {
+		return "Cost: unknown"
+	}
+	return fmt.Sprintf("Cost: $%.4f", *msg.CostUSD)
+}
+
+// Slack Block Kit
+type slackBlock struct {
+	Type     string      `json:"type"`
+	Text     *slackText  `json:"text,omitempty"`
+	Fields   []slackText `json:"fields,omitempty"`
+	Elements []slackText `json:"elements,omitempty"`
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// slackEscaper escapes the characters Slack reads as markup, so a run's
+// text can't form links or mentions.
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+func slackPayload(msg Message, text string) map[string]any {
+	blocks := []slackBlock{
+		{Type: "header", Text: &slackText{Type: "plain_text", Text: title(msg)}},
+		{Type: "section", Text: &slackText{Type: "mrkdwn", Text: slackEscaper.Replace(text)}},
+	}
+	if len(msg.Metrics) > 0 {
+		fields := make([]slackText, len(msg.Metrics))
+		for i, m := range msg.Metrics {
+			fields[i] = slackText{Type: "mrkdwn", Text: fmt.Sprintf("*%s*\n%s", slackEscaper.Replace(m.Name), slackEscaper.Replace(m.value()))}
+		}
+		blocks = append(blocks, slackBlock{Type: "section", Fields: fields})
+	}
+	footer := cost(msg)
+	if msg.URL != "" {
+		footer += fmt.Sprintf(" · <%s|View run %s>", msg.URL, msg.RunID)
+	}
+	blocks = append(blocks, slackBlock{Type: "context", Elements: []slackText{{Type: "mrkdwn", Text: footer}}})
+	// text is the fallback for clients that can't show blocks
+	return map[string]any{"text": title(msg) + ": " + slackEscaper.Replace(msg.Goal), "blocks": blocks}
+}
+
+// Discord embed colors by status
+var discordColors = map[string]int{"completed": 0x2EB67D, "failed": 0xE01E5A, "canceled": 0x9E9E9E}
+
+type discordEmbed struct {
+	Title       string         `json:"title"`
+	URL         string         `json:"url,omitempty"`
+	Description string         `json:"description"`
+	Color       int            `json:"color"`
+	Fields      []discordField `json:"fields,omitempty"`
+	Footer      discordFooter  `json:"footer"`
+}
+
+type discordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+type discordFooter struct {
+	Text string `json:"text"`
+}
+
+func discordPayload(msg Message, text string) map[string]any {
+	embed := discordEmbed{
+		Title:       title(msg),
+		URL:         msg.URL,
+		Description: text,
+		Color:       discordColors[msg.Status],
+		Footer:      discordFooter{Text: cost(msg) + " · " + msg.RunID},
+	}
+	for _, m := range msg.Metrics {
+		embed.Fields = append(embed.Fields, discordField{Name: m.Name, Value: m.value(), Inline: true})
+	}
+	return map[string]any{"embeds": []discordEmbed{embed}}
+}
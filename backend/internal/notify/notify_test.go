@@ -0,0 +1,182 @@
+package notify
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// hook is a fake webhook recording the bodies posted to it and answering
+// with statuses in turn, then 204.
+type hook struct {
+	mu       sync.Mutex
+	bodies   []string
+	statuses []int
+}
+
+func (h *hook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.bodies = append(h.bodies, string(body))
+	status := http.StatusNoContent
+	if len(h.statuses) > 0 {
+		status, h.statuses = h.statuses[0], h.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func (h *hook) posted() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]string(nil), h.bodies...)
+}
+
+func completed() Message {
+	cost := 0.0123
+	return Message{
+		RunID:  "run-1",
+		Goal:   "Cut wait times",
+		Status: "completed",
+		Winner: "v3",
+		Metrics: []Metric{
+			{Name: "queue_avg_wait_time_min", Unit: "min", Value: 3.5, Baseline: 4},
+			{Name: "queue_utilization", Unit: "ratio", Value: 0.8, Baseline: 0.8},
+		},
+		CostUSD: &cost,
+		URL:     "http://simstack.test/api/runs/run-1",
+	}
+}
+
+func failed() Message {
+	return Message{RunID: "run-2", Goal: "Cut wait times", Status: "failed", URL: "http://simstack.test/api/runs/run-2"}
+}
+
+func TestSendPostsPlatformPayloads(t *testing.T) {
+	tests := []struct {
+		name     string
+		platform string
+		msg      Message
+		want     string
+	}{
+		{"slack completed", Slack, completed(), `{"blocks":[` +
+			`{"type":"header","text":{"type":"plain_text","text":"SimStack run completed"}},` +
+			`{"type":"section","text":{"type":"mrkdwn","text":"Goal: Cut wait times\nWinner: v3"}},` +
+			`{"type":"section","fields":[{"type":"mrkdwn","text":"*queue_avg_wait_time_min*\n3.5 min (-12.5% vs median)"},{"type":"mrkdwn","text":"*queue_utilization*\n0.8 (+0.0% vs median)"}]},` +
+			`{"type":"context","elements":[{"type":"mrkdwn","text":"Cost: $0.0123 · <http://simstack.test/api/runs/run-1|View run run-1>"}]}],` +
+			`"text":"SimStack run completed: Cut wait times"}`},
+		{"slack failed", Slack, failed(), `{"blocks":[` +
+			`{"type":"header","text":{"type":"plain_text","text":"SimStack run failed"}},` +
+			`{"type":"section","text":{"type":"mrkdwn","text":"Goal: Cut wait times"}},` +
+			`{"type":"context","elements":[{"type":"mrkdwn","text":"Cost: unknown · <http://simstack.test/api/runs/run-2|View run run-2>"}]}],` +
+			`"text":"SimStack run failed: Cut wait times"}`},
+		{"discord completed", Discord, completed(), `{"embeds":[{"title":"SimStack run completed","url":"http://simstack.test/api/runs/run-1",` +
+			`"description":"Goal: Cut wait times\nWinner: v3","color":3061373,` +
+			`"fields":[{"name":"queue_avg_wait_time_min","value":"3.5 min (-12.5% vs median)","inline":true},{"name":"queue_utilization","value":"0.8 (+0.0% vs median)","inline":true}],` +
+			`"footer":{"text":"Cost: $0.0123 · run-1"}}]}`},
+		{"discord failed", Discord, failed(), `{"embeds":[{"title":"SimStack run failed","url":"http://simstack.test/api/runs/run-2",` +
+			`"description":"Goal: Cut wait times","color":14687834,` +
+			`"footer":{"text":"Cost: unknown · run-2"}}]}`},
+	}
+	for _, tt := range tests {
+		h := &hook{}
+		srv := httptest.NewServer(h)
+		n := New(srv.Client())
+		n.Send(tt.msg, []Target{{Platform: tt.platform, URL: srv.URL}}, Options{})
+		n.Wait()
+		srv.Close()
+		if got := h.posted(); len(got) != 1 || got[0] != tt.want {
+			t.Errorf("%s: posted %q\nwant %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSendRetriesTransientFailures(t *testing.T) {
+	h := &hook{statuses: []int{http.StatusInternalServerError, http.StatusTooManyRequests}}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	n := New(srv.Client())
+	n.Send(failed(), []Target{{Platform: Slack, URL: srv.URL}}, Options{Retries: 3, Backoff: time.Millisecond})
+	n.Wait()
+	if got := len(h.posted()); got != 3 {
+		t.Errorf("expected two retries before the post went through, got %d posts", got)
+	}
+
+	h = &hook{statuses: []int{http.StatusBadRequest}}
+	bad := httptest.NewServer(h)
+	defer bad.Close()
+	n.Send(failed(), []Target{{Platform: Slack, URL: bad.URL}}, Options{Retries: 3, Backoff: time.Millisecond})
+	n.Wait()
+	if got := len(h.posted()); got != 1 {
+		t.Errorf("expected a rejected post not retried, got %d posts", got)
+	}
+}
+
+func TestCustomTemplate(t *testing.T) {
+	text, err := Render(completed(), "{{.Status}}: {{.Goal}} ({{len .Metrics}} metrics)")
+	if err != nil || text != "completed: Cut wait times (2 metrics)" {
+		t.Errorf("got %q, %v", text, err)
+	}
+	if _, err := Render(completed(), "{{.Nope}}"); err == nil {
+		t.Error("expected a template naming an unknown field rejected")
+	}
+	if _, err := ParseTemplate("{{.Goal"); err == nil {
+		t.Error("expected a malformed template rejected")
+	}
+}
+
+func TestRunWebhooksReachOnlyPublicAddresses(t *testing.T) {
+	h := &hook{}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	n := New(srv.Client())
+	// The test server is on loopback: refused for a run's own webhook, at
+	// once rather than retried, unless its host is allowed
+	n.Send(failed(), []Target{{Platform: Slack, URL: srv.URL, FromRun: true}}, Options{Retries: 3, Backoff: time.Hour})
+	n.Wait()
+	if got := h.posted(); len(got) != 0 {
+		t.Errorf("expected nothing posted to a private address, got %q", got)
+	}
+	n.Send(failed(), []Target{{Platform: Slack, URL: srv.URL, FromRun: true}}, Options{AllowedHosts: []string{"127.0.0.1"}})
+	n.Wait()
+	if got := h.posted(); len(got) != 1 {
+		t.Errorf("expected an allowed host posted to, got %d posts", len(got))
+	}
+	n.Send(failed(), []Target{{Platform: Slack, URL: srv.URL}}, Options{})
+	n.Wait()
+	if got := h.posted(); len(got) != 2 {
+		t.Errorf("expected a configured webhook trusted, got %d posts", len(got))
+	}
+
+	for raw, want := range map[string]error{
+		"https://hooks.slack.com/services/x": nil,
+		"http://192.168.1.20/hook":           ErrPrivateTarget,
+		"http://[fe80::1]/hook":              ErrPrivateTarget,
+		"http://app.localhost/hook":          ErrPrivateTarget,
+	} {
+		if err := CheckURL(raw, nil); !errors.Is(err, want) {
+			t.Errorf("%s: expected %v, got %v", raw, want, err)
+		}
+	}
+	if err := CheckURL("ftp://hooks.slack.com/x", nil); err == nil {
+		t.Error("expected a non-http URL refused")
+	}
+}
+
+func TestSlackTextEscaped(t *testing.T) {
+	msg := failed()
+	msg.Goal = "Cut <!channel> waits & <https://evil.test|costs>"
+	body, err := Payload(Slack, msg, "Goal: "+msg.Goal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "Cut &lt;!channel&gt; waits &amp; &lt;https://evil.test|costs&gt;"
+	if got := string(body); strings.Count(got, want) != 2 || strings.Contains(got, "<!channel>") {
+		t.Errorf("expected the goal escaped in the text and its fallback, got %s", got)
+	}
+}
@@ -0,0 +1,164 @@
+package openapi
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Param is a query parameter.
+type Param struct {
+	Name        string
+	Description string
+	// JSON Schema type: string, integer or boolean
+	Type string
+}
+
+// Operation is one method on one path.
+type Operation struct {
+	Method  string
+	Path    string
+	Summary string
+	Query   []Param
+	// A value of the JSON request body's type, or nil for no JSON body
+	Body any
+	// Media types the body may also, or only, be sent as
+	BodyTypes []string
+	// Answered on success; 200 when zero
+	Status int
+	// Other success statuses, answered with the same response
+	Also []int
+	// A value of the JSON response's type, or nil when it is not JSON
+	Response any
+	// Media types of a response that isn't JSON, such as a download
+	ResponseTypes []string
+	// Statuses answered with the error body
+	Errors []int
+	// Served without an API key
+	Public bool
+}
+
+// Pattern is the operation as a net/http ServeMux pattern, e.g.
+// "GET /api/runs/{id}".
+func (op Operation) Pattern() string {
+	return op.Method + " " + op.Path
+}
+
+// Info describes the API.
+type Info struct {
+	Title       string
+	Version     string
+	Description string
+}
+
+var pathParam = regexp.MustCompile(`\{([^}]+)\}`)
+
+// Document returns the OpenAPI document of ops. Every error status answers
+// with errorBody's type. Operations need an API key, as a bearer token or
+// an X-API-Key header, unless Public.
+func (g *Generator) Document(info Info, ops []Operation, errorBody any) map[string]any {
+	errorSchema := g.Schema(errorBody)
+	paths := map[string]any{}
+	for _, op := range ops {
+		item, _ := paths[op.Path].(map[string]any)
+		if item == nil {
+			item = map[string]any{}
+			paths[op.Path] = item
+		}
+		item[strings.ToLower(op.Method)] = g.operation(op, errorSchema)
+	}
+	doc := map[string]any{
+		"openapi": Version,
+		"info":    map[string]any{"title": info.Title, "version": info.Version},
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas": g.Components(),
+			"securitySchemes": map[string]any{
+				"bearer": map[string]any{"type": "http", "scheme": "bearer"},
+				"apiKey": map[string]any{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+			},
+		},
+		"security": []any{map[string]any{"bearer": []any{}}, map[string]any{"apiKey": []any{}}},
+	}
+	if info.Description != "" {
+		doc["info"].(map[string]any)["description"] = info.Description
+	}
+	return doc
+}
+
+func (g *Generator) operation(op Operation, errorSchema map[string]any) map[string]any {
+	out := map[string]any{"summary": op.Summary, "operationId": operationID(op)}
+	var params []any
+	for _, m := range pathParam.FindAllStringSubmatch(op.Path, -1) {
+		params = append(params, map[string]any{"name": m[1], "in": "path", "required": true, "schema": map[string]any{"type": "string"}})
+	}
+	for _, p := range op.Query {
+		param := map[string]any{"name": p.Name, "in": "query", "schema": map[string]any{"type": p.Type}}
+		if p.Description != "" {
+			param["description"] = p.Description
+		}
+		params = append(params, param)
+	}
+	if len(params) > 0 {
+		out["parameters"] = params
+	}
+
+	if op.Body != nil || len(op.BodyTypes) > 0 {
+		content := map[string]any{}
+		if op.Body != nil {
+			content["application/json"] = map[string]any{"schema": g.Schema(op.Body)}
+		}
+		for _, t := range op.BodyTypes {
+			body := map[string]any{}
+			if op.Body != nil {
+				body["schema"] = g.Schema(op.Body)
+			}
+			content[t] = body
+		}
+		out["requestBody"] = map[string]any{"required": true, "content": content}
+	}
+
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	ok := map[string]any{"description": http.StatusText(status)}
+	content := map[string]any{}
+	if op.Response != nil {
+		content["application/json"] = map[string]any{"schema": g.Schema(op.Response)}
+	}
+	for _, t := range op.ResponseTypes {
+		content[t] = map[string]any{}
+	}
+	if len(content) > 0 {
+		ok["content"] = content
+	}
+	responses := map[string]any{strconv.Itoa(status): ok}
+	for _, code := range op.Also {
+		also := map[string]any{"description": http.StatusText(code)}
+		if content, ok := ok["content"]; ok {
+			also["content"] = content
+		}
+		responses[strconv.Itoa(code)] = also
+	}
+	for _, code := range op.Errors {
+		responses[strconv.Itoa(code)] = map[string]any{
+			"description": http.StatusText(code),
+			"content":     map[string]any{"application/json": map[string]any{"schema": errorSchema}},
+		}
+	}
+	out["responses"] = responses
+	if op.Public {
+		out["security"] = []any{}
+	}
+	return out
+}
+
+// operationID derives a unique ID from the method and path, e.g.
+// get_api_runs_id for GET /api/runs/{id}.
+func operationID(op Operation) string {
+	id := strings.ToLower(op.Method) + op.Path
+	id = strings.NewReplacer("/", "_", "{", "", "}", "", ".", "_", "-", "_").Replace(id)
+	return id
+}
@@ -0,0 +1,235 @@
+// Package openapi builds an OpenAPI 3.1 document whose schemas come from Go
+// types, by the rules encoding/json marshals them with, so that the document
+// cannot drift from what the handlers send. Operations are listed by hand;
+// their bodies and responses name Go values whose types become components.
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Version is the OpenAPI version documents are written in; 3.1 schemas are
+// JSON Schema 2020-12, as the published request schemas are.
+const Version = "3.1.0"
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Generator collects the component schemas of the types it is given.
+// Structs become components named after their type, capitalized; those of
+// packages other than the home ones are prefixed with their package name,
+// so that config.Report and archive.Report stay apart.
+type Generator struct {
+	homes   []string
+	schemas map[string]any
+	// Component name by type, for types already named
+	names map[reflect.Type]string
+}
+
+// New returns a Generator whose home packages' types keep their own names.
+func New(homes ...string) *Generator {
+	return &Generator{homes: homes, schemas: map[string]any{}, names: map[reflect.Type]string{}}
+}
+
+// Name gives v's type the component name name.
+func (g *Generator) Name(v any, name string) {
+	g.names[reflect.TypeOf(v)] = name
+}
+
+// Define gives v's type the schema s, as published elsewhere, instead of
+// the one its fields would give it. s is a JSON Schema document.
+func (g *Generator) Define(v any, s []byte) error {
+	var doc map[string]any
+	if err := json.Unmarshal(s, &doc); err != nil {
+		return err
+	}
+	// Identifiers of the standalone document, not of a component
+	delete(doc, "$schema")
+	delete(doc, "$id")
+	t := reflect.TypeOf(v)
+	name := g.name(t)
+	g.names[t] = name
+	g.schemas[name] = doc
+	return nil
+}
+
+// OneOf stands for a value of any one of its values' types.
+type OneOf []any
+
+// Schema returns the schema of v's type: a reference to a component for a
+// named struct, an inline schema otherwise.
+func (g *Generator) Schema(v any) map[string]any {
+	if alts, ok := v.(OneOf); ok {
+		out := make([]any, len(alts))
+		for i, alt := range alts {
+			out[i] = g.Schema(alt)
+		}
+		return map[string]any{"oneOf": out}
+	}
+	return g.schema(reflect.TypeOf(v))
+}
+
+// Component adds schema s as component name, for a schema no Go type has,
+// such as a union of them, and returns a reference to it.
+func (g *Generator) Component(name string, s map[string]any) map[string]any {
+	g.schemas[name] = s
+	return Ref(name)
+}
+
+// Components returns every component schema collected, by name.
+func (g *Generator) Components() map[string]any {
+	return g.schemas
+}
+
+// Ref is a reference to component name.
+func Ref(name string) map[string]any {
+	return map[string]any{"$ref": "#/components/schemas/" + name}
+}
+
+func (g *Generator) schema(t reflect.Type) map[string]any {
+	if t == nil {
+		return map[string]any{}
+	}
+	if t == timeType {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.schema(t.Elem())
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]any{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Interface:
+		return map[string]any{}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		name, ok := g.names[t]
+		if !ok {
+			name = g.name(t)
+			g.names[t] = name
+		}
+		if _, done := g.schemas[name]; !done {
+			// Reserved first, for types that refer to themselves
+			g.schemas[name] = nil
+			g.schemas[name] = g.object(t)
+		}
+		return Ref(name)
+	}
+	panic(fmt.Sprintf("openapi: no schema for %s", t))
+}
+
+// object is the schema of struct t: its fields as encoding/json sees them,
+// those of embedded structs included, and required unless omitempty.
+func (g *Generator) object(t reflect.Type) map[string]any {
+	props := map[string]any{}
+	var required []string
+	for _, f := range Fields(t) {
+		s := g.schema(f.Type)
+		if f.Type.Kind() == reflect.Pointer && !f.OmitEmpty {
+			s = map[string]any{"anyOf": []any{s, map[string]any{"type": "null"}}}
+		}
+		props[f.Name] = s
+		if !f.OmitEmpty {
+			required = append(required, f.Name)
+		}
+	}
+	out := map[string]any{"type": "object", "properties": props}
+	if len(required) > 0 {
+		sort.Strings(required)
+		out["required"] = required
+	}
+	return out
+}
+
+// name is t's component name; two types given the same one panic.
+func (g *Generator) name(t reflect.Type) string {
+	name := capitalize(t.Name())
+	if !slices.Contains(g.homes, t.PkgPath()) {
+		name = capitalize(path.Base(t.PkgPath())) + name
+	}
+	for other, n := range g.names {
+		if n == name && other != t {
+			panic(fmt.Sprintf("openapi: %s and %s are both named %s", other, t, name))
+		}
+	}
+	return name
+}
+
+func capitalize(s string) string {
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// Field is a struct field as encoding/json names it.
+type Field struct {
+	Name      string
+	Type      reflect.Type
+	OmitEmpty bool
+}
+
+// Fields returns the fields encoding/json writes for struct t, promoting
+// those of untagged embedded structs. A field promoted from deeper down
+// loses to one of the same name higher up.
+func Fields(t reflect.Type) []Field {
+	var out []Field
+	seen := map[string]bool{}
+	var walk func(t reflect.Type)
+	walk = func(t reflect.Type) {
+		var embedded []reflect.Type
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			if f.Anonymous && name == "" {
+				ft := f.Type
+				if ft.Kind() == reflect.Pointer {
+					ft = ft.Elem()
+				}
+				if ft.Kind() == reflect.Struct {
+					embedded = append(embedded, ft)
+					continue
+				}
+			}
+			if !f.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			out = append(out, Field{Name: name, Type: f.Type, OmitEmpty: strings.Contains(","+opts+",", ",omitempty,")})
+		}
+		for _, e := range embedded {
+			walk(e)
+		}
+	}
+	walk(t)
+	return out
+}
@@ -0,0 +1,90 @@
+package openapi
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type inner struct {
+	Shared string `json:"shared"`
+	Deep   int    `json:"deep,omitempty"`
+}
+
+type node struct {
+	inner
+	Name     string            `json:"name"`
+	Shared   bool              `json:"shared"`
+	When     time.Time         `json:"when"`
+	Cost     *float64          `json:"cost"`
+	Children []node            `json:"children,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Any      any               `json:"any,omitempty"`
+	Skipped  string            `json:"-"`
+	Untagged int64
+	hidden   string
+}
+
+func TestSchemaFollowsEncodingJSON(t *testing.T) {
+	g := New("simstack/internal/openapi")
+	if ref := g.Schema(node{}); !reflect.DeepEqual(ref, Ref("Node")) {
+		t.Fatalf("expected a reference to Node, got %v", ref)
+	}
+	s := g.Components()["Node"].(map[string]any)
+	props := s["properties"].(map[string]any)
+	var names []string
+	for name := range props {
+		names = append(names, name)
+	}
+	want := []string{"name", "shared", "when", "cost", "children", "labels", "any", "Untagged", "deep"}
+	if len(props) != len(want) {
+		t.Errorf("expected properties %v, got %v", want, names)
+	}
+	for _, name := range want {
+		if props[name] == nil {
+			t.Errorf("expected property %s, got %v", name, names)
+		}
+	}
+	if props["shared"].(map[string]any)["type"] != "boolean" {
+		t.Errorf("expected the outer shared to hide the embedded one, got %v", props["shared"])
+	}
+	if !reflect.DeepEqual(props["children"], map[string]any{"type": "array", "items": Ref("Node")}) {
+		t.Errorf("expected children to refer back to Node, got %v", props["children"])
+	}
+	if props["when"].(map[string]any)["format"] != "date-time" || props["cost"].(map[string]any)["anyOf"] == nil {
+		t.Errorf("expected a date-time and a nullable number, got %v %v", props["when"], props["cost"])
+	}
+	if !reflect.DeepEqual(s["required"], []string{"Untagged", "cost", "name", "shared", "when"}) {
+		t.Errorf("expected the fields without omitempty required, got %v", s["required"])
+	}
+
+	union := g.Schema(OneOf{[]node{}, inner{}})
+	if alts := union["oneOf"].([]any); len(alts) != 2 || !reflect.DeepEqual(alts[1], Ref("Inner")) {
+		t.Errorf("expected a oneOf of both, got %v", union)
+	}
+}
+
+func TestDefineAndName(t *testing.T) {
+	g := New()
+	if err := g.Define(inner{}, []byte(`{"$schema": "x", "$id": "y", "type": "object", "required": ["shared"]}`)); err != nil {
+		t.Fatal(err)
+	}
+	g.Name(node{}, "Tree")
+	g.Schema(node{})
+	defined := g.Components()["OpenapiInner"].(map[string]any)
+	if defined["$id"] != nil || defined["required"] == nil {
+		t.Errorf("expected the published schema without its identifiers, got %v", defined)
+	}
+	if g.Components()["Tree"] == nil {
+		t.Errorf("expected node named Tree, got %v", g.Components())
+	}
+
+	g = New("simstack/internal/openapi")
+	g.Name(node{}, "Inner")
+	defer func() {
+		if recover() == nil {
+			t.Error("expected two types given one name to panic")
+		}
+	}()
+	g.Schema(inner{})
+}
@@ -0,0 +1,172 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"simstack/internal/types"
+)
+
+// Limits on what one run may carry in annotations
+const (
+	maxAnnotations       = 200
+	maxAnnotationText    = 4000
+	maxAnnotationAuthor  = 100
+	maxAnnotationTags    = 10
+	maxAnnotationTagSize = 50
+)
+
+var (
+	// ErrInvalidAnnotation wraps what is wrong with an annotation that was
+	// refused, including the run having too many already.
+	ErrInvalidAnnotation = errors.New("invalid annotation")
+	// ErrUnknownVariant means an annotation names a variant the run's plan
+	// doesn't have.
+	ErrUnknownVariant = errors.New("unknown variant")
+	// ErrAnnotationNotFound means the run has no annotation by that ID.
+	ErrAnnotationNotFound = errors.New("annotation not found")
+	// ErrNotAuthor means a caller other than the one who added an annotation
+	// tried to delete it.
+	ErrNotAuthor = errors.New("only the author may delete an annotation")
+)
+
+// Annotations returns the notes left on run id, oldest first.
+func (e *Engine) Annotations(ctx context.Context, id string) ([]types.Annotation, error) {
+	run, err := e.store.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if run.Annotations == nil {
+		return []types.Annotation{}, nil
+	}
+	return run.Annotations, nil
+}
+
+// Annotate adds a to run id, stamping its ID and creation time, and tells
+// the run's watchers. a.Owner names the caller adding it, who alone may
+// delete it. An in-flight run keeps the note when it finishes.
+func (e *Engine) Annotate(ctx context.Context, id string, a types.Annotation) (types.Annotation, error) {
+	a.Author = strings.TrimSpace(a.Author)
+	a.Text = strings.TrimSpace(a.Text)
+	if err := validateAnnotation(a); err != nil {
+		return types.Annotation{}, err
+	}
+
+	e.annotationsMu.Lock()
+	defer e.annotationsMu.Unlock()
+	run, err := e.store.Get(ctx, id)
+	if err != nil {
+		return types.Annotation{}, err
+	}
+	if len(run.Annotations) >= maxAnnotations {
+		return types.Annotation{}, fmt.Errorf("%w: run already has %d annotations", ErrInvalidAnnotation, maxAnnotations)
+	}
+	if a.VariantID != "" && !hasVariant(run, a.VariantID) {
+		return types.Annotation{}, fmt.Errorf("%w %s", ErrUnknownVariant, a.VariantID)
+	}
+	a.ID = newAnnotationID()
+	a.CreatedAt = e.clock.Now().UTC()
+	if err := e.saveAnnotations(ctx, run, append(slices.Clone(run.Annotations), a)); err != nil {
+		return types.Annotation{}, err
+	}
+	e.emitAnnotation(run, types.AnnotationEvent{Annotation: a})
+	return a, nil
+}
+
+// DeleteAnnotation removes annotation annotationID from run id on behalf of
+// caller, who must be the one who added it.
+func (e *Engine) DeleteAnnotation(ctx context.Context, id, annotationID, caller string) error {
+	e.annotationsMu.Lock()
+	defer e.annotationsMu.Unlock()
+	run, err := e.store.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(run.Annotations, func(a types.Annotation) bool { return a.ID == annotationID })
+	if i < 0 {
+		return ErrAnnotationNotFound
+	}
+	a := run.Annotations[i]
+	if a.Owner != caller {
+		return ErrNotAuthor
+	}
+	if err := e.saveAnnotations(ctx, run, slices.Delete(slices.Clone(run.Annotations), i, i+1)); err != nil {
+		return err
+	}
+	e.emitAnnotation(run, types.AnnotationEvent{Annotation: a, Deleted: true})
+	return nil
+}
+
+// saveAnnotations stores run with annotations in place of its own, in its
+// manifest too if it has one yet. e.annotationsMu must be held.
+func (e *Engine) saveAnnotations(ctx context.Context, run types.RunRecord, annotations []types.Annotation) error {
+	if len(annotations) == 0 {
+		annotations = nil
+	}
+	run.Annotations = annotations
+	if run.Manifest != nil {
+		manifest := *run.Manifest
+		manifest.Annotations = annotations
+		run.Manifest = &manifest
+	}
+	return e.store.Save(ctx, run)
+}
+
+// keepAnnotations carries the annotations already stored for run over to
+// it, so a run saving its own record doesn't drop notes added while it was
+// in flight. e.annotationsMu must be held.
+func (e *Engine) keepAnnotations(ctx context.Context, run *types.RunRecord) {
+	stored, err := e.store.Get(ctx, run.ID)
+	if err != nil || len(stored.Annotations) == 0 {
+		return
+	}
+	run.Annotations = stored.Annotations
+	if run.Manifest != nil {
+		manifest := *run.Manifest
+		manifest.Annotations = stored.Annotations
+		run.Manifest = &manifest
+	}
+}
+
+func (e *Engine) emitAnnotation(run types.RunRecord, payload types.AnnotationEvent) {
+	ev := types.NewEventAt(e.clock.Now(), types.EventAnnotation, payload)
+	ev.RunID, ev.PlanID = run.ID, run.PlanID
+	e.emit(ev)
+}
+
+func validateAnnotation(a types.Annotation) error {
+	switch {
+	case a.Author == "":
+		return fmt.Errorf("%w: author is required", ErrInvalidAnnotation)
+	case utf8.RuneCountInString(a.Author) > maxAnnotationAuthor:
+		return fmt.Errorf("%w: author is longer than %d characters", ErrInvalidAnnotation, maxAnnotationAuthor)
+	case a.Text == "":
+		return fmt.Errorf("%w: text is required", ErrInvalidAnnotation)
+	case utf8.RuneCountInString(a.Text) > maxAnnotationText:
+		return fmt.Errorf("%w: text is longer than %d characters", ErrInvalidAnnotation, maxAnnotationText)
+	case len(a.Tags) > maxAnnotationTags:
+		return fmt.Errorf("%w: more than %d tags", ErrInvalidAnnotation, maxAnnotationTags)
+	}
+	for _, tag := range a.Tags {
+		if tag == "" || utf8.RuneCountInString(tag) > maxAnnotationTagSize {
+			return fmt.Errorf("%w: tags must be 1 to %d characters", ErrInvalidAnnotation, maxAnnotationTagSize)
+		}
+	}
+	return nil
+}
+
+// hasVariant reports whether run's plan or results include variant id.
+func hasVariant(run types.RunRecord, id string) bool {
+	if run.Plan != nil && slices.ContainsFunc(run.Plan.Variants, func(v types.Variant) bool { return v.VariantID == id }) {
+		return true
+	}
+	return slices.ContainsFunc(run.Results, func(r types.SimulationResult) bool { return r.VariantID == id })
+}
+
+func newAnnotationID() string {
+	return "ann-" + randomHex(8)
+}
@@ -0,0 +1,91 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"simstack/internal/eventbus"
+	"simstack/internal/runstore"
+	"simstack/internal/testsupport"
+	"simstack/internal/types"
+)
+
+func TestAnnotations(t *testing.T) {
+	ctx := context.Background()
+	store := runstore.NewMemory()
+	clk := testsupport.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	var events []types.WSEvent
+	bus := eventbus.Direct(func(v any) { events = append(events, v.(types.WSEvent)) })
+	e := NewEngine(bus, WithChatClient(testsupport.NewFakeChat(), "m"), WithRunStore(store), WithClock(clk))
+
+	// A run in flight, which doesn't know about notes added meanwhile
+	running := types.RunRecord{ID: "run-1", Goal: "g", Status: "running", PlanID: "plan-1",
+		Plan: &types.SimulationPlan{PlanID: "plan-1", Variants: []types.Variant{{VariantID: "plan-1-v1"}}}}
+	e.saveRun(ctx, running)
+
+	a, err := e.Annotate(ctx, "run-1", types.Annotation{Author: " dana ", Owner: "key:dana", Text: "approved for pilot", VariantID: "plan-1-v1", Tags: []string{"decision"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.ID == "" || a.Author != "dana" || !a.CreatedAt.Equal(clk.Now()) {
+		t.Errorf("expected the note stamped and trimmed, got %+v", a)
+	}
+	if len(events) != 1 || events[0].Type != types.EventAnnotation || events[0].RunID != "run-1" || events[0].PlanID != "plan-1" {
+		t.Fatalf("expected one annotation event for the run, got %+v", events)
+	}
+
+	finished := clk.Now()
+	running.Status, running.FinishedAt = "completed", &finished
+	running.Manifest = &types.RunManifest{RunID: "run-1"}
+	e.saveRun(ctx, running)
+	run, _ := e.GetRun(ctx, "run-1")
+	if len(run.Annotations) != 1 || run.Manifest == nil || len(run.Manifest.Annotations) != 1 || run.Manifest.Annotations[0].ID != a.ID {
+		t.Errorf("expected the note kept in the record and its manifest, got %+v", run)
+	}
+
+	for name, c := range map[string]struct {
+		run  string
+		note types.Annotation
+		want error
+	}{
+		"unknown run":     {run: "missing", note: types.Annotation{Author: "dana", Text: "x"}, want: runstore.ErrNotFound},
+		"unknown variant": {run: "run-1", note: types.Annotation{Author: "dana", Text: "x", VariantID: "plan-1-v9"}, want: ErrUnknownVariant},
+		"no author":       {run: "run-1", note: types.Annotation{Text: "x"}, want: ErrInvalidAnnotation},
+		"text too long":   {run: "run-1", note: types.Annotation{Author: "dana", Text: strings.Repeat("x", maxAnnotationText+1)}, want: ErrInvalidAnnotation},
+	} {
+		if _, err := e.Annotate(ctx, c.run, c.note); !errors.Is(err, c.want) {
+			t.Errorf("%s: got %v, want %v", name, err, c.want)
+		}
+	}
+
+	if err := e.DeleteAnnotation(ctx, "run-1", a.ID, "key:sam"); !errors.Is(err, ErrNotAuthor) {
+		t.Errorf("expected only the caller who added it allowed to delete, got %v", err)
+	}
+	if err := e.DeleteAnnotation(ctx, "run-1", a.ID, "key:dana"); err != nil {
+		t.Fatal(err)
+	}
+	if notes, _ := e.Annotations(ctx, "run-1"); len(notes) != 0 {
+		t.Errorf("expected the note gone, got %+v", notes)
+	}
+	if run, _ := e.GetRun(ctx, "run-1"); len(run.Manifest.Annotations) != 0 {
+		t.Errorf("expected the note gone from the manifest, got %+v", run.Manifest.Annotations)
+	}
+	if ev := events[len(events)-1].Payload.(types.AnnotationEvent); !ev.Deleted || ev.ID != a.ID {
+		t.Errorf("expected a deletion event, got %+v", ev)
+	}
+	if err := e.DeleteAnnotation(ctx, "run-1", a.ID, "key:dana"); !errors.Is(err, ErrAnnotationNotFound) {
+		t.Errorf("expected a second delete to find nothing, got %v", err)
+	}
+
+	for i := 0; i < maxAnnotations; i++ {
+		if _, err := e.Annotate(ctx, "run-1", types.Annotation{Author: "dana", Text: "note"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := e.Annotate(ctx, "run-1", types.Annotation{Author: "dana", Text: "one too many"}); !errors.Is(err, ErrInvalidAnnotation) {
+		t.Errorf("expected the count limit enforced, got %v", err)
+	}
+}
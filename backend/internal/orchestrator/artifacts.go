@@ -0,0 +1,37 @@
+package orchestrator
+
+import (
+	"context"
+	"log/slog"
+
+	"simstack/internal/artifacts"
+	"simstack/internal/types"
+)
+
+// captureArtifact stores data as an artifact of origin. Capture is best
+// effort: failures are logged and ok is false.
+func (e *Engine) captureArtifact(ctx context.Context, origin types.ArtifactOrigin, name, contentType string, data []byte) (types.Artifact, bool) {
+	if !e.captureArtifacts || origin.RunID == "" {
+		return types.Artifact{}, false
+	}
+	a, err := artifacts.New(origin, name, contentType, data)
+	if err == nil {
+		a, err = e.artifacts.Put(ctx, a, data)
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "artifact not kept", "artifact", name, "run_id", origin.RunID, "variant_id", origin.VariantID, "err", err)
+		return types.Artifact{}, false
+	}
+	return a, true
+}
+
+// Artifact returns a stored artifact and its content.
+func (e *Engine) Artifact(ctx context.Context, runID, variantID, name string) (types.Artifact, []byte, error) {
+	return e.artifacts.Get(ctx, runID, variantID, name)
+}
+
+// runActive reports whether run id is still in flight.
+func (e *Engine) runActive(id string) bool {
+	_, ok := e.active.Load(id)
+	return ok
+}
@@ -0,0 +1,123 @@
+package orchestrator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"simstack/internal/cerebras"
+	"simstack/internal/llm"
+	"simstack/internal/types"
+)
+
+// maxAuditContent caps each retained prompt message.
+const maxAuditContent = 4096
+
+// Credential shapes stripped from retained prompts even when the exact key
+// isn't known: bearer tokens and OpenAI/Cerebras-style secret keys.
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)bearer\s+[A-Za-z0-9._~+/=-]+`),
+	regexp.MustCompile(`\b(?:sk|csk)-[A-Za-z0-9_-]{8,}`),
+}
+
+// correlation ties LLM calls back to the run and phase that made them.
+type correlation struct {
+	RunID string
+	Phase string
+}
+
+type correlationKey struct{}
+
+func withRunID(ctx context.Context, runID string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, correlation{RunID: runID})
+}
+
+// withPhase tags calls made with ctx as belonging to phase of the current run.
+func withPhase(ctx context.Context, phase string) context.Context {
+	c, _ := ctx.Value(correlationKey{}).(correlation)
+	c.Phase = phase
+	return context.WithValue(ctx, correlationKey{}, c)
+}
+
+func correlationFrom(ctx context.Context) correlation {
+	c, _ := ctx.Value(correlationKey{}).(correlation)
+	return c
+}
+
+// recordAudit appends the compliance record for one LLM call to the run
+// store. Calls made outside a run (no run ID on ctx) are not audited.
+func (e *Engine) recordAudit(ctx context.Context, req cerebras.OpenAIChatRequest, resp map[string]any, call types.LLMCallRecord) {
+	corr := correlationFrom(ctx)
+	if corr.RunID == "" {
+		return
+	}
+
+	var prompt strings.Builder
+	for _, m := range req.Messages {
+		prompt.WriteString(m.Role + "\n" + fmt.Sprint(m.Content) + "\n")
+	}
+	rec := types.LLMAuditRecord{
+		RunID:      corr.RunID,
+		Phase:      corr.Phase,
+		Provider:   call.Provider,
+		Model:      call.Model,
+		Time:       e.clock.Now().UTC(),
+		PromptHash: hashText(prompt.String()),
+		LatencyMs:  call.LatencyMs,
+		Error:      redactSecrets(call.Error, e.auditSecrets),
+	}
+	if rec.Model == "" {
+		rec.Model = req.Model
+	}
+	if e.auditPrompts {
+		for _, m := range req.Messages {
+			rec.Prompt = append(rec.Prompt, types.AuditMessage{Role: m.Role, Content: sanitizeAudit(fmt.Sprint(m.Content), e.auditSecrets)})
+		}
+	}
+	if content, ok := cerebras.MessageContent(resp); ok {
+		rec.ResponseHash = hashText(content)
+	}
+	if usage, ok := resp["usage"].(map[string]any); ok {
+		rec.PromptTokens = intOf(usage["prompt_tokens"])
+		rec.CompletionTokens = intOf(usage["completion_tokens"])
+		rec.TotalTokens = intOf(usage["total_tokens"])
+	}
+	if err := e.store.AppendLLMCall(ctx, rec); err != nil {
+		slog.WarnContext(ctx, "audit record not stored", "run_id", rec.RunID, "provider", llm.NameOf(e.llm), "err", err)
+	}
+}
+
+// sanitizeAudit redacts credentials and caps s at maxAuditContent bytes.
+func sanitizeAudit(s string, secrets []string) string {
+	s = redactSecrets(s, secrets)
+	if len(s) > maxAuditContent {
+		s = s[:maxAuditContent] + fmt.Sprintf("...[truncated %d bytes]", len(s)-maxAuditContent)
+	}
+	return s
+}
+
+func redactSecrets(s string, secrets []string) string {
+	for _, secret := range secrets {
+		if secret != "" {
+			s = strings.ReplaceAll(s, secret, "[REDACTED]")
+		}
+	}
+	for _, re := range secretPatterns {
+		s = re.ReplaceAllString(s, "[REDACTED]")
+	}
+	return s
+}
+
+func hashText(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func intOf(v any) int {
+	f, _ := v.(float64)
+	return int(f)
+}
@@ -0,0 +1,83 @@
+package orchestrator
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"simstack/internal/runstore"
+	"simstack/internal/testsupport"
+	"simstack/internal/types"
+)
+
+func TestSanitizeAudit(t *testing.T) {
+	secrets := []string{"my-exact-key-123", ""}
+	cases := []struct {
+		in, want string
+	}{
+		{"goal: cut waits", "goal: cut waits"},
+		{"Authorization: Bearer abc.def-123", "Authorization: [REDACTED]"},
+		{"use key sk-proj_AbC123dEf456 please", "use key [REDACTED] please"},
+		{"cerebras csk-9x8y7z6w5v4u3t2s", "cerebras [REDACTED]"},
+		{"token=my-exact-key-123;", "token=[REDACTED];"},
+		{"sk-short", "sk-short"},
+	}
+	for _, tc := range cases {
+		if got := sanitizeAudit(tc.in, secrets); got != tc.want {
+			t.Errorf("sanitizeAudit(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+
+	long := strings.Repeat("x", maxAuditContent+100)
+	got := sanitizeAudit(long, nil)
+	if !strings.HasPrefix(got, strings.Repeat("x", maxAuditContent)+"...[truncated 100 bytes]") || len(got) > maxAuditContent+32 {
+		t.Errorf("expected content capped at %d bytes, got %d", maxAuditContent, len(got))
+	}
+}
+
+func TestLLMCallsAudited(t *testing.T) {
+	store := runstore.NewMemory()
+	fake := testsupport.NewFakeChat(
+		testsupport.Content(plannerJSON),
+		testsupport.Content(`{"winner": "p-v1", "recommendation": "keep v1", "confidence": 0.9, "trade_offs": [], "counterfactuals": []}`),
+	)
+	e := NewEngine(nil, WithChatClient(fake, "m"), WithRunStore(store))
+	e.auditPrompts = true
+	e.auditSecrets = []string{"hunter2-secret"}
+
+	ctx := context.Background()
+	_ = store.Save(ctx, types.RunRecord{ID: "run-1", StartedAt: time.Now()})
+	ctx = withRunID(ctx, "run-1")
+	req := types.RunRequest{Goal: "reduce wait, key hunter2-secret"}
+	e.plan(ctx, req, nil)
+	e.analyzeResults(ctx, req, []types.SimulationResult{{VariantID: "p-v1"}}, nil)
+
+	calls, err := store.LLMCalls(ctx, "run-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 2 || calls[0].Phase != "plan" || calls[1].Phase != "critic" {
+		t.Fatalf("expected plan and critic records, got %+v", calls)
+	}
+	c := calls[0]
+	if c.RunID != "run-1" || c.Provider != "fake" || c.Model != "m" || c.TotalTokens != 150 || c.PromptTokens != 100 {
+		t.Errorf("unexpected record %+v", c)
+	}
+	if !strings.HasPrefix(c.PromptHash, "sha256:") || !strings.HasPrefix(c.ResponseHash, "sha256:") || c.PromptHash == calls[1].PromptHash {
+		t.Errorf("expected distinct prompt hashes and a response hash, got %q / %q", c.PromptHash, c.ResponseHash)
+	}
+	if len(c.Prompt) != 2 || strings.Contains(c.Prompt[1].Content, "hunter2") || !strings.Contains(c.Prompt[1].Content, "[REDACTED]") {
+		t.Errorf("expected redacted retained prompt, got %+v", c.Prompt)
+	}
+
+	// Without retention only hashes are kept
+	e.auditPrompts = false
+	fake = testsupport.NewFakeChat(testsupport.Content(plannerJSON))
+	e.llm = fake
+	e.plan(ctx, req, nil)
+	calls, _ = store.LLMCalls(ctx, "run-1")
+	if last := calls[len(calls)-1]; last.Prompt != nil || last.PromptHash != c.PromptHash {
+		t.Errorf("expected hash-only record matching the earlier prompt, got %+v", last)
+	}
+}
@@ -0,0 +1,154 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"simstack/internal/clock"
+	"simstack/internal/types"
+)
+
+// ErrBudgetExhausted means a run has no LLM time or tokens left.
+var ErrBudgetExhausted = errors.New("llm budget exhausted")
+
+// Per-phase ceilings when LLM_PLAN_TIMEOUT and LLM_ANALYSIS_TIMEOUT don't
+// set them; the run budget can only shorten them.
+const (
+	planPhaseTimeout     = 90 * time.Second
+	analysisPhaseTimeout = 60 * time.Second
+)
+
+// phaseTimeouts returns the planning and analysis ceilings in effect.
+func (e *Engine) phaseTimeouts() (plan, analysis time.Duration) {
+	cfg := e.config()
+	plan, analysis = cfg.PlanTimeout, cfg.AnalysisTimeout
+	if plan <= 0 {
+		plan = planPhaseTimeout
+	}
+	if analysis <= 0 {
+		analysis = analysisPhaseTimeout
+	}
+	return plan, analysis
+}
+
+// llmBudget is a run's shared allowance of LLM wall time and tokens. Each call
+// acquires a context capped at the lesser of its phase maximum and what is
+// left, and spend is recorded when the call finishes, both on clock. A nil
+// budget is unlimited. Zero limits are unlimited too.
+type llmBudget struct {
+	clock      clock.Clock
+	timeLimit  time.Duration
+	tokenLimit int
+
+	mu     sync.Mutex
+	spent  time.Duration
+	tokens int
+}
+
+func newLLMBudget(c clock.Clock, timeLimit time.Duration, tokenLimit int) *llmBudget {
+	return &llmBudget{clock: c, timeLimit: timeLimit, tokenLimit: tokenLimit}
+}
+
+type budgetKey struct{}
+
+func withBudget(ctx context.Context, b *llmBudget) context.Context {
+	return context.WithValue(ctx, budgetKey{}, b)
+}
+
+func budgetFrom(ctx context.Context) *llmBudget {
+	b, _ := ctx.Value(budgetKey{}).(*llmBudget)
+	return b
+}
+
+// acquire returns a context for one LLM phase. The cancel func must always be
+// called; it records the time actually spent.
+func (b *llmBudget) acquire(ctx context.Context, phaseMax time.Duration) (context.Context, context.CancelFunc, error) {
+	if b == nil {
+		ctx, cancel := context.WithTimeout(ctx, phaseMax)
+		return ctx, cancel, nil
+	}
+
+	b.mu.Lock()
+	limit := phaseMax
+	exhausted := b.tokenLimit > 0 && b.tokens >= b.tokenLimit
+	if b.timeLimit > 0 {
+		remaining := b.timeLimit - b.spent
+		if remaining <= 0 {
+			exhausted = true
+		}
+		if remaining < limit {
+			limit = remaining
+		}
+	}
+	b.mu.Unlock()
+	if exhausted {
+		return ctx, func() {}, ErrBudgetExhausted
+	}
+
+	start := b.clock.Now()
+	ctx, cancel := clock.WithTimeout(ctx, b.clock, limit)
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() {
+			cancel()
+			b.mu.Lock()
+			b.spent += b.clock.Now().Sub(start)
+			b.mu.Unlock()
+		})
+	}, nil
+}
+
+// exhausted reports whether no time or tokens remain. A nil budget never is.
+func (b *llmBudget) exhausted() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return (b.tokenLimit > 0 && b.tokens >= b.tokenLimit) || (b.timeLimit > 0 && b.spent >= b.timeLimit)
+}
+
+func (b *llmBudget) addTokens(n int) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.tokens += n
+	b.mu.Unlock()
+}
+
+func (b *llmBudget) snapshot() (spent time.Duration, tokens int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.spent, b.tokens
+}
+
+// emitBudgetExhausted tells clients a phase skipped the LLM because the run's
+// budget was used up.
+func (e *Engine) emitBudgetExhausted(ctx context.Context, stage string) {
+	payload := types.BudgetExhaustedEvent{Stage: stage}
+	if b := budgetFrom(ctx); b != nil {
+		spent, tokens := b.snapshot()
+		payload.SpentMs = spent.Milliseconds()
+		payload.Tokens = tokens
+		payload.TimeBudgetMs = b.timeLimit.Milliseconds()
+		payload.TokenBudget = b.tokenLimit
+	}
+	e.eventsFrom(ctx).send(types.EventBudgetExhausted, payload)
+}
+
+// runBudget builds a run's budget from the request, falling back to
+// LLM_TIME_BUDGET and LLM_TOKEN_BUDGET.
+func (e *Engine) runBudget(req types.RunRequest) *llmBudget {
+	timeLimit := e.timeBudget
+	if req.LLMTimeBudgetSeconds > 0 {
+		timeLimit = time.Duration(req.LLMTimeBudgetSeconds * float64(time.Second))
+	}
+	tokenLimit := e.tokenBudget
+	if req.LLMTokenBudget > 0 {
+		tokenLimit = req.LLMTokenBudget
+	}
+	return newLLMBudget(e.clock, timeLimit, tokenLimit)
+}
@@ -0,0 +1,104 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"simstack/internal/clock"
+	"simstack/internal/eventbus"
+	"simstack/internal/testsupport"
+	"simstack/internal/types"
+)
+
+func TestBudgetCapsPhaseAndSkipsLaterPhases(t *testing.T) {
+	rec := &recorder{}
+	clk := testsupport.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	fake := testsupport.NewFakeChat(testsupport.Slow(testsupport.Content(plannerJSON), time.Second))
+	fake.Clock = clk
+	e := NewEngine(eventbus.Direct(rec.emit), WithChatClient(fake, "m"), WithClock(clk))
+	ctx := withBudget(context.Background(), newLLMBudget(clk, 50*time.Millisecond, 0))
+
+	planned := make(chan types.SimulationPlan, 1)
+	go func() { planned <- e.plan(ctx, types.RunRequest{Goal: "g"}, nil) }()
+	// The budget's deadline, the soft deadline and the planner's reply
+	clk.BlockUntil(3)
+	clk.Advance(50 * time.Millisecond)
+	plan := <-planned
+	if len(plan.Variants) != len(e.fallbackVariants("x", types.RunRequest{})) {
+		t.Errorf("expected fallback variants after timeout, got %d", len(plan.Variants))
+	}
+
+	analysis := e.analyzeResults(ctx, types.RunRequest{Goal: "g"}, []types.SimulationResult{{VariantID: "p-v1"}}, nil)
+	if analysis["winner"] != "p-v1" {
+		t.Errorf("expected fallback analysis, got %v", analysis)
+	}
+	if fake.Calls() != 1 {
+		t.Errorf("critic should not call the LLM once the budget is spent, got %d calls", fake.Calls())
+	}
+	events := rec.ofType("llm_budget_exhausted")
+	if len(events) != 1 || events[0].Payload.(types.BudgetExhaustedEvent).Stage != "analysis" {
+		t.Errorf("expected one llm_budget_exhausted event for analysis, got %+v", events)
+	}
+}
+
+func TestTokenBudgetExhaustion(t *testing.T) {
+	fake := testsupport.NewFakeChat(testsupport.Content(plannerJSON))
+	e := NewEngine(nil, WithChatClient(fake, "m"))
+	ctx := withBudget(context.Background(), newLLMBudget(clock.Real, 0, 100))
+
+	e.plan(ctx, types.RunRequest{Goal: "g"}, nil)
+	e.analyzeResults(ctx, types.RunRequest{Goal: "g"}, []types.SimulationResult{{VariantID: "p-v1"}}, nil)
+	if fake.Calls() != 1 {
+		t.Errorf("expected critic to be skipped after 150 of 100 tokens, got %d calls", fake.Calls())
+	}
+}
+
+func TestBudgetConcurrentAccounting(t *testing.T) {
+	clk := testsupport.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	b := newLLMBudget(clk, time.Minute, 0)
+	cancels := make([]context.CancelFunc, 20)
+	var wg sync.WaitGroup
+	for i := range cancels {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, cancel, err := b.acquire(context.Background(), time.Second)
+			if err != nil {
+				t.Errorf("acquire: %v", err)
+				cancel = func() {}
+			}
+			cancels[i] = cancel
+			b.addTokens(5)
+		}()
+	}
+	wg.Wait()
+	clk.Advance(10 * time.Millisecond)
+	for _, cancel := range cancels {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cancel()
+			cancel()
+		}()
+	}
+	wg.Wait()
+
+	spent, tokens := b.snapshot()
+	if tokens != 100 {
+		t.Errorf("expected 100 tokens, got %d", tokens)
+	}
+	if spent != 200*time.Millisecond {
+		t.Errorf("expected 20x10ms recorded, got %v", spent)
+	}
+
+	exhausted := newLLMBudget(clk, time.Nanosecond, 0)
+	_, cancel, _ := exhausted.acquire(context.Background(), time.Second)
+	clk.Advance(time.Millisecond)
+	cancel()
+	if _, _, err := exhausted.acquire(context.Background(), time.Second); !errors.Is(err, ErrBudgetExhausted) {
+		t.Errorf("expected ErrBudgetExhausted, got %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+package orchestrator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// simBuffers holds the request and response bodies of simulator calls, so
+// a fan-out of hundreds of variants reuses a handful of buffers rather than
+// growing fresh ones for every call.
+var simBuffers = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+// Buffers that grew past this, for an unusually large response, are left
+// to the collector rather than kept in the pool.
+const maxPooledBuffer = 64 << 10
+
+func getBuffer() *bytes.Buffer {
+	buf := simBuffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBuffer {
+		simBuffers.Put(buf)
+	}
+}
+
+// requestBody returns params as JSON, byte for byte what json.Marshal
+// would, in a pooled buffer that goes back to the pool when the transport
+// closes the body. The transport may do that after the call has returned.
+func requestBody(params map[string]any) (*pooledBody, error) {
+	buf := getBuffer()
+	if b, ok := appendParams(buf.AvailableBuffer(), params); ok {
+		buf.Write(b)
+	} else {
+		if err := json.NewEncoder(buf).Encode(params); err != nil {
+			putBuffer(buf)
+			return nil, err
+		}
+		buf.Truncate(buf.Len() - 1) // Encode's trailing newline
+	}
+	body := &pooledBody{buf: buf}
+	body.Reset(buf.Bytes())
+	return body, nil
+}
+
+// appendParams appends params to b as json.Marshal would write them, without
+// its reflection, when every value is of a kind simulators take: a number
+// json writes without an exponent, an int, or a plain string or list of
+// them. It reports false for anything else, which is left to json.
+func appendParams(b []byte, params map[string]any) ([]byte, bool) {
+	var names [8]string
+	if len(params) > len(names) {
+		return b, false
+	}
+	keys := names[:0]
+	for k := range params {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	ok := true
+	b = append(b, '{')
+	for i, k := range keys {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b, ok = appendPlainString(b, k)
+		b = append(b, ':')
+		switch v := params[k].(type) {
+		case float64:
+			if math.IsNaN(v) || math.IsInf(v, 0) || v != 0 && (math.Abs(v) < 1e-6 || math.Abs(v) >= 1e21) {
+				return b, false
+			}
+			b = strconv.AppendFloat(b, v, 'f', -1, 64)
+		case int:
+			b = strconv.AppendInt(b, int64(v), 10)
+		case string:
+			b, ok = appendPlainString(b, v)
+		case []string:
+			if v == nil {
+				b = append(b, "null"...)
+				break
+			}
+			b = append(b, '[')
+			for j := 0; ok && j < len(v); j++ {
+				if j > 0 {
+					b = append(b, ',')
+				}
+				b, ok = appendPlainString(b, v[j])
+			}
+			b = append(b, ']')
+		default:
+			return b, false
+		}
+		if !ok {
+			return b, false
+		}
+	}
+	return append(b, '}'), true
+}
+
+// appendPlainString appends s quoted, reporting false when json would
+// escape any of it.
+func appendPlainString(b []byte, s string) ([]byte, bool) {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < 0x20 || c >= 0x7f || c == '"' || c == '\\' || c == '<' || c == '>' || c == '&' {
+			return b, false
+		}
+	}
+	b = append(b, '"')
+	b = append(b, s...)
+	return append(b, '"'), true
+}
+
+type pooledBody struct {
+	bytes.Reader
+	buf  *bytes.Buffer
+	once sync.Once
+}
+
+func (b *pooledBody) Close() error {
+	b.once.Do(func() { putBuffer(b.buf) })
+	return nil
+}
+
+// readBody reads r whole into a pooled buffer and returns a copy sized to
+// fit, which the caller may keep. Bodies longer than max bytes are refused
+// unread past the limit.
+func readBody(r io.Reader, max int64) ([]byte, error) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if _, err := buf.ReadFrom(io.LimitReader(r, max+1)); err != nil {
+		return nil, err
+	}
+	if int64(buf.Len()) > max {
+		return nil, fmt.Errorf("simulator response exceeds %d bytes", max)
+	}
+	return bytes.Clone(buf.Bytes()), nil
+}
+
+// maxPrefixedNames bounds prefixedNames against simulators inventing metric
+// names; past it, names are built afresh.
+const maxPrefixedNames = 4096
+
+// prefixedNames interns tool-prefixed metric names, which every variant
+// would otherwise build again for every metric of every call.
+type prefixedNames struct {
+	mu    sync.RWMutex
+	names map[[2]string]string
+}
+
+// get returns tool + "_" + metric.
+func (p *prefixedNames) get(tool, metric string) string {
+	key := [2]string{tool, metric}
+	p.mu.RLock()
+	name, ok := p.names[key]
+	p.mu.RUnlock()
+	if ok {
+		return name
+	}
+	name = tool + "_" + metric
+	p.mu.Lock()
+	if p.names == nil {
+		p.names = make(map[[2]string]string)
+	}
+	if len(p.names) < maxPrefixedNames {
+		p.names[key] = name
+	}
+	p.mu.Unlock()
+	return name
+}
@@ -0,0 +1,82 @@
+package orchestrator
+
+import (
+	"simstack/internal/cerebras"
+	"simstack/internal/types"
+)
+
+// estimateUsage fills in token counts for a response that reported none, as
+// streams without a usage chunk do: the prompt from the pre-call estimate,
+// the completion from the returned content.
+func estimateUsage(call *types.LLMCallRecord, resp map[string]any) {
+	content, _ := cerebras.MessageContent(resp)
+	call.PromptTokens = call.EstimatedPromptTokens
+	call.CompletionTokens = cerebras.EstimateTokens(content)
+	call.Tokens = call.PromptTokens + call.CompletionTokens
+	call.UsageEstimated = true
+}
+
+// priceCall sets the estimated cost of a completed call and adds it to the
+// process-wide total; calls to unpriced models keep a nil cost.
+func (e *Engine) priceCall(call *types.LLMCallRecord) {
+	completion := call.CompletionTokens
+	if completion == 0 && call.Tokens > call.PromptTokens {
+		// Providers that report only totals
+		completion = call.Tokens - call.PromptTokens
+	}
+	usd, ok := e.pricing.Cost(call.Model, call.PromptTokens, completion)
+	if !ok {
+		e.counters.UnpricedLLMCalls.Add(1)
+		return
+	}
+	call.CostUSD = &usd
+	e.counters.AddCost(usd)
+}
+
+// runCost sums the cost of a run's calls. It is nil when any completed call
+// used a model without a price, since a partial sum would understate it;
+// unpriced counts those calls.
+func runCost(calls []types.LLMCallRecord) (cost *float64, unpriced int) {
+	var total float64
+	for _, c := range calls {
+		switch {
+		case c.Error != "":
+			// Failed calls report no usage and are not billed
+		case c.CostUSD == nil:
+			unpriced++
+		default:
+			total += *c.CostUSD
+		}
+	}
+	if unpriced > 0 {
+		return nil, unpriced
+	}
+	return &total, 0
+}
+
+// phaseUsage sums a run's calls by phase, planning first, next to the model
+// each phase asked for. Phases that made no call are left out.
+func phaseUsage(manifest *types.RunManifest) []types.PhaseUsage {
+	var usage []types.PhaseUsage
+	for _, p := range []struct{ phase, model string }{
+		{"plan", manifest.PlannerModel},
+		{"analysis", manifest.CriticModel},
+	} {
+		var calls []types.LLMCallRecord
+		for _, c := range manifest.LLMCalls {
+			if c.Purpose == p.phase {
+				calls = append(calls, c)
+			}
+		}
+		if len(calls) == 0 {
+			continue
+		}
+		u := types.PhaseUsage{Phase: p.phase, Model: p.model, Calls: len(calls)}
+		for _, c := range calls {
+			u.Tokens += c.Tokens
+		}
+		u.CostUSD, _ = runCost(calls)
+		usage = append(usage, u)
+	}
+	return usage
+}
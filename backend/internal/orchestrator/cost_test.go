@@ -0,0 +1,71 @@
+package orchestrator
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"simstack/internal/cerebras"
+	"simstack/internal/clock"
+	"simstack/internal/testsupport"
+	"simstack/internal/types"
+)
+
+func TestLLMCallCost(t *testing.T) {
+	streamed := testsupport.ChatResponse("four")
+	delete(streamed, "usage")
+	fake := testsupport.NewFakeChat(testsupport.Content("{}"), testsupport.Reply{Response: streamed}, testsupport.Reply{Err: cerebras.ErrOffline})
+	e := NewEngine(nil, WithChatClient(fake, "llama3.1-8b"))
+	req := cerebras.OpenAIChatRequest{Model: "llama3.1-8b", Messages: []cerebras.ChatMessage{{Role: "user", Content: "hi"}}}
+	manifest := &types.RunManifest{}
+	budget := newLLMBudget(clock.Real, 0, 0)
+	ctx := withBudget(context.Background(), budget)
+	for i := 0; i < 3; i++ {
+		_, _, _ = e.chat(ctx, "plan", req, manifest)
+	}
+
+	// 100 prompt and 50 completion tokens at $0.10 per million each
+	reported := manifest.LLMCalls[0]
+	if reported.CostUSD == nil || math.Abs(*reported.CostUSD-150*0.10/1e6) > 1e-15 || reported.UsageEstimated {
+		t.Errorf("unexpected cost of a reported call %+v", reported)
+	}
+	estimated := manifest.LLMCalls[1]
+	wantTokens := cerebras.EstimatePromptTokens(req) + cerebras.EstimateTokens("four")
+	if !estimated.UsageEstimated || estimated.Tokens != wantTokens || estimated.CostUSD == nil || math.Abs(*estimated.CostUSD-float64(wantTokens)*0.10/1e6) > 1e-15 {
+		t.Errorf("expected an estimated cost for %d tokens, got %+v", wantTokens, estimated)
+	}
+	if _, tokens := budget.snapshot(); tokens != reported.Tokens+estimated.Tokens {
+		t.Errorf("expected the budget charged %d tokens, estimated ones too, got %d", reported.Tokens+estimated.Tokens, tokens)
+	}
+	if failed := manifest.LLMCalls[2]; failed.CostUSD != nil {
+		t.Errorf("a failed call must not be priced, got %+v", failed)
+	}
+
+	cost, unpriced := runCost(manifest.LLMCalls)
+	want := *reported.CostUSD + *estimated.CostUSD
+	if cost == nil || *cost != want || unpriced != 0 {
+		t.Errorf("expected a run cost of $%g, got %v (%d unpriced)", want, cost, unpriced)
+	}
+	if got := e.Metrics(0).Counters.CostUSD; got != want {
+		t.Errorf("expected $%g in the global total, got $%g", want, got)
+	}
+}
+
+func TestUnpricedModelCostIsUnknown(t *testing.T) {
+	e := NewEngine(nil, WithChatClient(testsupport.NewFakeChat(testsupport.Content("{}")), "private-model"))
+	manifest := &types.RunManifest{}
+	_, _, _ = e.chat(context.Background(), "plan", cerebras.OpenAIChatRequest{Model: "private-model"}, manifest)
+
+	if manifest.LLMCalls[0].CostUSD != nil {
+		t.Errorf("expected no cost for an unpriced model, got %v", *manifest.LLMCalls[0].CostUSD)
+	}
+	if cost, unpriced := runCost(manifest.LLMCalls); cost != nil || unpriced != 1 {
+		t.Errorf("expected an unknown run cost, got %v (%d unpriced)", cost, unpriced)
+	}
+	if c := e.Metrics(0).Counters; c.UnpricedLLMCalls != 1 || c.CostUSD != 0 {
+		t.Errorf("unexpected counters %+v", c)
+	}
+	if cost, _ := runCost(nil); cost == nil || *cost != 0 {
+		t.Error("a run without LLM calls costs nothing")
+	}
+}
@@ -0,0 +1,54 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"simstack/internal/cassette"
+	"simstack/internal/cerebras"
+	"simstack/internal/transport"
+)
+
+// withDebugHook attaches an LLM traffic hook to a debug run's context. Traffic
+// goes to a JSONL file under SIMSTACK_LLM_LOG_DIR when set, otherwise to stderr.
+func (e *Engine) withDebugHook(ctx context.Context) (context.Context, func()) {
+	dir := e.config().LLMLogDir
+	if dir == "" {
+		logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
+		return cerebras.WithHook(ctx, cerebras.SlogHook{Logger: logger}), func() {}
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("llm-%d.jsonl", time.Now().UnixNano()))
+	hook, err := cerebras.OpenJSONLHook(path)
+	if err != nil {
+		slog.WarnContext(ctx, "debug log unavailable", "err", err)
+		return ctx, func() {}
+	}
+	slog.InfoContext(ctx, "logging LLM traffic", "path", path)
+	return cerebras.WithHook(ctx, hook), func() { _ = hook.Close() }
+}
+
+// cassetteTransport wraps the LLM transport with a cassette recorder.
+// LLM_CASSETTE_MODE picks record or replay (the default); in replay mode
+// LLM_CASSETTE_PASSTHROUGH=true lets unmatched requests reach the provider.
+func (e *Engine) cassetteTransport(path string) http.RoundTripper {
+	next := e.llmTransport
+	if next == nil {
+		next = transport.SharedLLM()
+	}
+	cfg := e.config()
+	mode := cfg.CassetteMode
+	rec, err := cassette.New(path, mode, next)
+	if err != nil {
+		slog.Warn("LLM cassette unavailable, calling the provider directly", "err", err)
+		return e.llmTransport
+	}
+	rec.PassThrough = cfg.CassettePassThrough
+	slog.Info("LLM cassette", "path", path, "mode", mode)
+	return rec
+}
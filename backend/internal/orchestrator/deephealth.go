@@ -0,0 +1,136 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"simstack/internal/health"
+	"simstack/internal/llm"
+	"simstack/internal/types"
+)
+
+// deepHealthTimeout bounds each probe when SIMULATOR_HEALTH_TIMEOUT doesn't.
+const deepHealthTimeout = 2 * time.Second
+
+// deepHealthCache holds the latest deep health check. Its lock is held for
+// the whole of a check, so callers arriving meanwhile wait for it and share
+// its answer instead of probing again.
+type deepHealthCache struct {
+	mu   sync.Mutex
+	last *types.DeepHealth
+}
+
+// DeepHealth probes every simulator's health endpoint and the LLM
+// provider's model list, all at once, and classifies the backend from what
+// answered. A check less than SIMSTACK_DEEP_HEALTH_TTL old is answered
+// again instead, so the check can't be used to flood the simulators. The
+// probes don't end with ctx: a caller that hangs up must not leave every
+// dependency cached as down. Anyone may ask, so no URL is given out.
+func (e *Engine) DeepHealth(ctx context.Context) types.DeepHealth {
+	cfg := e.config()
+	e.deepHealth.mu.Lock()
+	defer e.deepHealth.mu.Unlock()
+	if last := e.deepHealth.last; last != nil && e.clock.Now().Sub(last.CheckedAt) < cfg.DeepHealthTTL {
+		out := *last
+		out.Cached = true
+		return out
+	}
+
+	timeout := cfg.HealthTimeout
+	if timeout <= 0 {
+		timeout = deepHealthTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	defer cancel()
+
+	deps := make([]types.DependencyHealth, 0, len(cfg.SimulatorURLs)+1)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for tool, base := range cfg.SimulatorURLs {
+		wg.Add(1)
+		go func(tool, base string) {
+			defer wg.Done()
+			dep := types.DependencyHealth{Name: tool, Kind: "simulator", Status: types.HealthUp}
+			latency, err := health.Probe(ctx, e.simClient, base)
+			dep.LatencyMs = latency.Milliseconds()
+			switch {
+			case err != nil:
+				dep.Status = types.HealthDown
+				dep.Error = withoutURL(err).Error()
+			case cfg.HealthSlow > 0 && latency > cfg.HealthSlow:
+				dep.Status = types.HealthDegraded
+			}
+			mu.Lock()
+			deps = append(deps, dep)
+			mu.Unlock()
+		}(tool, base)
+	}
+	llmHealth := e.llmHealth(ctx)
+	wg.Wait()
+	sort.Slice(deps, func(i, j int) bool { return deps[i].Name < deps[j].Name })
+	deps = append(deps, llmHealth)
+
+	out := types.DeepHealth{Status: classify(deps), CheckedAt: e.clock.Now().UTC(), Dependencies: deps}
+	e.deepHealth.last = &out
+	return out
+}
+
+// llmHealth lists the provider's models, which needs the provider up and
+// the API key accepted. Offline backends and providers that can't list
+// models are left unknown.
+func (e *Engine) llmHealth(ctx context.Context) types.DependencyHealth {
+	dep := types.DependencyHealth{Name: llm.NameOf(e.llm), Kind: "llm", Status: types.HealthUnknown}
+	if e.offline {
+		dep.Error = "offline"
+		return dep
+	}
+	if _, ok := e.llm.(llm.ModelLister); !ok {
+		dep.Error = "provider can't list models"
+		return dep
+	}
+	start := time.Now()
+	_, err := e.refreshModels(ctx)
+	dep.LatencyMs = time.Since(start).Milliseconds()
+	dep.Status = types.HealthUp
+	if err != nil {
+		dep.Status = types.HealthDown
+		dep.Error = withoutURL(err).Error()
+	}
+	return dep
+}
+
+// withoutURL drops the URL an HTTP client error names, leaving what went
+// wrong.
+func withoutURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
+
+// classify is unhealthy when no simulator answers, since no run can then
+// produce results, and degraded when any dependency probed isn't up; a run
+// without the LLM falls back to the built-in heuristics.
+func classify(deps []types.DependencyHealth) types.DeepHealthStatus {
+	out := types.Healthy
+	simulatorUp := false
+	for _, d := range deps {
+		switch {
+		case d.Status == types.HealthUnknown:
+		case d.Status != types.HealthUp:
+			out = types.Degraded
+		}
+		if d.Kind == "simulator" && d.Status != types.HealthDown {
+			simulatorUp = true
+		}
+	}
+	if !simulatorUp {
+		return types.Unhealthy
+	}
+	return out
+}
@@ -0,0 +1,87 @@
+package orchestrator
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"simstack/internal/config"
+	"simstack/internal/testsupport"
+	"simstack/internal/types"
+)
+
+func TestDeepHealth(t *testing.T) {
+	var probes atomic.Int64
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probes.Add(1)
+	}))
+	defer up.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	down.Close()
+
+	cfg, _ := config.Load()
+	cfg.Offline = true
+	cfg.SimulatorURLs = map[string]string{"queue": up.URL, "traffic": down.URL}
+	clk := testsupport.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	e := NewEngine(nil, WithConfig(cfg), WithChatClient(testsupport.NewFakeChat(), "m"), WithClock(clk))
+
+	h := e.DeepHealth(context.Background())
+	if h.Status != types.Degraded || h.Cached || len(h.Dependencies) != 3 {
+		t.Fatalf("expected a degraded backend and three dependencies, got %+v", h)
+	}
+	queue, traffic, llm := h.Dependencies[0], h.Dependencies[1], h.Dependencies[2]
+	if queue.Name != "queue" || queue.Status != types.HealthUp || traffic.Status != types.HealthDown || traffic.Error == "" {
+		t.Errorf("expected queue up and traffic down, got %+v %+v", queue, traffic)
+	}
+	if llm.Kind != "llm" || llm.Status != types.HealthUnknown {
+		t.Errorf("expected the LLM left unprobed offline, got %+v", llm)
+	}
+
+	if strings.Contains(traffic.Error, down.URL) {
+		t.Errorf("expected no simulator URL given out, got %q", traffic.Error)
+	}
+
+	if again := e.DeepHealth(context.Background()); !again.Cached || probes.Load() != 1 {
+		t.Errorf("expected the check answered from cache, got %+v after %d probes", again, probes.Load())
+	}
+	clk.Advance(cfg.DeepHealthTTL)
+	if again := e.DeepHealth(context.Background()); again.Cached || probes.Load() != 2 {
+		t.Errorf("expected a stale check probed again, got %+v after %d probes", again, probes.Load())
+	}
+
+	// A caller gone before the probes ran doesn't leave the queue cached down
+	clk.Advance(cfg.DeepHealthTTL)
+	gone, cancel := context.WithCancel(context.Background())
+	cancel()
+	if again := e.DeepHealth(gone); again.Dependencies[0].Status != types.HealthUp || probes.Load() != 3 {
+		t.Errorf("expected the queue probed up despite the caller leaving, got %+v", again.Dependencies[0])
+	}
+}
+
+func TestClassify(t *testing.T) {
+	sim := func(status types.HealthStatus) types.DependencyHealth {
+		return types.DependencyHealth{Kind: "simulator", Status: status}
+	}
+	llm := func(status types.HealthStatus) types.DependencyHealth {
+		return types.DependencyHealth{Kind: "llm", Status: status}
+	}
+	for _, tc := range []struct {
+		deps []types.DependencyHealth
+		want types.DeepHealthStatus
+	}{
+		{[]types.DependencyHealth{sim(types.HealthUp), llm(types.HealthUp)}, types.Healthy},
+		{[]types.DependencyHealth{sim(types.HealthUp), llm(types.HealthUnknown)}, types.Healthy},
+		{[]types.DependencyHealth{sim(types.HealthDegraded), llm(types.HealthUp)}, types.Degraded},
+		{[]types.DependencyHealth{sim(types.HealthUp), llm(types.HealthDown)}, types.Degraded},
+		{[]types.DependencyHealth{sim(types.HealthDown), sim(types.HealthDown), llm(types.HealthUp)}, types.Unhealthy},
+		{[]types.DependencyHealth{llm(types.HealthUp)}, types.Unhealthy},
+	} {
+		if got := classify(tc.deps); got != tc.want {
+			t.Errorf("%+v: expected %s, got %s", tc.deps, tc.want, got)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package orchestrator
+
+import (
+	"simstack/internal/config"
+	"simstack/internal/metrics"
+	"simstack/internal/types"
+)
+
+// scoreMetric names the heuristic score's distribution, charted alongside
+// the metrics.
+const scoreMetric = "score"
+
+// distributions charts how the heuristic score and the metrics the most
+// variants report, up to cfg's DistributionMetrics of them, spread across
+// results. Failed results are left out.
+func (e *Engine) distributions(cfg *config.Config, results []types.SimulationResult) []types.MetricDistribution {
+	if cfg.DistributionMetrics == 0 {
+		return nil
+	}
+	samples := map[string][]metrics.Sample{}
+	var scores []metrics.Sample
+	for _, r := range results {
+		if r.Status == types.ResultFailed || len(r.Metrics) == 0 {
+			continue
+		}
+		for name, v := range r.Metrics {
+			samples[name] = append(samples[name], metrics.Sample{VariantID: r.VariantID, Value: v})
+		}
+		scores = append(scores, metrics.Sample{VariantID: r.VariantID, Value: e.heuristicScore(r)})
+	}
+	if len(scores) == 0 {
+		return nil
+	}
+
+	score := metrics.Distribution(scoreMetric, scores, cfg.DistributionBuckets)
+	score.Direction = types.HigherIsBetter
+	out := []types.MetricDistribution{score}
+	for _, name := range metrics.TopMetrics(samples, cfg.DistributionMetrics) {
+		d := metrics.Distribution(name, samples[name], cfg.DistributionBuckets)
+		def, _ := e.metrics.Lookup(name)
+		d.Unit, d.Direction = def.Unit, def.Direction
+		out = append(out, d)
+	}
+	return out
+}
@@ -0,0 +1,67 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"simstack/internal/config"
+	"simstack/internal/eventbus"
+	"simstack/internal/testsupport"
+	"simstack/internal/types"
+)
+
+func TestRunEmitsMetricDistributions(t *testing.T) {
+	sim := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"metrics": {"utilization": 0.5, "avg_wait_time_min": 3}}`)
+	}))
+	defer sim.Close()
+	cfg, _ := config.Load()
+	cfg.SimulatorURLs = map[string]string{"queue": sim.URL, "traffic": sim.URL, "resource": sim.URL}
+	cfg.DistributionMetrics = 2
+	rec := &recorder{}
+	e := NewEngine(eventbus.Direct(rec.emit), WithConfig(cfg), WithChatClient(testsupport.NewFakeChat(), "m"))
+	if err := e.Run(context.Background(), types.RunRequest{Goal: "g", Offline: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	events := rec.ofType(types.EventDistribution)
+	if len(events) != 3 {
+		t.Fatalf("expected the score and two metrics charted, got %d events", len(events))
+	}
+	score := events[0].Payload.(types.MetricDistribution)
+	if score.Metric != scoreMetric || score.Count != 16 || len(score.Variants) != 16 || score.Direction != types.HigherIsBetter {
+		t.Errorf("unexpected score distribution %+v", score)
+	}
+	wait := events[1].Payload.(types.MetricDistribution)
+	if wait.Metric != "queue_avg_wait_time_min" || wait.Unit != "min" || wait.Direction != types.LowerIsBetter {
+		t.Errorf("expected the wait time charted with its catalog definition, got %+v", wait)
+	}
+	// Every variant waited 3 minutes
+	if len(wait.Buckets) != 1 || wait.Buckets[0].Count != 16 || wait.Median != 3 {
+		t.Errorf("expected one bucket holding every variant, got %+v", wait.Buckets)
+	}
+	for _, ev := range events {
+		if ev.RunID == "" || ev.PlanID == "" {
+			t.Errorf("expected distribution events tagged with their run, got %+v", ev)
+		}
+	}
+
+	runID := rec.ofType(types.EventDone)[0].Payload.(types.DoneEvent).RunID
+	var summary *types.ResultsSummary
+	err := e.StreamResults(context.Background(), runID, false, func(l types.ResultsLine) error {
+		if l.Summary != nil {
+			summary = l.Summary
+		}
+		return nil
+	})
+	if err != nil || summary == nil || len(summary.Distributions) != 3 || summary.Distributions[1].Metric != wait.Metric {
+		t.Errorf("expected the distributions in the results summary, got %+v (%v)", summary, err)
+	}
+	results, err := e.RunResults(context.Background(), runID)
+	if err != nil || len(results.Distributions) != 3 || results.Distributions[1].Metric != wait.Metric {
+		t.Errorf("expected the distributions in the run's results, got %+v (%v)", results.Distributions, err)
+	}
+}
@@ -0,0 +1,81 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrShuttingDown is returned by runs asked for once Drain has begun.
+var ErrShuttingDown = errors.New("shutting down")
+
+// How long Drain waits, past its deadline, for the runs it canceled to save
+// their records
+const drainCancelWait = 5 * time.Second
+
+// drain tracks the runs in flight so that Drain can wait for them.
+type drain struct {
+	mu       sync.Mutex
+	draining atomic.Bool
+	runs     sync.WaitGroup
+}
+
+// enter counts a run starting, or refuses it with ErrShuttingDown once
+// draining; a run that entered calls the returned func when it ends.
+func (d *drain) enter() (func(), error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.draining.Load() {
+		return nil, ErrShuttingDown
+	}
+	d.runs.Add(1)
+	return d.runs.Done, nil
+}
+
+// Draining reports whether Drain has begun.
+func (e *Engine) Draining() bool {
+	return e.drain.draining.Load()
+}
+
+// InFlight returns how many runs are in flight.
+func (e *Engine) InFlight() int {
+	n := 0
+	e.active.Range(func(_, _ any) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// Drain stops the engine starting runs, and the runs in flight starting
+// their variants, then waits for those runs to finish. Once ctx is done it
+// cancels the runs still going, which are saved canceled with the variants
+// that finished, and returns ctx's error after giving them a moment to
+// save.
+func (e *Engine) Drain(ctx context.Context) error {
+	e.drain.mu.Lock()
+	e.drain.draining.Store(true)
+	e.drain.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		e.drain.runs.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+	e.active.Range(func(_, cancel any) bool {
+		cancel.(context.CancelFunc)()
+		return true
+	})
+	select {
+	case <-done:
+	case <-time.After(drainCancelWait):
+	}
+	return ctx.Err()
+}
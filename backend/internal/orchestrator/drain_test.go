@@ -0,0 +1,98 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"simstack/internal/config"
+	"simstack/internal/runstore"
+	"simstack/internal/testsupport"
+	"simstack/internal/types"
+)
+
+// drainEngine returns an engine whose queue simulator holds every call
+// until release is closed or the call is abandoned, and a channel that
+// gets a value as each call arrives.
+func drainEngine(t *testing.T, store runstore.RunStore, release chan struct{}) (*Engine, chan struct{}) {
+	t.Helper()
+	calls := make(chan struct{}, 16)
+	sim := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls <- struct{}{}
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			return
+		}
+		fmt.Fprint(w, `{"metrics": {"utilization": 0.5}}`)
+	}))
+	t.Cleanup(sim.Close)
+	cfg, _ := config.Load()
+	cfg.SimulatorURLs = map[string]string{"queue": sim.URL}
+	cfg.SimulatorWarmup = false
+	return NewEngine(nil, WithConfig(cfg), WithChatClient(testsupport.NewFakeChat(), "m"), WithRunStore(store)), calls
+}
+
+func TestDrainWaitsForRunsInFlight(t *testing.T) {
+	store := runstore.NewMemory()
+	release := make(chan struct{})
+	e, calls := drainEngine(t, store, release)
+	ran := make(chan error, 1)
+	go func() {
+		ran <- e.RunWithID(context.Background(), "run-1", types.RunRequest{Goal: "reduce wait", Offline: true})
+	}()
+	<-calls
+
+	drained := make(chan error, 1)
+	go func() { drained <- e.Drain(context.Background()) }()
+	for !e.Draining() {
+		time.Sleep(time.Millisecond)
+	}
+	if err := e.Run(context.Background(), types.RunRequest{Goal: "g", Offline: true}); !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("expected new runs refused while draining, got %v", err)
+	}
+	select {
+	case err := <-drained:
+		t.Fatalf("expected Drain to wait for the run, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-ran; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-drained; err != nil {
+		t.Errorf("expected a clean drain, got %v", err)
+	}
+	if run, err := store.Get(context.Background(), "run-1"); err != nil || run.Status != "completed" {
+		t.Errorf("expected the run to finish, got %+v %v", run, err)
+	}
+}
+
+func TestDrainCancelsRunsPastDeadline(t *testing.T) {
+	store := runstore.NewMemory()
+	release := make(chan struct{})
+	defer close(release)
+	e, calls := drainEngine(t, store, release)
+	ran := make(chan error, 1)
+	go func() {
+		ran <- e.RunWithID(context.Background(), "run-1", types.RunRequest{Goal: "reduce wait", Offline: true})
+	}()
+	<-calls
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := e.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline reported, got %v", err)
+	}
+	if err := <-ran; !errors.Is(err, ErrRunCanceled) {
+		t.Errorf("expected the run canceled, got %v", err)
+	}
+	if run, err := store.Get(context.Background(), "run-1"); err != nil || run.Status != outcomeCanceled {
+		t.Errorf("expected the run saved canceled, got %+v %v", run, err)
+	}
+}
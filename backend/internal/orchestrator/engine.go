@@ -1,76 +1,699 @@
 package orchestrator
 
 import (
-	"bytes"
+	"cmp"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"log"
+	"log/slog"
 	"math"
 	"net/http"
-	"os"
+	"net/url"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"simstack/internal/artifacts"
 	"simstack/internal/cerebras"
+	"simstack/internal/clock"
+	"simstack/internal/config"
+	"simstack/internal/eventbus"
+	"simstack/internal/health"
+	"simstack/internal/llm"
+	"simstack/internal/logging"
+	"simstack/internal/metrics"
+	"simstack/internal/notify"
+	"simstack/internal/pricing"
+	"simstack/internal/runstore"
+	"simstack/internal/simcache"
+	"simstack/internal/simstats"
+	"simstack/internal/tracing"
+	"simstack/internal/transport"
 	"simstack/internal/types"
+	"simstack/internal/version"
+	"simstack/simsign"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Completion caps: a variant is ~60 tokens of JSON, so the planner budget fits
+// a generous variant list; the critic only needs a short structured verdict.
+const (
+	plannerMaxTokens = 1536
+	criticMaxTokens  = 768
 )
 
 type Engine struct {
-	emit             func(v any)
-	cereClient       *cerebras.Client
-	plannerLatencyMs int64
-	simStartupMs     int64
-	tokensPerSec     float64
+	emit func(v any)
+	// The active configuration, replaced whole by Reload; read it through
+	// config() once per run or call so one sees a consistent snapshot
+	cfg          atomic.Pointer[config.Config]
+	reloadMu     sync.Mutex
+	llm          llm.ChatClient
+	model        string
+	chains       map[string]llm.ModelChain
+	modelTimeout time.Duration
+	// Each call site's configured model, and the phases' default
+	// temperatures
+	phaseModels map[string]string
+	plannerTemp float64
+	criticTemp  float64
+	// Figures of the latest completed run, replaced whole as each run
+	// completes so that overlapping runs never mix theirs
+	latest atomic.Pointer[runFigures]
+	// Tells the time and times out the run's waits; clock.Real outside tests
+	clock clock.Clock
+
+	// Ask for schema-constrained JSON via response_format
+	structuredOutput bool
+
+	// Models runs may select via RunRequest.Model; empty allows any listed model
+	allowedModels []string
+
+	// Never contact the LLM (SIMSTACK_OFFLINE); runs can also opt in singly
+	offline bool
+
+	// Keep sanitized prompt text in audit records (SIMSTACK_AUDIT_PROMPTS);
+	// auditSecrets are known credentials scrubbed from anything retained
+	auditPrompts bool
+	auditSecrets []string
+
+	// Follow-up calls allowed to finish a plan cut off by max_tokens
+	maxContinuations int
+
+	// Default per-run LLM budget (zero = unlimited)
+	timeBudget  time.Duration
+	tokenBudget int
+
+	// Shared across all simulator calls; timeouts come from each call's context
+	simClient    *http.Client
+	metricNames  prefixedNames
+	llmTransport http.RoundTripper
+
+	store    runstore.RunStore
+	embedder llm.Embedder
+	// The memory-resident runs in front of store (store itself when that is
+	// the in-memory store), trimmed as runs finish
+	registry *runstore.Memory
+	// Serializes changes to runs' annotations with the saves of runs in
+	// flight, which would otherwise drop notes added meanwhile
+	annotationsMu sync.Mutex
+
+	// Provider model list from CheckModel, served by /api/models
+	modelsMu sync.RWMutex
+	models   []cerebras.ModelInfo
+
+	// Which way each metric improves; simulators may add their own
+	metrics *metrics.Catalog
+	// Performance records of recent runs, served by /metrics
+	history *metrics.History
+	// Run lifecycle counters since startup, also pushed to sink if set
+	counters *metrics.Counters
+	sink     metrics.Sink
+	// Per-simulator latency, errors and circuit breakers
+	simStats *simstats.Tracker
+	// The instance each simulator's calls go to, by tool, once they have
+	// failed over to a backup
+	routes sync.Map
+	// Simulator responses by call, across runs
+	simCache *simcache.Cache
+	// Posts finished runs to chat webhooks
+	notifier *notify.Notifier
+	// Narratives comparing two finished runs, by pair
+	narratives narrativeCache
+	// Background health probes of the configured simulators
+	health *health.Poller
+	// The latest on-demand probe of every dependency
+	deepHealth deepHealthCache
+
+	// Spans for each run, its phases and every outgoing call
+	tracer trace.Tracer
+
+	// Prices for estimating what each LLM call cost
+	pricing pricing.Table
+
+	// Raw simulator responses kept as artifacts (SIMSTACK_CAPTURE_ARTIFACTS)
+	artifacts        artifacts.Store
+	captureArtifacts bool
+
+	// Cancel funcs of runs in flight by ID; their artifacts must not be
+	// collected
+	active sync.Map
+	// *liveRun of runs in flight by ID, and for a grace period after, for
+	// results followers
+	live sync.Map
+	// The shared store's completion feed, nil when the store has none, and
+	// the results followers waiting on it for runs of other replicas
+	completer runstore.Completer
+	finished  finishedRuns
+	// Runs in flight, for Drain
+	drain drain
+}
+
+// Option customizes an Engine at construction.
+type Option func(*Engine)
+
+// WithConfig builds the engine from cfg, typically the validated result of
+// config.Load, instead of reading the environment.
+func WithConfig(cfg config.Config) Option {
+	return func(e *Engine) {
+		e.cfg.Store(&cfg)
+	}
+}
+
+// WithSimulatorTransport overrides the shared simulator transport.
+func WithSimulatorTransport(rt http.RoundTripper) Option {
+	return func(e *Engine) {
+		e.simClient = &http.Client{Transport: rt}
+	}
+}
+
+// WithLLMTransport overrides the shared LLM transport for the env-configured provider.
+func WithLLMTransport(rt http.RoundTripper) Option {
+	return func(e *Engine) {
+		e.llmTransport = rt
+	}
+}
+
+// WithRunStore records runs in store instead of a fresh in-memory store.
+func WithRunStore(store runstore.RunStore) Option {
+	return func(e *Engine) {
+		e.store = store
+	}
+}
+
+// WithMetricCatalog replaces the shared metric catalog.
+func WithMetricCatalog(c *metrics.Catalog) Option {
+	return func(e *Engine) {
+		e.metrics = c
+	}
+}
+
+// WithMetricsSink pushes instrumentation to sink as it happens, e.g. a
+// StatsD client. Given more than once, every sink gets it.
+func WithMetricsSink(sink metrics.Sink) Option {
+	return func(e *Engine) {
+		switch prev := e.sink.(type) {
+		case nil:
+			e.sink = sink
+		case metrics.Sinks:
+			e.sink = append(prev, sink)
+		default:
+			e.sink = metrics.Sinks{prev, sink}
+		}
+	}
+}
+
+// WithArtifactStore keeps run artifacts in store instead of a bounded
+// in-memory store.
+func WithArtifactStore(store artifacts.Store) Option {
+	return func(e *Engine) {
+		e.artifacts = store
+	}
+}
+
+// WithTracerProvider traces runs with tp instead of the global provider.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(e *Engine) {
+		e.tracer = tp.Tracer(tracing.Name)
+	}
+}
+
+// WithClock runs the engine's timings, deadlines and timestamps on c
+// instead of the wall clock.
+func WithClock(c clock.Clock) Option {
+	return func(e *Engine) {
+		e.clock = c
+	}
+}
+
+// WithChatClient replaces the env-configured LLM provider, e.g. with a fake in tests.
+func WithChatClient(c llm.ChatClient, model string) Option {
+	return func(e *Engine) {
+		e.llm = c
+		e.model = model
+	}
+}
+
+// unavailableLLM stands in for a provider that couldn't be built, failing
+// any call that slips past the offline engine with why.
+type unavailableLLM struct {
+	name string
+	err  error
 }
 
-func NewEngine(emitter func(v any)) *Engine {
-	return &Engine{
-		emit:       emitter,
-		cereClient: cerebras.New(),
+func (u unavailableLLM) Name() string { return u.name }
+
+func (u unavailableLLM) Chat(context.Context, cerebras.OpenAIChatRequest) (map[string]any, error) {
+	return nil, u.err
+}
+
+// NewEngine builds an engine publishing its events to bus, from the
+// configuration given with WithConfig. Without it the environment is read
+// here, unvalidated. A nil bus discards the events. When the configuration
+// names an LLM provider that can't be built, which config.Validate refuses,
+// the engine logs why and runs offline; no other provider is quietly used
+// instead.
+func NewEngine(bus *eventbus.Bus, opts ...Option) *Engine {
+	e := &Engine{emit: bus.Publish, clock: clock.Real}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if e.cfg.Load() == nil {
+		cfg, err := config.Load()
+		if err != nil {
+			slog.Warn("configuration invalid", "err", err)
+		}
+		e.cfg.Store(&cfg)
+	}
+	cfg := *e.config()
+
+	if e.metrics == nil {
+		e.metrics = metrics.Default()
+	}
+	e.history = metrics.NewHistory(cfg.MetricsHistory)
+	e.counters = metrics.NewCounters()
+	if e.sink != nil {
+		e.counters.SetSink(e.sink)
+	}
+	e.simStats = simstats.NewTracker(cfg.BreakerThreshold, cfg.BreakerCooldown)
+	e.simCache = simcache.New(cfg.SimulatorCacheTTL, cfg.SimulatorCacheMaxEntries)
+	e.notifier = notify.New(&http.Client{})
+	if e.tracer == nil {
+		e.tracer = otel.GetTracerProvider().Tracer(tracing.Name)
+	}
+	if e.simClient == nil {
+		e.simClient = &http.Client{Transport: transport.NewSimulator(cfg.SimulatorMaxIdleConns)}
+	}
+	// Wrap a copy: the client may have been handed in, and its owner keeps
+	// its transport as given.
+	sim := *e.simClient
+	sim.Transport = transport.UserAgent(tracing.Transport(sim.Transport), version.UserAgent())
+	e.simClient = &sim
+	e.health = health.New(e.simClient, func() map[string]string { return e.config().SimulatorURLs }, health.Options{
+		Interval:   cfg.HealthInterval,
+		Timeout:    cfg.HealthTimeout,
+		Slow:       cfg.HealthSlow,
+		DownAfter:  cfg.HealthDownAfter,
+		MaxBackoff: cfg.HealthMaxBackoff,
+	}, func(ev types.SimulatorStatusEvent) {
+		slog.Info("simulator health changed", "tool", ev.Tool, "status", ev.Status, "previous", ev.Previous)
+		e.emit(types.NewEventAt(e.clock.Now(), types.EventSimulatorStatus, ev))
+		if ev.Status == types.HealthUp {
+			e.primaryRecovered(ev.Tool, ev.URL)
+		}
+	})
+
+	e.structuredOutput = cfg.StructuredOutput
+	e.offline = cfg.Offline
+	e.auditPrompts = cfg.AuditPrompts
+	e.auditSecrets = config.Secrets(cfg)
+	if cfg.Cassette != "" {
+		e.llmTransport = e.cassetteTransport(cfg.Cassette)
 	}
+	if e.llm == nil {
+		llmCfg := cfg.LLM
+		llmCfg.Transport = e.llmTransport
+		if llmCfg.Transport == nil {
+			llmCfg.Transport = transport.SharedLLM()
+		}
+		llmCfg.Transport = transport.UserAgent(tracing.Transport(llmCfg.Transport), version.UserAgent())
+		llmCfg.Offline = e.offline
+		if provider, err := llm.New(llmCfg); err != nil {
+			slog.Error("LLM provider unavailable, running offline", "provider", llmCfg.Provider, "err", err)
+			e.offline = true
+			e.llm = unavailableLLM{name: llmCfg.Provider, err: err}
+			e.model = llmCfg.Model
+		} else {
+			slog.Info("LLM provider", "provider", provider.Name(), "model", llmCfg.Model)
+			e.llm = provider
+			e.model = llmCfg.Model
+			e.embedder = llm.NewEmbedder(provider, llmCfg.EmbeddingModel)
+		}
+	}
+	if e.embedder == nil || e.offline {
+		e.embedder = llm.TrigramEmbedder{}
+	}
+	switch store := e.store.(type) {
+	case nil:
+		e.registry = runstore.NewMemory()
+	case *runstore.Memory:
+		e.registry = store
+	default:
+		e.registry = runstore.NewCache(store)
+		e.completer, _ = store.(runstore.Completer)
+	}
+	e.registry.SetRetention(runstore.Retention{MaxAge: cfg.RunRetention, MaxRuns: cfg.RunMaxResident})
+	e.store = e.registry
+	if e.artifacts == nil {
+		e.artifacts = artifacts.NewMemory(cfg.ArtifactMemoryBytes)
+	}
+	if s, ok := e.artifacts.(interface{ SetActive(func(string) bool) }); ok {
+		s.SetActive(e.runActive)
+	}
+	e.captureArtifacts = cfg.CaptureArtifacts
+
+	// Each call site gets an ordered model chain; LLM_PLAN_MODEL_CHAIN and
+	// LLM_ANALYSIS_MODEL_CHAIN override the phase's own model, which
+	// overrides the shared LLM_MODEL_CHAIN
+	e.modelTimeout = cfg.ModelTimeout
+	sharedChain := cfg.ModelChain
+	if sharedChain == "" {
+		sharedChain = e.model
+	}
+	chainFor := func(spec, model string) llm.ModelChain {
+		if spec == "" {
+			spec = cmp.Or(model, sharedChain)
+		}
+		return llm.ParseModelChain(spec, e.modelTimeout)
+	}
+	plannerModel, criticModel := cmp.Or(cfg.PlannerModel, e.model), cmp.Or(cfg.CriticModel, e.model)
+	e.chains = map[string]llm.ModelChain{
+		"plan":     chainFor(cfg.PlanModelChain, cfg.PlannerModel),
+		"analysis": chainFor(cfg.AnalysisModelChain, cfg.CriticModel),
+		// Run comparisons are the critic's work too
+		"narrative": chainFor(cfg.AnalysisModelChain, cfg.CriticModel),
+	}
+	e.phaseModels = map[string]string{"plan": plannerModel, "analysis": criticModel, "narrative": criticModel}
+	e.plannerTemp, e.criticTemp = cfg.PlannerTemperature, cfg.CriticTemperature
+	e.allowedModels = cfg.AllowedModels
+	e.timeBudget = cfg.TimeBudget
+	e.tokenBudget = cfg.TokenBudget
+	e.maxContinuations = cfg.MaxContinuations
+	e.pricing = cfg.Pricing
+	if e.pricing == nil {
+		e.pricing = pricing.Default()
+	}
+	for _, model := range e.configuredModels() {
+		if _, ok := e.pricing.Lookup(model); !ok && !e.offline {
+			slog.Warn("model has no price (LLM_PRICING); run costs will be reported as unknown", "model", model)
+		}
+	}
+	return e
+}
+
+// NewRunID returns a fresh run ID, for callers that need it before the run
+// starts; see runstore.NewRunID.
+func NewRunID() string {
+	return runstore.NewRunID()
 }
 
+// randomHex returns n random bytes in hex.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Run executes req under a new run ID.
 func (e *Engine) Run(ctx context.Context, req types.RunRequest) error {
-	start := time.Now()
-	plan := e.plan(ctx, req)
-	e.plannerLatencyMs = time.Since(start).Milliseconds()
+	return e.RunWithID(ctx, NewRunID(), req)
+}
 
-	e.emit(types.WSEvent{Type: "plan", Payload: plan, Timestamp: time.Now().UTC().Format(time.RFC3339Nano)})
+// RunWithID executes req as run id. Cancel stops it early.
+func (e *Engine) RunWithID(ctx context.Context, id string, req types.RunRequest) error {
+	return e.execute(ctx, id, req, nil)
+}
 
-	// Spawn simulators for each variant in parallel
-	simStart := time.Now()
-	results := e.runSimulators(ctx, plan)
-	e.simStartupMs = time.Since(simStart).Milliseconds()
+// execute runs req as run id, planning it unless replay supplies the plan.
+func (e *Engine) execute(ctx context.Context, id string, req types.RunRequest, replay *Replay) error {
+	leave, err := e.drain.enter()
+	if err != nil {
+		return err
+	}
+	defer leave()
+	runStart := e.clock.Now()
+	var timings types.PhaseTimings
+	ctx, cancel := context.WithCancel(logging.With(ctx, "run_id", id))
+	defer cancel()
+	if req.Debug {
+		var closeLog func()
+		ctx, closeLog = e.withDebugHook(ctx)
+		defer closeLog()
+	}
+	ctx = withBudget(ctx, e.runBudget(req))
+	if req.NoCache {
+		ctx = withNoCache(ctx)
+	}
+	if req.OrderedResults {
+		ctx = withOrderedResults(ctx)
+	}
+	offline := e.offline || req.Offline
+	if offline {
+		// Marks the context so the client refuses any call that slips through
+		ctx = cerebras.WithOffline(ctx)
+	}
 
-	// Emit results as they complete
+	manifest := &types.RunManifest{
+		Goal:               req.Goal,
+		Model:              e.modelFor(req),
+		PlannerModel:       e.phaseModelFor("plan", req),
+		CriticModel:        e.phaseModelFor("analysis", req),
+		PlannerTemperature: e.plannerTemperature(req),
+		CriticTemperature:  e.criticTemperature(req),
+		Offline:            offline,
+		Reproducible:       req.Reproducible,
+		Seed:               runSeed(req),
+	}
+	run := types.RunRecord{
+		ID:        id,
+		Goal:      req.Goal,
+		Status:    "running",
+		StartedAt: e.clock.Now().UTC(),
+	}
+	e.active.Store(run.ID, context.CancelFunc(cancel))
+	defer e.active.Delete(run.ID)
+	live := newLiveRun()
+	e.live.Store(run.ID, live)
+	// After the final save, or once the watchdog has failed the run
+	endLive := sync.OnceFunc(func() {
+		live.finish()
+		e.releaseLive(run.ID, live)
+	})
+	defer endLive()
+	e.saveRun(ctx, run)
+	e.counters.RunStarted()
+	started := run
+	release := releaseFrom(ctx)
+	watchdog := newRunWatchdog(e.clock, e.config().RunStallTimeout, func(w *runWatchdog, idle time.Duration) {
+		e.failStalled(started, w, live, cancel, endLive, release, idle)
+	})
+	ctx = withWatchdog(ctx, watchdog)
+	ctx = withRunID(ctx, run.ID)
+	events := e.newRunEvents(ctx, run.ID)
+	events.watchdog = watchdog
+	ctx = withRunEvents(ctx, events)
+	manifest.RunID = run.ID
+
+	ctx, span := e.tracer.Start(ctx, "run", trace.WithAttributes(
+		attribute.String("simstack.run_id", run.ID),
+		tracing.GoalHash(req.Goal),
+		attribute.Bool("simstack.offline", offline),
+	))
+	defer span.End()
+
+	var plan types.SimulationPlan
+	if replay != nil {
+		plan = replay.Plan
+		manifest.ReplayOf = replay.ReplayOf
+		span.SetAttributes(attribute.String("simstack.replay_of", replay.ReplayOf))
+	} else {
+		phaseStart := e.clock.Now()
+		planCtx, planSpan := e.tracer.Start(ctx, "plan")
+		plan = e.plan(planCtx, req, manifest)
+		planSpan.SetAttributes(
+			attribute.String("simstack.plan_id", plan.PlanID),
+			attribute.Int("simstack.variant_count", len(plan.Variants)),
+			attribute.Bool("simstack.llm", plan.LLM),
+		)
+		planSpan.End()
+		if !plan.LLM && !offline {
+			e.counters.PlanningFellBack()
+		}
+		timings.PlannerMs = e.msSince(phaseStart)
+		e.counters.PlannerLatency(e.clock.Now().Sub(phaseStart))
+	}
+	span.SetAttributes(attribute.Int("simstack.variant_count", len(plan.Variants)))
+	manifest.PlanID = plan.PlanID
+
+	events.planID = plan.PlanID
+	events.send(types.EventPlan, plan)
+	live.setPlan(plan)
+
+	// Warm the simulators up, then spawn them for each variant in parallel;
+	// both see the same configuration
+	cfg := e.config()
+	// A run still planning when shutdown began starts no variants; it ends
+	// canceled like any other run cut short
+	if e.Draining() {
+		cancel()
+		timings.TotalMs = e.msSince(runStart)
+		manifest.PhaseTimings = timings
+		return e.finishCanceled(ctx, cfg, req, watchdog, run, plan, nil, manifest)
+	}
+	results, warmup := e.runSimulators(ctx, cfg, plan, &timings)
+	latency := e.recordTimings(results, warmup, manifest)
 	for _, r := range results {
-		e.emit(types.WSEvent{Type: "result", Payload: r, Timestamp: time.Now().UTC().Format(time.RFC3339Nano)})
+		manifest.Artifacts = append(manifest.Artifacts, r.Artifacts...)
+	}
+	if errors.Is(ctx.Err(), context.Canceled) {
+		timings.TotalMs = e.msSince(runStart)
+		manifest.PhaseTimings = timings
+		manifest.SimulationMs = timings.SimulationPhaseMs
+		return e.finishCanceled(ctx, cfg, req, watchdog, run, plan, results, manifest)
 	}
 
-	// Run Critic Agent to analyze results and provide recommendations
-	critStart := time.Now()
-	analysis := e.analyzeResults(ctx, req, results)
-	log.Printf("Critic analysis completed in %dms", time.Since(critStart).Milliseconds())
+	// Emit results as they complete, unless they went out in variant order
+	// during the simulations
+	if !req.OrderedResults {
+		for _, r := range results {
+			events.send(types.EventResult, r)
+		}
+	}
+	dists := e.distributions(cfg, results)
+	for _, d := range dists {
+		events.send(types.EventDistribution, d)
+	}
 
-	e.emit(types.WSEvent{Type: "analysis", Payload: analysis, Timestamp: time.Now().UTC().Format(time.RFC3339Nano)})
+	// Run Critic Agent to analyze results and provide recommendations
+	live.setPhase(types.PhaseAnalyzing)
+	phaseStart := e.clock.Now()
+	analysisCtx, analysisSpan := e.tracer.Start(ctx, "analysis")
+	analysis := e.analyzeResults(analysisCtx, req, results, manifest)
+	if winner, ok := analysis["winner"].(string); ok {
+		analysisSpan.SetAttributes(attribute.String("simstack.winner", winner))
+	}
+	analysisSpan.End()
+	timings.AnalysisMs = e.msSince(phaseStart)
+	slog.InfoContext(ctx, "critic analysis completed", "analysis_ms", timings.AnalysisMs)
+
+	if winner, ok := analysis["winner"].(string); ok {
+		for _, r := range results {
+			if r.VariantID == winner && r.DurationMs > 0 {
+				analysis["winner_duration_ms"] = r.DurationMs
+			}
+		}
+	}
+	manifest.CostUSD, manifest.UnpricedCalls = runCost(manifest.LLMCalls)
+	manifest.PhaseUsage = phaseUsage(manifest)
+	if manifest.CostUSD != nil {
+		analysis["cost_usd"] = *manifest.CostUSD
+	}
+	events.send(types.EventAnalysis, analysisEvent(analysis))
+	llmAssisted, _ := analysis["llm"].(bool)
+	manifest.LLM = plan.LLM || llmAssisted
+	timings.TotalMs = e.msSince(runStart)
+	manifest.PhaseTimings = timings
+	manifest.SimulationMs = timings.SimulationPhaseMs
+	tokensPerSec := plannerTokensPerSec(manifest.LLMCalls)
+	e.counters.PlannerThroughput(tokensPerSec)
+	e.latest.Store(&runFigures{
+		phases:       timings,
+		tokensPerSec: tokensPerSec,
+		simLatencyMs: latency,
+		simWarmupMs:  manifest.SimulatorStartupMs,
+	})
+	events.send(types.EventManifest, *manifest)
 
-	e.emit(types.WSEvent{Type: "done", Payload: map[string]string{"plan_id": plan.PlanID}, Timestamp: time.Now().UTC().Format(time.RFC3339Nano)})
+	if !watchdog.finish() {
+		return ErrRunStalled
+	}
+	finished := e.clock.Now().UTC()
+	run.Status = "completed"
+	run.FinishedAt = &finished
+	run.PlanID = plan.PlanID
+	run.Plan = &plan
+	run.Results = results
+	run.Analysis = analysis
+	run.Manifest = manifest
+	if winner, ok := analysis["winner"].(string); ok {
+		run.Winner = winner
+	}
+	e.indexGoal(ctx, &run)
+	e.saveRun(ctx, run)
+	e.history.Record(runMetrics(run, results, types.RunMetrics{
+		PhaseTimings: timings,
+		SimulationMs: timings.SimulationPhaseMs,
+		Offline:      offline,
+	}))
+
+	outcome := runOutcome(ctx, results)
+	e.counters.RunFinished(outcome)
+	e.notify(cfg, req, run, outcome, dists)
+
+	events.send(types.EventDone, types.DoneEvent{PlanID: plan.PlanID, RunID: run.ID, LLM: manifest.LLM, Offline: offline, ReplayOf: manifest.ReplayOf})
 	return nil
 }
 
-func (e *Engine) plan(parentCtx context.Context, req types.RunRequest) types.SimulationPlan {
+// finishCanceled ends a run canceled before its analysis: it saves the run
+// canceled, keeping the results of the variants that finished, and tells
+// the run's watchers.
+func (e *Engine) finishCanceled(ctx context.Context, cfg *config.Config, req types.RunRequest, watchdog *runWatchdog, run types.RunRecord, plan types.SimulationPlan, results []types.SimulationResult, manifest *types.RunManifest) error {
+	if !watchdog.finish() {
+		return ErrRunStalled
+	}
+	manifest.CostUSD, manifest.UnpricedCalls = runCost(manifest.LLMCalls)
+	manifest.PhaseUsage = phaseUsage(manifest)
+	finished := e.clock.Now().UTC()
+	run.Status = outcomeCanceled
+	run.FinishedAt = &finished
+	run.PlanID = plan.PlanID
+	run.Plan = &plan
+	run.Results = results
+	run.Manifest = manifest
+	// The run's context is done; the save must not be
+	e.saveRun(context.WithoutCancel(ctx), run)
+	e.counters.RunFinished(outcomeCanceled)
+	e.notify(cfg, req, run, outcomeCanceled, nil)
+
+	e.eventsFrom(ctx).send(types.EventCancelled, types.CancelledEvent{PlanID: plan.PlanID, RunID: run.ID, Completed: len(results)})
+	return ErrRunCanceled
+}
+
+// msSince returns the milliseconds from start to now on the engine's clock.
+func (e *Engine) msSince(start time.Time) int64 {
+	return e.clock.Now().Sub(start).Milliseconds()
+}
+
+// Outcomes of a finished run
+const (
+	outcomeCompleted = "completed"
+	outcomeFailed    = "failed"
+	outcomeCanceled  = "canceled"
+)
+
+// runOutcome returns how a finished run ended: canceled, failed when no
+// variant produced metrics, or completed.
+func runOutcome(ctx context.Context, results []types.SimulationResult) string {
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return outcomeCanceled
+	}
+	for _, r := range results {
+		if r.Status != types.ResultFailed {
+			return outcomeCompleted
+		}
+	}
+	return outcomeFailed
+}
+
+func (e *Engine) plan(parentCtx context.Context, req types.RunRequest, manifest *types.RunManifest) types.SimulationPlan {
 	// Integrate Cerebras OpenAI-compatible planning with tool calling
-	planID := fmt.Sprintf("plan-%d", time.Now().UnixNano())
+	planID := fmt.Sprintf("plan-%d", e.clock.Now().UnixNano())
 
-	// Create a separate context for planning so it doesn't affect simulators
-	ctx, cancel := context.WithTimeout(parentCtx, 90*time.Second)
+	// Create a separate context for planning so it doesn't affect simulators;
+	// it is capped by whatever remains of the run's LLM budget
+	planTimeout, _ := e.phaseTimeouts()
+	ctx, cancel, budgetErr := budgetFrom(parentCtx).acquire(withPhase(parentCtx, "plan"), planTimeout)
 	defer cancel()
 
-	// Use Cerebras Llama for fast planning (without tools parameter for compatibility)
-	model := getEnv("CEREBRAS_MODEL", "llama3.1-8b")
+	// Use the configured provider (Cerebras Llama by default) for fast planning
 	systemPrompt := `You are a simulation planning AI. Given a goal, create 3 variant parameter sets to test different scenarios.
 
 Available simulators:
@@ -81,86 +704,209 @@ Available simulators:
 Return ONLY valid JSON with this structure:
 {"variants": [{"id": "v1", "queue": {"arrival_rate": 10, "service_rate": 12}, "traffic": {"density": 0.5}, "resource": {"staff": 20}}]}`
 
+	input := e.promptInputFor(req)
 	messages := []cerebras.ChatMessage{
-		{Role: "system", Content: systemPrompt},
-		{Role: "user", Content: fmt.Sprintf("Goal: %s. Constraints: %v. Create 3 test variants.", req.Goal, req.Constraints)},
+		{Role: "system", Content: systemPrompt + userDataNotice},
+		{Role: "user", Content: input.sections() + "\nCreate 3 test variants."},
 	}
 
-	startTokens := time.Now()
+	temperature := e.plannerTemperature(req)
 	// Don't send tools parameter - Cerebras API doesn't support it like OpenAI
-	resp, err := e.cereClient.Chat(ctx, cerebras.OpenAIChatRequest{
-		Model:       model,
+	chatReq := cerebras.OpenAIChatRequest{
+		Model:       e.phaseModelFor("plan", req),
 		Messages:    messages,
-		Temperature: 0.7,
-	})
-	elapsed := time.Since(startTokens).Seconds()
+		Temperature: float32(temperature),
+		MaxTokens:   plannerMaxTokens,
+		Seed:        runSeed(req),
+	}
+	if e.structuredOutput {
+		chatReq.ResponseFormat = plannerResponseFormat
+	}
+	offline := e.isOffline(parentCtx)
+	reply := planReply{err: budgetErr}
+	sources := &types.PlanSources{}
+	// Fallback variants that beat a slow planner
+	var raced []types.Variant
+	if budgetErr == nil && !offline {
+		reply, raced, sources = e.racePlanner(ctx, parentCtx, chatReq, manifest, planID, req)
+	}
+	resp, model, err := reply.resp, reply.model, reply.err
+	elapsed := reply.elapsed.Seconds()
 
 	// Check for errors first before using response
 	var variants []types.Variant
-	if err != nil {
-		log.Printf("Cerebras planning unavailable, using fallback variants: %v", err)
+	var repaired, fromModel bool
+	if offline {
+		slog.InfoContext(parentCtx, "offline mode, planning with the fallback grid")
+		variants = e.fallbackVariants(planID, req)
+	} else if raced != nil {
+		slog.WarnContext(parentCtx, "planning missed its soft deadline, using fallback variants", "provider", llm.NameOf(e.llm))
+		e.emitFallback(parentCtx, "plan", "slow", nil)
+		variants = raced
+	} else if errors.Is(err, ErrBudgetExhausted) {
+		slog.WarnContext(parentCtx, "LLM budget exhausted, skipping planning")
+		e.emitBudgetExhausted(parentCtx, "plan")
+		variants = e.fallbackVariants(planID, req)
+	} else if err != nil {
+		slog.WarnContext(parentCtx, "planning unavailable, using fallback variants", "provider", llm.NameOf(e.llm), "category", cerebras.Category(err), "err", err)
+		e.emitFallback(parentCtx, "plan", cerebras.Category(err), err)
 		variants = e.fallbackVariants(planID, req)
 	} else {
 		// Track token performance (Cerebras can do 1800+ tokens/sec)
 		if usage, ok := resp["usage"].(map[string]interface{}); ok {
 			if total, ok := usage["total_tokens"].(float64); ok && elapsed > 0 {
-				e.tokensPerSec = total / elapsed
-				log.Printf("Cerebras planning completed: %.0f tokens/sec", e.tokensPerSec)
+				slog.InfoContext(parentCtx, "planning completed", "provider", llm.NameOf(e.llm), "tokens_per_sec", math.Round(total/elapsed))
 			}
 		}
 
 		// Parse response or use fallback variants
-		variants = e.parseVariantsFromResponse(resp, planID)
-		if len(variants) == 0 {
-			log.Println("Cerebras planning returned no parseable variants, using fallback")
+		variants, repaired = e.parseVariantsFromResponse(resp, planID)
+		fromModel = len(variants) > 0
+		if !fromModel {
+			slog.WarnContext(parentCtx, "planning returned no parseable variants, using fallback", "provider", llm.NameOf(e.llm))
+			e.emitFallback(parentCtx, "plan", "invalid_output", nil)
 			variants = e.fallbackVariants(planID, req)
 		}
 	}
+	sources.Winner = types.PlanSourceFallback
+	if fromModel {
+		sources.Winner = types.PlanSourceLLM
+	}
+
+	return types.SimulationPlan{
+		PlanID:        planID,
+		Model:         model,
+		Steps:         planSteps(),
+		Variants:      variants,
+		Temperature:   temperature,
+		Continuations: reply.continuations,
+		LLM:           fromModel,
+		Repaired:      repaired && fromModel,
+		Sources:       sources,
+		Sanitized:     input.sanitized(),
+	}
+}
 
-	steps := []types.PlanStep{
+// planSteps returns the steps of every plan: one per bundled simulator.
+func planSteps() []types.PlanStep {
+	return []types.PlanStep{
 		{Name: "Queue", Description: "Queueing simulation", Tool: "queue", InputSchema: map[string]any{"arrival_rate": "number", "service_rate": "number"}},
 		{Name: "Traffic", Description: "Traffic flow simulation", Tool: "traffic", InputSchema: map[string]any{"density": "number", "signal_timing": "number"}},
 		{Name: "Resource", Description: "Resource allocation", Tool: "resource", InputSchema: map[string]any{"staff": "number", "shifts": "array"}},
 	}
+}
 
-	return types.SimulationPlan{PlanID: planID, Steps: steps, Variants: variants}
+// planReply is the planner LLM's answer, or why there is none.
+type planReply struct {
+	resp          map[string]any
+	model         string
+	continuations int
+	err           error
+	elapsed       time.Duration
 }
 
-func (e *Engine) parseVariantsFromResponse(resp map[string]any, planID string) []types.Variant {
-	// Try to extract variants from Cerebras response
-	choices, ok := resp["choices"].([]interface{})
-	if !ok || len(choices) == 0 {
-		return nil
+// askPlanner calls the planner LLM, finishing a reply cut off by max_tokens.
+func (e *Engine) askPlanner(ctx context.Context, chatReq cerebras.OpenAIChatRequest, manifest *types.RunManifest) planReply {
+	start := e.clock.Now()
+	var r planReply
+	r.resp, r.model, r.err = e.chat(ctx, "plan", chatReq, manifest)
+	if r.err == nil && cerebras.FinishReason(r.resp) == cerebras.FinishLength && e.maxContinuations > 0 {
+		r.resp, r.continuations = e.continuePlan(ctx, chatReq, r.model, r.resp, manifest)
 	}
-	choice := choices[0].(map[string]interface{})
-	message := choice["message"].(map[string]interface{})
-	content, ok := message["content"].(string)
-	if !ok || content == "" {
-		return nil
+	r.elapsed = e.clock.Now().Sub(start)
+	return r
+}
+
+// racePlanner waits for the planner LLM. Past the soft deadline it tells
+// clients planning is slow and builds the fallback grid alongside; the first
+// one ready wins and the LLM call is canceled if it lost. Fallback variants
+// are returned only when they won. Neither goroutine outlives the call: a
+// canceled LLM call is waited for, since it records itself in manifest.
+func (e *Engine) racePlanner(ctx, parentCtx context.Context, chatReq cerebras.OpenAIChatRequest, manifest *types.RunManifest, planID string, req types.RunRequest) (planReply, []types.Variant, *types.PlanSources) {
+	sources := &types.PlanSources{}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	replies := make(chan planReply, 1)
+	go func() { replies <- e.askPlanner(ctx, chatReq, manifest) }()
+
+	var slow <-chan time.Time
+	deadline := e.config().PlanSoftDeadline
+	if deadline > 0 {
+		timer := e.clock.NewTimer(deadline)
+		defer timer.Stop()
+		slow = timer.C()
+	}
+	select {
+	case r := <-replies:
+		sources.LLMMs = r.elapsed.Milliseconds()
+		return r, nil, sources
+	case <-slow:
 	}
 
-	var parsed struct {
-		Variants []map[string]map[string]interface{} `json:"variants"`
+	slog.WarnContext(parentCtx, "planning is past its soft deadline, racing the fallback grid", "provider", llm.NameOf(e.llm), "soft_deadline", deadline)
+	e.eventsFrom(parentCtx).send(types.EventPlanningSlow, types.PlanningSlowEvent{SoftDeadlineMs: deadline.Milliseconds()})
+	sources.Raced = true
+	fallbackStart := e.clock.Now()
+	fallbacks := make(chan []types.Variant, 1)
+	go func() { fallbacks <- e.fallbackVariants(planID, req) }()
+
+	select {
+	case r := <-replies:
+		sources.LLMMs = r.elapsed.Milliseconds()
+		// The grid can't be interrupted, but it is quick
+		<-fallbacks
+		sources.FallbackMs = e.clock.Now().Sub(fallbackStart).Milliseconds()
+		return r, nil, sources
+	case variants := <-fallbacks:
+		sources.FallbackMs = e.clock.Now().Sub(fallbackStart).Milliseconds()
+		cancel()
+		r := <-replies
+		sources.LLMMs = r.elapsed.Milliseconds()
+		return planReply{}, variants, sources
 	}
-	if err := json.Unmarshal([]byte(content), &parsed); err != nil {
-		return nil
+}
+
+// continuePlan asks the answering model to finish a truncated plan, within
+// the run's LLM budget. A failed continuation keeps what was stitched so far
+// for the parser (and JSON repair) to make the best of.
+func (e *Engine) continuePlan(ctx context.Context, req cerebras.OpenAIChatRequest, model string, resp map[string]any, manifest *types.RunManifest) (map[string]any, int) {
+	req.Model = model
+	chat := func(ctx context.Context, req cerebras.OpenAIChatRequest) (map[string]any, error) {
+		if budgetFrom(ctx).exhausted() {
+			return nil, ErrBudgetExhausted
+		}
+		resp, _, err := e.chat(ctx, "plan", req, manifest)
+		return resp, err
+	}
+	resp, n, err := llm.Continue(ctx, chat, req, resp, e.maxContinuations)
+	if err != nil {
+		slog.WarnContext(ctx, "plan continuation failed", "continuation", n+1, "err", err)
+	}
+	if n > 0 {
+		slog.InfoContext(ctx, "plan was truncated by max_tokens; stitched continuations", "continuations", n)
+	}
+	return resp, n
+}
+
+func (e *Engine) parseVariantsFromResponse(resp map[string]any, planID string) ([]types.Variant, bool) {
+	var parsed plannerOutput
+	repaired, err := decodeContent(resp, &parsed)
+	if err != nil {
+		slog.Warn("planner output does not match schema", "err", err)
+		return nil, false
+	}
+	if repaired {
+		slog.Warn("planner output was malformed JSON; used repaired content")
 	}
 
 	variants := make([]types.Variant, 0, len(parsed.Variants))
 	for i, v := range parsed.Variants {
-		merged := make(map[string]any)
-		for toolName, params := range v {
-			for k, val := range params {
-				merged[k] = val
-			}
-			_ = toolName
-		}
 		variants = append(variants, types.Variant{
 			VariantID:  fmt.Sprintf("%s-v%d", planID, i+1),
-			Parameters: merged,
+			Parameters: v.parameters(),
 		})
 	}
-	return variants
+	return variants, repaired
 }
 
 func (e *Engine) fallbackVariants(planID string, req types.RunRequest) []types.Variant {
@@ -201,143 +947,414 @@ func (e *Engine) fallbackVariants(planID string, req types.RunRequest) []types.V
 	return variants
 }
 
-func (e *Engine) runSimulators(parentCtx context.Context, plan types.SimulationPlan) []types.SimulationResult {
+// runSimulators warms up the simulators and runs plan's variants, both on
+// cfg, and times the two phases into timings. It returns the results and
+// how long each simulator took to answer this run's warm-up ping.
+func (e *Engine) runSimulators(parentCtx context.Context, cfg *config.Config, plan types.SimulationPlan, timings *types.PhaseTimings) ([]types.SimulationResult, map[string]int64) {
 	// Spawn Docker containers for each simulator in parallel
 	// Using HTTP calls to simulator services (running in docker-compose or MCP containers)
+	phaseStart := e.clock.Now()
+	warmup := e.warmUpSimulators(parentCtx, cfg, plan)
+	timings.SimulatorWarmupMs = e.msSince(phaseStart)
+	phaseStart = e.clock.Now()
+	results := e.dispatchVariants(parentCtx, cfg, plan)
+	timings.SimulationPhaseMs = e.msSince(phaseStart)
+	return results, warmup
+}
 
-	simulatorURLs := map[string]string{
-		"queue":    getEnv("QUEUE_SIMULATOR_URL", "http://localhost:8101"),
-		"traffic":  getEnv("TRAFFIC_SIMULATOR_URL", "http://localhost:8102"),
-		"resource": getEnv("RESOURCE_SIMULATOR_URL", "http://localhost:8103"),
+// metricsPerTool sizes a variant's metrics up front; the bundled simulators
+// report three or four each.
+const metricsPerTool = 4
+
+// dispatchVariants runs every variant of plan against the simulators in cfg,
+// all at once, and waits for their results.
+func (e *Engine) dispatchVariants(parentCtx context.Context, cfg *config.Config, plan types.SimulationPlan) []types.SimulationResult {
+	simulatorURLs := cfg.SimulatorURLs
+	runID := correlationFrom(parentCtx).RunID
+	events := e.eventsFrom(parentCtx)
+	noCache := cacheBypassed(parentCtx)
+	responseNames := make(map[string]string, len(simulatorURLs))
+	for toolName := range simulatorURLs {
+		responseNames[toolName] = toolName + "-response.json"
 	}
-
-	results := make([]types.SimulationResult, 0, len(plan.Variants))
-	resultsMu := sync.Mutex{}
+	// Each variant takes the next slot as it finishes, so results stay in
+	// completion order without a lock
+	results := make([]types.SimulationResult, len(plan.Variants))
+	var finished atomic.Int64
 	wg := sync.WaitGroup{}
+	phaseStart := e.clock.Now()
+	var order *resultOrder
+	if resultsOrdered(parentCtx) {
+		order = newResultOrder(e.clock, plan.Variants, cfg.VariantTimeout+orderedResultsGrace, events.send)
+		defer order.stop()
+	}
 
 	// Run variants in parallel for speed
-	for _, variant := range plan.Variants {
+	for i, variant := range plan.Variants {
 		wg.Add(1)
-		go func(v types.Variant) {
+		go func(i int, v types.Variant) {
 			defer wg.Done()
+			dispatched := e.clock.Now()
 
 			// CRITICAL: Create independent context for this variant so failures don't cascade
-			// Use background context with timeout instead of parent context
-			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Minute)
+			// Use background context with timeout instead of parent context; only
+			// the run's span and log attributes carry over, and the run being
+			// canceled
+			ctx, cancel := clock.WithTimeout(trace.ContextWithSpan(logging.With(logging.Detach(parentCtx), "variant_id", v.VariantID), trace.SpanFromContext(parentCtx)), e.clock, cfg.VariantTimeout)
 			defer cancel()
+			defer context.AfterFunc(parentCtx, func() {
+				if errors.Is(parentCtx.Err(), context.Canceled) {
+					cancel()
+				}
+			})()
+			span := tracing.Off
+			if trace.SpanFromContext(ctx).IsRecording() {
+				ctx, span = e.tracer.Start(ctx, "variant", trace.WithAttributes(attribute.String("simstack.variant_id", v.VariantID)))
+			}
+			defer span.End()
 
 			// Emit progress event
-			e.emit(types.WSEvent{
-				Type:      "sim_start",
-				Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
-				Payload:   map[string]any{"variant_id": v.VariantID},
-			})
+			events.send(types.EventSimStart, types.ProgressEvent{VariantID: v.VariantID})
 
 			// Run each simulator tool with variant parameters
-			variantMetrics := make(map[string]float64)
-
+			variantMetrics := make(map[string]float64, metricsPerTool*len(simulatorURLs))
+			toolDurations := make(map[string]int64, len(simulatorURLs))
+			calls := make([]types.ToolTiming, 0, len(simulatorURLs))
+			var inCalls time.Duration
+			var captured types.Artifacts
+			var coercions []string
+			started := e.clock.Now().UTC()
+			attempted, succeeded := 0, 0
+
+			interrupted := false
 			for toolName, baseURL := range simulatorURLs {
-				toolParams := e.extractToolParams(v.Parameters, toolName)
+				if errors.Is(parentCtx.Err(), context.Canceled) {
+					interrupted = true
+					break
+				}
+				toolParams, coerced, err := e.extractToolParams(v.Parameters, toolName)
 				if len(toolParams) == 0 {
 					continue // Skip if no params for this tool
 				}
-
-				// Create independent context for each simulator call
-				// Use shorter timeout (45s) than variant timeout (3min)
-				simCtx, simCancel := context.WithTimeout(ctx, 45*time.Second)
-				metrics, err := e.invokeSimulator(simCtx, baseURL, toolParams)
-				simCancel() // Always cancel to free resources
+				attempted++
+				coercions = append(coercions, coerced...)
 				if err != nil {
-					log.Printf("simulator %s error for %s: %v", toolName, v.VariantID, err)
-					// Don't fail the entire variant, just skip this simulator
+					// The simulator would only reject it less clearly
+					slog.WarnContext(ctx, "simulator refused the parameters", "tool", toolName, "err", err)
+					calls = append(calls, types.ToolTiming{Tool: toolName, Failed: true, Error: err.Error()})
 					continue
 				}
 
+				// A parameter set measured before, in this run or an earlier
+				// one, is answered from the cache without a call
+				seed := v.Parameters["seed"]
+				key, forever, cacheable := e.cacheKey(cfg, toolName, baseURL, toolParams, seed)
+				cacheable = cacheable && !noCache
+				var entry simcache.Entry
+				hit := false
+				if cacheable {
+					if entry, hit = e.simCache.Get(key); hit {
+						e.counters.SimulatorCacheHits.Add(1)
+					} else {
+						e.counters.SimulatorCacheMisses.Add(1)
+					}
+				}
+				toolMetrics, raw := entry.Metrics, entry.Raw
+				if hit {
+					calls = append(calls, types.ToolTiming{Tool: toolName, Cached: true})
+				} else {
+					if seed != nil {
+						toolParams["seed"] = seed
+					}
+					var call types.ToolTiming
+					var elapsed time.Duration
+					toolMetrics, raw, call, elapsed, err = e.callSimulator(ctx, cfg, toolName, baseURL, toolParams)
+					if call.Attempts == 0 {
+						slog.WarnContext(ctx, "simulator skipped", "tool", toolName, "err", simstats.ErrCircuitOpen)
+						continue
+					}
+					toolDurations[toolName] = call.DurationMs
+					inCalls += elapsed
+					calls = append(calls, call)
+					if err != nil && errors.Is(parentCtx.Err(), context.Canceled) {
+						interrupted = true
+						break
+					}
+					if err != nil {
+						slog.WarnContext(ctx, "simulator error", "tool", toolName, "err", err)
+						// Don't fail the entire variant, just skip this simulator
+						continue
+					}
+					if cacheable {
+						e.simCache.Put(key, simcache.Entry{Metrics: toolMetrics, Raw: raw}, forever)
+					}
+				}
+
+				succeeded++
+				origin := types.ArtifactOrigin{RunID: runID, VariantID: v.VariantID, Tool: toolName}
+				if a, ok := e.captureArtifact(ctx, origin, responseNames[toolName], "application/json", raw); ok {
+					captured = append(captured, a)
+				}
+
 				// Merge metrics with tool prefix
-				for k, val := range metrics {
-					variantMetrics[fmt.Sprintf("%s_%s", toolName, k)] = val
+				for k, val := range toolMetrics {
+					variantMetrics[e.metricNames.get(toolName, k)] = val
 				}
 			}
 
+			if interrupted {
+				// Cut short by the run being canceled; only finished
+				// variants are kept
+				return
+			}
+			if len(coercions) > 0 {
+				sort.Strings(coercions)
+				slog.InfoContext(ctx, "variant parameters coerced", "coercions", strings.Join(coercions, "; "))
+			}
+
+			completed := e.clock.Now().UTC()
+			queued, ran := dispatched.Sub(phaseStart), completed.Sub(dispatched)
+			timing := &types.VariantTiming{
+				QueueMs:    queued.Milliseconds(),
+				Calls:      calls,
+				OverheadMs: (ran - inCalls).Milliseconds(),
+				WallMs:     (queued + ran).Milliseconds(),
+			}
+			if span.IsRecording() {
+				span.SetAttributes(attribute.String("simstack.status", string(resultStatus(attempted, succeeded))))
+			}
 			result := types.SimulationResult{
-				VariantID: v.VariantID,
-				Tool:      "composite",
-				Metrics:   variantMetrics,
+				VariantID:     v.VariantID,
+				Tool:          "composite",
+				Metrics:       variantMetrics,
+				Artifacts:     captured,
+				Status:        resultStatus(attempted, succeeded),
+				StartedAt:     &started,
+				CompletedAt:   &completed,
+				DurationMs:    completed.Sub(started).Milliseconds(),
+				ToolDurations: toolDurations,
+				Timing:        timing,
+				Coercions:     coercions,
 			}
 
-			resultsMu.Lock()
-			results = append(results, result)
-			resultsMu.Unlock()
+			results[finished.Add(1)-1] = result
+			if live, ok := e.liveRunOf(runID); ok {
+				watchdogFrom(parentCtx).progress()
+				live.add(result)
+			}
+			e.counters.VariantsExecuted.Add(1)
 
-			e.emit(types.WSEvent{
-				Type:      "sim_complete",
-				Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
-				Payload:   result,
-			})
-		}(variant)
+			events.send(types.EventSimComplete, result)
+			if order != nil {
+				order.add(i, result)
+			}
+		}(i, variant)
 	}
 
 	wg.Wait()
-	return results
+	return results[:finished.Load()]
 }
 
-func (e *Engine) extractToolParams(params map[string]any, toolName string) map[string]any {
-	// Extract parameters relevant to a specific tool
-	extracted := make(map[string]any)
+// warmUpSimulators warms up the simulators plan uses, unless cfg turns
+// warm-up off, and returns how long each took to answer, or nil.
+func (e *Engine) warmUpSimulators(ctx context.Context, cfg *config.Config, plan types.SimulationPlan) map[string]int64 {
+	if !cfg.SimulatorWarmup {
+		return nil
+	}
+	return e.warmUp(ctx, cfg, plan)
+}
 
-	toolFields := map[string][]string{
-		"queue":    {"arrival_rate", "service_rate"},
-		"traffic":  {"density", "signal_timing"},
-		"resource": {"staff", "shifts"},
+// warmUp pings every simulator the plan's variants will call, all at once, and
+// waits for the answers, so connection setup and cold starts land here
+// rather than in the first variant's timing. A failed ping counts against
+// the simulator's breaker like a failed call; simulators whose breaker is
+// not closed are left alone. It returns how long each simulator that
+// answered took.
+func (e *Engine) warmUp(ctx context.Context, cfg *config.Config, plan types.SimulationPlan) map[string]int64 {
+	ctx, span := e.tracer.Start(ctx, "warmup")
+	defer span.End()
+
+	startup := map[string]int64{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for toolName, baseURL := range cfg.SimulatorURLs {
+		if !e.planNeeds(ctx, cfg, plan, toolName) || !e.simStats.Closed(toolName) {
+			continue
+		}
+		wg.Add(1)
+		go func(toolName, baseURL string) {
+			defer wg.Done()
+			pingCtx, cancel := clock.WithTimeout(ctx, e.clock, cfg.SimulatorTimeout)
+			pingCtx, pingSpan := e.tracer.Start(pingCtx, "simulator.warmup", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+				attribute.String("simstack.tool", toolName),
+				attribute.String("url.full", baseURL+health.Path),
+			))
+			latency, err := health.Probe(pingCtx, e.simClient, baseURL)
+			tracing.RecordError(pingSpan, err)
+			pingSpan.End()
+			cancel()
+			if err != nil {
+				if ctx.Err() != nil {
+					return // The run was canceled, not the simulator's fault
+				}
+				e.simStats.Record(toolName, latency, err)
+				e.counters.SimulatorFailed(toolName)
+				slog.WarnContext(ctx, "simulator warm-up failed", "tool", toolName, "err", err)
+				return
+			}
+			mu.Lock()
+			startup[toolName] = latency.Milliseconds()
+			mu.Unlock()
+		}(toolName, baseURL)
 	}
+	wg.Wait()
+	return startup
+}
 
-	fields, ok := toolFields[toolName]
-	if !ok {
-		return extracted
+func resultStatus(attempted, succeeded int) types.ResultStatus {
+	switch {
+	case succeeded == 0:
+		return types.ResultFailed
+	case succeeded < attempted:
+		return types.ResultPartial
+	default:
+		return types.ResultComplete
 	}
+}
 
-	for _, field := range fields {
-		if val, exists := params[field]; exists {
-			extracted[field] = val
+// recordTimings copies the run's simulation and warm-up timings into the
+// manifest and returns its mean call duration per simulator.
+func (e *Engine) recordTimings(results []types.SimulationResult, warmup map[string]int64, manifest *types.RunManifest) map[string]float64 {
+	manifest.SimulatorStartupMs = warmup
+	manifest.VariantDurationsMs = make(map[string]int64, len(results))
+	totals := map[string]float64{}
+	counts := map[string]float64{}
+	for _, r := range results {
+		manifest.VariantDurationsMs[r.VariantID] = r.DurationMs
+		for tool, ms := range r.ToolDurations {
+			totals[tool] += float64(ms)
+			counts[tool]++
 		}
 	}
+	latency := make(map[string]float64, len(totals))
+	for tool, total := range totals {
+		latency[tool] = total / counts[tool]
+	}
+	return latency
+}
 
-	return extracted
+// runFigures are one completed run's performance figures, which Metrics
+// reports for the latest run.
+type runFigures struct {
+	phases       types.PhaseTimings
+	tokensPerSec float64
+	simLatencyMs map[string]float64
+	simWarmupMs  map[string]int64
+}
+
+// plannerTokensPerSec is the planner's throughput over a run's successful
+// planning calls, or 0 when there were none.
+func plannerTokensPerSec(calls []types.LLMCallRecord) float64 {
+	var tokens int
+	var latencyMs int64
+	for _, call := range calls {
+		if call.Purpose == "plan" && call.Error == "" {
+			tokens += call.Tokens
+			latencyMs += call.LatencyMs
+		}
+	}
+	if latencyMs <= 0 {
+		return 0
+	}
+	return float64(tokens) / (float64(latencyMs) / 1000)
 }
 
-func (e *Engine) invokeSimulator(ctx context.Context, baseURL string, params map[string]any) (map[string]float64, error) {
+// jsonContentType and simUserAgent are shared by every simulator call's
+// request; nothing along the way writes to a header's values, only to the
+// header map. Setting the User-Agent up front spares the client's
+// transport copying each request to add it.
+var (
+	jsonContentType = []string{"application/json"}
+	simUserAgent    = []string{version.UserAgent()}
+)
+
+// invokeSimulator returns the simulator's metrics and its raw response body.
+func (e *Engine) invokeSimulator(ctx context.Context, toolName, baseURL string, params map[string]any) (metrics map[string]float64, raw []byte, err error) {
+	target := baseURL + "/simulate"
+	span := tracing.Off
+	if trace.SpanFromContext(ctx).IsRecording() {
+		ctx, span = e.tracer.Start(ctx, "simulator.call", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+			attribute.String("simstack.tool", toolName),
+			attribute.String("url.full", target),
+		))
+	}
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
 	// POST to simulator's /simulate endpoint
-	body, _ := json.Marshal(params)
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+"/simulate", bytes.NewReader(body))
+	body, err := requestBody(params)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, body)
+	if err != nil {
+		body.Close()
+		return nil, nil, err
+	}
+	// The pooled body can't be replayed, which a POST never is
+	req.ContentLength = int64(body.Len())
+	req.Header["Content-Type"] = jsonContentType
+	req.Header["User-Agent"] = simUserAgent
+	if secret := e.config().SimulatorSecrets[toolName]; secret != "" {
+		simsign.Sign(req.Header, []byte(secret), e.clock.Now(), req.Method, req.URL.Path, body.buf.Bytes())
 	}
-	req.Header.Set("Content-Type", "application/json")
 
-	// Context timeout (45s) will take precedence over HTTP client timeout
-	client := &http.Client{Timeout: 60 * time.Second}
-	resp, err := client.Do(req)
+	// Straight to the shared client's transport, sparing the client's copy
+	// of the request: it has no timeout of its own, as the call context
+	// governs, and simulators answer /simulate without redirecting.
+	// Credentials in the URL still need the client to send them.
+	var resp *http.Response
+	if req.URL.User != nil {
+		resp, err = e.simClient.Do(req)
+	} else if resp, err = e.simClient.Transport.RoundTrip(req); err != nil {
+		err = &url.Error{Op: "Post", URL: target, Err: err}
+	}
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer resp.Body.Close()
+	if span.IsRecording() {
+		span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	}
 
+	bodyBytes, err := readBody(resp.Body, e.config().SimulatorMaxResponseBytes)
+	if err != nil {
+		return nil, nil, err
+	}
 	if resp.StatusCode >= 300 {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("simulator returned %d: %s", resp.StatusCode, string(bodyBytes))
+		return nil, nil, fmt.Errorf("simulator returned %d: %s", resp.StatusCode, string(bodyBytes))
 	}
 
 	var result struct {
 		Metrics map[string]float64 `json:"metrics"`
+		// Optional definitions for metrics the catalog doesn't know, named
+		// without the tool prefix
+		MetricDefs []types.MetricDef `json:"metric_defs"`
+	}
+	if err := json.Unmarshal(bodyBytes, &result); err != nil {
+		return nil, nil, err
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, err
+	for i := range result.MetricDefs {
+		result.MetricDefs[i].Name = toolName + "_" + result.MetricDefs[i].Name
 	}
+	e.metrics.Register(result.MetricDefs...)
 
-	return result.Metrics, nil
+	return result.Metrics, bodyBytes, nil
 }
 
-func (e *Engine) analyzeResults(parentCtx context.Context, req types.RunRequest, results []types.SimulationResult) map[string]any {
+func (e *Engine) analyzeResults(parentCtx context.Context, req types.RunRequest, results []types.SimulationResult, manifest *types.RunManifest) map[string]any {
 	// Critic Agent: Analyze simulation results and provide recommendations using Cerebras
 
 	if len(results) == 0 {
@@ -345,17 +1362,28 @@ func (e *Engine) analyzeResults(parentCtx context.Context, req types.RunRequest,
 			"recommendation": "No results to analyze",
 			"confidence":     0.0,
 			"trade_offs":     []string{},
+			"llm":            false,
 		}
 	}
 
-	// Create independent context for criticism
-	ctx, cancel := context.WithTimeout(parentCtx, 60*time.Second)
+	if e.isOffline(parentCtx) {
+		slog.InfoContext(parentCtx, "offline mode, using heuristic analysis")
+		return e.fallbackAnalysis(results)
+	}
+
+	// Create independent context for criticism, capped by the run's LLM budget
+	_, analysisTimeout := e.phaseTimeouts()
+	ctx, cancel, err := budgetFrom(parentCtx).acquire(withPhase(parentCtx, "critic"), analysisTimeout)
 	defer cancel()
+	if err != nil {
+		slog.WarnContext(parentCtx, "LLM budget exhausted, skipping critic analysis")
+		e.emitBudgetExhausted(parentCtx, "analysis")
+		return e.fallbackAnalysis(results)
+	}
 
 	// Prepare results summary for Llama
 	resultsSummary := e.summarizeResults(results)
 
-	model := getEnv("CEREBRAS_MODEL", "llama3.1-8b")
 	systemPrompt := `You are an expert operations analyst. Analyze simulation results and provide:
 1. The best performing variant and why
 2. Key trade-offs between cost, performance, and constraints
@@ -372,78 +1400,216 @@ Return concise, actionable JSON:
   "key_metrics": {"metric": value}
 }`
 
-	userPrompt := fmt.Sprintf(`Goal: %s
-Constraints: %v
+	input := e.promptInputFor(req)
+	userPrompt := func(summary string) string {
+		return fmt.Sprintf(`%s
 
 Simulation Results:
 %s
 
-Analyze these results and recommend the best approach.`, req.Goal, req.Constraints, resultsSummary)
+Analyze these results and recommend the best approach.`, input.sections(), summary)
+	}
 
 	messages := []cerebras.ChatMessage{
-		{Role: "system", Content: systemPrompt},
-		{Role: "user", Content: userPrompt},
+		{Role: "system", Content: systemPrompt + userDataNotice},
+		{Role: "user", Content: userPrompt(resultsSummary)},
 	}
 
-	resp, err := e.cereClient.Chat(ctx, cerebras.OpenAIChatRequest{
-		Model:       model,
+	chatReq := cerebras.OpenAIChatRequest{
+		Model:       e.phaseModelFor("analysis", req),
 		Messages:    messages,
-		Temperature: 0.3, // Lower temperature for more consistent analysis
-	})
+		Temperature: float32(e.criticTemperature(req)),
+		MaxTokens:   criticMaxTokens,
+		Seed:        runSeed(req),
+	}
+	if e.structuredOutput {
+		chatReq.ResponseFormat = analysisResponseFormat
+	}
+
+	// Large runs can overflow the critic's context window; keep the best-scoring
+	// variants that fit rather than letting the provider reject the prompt
+	if fit := cerebras.EstimateFit(chatReq); !fit.Fits() {
+		budget := fit.Available() - (fit.PromptTokens - cerebras.EstimateTokens(resultsSummary))
+		resultsSummary = e.summarizeResultsWithin(results, budget)
+		chatReq.Messages[1].Content = userPrompt(resultsSummary)
+		slog.InfoContext(ctx, "critic prompt trimmed to fit the context window", "model", chatReq.Model, "tokens_available", fit.Available())
+	}
+
+	resp, model, err := e.chat(ctx, "analysis", chatReq, manifest)
 
 	if err != nil {
-		log.Printf("Critic analysis failed, using fallback: %v", err)
+		slog.WarnContext(ctx, "critic analysis failed, using fallback", "category", cerebras.Category(err), "err", err)
+		e.emitFallback(parentCtx, "analysis", cerebras.Category(err), err)
 		return e.fallbackAnalysis(results)
 	}
 
 	// Parse Llama's analysis
 	analysis := e.parseAnalysis(resp, results)
 	if analysis == nil {
-		log.Println("Failed to parse analysis, using fallback")
+		slog.WarnContext(ctx, "failed to parse analysis, using fallback")
+		e.emitFallback(parentCtx, "analysis", "invalid_output", nil)
 		return e.fallbackAnalysis(results)
 	}
+	analysis["model"] = model
+	analysis["llm"] = true
 
 	return analysis
 }
 
+// chat sends a request through the call site's model chain and records the
+// call, including any models that failed first, in the run manifest.
+func (e *Engine) chat(ctx context.Context, purpose string, req cerebras.OpenAIChatRequest, manifest *types.RunManifest) (map[string]any, string, error) {
+	ctx, span := e.tracer.Start(ctx, "llm.call", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("simstack.purpose", purpose),
+		attribute.String("gen_ai.request.model", req.Model),
+	))
+	defer span.End()
+
+	// A slow answer is not a stalled run
+	defer watchdogFrom(ctx).heartbeat()()
+
+	estimate := cerebras.EstimatePromptTokens(req)
+	chain := e.chains[purpose]
+	if req.Model != e.phaseModels[purpose] {
+		// A per-run model override replaces the configured fallback chain
+		chain = llm.ModelChain{{Model: req.Model, Timeout: e.modelTimeout}}
+	}
+	resp, result, err := chain.Chat(ctx, e.llm, req)
+
+	call := types.LLMCallRecord{
+		Purpose:               purpose,
+		Provider:              llm.NameOf(e.llm),
+		Model:                 result.Model,
+		LatencyMs:             result.LatencyMs,
+		EstimatedPromptTokens: estimate,
+		FailedModels:          result.Failed,
+	}
+	if err != nil {
+		call.Error = err.Error()
+		call.ErrorCategory = cerebras.Category(err)
+	} else if usage, ok := resp["usage"].(map[string]interface{}); ok {
+		if total, ok := usage["total_tokens"].(float64); ok {
+			call.Tokens = int(total)
+		}
+		if prompt, ok := usage["prompt_tokens"].(float64); ok {
+			call.PromptTokens = int(prompt)
+		}
+		if completion, ok := usage["completion_tokens"].(float64); ok {
+			call.CompletionTokens = int(completion)
+		}
+	} else {
+		estimateUsage(&call, resp)
+	}
+	if err == nil {
+		// Estimated tokens count against the budget like reported ones
+		budgetFrom(ctx).addTokens(call.Tokens)
+		e.priceCall(&call)
+		e.counters.LLMTokens(purpose, call.Tokens)
+	}
+	call.SystemFingerprint, _ = resp["system_fingerprint"].(string)
+	span.SetAttributes(
+		attribute.String("gen_ai.response.model", call.Model),
+		attribute.Int("gen_ai.usage.total_tokens", call.Tokens),
+	)
+	tracing.RecordError(span, err)
+	if manifest != nil {
+		manifest.LLMCalls = append(manifest.LLMCalls, call)
+	}
+	e.recordAudit(ctx, req, resp, call)
+	return resp, result.Model, err
+}
+
+// emitFallback tells clients an LLM stage fell back to the built-in heuristics
+// and why, so "provider slow" can be told apart from "caller canceled".
+func (e *Engine) emitFallback(ctx context.Context, stage, category string, err error) {
+	payload := types.FallbackEvent{Stage: stage, Category: category}
+	if err != nil {
+		payload.Error = err.Error()
+	}
+	e.eventsFrom(ctx).send(types.EventFallback, payload)
+}
+
+// analysisEvent types the analysis map for the wire; the map's keys mirror
+// AnalysisEvent's fields.
+func analysisEvent(analysis map[string]any) types.AnalysisEvent {
+	var ev types.AnalysisEvent
+	b, _ := json.Marshal(analysis)
+	_ = json.Unmarshal(b, &ev)
+	return ev
+}
+
 func (e *Engine) summarizeResults(results []types.SimulationResult) string {
 	var summary strings.Builder
 
 	for i, r := range results {
 		summary.WriteString(fmt.Sprintf("\nVariant %d (%s):\n", i+1, r.VariantID))
-		for key, val := range r.Metrics {
-			summary.WriteString(fmt.Sprintf("  %s: %.2f\n", key, val))
+		// Sorted so identical results always produce an identical prompt
+		keys := make([]string, 0, len(r.Metrics))
+		for key := range r.Metrics {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			summary.WriteString(fmt.Sprintf("  %s: %.2f\n", key, r.Metrics[key]))
+		}
+		if r.DurationMs > 0 {
+			summary.WriteString(fmt.Sprintf("  simulation runtime: %dms\n", r.DurationMs))
+		}
+		if r.Status == types.ResultPartial || r.Status == types.ResultFailed {
+			summary.WriteString(fmt.Sprintf("  status: %s (some simulators did not answer)\n", r.Status))
 		}
 	}
 
 	return summary.String()
 }
 
+// summarizeResultsWithin summarizes the highest-scoring variants that fit in
+// budget tokens, noting how many were left out.
+func (e *Engine) summarizeResultsWithin(results []types.SimulationResult, budget int) string {
+	order := make([]int, len(results))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return e.heuristicScore(results[order[a]]) > e.heuristicScore(results[order[b]])
+	})
+
+	var summary strings.Builder
+	used, included := 0, 0
+	for _, i := range order {
+		block := e.summarizeResults([]types.SimulationResult{results[i]})
+		block = strings.Replace(block, "Variant 1 ", fmt.Sprintf("Variant %d ", i+1), 1)
+		n := cerebras.EstimateTokens(block)
+		if used+n > budget {
+			break
+		}
+		summary.WriteString(block)
+		used += n
+		included++
+	}
+	if omitted := len(results) - included; omitted > 0 {
+		summary.WriteString(fmt.Sprintf("\n(%d lower-scoring variants omitted to fit the context window)\n", omitted))
+	}
+	return summary.String()
+}
+
 func (e *Engine) parseAnalysis(resp map[string]any, results []types.SimulationResult) map[string]any {
-	choices, ok := resp["choices"].([]interface{})
-	if !ok || len(choices) == 0 {
+	var parsed analysisOutput
+	repaired, err := decodeContent(resp, &parsed)
+	if err != nil {
+		slog.Warn("critic output does not match schema", "err", err)
 		return nil
 	}
-
-	choice := choices[0].(map[string]interface{})
-	message := choice["message"].(map[string]interface{})
-	content, ok := message["content"].(string)
-	if !ok || content == "" {
+	if err := parsed.validate(results); err != nil {
+		slog.Warn("critic output rejected", "err", err)
 		return nil
 	}
-
-	// Try to parse JSON response
-	var parsed map[string]any
-	if err := json.Unmarshal([]byte(content), &parsed); err != nil {
-		// If not JSON, return structured fallback
-		return map[string]any{
-			"recommendation": content,
-			"confidence":     0.7,
-			"winner":         results[0].VariantID,
-		}
+	analysis := parsed.toMap()
+	if repaired {
+		slog.Warn("critic output was malformed JSON; used repaired content")
+		analysis["repaired"] = true
 	}
-
-	return parsed
+	return analysis
 }
 
 func (e *Engine) fallbackAnalysis(results []types.SimulationResult) map[string]any {
@@ -452,23 +1618,7 @@ func (e *Engine) fallbackAnalysis(results []types.SimulationResult) map[string]a
 	bestScore := 0.0
 
 	for i, r := range results {
-		score := 0.0
-		count := 0
-
-		// Calculate average of key metrics (lower wait time is better, higher throughput is better)
-		for key, val := range r.Metrics {
-			if strings.Contains(key, "wait") {
-				score += 1.0 / (1.0 + val) // Lower is better
-			} else {
-				score += val // Higher is better
-			}
-			count++
-		}
-
-		if count > 0 {
-			score = score / float64(count)
-		}
-
+		score := e.heuristicScore(r)
 		if score > bestScore {
 			bestScore = score
 			bestIdx = i
@@ -491,37 +1641,81 @@ func (e *Engine) fallbackAnalysis(results []types.SimulationResult) map[string]a
 			"Reducing arrival rate through scheduling could improve service quality",
 		},
 		"key_metrics": winner.Metrics,
+		"llm":         false,
 	}
 }
 
-func (e *Engine) ExportCompose(ctx context.Context, req types.ExportRequest) (string, string, error) {
-	// Minimal docker-compose with three services and environment for params
-	yml := `version: '3.9'
-services:
-  queue:
-    image: simstack/queue:latest
-    environment:
-      - PARAMS=` + fmt.Sprintf("%v", req.Parameters) + `
-  traffic:
-    image: simstack/traffic:latest
-    environment:
-      - PARAMS=` + fmt.Sprintf("%v", req.Parameters) + `
-  resource:
-    image: simstack/resource:latest
-    environment:
-      - PARAMS=` + fmt.Sprintf("%v", req.Parameters) + `
-`
-	return yml, "simstack-compose.yml", nil
-}
+// heuristicScore averages a variant's metrics, inverting those the catalog
+// marks lower-is-better so that higher is always better.
+func (e *Engine) heuristicScore(r types.SimulationResult) float64 {
+	score := 0.0
+	count := 0
+
+	for key, val := range r.Metrics {
+		if e.metrics.Direction(key) == types.LowerIsBetter {
+			score += 1.0 / (1.0 + val)
+		} else {
+			score += val
+		}
+		count++
+	}
 
-func (e *Engine) Metrics() types.MetricsSnapshot {
-	return types.MetricsSnapshot{PlannerMs: e.plannerLatencyMs, SimulationStartupMs: e.simStartupMs, TokensPerSecond: e.tokensPerSec}
+	if count > 0 {
+		score = score / float64(count)
+	}
+	return score
 }
 
-func getEnv(key, def string) string {
-	v := os.Getenv(key)
-	if v == "" {
-		return def
+// Metrics returns the latest completed run's figures, all from that one run
+// however many overlapped it, and limiter counters, plus the newest recent
+// records of the run history (all if recent <= 0), each run's own, with
+// aggregates over the whole history.
+func (e *Engine) Metrics(recent int) types.MetricsSnapshot {
+	m := types.MetricsSnapshot{Simulators: e.simStats.Snapshot(), Counters: e.counters.Snapshot()}
+	m.Counters.RunsResident = int64(e.registry.Resident())
+	if latest := e.latest.Load(); latest != nil {
+		m.PhaseTimings = latest.phases
+		m.SimulationStartupMs = latest.phases.SimulationPhaseMs
+		m.TokensPerSecond = latest.tokensPerSec
+		m.SimulatorLatencyMs = latest.simLatencyMs
+		m.SimulatorStartupMs = latest.simWarmupMs
+	}
+	if runs := e.history.Latest(0); len(runs) > 0 {
+		agg := metrics.Aggregate(runs)
+		m.Aggregates = &agg
+		if recent > 0 && recent < len(runs) {
+			runs = runs[:recent]
+		}
+		m.Runs = runs
+	}
+	if lr, ok := e.llm.(llm.LimitReporter); ok {
+		stats := lr.LimiterStats()
+		m.LLMDelayedCalls = stats.Delayed
+		m.LLMRejectedCalls = stats.Rejected
 	}
-	return v
+	return m
+}
+
+// SimulatorStats returns each simulator's call statistics since startup.
+func (e *Engine) SimulatorStats() []types.SimulatorStats {
+	return e.simStats.Snapshot()
+}
+
+// PollSimulators probes the simulators' health in the background until ctx
+// is done (SIMULATOR_HEALTH_INTERVAL; never when it is 0). Runs don't wait
+// on it.
+func (e *Engine) PollSimulators(ctx context.Context) {
+	e.health.Run(ctx)
+}
+
+// PingStore checks the run store's database can be reached; the in-memory
+// store always can.
+func (e *Engine) PingStore(ctx context.Context) error {
+	return e.registry.Ping(ctx)
+}
+
+// SimulatorHealth returns each simulator's status as of its latest probe,
+// without probing.
+func (e *Engine) SimulatorHealth() []types.SimulatorHealth {
+	return e.health.Snapshot()
 }
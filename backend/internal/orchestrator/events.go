@@ -0,0 +1,55 @@
+package orchestrator
+
+import (
+	"context"
+
+	"simstack/internal/clock"
+	"simstack/internal/logging"
+	"simstack/internal/types"
+)
+
+// runEvents emits one run's events, each tagged with the run's ID and, once
+// planning has picked one, its plan ID, so clients can tell concurrent runs
+// apart.
+type runEvents struct {
+	emit  func(v any)
+	clock clock.Clock
+	runID string
+	// Of the request that started the run, if it had one
+	requestID string
+	// Told of every event; once it has failed the run, events are dropped
+	watchdog *runWatchdog
+	// Set once, before the variants are dispatched
+	planID string
+}
+
+// newRunEvents returns the emitter for run runID, started by the request
+// whose ID ctx carries.
+func (e *Engine) newRunEvents(ctx context.Context, runID string) *runEvents {
+	return &runEvents{emit: e.emit, clock: e.clock, runID: runID, requestID: logging.RequestID(ctx)}
+}
+
+func (r *runEvents) send(typ string, payload any) {
+	if r.watchdog.stalled() {
+		return
+	}
+	r.watchdog.sent(typ)
+	ev := types.NewEventAt(r.clock.Now(), typ, payload)
+	ev.RunID, ev.PlanID, ev.RequestID = r.runID, r.planID, r.requestID
+	r.emit(ev)
+}
+
+type runEventsKey struct{}
+
+func withRunEvents(ctx context.Context, r *runEvents) context.Context {
+	return context.WithValue(ctx, runEventsKey{}, r)
+}
+
+// eventsFrom returns the emitter of the run ctx belongs to, or an untagged
+// one outside a run.
+func (e *Engine) eventsFrom(ctx context.Context) *runEvents {
+	if r, ok := ctx.Value(runEventsKey{}).(*runEvents); ok {
+		return r
+	}
+	return &runEvents{emit: e.emit, clock: e.clock}
+}
@@ -0,0 +1,323 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"simstack/internal/artifacts"
+	"simstack/internal/compose"
+	"simstack/internal/paramfile"
+	"simstack/internal/reproscript"
+	"simstack/internal/types"
+)
+
+// Export formats of /api/export.
+const (
+	FormatCompose    = "compose"
+	FormatEnv        = "env"
+	FormatJSONParams = "json-params"
+	FormatScript     = "script"
+)
+
+// ExportContentTypes maps each export format to the media type it is served
+// as.
+var ExportContentTypes = map[string]string{
+	FormatCompose:    "application/x-yaml",
+	FormatEnv:        "text/plain; charset=utf-8",
+	FormatJSONParams: "application/json",
+	FormatScript:     "text/x-shellscript; charset=utf-8",
+}
+
+// ErrNoWinner marks exports of a run that picked no winner and named no
+// variant.
+var ErrNoWinner = errors.New("run has no winning variant")
+
+// ExportFile is an exported configuration, ready to download.
+type ExportFile struct {
+	Content     []byte
+	Filename    string
+	ContentType string
+}
+
+// Export writes a configuration in req.Format (compose by default). With a
+// RunID, the parameters are those of the run's VariantID, or of its winner,
+// overlaid with req.Parameters; otherwise they are req.Parameters alone.
+func (e *Engine) Export(ctx context.Context, req types.ExportRequest) (ExportFile, error) {
+	format := req.Format
+	if format == "" {
+		format = FormatCompose
+	}
+	if _, ok := ExportContentTypes[format]; !ok {
+		return ExportFile{}, fmt.Errorf("%w: unknown export format %q", ErrInvalidRequest, format)
+	}
+
+	if req.TopK < 0 {
+		return ExportFile{}, fmt.Errorf("%w: top_k must not be negative", ErrInvalidRequest)
+	}
+	if req.Profiles || req.TopK > 0 {
+		switch {
+		case format != FormatCompose:
+			return ExportFile{}, fmt.Errorf("%w: profiles are only exported as compose", ErrInvalidRequest)
+		case req.RunID == "":
+			return ExportFile{}, fmt.Errorf("%w: profiles need a run_id", ErrInvalidRequest)
+		case req.VariantID != "":
+			return ExportFile{}, fmt.Errorf("%w: profiles export several variants, not variant_id", ErrInvalidRequest)
+		}
+		return e.exportProfiles(ctx, req)
+	}
+
+	if format == FormatScript {
+		switch {
+		case req.RunID == "":
+			return ExportFile{}, fmt.Errorf("%w: a script reproduces a run and needs its run_id", ErrInvalidRequest)
+		case len(req.Parameters) > 0:
+			return ExportFile{}, fmt.Errorf("%w: a script reproduces the recorded parameters, which parameters would change", ErrInvalidRequest)
+		case req.Tolerance < 0:
+			return ExportFile{}, fmt.Errorf("%w: tolerance must not be negative", ErrInvalidRequest)
+		}
+	}
+
+	params, goal, variantID := req.Parameters, req.Goal, req.VariantID
+	var run types.RunRecord
+	if req.RunID != "" {
+		var err error
+		if run, err = e.store.Get(ctx, req.RunID); err != nil {
+			return ExportFile{}, err
+		}
+		if variantID == "" {
+			variantID = run.Winner
+		}
+		if variantID == "" {
+			return ExportFile{}, fmt.Errorf("%w: %s", ErrNoWinner, run.ID)
+		}
+		variant, ok := findVariant(run, variantID)
+		if !ok {
+			return ExportFile{}, fmt.Errorf("%w: run %s has no variant %q", ErrInvalidRequest, run.ID, variantID)
+		}
+		params = overlay(variant.Parameters, req.Parameters)
+		if goal == "" {
+			goal = run.Goal
+		}
+	} else if variantID != "" {
+		return ExportFile{}, fmt.Errorf("%w: variant_id needs a run_id", ErrInvalidRequest)
+	}
+
+	file := ExportFile{ContentType: ExportContentTypes[format]}
+	name := "simstack"
+	if variantID != "" {
+		name += "-" + artifacts.SanitizeName(variantID)
+	}
+	cfg := paramfile.Config{RunID: req.RunID, VariantID: variantID, Goal: goal}
+	cfg.Tools, cfg.Other = e.splitParams(params)
+	switch format {
+	case FormatCompose:
+		limits := e.config()
+		opts := compose.Options{Params: cfg, CPUs: limits.ExportCPUs, MemoryBytes: limits.ExportMemoryBytes, Backend: req.IncludeBackend}
+		content, err := compose.Marshal(compose.Build(opts), opts)
+		if err != nil {
+			return ExportFile{}, err
+		}
+		file.Content, file.Filename = content, name+"-compose.yml"
+	case FormatEnv:
+		file.Content, file.Filename = paramfile.Env(cfg), name+".env"
+	case FormatJSONParams:
+		content, err := paramfile.JSON(cfg)
+		if err != nil {
+			return ExportFile{}, err
+		}
+		file.Content, file.Filename = content, name+".params.json"
+	case FormatScript:
+		content, err := e.reproductionScript(run, cfg, req.Tolerance)
+		if err != nil {
+			return ExportFile{}, err
+		}
+		file.Content, file.Filename = content, "reproduce-"+name+".sh"
+	}
+	return file, nil
+}
+
+// reproductionScript writes the script re-measuring cfg's variant of run.
+// Each simulator is checked against the metrics recorded under its prefix;
+// metrics the catalog defines are the headline ones.
+func (e *Engine) reproductionScript(run types.RunRecord, cfg paramfile.Config, tolerance float64) ([]byte, error) {
+	var recorded map[string]float64
+	for _, r := range run.Results {
+		if r.VariantID == cfg.VariantID && r.Status != types.ResultFailed {
+			recorded = r.Metrics
+		}
+	}
+	if len(recorded) == 0 {
+		return nil, fmt.Errorf("%w: run %s recorded no metrics for %s", ErrInvalidRequest, run.ID, cfg.VariantID)
+	}
+	c := reproscript.Config{RunID: run.ID, VariantID: cfg.VariantID, Goal: cfg.Goal, Tolerance: tolerance}
+	if run.Manifest != nil {
+		c.LLMSeed = run.Manifest.Seed
+	}
+	// The variant's own seed went to the simulators; the LLM's never did
+	if v, ok := cfg.Other["seed"]; ok {
+		if seed, _, err := (toolParam{name: "seed"}).integer(v); err == nil {
+			s := int64(seed.(int))
+			c.Seed = &s
+		}
+	}
+	for _, sim := range compose.Simulators {
+		params, ok := cfg.Tools[sim.Tool]
+		if !ok {
+			continue
+		}
+		tool := reproscript.Tool{Name: sim.Tool, URL: fmt.Sprintf("http://localhost:%d", sim.HostPort), Params: params}
+		for _, name := range sortedKeys(recorded) {
+			if metric, ok := strings.CutPrefix(name, sim.Tool+"_"); ok {
+				_, headline := e.metrics.Lookup(name)
+				tool.Metrics = append(tool.Metrics, reproscript.Metric{Name: metric, Recorded: recorded[name], Headline: headline})
+			}
+		}
+		c.Tools = append(c.Tools, tool)
+	}
+	return reproscript.Script(c)
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// exportProfiles writes a compose file of several of a run's variants, each
+// under its profile: the req.TopK best by heuristic score (the winner first),
+// or the Pareto front of the run's results.
+func (e *Engine) exportProfiles(ctx context.Context, req types.ExportRequest) (ExportFile, error) {
+	run, err := e.store.Get(ctx, req.RunID)
+	if err != nil {
+		return ExportFile{}, err
+	}
+	var ids []string
+	if req.TopK > 0 {
+		ids = e.rankVariants(run)
+		if len(ids) > req.TopK {
+			ids = ids[:req.TopK]
+		}
+	} else {
+		ids = e.metrics.ParetoFront(run.Results)
+	}
+	if len(ids) == 0 && run.Winner != "" {
+		ids = []string{run.Winner}
+	}
+	if len(ids) == 0 {
+		return ExportFile{}, fmt.Errorf("%w: %s has no successful variants", ErrNoWinner, run.ID)
+	}
+
+	goal := req.Goal
+	if goal == "" {
+		goal = run.Goal
+	}
+	limits := e.config()
+	opts := compose.Options{
+		Params:      paramfile.Config{RunID: run.ID, Goal: goal},
+		CPUs:        limits.ExportCPUs,
+		MemoryBytes: limits.ExportMemoryBytes,
+		Backend:     req.IncludeBackend,
+	}
+	taken := map[string]bool{}
+	for _, id := range ids {
+		variant, ok := findVariant(run, id)
+		if !ok {
+			continue
+		}
+		name := compose.ProfileName(run.PlanID, id)
+		for base, i := name, 2; taken[name]; i++ {
+			name = fmt.Sprintf("%s-%d", base, i)
+		}
+		taken[name] = true
+		cfg := paramfile.Config{RunID: run.ID, VariantID: id, Goal: goal}
+		cfg.Tools, cfg.Other = e.splitParams(overlay(variant.Parameters, req.Parameters))
+		opts.Variants = append(opts.Variants, compose.Profile{Name: name, Params: cfg})
+	}
+	if len(opts.Variants) == 0 {
+		return ExportFile{}, fmt.Errorf("%w: run %s has no plan for its variants", ErrInvalidRequest, run.ID)
+	}
+	content, err := compose.Marshal(compose.Build(opts), opts)
+	if err != nil {
+		return ExportFile{}, err
+	}
+	return ExportFile{
+		Content:     content,
+		Filename:    "simstack-" + artifacts.SanitizeName(run.ID) + "-profiles-compose.yml",
+		ContentType: ExportContentTypes[FormatCompose],
+	}, nil
+}
+
+// rankVariants orders the IDs of a run's successful results best first: the
+// winner, then by heuristic score.
+func (e *Engine) rankVariants(run types.RunRecord) []string {
+	var ranked []types.SimulationResult
+	for _, r := range run.Results {
+		if r.Status != types.ResultFailed {
+			ranked = append(ranked, r)
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if wi, wj := ranked[i].VariantID == run.Winner, ranked[j].VariantID == run.Winner; wi != wj {
+			return wi
+		}
+		return e.heuristicScore(ranked[i]) > e.heuristicScore(ranked[j])
+	})
+	ids := make([]string, len(ranked))
+	for i, r := range ranked {
+		ids[i] = r.VariantID
+	}
+	return ids
+}
+
+// overlay returns base with over's entries added or replaced.
+func overlay(base, over map[string]any) map[string]any {
+	params := make(map[string]any, len(base)+len(over))
+	for k, v := range base {
+		params[k] = v
+	}
+	for k, v := range over {
+		params[k] = v
+	}
+	return params
+}
+
+func findVariant(run types.RunRecord, id string) (types.Variant, bool) {
+	if run.Plan != nil {
+		for _, v := range run.Plan.Variants {
+			if v.VariantID == id {
+				return v, true
+			}
+		}
+	}
+	return types.Variant{}, false
+}
+
+// splitParams groups params by the tools that take them, as each simulator
+// is sent them; other holds what no tool takes.
+func (e *Engine) splitParams(params map[string]any) (tools map[string]map[string]any, other map[string]any) {
+	tools = map[string]map[string]any{}
+	claimed := map[string]bool{}
+	for tool := range toolFields {
+		if p, _, _ := e.extractToolParams(params, tool); len(p) > 0 {
+			tools[tool] = p
+			for k := range p {
+				claimed[k] = true
+			}
+		}
+	}
+	for k, v := range params {
+		if !claimed[k] {
+			if other == nil {
+				other = map[string]any{}
+			}
+			other[k] = v
+		}
+	}
+	return tools, other
+}
@@ -0,0 +1,109 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"simstack/internal/clock"
+	"simstack/internal/config"
+	"simstack/internal/types"
+)
+
+// endpoint is one instance of a simulator and the key of its breaker: the
+// tool's name for the primary, so its statistics stay where they were, and
+// tool@url for a backup.
+type endpoint struct {
+	url     string
+	breaker string
+}
+
+// simulatorEndpoints appends tool's instances to endpoints in the order
+// calls try them: the primary at baseURL, then cfg's backups.
+func simulatorEndpoints(endpoints []endpoint, cfg *config.Config, tool, baseURL string) []endpoint {
+	endpoints = append(endpoints, endpoint{url: baseURL, breaker: tool})
+	for _, u := range cfg.SimulatorBackupURLs[tool] {
+		endpoints = append(endpoints, endpoint{url: u, breaker: tool + "@" + u})
+	}
+	return endpoints
+}
+
+// callSimulator calls tool's primary at baseURL or, while the primary's
+// breaker is open, the first backup whose breaker lets it. A failure that
+// opens an instance's breaker moves the call on to the next instance. call
+// covers every attempt, and has none when every breaker refused; elapsed is
+// the time they took.
+func (e *Engine) callSimulator(ctx context.Context, cfg *config.Config, tool, baseURL string, params map[string]any) (metrics map[string]float64, raw []byte, call types.ToolTiming, elapsed time.Duration, err error) {
+	call.Tool = tool
+	// Room for the primary and a few backups without allocating
+	var room [4]endpoint
+	for _, ep := range simulatorEndpoints(room[:0], cfg, tool, baseURL) {
+		trial := !e.simStats.Closed(ep.breaker)
+		if !e.simStats.Allow(ep.breaker) {
+			continue
+		}
+		if ep.url != baseURL {
+			e.routeTo(tool, baseURL, ep.url)
+		}
+
+		// Create independent context for each simulator call
+		// Use a shorter timeout (45s by default) than the variant's
+		simCtx, simCancel := clock.WithTimeout(ctx, e.clock, cfg.SimulatorTimeout)
+		start := e.clock.Now()
+		metrics, raw, err = e.invokeSimulator(simCtx, tool, ep.url, params)
+		d := e.clock.Now().Sub(start)
+		simCancel() // Always cancel to free resources
+		elapsed += d
+		call.Attempts++
+		call.Endpoint = ep.url
+		// Left out of the tool's statistics if canceled, though a trial call
+		// it held is freed
+		e.simStats.Record(ep.breaker, d, err)
+		if err != nil && errors.Is(ctx.Err(), context.Canceled) {
+			break // The run was canceled, not the simulator's fault
+		}
+		e.counters.SimulatorCalled(tool, d, err)
+		if err == nil {
+			if trial && ep.url == baseURL {
+				// The primary answered its breaker's trial call
+				e.routeTo(tool, baseURL, baseURL)
+			}
+			break
+		}
+		if e.simStats.Closed(ep.breaker) {
+			break // Not failing often enough to give up on it yet
+		}
+	}
+	call.DurationMs = elapsed.Milliseconds()
+	if err != nil {
+		call.Failed, call.Error = true, err.Error()
+	}
+	return metrics, raw, call, elapsed, err
+}
+
+// routeTo notes that tool's calls now go to url, and announces it when they
+// went elsewhere before: to a backup, or back to the primary at primary.
+// Of concurrent calls making the same move, only one announces it.
+func (e *Engine) routeTo(tool, primary, url string) {
+	from := primary
+	if prev, ok := e.routes.Swap(tool, url); ok {
+		from = prev.(string)
+	}
+	if from == url {
+		return
+	}
+	slog.Warn("simulator failed over", "tool", tool, "from", from, "to", url)
+	e.emit(types.NewEventAt(e.clock.Now(), types.EventFailover, types.FailoverEvent{Tool: tool, From: from, To: url, Primary: url == primary}))
+}
+
+// primaryRecovered sends tool's calls back to its primary at url when the
+// health poller finds it up while they go to a backup, without waiting out
+// the primary's breaker.
+func (e *Engine) primaryRecovered(tool, url string) {
+	if route, ok := e.routes.Load(tool); !ok || route == url {
+		return
+	}
+	e.simStats.Reset(tool)
+	e.routeTo(tool, url, url)
+}
@@ -0,0 +1,91 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"simstack/internal/config"
+	"simstack/internal/eventbus"
+	"simstack/internal/types"
+)
+
+func TestSimulatorFailsOverToBackup(t *testing.T) {
+	var dead atomic.Bool
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if dead.Load() {
+			// Gone mid-run: the connection drops without an answer
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		fmt.Fprint(w, `{"metrics": {"utilization": 0.5}}`)
+	}))
+	defer primary.Close()
+	backup := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"metrics": {"utilization": 0.9}}`)
+	}))
+	defer backup.Close()
+
+	cfg, _ := config.Load()
+	cfg.SimulatorURLs = map[string]string{"queue": primary.URL}
+	cfg.SimulatorBackupURLs = map[string][]string{"queue": {backup.URL}}
+	cfg.SimulatorCacheMaxEntries = 0
+	cfg.BreakerThreshold = 1
+	cfg.BreakerCooldown = time.Hour
+	cfg.HealthInterval = 0
+	rec := &recorder{}
+	e := NewEngine(eventbus.Direct(rec.emit), WithConfig(cfg))
+
+	dispatch := func(n int) []types.SimulationResult {
+		variants := orderVariants(n)
+		for i := range variants {
+			variants[i].Parameters = map[string]any{"arrival_rate": float64(i + 1), "service_rate": 12.0}
+		}
+		results := e.dispatchVariants(context.Background(), &cfg, types.SimulationPlan{Variants: variants})
+		if len(results) != n {
+			t.Fatalf("expected %d results, got %d", n, len(results))
+		}
+		return results
+	}
+	servedBy := func(results []types.SimulationResult, want string, utilization float64) {
+		t.Helper()
+		for _, r := range results {
+			if r.Status != types.ResultComplete || r.Metrics["queue_utilization"] != utilization {
+				t.Errorf("expected %s answered from %s, got %+v", r.VariantID, want, r)
+				continue
+			}
+			if calls := r.Timing.Calls; len(calls) != 1 || calls[0].Endpoint != want {
+				t.Errorf("expected %s's call served by %s, got %+v", r.VariantID, want, calls)
+			}
+		}
+	}
+
+	servedBy(dispatch(2), primary.URL, 0.5)
+	if got := rec.ofType(types.EventFailover); len(got) != 0 {
+		t.Fatalf("expected no failover while the primary answers, got %+v", got)
+	}
+
+	dead.Store(true)
+	servedBy(dispatch(6), backup.URL, 0.9)
+	got := rec.ofType(types.EventFailover)
+	if len(got) != 1 {
+		t.Fatalf("expected the failover announced once, got %+v", got)
+	}
+	if ev := got[0].Payload.(types.FailoverEvent); ev.Tool != "queue" || ev.From != primary.URL || ev.To != backup.URL || ev.Primary {
+		t.Errorf("unexpected failover %+v", ev)
+	}
+
+	// The health poller finds the primary back
+	dead.Store(false)
+	e.primaryRecovered("queue", primary.URL)
+	servedBy(dispatch(2), primary.URL, 0.5)
+	got = rec.ofType(types.EventFailover)
+	if len(got) != 2 || !got[1].Payload.(types.FailoverEvent).Primary {
+		t.Errorf("expected the return to the primary announced, got %+v", got)
+	}
+}
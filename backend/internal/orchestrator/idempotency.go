@@ -0,0 +1,75 @@
+package orchestrator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"simstack/internal/runstore"
+)
+
+// ErrIdempotencyConflict marks an Idempotency-Key sent again with a
+// different request than the one it started a run for.
+var ErrIdempotencyConflict = errors.New("idempotency key reused with a different request")
+
+// maxIdempotencyKey bounds the keys clients may send.
+const maxIdempotencyKey = 255
+
+// ClaimIdempotencyKey ties key to runID, the run body is about to start, for
+// SIMSTACK_IDEMPOTENCY_WINDOW, and returns runID. When key already names a
+// run within the window, it returns that run's ID instead, so the caller
+// starts nothing; a different body with the key fails with
+// ErrIdempotencyConflict. With the window at 0 every key is ignored.
+func (e *Engine) ClaimIdempotencyKey(ctx context.Context, key string, body []byte, runID string) (string, error) {
+	claim, now, err := e.idempotencyClaim(key, body, runID)
+	if err != nil || claim.Key == "" {
+		return runID, err
+	}
+	held, claimed, err := e.store.ClaimKey(ctx, claim, now)
+	if err != nil || claimed {
+		return runID, err
+	}
+	return heldRun(held, claim)
+}
+
+// IdempotentRun returns the run key already names within
+// SIMSTACK_IDEMPOTENCY_WINDOW, or "" when it names none, without claiming
+// it, so a retry finds its run before asking for a place in the queue. A
+// different body with the key fails with ErrIdempotencyConflict.
+func (e *Engine) IdempotentRun(ctx context.Context, key string, body []byte) (string, error) {
+	claim, now, err := e.idempotencyClaim(key, body, "")
+	if err != nil || claim.Key == "" {
+		return "", err
+	}
+	held, ok, err := e.store.HeldKey(ctx, key, now)
+	if err != nil || !ok {
+		return "", err
+	}
+	return heldRun(held, claim)
+}
+
+// idempotencyClaim is the claim of key for body's run runID, and the time
+// it is made; a zero claim when keys are ignored.
+func (e *Engine) idempotencyClaim(key string, body []byte, runID string) (runstore.KeyClaim, time.Time, error) {
+	window := e.config().IdempotencyWindow
+	if window <= 0 {
+		return runstore.KeyClaim{}, time.Time{}, nil
+	}
+	if len(key) > maxIdempotencyKey {
+		return runstore.KeyClaim{}, time.Time{}, fmt.Errorf("%w: Idempotency-Key is longer than %d characters", ErrInvalidRequest, maxIdempotencyKey)
+	}
+	sum := sha256.Sum256(body)
+	now := e.clock.Now()
+	return runstore.KeyClaim{Key: key, BodyHash: hex.EncodeToString(sum[:]), RunID: runID, Expires: now.Add(window)}, now, nil
+}
+
+// heldRun is the run of held, an earlier claim of claim's key.
+func heldRun(held, claim runstore.KeyClaim) (string, error) {
+	if held.BodyHash != claim.BodyHash {
+		return held.RunID, fmt.Errorf("%w: run %s was started with it", ErrIdempotencyConflict, held.RunID)
+	}
+	return held.RunID, nil
+}
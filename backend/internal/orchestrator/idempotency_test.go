@@ -0,0 +1,47 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"simstack/internal/config"
+	"simstack/internal/testsupport"
+)
+
+func TestClaimIdempotencyKey(t *testing.T) {
+	cfg, _ := config.Load()
+	cfg.IdempotencyWindow = time.Hour
+	clk := testsupport.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	e := NewEngine(nil, WithConfig(cfg), WithChatClient(testsupport.NewFakeChat(), "m"), WithClock(clk))
+	ctx := context.Background()
+	body := []byte(`{"goal": "g"}`)
+
+	if id, err := e.ClaimIdempotencyKey(ctx, "k", body, "run-1"); err != nil || id != "run-1" {
+		t.Fatalf("expected the key claimed for run-1, got %q %v", id, err)
+	}
+	if id, err := e.ClaimIdempotencyKey(ctx, "k", body, "run-2"); err != nil || id != "run-1" {
+		t.Errorf("expected a retry to get run-1, got %q %v", id, err)
+	}
+	if _, err := e.ClaimIdempotencyKey(ctx, "k", []byte(`{"goal": "other"}`), "run-3"); !errors.Is(err, ErrIdempotencyConflict) || !strings.Contains(err.Error(), "run-1") {
+		t.Errorf("expected a different body refused, got %v", err)
+	}
+	if _, err := e.ClaimIdempotencyKey(ctx, strings.Repeat("k", 256), body, "run-4"); !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("expected an overlong key refused, got %v", err)
+	}
+
+	clk.Advance(time.Hour)
+	if id, err := e.ClaimIdempotencyKey(ctx, "k", []byte(`{"goal": "other"}`), "run-5"); err != nil || id != "run-5" {
+		t.Errorf("expected the key free once the window passed, got %q %v", id, err)
+	}
+
+	cfg.IdempotencyWindow = 0
+	e = NewEngine(nil, WithConfig(cfg), WithChatClient(testsupport.NewFakeChat(), "m"))
+	for _, runID := range []string{"run-6", "run-7"} {
+		if id, err := e.ClaimIdempotencyKey(ctx, "k", body, runID); err != nil || id != runID {
+			t.Errorf("expected keys ignored with no window, got %q %v", id, err)
+		}
+	}
+}
@@ -0,0 +1,351 @@
+package orchestrator
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"simstack/internal/types"
+)
+
+// liveRun collects a run's results as variants complete, for followers of
+// the run that can't wait for the stored record.
+type liveRun struct {
+	mu       sync.Mutex
+	phase    types.RunPhase
+	plan     *types.SimulationPlan
+	planID   string
+	variants int
+	results  []types.SimulationResult
+	done     bool
+	// Closed and replaced whenever anything above changes
+	changed chan struct{}
+}
+
+func newLiveRun() *liveRun {
+	return &liveRun{phase: types.PhasePlanning, changed: make(chan struct{})}
+}
+
+func (l *liveRun) update(fn func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fn()
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// setPlan records the run's plan, which starts its simulations.
+func (l *liveRun) setPlan(plan types.SimulationPlan) {
+	l.update(func() {
+		l.plan, l.planID, l.variants, l.phase = &plan, plan.PlanID, len(plan.Variants), types.PhaseSimulating
+	})
+}
+
+func (l *liveRun) setPhase(phase types.RunPhase) {
+	l.update(func() { l.phase = phase })
+}
+
+func (l *liveRun) add(r types.SimulationResult) {
+	l.update(func() { l.results = append(l.results, r) })
+}
+
+func (l *liveRun) finish() {
+	l.update(func() { l.done = true })
+}
+
+// since returns the results after the first n, whether the run is done, and
+// a channel closed on the next change.
+func (l *liveRun) since(n int) ([]types.SimulationResult, bool, <-chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.results[n:len(l.results):len(l.results)], l.done, l.changed
+}
+
+// liveRunOf returns the live state of run id while it is in flight, and for
+// the grace period after.
+func (e *Engine) liveRunOf(id string) (*liveRun, bool) {
+	l, ok := e.live.Load(id)
+	if !ok {
+		return nil, false
+	}
+	return l.(*liveRun), true
+}
+
+// releaseLive keeps a finished run's live results for a grace period
+// (SIMSTACK_RUN_REPLAY_GRACE), so followers arriving as it ends still find
+// them, then drops them; later reads are served from the stored record.
+func (e *Engine) releaseLive(id string, live *liveRun) {
+	release := func() {
+		if e.live.CompareAndDelete(id, live) {
+			e.counters.ReplayBuffersReleased.Add(1)
+		}
+	}
+	if grace := e.config().RunReplayGrace; grace > 0 {
+		e.clock.AfterFunc(grace, release)
+		return
+	}
+	release()
+}
+
+// completionRetry is how long WatchCompletions waits before listening again
+// after the store's completion feed fails.
+const completionRetry = 5 * time.Second
+
+// finishedRuns wakes results followers waiting on runs in flight on other
+// replicas as the shared store reports them finished.
+type finishedRuns struct {
+	mu      sync.Mutex
+	waiting map[string]*finishWait
+}
+
+type finishWait struct {
+	ch        chan struct{}
+	followers int
+}
+
+// wait returns a channel closed when run id is reported finished, and a
+// func to call once the caller stops waiting.
+func (f *finishedRuns) wait(id string) (<-chan struct{}, func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.waiting == nil {
+		f.waiting = map[string]*finishWait{}
+	}
+	w, ok := f.waiting[id]
+	if !ok {
+		w = &finishWait{ch: make(chan struct{})}
+		f.waiting[id] = w
+	}
+	w.followers++
+	return w.ch, func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if w.followers--; w.followers == 0 && f.waiting[id] == w {
+			delete(f.waiting, id)
+		}
+	}
+}
+
+// finish wakes the followers waiting on run id.
+func (f *finishedRuns) finish(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if w, ok := f.waiting[id]; ok {
+		close(w.ch)
+		delete(f.waiting, id)
+	}
+}
+
+// WatchCompletions follows the shared run store's feed of finished runs
+// until ctx is done, so results followers of a run in flight on another
+// replica get its results once it finishes. It returns at once when the
+// store is not shared.
+func (e *Engine) WatchCompletions(ctx context.Context) {
+	if e.completer == nil {
+		return
+	}
+	for {
+		ids, err := e.completer.Completed(ctx)
+		if err == nil {
+			for id := range ids {
+				e.finished.finish(id)
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		slog.Warn("run store: completion feed lost, listening again", "err", err, "retry", completionRetry)
+		select {
+		case <-e.clock.After(completionRetry):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// RunProgress returns where run id has got to: its phase and variant counts
+// from the live state while it is in flight, and from the stored record
+// once it has finished.
+func (e *Engine) RunProgress(ctx context.Context, id string) (types.RunProgress, error) {
+	live, inFlight := e.liveRunOf(id)
+	run, err := e.store.Get(ctx, id)
+	if err != nil {
+		return types.RunProgress{}, err
+	}
+	p := types.RunProgress{RunID: run.ID, PlanID: run.PlanID, StartedAt: run.StartedAt}
+	if inFlight {
+		live.mu.Lock()
+		if !live.done {
+			p.Phase, p.PlanID = live.phase, live.planID
+			p.VariantsCompleted, p.VariantsTotal = len(live.results), live.variants
+		}
+		live.mu.Unlock()
+	}
+	if p.Phase == "" {
+		// Finished, so the record read after the live state is final, or
+		// in flight in another process
+		if final, err := e.store.Get(ctx, id); err == nil {
+			run = final
+		}
+		p.PlanID, p.FinishedAt = run.PlanID, run.FinishedAt
+		p.Phase = recordPhase(run.Status)
+		p.VariantsCompleted = len(run.Results)
+		if run.Plan != nil {
+			p.VariantsTotal = len(run.Plan.Variants)
+		}
+	}
+	end := e.clock.Now()
+	if p.FinishedAt != nil {
+		end = *p.FinishedAt
+	}
+	p.ElapsedMs = end.Sub(p.StartedAt).Milliseconds()
+	return p, nil
+}
+
+// RunResults returns run id's plan, results and analysis: the stored ones
+// once it has finished, and the results so far while it is in flight.
+func (e *Engine) RunResults(ctx context.Context, id string) (types.RunResults, error) {
+	live, inFlight := e.liveRunOf(id)
+	run, err := e.store.Get(ctx, id)
+	if err != nil {
+		return types.RunResults{}, err
+	}
+	if inFlight {
+		live.mu.Lock()
+		done := live.done
+		if !done {
+			run.Plan, run.PlanID = live.plan, live.planID
+			run.Results = append([]types.SimulationResult(nil), live.results...)
+		}
+		live.mu.Unlock()
+		if done {
+			if final, err := e.store.Get(ctx, id); err == nil {
+				run = final
+			}
+		}
+	}
+	out := types.RunResults{
+		RunID:    run.ID,
+		PlanID:   run.PlanID,
+		Status:   run.Status,
+		Complete: run.FinishedAt != nil,
+		Plan:     run.Plan,
+		Results:  run.Results,
+		Analysis: run.Analysis,
+	}
+	if out.Complete {
+		out.Distributions = e.distributions(e.config(), run.Results)
+	}
+	if out.Results == nil {
+		out.Results = []types.SimulationResult{}
+	}
+	return out, nil
+}
+
+// recordPhase is the phase of a run whose stored record has status.
+func recordPhase(status string) types.RunPhase {
+	switch status {
+	case outcomeCompleted:
+		return types.PhaseDone
+	case outcomeFailed:
+		return types.PhaseFailed
+	case outcomeCanceled:
+		return types.PhaseCancelled
+	}
+	return types.PhaseRunning
+}
+
+// StreamResults sends run id's results stream: a header, each result, and a
+// summary. Finished runs send their stored results. For a run in flight it
+// sends the results so far, then with follow keeps sending them as variants
+// complete until the run is done. With follow, a run in flight on another
+// replica sharing the store sends its results once that replica reports it
+// finished (see WatchCompletions). It returns early with ctx's error or the
+// first error from send; an unknown run fails before anything is sent.
+func (e *Engine) StreamResults(ctx context.Context, id string, follow bool, send func(types.ResultsLine) error) error {
+	// Waiting before the lookup, so a run finishing elsewhere in between
+	// still wakes the stream
+	var finished <-chan struct{}
+	if follow && e.completer != nil {
+		ch, stop := e.finished.wait(id)
+		defer stop()
+		finished = ch
+	}
+	// Live state first: a run that finishes after this lookup has still
+	// saved its record before it is marked done
+	live, inFlight := e.liveRunOf(id)
+	run, err := e.store.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	header := types.ResultsHeader{RunID: run.ID, PlanID: run.PlanID, Goal: run.Goal, Status: run.Status, StartedAt: run.StartedAt, Metrics: e.metrics.Defs()}
+	if run.Plan != nil {
+		header.Variants = len(run.Plan.Variants)
+	}
+	if inFlight {
+		live.mu.Lock()
+		header.PlanID, header.Variants = live.planID, live.variants
+		live.mu.Unlock()
+	}
+	if err := send(types.ResultsLine{Header: &header}); err != nil {
+		return err
+	}
+
+	if !inFlight {
+		if finished != nil && run.FinishedAt == nil {
+			select {
+			case <-finished:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			if final, err := e.store.Get(ctx, id); err == nil {
+				run = final
+			}
+		}
+		for i := range run.Results {
+			if err := send(types.ResultsLine{Result: &run.Results[i]}); err != nil {
+				return err
+			}
+		}
+		return send(types.ResultsLine{Summary: e.resultsSummary(run, len(run.Results))})
+	}
+
+	sent := 0
+	for {
+		results, done, changed := live.since(sent)
+		for i := range results {
+			if err := send(types.ResultsLine{Result: &results[i]}); err != nil {
+				return err
+			}
+		}
+		sent += len(results)
+		if done {
+			break
+		}
+		if !follow {
+			return send(types.ResultsLine{Summary: &types.ResultsSummary{RunID: run.ID, Status: run.Status, Results: sent}})
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if final, err := e.store.Get(ctx, id); err == nil {
+		run = final
+	}
+	return send(types.ResultsLine{Summary: e.resultsSummary(run, sent)})
+}
+
+// resultsSummary closes a finished run's stream, charting its results.
+func (e *Engine) resultsSummary(run types.RunRecord, results int) *types.ResultsSummary {
+	return &types.ResultsSummary{
+		RunID:         run.ID,
+		Status:        run.Status,
+		Winner:        run.Winner,
+		Results:       results,
+		FinishedAt:    run.FinishedAt,
+		Distributions: e.distributions(e.config(), run.Results),
+	}
+}
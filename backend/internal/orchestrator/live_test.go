@@ -0,0 +1,145 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"simstack/internal/config"
+	"simstack/internal/runstore"
+	"simstack/internal/testsupport"
+	"simstack/internal/types"
+)
+
+func TestRunProgress(t *testing.T) {
+	var calls atomic.Int64
+	release := make(chan struct{})
+	sim := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		// One variant finishes at once; the rest wait to be let go
+		if calls.Add(1) > 1 {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+				return
+			}
+		}
+		fmt.Fprint(w, `{"metrics": {"utilization": 0.5}}`)
+	}))
+	defer sim.Close()
+	cfg, _ := config.Load()
+	cfg.SimulatorURLs = map[string]string{"queue": sim.URL}
+	cfg.SimulatorWarmup = false
+	store := runstore.NewMemory()
+	e := NewEngine(nil, WithConfig(cfg), WithChatClient(testsupport.NewFakeChat(), "m"), WithRunStore(store))
+	ctx := context.Background()
+
+	done := make(chan error, 1)
+	go func() { done <- e.RunWithID(ctx, "run-1", types.RunRequest{Goal: "g", Offline: true}) }()
+	var p types.RunProgress
+	for deadline := time.Now().Add(5 * time.Second); p.VariantsCompleted < 1; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected a variant to finish, got %+v", p)
+		}
+		p, _ = e.RunProgress(ctx, "run-1")
+	}
+	if p.Phase != types.PhaseSimulating || p.VariantsCompleted != 1 || p.VariantsTotal != 16 || p.PlanID == "" || p.FinishedAt != nil {
+		t.Errorf("expected the run simulating with one of 16 variants done, got %+v", p)
+	}
+	partial, err := e.RunResults(ctx, "run-1")
+	if err != nil || partial.Complete || len(partial.Results) != 1 || partial.Plan == nil || partial.Plan.PlanID != p.PlanID || partial.Analysis != nil {
+		t.Errorf("expected the plan and the one result so far, got %+v (%v)", partial, err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	p, err = e.RunProgress(ctx, "run-1")
+	if err != nil || p.Phase != types.PhaseDone || p.VariantsCompleted != 16 || p.FinishedAt == nil || p.ElapsedMs != p.FinishedAt.Sub(p.StartedAt).Milliseconds() {
+		t.Errorf("expected the run done with every variant, got %+v (%v)", p, err)
+	}
+	if final, err := e.RunResults(ctx, "run-1"); err != nil || !final.Complete || len(final.Results) != 16 || final.Analysis == nil {
+		t.Errorf("expected every result and the analysis, got %+v (%v)", final, err)
+	}
+
+	// Runs this process isn't running answer from their record
+	started := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	finished := started.Add(time.Minute)
+	_ = store.Save(ctx, types.RunRecord{ID: "run-2", Status: "canceled", StartedAt: started, FinishedAt: &finished})
+	_ = store.Save(ctx, types.RunRecord{ID: "run-3", Status: "running", StartedAt: started})
+	if p, _ := e.RunProgress(ctx, "run-2"); p.Phase != types.PhaseCancelled || p.ElapsedMs != 60000 {
+		t.Errorf("expected a cancelled run a minute long, got %+v", p)
+	}
+	if p, _ := e.RunProgress(ctx, "run-3"); p.Phase != types.PhaseRunning || p.ElapsedMs <= 0 {
+		t.Errorf("expected a run elsewhere reported running, got %+v", p)
+	}
+	if r, _ := e.RunResults(ctx, "run-3"); r.Complete || r.Results == nil {
+		t.Errorf("expected a run elsewhere incomplete with no results, got %+v", r)
+	}
+	if _, err := e.RunProgress(ctx, "missing"); !errors.Is(err, runstore.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if _, err := e.RunResults(ctx, "missing"); !errors.Is(err, runstore.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+// sharedStore stands in for a store shared between replicas, reporting the
+// runs finished on any of them.
+type sharedStore struct {
+	*runstore.Memory
+	completed chan string
+}
+
+func (s *sharedStore) Completed(ctx context.Context) (<-chan string, error) {
+	return s.completed, nil
+}
+
+func TestStreamResultsFollowsRunElsewhere(t *testing.T) {
+	shared := &sharedStore{Memory: runstore.NewMemory(), completed: make(chan string)}
+	e := NewEngine(nil, WithChatClient(testsupport.NewFakeChat(), "m"), WithRunStore(shared))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer close(shared.completed)
+	go e.WatchCompletions(ctx)
+
+	started := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	_ = shared.Save(ctx, types.RunRecord{ID: "run-1", Status: "running", StartedAt: started})
+	lines := make(chan types.ResultsLine, 8)
+	done := make(chan error, 1)
+	go func() {
+		done <- e.StreamResults(ctx, "run-1", true, func(l types.ResultsLine) error {
+			lines <- l
+			return nil
+		})
+	}()
+	if l := <-lines; l.Header == nil || l.Header.Status != "running" {
+		t.Fatalf("expected the header first, got %+v", l)
+	}
+	select {
+	case l := <-lines:
+		t.Fatalf("expected the stream to wait for the run to finish, got %+v", l)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Another replica finishes the run
+	finished := started.Add(time.Minute)
+	_ = shared.Save(ctx, types.RunRecord{ID: "run-1", Status: "completed", StartedAt: started, FinishedAt: &finished,
+		Results: []types.SimulationResult{{VariantID: "v1"}}})
+	shared.completed <- "run-1"
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if l := <-lines; l.Result == nil || l.Result.VariantID != "v1" {
+		t.Errorf("expected the stored result, got %+v", l)
+	}
+	if l := <-lines; l.Summary == nil || l.Summary.Status != "completed" || l.Summary.Results != 1 {
+		t.Errorf("expected the finished summary, got %+v", l)
+	}
+}
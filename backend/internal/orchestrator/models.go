@@ -0,0 +1,75 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"simstack/internal/cerebras"
+	"simstack/internal/llm"
+)
+
+// ErrUnknownModel means the provider does not list a configured model.
+var ErrUnknownModel = errors.New("configured model not served by provider")
+
+// CheckModel fetches the provider's model list, caches it for Models and
+// checks that the planner's and the critic's models are on it. An absent
+// model is only logged unless strict is set. Providers that cannot list
+// models are logged and skipped so they never block startup.
+func (e *Engine) CheckModel(ctx context.Context, strict bool) error {
+	if e.offline {
+		slog.InfoContext(ctx, "offline mode, skipping model validation")
+		return nil
+	}
+	models, err := e.refreshModels(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "model listing unavailable, skipping validation", "provider", llm.NameOf(e.llm), "err", err)
+		return nil
+	}
+	if models == nil {
+		return nil
+	}
+	for _, model := range e.configuredModels() {
+		if cerebras.HasModel(models, model) {
+			continue
+		}
+		err = fmt.Errorf("%w: %q (provider %s lists %d models)", ErrUnknownModel, model, llm.NameOf(e.llm), len(models))
+		if strict {
+			return err
+		}
+		slog.WarnContext(ctx, "runs will fall back to the built-in heuristics", "err", err)
+	}
+	return nil
+}
+
+// Models returns the cached provider model list, fetching it if CheckModel
+// has not populated it yet.
+func (e *Engine) Models(ctx context.Context) ([]cerebras.ModelInfo, error) {
+	e.modelsMu.RLock()
+	models := e.models
+	e.modelsMu.RUnlock()
+	if models != nil {
+		return models, nil
+	}
+	return e.refreshModels(ctx)
+}
+
+// refreshModels returns nil, nil when the client cannot list models at all.
+func (e *Engine) refreshModels(ctx context.Context) ([]cerebras.ModelInfo, error) {
+	lister, ok := e.llm.(llm.ModelLister)
+	if !ok {
+		return nil, nil
+	}
+	models, err := lister.Models(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if models == nil {
+		models = []cerebras.ModelInfo{}
+	}
+	e.modelsMu.Lock()
+	e.models = models
+	e.modelsMu.Unlock()
+	return models, nil
+}
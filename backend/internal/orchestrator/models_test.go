@@ -0,0 +1,61 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"simstack/internal/cerebras"
+)
+
+func modelsServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/models" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"object":"list","data":[{"id":"llama3.1-8b","owned_by":"Meta"},{"id":"llama-3.3-70b","owned_by":"Meta"}]}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestCheckModelPresent(t *testing.T) {
+	srv := modelsServer(t)
+	e := NewEngine(nil, WithChatClient(cerebras.NewClient(srv.URL, "test"), "llama3.1-8b"))
+	if err := e.CheckModel(context.Background(), true); err != nil {
+		t.Fatalf("expected configured model to validate, got %v", err)
+	}
+	models, err := e.Models(context.Background())
+	if err != nil || len(models) != 2 {
+		t.Fatalf("expected 2 cached models, got %v (%v)", models, err)
+	}
+	if models[0].OwnedBy != "Meta" || models[0].ContextWindow == 0 {
+		t.Errorf("expected typed model info, got %+v", models[0])
+	}
+}
+
+func TestCheckModelAbsent(t *testing.T) {
+	srv := modelsServer(t)
+	e := NewEngine(nil, WithChatClient(cerebras.NewClient(srv.URL, "test"), "llama3.1-8bb"))
+	if err := e.CheckModel(context.Background(), false); err != nil {
+		t.Errorf("lenient mode should only warn, got %v", err)
+	}
+	if err := e.CheckModel(context.Background(), true); !errors.Is(err, ErrUnknownModel) {
+		t.Errorf("strict mode should fail with ErrUnknownModel, got %v", err)
+	}
+}
+
+func TestCheckModelUnreachableProvider(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := srv.URL
+	srv.Close()
+
+	e := NewEngine(nil, WithChatClient(cerebras.NewClient(url, "test"), "llama3.1-8b"))
+	if err := e.CheckModel(context.Background(), true); err != nil {
+		t.Errorf("listing failure should skip validation, got %v", err)
+	}
+}
@@ -0,0 +1,373 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"simstack/internal/cerebras"
+	"simstack/internal/clock"
+	"simstack/internal/types"
+)
+
+// narrativeMaxTokens caps a narrative: a few short paragraphs.
+const narrativeMaxTokens = 768
+
+// narrativeCacheSize bounds the narratives kept, one per run pair.
+const narrativeCacheSize = 256
+
+const narrativePrompt = `You are an expert operations analyst explaining to a manager how two simulation runs, A and B, differ. Using the run summaries and the numeric comparison, write a short narrative in Markdown with these sections:
+
+## What changed
+The inputs that differ between the runs.
+
+## How outcomes moved
+The metrics that moved the most, with their numbers.
+
+## Recommendation
+Whether the recommendation should change from run A's to run B's, and why.
+
+## Caveats
+What the numbers can't show: failed variants, different goals, too few variants.
+
+Be concise and don't invent numbers that aren't given.`
+
+// CompareNarrative writes a Markdown narrative of how run idB differs from
+// run idA, with the numeric comparison it is written from. The critic model
+// writes it; offline, or when the model fails, a template does instead.
+// Narratives the model wrote about finished runs are cached per pair.
+func (e *Engine) CompareNarrative(ctx context.Context, idA, idB string) (types.RunNarrative, error) {
+	a, err := e.store.Get(ctx, idA)
+	if err != nil {
+		return types.RunNarrative{}, err
+	}
+	b, err := e.store.Get(ctx, idB)
+	if err != nil {
+		return types.RunNarrative{}, err
+	}
+	key := [2]string{idA, idB}
+	if n, ok := e.narratives.get(key); ok {
+		return n, nil
+	}
+
+	n := types.RunNarrative{Comparison: e.compareRuns(a, b)}
+	if !e.isOffline(ctx) {
+		n.Markdown, n.Model, err = e.narrate(ctx, a, b, n.Comparison)
+		if err == nil {
+			n.LLM = true
+			if a.Status != "running" && b.Status != "running" {
+				e.narratives.put(key, n)
+			}
+			return n, nil
+		}
+		slog.WarnContext(ctx, "narrative written from the template", "run_a", idA, "run_b", idB, "err", err)
+	}
+	n.Markdown = narrativeTemplate(n.Comparison)
+	return n, nil
+}
+
+// narrate asks the critic model for the narrative.
+func (e *Engine) narrate(ctx context.Context, a, b types.RunRecord, cmp types.RunComparison) (string, string, error) {
+	_, analysisTimeout := e.phaseTimeouts()
+	ctx, cancel := clock.WithTimeout(withPhase(ctx, "narrative"), e.clock, analysisTimeout)
+	defer cancel()
+	resp, model, err := e.chat(ctx, "narrative", e.narrativeRequest(a, b, cmp), nil)
+	if err != nil {
+		return "", "", err
+	}
+	content, _ := cerebras.MessageContent(resp)
+	if content = strings.TrimSpace(content); content == "" {
+		return "", "", errors.New("empty narrative")
+	}
+	return content, model, nil
+}
+
+// narrativeRequest assembles the critic's request from both runs' compacted
+// manifests, their results and the comparison. When that overflows the
+// model's window, each run keeps the best-scoring variants that fit in half
+// of what is left. The goals are the users' own text, so each is sanitized
+// into its <user_goal> section and left out of everything else.
+func (e *Engine) narrativeRequest(a, b types.RunRecord, cmp types.RunComparison) cerebras.OpenAIChatRequest {
+	cmp.A.Goal, cmp.B.Goal = "", ""
+	cmp.Inputs = slices.DeleteFunc(slices.Clone(cmp.Inputs), func(in types.InputChange) bool { return in.Name == "goal" })
+	diff, _ := json.Marshal(cmp)
+	user := func(resultsA, resultsB string) string {
+		return fmt.Sprintf("Run A (%s):\n%s\n%s\nResults:%s\nRun B (%s):\n%s\n%s\nResults:%s\nNumeric comparison of the winners, B against A:\n%s",
+			a.ID, e.goalSection(a.Goal), compactManifest(a), resultsA, b.ID, e.goalSection(b.Goal), compactManifest(b), resultsB, diff)
+	}
+	resultsA, resultsB := e.summarizeResults(a.Results), e.summarizeResults(b.Results)
+	req := cerebras.OpenAIChatRequest{
+		Model: e.phaseModels["narrative"],
+		Messages: []cerebras.ChatMessage{
+			{Role: "system", Content: narrativePrompt + userDataNotice},
+			{Role: "user", Content: user(resultsA, resultsB)},
+		},
+		Temperature: float32(e.criticTemp),
+		MaxTokens:   narrativeMaxTokens,
+	}
+	if fit := cerebras.EstimateFit(req); !fit.Fits() {
+		rest := fit.PromptTokens - cerebras.EstimateTokens(resultsA) - cerebras.EstimateTokens(resultsB)
+		budget := (fit.Available() - rest) / 2
+		req.Messages[1].Content = user(e.summarizeResultsWithin(a.Results, budget), e.summarizeResultsWithin(b.Results, budget))
+	}
+	return req
+}
+
+// goalSection returns a run's goal sanitized within the goal cap, in the
+// <user_goal> section the system prompt tells the model is data.
+func (e *Engine) goalSection(goal string) string {
+	var report types.InputSanitation
+	return promptInput{goal: sanitizeText(goal, e.config().GoalMaxChars, "goal", &report)}.goalSection()
+}
+
+// compactManifest renders what a run's manifest says about its inputs and
+// outcome, leaving out its goal, LLM call records, artifacts and timings.
+func compactManifest(run types.RunRecord) string {
+	compact := map[string]any{"status": run.Status}
+	if run.Winner != "" {
+		compact["winner"] = run.Winner
+	}
+	if rec, ok := run.Analysis["recommendation"].(string); ok {
+		compact["recommendation"] = rec
+	}
+	if run.Plan != nil {
+		compact["variants"] = len(run.Plan.Variants)
+	}
+	if m := run.Manifest; m != nil {
+		compact["model"] = m.Model
+		compact["planner_temperature"] = m.PlannerTemperature
+		compact["critic_temperature"] = m.CriticTemperature
+		compact["offline"] = m.Offline
+		compact["llm"] = m.LLM
+		compact["cost_usd"] = m.CostUSD
+		compact["total_ms"] = m.TotalMs
+	}
+	b, _ := json.Marshal(compact)
+	return string(b)
+}
+
+// compareRuns works out how run b differs from run a.
+func (e *Engine) compareRuns(a, b types.RunRecord) types.RunComparison {
+	cmp := types.RunComparison{A: runSide(a), B: runSide(b), Inputs: []types.InputChange{}, Metrics: []types.MetricChange{}}
+
+	inA, inB := runInputs(a), runInputs(b)
+	for _, name := range unionKeys(inA, inB) {
+		va, okA := inA[name]
+		vb, okB := inB[name]
+		// Stored runs read numbers back as float64; compare as printed
+		if okA != okB || fmt.Sprint(va) != fmt.Sprint(vb) {
+			cmp.Inputs = append(cmp.Inputs, types.InputChange{Name: name, A: va, B: vb})
+		}
+	}
+
+	wa, wb := winnerResult(a), winnerResult(b)
+	if wa == nil || wb == nil {
+		return cmp
+	}
+	names := make([]string, 0, len(wa.Metrics))
+	for name := range wa.Metrics {
+		if _, ok := wb.Metrics[name]; ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		def, _ := e.metrics.Lookup(name)
+		c := types.MetricChange{Metric: name, Unit: def.Unit, Direction: def.Direction, A: wa.Metrics[name], B: wb.Metrics[name]}
+		c.Delta = c.B - c.A
+		if c.A != 0 {
+			pct := c.Delta / math.Abs(c.A) * 100
+			c.DeltaPct = &pct
+		}
+		switch {
+		case c.Delta == 0:
+			c.Change = "unchanged"
+		case (c.Delta < 0) == (def.Direction == types.LowerIsBetter):
+			c.Change = "better"
+		default:
+			c.Change = "worse"
+		}
+		cmp.Metrics = append(cmp.Metrics, c)
+	}
+	return cmp
+}
+
+func runSide(run types.RunRecord) types.RunSide {
+	side := types.RunSide{RunID: run.ID, Goal: run.Goal, Status: run.Status, Winner: run.Winner, Variants: len(run.Results)}
+	side.Recommendation, _ = run.Analysis["recommendation"].(string)
+	for _, r := range run.Results {
+		if r.Status == types.ResultFailed {
+			side.FailedVariants++
+		}
+	}
+	if run.Manifest != nil {
+		side.CostUSD = run.Manifest.CostUSD
+	}
+	return side
+}
+
+// runInputs lists what went into a run: its goal, model settings and the
+// winner's parameters, flattened under "winner.".
+func runInputs(run types.RunRecord) map[string]any {
+	in := map[string]any{"goal": run.Goal}
+	if m := run.Manifest; m != nil {
+		in["model"] = m.Model
+		in["planner_temperature"] = m.PlannerTemperature
+		in["critic_temperature"] = m.CriticTemperature
+		in["offline"] = m.Offline
+		in["reproducible"] = m.Reproducible
+	}
+	if run.Plan != nil {
+		in["variants"] = len(run.Plan.Variants)
+		for _, v := range run.Plan.Variants {
+			if v.VariantID == run.Winner {
+				flatten("winner", v.Parameters, in)
+			}
+		}
+	}
+	return in
+}
+
+func flatten(prefix string, params map[string]any, out map[string]any) {
+	for k, v := range params {
+		if nested, ok := v.(map[string]any); ok {
+			flatten(prefix+"."+k, nested, out)
+			continue
+		}
+		out[prefix+"."+k] = v
+	}
+}
+
+func unionKeys(a, b map[string]any) []string {
+	var keys []string
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func winnerResult(run types.RunRecord) *types.SimulationResult {
+	for i := range run.Results {
+		if run.Results[i].VariantID == run.Winner && run.Winner != "" {
+			return &run.Results[i]
+		}
+	}
+	return nil
+}
+
+// narrativeTemplate writes the narrative from the numbers alone.
+func narrativeTemplate(cmp types.RunComparison) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "## What changed\n")
+	if len(cmp.Inputs) == 0 {
+		b.WriteString("Both runs had the same inputs.\n")
+	}
+	for _, in := range cmp.Inputs {
+		fmt.Fprintf(&b, "- %s: %s → %s\n", in.Name, inputValue(in.A), inputValue(in.B))
+	}
+
+	b.WriteString("\n## How outcomes moved\n")
+	if len(cmp.Metrics) == 0 {
+		b.WriteString("The winners share no metrics to compare.\n")
+	}
+	better, worse := 0, 0
+	for _, m := range cmp.Metrics {
+		unit := ""
+		if m.Unit != "" && m.Unit != "ratio" {
+			unit = " " + m.Unit
+		}
+		pct := ""
+		if m.DeltaPct != nil {
+			pct = fmt.Sprintf("%+.1f%%, ", *m.DeltaPct)
+		}
+		fmt.Fprintf(&b, "- %s: %g → %g%s (%s%s)\n", m.Metric, m.A, m.B, unit, pct, m.Change)
+		switch m.Change {
+		case "better":
+			better++
+		case "worse":
+			worse++
+		}
+	}
+
+	b.WriteString("\n## Recommendation\n")
+	switch {
+	case cmp.A.Winner == "" || cmp.B.Winner == "":
+		b.WriteString("One of the runs has no winner, so there is nothing to switch to.\n")
+	case better > worse:
+		fmt.Fprintf(&b, "Run B's winner %s improves %d of %d metrics and worsens %d; consider switching to it from run A's %s.\n", cmp.B.Winner, better, len(cmp.Metrics), worse, cmp.A.Winner)
+	default:
+		fmt.Fprintf(&b, "Run B's winner %s improves %d of %d metrics and worsens %d; keep run A's %s.\n", cmp.B.Winner, better, len(cmp.Metrics), worse, cmp.A.Winner)
+	}
+
+	b.WriteString("\n## Caveats\n")
+	b.WriteString("- Written from the numbers alone, without the critic model.\n")
+	if cmp.A.Goal != cmp.B.Goal {
+		b.WriteString("- The runs pursued different goals.\n")
+	}
+	for _, side := range []struct {
+		name string
+		s    types.RunSide
+	}{{"A", cmp.A}, {"B", cmp.B}} {
+		if side.s.Status == "running" {
+			fmt.Fprintf(&b, "- Run %s is still running.\n", side.name)
+		}
+		if side.s.FailedVariants > 0 {
+			fmt.Fprintf(&b, "- %d of run %s's %d variants failed.\n", side.s.FailedVariants, side.name, side.s.Variants)
+		}
+	}
+	return b.String()
+}
+
+func inputValue(v any) string {
+	if v == nil {
+		return "unset"
+	}
+	if s, ok := v.(string); ok {
+		return fmt.Sprintf("%q", s)
+	}
+	return fmt.Sprint(v)
+}
+
+// narrativeCache keeps narratives by run pair, dropping the oldest when
+// full.
+type narrativeCache struct {
+	mu    sync.Mutex
+	byKey map[[2]string]types.RunNarrative
+	order [][2]string
+}
+
+func (c *narrativeCache) get(key [2]string) (types.RunNarrative, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n, ok := c.byKey[key]
+	return n, ok
+}
+
+func (c *narrativeCache) put(key [2]string, n types.RunNarrative) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.byKey == nil {
+		c.byKey = map[[2]string]types.RunNarrative{}
+	}
+	if _, ok := c.byKey[key]; !ok {
+		if len(c.order) == narrativeCacheSize {
+			delete(c.byKey, c.order[0])
+			c.order = c.order[1:]
+		}
+		c.order = append(c.order, key)
+	}
+	c.byKey[key] = n
+}
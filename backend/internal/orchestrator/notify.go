@@ -0,0 +1,83 @@
+package orchestrator
+
+import (
+	"strings"
+	"time"
+
+	"simstack/internal/config"
+	"simstack/internal/notify"
+	"simstack/internal/types"
+)
+
+// notifyBackoff is the wait before retrying a failed notification, doubling
+// with each retry.
+const notifyBackoff = time.Second
+
+// headlineMetrics bounds the metrics a notification shows.
+const headlineMetrics = 3
+
+// notify tells the configured webhooks and the run's own that run finished
+// with outcome, in the background.
+func (e *Engine) notify(cfg *config.Config, req types.RunRequest, run types.RunRecord, outcome string, dists []types.MetricDistribution) {
+	targets := notifyTargets(cfg, req)
+	if len(targets) == 0 {
+		return
+	}
+	msg := notify.Message{
+		RunID:  run.ID,
+		Goal:   run.Goal,
+		Status: outcome,
+		Winner: run.Winner,
+		URL:    strings.TrimSuffix(cfg.PublicURL, "/") + "/api/runs/" + run.ID,
+	}
+	if run.Manifest != nil {
+		msg.CostUSD = run.Manifest.CostUSD
+	}
+	msg.Metrics = winnerMetrics(run, dists)
+	e.notifier.Send(msg, targets, notify.Options{Template: cfg.NotifyTemplate, Retries: cfg.NotifyRetries, Backoff: notifyBackoff, AllowedHosts: cfg.NotifyAllowedHosts})
+}
+
+// notifyTargets lists the webhooks to tell about a run, each once.
+func notifyTargets(cfg *config.Config, req types.RunRequest) []notify.Target {
+	var targets []notify.Target
+	seen := map[string]bool{}
+	add := func(platform, url string, fromRun bool) {
+		if url != "" && !seen[url] {
+			seen[url] = true
+			targets = append(targets, notify.Target{Platform: platform, URL: url, FromRun: fromRun})
+		}
+	}
+	add(notify.Slack, cfg.SlackWebhookURL, false)
+	add(notify.Discord, cfg.DiscordWebhookURL, false)
+	if n := req.Notify; n != nil {
+		add(notify.Slack, n.SlackWebhookURL, true)
+		add(notify.Discord, n.DiscordWebhookURL, true)
+	}
+	return targets
+}
+
+// winnerMetrics returns the winner's values of the first charted metrics
+// it reports, against each one's median across variants.
+func winnerMetrics(run types.RunRecord, dists []types.MetricDistribution) []notify.Metric {
+	var winner *types.SimulationResult
+	for i := range run.Results {
+		if run.Results[i].VariantID == run.Winner {
+			winner = &run.Results[i]
+		}
+	}
+	if winner == nil {
+		return nil
+	}
+	var out []notify.Metric
+	for _, d := range dists {
+		v, ok := winner.Metrics[d.Metric]
+		if d.Metric == scoreMetric || !ok {
+			continue
+		}
+		out = append(out, notify.Metric{Name: d.Metric, Unit: d.Unit, Value: v, Baseline: d.Median})
+		if len(out) == headlineMetrics {
+			break
+		}
+	}
+	return out
+}
@@ -0,0 +1,115 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"simstack/internal/config"
+	"simstack/internal/eventbus"
+	"simstack/internal/notify"
+	"simstack/internal/testsupport"
+	"simstack/internal/types"
+)
+
+func TestRunNotifiesWithoutWaitingOnWebhooks(t *testing.T) {
+	sim := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"metrics": {"utilization": 0.5, "avg_wait_time_min": 3}}`)
+	}))
+	defer sim.Close()
+	// Both webhooks hold every post until released
+	release := make(chan struct{})
+	var mu sync.Mutex
+	posted := map[string][]byte{}
+	hook := func(platform string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+			body, _ := io.ReadAll(r.Body)
+			mu.Lock()
+			posted[platform] = body
+			mu.Unlock()
+		}))
+	}
+	slack, discord := hook("slack"), hook("discord")
+	defer slack.Close()
+	defer discord.Close()
+
+	cfg, _ := config.Load()
+	cfg.SimulatorURLs = map[string]string{"queue": sim.URL, "traffic": sim.URL, "resource": sim.URL}
+	cfg.SlackWebhookURL = slack.URL
+	cfg.PublicURL = "http://simstack.test/"
+	// The run's own webhook is on this machine
+	cfg.NotifyAllowedHosts = []string{"127.0.0.1"}
+	rec := &recorder{}
+	e := NewEngine(eventbus.Direct(rec.emit), WithConfig(cfg), WithChatClient(testsupport.NewFakeChat(), "m"))
+	req := types.RunRequest{Goal: "g", Offline: true, Notify: &types.NotifyTargets{
+		// The configured Slack webhook is told once
+		SlackWebhookURL:   slack.URL,
+		DiscordWebhookURL: discord.URL,
+	}}
+	if err := e.Run(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.ofType(types.EventDone)) != 1 {
+		t.Fatal("expected the run to finish while its webhooks hang")
+	}
+	close(release)
+	e.notifier.Wait()
+
+	runID := rec.ofType(types.EventDone)[0].Payload.(types.DoneEvent).RunID
+	var msg struct {
+		Text   string `json:"text"`
+		Blocks []struct {
+			Type     string `json:"type"`
+			Fields   []any  `json:"fields"`
+			Elements []struct {
+				Text string `json:"text"`
+			} `json:"elements"`
+		} `json:"blocks"`
+	}
+	if err := json.Unmarshal(posted["slack"], &msg); err != nil {
+		t.Fatalf("unexpected Slack post %q: %v", posted["slack"], err)
+	}
+	if msg.Text != "SimStack run completed: g" || len(msg.Blocks) != 4 || len(msg.Blocks[2].Fields) != headlineMetrics {
+		t.Fatalf("expected a completed message with the winner's headline metrics, got %s", posted["slack"])
+	}
+	link := fmt.Sprintf("<http://simstack.test/api/runs/%s|View run %s>", runID, runID)
+	if footer := msg.Blocks[len(msg.Blocks)-1].Elements[0].Text; footer != "Cost: $0.0000 · "+link {
+		t.Errorf("expected the footer to link the run, got %q", footer)
+	}
+	if len(posted["discord"]) == 0 {
+		t.Error("expected the run's own Discord webhook told")
+	}
+}
+
+func TestValidateRequestNotifyURLs(t *testing.T) {
+	e := NewEngine(nil)
+	bad := types.RunRequest{Goal: "g", Notify: &types.NotifyTargets{SlackWebhookURL: "hooks.slack.com/x"}}
+	if err := e.ValidateRequest(context.Background(), bad); err == nil {
+		t.Error("expected a webhook URL without a scheme rejected")
+	}
+	good := types.RunRequest{Goal: "g", Notify: &types.NotifyTargets{DiscordWebhookURL: "https://discord.test/api/webhooks/1"}}
+	if err := e.ValidateRequest(context.Background(), good); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	for _, u := range []string{"http://127.0.0.1:9000/hook", "http://localhost/hook", "http://[::1]/hook", "http://169.254.169.254/latest", "https://10.0.0.7/hook"} {
+		inside := types.RunRequest{Goal: "g", Notify: &types.NotifyTargets{SlackWebhookURL: u}}
+		if err := e.ValidateRequest(context.Background(), inside); !errors.Is(err, notify.ErrPrivateTarget) {
+			t.Errorf("%s: expected a private address refused, got %v", u, err)
+		}
+	}
+
+	cfg, _ := config.Load()
+	cfg.NotifyAllowedHosts = []string{"10.0.0.7"}
+	e = NewEngine(nil, WithConfig(cfg))
+	allowed := types.RunRequest{Goal: "g", Notify: &types.NotifyTargets{SlackWebhookURL: "https://10.0.0.7/hook"}}
+	if err := e.ValidateRequest(context.Background(), allowed); err != nil {
+		t.Errorf("expected an allowlisted host accepted, got %v", err)
+	}
+}
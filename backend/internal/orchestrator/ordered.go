@@ -0,0 +1,96 @@
+package orchestrator
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"simstack/internal/clock"
+	"simstack/internal/types"
+)
+
+// orderedResultsGrace is how long past its timeout a variant may still take
+// to report before a gap takes its place; a timed-out variant normally
+// reports a failed result well within it.
+const orderedResultsGrace = 5 * time.Second
+
+type orderedResultsKey struct{}
+
+// withOrderedResults makes the variants dispatched with ctx release their
+// result events in plan order.
+func withOrderedResults(ctx context.Context) context.Context {
+	return context.WithValue(ctx, orderedResultsKey{}, true)
+}
+
+func resultsOrdered(ctx context.Context) bool {
+	ordered, _ := ctx.Value(orderedResultsKey{}).(bool)
+	return ordered
+}
+
+// resultOrder holds completed results back until every variant before them
+// in the plan has reported, then sends them as result events, so the stream
+// follows variant order however the variants finish. It holds at most one
+// result per variant. Should a variant still be out when the watchdog fires,
+// a result_gap event takes its place and the results held behind it go out;
+// a result arriving after its gap is not sent again.
+type resultOrder struct {
+	mu       sync.Mutex
+	send     func(typ string, payload any)
+	variants []types.Variant
+	held     []*types.SimulationResult
+	next     int
+	waited   time.Duration
+	watchdog clock.Timer
+}
+
+// newResultOrder returns the order of plan's variants, sending through send
+// and giving up on stragglers after watchdog on c.
+func newResultOrder(c clock.Clock, variants []types.Variant, watchdog time.Duration, send func(typ string, payload any)) *resultOrder {
+	o := &resultOrder{
+		send:     send,
+		variants: variants,
+		held:     make([]*types.SimulationResult, len(variants)),
+		waited:   watchdog,
+	}
+	o.watchdog = c.AfterFunc(watchdog, o.expire)
+	return o
+}
+
+// add takes the result of the variant at index i and sends whatever it
+// unblocks.
+func (o *resultOrder) add(i int, r types.SimulationResult) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if i < o.next {
+		return // Its gap has been sent
+	}
+	o.held[i] = &r
+	for o.next < len(o.held) && o.held[o.next] != nil {
+		o.send(types.EventResult, *o.held[o.next])
+		o.held[o.next] = nil
+		o.next++
+	}
+}
+
+// expire sends a gap for every variant still out, and every result held.
+func (o *resultOrder) expire() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for ; o.next < len(o.held); o.next++ {
+		if r := o.held[o.next]; r != nil {
+			o.send(types.EventResult, *r)
+			o.held[o.next] = nil
+			continue
+		}
+		o.send(types.EventResultGap, types.ResultGapEvent{
+			VariantID: o.variants[o.next].VariantID,
+			Index:     o.next,
+			WaitedMs:  o.waited.Milliseconds(),
+		})
+	}
+}
+
+// stop stops the watchdog once every variant has reported.
+func (o *resultOrder) stop() {
+	o.watchdog.Stop()
+}
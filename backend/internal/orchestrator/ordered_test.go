@@ -0,0 +1,130 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"simstack/internal/clock"
+	"simstack/internal/config"
+	"simstack/internal/eventbus"
+	"simstack/internal/types"
+)
+
+// sentEvents records what a resultOrder sends, as "type variant" lines.
+type sentEvents struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (s *sentEvents) send(typ string, payload any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch p := payload.(type) {
+	case types.SimulationResult:
+		s.lines = append(s.lines, typ+" "+p.VariantID)
+	case types.ResultGapEvent:
+		s.lines = append(s.lines, fmt.Sprintf("%s %s@%d", typ, p.VariantID, p.Index))
+	}
+}
+
+func (s *sentEvents) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return fmt.Sprint(s.lines)
+}
+
+func orderVariants(n int) []types.Variant {
+	variants := make([]types.Variant, n)
+	for i := range variants {
+		variants[i] = types.Variant{VariantID: fmt.Sprintf("v%d", i+1)}
+	}
+	return variants
+}
+
+func TestResultOrderReleasesInVariantOrder(t *testing.T) {
+	sent := &sentEvents{}
+	variants := orderVariants(4)
+	o := newResultOrder(clock.Real, variants, time.Hour, sent.send)
+	defer o.stop()
+
+	for i := len(variants) - 1; i > 0; i-- {
+		o.add(i, types.SimulationResult{VariantID: variants[i].VariantID})
+	}
+	if got := sent.String(); got != "[]" {
+		t.Fatalf("expected results held until v1 reports, got %s", got)
+	}
+	o.add(0, types.SimulationResult{VariantID: "v1"})
+	if got, want := sent.String(), "[result v1 result v2 result v3 result v4]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestResultOrderGapsAStuckVariant(t *testing.T) {
+	sent := &sentEvents{}
+	variants := orderVariants(4)
+	o := newResultOrder(clock.Real, variants, 20*time.Millisecond, sent.send)
+	defer o.stop()
+
+	o.add(0, types.SimulationResult{VariantID: "v1"})
+	o.add(3, types.SimulationResult{VariantID: "v4"})
+	o.add(2, types.SimulationResult{VariantID: "v3"})
+	if got, want := sent.String(), "[result v1]"; got != want {
+		t.Fatalf("expected v3 and v4 held behind v2, got %s", got)
+	}
+
+	want := "[result v1 result_gap v2@1 result v3 result v4]"
+	deadline := time.Now().Add(5 * time.Second)
+	for sent.String() != want && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	// v2 reporting after its gap changes nothing
+	o.add(1, types.SimulationResult{VariantID: "v2"})
+	if got := sent.String(); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestDispatchOrdersResultEvents(t *testing.T) {
+	// The higher the arrival rate, the sooner the simulator answers, so the
+	// variants finish in reverse
+	sim := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var params map[string]float64
+		json.NewDecoder(r.Body).Decode(&params)
+		time.Sleep(time.Duration(5-params["arrival_rate"]) * 50 * time.Millisecond)
+		fmt.Fprint(w, `{"metrics": {"utilization": 0.5}}`)
+	}))
+	defer sim.Close()
+	cfg, _ := config.Load()
+	cfg.SimulatorURLs = map[string]string{"queue": sim.URL}
+	cfg.SimulatorCacheMaxEntries = 0
+	rec := &recorder{}
+	e := NewEngine(eventbus.Direct(rec.emit), WithConfig(cfg))
+
+	variants := orderVariants(4)
+	for i := range variants {
+		variants[i].Parameters = map[string]any{"arrival_rate": float64(i + 1), "service_rate": 12.0}
+	}
+	ctx := withOrderedResults(context.Background())
+	if got := e.dispatchVariants(ctx, &cfg, types.SimulationPlan{Variants: variants}); len(got) != 4 {
+		t.Fatalf("expected 4 results, got %d", len(got))
+	}
+
+	ids := func(typ string) (out []string) {
+		for _, ev := range rec.ofType(typ) {
+			out = append(out, ev.Payload.(types.SimulationResult).VariantID)
+		}
+		return out
+	}
+	if got := fmt.Sprint(ids(types.EventSimComplete)); got != "[v4 v3 v2 v1]" {
+		t.Fatalf("expected the variants to finish in reverse, got %s", got)
+	}
+	if got := fmt.Sprint(ids(types.EventResult)); got != "[v1 v2 v3 v4]" {
+		t.Errorf("expected results in variant order, got %s", got)
+	}
+}
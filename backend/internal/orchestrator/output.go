@@ -0,0 +1,119 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"simstack/internal/cerebras"
+	"simstack/internal/types"
+)
+
+// Shapes the planner and critic are asked to return. They double as the source
+// for the json_schema response formats, so the prompt and the decoder can't drift.
+
+type plannerOutput struct {
+	Variants []plannedVariant `json:"variants"`
+}
+
+type plannedVariant struct {
+	ID       string         `json:"id"`
+	Queue    queueParams    `json:"queue"`
+	Traffic  trafficParams  `json:"traffic"`
+	Resource resourceParams `json:"resource"`
+}
+
+type queueParams struct {
+	ArrivalRate float64 `json:"arrival_rate"`
+	ServiceRate float64 `json:"service_rate"`
+}
+
+type trafficParams struct {
+	Density      float64 `json:"density"`
+	SignalTiming float64 `json:"signal_timing,omitempty"`
+}
+
+type resourceParams struct {
+	Staff  int      `json:"staff"`
+	Shifts []string `json:"shifts,omitempty"`
+}
+
+func (v plannedVariant) parameters() map[string]any {
+	params := map[string]any{
+		"arrival_rate": v.Queue.ArrivalRate,
+		"service_rate": v.Queue.ServiceRate,
+		"density":      v.Traffic.Density,
+		"staff":        v.Resource.Staff,
+	}
+	if v.Traffic.SignalTiming > 0 {
+		params["signal_timing"] = v.Traffic.SignalTiming
+	}
+	if len(v.Resource.Shifts) > 0 {
+		params["shifts"] = v.Resource.Shifts
+	}
+	return params
+}
+
+type analysisOutput struct {
+	Winner          string             `json:"winner"`
+	Recommendation  string             `json:"recommendation"`
+	Confidence      float64            `json:"confidence"`
+	TradeOffs       []string           `json:"trade_offs"`
+	Counterfactuals []string           `json:"counterfactuals"`
+	KeyMetrics      map[string]float64 `json:"key_metrics,omitempty"`
+}
+
+func (a analysisOutput) validate(results []types.SimulationResult) error {
+	if a.Recommendation == "" {
+		return fmt.Errorf("missing recommendation")
+	}
+	if a.Confidence < 0 || a.Confidence > 1 {
+		return fmt.Errorf("confidence %v out of range", a.Confidence)
+	}
+	for _, r := range results {
+		if r.VariantID == a.Winner {
+			return nil
+		}
+	}
+	return fmt.Errorf("winner %q is not one of the simulated variants", a.Winner)
+}
+
+func (a analysisOutput) toMap() map[string]any {
+	return map[string]any{
+		"winner":          a.Winner,
+		"recommendation":  a.Recommendation,
+		"confidence":      a.Confidence,
+		"trade_offs":      a.TradeOffs,
+		"counterfactuals": a.Counterfactuals,
+		"key_metrics":     a.KeyMetrics,
+	}
+}
+
+var (
+	plannerResponseFormat  = cerebras.JSONSchemaFormat("simulation_plan", plannerOutput{})
+	analysisResponseFormat = cerebras.JSONSchemaFormat("simulation_analysis", analysisOutput{})
+)
+
+// decodeContent strictly decodes the first message's content into out. Only
+// if that fails is a repair pass attempted; repaired reports whether the
+// decoded value came from repaired content.
+func decodeContent(resp map[string]any, out any) (repaired bool, err error) {
+	content, ok := cerebras.MessageContent(resp)
+	if !ok {
+		return false, fmt.Errorf("empty response content")
+	}
+	err = json.Unmarshal([]byte(content), out)
+	if err == nil {
+		return false, nil
+	}
+	if _, isSyntax := err.(*json.SyntaxError); !isSyntax {
+		return false, err
+	}
+	fixed, ok := tryRepair(content)
+	if !ok {
+		return false, err
+	}
+	if rerr := json.Unmarshal([]byte(fixed), out); rerr != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,155 @@
+package orchestrator
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	"simstack/internal/cerebras"
+	"simstack/internal/notify"
+	"simstack/internal/types"
+)
+
+// ErrInvalidRequest marks run requests rejected before any work starts.
+var ErrInvalidRequest = errors.New("invalid run request")
+
+const maxTemperature = 2.0
+
+// ValidateRequest checks a run's goal and overrides: the goal must have
+// something left once sanitized for the prompt, each model must be on the
+// LLM_ALLOWED_MODELS allowlist when one is configured and on the provider's
+// cached model list when that is available, temperatures must be in
+// [0, 2], and notification webhooks must be http(s) URLs that don't name a
+// private address outside SIMSTACK_NOTIFY_ALLOWED_HOSTS.
+func (e *Engine) ValidateRequest(ctx context.Context, req types.RunRequest) error {
+	if sanitizeText(req.Goal, e.config().GoalMaxChars, "goal", &types.InputSanitation{}) == "" {
+		return fmt.Errorf("%w: goal is empty once control characters and instruction phrases are removed", ErrInvalidRequest)
+	}
+	for _, m := range []struct{ name, model, configured string }{
+		{"model", req.Model, e.model},
+		{"planner_model", req.PlannerModel, e.phaseModels["plan"]},
+		{"critic_model", req.CriticModel, e.phaseModels["analysis"]},
+	} {
+		if m.model == "" || m.model == m.configured {
+			continue
+		}
+		if len(e.allowedModels) > 0 && !contains(e.allowedModels, m.model) {
+			return fmt.Errorf("%w: %s %q is not allowed (allowed: %s)", ErrInvalidRequest, m.name, m.model, strings.Join(e.allowedModels, ", "))
+		}
+		e.modelsMu.RLock()
+		models := e.models
+		e.modelsMu.RUnlock()
+		if models != nil && !cerebras.HasModel(models, m.model) {
+			return fmt.Errorf("%w: %s %q is not served by the provider", ErrInvalidRequest, m.name, m.model)
+		}
+	}
+	for name, t := range map[string]*float64{"planner_temperature": req.PlannerTemperature, "critic_temperature": req.CriticTemperature} {
+		if t != nil && (*t < 0 || *t > maxTemperature) {
+			return fmt.Errorf("%w: %s %.2f outside [0, %.0f]", ErrInvalidRequest, name, *t, maxTemperature)
+		}
+		if t != nil && *t != 0 && req.Reproducible {
+			return fmt.Errorf("%w: %s must be 0 for a reproducible run", ErrInvalidRequest, name)
+		}
+	}
+	if n := req.Notify; n != nil {
+		for name, u := range map[string]string{"slack_webhook_url": n.SlackWebhookURL, "discord_webhook_url": n.DiscordWebhookURL} {
+			if u == "" {
+				continue
+			}
+			if err := notify.CheckURL(u, e.config().NotifyAllowedHosts); err != nil {
+				return fmt.Errorf("%w: notify %s: %w", ErrInvalidRequest, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// modelFor returns the model a run asked for, or the configured default.
+func (e *Engine) modelFor(req types.RunRequest) string {
+	if req.Model != "" {
+		return req.Model
+	}
+	return e.model
+}
+
+// phaseModelFor returns the model a run's call site asks for: the phase's
+// own override, then the run's model, then the one configured for the call
+// site. plan is the planner's phase; the others are the critic's.
+func (e *Engine) phaseModelFor(purpose string, req types.RunRequest) string {
+	override := req.CriticModel
+	if purpose == "plan" {
+		override = req.PlannerModel
+	}
+	return cmp.Or(override, req.Model, e.phaseModels[purpose])
+}
+
+// configuredModels returns the distinct models the call sites are
+// configured with.
+func (e *Engine) configuredModels() []string {
+	var models []string
+	for _, purpose := range []string{"plan", "analysis"} {
+		if m := e.phaseModels[purpose]; m != "" && !contains(models, m) {
+			models = append(models, m)
+		}
+	}
+	return models
+}
+
+// isOffline reports whether LLM calls are off for the whole engine or for the
+// run ctx belongs to.
+func (e *Engine) isOffline(ctx context.Context) bool {
+	return e.offline || cerebras.IsOffline(ctx)
+}
+
+// plannerTemperature and criticTemperature return a run's effective
+// temperatures, its own or the configured defaults; reproducible runs always
+// use 0.
+func (e *Engine) plannerTemperature(req types.RunRequest) float64 {
+	if req.Reproducible {
+		return 0
+	}
+	return temperatureOr(req.PlannerTemperature, e.plannerTemp)
+}
+
+func (e *Engine) criticTemperature(req types.RunRequest) float64 {
+	if req.Reproducible {
+		return 0
+	}
+	return temperatureOr(req.CriticTemperature, e.criticTemp)
+}
+
+// runSeed returns the seed for a reproducible run's LLM calls: the requested
+// one, or a hash of the goal so the same goal always gets the same seed. It
+// is nil for ordinary runs.
+func runSeed(req types.RunRequest) *int64 {
+	if !req.Reproducible {
+		return nil
+	}
+	if req.Seed != nil {
+		seed := *req.Seed
+		return &seed
+	}
+	h := fnv.New64a()
+	h.Write([]byte(req.Goal))
+	seed := int64(h.Sum64() >> 1)
+	return &seed
+}
+
+func temperatureOr(t *float64, def float64) float64 {
+	if t != nil {
+		return *t
+	}
+	return def
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
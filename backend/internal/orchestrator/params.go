@@ -0,0 +1,170 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// paramKind is the JSON type a simulator expects for a parameter.
+type paramKind int
+
+const (
+	kindNumber paramKind = iota
+	kindInteger
+	kindStringList
+)
+
+// toolParam is a variant parameter a simulator takes. Enum, when set, lists
+// the values a string list may hold.
+type toolParam struct {
+	name string
+	kind paramKind
+	enum []string
+}
+
+// toolFields are the variant parameters each simulator takes, typed as the
+// simulators' input models declare them.
+var toolFields = map[string][]toolParam{
+	"queue":    {{name: "arrival_rate", kind: kindNumber}, {name: "service_rate", kind: kindNumber}},
+	"traffic":  {{name: "density", kind: kindNumber}, {name: "signal_timing", kind: kindNumber}},
+	"resource": {{name: "staff", kind: kindInteger}, {name: "shifts", kind: kindStringList}},
+}
+
+// extractToolParams returns the parameters of params that toolName takes,
+// coerced to the types it expects, and a note for every value it had to
+// change. A value that can't be coerced is left as it was and reported in
+// err, the first such, so the call can be refused before it reaches the
+// simulator. Null values count as unset.
+func (e *Engine) extractToolParams(params map[string]any, toolName string) (extracted map[string]any, coercions []string, err error) {
+	extracted = make(map[string]any)
+	for _, field := range toolFields[toolName] {
+		val, exists := params[field.name]
+		if !exists || val == nil {
+			continue
+		}
+		coerced, note, ferr := field.coerce(val)
+		if ferr != nil {
+			extracted[field.name] = val
+			if err == nil {
+				err = ferr
+			}
+			continue
+		}
+		extracted[field.name] = coerced
+		if note != "" {
+			coercions = append(coercions, note)
+		}
+	}
+	return extracted, coercions, err
+}
+
+// coerce returns v as p's kind, with a note when that changed it.
+func (p toolParam) coerce(v any) (any, string, error) {
+	switch p.kind {
+	case kindNumber:
+		return p.number(v)
+	case kindInteger:
+		return p.integer(v)
+	default:
+		return p.stringList(v)
+	}
+}
+
+func (p toolParam) number(v any) (any, string, error) {
+	switch n := v.(type) {
+	case float64:
+		if math.IsNaN(n) || math.IsInf(n, 0) {
+			return nil, "", fmt.Errorf("%s must be a finite number, got %v", p.name, n)
+		}
+		return n, "", nil
+	case int:
+		return float64(n), "", nil
+	case int64:
+		return float64(n), "", nil
+	case json.Number:
+		return p.number(string(n))
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, "", fmt.Errorf("%s must be a number, got %q", p.name, n)
+		}
+		return f, fmt.Sprintf("%s: parsed string %q as %s", p.name, n, formatNumber(f)), nil
+	}
+	return nil, "", fmt.Errorf("%s must be a number, got %s", p.name, describe(v))
+}
+
+func (p toolParam) integer(v any) (any, string, error) {
+	switch n := v.(type) {
+	case int:
+		return n, "", nil
+	case int64:
+		return int(n), "", nil
+	case float64:
+		if n != math.Trunc(n) || math.Abs(n) > 1<<53 {
+			return nil, "", fmt.Errorf("%s must be an integer, got %s", p.name, formatNumber(n))
+		}
+		return int(n), fmt.Sprintf("%s: cast number %s to integer", p.name, formatNumber(n)), nil
+	case json.Number:
+		return p.integer(string(n))
+	case string:
+		i, err := strconv.Atoi(strings.TrimSpace(n))
+		if err != nil {
+			return nil, "", fmt.Errorf("%s must be an integer, got %q", p.name, n)
+		}
+		return i, fmt.Sprintf("%s: parsed string %q as %d", p.name, n, i), nil
+	}
+	return nil, "", fmt.Errorf("%s must be an integer, got %s", p.name, describe(v))
+}
+
+func (p toolParam) stringList(v any) (any, string, error) {
+	var items []any
+	note := ""
+	switch l := v.(type) {
+	case []string:
+		for _, s := range l {
+			items = append(items, s)
+		}
+	case []any:
+		items = l
+	case string:
+		items = []any{l}
+		note = fmt.Sprintf("%s: wrapped string %q in a list", p.name, l)
+	default:
+		return nil, "", fmt.Errorf("%s must be an array of strings, got %s", p.name, describe(v))
+	}
+	out := make([]string, 0, len(items))
+	for i, item := range items {
+		s, ok := item.(string)
+		if !ok {
+			return nil, "", fmt.Errorf("%s[%d] must be a string, got %s", p.name, i, describe(item))
+		}
+		if len(p.enum) > 0 && !slices.Contains(p.enum, s) {
+			return nil, "", fmt.Errorf("%s[%d] must be one of %s, got %q", p.name, i, strings.Join(p.enum, ", "), s)
+		}
+		out = append(out, s)
+	}
+	return out, note, nil
+}
+
+func formatNumber(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// describe renders a rejected value for an error message.
+func describe(v any) string {
+	switch v := v.(type) {
+	case string:
+		return strconv.Quote(v)
+	case float64:
+		return formatNumber(v)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(b)
+}
@@ -0,0 +1,140 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"simstack/internal/config"
+	"simstack/internal/testsupport"
+	"simstack/internal/types"
+)
+
+func TestCoerceParam(t *testing.T) {
+	number := toolParam{name: "arrival_rate", kind: kindNumber}
+	integer := toolParam{name: "staff", kind: kindInteger}
+	list := toolParam{name: "shifts", kind: kindStringList}
+	enum := toolParam{name: "shifts", kind: kindStringList, enum: []string{"day", "night"}}
+
+	tests := []struct {
+		name  string
+		param toolParam
+		in    any
+		want  any
+		note  string
+		err   string
+	}{
+		{"number as is", number, 10.5, 10.5, "", ""},
+		{"number from int", number, 10, 10.0, "", ""},
+		{"number from int64", number, int64(10), 10.0, "", ""},
+		{"number from json.Number", number, json.Number("10.5"), 10.5, `arrival_rate: parsed string "10.5" as 10.5`, ""},
+		{"number from string", number, " 12 ", 12.0, `arrival_rate: parsed string " 12 " as 12`, ""},
+		{"number from bad string", number, "fast", nil, "", `arrival_rate must be a number, got "fast"`},
+		{"number from NaN string", number, "NaN", nil, "", `arrival_rate must be a number, got "NaN"`},
+		{"number infinite", number, math.Inf(1), nil, "", "arrival_rate must be a finite number, got +Inf"},
+		{"number from bool", number, true, nil, "", "arrival_rate must be a number, got true"},
+		{"number from list", number, []any{1.0}, nil, "", "arrival_rate must be a number, got [1]"},
+
+		{"integer as is", integer, 20, 20, "", ""},
+		{"integer from int64", integer, int64(20), 20, "", ""},
+		{"integer from whole float", integer, 20.0, 20, "staff: cast number 20 to integer", ""},
+		{"integer from fractional float", integer, 20.5, nil, "", "staff must be an integer, got 20.5"},
+		{"integer from huge float", integer, 1e20, nil, "", "staff must be an integer, got 100000000000000000000"},
+		{"integer from string", integer, "20", 20, `staff: parsed string "20" as 20`, ""},
+		{"integer from json.Number", integer, json.Number("20"), 20, `staff: parsed string "20" as 20`, ""},
+		{"integer from fractional string", integer, "20.5", nil, "", `staff must be an integer, got "20.5"`},
+		{"integer from object", integer, map[string]any{"n": 1.0}, nil, "", `staff must be an integer, got {"n":1}`},
+
+		{"list as is", list, []string{"day"}, []string{"day"}, "", ""},
+		{"list from decoded JSON", list, []any{"day", "night"}, []string{"day", "night"}, "", ""},
+		{"list from single string", list, "day", []string{"day"}, `shifts: wrapped string "day" in a list`, ""},
+		{"list with a number", list, []any{"day", 3.0}, nil, "", "shifts[1] must be a string, got 3"},
+		{"list from number", list, 3.0, nil, "", "shifts must be an array of strings, got 3"},
+		{"enum member", enum, []any{"night"}, []string{"night"}, "", ""},
+		{"enum outsider", enum, []any{"day", "noon"}, nil, "", `shifts[1] must be one of day, night, got "noon"`},
+	}
+	for _, tt := range tests {
+		got, note, err := tt.param.coerce(tt.in)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s: expected error %q, got %v (value %v)", tt.name, tt.err, err, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) || note != tt.note {
+			t.Errorf("%s: got %#v with note %q, want %#v with note %q", tt.name, got, note, tt.want, tt.note)
+		}
+	}
+}
+
+func TestExtractToolParamsCoerces(t *testing.T) {
+	e := NewEngine(nil)
+	params := map[string]any{"staff": "20", "shifts": nil, "arrival_rate": 10.0}
+
+	got, coercions, err := e.extractToolParams(params, "resource")
+	if err != nil || !reflect.DeepEqual(got, map[string]any{"staff": 20}) || len(coercions) != 1 {
+		t.Errorf("expected staff coerced and null shifts dropped, got %v %v %v", got, coercions, err)
+	}
+
+	params["staff"] = 20.5
+	got, _, err = e.extractToolParams(params, "resource")
+	if err == nil || err.Error() != "staff must be an integer, got 20.5" || got["staff"] != 20.5 {
+		t.Errorf("expected staff refused and kept as given, got %v %v", got, err)
+	}
+}
+
+// A variant whose parameters a simulator can't take has that call refused
+// before dispatch, with the reason on its result; values that can be
+// coerced reach the simulator as the type it expects.
+func TestDispatchCoercesOrRefusesParameters(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []map[string]any
+	sim := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		bodies = append(bodies, body)
+		mu.Unlock()
+		fmt.Fprint(w, `{"metrics": {"coverage_units": 16}}`)
+	}))
+	defer sim.Close()
+	cfg, _ := config.Load()
+	cfg.SimulatorURLs = map[string]string{"resource": sim.URL}
+	cfg.SimulatorWarmup = false
+	e := NewEngine(nil, WithConfig(cfg), WithChatClient(testsupport.NewFakeChat(), "m"))
+	plan := types.SimulationPlan{Variants: []types.Variant{
+		{VariantID: "p-v1", Parameters: map[string]any{"staff": "20", "shifts": "day"}},
+		{VariantID: "p-v2", Parameters: map[string]any{"staff": 20.5}},
+	}}
+	byID := map[string]types.SimulationResult{}
+	for _, r := range e.simulate(context.Background(), plan) {
+		byID[r.VariantID] = r
+	}
+
+	if len(bodies) != 1 || bodies[0]["staff"] != 20.0 || !reflect.DeepEqual(bodies[0]["shifts"], []any{"day"}) {
+		t.Errorf("expected one call with coerced parameters, got %v", bodies)
+	}
+	if r := byID["p-v1"]; r.Status != types.ResultComplete || len(r.Coercions) != 2 {
+		t.Errorf("expected the coercions noted on p-v1, got %+v", r)
+	}
+	r := byID["p-v2"]
+	if r.Status != types.ResultFailed || len(r.Timing.Calls) != 1 {
+		t.Fatalf("expected p-v2 refused, got %+v", r)
+	}
+	if call := r.Timing.Calls[0]; !call.Failed || call.Attempts != 0 || call.Error != "staff must be an integer, got 20.5" {
+		t.Errorf("expected the refusal recorded on the call, got %+v", call)
+	}
+	if stats := e.SimulatorStats(); len(stats) != 1 || stats[0].Errors != 0 {
+		t.Errorf("a refusal must not count against the simulator, got %+v", stats)
+	}
+}
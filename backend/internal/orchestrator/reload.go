@@ -0,0 +1,54 @@
+package orchestrator
+
+import (
+	"simstack/internal/config"
+	"simstack/internal/llm"
+)
+
+func (e *Engine) config() *config.Config {
+	return e.cfg.Load()
+}
+
+// Config returns the active configuration.
+func (e *Engine) Config() config.Config {
+	return *e.config()
+}
+
+// Reload takes next's hot-swappable settings (config.Hot) and reports every
+// setting that differs from the active configuration, applied or not. The
+// swap is atomic: runs dispatched afterwards see all of next's applied
+// settings, variants already in flight finish on the ones they started with.
+func (e *Engine) Reload(next config.Config) config.Report {
+	e.reloadMu.Lock()
+	defer e.reloadMu.Unlock()
+	active := e.config()
+	report := config.Compare(*active, next)
+	applied := config.Apply(*active, next)
+
+	if applied.LLM.RPM != active.LLM.RPM || applied.LLM.Burst != active.LLM.Burst || applied.LLM.MaxConcurrent != active.LLM.MaxConcurrent {
+		if s, ok := e.llm.(llm.LimitSetter); ok {
+			s.SetLimits(applied.LLM.RPM, applied.LLM.Burst, applied.LLM.MaxConcurrent)
+		} else {
+			applied.LLM.RPM, applied.LLM.Burst, applied.LLM.MaxConcurrent = active.LLM.RPM, active.LLM.Burst, active.LLM.MaxConcurrent
+			report = rejectLLMLimits(report)
+		}
+	}
+	e.cfg.Store(&applied)
+	return report
+}
+
+// rejectLLMLimits moves the LLM limit changes of r to its rejected list.
+func rejectLLMLimits(r config.Report) config.Report {
+	applied := r.Applied[:0:0]
+	for _, c := range r.Applied {
+		switch c.Setting {
+		case "LLM.RPM", "LLM.Burst", "LLM.MaxConcurrent":
+			c.Reason = "the LLM provider has no client-side limiter"
+			r.Rejected = append(r.Rejected, c)
+		default:
+			applied = append(applied, c)
+		}
+	}
+	r.Applied = applied
+	return r
+}
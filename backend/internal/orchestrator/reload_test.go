@@ -0,0 +1,111 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"simstack/internal/config"
+	"simstack/internal/llm"
+	"simstack/internal/testsupport"
+	"simstack/internal/types"
+)
+
+func utilizationServer(t *testing.T, utilization float64, hold <-chan struct{}, called chan<- struct{}) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case called <- struct{}{}:
+		default:
+		}
+		if hold != nil {
+			<-hold
+		}
+		fmt.Fprintf(w, `{"metrics": {"utilization": %g}}`, utilization)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// A reload reaches runs dispatched after it; a variant already in flight
+// finishes against the simulator it started with. Run with -race.
+func TestReloadDuringDispatch(t *testing.T) {
+	hold, called := make(chan struct{}), make(chan struct{}, 1)
+	before := utilizationServer(t, 0.5, hold, called)
+	after := utilizationServer(t, 0.9, nil, nil)
+
+	cfg, _ := config.Load()
+	cfg.SimulatorURLs = map[string]string{"queue": before.URL}
+	e := NewEngine(nil, WithConfig(cfg), WithChatClient(testsupport.NewFakeChat(), "m"))
+	plan := types.SimulationPlan{Variants: []types.Variant{{VariantID: "p-v1", Parameters: map[string]any{"arrival_rate": 1}}}}
+
+	inFlight := make(chan []types.SimulationResult)
+	go func() { inFlight <- e.simulate(context.Background(), plan) }()
+	<-called
+
+	next := cfg
+	next.SimulatorURLs = map[string]string{"queue": after.URL}
+	next.SimulatorTimeout = 5 * time.Second
+	next.Addr = ":9999"
+	report := e.Reload(next)
+	if len(report.Applied) != 2 || len(report.Rejected) != 1 || report.Rejected[0].Setting != "Addr" {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if got := e.Config(); got.Addr != cfg.Addr || got.SimulatorTimeout != 5*time.Second {
+		t.Errorf("expected only the hot settings applied, got %+v", got)
+	}
+
+	if results := e.simulate(context.Background(), plan); results[0].Metrics["queue_utilization"] != 0.9 {
+		t.Errorf("expected a new run on the reloaded simulator, got %+v", results[0].Metrics)
+	}
+	close(hold)
+	if results := <-inFlight; results[0].Metrics["queue_utilization"] != 0.5 {
+		t.Errorf("expected the in-flight variant on its original simulator, got %+v", results[0].Metrics)
+	}
+
+	// Reloads racing dispatch never mix two configurations within a run
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			next := cfg
+			next.SimulatorURLs = map[string]string{"queue": []string{before.URL, after.URL}[i%2]}
+			next.VariantTimeout = time.Duration(i+1) * time.Minute
+			e.Reload(next)
+		}(i)
+		go func() {
+			defer wg.Done()
+			results := e.simulate(context.Background(), plan)
+			if u := results[0].Metrics["queue_utilization"]; u != 0.5 && u != 0.9 {
+				t.Errorf("unexpected metrics %+v", results[0].Metrics)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestReloadLLMLimits(t *testing.T) {
+	cfg, _ := config.Load()
+	next := cfg
+	next.LLM.RPM = 30
+
+	// The fake chat client has no limiter to change
+	e := NewEngine(nil, WithConfig(cfg), WithChatClient(testsupport.NewFakeChat(), "m"))
+	report := e.Reload(next)
+	if len(report.Applied) != 0 || len(report.Rejected) != 1 || report.Rejected[0].Reason != "the LLM provider has no client-side limiter" || e.Config().LLM.RPM != cfg.LLM.RPM {
+		t.Errorf("expected the limit change refused, got %+v", report)
+	}
+
+	provider, err := llm.New(llm.Config{Provider: "ollama"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e = NewEngine(nil, WithConfig(cfg), WithChatClient(provider, "m"))
+	if report := e.Reload(next); len(report.Applied) != 1 || e.Config().LLM.RPM != 30 {
+		t.Errorf("expected the limit applied, got %+v", report)
+	}
+}
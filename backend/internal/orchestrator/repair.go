@@ -0,0 +1,154 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// maxRepairBytes caps the content we try to repair; anything larger is more
+// likely a runaway completion than a near-miss worth salvaging.
+const maxRepairBytes = 32 << 10
+
+// repairJSON applies a fixed set of deterministic fixes to nearly-valid JSON:
+// single-quoted strings become double-quoted, bare object keys are quoted,
+// trailing commas are dropped, and a truncated tail has its open string and
+// brackets closed. It returns the rewritten text and whether anything changed.
+// The result is not guaranteed to be valid; callers re-parse it.
+func repairJSON(s string) (string, bool) {
+	var out strings.Builder
+	out.Grow(len(s) + 8)
+
+	var stack []byte // expected closers
+	var quote byte   // active string delimiter, 0 outside strings
+	escaped := false
+	last := byte(0) // last significant byte written outside strings
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if quote != 0 {
+			switch {
+			case escaped:
+				escaped = false
+				if quote == '\'' && c == '\'' {
+					// \' is not a valid JSON escape; drop the backslash
+					str := out.String()
+					out.Reset()
+					out.WriteString(str[:len(str)-1])
+				}
+				out.WriteByte(c)
+			case c == '\\':
+				escaped = true
+				out.WriteByte(c)
+			case c == quote:
+				quote = 0
+				last = '"'
+				out.WriteByte('"')
+			case c == '"':
+				out.WriteString(`\"`)
+			default:
+				out.WriteByte(c)
+			}
+			continue
+		}
+
+		switch {
+		case c == '"' || c == '\'':
+			quote = c
+			out.WriteByte('"')
+		case c == '{':
+			stack = append(stack, '}')
+			last = c
+			out.WriteByte(c)
+		case c == '[':
+			stack = append(stack, ']')
+			last = c
+			out.WriteByte(c)
+		case c == '}' || c == ']':
+			trimTrailingComma(&out)
+			if n := len(stack); n > 0 {
+				stack = stack[:n-1]
+			}
+			last = c
+			out.WriteByte(c)
+		case isIdentStart(c) && (last == '{' || last == ','):
+			j := i
+			for j < len(s) && isIdentPart(s[j]) {
+				j++
+			}
+			k := j
+			for k < len(s) && isSpace(s[k]) {
+				k++
+			}
+			if k < len(s) && s[k] == ':' {
+				out.WriteString(`"` + s[i:j] + `"`)
+			} else {
+				out.WriteString(s[i:j])
+			}
+			last = s[j-1]
+			i = j - 1
+		default:
+			if !isSpace(c) {
+				last = c
+			}
+			out.WriteByte(c)
+		}
+	}
+
+	// Truncated output: close the open string, drop a dangling separator and
+	// close whatever is still open
+	if quote != 0 {
+		if escaped {
+			str := out.String()
+			out.Reset()
+			out.WriteString(str[:len(str)-1])
+		}
+		out.WriteByte('"')
+	}
+	if len(stack) > 0 {
+		trimTrailingComma(&out)
+		if str := strings.TrimRight(out.String(), " \t\r\n"); strings.HasSuffix(str, ":") {
+			out.Reset()
+			out.WriteString(str + " null")
+		}
+		for i := len(stack) - 1; i >= 0; i-- {
+			out.WriteByte(stack[i])
+		}
+	}
+
+	fixed := out.String()
+	return fixed, fixed != s
+}
+
+// tryRepair returns repaired content when strict parsing failed but the
+// repaired text is valid JSON.
+func tryRepair(content string) (string, bool) {
+	if len(content) > maxRepairBytes {
+		return "", false
+	}
+	fixed, changed := repairJSON(content)
+	if !changed || !json.Valid([]byte(fixed)) {
+		return "", false
+	}
+	return fixed, true
+}
+
+func trimTrailingComma(out *strings.Builder) {
+	str := out.String()
+	trimmed := strings.TrimRight(str, " \t\r\n")
+	if strings.HasSuffix(trimmed, ",") {
+		out.Reset()
+		out.WriteString(trimmed[:len(trimmed)-1] + str[len(trimmed):])
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || (c >= '0' && c <= '9')
+}
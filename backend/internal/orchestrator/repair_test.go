@@ -0,0 +1,69 @@
+package orchestrator
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRepairJSON(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"trailing comma in object", `{"a": 1,}`, `{"a": 1}`},
+		{"trailing comma in array", `[1, 2, 3, ]`, `[1, 2, 3 ]`},
+		{"nested trailing commas", `{"v": [{"id": "v1",},],}`, `{"v": [{"id": "v1"}]}`},
+		{"trailing comma before newline", "{\"a\": 1,\n}", "{\"a\": 1\n}"},
+		{"single quoted strings", `{'id': 'v1'}`, `{"id": "v1"}`},
+		{"single quoted with double quote inside", `{'msg': 'say "hi"'}`, `{"msg": "say \"hi\""}`},
+		{"escaped single quote", `{'msg': 'it\'s'}`, `{"msg": "it's"}`},
+		{"apostrophe in double quoted string", `{"msg": "it's fine"}`, `{"msg": "it's fine"}`},
+		{"unquoted keys", `{id: "v1", arrival_rate: 10}`, `{"id": "v1", "arrival_rate": 10}`},
+		{"unquoted nested keys", `{"queue": {service_rate: 12}}`, `{"queue": {"service_rate": 12}}`},
+		{"literals left alone", `{"a": true, "b": [null, false]}`, `{"a": true, "b": [null, false]}`},
+		{"bare literal in array after comma", `[1, true]`, `[1, true]`},
+		{"truncated after value", `{"variants": [{"id": "v1", "queue": {"arrival_rate": 10`, `{"variants": [{"id": "v1", "queue": {"arrival_rate": 10}}]}`},
+		{"truncated after comma", `{"a": 1, `, `{"a": 1 }`},
+		{"truncated inside string", `{"recommendation": "use v2`, `{"recommendation": "use v2"}`},
+		{"truncated after escape", `{"a": "x\`, `{"a": "x"}`},
+		{"truncated after colon", `{"a": 1, "b":`, `{"a": 1, "b": null}`},
+		{"combined", `{variants: [{'id': 'v1', queue: {arrival_rate: 10,},},`, `{"variants": [{"id": "v1", "queue": {"arrival_rate": 10}}]}`},
+	}
+	for _, tc := range cases {
+		got, changed := repairJSON(tc.in)
+		if got != tc.want {
+			t.Errorf("%s: repairJSON(%q) = %q, want %q", tc.name, tc.in, got, tc.want)
+		}
+		if changed != (tc.in != tc.want) {
+			t.Errorf("%s: changed = %v", tc.name, changed)
+		}
+		if _, ok := tryRepair(tc.in); ok != changed {
+			t.Errorf("%s: tryRepair ok = %v, want %v", tc.name, ok, changed)
+		}
+	}
+}
+
+func TestRepairJSONLeavesGarbageUnparseable(t *testing.T) {
+	cases := []string{
+		`Here are three variants you could try.`,
+		`{"a": 1 "b": 2}`,
+		`{"a": tru}`,
+		`{"a`,
+		`{"a": 1}}`,
+		`{"a": [1, 2}`,
+		`{: 1}`,
+		"```json\n{\"a\": 1,}\n```",
+		// Repairable, but over the size cap
+		`[` + strings.Repeat(`1, `, maxRepairBytes/2) + `]`,
+	}
+	for _, in := range cases {
+		if _, ok := tryRepair(in); ok {
+			name := in
+			if len(name) > 40 {
+				name = name[:40] + "..."
+			}
+			t.Errorf("tryRepair(%q) unexpectedly succeeded", name)
+		}
+	}
+}
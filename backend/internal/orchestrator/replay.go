@@ -0,0 +1,135 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"maps"
+
+	"simstack/internal/types"
+)
+
+// Replay is a plan checked and ready to run again, with the request the
+// critic weighs its results against.
+type Replay struct {
+	Request types.RunRequest
+	Plan    types.SimulationPlan
+	// The stored run the plan came from; empty for a plan given inline
+	ReplayOf string
+}
+
+// PrepareReplay resolves req to the plan it replays and checks that every
+// variant only names simulators this engine knows. A stored run is replayed
+// with the models, temperatures and seed its manifest records. A run ID that isn't stored
+// fails with runstore.ErrNotFound; anything else wrong with the request
+// fails with ErrInvalidRequest.
+func (e *Engine) PrepareReplay(ctx context.Context, req types.ReplayRequest) (Replay, error) {
+	if (req.RunID == "") == (req.Plan == nil) {
+		return Replay{}, fmt.Errorf("%w: give either run_id or plan", ErrInvalidRequest)
+	}
+	r := Replay{Request: types.RunRequest{Goal: req.Goal, Offline: req.Offline}}
+	if req.RunID != "" {
+		run, err := e.store.Get(ctx, req.RunID)
+		if err != nil {
+			return Replay{}, err
+		}
+		if run.Plan == nil {
+			return Replay{}, fmt.Errorf("%w: run %s has no plan yet", ErrInvalidRequest, req.RunID)
+		}
+		r.Plan, r.ReplayOf = *run.Plan, run.ID
+		if r.Request.Goal == "" {
+			r.Request.Goal = run.Goal
+		}
+		if m := run.Manifest; m != nil {
+			r.Request.Model, r.Request.PlannerModel, r.Request.CriticModel = m.Model, m.PlannerModel, m.CriticModel
+			r.Request.Reproducible = m.Reproducible
+			if !m.Reproducible {
+				// A reproducible run pins both to 0 itself
+				planner, critic := m.PlannerTemperature, m.CriticTemperature
+				r.Request.PlannerTemperature, r.Request.CriticTemperature = &planner, &critic
+			}
+			if m.Seed != nil {
+				seed := *m.Seed
+				r.Request.Seed = &seed
+			}
+		}
+	} else {
+		r.Plan = *req.Plan
+		if r.Request.Goal == "" {
+			r.Request.Goal = "Replay of " + r.Plan.PlanID
+		}
+	}
+	if len(r.Plan.Steps) == 0 {
+		r.Plan.Steps = planSteps()
+	}
+	if err := e.checkPlan(&r.Plan); err != nil {
+		return Replay{}, err
+	}
+	if err := e.ValidateRequest(ctx, r.Request); err != nil {
+		return Replay{}, err
+	}
+	return r, nil
+}
+
+// ReplayWithID runs r as run id: the usual run with its events, except that
+// it starts from r's plan instead of asking the planner for one.
+func (e *Engine) ReplayWithID(ctx context.Context, id string, r Replay) error {
+	return e.execute(ctx, id, r.Request, &r)
+}
+
+// checkPlan rejects a plan that names a simulator the engine doesn't know,
+// in a step or as a variant's tool-scoped parameters, or whose variants
+// give a simulator nothing to do. Tool-scoped parameters, such as
+// {"queue": {"arrival_rate": 10}}, are flattened into the variant the way
+// planner output is.
+func (e *Engine) checkPlan(plan *types.SimulationPlan) error {
+	known := func(tool string) bool { return toolFields[tool] != nil }
+	if plan.PlanID == "" {
+		return fmt.Errorf("%w: plan has no plan_id", ErrInvalidRequest)
+	}
+	if len(plan.Variants) == 0 {
+		return fmt.Errorf("%w: plan has no variants", ErrInvalidRequest)
+	}
+	for _, step := range plan.Steps {
+		if !known(step.Tool) {
+			return fmt.Errorf("%w: step %q uses unknown tool %q", ErrInvalidRequest, step.Name, step.Tool)
+		}
+	}
+	seen := make(map[string]bool, len(plan.Variants))
+	variants := make([]types.Variant, len(plan.Variants))
+	for i, v := range plan.Variants {
+		if v.VariantID == "" || seen[v.VariantID] {
+			return fmt.Errorf("%w: variant %d needs a unique variant_id", ErrInvalidRequest, i+1)
+		}
+		seen[v.VariantID] = true
+		params := make(map[string]any, len(v.Parameters))
+		for name, val := range v.Parameters {
+			if _, scoped := val.(map[string]any); !scoped {
+				params[name] = val
+			}
+		}
+		for name, val := range v.Parameters {
+			scoped, ok := val.(map[string]any)
+			if !ok {
+				continue
+			}
+			if !known(name) {
+				return fmt.Errorf("%w: variant %s references unknown tool %q", ErrInvalidRequest, v.VariantID, name)
+			}
+			maps.Copy(params, scoped)
+		}
+		used := false
+		for tool := range toolFields {
+			extracted, _, err := e.extractToolParams(params, tool)
+			if err != nil {
+				return fmt.Errorf("%w: variant %s: %v", ErrInvalidRequest, v.VariantID, err)
+			}
+			used = used || len(extracted) > 0
+		}
+		if !used {
+			return fmt.Errorf("%w: variant %s has no parameters for any simulator", ErrInvalidRequest, v.VariantID)
+		}
+		variants[i] = types.Variant{VariantID: v.VariantID, Parameters: params}
+	}
+	plan.Variants = variants
+	return nil
+}
@@ -0,0 +1,162 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"simstack/internal/config"
+	"simstack/internal/eventbus"
+	"simstack/internal/runstore"
+	"simstack/internal/testsupport"
+	"simstack/internal/types"
+)
+
+func TestReplayRunsStoredPlanWithoutPlanning(t *testing.T) {
+	var mu sync.Mutex
+	var sent []map[string]any
+	sim := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var params map[string]any
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &params)
+		mu.Lock()
+		sent = append(sent, params)
+		mu.Unlock()
+		fmt.Fprint(w, `{"metrics": {"utilization": 0.5}}`)
+	}))
+	defer sim.Close()
+	cfg, _ := config.Load()
+	cfg.SimulatorURLs = map[string]string{"queue": sim.URL}
+	cfg.SimulatorWarmup = false
+	store := runstore.NewMemory()
+	plan := types.SimulationPlan{PlanID: "plan-1", Steps: planSteps(), Variants: []types.Variant{
+		{VariantID: "plan-1-v1", Parameters: map[string]any{"arrival_rate": 8.0, "service_rate": 12.0}},
+		{VariantID: "plan-1-v2", Parameters: map[string]any{"arrival_rate": 9.0, "service_rate": 15.0}},
+	}}
+	ctx := context.Background()
+	_ = store.Save(ctx, types.RunRecord{ID: "run-1", Goal: "cut waits", Status: "completed", PlanID: "plan-1", Plan: &plan})
+	rec := &recorder{}
+	chat := testsupport.NewFakeChat()
+	e := NewEngine(eventbus.Direct(rec.emit), WithConfig(cfg), WithChatClient(chat, "m"), WithRunStore(store))
+
+	replay, err := e.PrepareReplay(ctx, types.ReplayRequest{RunID: "run-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replay.ReplayOf != "run-1" || replay.Request.Goal != "cut waits" {
+		t.Errorf("expected the original run's goal, got %+v", replay)
+	}
+	if err := e.ReplayWithID(ctx, "run-2", replay); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the critic asked the model
+	if chat.Calls() != 1 || strings.Contains(fmt.Sprint(chat.Requests[0].Messages[0].Content), "planning") {
+		t.Errorf("expected one critic call and no planner call, got %d calls", chat.Calls())
+	}
+	if len(sent) != 2 {
+		t.Errorf("expected both variants simulated, got %v", sent)
+	}
+	planned := rec.ofType(types.EventPlan)
+	if len(planned) != 1 || planned[0].RunID != "run-2" || planned[0].Payload.(types.SimulationPlan).PlanID != "plan-1" {
+		t.Errorf("expected the stored plan sent for the new run, got %+v", planned)
+	}
+	done := rec.ofType(types.EventDone)
+	if len(done) != 1 || done[0].Payload.(types.DoneEvent).ReplayOf != "run-1" {
+		t.Errorf("expected the done event to name the original run, got %+v", done)
+	}
+	run, err := store.Get(ctx, "run-2")
+	if err != nil || run.Status != "completed" || len(run.Results) != 2 || run.Manifest.ReplayOf != "run-1" || run.Manifest.PhaseTimings.PlannerMs != 0 {
+		t.Errorf("expected the replay saved as its own run, got %+v (%v)", run, err)
+	}
+}
+
+// A replay by run_id is the same run again: the manifest's models,
+// temperatures and seed carry over, not just the goal.
+func TestPrepareReplayKeepsManifestSettings(t *testing.T) {
+	store := runstore.NewMemory()
+	seed := int64(7)
+	plan := types.SimulationPlan{PlanID: "plan-1", Variants: []types.Variant{{VariantID: "plan-1-v1", Parameters: map[string]any{"staff": 20.0}}}}
+	ctx := context.Background()
+	_ = store.Save(ctx, types.RunRecord{ID: "run-1", Goal: "cut waits", Status: "completed", Plan: &plan, Manifest: &types.RunManifest{
+		Model: "big", PlannerModel: "planner", CriticModel: "critic", Reproducible: true, Seed: &seed,
+	}})
+	_ = store.Save(ctx, types.RunRecord{ID: "run-2", Goal: "cut waits", Status: "completed", Plan: &plan, Manifest: &types.RunManifest{
+		Model: "big", PlannerModel: "big", CriticModel: "big", PlannerTemperature: 0.4, CriticTemperature: 0.2,
+	}})
+	e := NewEngine(nil, WithChatClient(testsupport.NewFakeChat(), "m"), WithRunStore(store))
+
+	reproducible, err := e.PrepareReplay(ctx, types.ReplayRequest{RunID: "run-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ordinary, err := e.PrepareReplay(ctx, types.ReplayRequest{RunID: "run-2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, c := range map[string]struct{ got, want any }{
+		"model":               {e.modelFor(reproducible.Request), "big"},
+		"planner model":       {e.phaseModelFor("plan", reproducible.Request), "planner"},
+		"critic model":        {e.phaseModelFor("analysis", reproducible.Request), "critic"},
+		"reproducible":        {reproducible.Request.Reproducible, true},
+		"seed":                {deref(runSeed(reproducible.Request)), int64(7)},
+		"pinned temperature":  {e.plannerTemperature(reproducible.Request), 0.0},
+		"planner temperature": {e.plannerTemperature(ordinary.Request), 0.4},
+		"critic temperature":  {e.criticTemperature(ordinary.Request), 0.2},
+		"no seed":             {deref(runSeed(ordinary.Request)), nil},
+	} {
+		if c.got != c.want {
+			t.Errorf("%s: got %v, want %v", name, c.got, c.want)
+		}
+	}
+}
+
+// deref returns what p points at, or nil.
+func deref[T any](p *T) any {
+	if p == nil {
+		return nil
+	}
+	return *p
+}
+
+func TestPrepareReplayChecksPlan(t *testing.T) {
+	cfg, _ := config.Load()
+	e := NewEngine(nil, WithConfig(cfg), WithChatClient(testsupport.NewFakeChat(), "m"))
+	ctx := context.Background()
+
+	inline := &types.SimulationPlan{PlanID: "p", Variants: []types.Variant{
+		{VariantID: "p-v1", Parameters: map[string]any{"queue": map[string]any{"arrival_rate": 8.0}, "staff": 20.0}},
+	}}
+	r, err := e.PrepareReplay(ctx, types.ReplayRequest{Plan: inline})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.ReplayOf != "" || len(r.Plan.Steps) != 3 || r.Plan.Variants[0].Parameters["arrival_rate"] != 8.0 || r.Plan.Variants[0].Parameters["staff"] != 20.0 {
+		t.Errorf("expected tool-scoped parameters flattened and the default steps, got %+v", r.Plan)
+	}
+
+	for name, req := range map[string]types.ReplayRequest{
+		"neither":      {},
+		"both":         {RunID: "run-1", Plan: inline},
+		"no variants":  {Plan: &types.SimulationPlan{PlanID: "p"}},
+		"unknown step": {Plan: &types.SimulationPlan{PlanID: "p", Steps: []types.PlanStep{{Name: "Weather", Tool: "weather"}}, Variants: inline.Variants}},
+		"unknown tool": {Plan: &types.SimulationPlan{PlanID: "p", Variants: []types.Variant{{VariantID: "v", Parameters: map[string]any{"weather": map[string]any{"rain": 1.0}}}}}},
+		"no tool":      {Plan: &types.SimulationPlan{PlanID: "p", Variants: []types.Variant{{VariantID: "v", Parameters: map[string]any{"rain": 1.0}}}}},
+		"bad value":    {Plan: &types.SimulationPlan{PlanID: "p", Variants: []types.Variant{{VariantID: "v", Parameters: map[string]any{"staff": "many"}}}}},
+		"duplicate id": {Plan: &types.SimulationPlan{PlanID: "p", Variants: []types.Variant{inline.Variants[0], inline.Variants[0]}}},
+	} {
+		if _, err := e.PrepareReplay(ctx, req); !errors.Is(err, ErrInvalidRequest) {
+			t.Errorf("%s: expected ErrInvalidRequest, got %v", name, err)
+		}
+	}
+	if _, err := e.PrepareReplay(ctx, types.ReplayRequest{RunID: "missing"}); !errors.Is(err, runstore.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,236 @@
+package orchestrator
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sort"
+	"time"
+
+	"simstack/internal/archive"
+	"simstack/internal/grafana"
+	"simstack/internal/llm"
+	"simstack/internal/runstore"
+	"simstack/internal/types"
+)
+
+func (e *Engine) saveRun(ctx context.Context, run types.RunRecord) {
+	e.annotationsMu.Lock()
+	defer e.annotationsMu.Unlock()
+	e.keepAnnotations(ctx, &run)
+	if err := e.store.Save(ctx, run); err != nil {
+		slog.ErrorContext(ctx, "run store save failed", "run_id", run.ID, "err", err)
+	}
+	if run.FinishedAt != nil {
+		e.evictRuns()
+	}
+}
+
+// evictRuns trims the runs held in memory to the retention policy
+// (SIMSTACK_RUN_RETENTION, SIMSTACK_RUN_MAX_RESIDENT). Runs in flight stay.
+func (e *Engine) evictRuns() {
+	if n := e.registry.Evict(e.clock.Now().UTC(), e.runActive); n > 0 {
+		e.counters.RunsEvicted.Add(int64(n))
+	}
+}
+
+// indexGoal embeds the run's goal so it can be found by similarity later.
+// Failures are logged; SimilarRuns embeds lazily for runs that missed out.
+func (e *Engine) indexGoal(ctx context.Context, run *types.RunRecord) {
+	if e.isOffline(ctx) && e.embedder.Name() != (llm.TrigramEmbedder{}).Name() {
+		// Left for SimilarRuns to embed once the provider may be contacted
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	vecs, err := e.embedder.Embed(ctx, []string{run.Goal})
+	if err != nil || len(vecs) != 1 {
+		slog.WarnContext(ctx, "goal embedding unavailable", "run_id", run.ID, "err", err)
+		return
+	}
+	run.GoalEmbedding = vecs[0]
+	run.EmbeddingSpace = e.embedder.Name()
+}
+
+// SaveUnstarted records run id, which pursued goal, as canceled before it
+// started: a run still queued when the server shut down.
+func (e *Engine) SaveUnstarted(ctx context.Context, id, goal string) error {
+	now := e.clock.Now().UTC()
+	return e.store.Save(ctx, types.RunRecord{ID: id, Goal: goal, Status: outcomeCanceled, StartedAt: now, FinishedAt: &now})
+}
+
+// GetRun returns the stored record of run id, in flight or finished.
+func (e *Engine) GetRun(ctx context.Context, id string) (types.RunRecord, error) {
+	return e.store.Get(ctx, id)
+}
+
+// GrafanaDashboard returns a Grafana dashboard of run id's results.
+func (e *Engine) GrafanaDashboard(ctx context.Context, id string) (grafana.Dashboard, error) {
+	run, err := e.store.Get(ctx, id)
+	if err != nil {
+		return grafana.Dashboard{}, err
+	}
+	return grafana.Build(run, e.metrics), nil
+}
+
+// Cancel stops run id if it is in flight and reports whether it was.
+func (e *Engine) Cancel(id string) bool {
+	cancel, ok := e.active.Load(id)
+	if ok {
+		cancel.(context.CancelFunc)()
+	}
+	return ok
+}
+
+func (e *Engine) Runs(ctx context.Context, opts runstore.ListOptions) ([]types.RunSummary, error) {
+	runs, err := e.store.List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]types.RunSummary, 0, len(runs))
+	for _, r := range runs {
+		out = append(out, r.Summary())
+	}
+	return out, nil
+}
+
+// ExportRuns writes the run history matching opts, with artifact content if
+// asked, to w as an archive and returns how many runs it holds.
+func (e *Engine) ExportRuns(ctx context.Context, w io.Writer, opts archive.ExportOptions) (int, error) {
+	return archive.Export(ctx, w, e.store, e.artifacts, opts)
+}
+
+// ImportRuns reads an archive written by ExportRuns into the run store.
+func (e *Engine) ImportRuns(ctx context.Context, r io.Reader, opts archive.ImportOptions) (archive.Report, error) {
+	return archive.Import(ctx, r, e.store, e.artifacts, opts)
+}
+
+// LLMCalls returns the audit trail of a run's LLM calls.
+func (e *Engine) LLMCalls(ctx context.Context, id string) ([]types.LLMAuditRecord, error) {
+	return e.store.LLMCalls(ctx, id)
+}
+
+// SimilarRuns ranks past runs by goal similarity to run id, most similar first.
+// Runs whose stored embedding is missing or from another embedding space are
+// re-embedded in one batch and saved back.
+func (e *Engine) SimilarRuns(ctx context.Context, id string, limit int) ([]types.SimilarRun, error) {
+	if _, err := e.store.Get(ctx, id); err != nil {
+		return nil, err
+	}
+	runs, err := e.store.List(ctx, runstore.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	space := e.embedder.Name()
+	var stale []int
+	var texts []string
+	for i, r := range runs {
+		if r.EmbeddingSpace != space || len(r.GoalEmbedding) == 0 {
+			stale = append(stale, i)
+			texts = append(texts, r.Goal)
+		}
+	}
+	if len(texts) > 0 {
+		vecs, err := e.embedder.Embed(ctx, texts)
+		if err != nil {
+			return nil, err
+		}
+		// The embedder may have degraded mid-call; tag with the space that produced the vectors
+		space = e.embedder.Name()
+		for j, i := range stale {
+			runs[i].GoalEmbedding = vecs[j]
+			runs[i].EmbeddingSpace = space
+			e.saveRun(ctx, runs[i])
+		}
+	}
+
+	var target []float64
+	for _, r := range runs {
+		if r.ID == id {
+			target = r.GoalEmbedding
+		}
+	}
+
+	out := make([]types.SimilarRun, 0, len(runs))
+	for _, r := range runs {
+		if r.ID == id {
+			continue
+		}
+		out = append(out, types.SimilarRun{RunSummary: r.Summary(), Similarity: llm.Cosine(target, r.GoalEmbedding)})
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Similarity > out[j].Similarity })
+	if limit > 0 && limit < len(out) {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+// runMetrics completes m, which carries the phase timings, from the run's
+// results and LLM call records.
+func runMetrics(run types.RunRecord, results []types.SimulationResult, m types.RunMetrics) types.RunMetrics {
+	m.RunID = run.ID
+	m.StartedAt = run.StartedAt
+	if run.FinishedAt != nil {
+		m.CompletedAt = *run.FinishedAt
+	}
+	var latencyMs int64
+	if run.Manifest != nil {
+		for _, call := range run.Manifest.LLMCalls {
+			m.LLMCalls++
+			if call.Error != "" {
+				m.FailedLLMCalls++
+			}
+			m.PromptTokens += call.PromptTokens
+			m.TotalTokens += call.Tokens
+			latencyMs += call.LatencyMs
+		}
+	}
+	if latencyMs > 0 {
+		m.TokensPerSecond = float64(m.TotalTokens) / (float64(latencyMs) / 1000)
+	}
+	if run.Manifest != nil {
+		m.CostUSD = run.Manifest.CostUSD
+	}
+	m.Variants = len(results)
+	var queued int64
+	var timed int
+	calls := map[string]int{}
+	for _, r := range results {
+		switch r.Status {
+		case types.ResultPartial:
+			m.PartialVariants++
+		case types.ResultFailed:
+			m.FailedVariants++
+		}
+		if r.Timing == nil {
+			continue
+		}
+		timed++
+		queued += r.Timing.QueueMs
+		for _, c := range r.Timing.Calls {
+			if m.ToolTotalMs == nil {
+				m.ToolTotalMs = map[string]int64{}
+			}
+			m.ToolTotalMs[c.Tool] += c.DurationMs
+			calls[c.Tool]++
+		}
+	}
+	if timed > 0 {
+		m.AvgQueueMs = float64(queued) / float64(timed)
+	}
+	for tool, total := range m.ToolTotalMs {
+		if m.ToolAvgMs == nil {
+			m.ToolAvgMs = map[string]float64{}
+		}
+		m.ToolAvgMs[tool] = float64(total) / float64(calls[tool])
+	}
+	return m
+}
+
+// RunMetrics returns the performance record of run id, if it is still in the
+// metrics history.
+func (e *Engine) RunMetrics(id string) (types.RunMetrics, bool) {
+	return e.history.Get(id)
+}
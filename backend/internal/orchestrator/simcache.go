@@ -0,0 +1,62 @@
+package orchestrator
+
+import (
+	"context"
+	"slices"
+
+	"simstack/internal/config"
+	"simstack/internal/simcache"
+	"simstack/internal/types"
+)
+
+type noCacheKey struct{}
+
+// withNoCache keeps the simulator calls made with ctx out of the response
+// cache.
+func withNoCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noCacheKey{}, true)
+}
+
+func cacheBypassed(ctx context.Context) bool {
+	bypassed, _ := ctx.Value(noCacheKey{}).(bool)
+	return bypassed
+}
+
+// cacheKey returns the response cache key of a call to toolName at baseURL
+// with params, and whether its response may be kept forever. A simulator in
+// cfg's deterministic list answers the same parameters the same way, so its
+// key ignores the seed and its responses never expire. Any other simulator
+// is only cached when the variant carries a seed, keyed by it and for the
+// cache's TTL. ok is false when the call can't be cached.
+func (e *Engine) cacheKey(cfg *config.Config, toolName, baseURL string, params map[string]any, seed any) (key simcache.Key, forever, ok bool) {
+	if !e.simCache.Enabled() {
+		return simcache.Key{}, false, false
+	}
+	if slices.Contains(cfg.SimulatorCacheDeterministic, toolName) {
+		return simcache.NewKey(toolName, baseURL, params, nil), true, true
+	}
+	if seed == nil {
+		return simcache.Key{}, false, false
+	}
+	return simcache.NewKey(toolName, baseURL, params, seed), false, true
+}
+
+// planNeeds reports whether any of plan's variants will call toolName: it
+// has valid parameters for it that the response cache can't answer.
+func (e *Engine) planNeeds(ctx context.Context, cfg *config.Config, plan types.SimulationPlan, toolName string) bool {
+	noCache := cacheBypassed(ctx)
+	for _, v := range plan.Variants {
+		params, _, err := e.extractToolParams(v.Parameters, toolName)
+		if len(params) == 0 || err != nil {
+			continue
+		}
+		key, _, ok := e.cacheKey(cfg, toolName, cfg.SimulatorURLs[toolName], params, v.Parameters["seed"])
+		if !ok || noCache {
+			return true
+		}
+		if _, hit := e.simCache.Get(key); !hit {
+			return true
+		}
+	}
+	return false
+}
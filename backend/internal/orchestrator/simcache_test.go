@@ -0,0 +1,133 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"simstack/internal/config"
+	"simstack/internal/eventbus"
+	"simstack/internal/testsupport"
+	"simstack/internal/types"
+)
+
+// countingSimulator answers every simulator and counts the requests for
+// each path.
+type countingSimulator struct {
+	mu       sync.Mutex
+	requests map[string]int
+	*httptest.Server
+}
+
+func newCountingSimulator(t *testing.T) *countingSimulator {
+	s := &countingSimulator{requests: map[string]int{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests[r.URL.Path]++
+		s.mu.Unlock()
+		fmt.Fprint(w, `{"metrics": {"utilization": 0.5}}`)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// take returns the requests counted since the last call.
+func (s *countingSimulator) take() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := s.requests
+	s.requests = map[string]int{}
+	return out
+}
+
+func TestReplayAnsweredFromCache(t *testing.T) {
+	sim := newCountingSimulator(t)
+	cfg, _ := config.Load()
+	cfg.SimulatorURLs = map[string]string{"queue": sim.URL, "traffic": sim.URL, "resource": sim.URL}
+	rec := &recorder{}
+	e := NewEngine(eventbus.Direct(rec.emit), WithConfig(cfg), WithChatClient(testsupport.NewFakeChat(), "m"))
+	req := types.RunRequest{Goal: "g", Offline: true}
+
+	if err := e.Run(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	if first := sim.take(); first["/simulate"] == 0 {
+		t.Fatalf("expected the first run to call the simulators, got %v", first)
+	}
+
+	rec.events = nil
+	if err := e.Run(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	if replay := sim.take(); len(replay) != 0 {
+		t.Errorf("expected the replay answered without any HTTP call, got %v", replay)
+	}
+	for _, ev := range rec.ofType(types.EventSimComplete) {
+		r := ev.Payload.(types.SimulationResult)
+		if r.Status != types.ResultComplete || len(r.Timing.Calls) == 0 || r.Metrics["queue_utilization"] != 0.5 {
+			t.Errorf("%s: expected complete cached metrics, got %+v", r.VariantID, r)
+		}
+		for _, call := range r.Timing.Calls {
+			if !call.Cached || call.Attempts != 0 {
+				t.Errorf("%s: expected %s marked cached, got %+v", r.VariantID, call.Tool, call)
+			}
+		}
+	}
+
+	// A changed variant misses; the unchanged one still hits
+	plan := types.SimulationPlan{Variants: []types.Variant{
+		{VariantID: "p-v1", Parameters: map[string]any{"arrival_rate": 8.0, "service_rate": 16.0}},
+		{VariantID: "p-v2", Parameters: map[string]any{"arrival_rate": 9.5, "service_rate": 16.0}},
+	}}
+	e.simulate(context.Background(), plan)
+	if got := sim.take()["/simulate"]; got != 1 {
+		t.Errorf("expected only the changed variant simulated, got %d calls", got)
+	}
+
+	// no_cache simulates everything afresh
+	if err := e.Run(context.Background(), types.RunRequest{Goal: "g", Offline: true, NoCache: true}); err != nil {
+		t.Fatal(err)
+	}
+	if got := sim.take(); got["/simulate"] == 0 || got["/healthz"] == 0 {
+		t.Errorf("expected a no_cache run to warm up and call the simulators, got %v", got)
+	}
+
+	c := e.Metrics(0).Counters
+	if c.SimulatorCacheHits == 0 || c.SimulatorCacheMisses == 0 {
+		t.Errorf("expected cache hits and misses counted, got %+v", c)
+	}
+}
+
+// Simulators not known to be deterministic are only cached for a matching
+// seed.
+func TestStochasticCallsNeedMatchingSeed(t *testing.T) {
+	sim := newCountingSimulator(t)
+	cfg, _ := config.Load()
+	cfg.SimulatorURLs = map[string]string{"queue": sim.URL}
+	cfg.SimulatorCacheDeterministic = nil
+	cfg.SimulatorWarmup = false
+	e := NewEngine(nil, WithConfig(cfg), WithChatClient(testsupport.NewFakeChat(), "m"))
+	params := func(seed any) map[string]any {
+		p := map[string]any{"arrival_rate": 8.0, "service_rate": 16.0}
+		if seed != nil {
+			p["seed"] = seed
+		}
+		return p
+	}
+	dispatch := func(seed any) int {
+		e.simulate(context.Background(), types.SimulationPlan{Variants: []types.Variant{{VariantID: "p-v1", Parameters: params(seed)}}})
+		return sim.take()["/simulate"]
+	}
+
+	for i, c := range []struct {
+		seed  any
+		calls int
+	}{{nil, 1}, {nil, 1}, {7.0, 1}, {7.0, 0}, {8.0, 1}} {
+		if got := dispatch(c.seed); got != c.calls {
+			t.Errorf("dispatch %d (seed %v): expected %d calls, got %d", i, c.seed, c.calls, got)
+		}
+	}
+}
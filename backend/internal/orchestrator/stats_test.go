@@ -0,0 +1,80 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"simstack/internal/config"
+	"simstack/internal/statsd"
+	"simstack/internal/testsupport"
+	"simstack/internal/types"
+)
+
+func TestRunPushesStatsD(t *testing.T) {
+	agent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer agent.Close()
+	stats, err := statsd.New(statsd.Config{Addr: agent.LocalAddr().String(), Prefix: "simstack.", Tags: true, FlushInterval: time.Hour, MaxPacketBytes: 65507})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sim := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "traffic") {
+			http.Error(w, "down", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, `{"metrics": {"utilization": 0.5}}`)
+	}))
+	defer sim.Close()
+	cfg, _ := config.Load()
+	cfg.SimulatorURLs = map[string]string{"queue": sim.URL, "traffic": sim.URL + "/traffic", "resource": sim.URL}
+	// Every variant calls every simulator, the failing one included
+	cfg.SimulatorCacheMaxEntries = 0
+	cfg.BreakerThreshold = 0
+	// The plan call succeeds with an unusable plan; analysis then fails
+	fake := testsupport.NewFakeChat(testsupport.Content("{}"))
+	e := NewEngine(nil, WithConfig(cfg), WithChatClient(fake, "m"), WithMetricsSink(stats))
+	if err := e.Run(context.Background(), types.RunRequest{Goal: "g"}); err != nil {
+		t.Fatal(err)
+	}
+	stats.Close()
+
+	agent.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 65536)
+	n, _, err := agent.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := map[string]int{}
+	for _, l := range strings.Split(string(buf[:n]), "\n") {
+		// Durations vary; keep the name, type and tags
+		name, rest, _ := strings.Cut(l, ":")
+		if strings.Contains(rest, "|ms") {
+			_, rest, _ = strings.Cut(rest, "|")
+			l = name + ":*|" + rest
+		}
+		lines[l]++
+	}
+	for line, want := range map[string]int{
+		"simstack.run.started:1|c":                                             1,
+		"simstack.run.completed:1|c|#status:completed":                         1,
+		"simstack.planner.latency:*|ms":                                        1,
+		"simstack.llm.tokens:150|c|#phase:plan":                                1,
+		"simstack.simulator.call.duration:*|ms|#tool:queue,outcome:success":    16,
+		"simstack.simulator.call.duration:*|ms|#tool:traffic,outcome:failure":  16,
+		"simstack.simulator.call.duration:*|ms|#tool:resource,outcome:success": 16,
+	} {
+		if lines[line] != want {
+			t.Errorf("expected %d of %q, got %d in %v", want, line, lines[line], lines)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"simstack/internal/testsupport"
+	"simstack/internal/tracing"
+	"simstack/internal/types"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestRunIsTracedEndToEnd(t *testing.T) {
+	if _, err := tracing.Setup(context.Background(), tracing.Config{}); err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var parents, pings []string
+	sim := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		if r.URL.Path == "/simulate" {
+			parents = append(parents, r.Header.Get("traceparent"))
+		} else {
+			pings = append(pings, r.Header.Get("traceparent"))
+		}
+		mu.Unlock()
+		fmt.Fprint(w, `{"metrics": {"utilization": 0.5}}`)
+	}))
+	defer sim.Close()
+	for _, key := range []string{"QUEUE_SIMULATOR_URL", "TRAFFIC_SIMULATOR_URL", "RESOURCE_SIMULATOR_URL"} {
+		t.Setenv(key, sim.URL)
+	}
+
+	spans := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))
+	e := NewEngine(nil, WithChatClient(testsupport.NewFakeChat(), "m"), WithTracerProvider(tp))
+	if err := e.Run(context.Background(), types.RunRequest{Goal: "reduce wait"}); err != nil {
+		t.Fatal(err)
+	}
+
+	byName := map[string][]sdktrace.ReadOnlySpan{}
+	for _, s := range spans.Ended() {
+		byName[s.Name()] = append(byName[s.Name()], s)
+	}
+	if len(byName["run"]) != 1 {
+		t.Fatalf("expected one run span, got %d", len(byName["run"]))
+	}
+	root := byName["run"][0]
+	if root.Parent().IsValid() {
+		t.Error("the run span should be the root")
+	}
+	traceID := root.SpanContext().TraceID()
+	childOf := func(s sdktrace.ReadOnlySpan, parent trace.SpanContext) bool {
+		return s.SpanContext().TraceID() == traceID && s.Parent().SpanID() == parent.SpanID()
+	}
+
+	for _, name := range []string{"plan", "analysis"} {
+		if len(byName[name]) != 1 || !childOf(byName[name][0], root.SpanContext()) {
+			t.Errorf("expected one %s span under the run", name)
+		}
+	}
+	variants := map[trace.SpanID]bool{}
+	for _, v := range byName["variant"] {
+		if !childOf(v, root.SpanContext()) {
+			t.Errorf("variant span %v is not under the run", v.Attributes())
+		}
+		variants[v.SpanContext().SpanID()] = true
+	}
+	if len(variants) == 0 {
+		t.Fatal("expected variant spans")
+	}
+	calls := byName["simulator.call"]
+	if len(calls) == 0 || len(calls) != len(parents) {
+		t.Fatalf("expected a span per simulator request, got %d spans for %d requests", len(calls), len(parents))
+	}
+	callIDs := map[string]bool{}
+	for _, c := range calls {
+		if c.SpanContext().TraceID() != traceID || !variants[c.Parent().SpanID()] {
+			t.Errorf("simulator call %v is not under a variant", c.Attributes())
+		}
+		callIDs[fmt.Sprintf("00-%s-%s-01", traceID, c.SpanContext().SpanID())] = true
+	}
+	for _, p := range parents {
+		if !callIDs[p] {
+			t.Errorf("traceparent %q does not name a simulator call span", p)
+		}
+	}
+
+	// Warm-up pings precede the variants, under their own span
+	if len(byName["warmup"]) != 1 || !childOf(byName["warmup"][0], root.SpanContext()) {
+		t.Fatal("expected one warmup span under the run")
+	}
+	pingIDs := map[string]bool{}
+	for _, c := range byName["simulator.warmup"] {
+		if !childOf(c, byName["warmup"][0].SpanContext()) {
+			t.Errorf("warm-up ping %v is not under the warmup span", c.Attributes())
+		}
+		pingIDs[fmt.Sprintf("00-%s-%s-01", traceID, c.SpanContext().SpanID())] = true
+	}
+	if len(pings) == 0 || len(pingIDs) != len(pings) {
+		t.Errorf("expected a span per warm-up ping, got %d spans for %d pings", len(pingIDs), len(pings))
+	}
+	for _, p := range pings {
+		if !pingIDs[p] {
+			t.Errorf("traceparent %q does not name a warm-up span", p)
+		}
+	}
+
+	// The fake chat has no replies, so every LLM call fails into fallbacks
+	llmCalls := byName["llm.call"]
+	if len(llmCalls) == 0 {
+		t.Fatal("expected llm.call spans")
+	}
+	for _, c := range llmCalls {
+		if c.SpanContext().TraceID() != traceID || c.Status().Code != codes.Error {
+			t.Errorf("expected a failed LLM call in the run's trace, got %v", c.Status())
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package orchestrator
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime/pprof"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"simstack/internal/clock"
+	"simstack/internal/types"
+)
+
+// ErrRunStalled is what a run the watchdog failed returns, should it ever
+// return.
+var ErrRunStalled = errors.New("run stalled")
+
+// ErrRunCanceled is returned by a run stopped by Cancel. The run is saved
+// canceled with the variants that finished.
+var ErrRunCanceled = errors.New("run canceled")
+
+// stallReason is the reason recorded on a run failed for stalling.
+const stallReason = "stalled"
+
+// States of a watched run
+const (
+	watchRunning int32 = iota
+	watchStalled
+	watchFinished
+)
+
+// runWatchdog fails a run that goes timeout without progress: an event
+// sent, a result added, or a heartbeat from a phase that is slow but still
+// working, such as a long critic call. A nil watchdog watches nothing.
+type runWatchdog struct {
+	clock   clock.Clock
+	timeout time.Duration
+	// Unix nanoseconds of the latest progress, and the latest event sent
+	last      atomic.Int64
+	lastEvent atomic.Pointer[string]
+	state     atomic.Int32
+	onStall   func(w *runWatchdog, idle time.Duration)
+	done      chan struct{}
+	stop      sync.Once
+}
+
+// newRunWatchdog starts watching a run, calling onStall once should it go
+// timeout on c without progress; it returns nil when timeout is 0.
+func newRunWatchdog(c clock.Clock, timeout time.Duration, onStall func(w *runWatchdog, idle time.Duration)) *runWatchdog {
+	if timeout <= 0 {
+		return nil
+	}
+	w := &runWatchdog{clock: c, timeout: timeout, onStall: onStall, done: make(chan struct{})}
+	w.last.Store(c.Now().UnixNano())
+	go w.watch()
+	return w
+}
+
+func (w *runWatchdog) watch() {
+	timer := w.clock.NewTimer(w.timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-timer.C():
+		}
+		idle := w.clock.Now().Sub(time.Unix(0, w.last.Load()))
+		if idle < w.timeout {
+			timer.Reset(w.timeout - idle)
+			continue
+		}
+		if w.state.CompareAndSwap(watchRunning, watchStalled) {
+			w.onStall(w, idle)
+		}
+		return
+	}
+}
+
+// progress notes that the run is moving.
+func (w *runWatchdog) progress() {
+	if w != nil {
+		w.last.Store(w.clock.Now().UnixNano())
+	}
+}
+
+// sent notes an event of type typ going out.
+func (w *runWatchdog) sent(typ string) {
+	if w != nil {
+		w.progress()
+		// Runs send the same few types over and over
+		if last := w.lastEvent.Load(); last == nil || *last != typ {
+			w.lastEvent.Store(&typ)
+		}
+	}
+}
+
+// heartbeat keeps the run alive while a slow phase works, until the
+// returned func is called.
+func (w *runWatchdog) heartbeat() func() {
+	if w == nil {
+		return func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		ticker := w.clock.NewTicker(w.timeout / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C():
+				w.progress()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// stalled reports whether the watchdog has failed the run.
+func (w *runWatchdog) stalled() bool {
+	return w != nil && w.state.Load() == watchStalled
+}
+
+// finish stops watching a run about to save its final record. It reports
+// false if the watchdog has failed the run already.
+func (w *runWatchdog) finish() bool {
+	if w == nil {
+		return true
+	}
+	w.stop.Do(func() { close(w.done) })
+	return w.state.CompareAndSwap(watchRunning, watchFinished) || w.state.Load() == watchFinished
+}
+
+type watchdogKey struct{}
+
+func withWatchdog(ctx context.Context, w *runWatchdog) context.Context {
+	return context.WithValue(ctx, watchdogKey{}, w)
+}
+
+type releaseKey struct{}
+
+// WithRelease returns ctx for a run that holds a place, such as a slot in a
+// queue, which release gives up. A run the watchdog fails calls release, as
+// its own goroutine may never return to; the caller still gives the place
+// up when the run returns, so release must do nothing the second time.
+func WithRelease(ctx context.Context, release func()) context.Context {
+	return context.WithValue(ctx, releaseKey{}, release)
+}
+
+// releaseFrom returns the release func ctx's run was given, or one that
+// does nothing.
+func releaseFrom(ctx context.Context) func() {
+	if release, ok := ctx.Value(releaseKey{}).(func()); ok {
+		return release
+	}
+	return func() {}
+}
+
+// watchdogFrom returns the watchdog of the run ctx belongs to, or nil.
+func watchdogFrom(ctx context.Context) *runWatchdog {
+	w, _ := ctx.Value(watchdogKey{}).(*runWatchdog)
+	return w
+}
+
+// failStalled fails run, as it was when it started, for going idle without
+// progress. The run's own goroutine may be stuck for good, so this does
+// what its end would: it cancels the run, saves it failed with the results
+// so far, frees its place among the active runs and the one release holds,
+// and ends its live results.
+func (e *Engine) failStalled(run types.RunRecord, w *runWatchdog, live *liveRun, cancel context.CancelFunc, endLive func(), release func(), idle time.Duration) {
+	cancel()
+	e.dumpStalled(run.ID, w, live, idle)
+
+	live.mu.Lock()
+	run.PlanID = live.planID
+	run.Results = append([]types.SimulationResult(nil), live.results...)
+	live.mu.Unlock()
+	finished := e.clock.Now().UTC()
+	run.Status = outcomeFailed
+	run.Reason = stallReason
+	run.FinishedAt = &finished
+	e.saveRun(context.Background(), run)
+	e.active.Delete(run.ID)
+	release()
+	e.counters.RunFinished(outcomeFailed)
+
+	ev := types.NewEventAt(e.clock.Now(), types.EventError, types.ErrorEvent{Error: fmt.Sprintf("%v: no progress for %s", ErrRunStalled, idle.Round(time.Millisecond))})
+	ev.RunID = run.ID
+	e.emit(ev)
+	endLive()
+}
+
+// dumpStalled logs what a stalled run was doing, and where every goroutine
+// is, for working out what it was stuck on.
+func (e *Engine) dumpStalled(id string, w *runWatchdog, live *liveRun, idle time.Duration) {
+	lastEvent := "none"
+	if typ := w.lastEvent.Load(); typ != nil {
+		lastEvent = *typ
+	}
+	live.mu.Lock()
+	results, variants := len(live.results), live.variants
+	live.mu.Unlock()
+	var stacks bytes.Buffer
+	_ = pprof.Lookup("goroutine").WriteTo(&stacks, 1)
+	slog.Error("run stalled", "run_id", id, "idle", idle.Round(time.Millisecond), "last_event", lastEvent,
+		"variants_reported", results, "variants", variants, "goroutines", stacks.String())
+}
@@ -0,0 +1,119 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"simstack/internal/eventbus"
+	"simstack/internal/testsupport"
+	"simstack/internal/types"
+)
+
+func TestWatchdogFailsStalledRun(t *testing.T) {
+	release := make(chan struct{})
+	answer := sync.OnceFunc(func() { close(release) })
+	sim := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release // Never answers while the run is watched
+	}))
+	defer sim.Close()
+	defer answer()
+	for _, key := range []string{"QUEUE_SIMULATOR_URL", "TRAFFIC_SIMULATOR_URL", "RESOURCE_SIMULATOR_URL"} {
+		t.Setenv(key, sim.URL)
+	}
+	t.Setenv("SIMULATOR_WARMUP", "false")
+	t.Setenv("SIMSTACK_RUN_STALL_TIMEOUT", "200ms")
+
+	var e *Engine
+	rec := &recorder{}
+	released := make(chan bool, 1)
+	var slotFreed atomic.Bool
+	e = NewEngine(eventbus.Direct(func(v any) {
+		rec.emit(v)
+		if ev, ok := v.(types.WSEvent); ok && ev.Type == types.EventError {
+			released <- !e.runActive(ev.RunID) && slotFreed.Load()
+		}
+	}), WithChatClient(testsupport.NewFakeChat(), "m"))
+
+	// Variants don't share the run's context, so canceling the run leaves
+	// their calls hanging: the watchdog fails the run without it returning
+	returned := make(chan error, 1)
+	go func() {
+		ctx := WithRelease(context.Background(), func() { slotFreed.Store(true) })
+		returned <- e.RunWithID(ctx, "run-stuck", types.RunRequest{Goal: "g", Offline: true})
+	}()
+	select {
+	case ok := <-released:
+		if !ok {
+			t.Error("expected the run's slot and its scheduler place released before the error event")
+		}
+	case err := <-returned:
+		t.Fatalf("expected the run stuck until the watchdog fails it, returned %v", err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected an error event")
+	}
+
+	run, err := e.GetRun(context.Background(), "run-stuck")
+	if err != nil || run.Status != "failed" || run.Reason != "stalled" || run.FinishedAt == nil || run.PlanID == "" {
+		t.Errorf("expected the run saved failed as stalled, got %+v (%v)", run, err)
+	}
+	if c := e.Metrics(0).Counters; c.RunsFailed != 1 || c.RunsCompleted != 0 {
+		t.Errorf("expected the run counted failed once, got %+v", c)
+	}
+
+	// Once the simulators answer, the run gets to its end and leaves the
+	// watchdog's record alone
+	answer()
+	if err := <-returned; !errors.Is(err, ErrRunStalled) {
+		t.Errorf("expected the run to return as stalled, got %v", err)
+	}
+	if run, _ := e.GetRun(context.Background(), "run-stuck"); run.Status != "failed" {
+		t.Errorf("expected the run to stay failed, got %s", run.Status)
+	}
+	if got := rec.ofType(types.EventDone); len(got) != 0 {
+		t.Errorf("expected nothing sent after the stall, got %+v", got)
+	}
+}
+
+func TestWatchdogHeartbeatKeepsSlowPhaseAlive(t *testing.T) {
+	clk := testsupport.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	stalled := make(chan time.Duration, 1)
+	w := newRunWatchdog(clk, 40*time.Millisecond, func(_ *runWatchdog, idle time.Duration) { stalled <- idle })
+	stop := w.heartbeat()
+	// The watchdog's timer and the heartbeat's ticker
+	clk.BlockUntil(2)
+	for i := 0; i < 20; i++ {
+		clk.Advance(10 * time.Millisecond)
+		for w.last.Load() != clk.Now().UnixNano() {
+			runtime.Gosched() // Until the heartbeat takes the tick
+		}
+	}
+	if w.stalled() {
+		t.Fatal("expected heartbeats to keep a slow phase alive")
+	}
+
+	stop()
+	var idle time.Duration
+	for i := 0; idle == 0; i++ {
+		if i == 100 {
+			t.Fatal("expected the watchdog to fire once the heartbeats stop")
+		}
+		clk.Advance(10 * time.Millisecond)
+		select {
+		case idle = <-stalled:
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if idle < 40*time.Millisecond {
+		t.Errorf("expected the watchdog to wait out the timeout, fired after %s", idle)
+	}
+	if w.finish() {
+		t.Error("expected a stalled run not to finish")
+	}
+}
@@ -0,0 +1,189 @@
+// Package paramfile writes a variant's parameters in forms deployment
+// scripts consume: a .env file with one upper-snake key per parameter, and a
+// JSON document of each simulator's parameters as they are sent to it.
+package paramfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Config is what gets written: parameters grouped by the tool that takes
+// them, and those no tool takes.
+type Config struct {
+	RunID     string
+	VariantID string
+	Goal      string
+	Tools     map[string]map[string]any
+	Other     map[string]any
+}
+
+// Key normalizes a tool's parameter name to an upper-snake environment
+// variable name: QUEUE_ARRIVAL_RATE for queue's arrival_rate or arrivalRate.
+// Parameters of no tool pass an empty tool.
+func Key(tool, param string) string {
+	var parts []string
+	for _, s := range []string{tool, param} {
+		if w := words(s); w != "" {
+			parts = append(parts, w)
+		}
+	}
+	key := strings.Join(parts, "_")
+	if key == "" {
+		return "PARAM"
+	}
+	if key[0] >= '0' && key[0] <= '9' {
+		key = "_" + key
+	}
+	return key
+}
+
+// words upper-cases s with words separated by single underscores: at any
+// run of other characters, and where lower case or a digit meets upper case.
+func words(s string) string {
+	var b strings.Builder
+	var prev rune
+	sep := false
+	for _, r := range s {
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			if b.Len() > 0 && (sep || unicode.IsUpper(r) && (unicode.IsLower(prev) || unicode.IsDigit(prev))) {
+				b.WriteByte('_')
+			}
+			b.WriteRune(unicode.ToUpper(r))
+			sep = false
+		default:
+			sep = true
+		}
+		prev = r
+	}
+	return b.String()
+}
+
+// Env writes c as a .env file, a commented section per tool. Keys are
+// assigned in order (tools by name, then other parameters, each by name), so
+// when two parameters normalize to the same key the later one deterministically
+// gets a numeric suffix, noted in a comment.
+func Env(c Config) []byte {
+	var b bytes.Buffer
+	b.WriteString("# SimStack winning configuration\n")
+	writeSource(&b, c)
+
+	taken := map[string]string{}
+	section := func(title, tool string, params map[string]any) {
+		if len(params) == 0 {
+			return
+		}
+		fmt.Fprintf(&b, "\n# %s\n", title)
+		for _, name := range sortedKeys(params) {
+			key := Key(tool, name)
+			origin := name
+			if tool != "" {
+				origin = tool + "." + name
+			}
+			if prev, ok := taken[key]; ok {
+				base := key
+				for i := 2; taken[key] != ""; i++ {
+					key = fmt.Sprintf("%s_%d", base, i)
+				}
+				fmt.Fprintf(&b, "# %s is %s: %s already has %s\n", origin, key, prev, base)
+			}
+			taken[key] = origin
+			fmt.Fprintf(&b, "%s=%s\n", key, envValue(params[name]))
+		}
+	}
+	for _, tool := range sortedKeys(c.Tools) {
+		section(tool, tool, c.Tools[tool])
+	}
+	section("other parameters", "", c.Other)
+	return b.Bytes()
+}
+
+func writeSource(b *bytes.Buffer, c Config) {
+	if c.RunID != "" {
+		fmt.Fprintf(b, "# run: %s\n", oneLine(c.RunID))
+	}
+	if c.VariantID != "" {
+		fmt.Fprintf(b, "# variant: %s\n", oneLine(c.VariantID))
+	}
+	if c.Goal != "" {
+		fmt.Fprintf(b, "# goal: %s\n", oneLine(c.Goal))
+	}
+}
+
+var bare = regexp.MustCompile(`^[A-Za-z0-9_./:@+,-]*$`)
+
+// Value is a parameter's unquoted text: strings as they are, numbers without
+// exponents, other values as JSON.
+func Value(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		return ""
+	case bool, int, int64, json.Number:
+		return fmt.Sprint(v)
+	default:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+}
+
+// envValue formats v for a .env file that both shells and dotenv loaders
+// read back unchanged: bare when safe, single-quoted when that suffices,
+// else double-quoted with escapes.
+func envValue(v any) string {
+	s := Value(v)
+	switch {
+	case bare.MatchString(s):
+		return s
+	case !strings.ContainsAny(s, "'\n\r"):
+		return "'" + s + "'"
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`", "\n", `\n`, "\r", `\r`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// Document is the json-params form: each tool's parameters exactly as its
+// /simulate endpoint receives them.
+type Document struct {
+	RunID     string                    `json:"run_id,omitempty"`
+	VariantID string                    `json:"variant_id,omitempty"`
+	Goal      string                    `json:"goal,omitempty"`
+	Tools     map[string]map[string]any `json:"tools"`
+	Other     map[string]any            `json:"other,omitempty"`
+}
+
+// JSON writes c as an indented Document.
+func JSON(c Config) ([]byte, error) {
+	doc := Document{RunID: c.RunID, VariantID: c.VariantID, Goal: c.Goal, Tools: c.Tools, Other: c.Other}
+	if doc.Tools == nil {
+		doc.Tools = map[string]map[string]any{}
+	}
+	b, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,87 @@
+package paramfile
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestKey(t *testing.T) {
+	for in, want := range map[[2]string]string{
+		{"queue", "arrival_rate"}:    "QUEUE_ARRIVAL_RATE",
+		{"queue", "arrivalRate"}:     "QUEUE_ARRIVAL_RATE",
+		{"queue", "arrival-rate "}:   "QUEUE_ARRIVAL_RATE",
+		{"traffic", "signal.timing"}: "TRAFFIC_SIGNAL_TIMING",
+		{"resource", "shift2Staff"}:  "RESOURCE_SHIFT2_STAFF",
+		{"", "utilization"}:          "UTILIZATION",
+		{"", "2nd_shift"}:            "_2ND_SHIFT",
+		{"", "héllo"}:                "H_LLO",
+		{"", "---"}:                  "PARAM",
+	} {
+		if got := Key(in[0], in[1]); got != want {
+			t.Errorf("Key(%q, %q) = %q, want %q", in[0], in[1], got, want)
+		}
+	}
+}
+
+func TestEnv(t *testing.T) {
+	c := Config{
+		RunID: "run-1", VariantID: "plan-1-v3", Goal: "reduce\nwait",
+		Tools: map[string]map[string]any{
+			"queue":    {"arrival_rate": 12.0, "arrivalRate": 13.0, "rate": 1.5},
+			"traffic":  {"rate": 0.25, "note": "night shift"},
+			"resource": {"staff": 24, "shifts": []string{"day", "night"}, "home": "$HOME", "quote": "it's \"x\""},
+		},
+		Other: map[string]any{"queue_arrival_rate": 9.0, "enabled": true, "empty": nil},
+	}
+	want := `# SimStack winning configuration
+# run: run-1
+# variant: plan-1-v3
+# goal: reduce wait
+
+# queue
+QUEUE_ARRIVAL_RATE=13
+# queue.arrival_rate is QUEUE_ARRIVAL_RATE_2: queue.arrivalRate already has QUEUE_ARRIVAL_RATE
+QUEUE_ARRIVAL_RATE_2=12
+QUEUE_RATE=1.5
+
+# resource
+RESOURCE_HOME='$HOME'
+RESOURCE_QUOTE="it's \"x\""
+RESOURCE_SHIFTS='["day","night"]'
+RESOURCE_STAFF=24
+
+# traffic
+TRAFFIC_NOTE='night shift'
+TRAFFIC_RATE=0.25
+
+# other parameters
+EMPTY=
+ENABLED=true
+# queue_arrival_rate is QUEUE_ARRIVAL_RATE_3: queue.arrivalRate already has QUEUE_ARRIVAL_RATE
+QUEUE_ARRIVAL_RATE_3=9
+`
+	for i := 0; i < 5; i++ {
+		if got := string(Env(c)); got != want {
+			t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	c := Config{RunID: "run-1", VariantID: "plan-1-v3", Tools: map[string]map[string]any{"queue": {"arrival_rate": 12.0}}}
+	b, err := JSON(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc Document
+	if err := json.Unmarshal(b, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.RunID != "run-1" || doc.VariantID != "plan-1-v3" || !reflect.DeepEqual(doc.Tools, c.Tools) || doc.Other != nil {
+		t.Errorf("unexpected document %s", b)
+	}
+	if b, _ := JSON(Config{}); string(b) != "{\n  \"tools\": {}\n}\n" {
+		t.Errorf("expected an empty tools object, got %s", b)
+	}
+}
@@ -0,0 +1,108 @@
+// Package pricing estimates what LLM calls cost from their token usage.
+// Prices change, so the built-in table is only a default; LLM_PRICING
+// overrides or extends it.
+package pricing
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Price is a model's cost in US dollars per million tokens.
+type Price struct {
+	InputPerMillion  float64 `json:"input_per_million"`
+	OutputPerMillion float64 `json:"output_per_million"`
+}
+
+// Table maps model names to prices. Keys are matched as prefixes so tagged
+// names like "gpt-4o-2024-08-06" resolve too; longer keys win.
+type Table map[string]Price
+
+// Default returns list prices of the models the backend ships configured for.
+func Default() Table {
+	return Table{
+		"llama3.1-8b":   {InputPerMillion: 0.10, OutputPerMillion: 0.10},
+		"llama-3.3-70b": {InputPerMillion: 0.85, OutputPerMillion: 1.20},
+		"gpt-4o":        {InputPerMillion: 2.50, OutputPerMillion: 10.00},
+		"gpt-4o-mini":   {InputPerMillion: 0.15, OutputPerMillion: 0.60},
+	}
+}
+
+// Lookup returns model's price, if the table has one.
+func (t Table) Lookup(model string) (Price, bool) {
+	best := ""
+	for prefix := range t {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(best) {
+			best = prefix
+		}
+	}
+	if best == "" {
+		return Price{}, false
+	}
+	return t[best], true
+}
+
+// Cost estimates a call's cost in dollars. ok is false for models without a
+// price, whose cost is unknown rather than zero.
+func (t Table) Cost(model string, promptTokens, completionTokens int) (usd float64, ok bool) {
+	p, ok := t.Lookup(model)
+	if !ok {
+		return 0, false
+	}
+	return (float64(promptTokens)*p.InputPerMillion + float64(completionTokens)*p.OutputPerMillion) / 1e6, true
+}
+
+// Parse reads overrides in the form "model=input/output,..." with prices in
+// dollars per million tokens, e.g. "gpt-4o=2.5/10, my-model=0/0", and returns
+// them merged over base.
+func Parse(s string, base Table) (Table, error) {
+	out := make(Table, len(base))
+	for model, p := range base {
+		out[model] = p
+	}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		model, prices, ok := strings.Cut(entry, "=")
+		in, outPrice, ok2 := strings.Cut(prices, "/")
+		model = strings.TrimSpace(model)
+		if !ok || !ok2 || model == "" {
+			return nil, fmt.Errorf("%q is not model=input/output", entry)
+		}
+		inUSD, err := strconv.ParseFloat(strings.TrimSpace(in), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q: input price is not a number", entry)
+		}
+		outUSD, err := strconv.ParseFloat(strings.TrimSpace(outPrice), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q: output price is not a number", entry)
+		}
+		out[model] = Price{InputPerMillion: inUSD, OutputPerMillion: outUSD}
+	}
+	return out, nil
+}
+
+// Validate returns a problem for every price that is negative or not finite.
+func (t Table) Validate() []string {
+	models := make([]string, 0, len(t))
+	for model := range t {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+	var problems []string
+	for _, model := range models {
+		p := t[model]
+		for _, v := range []float64{p.InputPerMillion, p.OutputPerMillion} {
+			if v < 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+				problems = append(problems, fmt.Sprintf("price of %s must be a non-negative number, got %g/%g", model, p.InputPerMillion, p.OutputPerMillion))
+				break
+			}
+		}
+	}
+	return problems
+}
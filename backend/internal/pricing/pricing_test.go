@@ -0,0 +1,68 @@
+package pricing
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestCost(t *testing.T) {
+	table := Table{
+		"gpt-4o":      {InputPerMillion: 2.50, OutputPerMillion: 10.00},
+		"gpt-4o-mini": {InputPerMillion: 0.15, OutputPerMillion: 0.60},
+	}
+	cases := []struct {
+		model              string
+		prompt, completion int
+		want               float64
+	}{
+		{"gpt-4o", 1_000_000, 0, 2.50},
+		{"gpt-4o", 2000, 500, 0.005 + 0.005},
+		// The longest matching prefix wins
+		{"gpt-4o-mini-2024-07-18", 1_000_000, 1_000_000, 0.75},
+		{"gpt-4o", 0, 0, 0},
+	}
+	for _, c := range cases {
+		got, ok := table.Cost(c.model, c.prompt, c.completion)
+		if !ok || math.Abs(got-c.want) > 1e-12 {
+			t.Errorf("%s %d/%d: expected $%g, got $%g (%v)", c.model, c.prompt, c.completion, c.want, got, ok)
+		}
+	}
+	if _, ok := table.Cost("llama3.1-8b", 100, 100); ok {
+		t.Error("a model without a price must report an unknown cost")
+	}
+}
+
+func TestParse(t *testing.T) {
+	table, err := Parse("gpt-4o=3/12, local-model=0/0,", Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, _ := table.Lookup("gpt-4o"); p.InputPerMillion != 3 || p.OutputPerMillion != 12 {
+		t.Errorf("expected the override, got %+v", p)
+	}
+	if usd, ok := table.Cost("local-model", 1000, 1000); !ok || usd != 0 {
+		t.Errorf("expected a free local model, got %g %v", usd, ok)
+	}
+	if _, ok := table.Lookup("llama3.1-8b"); !ok {
+		t.Error("defaults must survive overrides")
+	}
+	if p, _ := Default().Lookup("gpt-4o"); p.InputPerMillion != 2.50 {
+		t.Error("Parse must not modify the base table")
+	}
+	for _, bad := range []string{"gpt-4o", "gpt-4o=3", "=1/2", "gpt-4o=cheap/2", "gpt-4o=1/x"} {
+		if _, err := Parse(bad, nil); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if problems := Default().Validate(); len(problems) != 0 {
+		t.Errorf("default table must be valid, got %v", problems)
+	}
+	problems := Table{"a": {InputPerMillion: -1}, "b": {OutputPerMillion: math.NaN()}, "c": {}}.Validate()
+	if len(problems) != 2 || !strings.Contains(problems[0], "a") || !strings.Contains(problems[1], "b") {
+		t.Errorf("expected problems for a and b, got %v", problems)
+	}
+}
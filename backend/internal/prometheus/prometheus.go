@@ -0,0 +1,149 @@
+// Package prometheus exposes the engine's instrumentation for a Prometheus
+// server to scrape. A Sink turns the events the engine pushes, as it would
+// to StatsD, into counters and summaries; gauges are read from their owners
+// at scrape time.
+package prometheus
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Namespace prefixes every metric name.
+const Namespace = "simstack"
+
+// Quantiles reported by the summaries, with their allowed error.
+var objectives = map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001}
+
+// Sink collects the engine's instrumentation in its own registry, along with
+// the Go runtime's and the process's. It is safe for concurrent use.
+type Sink struct {
+	registry *prom.Registry
+
+	runsStarted      prom.Counter
+	runsFinished     *prom.CounterVec // status
+	plannerCalls     prom.Counter
+	plannerFallbacks prom.Counter
+	plannerLatency   prom.Summary
+	plannerRate      prom.Summary
+	simulatorCalls   *prom.CounterVec // tool
+	simulatorErrors  *prom.CounterVec // tool
+	llmTokens        *prom.CounterVec // phase
+}
+
+// New returns a Sink with every counter registered.
+func New() *Sink {
+	s := &Sink{
+		registry: prom.NewRegistry(),
+		runsStarted: prom.NewCounter(prom.CounterOpts{
+			Namespace: Namespace, Name: "runs_started_total",
+			Help: "Runs started.",
+		}),
+		runsFinished: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: Namespace, Name: "runs_finished_total",
+			Help: "Runs finished, by how they ended: completed, failed or canceled.",
+		}, []string{"status"}),
+		plannerCalls: prom.NewCounter(prom.CounterOpts{
+			Namespace: Namespace, Name: "planner_calls_total",
+			Help: "Runs planned, by the LLM or by fallback.",
+		}),
+		plannerFallbacks: prom.NewCounter(prom.CounterOpts{
+			Namespace: Namespace, Name: "planner_fallbacks_total",
+			Help: "Online runs planned without the LLM.",
+		}),
+		plannerLatency: prom.NewSummary(prom.SummaryOpts{
+			Namespace: Namespace, Name: "planner_latency_seconds",
+			Help:       "How long runs took to plan.",
+			Objectives: objectives,
+		}),
+		plannerRate: prom.NewSummary(prom.SummaryOpts{
+			Namespace: Namespace, Name: "planner_tokens_per_second",
+			Help:       "Tokens per second of each run's planner calls.",
+			Objectives: objectives,
+		}),
+		simulatorCalls: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: Namespace, Name: "simulator_calls_total",
+			Help: "Simulator calls made, by tool.",
+		}, []string{"tool"}),
+		simulatorErrors: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: Namespace, Name: "simulator_errors_total",
+			Help: "Simulator calls that failed, by tool.",
+		}, []string{"tool"}),
+		llmTokens: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: Namespace, Name: "llm_tokens_total",
+			Help: "Tokens used by LLM calls, by phase.",
+		}, []string{"phase"}),
+	}
+	s.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		s.runsStarted, s.runsFinished,
+		s.plannerCalls, s.plannerFallbacks, s.plannerLatency, s.plannerRate,
+		s.simulatorCalls, s.simulatorErrors, s.llmTokens,
+	)
+	return s
+}
+
+// Gauge registers a gauge whose value f reads at scrape time, for figures
+// owned elsewhere such as the connected clients. name is prefixed with the
+// namespace.
+func (s *Sink) Gauge(name, help string, f func() float64) {
+	s.registry.MustRegister(prom.NewGaugeFunc(prom.GaugeOpts{Namespace: Namespace, Name: name, Help: help}, f))
+}
+
+// Handler serves the registry in the text exposition format, or in
+// OpenMetrics to a scraper that asks for it.
+func (s *Sink) Handler() http.Handler {
+	return promhttp.HandlerFor(s.registry, promhttp.HandlerOpts{EnableOpenMetrics: true})
+}
+
+// Count implements metrics.Sink. Names it has no counter for are dropped.
+func (s *Sink) Count(name string, n int64, tags ...string) {
+	switch name {
+	case "run.started":
+		s.runsStarted.Add(float64(n))
+	case "run.completed":
+		s.runsFinished.WithLabelValues(tag(tags, "status")).Add(float64(n))
+	case "planner.fallback":
+		s.plannerFallbacks.Add(float64(n))
+	case "llm.tokens":
+		s.llmTokens.WithLabelValues(tag(tags, "phase")).Add(float64(n))
+	}
+}
+
+// Timing implements metrics.Sink.
+func (s *Sink) Timing(name string, d time.Duration, tags ...string) {
+	switch name {
+	case "planner.latency":
+		s.plannerCalls.Inc()
+		s.plannerLatency.Observe(d.Seconds())
+	case "simulator.call.duration":
+		tool := tag(tags, "tool")
+		s.simulatorCalls.WithLabelValues(tool).Inc()
+		if tag(tags, "outcome") == "failure" {
+			s.simulatorErrors.WithLabelValues(tool).Inc()
+		}
+	}
+}
+
+// Observe implements metrics.Sink.
+func (s *Sink) Observe(name string, v float64, tags ...string) {
+	if name == "planner.tokens_per_second" {
+		s.plannerRate.Observe(v)
+	}
+}
+
+// tag returns the value of the "key:value" tag named key, or "".
+func tag(tags []string, key string) string {
+	for _, t := range tags {
+		if k, v, ok := strings.Cut(t, ":"); ok && k == key {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,83 @@
+package prometheus
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"simstack/internal/metrics"
+)
+
+func scrape(t *testing.T, s *Sink) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != 200 {
+		t.Fatalf("scrape answered %d", rec.Code)
+	}
+	return rec.Body.String()
+}
+
+func TestSinkExposesCounters(t *testing.T) {
+	s := New()
+	c := metrics.NewCounters()
+	c.SetSink(s)
+	c.RunStarted()
+	c.RunStarted()
+	c.RunFinished("completed")
+	c.RunFinished("failed")
+	c.PlannerLatency(1500 * time.Millisecond)
+	c.PlanningFellBack()
+	c.PlannerThroughput(1800)
+	c.PlannerThroughput(0)
+	c.SimulatorCalled("queue", time.Millisecond, nil)
+	c.SimulatorCalled("queue", time.Millisecond, errors.New("down"))
+	c.LLMTokens("plan", 300)
+	active := 3.0
+	s.Gauge("runs_active", "Runs in flight.", func() float64 { return active })
+
+	body := scrape(t, s)
+	for _, want := range []string{
+		"simstack_runs_started_total 2\n",
+		`simstack_runs_finished_total{status="completed"} 1` + "\n",
+		`simstack_runs_finished_total{status="failed"} 1` + "\n",
+		"simstack_planner_calls_total 1\n",
+		"simstack_planner_fallbacks_total 1\n",
+		"simstack_planner_latency_seconds_sum 1.5\n",
+		"simstack_planner_tokens_per_second_count 1\n",
+		`simstack_simulator_calls_total{tool="queue"} 2` + "\n",
+		`simstack_simulator_errors_total{tool="queue"} 1` + "\n",
+		`simstack_llm_tokens_total{phase="plan"} 300` + "\n",
+		"simstack_runs_active 3\n",
+		"go_goroutines ",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in:\n%s", want, body)
+		}
+	}
+	if c.PlanningFallbacks.Load() != 1 {
+		t.Errorf("expected the JSON counters kept too, got %d fallbacks", c.PlanningFallbacks.Load())
+	}
+}
+
+func TestSinkDropsUnknownNames(t *testing.T) {
+	s := New()
+	s.Count("something.else", 1, "tool:queue")
+	s.Timing("something.else", time.Second)
+	s.Observe("something.else", 1)
+	if body := scrape(t, s); strings.Contains(body, "something") {
+		t.Errorf("expected unknown names dropped, got:\n%s", body)
+	}
+}
+
+func TestSinksFanOut(t *testing.T) {
+	a, b := New(), New()
+	metrics.Sinks{a, b}.Count("run.started", 1)
+	for _, s := range []*Sink{a, b} {
+		if !strings.Contains(scrape(t, s), "simstack_runs_started_total 1\n") {
+			t.Error("expected every sink to get the count")
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// Package ratelimit limits how often each client may call the backend, with
+// a token bucket per client. Memory keeps the buckets in this process; a
+// shared store can stand in for it behind Limiter, so that replicas limit a
+// client together.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Limiter takes a token from a client's bucket, if it has one.
+type Limiter interface {
+	// Allow spends one of key's tokens, or reports when the next is due.
+	Allow(ctx context.Context, key string) (Decision, error)
+}
+
+// Decision is what a Limiter made of a request, and the client's quota
+// after it.
+type Decision struct {
+	Allowed bool
+	// Size of the bucket: the most requests a client may make at once
+	Limit int
+	// Whole tokens left
+	Remaining int
+	// How long until the next token, when refused
+	RetryAfter time.Duration
+	// How long until the bucket is full again
+	Reset time.Duration
+}
+
+// Memory is a Limiter keeping every client's bucket in memory. It is safe for
+// concurrent use.
+type Memory struct {
+	// Time to earn one token
+	interval time.Duration
+	burst    float64
+	now      func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	// When buckets were last swept of clients gone quiet
+	swept time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewMemory allows each client perMinute requests a minute, bursting up to
+// burst (perMinute when zero). perMinute must be positive.
+func NewMemory(perMinute, burst int) *Memory {
+	if burst <= 0 {
+		burst = perMinute
+	}
+	return &Memory{
+		interval: time.Minute / time.Duration(perMinute),
+		burst:    float64(burst),
+		now:      time.Now,
+		buckets:  map[string]*bucket{},
+	}
+}
+
+// Allow implements Limiter; it never fails.
+func (m *Memory) Allow(_ context.Context, key string) (Decision, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	m.sweep(now)
+	b := m.buckets[key]
+	if b == nil {
+		b = &bucket{tokens: m.burst, last: now}
+		m.buckets[key] = b
+	}
+	b.tokens = math.Min(m.burst, b.tokens+float64(now.Sub(b.last))/float64(m.interval))
+	b.last = now
+
+	d := Decision{Limit: int(m.burst)}
+	if b.tokens >= 1 {
+		b.tokens--
+		d.Allowed = true
+	} else {
+		d.RetryAfter = time.Duration((1 - b.tokens) * float64(m.interval))
+	}
+	d.Remaining = int(b.tokens)
+	d.Reset = time.Duration((m.burst - b.tokens) * float64(m.interval))
+	return d, nil
+}
+
+// sweep drops, once per minute at most, the buckets that have filled up
+// again, which are no different from a client's first request. Callers hold
+// mu.
+func (m *Memory) sweep(now time.Time) {
+	if now.Sub(m.swept) < time.Minute {
+		return
+	}
+	m.swept = now
+	full := time.Duration(m.burst * float64(m.interval))
+	for key, b := range m.buckets {
+		if now.Sub(b.last) >= full {
+			delete(m.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryBucket(t *testing.T) {
+	now := time.Unix(1000, 0)
+	m := NewMemory(60, 2)
+	m.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for i, want := range []int{1, 0} {
+		d, _ := m.Allow(ctx, "a")
+		if !d.Allowed || d.Limit != 2 || d.Remaining != want {
+			t.Fatalf("request %d: expected allowed with %d left, got %+v", i, want, d)
+		}
+	}
+	d, _ := m.Allow(ctx, "a")
+	if d.Allowed || d.RetryAfter != time.Second || d.Reset != 2*time.Second {
+		t.Errorf("expected a refusal due in 1s, got %+v", d)
+	}
+	if d, _ := m.Allow(ctx, "b"); !d.Allowed {
+		t.Errorf("expected each client its own bucket, got %+v", d)
+	}
+
+	now = now.Add(1500 * time.Millisecond)
+	if d, _ := m.Allow(ctx, "a"); !d.Allowed || d.Remaining != 0 {
+		t.Errorf("expected a token earned back, got %+v", d)
+	}
+}
+
+func TestMemorySweepsIdleClients(t *testing.T) {
+	now := time.Unix(1000, 0)
+	m := NewMemory(60, 0)
+	m.now = func() time.Time { return now }
+	ctx := context.Background()
+	_, _ = m.Allow(ctx, "a")
+	now = now.Add(2 * time.Minute)
+	_, _ = m.Allow(ctx, "b")
+	if _, ok := m.buckets["a"]; ok || len(m.buckets) != 1 {
+		t.Errorf("expected the idle client's bucket dropped, got %v", m.buckets)
+	}
+}
@@ -0,0 +1,121 @@
+// Package reproscript writes a POSIX shell script that re-measures a variant:
+// it sends the variant's parameters to each simulator again and checks the
+// metrics against those its run recorded.
+package reproscript
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// DefaultTolerance is the relative difference a headline metric may show
+// before the script fails.
+const DefaultTolerance = 0.05
+
+// Config is what the script reproduces.
+type Config struct {
+	RunID     string
+	VariantID string
+	Goal      string
+	// The variant's seed parameter, which the run sent every simulator and
+	// the script sends again
+	Seed *int64
+	// The run's LLM seed, recorded in the header
+	LLMSeed *int64
+	// Relative; DefaultTolerance when 0
+	Tolerance float64
+	// Checked in order
+	Tools []Tool
+}
+
+// Tool is one simulator call.
+type Tool struct {
+	// Lower-case letters, digits and underscores; also names the
+	// <NAME>_SIMULATOR_URL variable that overrides URL
+	Name   string
+	URL    string
+	Params map[string]any
+	// The metrics recorded for the call, without the tool prefix
+	Metrics []Metric
+}
+
+// Metric is a recorded metric. Only headline metrics fail the script; the
+// others are shown for comparison.
+type Metric struct {
+	Name     string
+	Recorded float64
+	Headline bool
+}
+
+//go:embed script.sh.tmpl
+var source string
+
+var toolName = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+var tmpl = template.Must(template.New("script").Funcs(template.FuncMap{
+	"quote":   Quote,
+	"oneLine": func(s string) string { return strings.Join(strings.Fields(s), " ") },
+	"number":  func(f float64) string { return strconv.FormatFloat(f, 'f', -1, 64) },
+	"extract": extract,
+	"pad":     func(s string) string { return strings.Repeat(" ", max(1, 22-len(s))) },
+}).Parse(source))
+
+type tool struct {
+	Tool
+	EnvVar string
+	// The JSON request body
+	Body string
+}
+
+// Script renders c.
+func Script(c Config) ([]byte, error) {
+	data := struct {
+		Config
+		Tolerance string
+		Tools     []tool
+	}{Config: c, Tolerance: strconv.FormatFloat(c.Tolerance, 'f', -1, 64)}
+	if c.Tolerance == 0 {
+		data.Tolerance = strconv.FormatFloat(DefaultTolerance, 'f', -1, 64)
+	}
+	for _, t := range c.Tools {
+		if !toolName.MatchString(t.Name) {
+			return nil, fmt.Errorf("reproscript: tool name %q is not a shell identifier", t.Name)
+		}
+		params := t.Params
+		if _, ok := params["seed"]; c.Seed != nil && !ok {
+			params = make(map[string]any, len(t.Params)+1)
+			for k, v := range t.Params {
+				params[k] = v
+			}
+			params["seed"] = *c.Seed
+		}
+		body, err := json.Marshal(params)
+		if err != nil {
+			return nil, fmt.Errorf("reproscript: %s parameters: %w", t.Name, err)
+		}
+		data.Tools = append(data.Tools, tool{Tool: t, EnvVar: strings.ToUpper(t.Name) + "_SIMULATOR_URL", Body: string(body)})
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, data); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// Quote returns s as a single shell word that expands to exactly s.
+func Quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+var breSpecial = strings.NewReplacer(`\`, `\\`, `.`, `\.`, `[`, `\[`, `]`, `\]`, `*`, `\*`, `^`, `\^`, `$`, `\$`, `/`, `\/`)
+
+// extract is the sed script printing the number a JSON response gives name.
+func extract(name string) string {
+	return `s/.*"` + breSpecial.Replace(name) + `"[[:space:]]*:[[:space:]]*\([-+0-9.eE]*\).*/\1/p`
+}
@@ -0,0 +1,145 @@
+package reproscript
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files")
+
+func sampleConfig() Config {
+	seed, llmSeed := int64(7), int64(42)
+	return Config{
+		RunID: "run-1", VariantID: "plan-1-v2", Goal: "reduce ER wait\ntime by 20%", Seed: &seed, LLMSeed: &llmSeed,
+		Tools: []Tool{
+			{Name: "queue", URL: "http://localhost:8101", Params: map[string]any{"arrival_rate": 4.5, "service_rate": 6.0}, Metrics: []Metric{
+				{Name: "avg_wait_time_min", Recorded: 2.5, Headline: true},
+				{Name: "utilization", Recorded: 0.75},
+			}},
+			{Name: "resource", URL: "http://localhost:8103", Params: map[string]any{"staff": 25.0, "shifts": []any{"night's", "$HOME `id`"}}, Metrics: []Metric{
+				{Name: "coverage_units", Recorded: 20, Headline: true},
+			}},
+		},
+	}
+}
+
+func TestScriptGolden(t *testing.T) {
+	got, err := Script(sampleConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join("testdata", "reproduce.sh")
+	if *update {
+		if err := os.WriteFile(path, got, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("script differs from the golden file (rerun with -update to accept):\n%s", got)
+	}
+	if _, err := Script(Config{Tools: []Tool{{Name: "queue; rm -rf /"}}}); err == nil {
+		t.Errorf("expected a tool name that isn't an identifier refused")
+	}
+}
+
+// sent holds the parameters a fake simulator was last sent. The handler
+// writes it from the server's goroutines, so it is read under the lock.
+type sent struct {
+	mu     sync.Mutex
+	params map[string]any
+}
+
+func (s *sent) get() map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.params
+}
+
+// fakeSimulator answers /healthz and /simulate with metrics, recording the
+// parameters it was sent.
+func fakeSimulator(t *testing.T, metrics map[string]float64, got *sent) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"status":"ok"}`)
+	})
+	mux.HandleFunc("POST /simulate", func(w http.ResponseWriter, r *http.Request) {
+		var params map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&params)
+		got.mu.Lock()
+		got.params = params
+		got.mu.Unlock()
+		_ = json.NewEncoder(w).Encode(map[string]any{"metrics": metrics})
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestScriptRuns(t *testing.T) {
+	for _, tool := range []string{"sh", "curl", "awk", "sed"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s is not installed", tool)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "reproduce.sh")
+	script, err := Script(sampleConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, script, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	run := func(env ...string) (int, string) {
+		cmd := exec.Command("sh", path)
+		cmd.Env = append(os.Environ(), env...)
+		out, err := cmd.CombinedOutput()
+		var exit *exec.ExitError
+		if errors.As(err, &exit) {
+			return exit.ExitCode(), string(out)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return 0, string(out)
+	}
+
+	var queueGot, resourceGot sent
+	queue := fakeSimulator(t, map[string]float64{"avg_wait_time_min": 2.55, "utilization": 0.9}, &queueGot)
+	resource := fakeSimulator(t, map[string]float64{"coverage_units": 20}, &resourceGot)
+	code, out := run("QUEUE_SIMULATOR_URL="+queue.URL, "RESOURCE_SIMULATOR_URL="+resource.URL)
+	if code != 0 || !strings.Contains(out, "reproduced within 0.05") || !strings.Contains(out, "queue_utilization") {
+		t.Errorf("expected a reproduction, got %d:\n%s", code, out)
+	}
+	// Read once the script has exited; the seed goes along with each call
+	want := map[string]any{"seed": 7.0}
+	for k, v := range sampleConfig().Tools[1].Params {
+		want[k] = v
+	}
+	if got := resourceGot.get(); !reflect.DeepEqual(got, want) || queueGot.get()["arrival_rate"] != 4.5 || queueGot.get()["seed"] != 7.0 {
+		t.Errorf("parameters changed on the way: %v and %v, want %v", got, queueGot.get(), want)
+	}
+
+	// Only the headline metric fails it
+	if code, out := run("QUEUE_SIMULATOR_URL="+queue.URL, "RESOURCE_SIMULATOR_URL="+resource.URL, "SIMSTACK_TOLERANCE=0.01"); code != 1 || !strings.Contains(out, "not reproduced") {
+		t.Errorf("expected a deviation beyond 1%%, got %d:\n%s", code, out)
+	}
+	if code, out := run("QUEUE_SIMULATOR_URL="+queue.URL, "RESOURCE_SIMULATOR_URL=http://127.0.0.1:1"); code != 2 || !strings.Contains(out, "resource simulator at http://127.0.0.1:1 is unreachable") {
+		t.Errorf("expected an unreachable simulator, got %d:\n%s", code, out)
+	}
+}
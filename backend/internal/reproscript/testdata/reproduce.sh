@@ -0,0 +1,87 @@
+#!/bin/sh
+# SimStack reproduction script
+# run: run-1
+# variant: plan-1-v2
+# goal: reduce ER wait time by 20%
+# seed: 7 (sent to every simulator, as the run did)
+# llm seed: 42 (the run's planner and critic seed)
+#
+# Sends the variant's parameters to each simulator again and compares the
+# metrics it returns with those the run recorded. Exits 1 when a headline
+# metric (*) differs by more than the relative tolerance, 2 when a simulator
+# can't be reached. Needs curl.
+#
+# Environment:
+#   SIMSTACK_TOLERANCE    relative tolerance (default 0.05)
+#   QUEUE_SIMULATOR_URL   queue simulator (default http://localhost:8101)
+#   RESOURCE_SIMULATOR_URL resource simulator (default http://localhost:8103)
+set -u
+
+tolerance=${SIMSTACK_TOLERANCE:-0.05}
+queue_url=${QUEUE_SIMULATOR_URL:-'http://localhost:8101'}
+resource_url=${RESOURCE_SIMULATOR_URL:-'http://localhost:8103'}
+status=0
+
+command -v curl >/dev/null 2>&1 || { echo "curl is required" >&2; exit 2; }
+
+# reachable NAME URL
+reachable() {
+	if ! curl -fsS -o /dev/null --max-time 10 "$2/healthz"; then
+		echo "$1 simulator at $2 is unreachable" >&2
+		status=2
+	fi
+}
+
+reachable 'queue' "$queue_url"
+reachable 'resource' "$resource_url"
+[ "$status" -eq 0 ] || exit "$status"
+
+# simulate URL BODY
+simulate() {
+	curl -fsS --max-time 60 -H 'Content-Type: application/json' -d "$2" "$1/simulate"
+}
+
+# metric RESPONSE SCRIPT: the number the sed SCRIPT picks out of RESPONSE
+metric() {
+	printf '%s\n' "$1" | sed -n "$2" | head -n 1
+}
+
+# compare NAME RECORDED MEASURED HEADLINE
+compare() {
+	verdict=$(awk -v want="$2" -v got="$3" -v tol="$tolerance" 'BEGIN {
+		if (got == "") { print "missing"; exit }
+		d = got - want; if (d < 0) d = -d
+		base = want < 0 ? -want : want
+		print ((base == 0 ? d : d / base) <= tol) ? "ok" : "differs"
+	}')
+	mark=" "
+	if [ "$4" = 1 ]; then
+		mark="*"
+		[ "$verdict" = ok ] || status=1
+	fi
+	printf '%s %-36s %14s %14s  %s\n' "$mark" "$1" "$2" "${3:--}" "$verdict"
+}
+
+printf '  %-36s %14s %14s\n' metric recorded measured
+
+if response=$(simulate "$queue_url" '{"arrival_rate":4.5,"seed":7,"service_rate":6}'); then
+	compare 'queue_avg_wait_time_min' 2.5 "$(metric "$response" 's/.*"avg_wait_time_min"[[:space:]]*:[[:space:]]*\([-+0-9.eE]*\).*/\1/p')" 1
+	compare 'queue_utilization' 0.75 "$(metric "$response" 's/.*"utilization"[[:space:]]*:[[:space:]]*\([-+0-9.eE]*\).*/\1/p')" 0
+else
+	echo "queue simulation failed" >&2
+	status=1
+fi
+
+if response=$(simulate "$resource_url" '{"seed":7,"shifts":["night'\''s","$HOME `id`"],"staff":25}'); then
+	compare 'resource_coverage_units' 20 "$(metric "$response" 's/.*"coverage_units"[[:space:]]*:[[:space:]]*\([-+0-9.eE]*\).*/\1/p')" 1
+else
+	echo "resource simulation failed" >&2
+	status=1
+fi
+
+if [ "$status" -ne 0 ]; then
+	echo "not reproduced within $tolerance" >&2
+else
+	echo "reproduced within $tolerance"
+fi
+exit "$status"
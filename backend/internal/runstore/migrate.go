@@ -0,0 +1,105 @@
+package runstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// migrations[i] takes a database from schema version i to i+1; the version is
+// kept in PRAGMA user_version. Append new steps, never edit shipped ones.
+var migrations = []string{
+	// v1: runs and their parts, and the LLM call audit log
+	`CREATE TABLE runs (
+		id          TEXT PRIMARY KEY,
+		goal        TEXT NOT NULL,
+		status      TEXT NOT NULL,
+		started_at  INTEGER NOT NULL, -- unix nanoseconds
+		finished_at INTEGER,
+		plan_id     TEXT NOT NULL DEFAULT '',
+		winner      TEXT NOT NULL DEFAULT '',
+		manifest    TEXT              -- JSON RunManifest
+	);
+	CREATE TABLE plans (
+		run_id  TEXT PRIMARY KEY REFERENCES runs (id) ON DELETE CASCADE,
+		plan_id TEXT NOT NULL,
+		plan    TEXT NOT NULL         -- JSON SimulationPlan without its variants
+	);
+	CREATE TABLE variants (
+		run_id     TEXT NOT NULL REFERENCES runs (id) ON DELETE CASCADE,
+		position   INTEGER NOT NULL,
+		variant_id TEXT NOT NULL,
+		parameters TEXT NOT NULL,     -- JSON
+		PRIMARY KEY (run_id, position)
+	);
+	CREATE TABLE results (
+		run_id     TEXT NOT NULL REFERENCES runs (id) ON DELETE CASCADE,
+		position   INTEGER NOT NULL,
+		variant_id TEXT NOT NULL,
+		status     TEXT NOT NULL,
+		result     TEXT NOT NULL,     -- JSON SimulationResult
+		PRIMARY KEY (run_id, position)
+	);
+	CREATE TABLE analyses (
+		run_id   TEXT PRIMARY KEY REFERENCES runs (id) ON DELETE CASCADE,
+		analysis TEXT NOT NULL        -- JSON
+	);
+	-- Append-only, and may precede the run's first save
+	CREATE TABLE llm_calls (
+		id     INTEGER PRIMARY KEY AUTOINCREMENT,
+		run_id TEXT NOT NULL,
+		phase  TEXT NOT NULL,
+		time   INTEGER NOT NULL,
+		record TEXT NOT NULL          -- JSON LLMAuditRecord
+	);
+	CREATE INDEX llm_calls_run ON llm_calls (run_id, id);`,
+
+	// v2: goal embeddings for similar-run search, and indexes for listing
+	`ALTER TABLE runs ADD COLUMN goal_embedding TEXT; -- JSON []float64
+	ALTER TABLE runs ADD COLUMN embedding_space TEXT NOT NULL DEFAULT '';
+	CREATE INDEX runs_started ON runs (started_at DESC, id DESC);
+	CREATE INDEX runs_status_started ON runs (status, started_at DESC, id DESC);
+	CREATE INDEX results_variant ON results (run_id, variant_id);`,
+
+	// v3: notes left on runs
+	`ALTER TABLE runs ADD COLUMN annotations TEXT; -- JSON []Annotation`,
+
+	// v4: Idempotency-Keys of POST /api/run and the runs they started
+	`CREATE TABLE idempotency_keys (
+		key        TEXT PRIMARY KEY,
+		body_hash  TEXT NOT NULL,
+		run_id     TEXT NOT NULL,
+		expires_at INTEGER NOT NULL -- unix nanoseconds
+	);
+	CREATE INDEX idempotency_keys_expires ON idempotency_keys (expires_at);`,
+}
+
+// migrate applies the steps db has not seen yet, each in its own transaction.
+func migrate(ctx context.Context, db *sql.DB, steps []string) error {
+	var version int
+	if err := db.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+	if version > len(steps) {
+		return fmt.Errorf("database schema v%d is newer than this build (v%d)", version, len(steps))
+	}
+	for v := version; v < len(steps); v++ {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, steps[v]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("v%d: %w", v+1, err)
+		}
+		// PRAGMA takes no parameters; v is an int
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`PRAGMA user_version = %d`, v+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+-- Runs with their plan, results and analysis as JSONB documents
+CREATE TABLE runs (
+    id              text PRIMARY KEY,
+    goal            text NOT NULL,
+    status          text NOT NULL,
+    started_at      timestamptz NOT NULL,
+    finished_at     timestamptz,
+    plan_id         text NOT NULL DEFAULT '',
+    winner          text NOT NULL DEFAULT '',
+    plan            jsonb,
+    results         jsonb,
+    analysis        jsonb,
+    manifest        jsonb,
+    goal_embedding  jsonb,
+    embedding_space text NOT NULL DEFAULT ''
+);
+
+CREATE INDEX runs_started ON runs (started_at DESC, id DESC);
+CREATE INDEX runs_status_started ON runs (status, started_at DESC, id DESC);
+-- Containment queries such as results @> '[{"status": "failed"}]'
+CREATE INDEX runs_results ON runs USING GIN (results jsonb_path_ops);
+CREATE INDEX runs_analysis ON runs USING GIN (analysis jsonb_path_ops);
+
+-- Append-only, and may precede the run's first save
+CREATE TABLE llm_calls (
+    id     bigserial PRIMARY KEY,
+    run_id text NOT NULL,
+    phase  text NOT NULL,
+    time   timestamptz NOT NULL,
+    record jsonb NOT NULL
+);
+
+CREATE INDEX llm_calls_run ON llm_calls (run_id, id);
@@ -0,0 +1,2 @@
+-- Notes left on runs, oldest first
+ALTER TABLE runs ADD COLUMN annotations jsonb;
@@ -0,0 +1,8 @@
+-- Idempotency-Keys of POST /api/run and the runs they started
+CREATE TABLE idempotency_keys (
+    key        text PRIMARY KEY,
+    body_hash  text NOT NULL,
+    run_id     text NOT NULL,
+    expires_at timestamptz NOT NULL
+);
+CREATE INDEX idempotency_keys_expires ON idempotency_keys (expires_at);
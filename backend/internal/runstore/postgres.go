@@ -0,0 +1,373 @@
+package runstore
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"simstack/internal/types"
+)
+
+//go:embed migrations/postgres/*.sql
+var postgresMigrations embed.FS
+
+// Replicas starting together take this advisory lock, so exactly one of them
+// applies pending migrations while the others wait.
+const postgresMigrationLock = 0x53696d537461636b // "SimStack"
+
+// CompletedChannel is the NOTIFY channel carrying the ID of every run saved
+// with a finish time, so replicas learn about runs completed elsewhere.
+const CompletedChannel = "simstack_run_completed"
+
+// Postgres is a RunStore in PostgreSQL, shared by every replica pointed at
+// the same database. Timestamps keep microsecond precision.
+type Postgres struct {
+	pool *pgxpool.Pool
+}
+
+// OpenPostgres connects to dsn (a URL or key=value string) and migrates the
+// database. Connection problems come back with what to check.
+func OpenPostgres(ctx context.Context, dsn string) (*Postgres, error) {
+	cfg, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("runstore: SIMSTACK_POSTGRES_DSN is not a valid connection string: %w", err)
+	}
+	if cfg.ConnConfig.ConnectTimeout == 0 {
+		cfg.ConnConfig.ConnectTimeout = 10 * time.Second
+	}
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("runstore: %w", err)
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, connectError(err, cfg.ConnConfig)
+	}
+	if err := migratePostgres(ctx, pool); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("runstore: migrate postgres: %w", err)
+	}
+	return &Postgres{pool: pool}, nil
+}
+
+// connectError says which part of the DSN to look at.
+func connectError(err error, cfg *pgx.ConnConfig) error {
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(int(cfg.Port)))
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "28P01", "28000":
+			return fmt.Errorf("runstore: postgres at %s rejected user %q; check the user and password in SIMSTACK_POSTGRES_DSN: %w", addr, cfg.User, err)
+		case "3D000":
+			return fmt.Errorf("runstore: database %q does not exist on %s; create it (createdb %s) or fix SIMSTACK_POSTGRES_DSN: %w", cfg.Database, addr, cfg.Database, err)
+		}
+		return fmt.Errorf("runstore: postgres at %s: %w", addr, err)
+	}
+	return fmt.Errorf("runstore: cannot reach postgres at %s; check the server is running and the host and port in SIMSTACK_POSTGRES_DSN: %w", addr, err)
+}
+
+// migratePostgres applies embedded migrations newer than the database's
+// version, each in its own transaction, under the advisory lock.
+func migratePostgres(ctx context.Context, pool *pgxpool.Pool) error {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, int64(postgresMigrationLock)); err != nil {
+		return err
+	}
+	defer conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, int64(postgresMigrationLock))
+
+	if _, err := conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    integer PRIMARY KEY,
+		applied_at timestamptz NOT NULL DEFAULT now()
+	)`); err != nil {
+		return err
+	}
+	var current int
+	if err := conn.QueryRow(ctx, `SELECT COALESCE(max(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return err
+	}
+
+	steps, err := postgresSteps()
+	if err != nil {
+		return err
+	}
+	if latest := len(steps); current > latest {
+		return fmt.Errorf("database schema v%d is newer than this build (v%d)", current, latest)
+	}
+	for _, step := range steps[current:] {
+		tx, err := conn.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, step.sql); err != nil {
+			tx.Rollback(ctx)
+			return fmt.Errorf("%s: %w", step.name, err)
+		}
+		if _, err := tx.Exec(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, step.version); err != nil {
+			tx.Rollback(ctx)
+			return err
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type postgresStep struct {
+	version int
+	name    string
+	sql     string
+}
+
+// postgresSteps reads the migrations, named NNNN_description.sql and
+// numbered from 1 without gaps.
+func postgresSteps() ([]postgresStep, error) {
+	names, err := fs.Glob(postgresMigrations, "migrations/postgres/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	steps := make([]postgresStep, 0, len(names))
+	for i, name := range names {
+		base := name[strings.LastIndex(name, "/")+1:]
+		prefix, _, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil || version != i+1 {
+			return nil, fmt.Errorf("migration %s is out of sequence", base)
+		}
+		b, err := postgresMigrations.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, postgresStep{version: version, name: base, sql: string(b)})
+	}
+	return steps, nil
+}
+
+// Close closes the connection pool.
+func (p *Postgres) Close() error {
+	p.pool.Close()
+	return nil
+}
+
+// Ping checks the database can be reached.
+func (p *Postgres) Ping(ctx context.Context) error {
+	return p.pool.Ping(ctx)
+}
+
+// Save creates or replaces the run, notifying CompletedChannel once the run
+// has finished. Audit records are left alone.
+func (p *Postgres) Save(ctx context.Context, run types.RunRecord) error {
+	blobs := make([]any, 0, 6)
+	for _, v := range []any{run.Plan, run.Results, run.Analysis, run.Manifest, run.GoalEmbedding, run.Annotations} {
+		b, err := jsonOrNull(v)
+		if err != nil {
+			return err
+		}
+		blobs = append(blobs, b)
+	}
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO runs (id, goal, status, started_at, finished_at, plan_id, winner, plan, results, analysis, manifest, goal_embedding, embedding_space, annotations)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		ON CONFLICT (id) DO UPDATE SET
+			goal = excluded.goal, status = excluded.status, started_at = excluded.started_at,
+			finished_at = excluded.finished_at, plan_id = excluded.plan_id, winner = excluded.winner,
+			plan = excluded.plan, results = excluded.results, analysis = excluded.analysis,
+			manifest = excluded.manifest, goal_embedding = excluded.goal_embedding,
+			embedding_space = excluded.embedding_space, annotations = excluded.annotations`,
+		run.ID, run.Goal, run.Status, run.StartedAt, run.FinishedAt, run.PlanID, run.Winner,
+		blobs[0], blobs[1], blobs[2], blobs[3], blobs[4], run.EmbeddingSpace, blobs[5],
+	); err != nil {
+		return err
+	}
+	if run.FinishedAt != nil {
+		// Delivered to listeners when the transaction commits
+		if _, err := tx.Exec(ctx, `SELECT pg_notify($1, $2)`, CompletedChannel, run.ID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+func (p *Postgres) Get(ctx context.Context, id string) (types.RunRecord, error) {
+	runs, err := p.query(ctx, `WHERE id = $1`, id)
+	if err != nil {
+		return types.RunRecord{}, err
+	}
+	if len(runs) == 0 {
+		return types.RunRecord{}, ErrNotFound
+	}
+	return runs[0], nil
+}
+
+// List returns runs newest first, optionally filtered by status.
+func (p *Postgres) List(ctx context.Context, opts ListOptions) ([]types.RunRecord, error) {
+	var limit *int
+	if opts.Limit > 0 {
+		limit = &opts.Limit
+	}
+	return p.query(ctx, `WHERE ($1 = '' OR status = $1) ORDER BY started_at DESC, id DESC LIMIT $2 OFFSET $3`,
+		opts.Status, limit, max(opts.Offset, 0))
+}
+
+func (p *Postgres) AppendLLMCall(ctx context.Context, call types.LLMAuditRecord) error {
+	blob, err := json.Marshal(call)
+	if err != nil {
+		return err
+	}
+	_, err = p.pool.Exec(ctx, `INSERT INTO llm_calls (run_id, phase, time, record) VALUES ($1, $2, $3, $4)`, call.RunID, call.Phase, call.Time, blob)
+	return err
+}
+
+func (p *Postgres) ClaimKey(ctx context.Context, claim KeyClaim, now time.Time) (KeyClaim, bool, error) {
+	if _, err := p.pool.Exec(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= $1`, now); err != nil {
+		return KeyClaim{}, false, err
+	}
+	// Replicas claiming the same key at once: the insert of one wins, and
+	// the others read its claim
+	tag, err := p.pool.Exec(ctx, `
+		INSERT INTO idempotency_keys (key, body_hash, run_id, expires_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (key) DO UPDATE SET body_hash = excluded.body_hash, run_id = excluded.run_id, expires_at = excluded.expires_at
+		WHERE idempotency_keys.expires_at <= $5`,
+		claim.Key, claim.BodyHash, claim.RunID, claim.Expires, now)
+	if err != nil {
+		return KeyClaim{}, false, err
+	}
+	if tag.RowsAffected() == 1 {
+		return claim, true, nil
+	}
+	held := KeyClaim{Key: claim.Key}
+	err = p.pool.QueryRow(ctx, `SELECT body_hash, run_id, expires_at FROM idempotency_keys WHERE key = $1`, claim.Key).Scan(&held.BodyHash, &held.RunID, &held.Expires)
+	held.Expires = held.Expires.UTC()
+	return held, false, err
+}
+
+func (p *Postgres) HeldKey(ctx context.Context, key string, now time.Time) (KeyClaim, bool, error) {
+	held := KeyClaim{Key: key}
+	err := p.pool.QueryRow(ctx, `SELECT body_hash, run_id, expires_at FROM idempotency_keys WHERE key = $1 AND expires_at > $2`, key, now).Scan(&held.BodyHash, &held.RunID, &held.Expires)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return KeyClaim{}, false, nil
+	}
+	if err != nil {
+		return KeyClaim{}, false, err
+	}
+	held.Expires = held.Expires.UTC()
+	return held, true, nil
+}
+
+// LLMCalls returns a run's audit records in call order.
+func (p *Postgres) LLMCalls(ctx context.Context, runID string) ([]types.LLMAuditRecord, error) {
+	var exists bool
+	if err := p.pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM runs WHERE id = $1)`, runID).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrNotFound
+	}
+	rows, err := p.pool.Query(ctx, `SELECT record FROM llm_calls WHERE run_id = $1 ORDER BY id`, runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	calls := []types.LLMAuditRecord{}
+	for rows.Next() {
+		var blob []byte
+		var call types.LLMAuditRecord
+		if err := rows.Scan(&blob); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(blob, &call); err != nil {
+			return nil, err
+		}
+		calls = append(calls, call)
+	}
+	return calls, rows.Err()
+}
+
+func (p *Postgres) query(ctx context.Context, tail string, args ...any) ([]types.RunRecord, error) {
+	rows, err := p.pool.Query(ctx, `
+		SELECT id, goal, status, started_at, finished_at, plan_id, winner, plan, results, analysis, manifest, goal_embedding, embedding_space, annotations
+		FROM runs `+tail, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var runs []types.RunRecord
+	for rows.Next() {
+		var run types.RunRecord
+		var plan, results, analysis, manifest, embedding, annotations []byte
+		if err := rows.Scan(&run.ID, &run.Goal, &run.Status, &run.StartedAt, &run.FinishedAt, &run.PlanID, &run.Winner,
+			&plan, &results, &analysis, &manifest, &embedding, &run.EmbeddingSpace, &annotations); err != nil {
+			return nil, err
+		}
+		run.StartedAt = run.StartedAt.UTC()
+		if run.FinishedAt != nil {
+			t := run.FinishedAt.UTC()
+			run.FinishedAt = &t
+		}
+		for _, f := range []struct {
+			blob []byte
+			into any
+		}{{plan, &run.Plan}, {results, &run.Results}, {analysis, &run.Analysis}, {manifest, &run.Manifest}, {embedding, &run.GoalEmbedding}, {annotations, &run.Annotations}} {
+			if err := unmarshalIfSet(f.blob, f.into); err != nil {
+				return nil, err
+			}
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// Completed streams the IDs of runs that finish on any replica, this one
+// included, until ctx is done or the listening connection fails; the channel
+// is then closed.
+func (p *Postgres) Completed(ctx context.Context) (<-chan string, error) {
+	conn, err := p.pool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{CompletedChannel}.Sanitize()); err != nil {
+		conn.Release()
+		return nil, err
+	}
+	// The session keeps listening, so it never goes back to the pool
+	listener := conn.Hijack()
+	ids := make(chan string, 64)
+	go func() {
+		defer close(ids)
+		defer listener.Close(context.Background())
+		for {
+			n, err := listener.WaitForNotification(ctx)
+			if err != nil {
+				return
+			}
+			select {
+			case ids <- n.Payload:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ids, nil
+}
@@ -0,0 +1,395 @@
+package runstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"simstack/internal/types"
+)
+
+// SQLite is a RunStore in a SQLite database file, so history survives
+// restarts. A run's scalar fields are columns; plans, variants, results and
+// analyses live in their own tables with JSON blobs for the flexible parts.
+type SQLite struct {
+	db *sql.DB
+}
+
+// Writers serialize on SQLite's lock anyway; a few connections let readers
+// and the per-call audit appends of concurrent runs proceed under WAL while
+// one run is being saved.
+const sqliteMaxConns = 8
+
+// OpenSQLite opens (creating if needed) the database at path and migrates it
+// to the current schema.
+func OpenSQLite(ctx context.Context, path string) (*SQLite, error) {
+	return openAt(ctx, path, migrations)
+}
+
+// openAt opens path migrated through steps.
+func openAt(ctx context.Context, path string, steps []string) (*SQLite, error) {
+	// Immediate transactions take the write lock up front, so concurrent
+	// writers wait out busy_timeout instead of failing on lock upgrade
+	dsn := "file:" + (&url.URL{Path: path}).EscapedPath() + "?_txlock=immediate" +
+		"&_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)&_pragma=synchronous(NORMAL)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("runstore: open %s: %w", path, err)
+	}
+	db.SetMaxOpenConns(sqliteMaxConns)
+	db.SetMaxIdleConns(sqliteMaxConns)
+	if err := migrate(ctx, db, steps); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("runstore: migrate %s: %w", path, err)
+	}
+	return &SQLite{db: db}, nil
+}
+
+// Close closes the database.
+func (s *SQLite) Close() error {
+	return s.db.Close()
+}
+
+// Ping checks the database can be reached.
+func (s *SQLite) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// Save replaces the run and everything hanging off it in one transaction.
+// Audit records are left alone.
+func (s *SQLite) Save(ctx context.Context, run types.RunRecord) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var finished sql.NullInt64
+	if run.FinishedAt != nil {
+		finished = sql.NullInt64{Int64: run.FinishedAt.UnixNano(), Valid: true}
+	}
+	manifest, err := jsonOrNull(run.Manifest)
+	if err != nil {
+		return err
+	}
+	embedding, err := jsonOrNull(run.GoalEmbedding)
+	if err != nil {
+		return err
+	}
+	annotations, err := jsonOrNull(run.Annotations)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO runs (id, goal, status, started_at, finished_at, plan_id, winner, manifest, goal_embedding, embedding_space, annotations)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			goal = excluded.goal, status = excluded.status, started_at = excluded.started_at,
+			finished_at = excluded.finished_at, plan_id = excluded.plan_id, winner = excluded.winner,
+			manifest = excluded.manifest, goal_embedding = excluded.goal_embedding,
+			embedding_space = excluded.embedding_space, annotations = excluded.annotations`,
+		run.ID, run.Goal, run.Status, run.StartedAt.UnixNano(), finished, run.PlanID, run.Winner, manifest, embedding, run.EmbeddingSpace, annotations,
+	); err != nil {
+		return err
+	}
+
+	for _, table := range []string{"plans", "variants", "results", "analyses"} {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE run_id = ?", run.ID); err != nil {
+			return err
+		}
+	}
+	if run.Plan != nil {
+		plan := *run.Plan
+		plan.Variants = nil
+		blob, _ := json.Marshal(plan)
+		if _, err := tx.ExecContext(ctx, `INSERT INTO plans (run_id, plan_id, plan) VALUES (?, ?, ?)`, run.ID, plan.PlanID, blob); err != nil {
+			return err
+		}
+		for i, v := range run.Plan.Variants {
+			params, _ := json.Marshal(v.Parameters)
+			if _, err := tx.ExecContext(ctx, `INSERT INTO variants (run_id, position, variant_id, parameters) VALUES (?, ?, ?, ?)`, run.ID, i, v.VariantID, params); err != nil {
+				return err
+			}
+		}
+	}
+	for i, r := range run.Results {
+		blob, _ := json.Marshal(r)
+		if _, err := tx.ExecContext(ctx, `INSERT INTO results (run_id, position, variant_id, status, result) VALUES (?, ?, ?, ?, ?)`, run.ID, i, r.VariantID, string(r.Status), blob); err != nil {
+			return err
+		}
+	}
+	if run.Analysis != nil {
+		blob, err := json.Marshal(run.Analysis)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO analyses (run_id, analysis) VALUES (?, ?)`, run.ID, blob); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLite) Get(ctx context.Context, id string) (types.RunRecord, error) {
+	runs, err := s.query(ctx, `WHERE id = ?`, id)
+	if err != nil {
+		return types.RunRecord{}, err
+	}
+	if len(runs) == 0 {
+		return types.RunRecord{}, ErrNotFound
+	}
+	return runs[0], nil
+}
+
+// List returns runs newest first, optionally filtered by status.
+func (s *SQLite) List(ctx context.Context, opts ListOptions) ([]types.RunRecord, error) {
+	where, args := "", []any{}
+	if opts.Status != "" {
+		where, args = "WHERE status = ?", append(args, opts.Status)
+	}
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	args = append(args, limit, max(opts.Offset, 0))
+	return s.query(ctx, where+` ORDER BY started_at DESC, id DESC LIMIT ? OFFSET ?`, args...)
+}
+
+func (s *SQLite) AppendLLMCall(ctx context.Context, call types.LLMAuditRecord) error {
+	blob, err := json.Marshal(call)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `INSERT INTO llm_calls (run_id, phase, time, record) VALUES (?, ?, ?, ?)`, call.RunID, call.Phase, call.Time.UnixNano(), blob)
+	return err
+}
+
+func (s *SQLite) ClaimKey(ctx context.Context, claim KeyClaim, now time.Time) (KeyClaim, bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return KeyClaim{}, false, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= ?`, now.UnixNano()); err != nil {
+		return KeyClaim{}, false, err
+	}
+	held := KeyClaim{Key: claim.Key}
+	var expires int64
+	err = tx.QueryRowContext(ctx, `SELECT body_hash, run_id, expires_at FROM idempotency_keys WHERE key = ?`, claim.Key).Scan(&held.BodyHash, &held.RunID, &expires)
+	if err == nil {
+		held.Expires = time.Unix(0, expires).UTC()
+		return held, false, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return KeyClaim{}, false, err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO idempotency_keys (key, body_hash, run_id, expires_at) VALUES (?, ?, ?, ?)`,
+		claim.Key, claim.BodyHash, claim.RunID, claim.Expires.UnixNano()); err != nil {
+		return KeyClaim{}, false, err
+	}
+	return claim, true, tx.Commit()
+}
+
+func (s *SQLite) HeldKey(ctx context.Context, key string, now time.Time) (KeyClaim, bool, error) {
+	held := KeyClaim{Key: key}
+	var expires int64
+	err := s.db.QueryRowContext(ctx, `SELECT body_hash, run_id, expires_at FROM idempotency_keys WHERE key = ? AND expires_at > ?`, key, now.UnixNano()).Scan(&held.BodyHash, &held.RunID, &expires)
+	if errors.Is(err, sql.ErrNoRows) {
+		return KeyClaim{}, false, nil
+	}
+	if err != nil {
+		return KeyClaim{}, false, err
+	}
+	held.Expires = time.Unix(0, expires).UTC()
+	return held, true, nil
+}
+
+// LLMCalls returns a run's audit records in call order.
+func (s *SQLite) LLMCalls(ctx context.Context, runID string) ([]types.LLMAuditRecord, error) {
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM runs WHERE id = ?)`, runID).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrNotFound
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT record FROM llm_calls WHERE run_id = ? ORDER BY id`, runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	calls := []types.LLMAuditRecord{}
+	for rows.Next() {
+		var blob []byte
+		var call types.LLMAuditRecord
+		if err := rows.Scan(&blob); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(blob, &call); err != nil {
+			return nil, err
+		}
+		calls = append(calls, call)
+	}
+	return calls, rows.Err()
+}
+
+// query loads the runs selected by tail (a WHERE/ORDER/LIMIT suffix) with
+// their plans, variants, results and analyses.
+func (s *SQLite) query(ctx context.Context, tail string, args ...any) ([]types.RunRecord, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, goal, status, started_at, finished_at, plan_id, winner, manifest, goal_embedding, embedding_space, annotations
+		FROM runs `+tail, args...)
+	if err != nil {
+		return nil, err
+	}
+	var runs []types.RunRecord
+	for rows.Next() {
+		var run types.RunRecord
+		var started int64
+		var finished sql.NullInt64
+		var manifest, embedding, annotations []byte
+		if err := rows.Scan(&run.ID, &run.Goal, &run.Status, &started, &finished, &run.PlanID, &run.Winner, &manifest, &embedding, &run.EmbeddingSpace, &annotations); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		run.StartedAt = time.Unix(0, started).UTC()
+		if finished.Valid {
+			t := time.Unix(0, finished.Int64).UTC()
+			run.FinishedAt = &t
+		}
+		if err := unmarshalIfSet(manifest, &run.Manifest); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if err := unmarshalIfSet(embedding, &run.GoalEmbedding); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if err := unmarshalIfSet(annotations, &run.Annotations); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for lo := 0; lo < len(runs); lo += detailBatch {
+		if err := s.loadDetails(ctx, runs[lo:min(lo+detailBatch, len(runs))]); err != nil {
+			return nil, err
+		}
+	}
+	return runs, nil
+}
+
+// detailBatch is how many runs loadDetails takes at once, well inside
+// SQLite's limit on query parameters.
+const detailBatch = 500
+
+// loadDetails fills in runs' plans, variants, results and analyses with
+// one query per table, whatever the number of runs.
+func (s *SQLite) loadDetails(ctx context.Context, runs []types.RunRecord) error {
+	byID := make(map[string]*types.RunRecord, len(runs))
+	ids := make([]any, len(runs))
+	for i := range runs {
+		byID[runs[i].ID] = &runs[i]
+		ids[i] = runs[i].ID
+	}
+	in := "IN (?" + strings.Repeat(", ?", len(ids)-1) + ")"
+
+	if err := s.scanRows(ctx, `SELECT run_id, plan FROM plans WHERE run_id `+in, ids, func(rows *sql.Rows) error {
+		var runID string
+		var blob []byte
+		if err := rows.Scan(&runID, &blob); err != nil {
+			return err
+		}
+		var plan types.SimulationPlan
+		if err := json.Unmarshal(blob, &plan); err != nil {
+			return err
+		}
+		byID[runID].Plan = &plan
+		return nil
+	}); err != nil {
+		return err
+	}
+	if err := s.scanRows(ctx, `SELECT run_id, variant_id, parameters FROM variants WHERE run_id `+in+` ORDER BY run_id, position`, ids, func(rows *sql.Rows) error {
+		var runID string
+		var v types.Variant
+		var params []byte
+		if err := rows.Scan(&runID, &v.VariantID, &params); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(params, &v.Parameters); err != nil {
+			return err
+		}
+		// Variants are only kept alongside their plan
+		if plan := byID[runID].Plan; plan != nil {
+			plan.Variants = append(plan.Variants, v)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if err := s.scanRows(ctx, `SELECT run_id, result FROM results WHERE run_id `+in+` ORDER BY run_id, position`, ids, func(rows *sql.Rows) error {
+		var runID string
+		var blob []byte
+		if err := rows.Scan(&runID, &blob); err != nil {
+			return err
+		}
+		var r types.SimulationResult
+		if err := json.Unmarshal(blob, &r); err != nil {
+			return err
+		}
+		run := byID[runID]
+		run.Results = append(run.Results, r)
+		return nil
+	}); err != nil {
+		return err
+	}
+	return s.scanRows(ctx, `SELECT run_id, analysis FROM analyses WHERE run_id `+in, ids, func(rows *sql.Rows) error {
+		var runID string
+		var blob []byte
+		if err := rows.Scan(&runID, &blob); err != nil {
+			return err
+		}
+		return json.Unmarshal(blob, &byID[runID].Analysis)
+	})
+}
+
+func (s *SQLite) scanRows(ctx context.Context, query string, args []any, scan func(*sql.Rows) error) error {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// jsonOrNull encodes v, storing nil pointers and slices as SQL NULL.
+func jsonOrNull(v any) (any, error) {
+	b, err := json.Marshal(v)
+	if err != nil || string(b) == "null" {
+		return nil, err
+	}
+	return b, nil
+}
+
+func unmarshalIfSet(blob []byte, v any) error {
+	if len(blob) == 0 {
+		return nil
+	}
+	return json.Unmarshal(blob, v)
+}
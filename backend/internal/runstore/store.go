@@ -0,0 +1,277 @@
+// Package runstore keeps the history of runs started by the engine.
+package runstore
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"simstack/internal/types"
+)
+
+var ErrNotFound = errors.New("runstore: run not found")
+
+// NewRunID returns a fresh run ID. The random suffix keeps runs started in
+// the same clock tick, or by another replica, apart.
+func NewRunID() string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("run-%d-%s", time.Now().UnixNano(), hex.EncodeToString(b))
+}
+
+type ListOptions struct {
+	Limit  int
+	Offset int
+	Status string
+}
+
+// RunStore persists run records. Save creates or replaces by ID. LLM call
+// audit records are append-only and kept apart from the run record, so
+// saving a run never drops calls logged while it was in flight.
+//
+// ClaimKey records claim unless its key is held by an earlier claim that
+// hasn't expired by now; it then returns that claim and false. Expired
+// claims are dropped as it goes. HeldKey returns the claim holding key at
+// now, if any, without claiming it.
+type RunStore interface {
+	Save(ctx context.Context, run types.RunRecord) error
+	Get(ctx context.Context, id string) (types.RunRecord, error)
+	List(ctx context.Context, opts ListOptions) ([]types.RunRecord, error)
+	AppendLLMCall(ctx context.Context, call types.LLMAuditRecord) error
+	LLMCalls(ctx context.Context, runID string) ([]types.LLMAuditRecord, error)
+	ClaimKey(ctx context.Context, claim KeyClaim, now time.Time) (KeyClaim, bool, error)
+	HeldKey(ctx context.Context, key string, now time.Time) (KeyClaim, bool, error)
+}
+
+// Pinger is a RunStore that can check its database is reachable.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Completer is a RunStore shared between replicas that reports the runs
+// finished on any of them.
+type Completer interface {
+	Completed(ctx context.Context) (<-chan string, error)
+}
+
+// KeyClaim ties an Idempotency-Key to the run its first request started,
+// until Expires. BodyHash identifies that request, so a different one sent
+// with the same key can be told apart.
+type KeyClaim struct {
+	Key      string
+	BodyHash string
+	RunID    string
+	Expires  time.Time
+}
+
+// Memory is an in-process RunStore. On its own, history is lost on restart
+// and runs it evicts are gone; in front of a persistent store (NewCache) it
+// writes through, and reads what it no longer holds from the store.
+type Memory struct {
+	mu        sync.RWMutex
+	runs      map[string]types.RunRecord
+	calls     map[string][]types.LLMAuditRecord
+	keys      map[string]KeyClaim
+	backing   RunStore
+	retention Retention
+}
+
+// Retention bounds the completed runs a Memory keeps. Runs that haven't
+// finished are never evicted and don't count toward MaxRuns.
+type Retention struct {
+	// Evict runs finished longer ago; zero keeps them regardless of age
+	MaxAge time.Duration
+	// Evict all but the newest MaxRuns finished runs; zero keeps any number
+	MaxRuns int
+}
+
+func NewMemory() *Memory {
+	return &Memory{runs: make(map[string]types.RunRecord), calls: make(map[string][]types.LLMAuditRecord), keys: make(map[string]KeyClaim)}
+}
+
+// NewCache returns a Memory in front of backing.
+func NewCache(backing RunStore) *Memory {
+	m := NewMemory()
+	m.backing = backing
+	return m
+}
+
+// Ping checks the store behind the cache, if any and it can be checked.
+func (m *Memory) Ping(ctx context.Context) error {
+	if p, ok := m.backing.(Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+// SetRetention sets the policy Evict applies.
+func (m *Memory) SetRetention(r Retention) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.retention = r
+}
+
+// Evict drops the finished runs the retention policy no longer covers, with
+// their LLM call records, skipping any run active reports as in flight. It
+// returns how many runs it dropped.
+func (m *Memory) Evict(now time.Time, active func(id string) bool) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var finished []types.RunRecord
+	for _, r := range m.runs {
+		if r.FinishedAt != nil && (active == nil || !active(r.ID)) {
+			finished = append(finished, r)
+		}
+	}
+	// Newest first, so the ones past MaxRuns are at the end
+	sort.Slice(finished, func(i, j int) bool {
+		if finished[i].FinishedAt.Equal(*finished[j].FinishedAt) {
+			return finished[i].ID > finished[j].ID
+		}
+		return finished[i].FinishedAt.After(*finished[j].FinishedAt)
+	})
+	evicted := 0
+	for i, r := range finished {
+		expired := m.retention.MaxAge > 0 && now.Sub(*r.FinishedAt) > m.retention.MaxAge
+		if expired || (m.retention.MaxRuns > 0 && i >= m.retention.MaxRuns) {
+			delete(m.runs, r.ID)
+			delete(m.calls, r.ID)
+			evicted++
+		}
+	}
+	return evicted
+}
+
+// Resident returns how many runs are held in memory.
+func (m *Memory) Resident() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.runs)
+}
+
+func (m *Memory) Save(ctx context.Context, run types.RunRecord) error {
+	if m.backing != nil {
+		if err := m.backing.Save(ctx, run); err != nil {
+			return err
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.runs[run.ID] = run
+	return nil
+}
+
+func (m *Memory) Get(ctx context.Context, id string) (types.RunRecord, error) {
+	m.mu.RLock()
+	run, ok := m.runs[id]
+	m.mu.RUnlock()
+	if ok {
+		return run, nil
+	}
+	if m.backing != nil {
+		return m.backing.Get(ctx, id)
+	}
+	return types.RunRecord{}, ErrNotFound
+}
+
+// List returns runs newest first, optionally filtered by status. In front of
+// a store, the store lists every run, evicted or not.
+func (m *Memory) List(ctx context.Context, opts ListOptions) ([]types.RunRecord, error) {
+	if m.backing != nil {
+		return m.backing.List(ctx, opts)
+	}
+	m.mu.RLock()
+	runs := make([]types.RunRecord, 0, len(m.runs))
+	for _, r := range m.runs {
+		if opts.Status == "" || r.Status == opts.Status {
+			runs = append(runs, r)
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(runs, func(i, j int) bool {
+		if runs[i].StartedAt.Equal(runs[j].StartedAt) {
+			return runs[i].ID > runs[j].ID
+		}
+		return runs[i].StartedAt.After(runs[j].StartedAt)
+	})
+	return paginate(runs, opts), nil
+}
+
+func (m *Memory) AppendLLMCall(ctx context.Context, call types.LLMAuditRecord) error {
+	if m.backing != nil {
+		if err := m.backing.AppendLLMCall(ctx, call); err != nil {
+			return err
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls[call.RunID] = append(m.calls[call.RunID], call)
+	return nil
+}
+
+// LLMCalls returns a run's audit records in call order.
+func (m *Memory) LLMCalls(ctx context.Context, runID string) ([]types.LLMAuditRecord, error) {
+	m.mu.RLock()
+	_, ok := m.runs[runID]
+	calls := append([]types.LLMAuditRecord{}, m.calls[runID]...)
+	m.mu.RUnlock()
+	if ok {
+		return calls, nil
+	}
+	if m.backing != nil {
+		return m.backing.LLMCalls(ctx, runID)
+	}
+	return nil, ErrNotFound
+}
+
+// ClaimKey claims in the backing store when there is one, so every replica
+// sharing it sees the same claims.
+func (m *Memory) ClaimKey(ctx context.Context, claim KeyClaim, now time.Time) (KeyClaim, bool, error) {
+	if m.backing != nil {
+		return m.backing.ClaimKey(ctx, claim, now)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, c := range m.keys {
+		if !now.Before(c.Expires) {
+			delete(m.keys, key)
+		}
+	}
+	if held, ok := m.keys[claim.Key]; ok {
+		return held, false, nil
+	}
+	m.keys[claim.Key] = claim
+	return claim, true, nil
+}
+
+// HeldKey reads the backing store's claims when there is one.
+func (m *Memory) HeldKey(ctx context.Context, key string, now time.Time) (KeyClaim, bool, error) {
+	if m.backing != nil {
+		return m.backing.HeldKey(ctx, key, now)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if held, ok := m.keys[key]; ok && now.Before(held.Expires) {
+		return held, true, nil
+	}
+	return KeyClaim{}, false, nil
+}
+
+func paginate(runs []types.RunRecord, opts ListOptions) []types.RunRecord {
+	if opts.Offset > 0 {
+		if opts.Offset >= len(runs) {
+			return nil
+		}
+		runs = runs[opts.Offset:]
+	}
+	if opts.Limit > 0 && opts.Limit < len(runs) {
+		runs = runs[:opts.Limit]
+	}
+	return runs
+}
@@ -0,0 +1,396 @@
+package runstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"simstack/internal/types"
+)
+
+// testStore is the behaviour every RunStore must share.
+func testStore(t *testing.T, open func(t *testing.T) RunStore) {
+	ctx := context.Background()
+	// Postgres keeps microseconds
+	base := time.Date(2026, 1, 2, 3, 4, 5, 6000, time.UTC)
+
+	t.Run("round trip", func(t *testing.T) {
+		s := open(t)
+		finished := base.Add(time.Minute)
+		cost := 0.01
+		run := types.RunRecord{
+			ID: "run-1", Goal: "reduce wait", Status: "completed", StartedAt: base, FinishedAt: &finished,
+			PlanID: "plan-1", Winner: "plan-1-v2",
+			Plan: &types.SimulationPlan{PlanID: "plan-1", Model: "m", Temperature: 0.7, LLM: true, Variants: []types.Variant{
+				{VariantID: "plan-1-v1", Parameters: map[string]any{"arrival_rate": 10.0}},
+				{VariantID: "plan-1-v2", Parameters: map[string]any{"staff": 20.0, "shifts": []any{"am", "pm"}}},
+			}},
+			Results: []types.SimulationResult{
+				{VariantID: "plan-1-v2", Tool: "composite", Metrics: map[string]float64{"queue_avg_wait": 2.5}, Status: types.ResultComplete},
+				{VariantID: "plan-1-v1", Tool: "composite", Metrics: map[string]float64{}, Status: types.ResultFailed},
+			},
+			Analysis:       map[string]any{"winner": "plan-1-v2", "confidence": 0.8},
+			Manifest:       &types.RunManifest{RunID: "run-1", Model: "m", CostUSD: &cost},
+			Annotations:    []types.Annotation{{ID: "ann-1", Author: "dana", Text: "approved for pilot", VariantID: "plan-1-v2", Tags: []string{"decision"}, CreatedAt: base}},
+			GoalEmbedding:  []float64{0.25, -0.5},
+			EmbeddingSpace: "trigram",
+		}
+		if err := s.Save(ctx, run); err != nil {
+			t.Fatal(err)
+		}
+		got, err := s.Get(ctx, "run-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, _ := json.Marshal(run)
+		have, _ := json.Marshal(got)
+		if string(want) != string(have) {
+			t.Errorf("run changed in the store:\nwant %s\n got %s", want, have)
+		}
+		if !reflect.DeepEqual(got.GoalEmbedding, run.GoalEmbedding) || got.EmbeddingSpace != "trigram" {
+			t.Errorf("embedding changed: %v %q", got.GoalEmbedding, got.EmbeddingSpace)
+		}
+		if _, err := s.Get(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("save replaces", func(t *testing.T) {
+		s := open(t)
+		run := types.RunRecord{ID: "run-1", Goal: "g", Status: "running", StartedAt: base,
+			Plan: &types.SimulationPlan{PlanID: "p", Variants: []types.Variant{{VariantID: "p-v1"}, {VariantID: "p-v2"}}}}
+		_ = s.Save(ctx, run)
+		run.Status = "completed"
+		run.Plan = &types.SimulationPlan{PlanID: "p", Variants: []types.Variant{{VariantID: "p-v3"}}}
+		if err := s.Save(ctx, run); err != nil {
+			t.Fatal(err)
+		}
+		got, _ := s.Get(ctx, "run-1")
+		if got.Status != "completed" || len(got.Plan.Variants) != 1 || got.Plan.Variants[0].VariantID != "p-v3" || got.FinishedAt != nil {
+			t.Errorf("expected the second save to win, got %+v", got)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		s := open(t)
+		for i := 0; i < 5; i++ {
+			status := "completed"
+			if i%2 == 1 {
+				status = "running"
+			}
+			_ = s.Save(ctx, types.RunRecord{ID: fmt.Sprintf("run-%d", i), Goal: "g", Status: status, StartedAt: base.Add(time.Duration(i) * time.Second)})
+		}
+		// Same start time: ties break by ID, descending
+		_ = s.Save(ctx, types.RunRecord{ID: "run-4b", Goal: "g", Status: "completed", StartedAt: base.Add(4 * time.Second)})
+
+		ids := func(runs []types.RunRecord) []string {
+			var out []string
+			for _, r := range runs {
+				out = append(out, r.ID)
+			}
+			return out
+		}
+		all, _ := s.List(ctx, ListOptions{})
+		if got := ids(all); !reflect.DeepEqual(got, []string{"run-4b", "run-4", "run-3", "run-2", "run-1", "run-0"}) {
+			t.Errorf("expected newest first, got %v", got)
+		}
+		page, _ := s.List(ctx, ListOptions{Limit: 2, Offset: 1})
+		if got := ids(page); !reflect.DeepEqual(got, []string{"run-4", "run-3"}) {
+			t.Errorf("unexpected page %v", got)
+		}
+		running, _ := s.List(ctx, ListOptions{Status: "running"})
+		if got := ids(running); !reflect.DeepEqual(got, []string{"run-3", "run-1"}) {
+			t.Errorf("unexpected status filter %v", got)
+		}
+		if past, _ := s.List(ctx, ListOptions{Offset: 10}); len(past) != 0 {
+			t.Errorf("expected nothing past the end, got %v", ids(past))
+		}
+	})
+
+	t.Run("list with details", func(t *testing.T) {
+		s := open(t)
+		for i := 0; i < 3; i++ {
+			id := fmt.Sprintf("run-%d", i)
+			run := types.RunRecord{ID: id, Goal: "g", Status: "completed", StartedAt: base.Add(time.Duration(i) * time.Second)}
+			// The first run never got a plan
+			if i > 0 {
+				run.Plan = &types.SimulationPlan{PlanID: id, Variants: []types.Variant{{VariantID: id + "-v1"}, {VariantID: id + "-v2"}}}
+				run.Results = []types.SimulationResult{{VariantID: id + "-v2", Metrics: map[string]float64{"m": float64(i)}}, {VariantID: id + "-v1"}}
+				run.Analysis = map[string]any{"winner": id + "-v2"}
+			}
+			_ = s.Save(ctx, run)
+		}
+		all, err := s.List(ctx, ListOptions{})
+		if err != nil || len(all) != 3 {
+			t.Fatalf("expected 3 runs, got %d (%v)", len(all), err)
+		}
+		for _, run := range all {
+			want, _ := s.Get(ctx, run.ID)
+			if !reflect.DeepEqual(run, want) {
+				t.Errorf("listed %s differs from Get:\nlist %+v\n get %+v", run.ID, run, want)
+			}
+		}
+		if last := all[2]; last.Plan != nil || last.Results != nil || last.Analysis != nil {
+			t.Errorf("expected the run without a plan bare, got %+v", last)
+		}
+		if first := all[0]; len(first.Plan.Variants) != 2 || first.Results[0].VariantID != "run-2-v2" || first.Analysis["winner"] != "run-2-v2" {
+			t.Errorf("expected the newest run's own details, got %+v", first)
+		}
+	})
+
+	t.Run("llm calls", func(t *testing.T) {
+		s := open(t)
+		if _, err := s.LLMCalls(ctx, "run-1"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound for an unknown run, got %v", err)
+		}
+		_ = s.Save(ctx, types.RunRecord{ID: "run-1", Goal: "g", Status: "running", StartedAt: base})
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if err := s.AppendLLMCall(ctx, types.LLMAuditRecord{RunID: "run-1", Phase: "plan", Time: base, TotalTokens: i}); err != nil {
+					t.Error(err)
+				}
+			}(i)
+		}
+		wg.Wait()
+		_ = s.AppendLLMCall(ctx, types.LLMAuditRecord{RunID: "run-1", Phase: "analysis", Time: base, PromptHash: "h"})
+		// Saving the finished run keeps calls logged while it was in flight
+		_ = s.Save(ctx, types.RunRecord{ID: "run-1", Goal: "g", Status: "completed", StartedAt: base})
+
+		calls, err := s.LLMCalls(ctx, "run-1")
+		if err != nil || len(calls) != 21 || calls[20].Phase != "analysis" || calls[20].PromptHash != "h" {
+			t.Fatalf("expected 21 calls ending with the analysis, got %d (%v)", len(calls), err)
+		}
+		seen := map[int]bool{}
+		for _, c := range calls[:20] {
+			seen[c.TotalTokens] = true
+		}
+		if len(seen) != 20 {
+			t.Errorf("expected every concurrent append, got %v", seen)
+		}
+	})
+
+	t.Run("idempotency keys", func(t *testing.T) {
+		s := open(t)
+		first := KeyClaim{Key: "k", BodyHash: "h1", RunID: "run-1", Expires: base.Add(time.Hour)}
+		if got, ok, err := s.ClaimKey(ctx, first, base); err != nil || !ok || got != first {
+			t.Fatalf("expected a fresh key claimed, got %+v %v %v", got, ok, err)
+		}
+		again := KeyClaim{Key: "k", BodyHash: "h2", RunID: "run-2", Expires: base.Add(2 * time.Hour)}
+		if got, ok, err := s.ClaimKey(ctx, again, base.Add(time.Minute)); err != nil || ok || got.RunID != "run-1" || got.BodyHash != "h1" || !got.Expires.Equal(first.Expires) {
+			t.Errorf("expected the first claim to hold, got %+v %v %v", got, ok, err)
+		}
+		if got, ok, err := s.HeldKey(ctx, "k", base.Add(time.Minute)); err != nil || !ok || got.RunID != "run-1" || got.BodyHash != "h1" {
+			t.Errorf("expected the held claim read back, got %+v %v %v", got, ok, err)
+		}
+		if _, ok, err := s.HeldKey(ctx, "k", base.Add(time.Hour)); err != nil || ok {
+			t.Errorf("expected an expired claim not held, got %v %v", ok, err)
+		}
+		if _, ok, err := s.HeldKey(ctx, "unknown", base); err != nil || ok {
+			t.Errorf("expected an unknown key not held, got %v %v", ok, err)
+		}
+		if _, ok, _ := s.ClaimKey(ctx, KeyClaim{Key: "other", RunID: "run-3", Expires: base.Add(time.Hour)}, base); !ok {
+			t.Error("expected another key claimed alongside")
+		}
+		if got, ok, err := s.ClaimKey(ctx, again, base.Add(time.Hour)); err != nil || !ok || got.RunID != "run-2" {
+			t.Errorf("expected an expired claim replaced, got %+v %v %v", got, ok, err)
+		}
+	})
+}
+
+func TestMemory(t *testing.T) {
+	testStore(t, func(t *testing.T) RunStore { return NewMemory() })
+}
+
+func TestCache(t *testing.T) {
+	testStore(t, func(t *testing.T) RunStore { return NewCache(NewMemory()) })
+}
+
+// fill saves n finished runs, a minute apart from base, and one still running.
+func fill(t *testing.T, s RunStore, base time.Time, n int) {
+	for i := 0; i < n; i++ {
+		finished := base.Add(time.Duration(i) * time.Minute)
+		run := types.RunRecord{ID: fmt.Sprintf("run-%d", i), Status: "completed", StartedAt: finished, FinishedAt: &finished}
+		if err := s.Save(context.Background(), run); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.AppendLLMCall(context.Background(), types.LLMAuditRecord{RunID: run.ID, Phase: "plan"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Save(context.Background(), types.RunRecord{ID: "running", Status: "running", StartedAt: base}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemoryEviction(t *testing.T) {
+	ctx := context.Background()
+	base := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	m := NewMemory()
+	fill(t, m, base, 10)
+
+	if n := m.Evict(base, nil); n != 0 || m.Resident() != 11 {
+		t.Fatalf("expected no eviction without a policy, got %d (%d resident)", n, m.Resident())
+	}
+
+	// run-0 is past the limit, but still reported active
+	m.SetRetention(Retention{MaxRuns: 3})
+	active := func(id string) bool { return id == "run-0" }
+	if n := m.Evict(base, active); n != 6 || m.Resident() != 5 {
+		t.Errorf("expected 6 evicted, 5 resident, got %d and %d", n, m.Resident())
+	}
+	for _, id := range []string{"run-0", "run-7", "run-8", "run-9", "running"} {
+		if _, err := m.Get(ctx, id); err != nil {
+			t.Errorf("%s: expected it resident, got %v", id, err)
+		}
+	}
+	if _, err := m.Get(ctx, "run-6"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected an evicted run gone without a backing store, got %v", err)
+	}
+	if _, err := m.LLMCalls(ctx, "run-6"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected an evicted run's calls gone, got %v", err)
+	}
+
+	// By age the running run stays however old it is
+	m.SetRetention(Retention{MaxAge: time.Hour})
+	if n := m.Evict(base.Add(24*time.Hour), nil); n != 4 || m.Resident() != 1 {
+		t.Errorf("expected every finished run evicted by age, got %d (%d resident)", n, m.Resident())
+	}
+}
+
+func TestCacheFallsThrough(t *testing.T) {
+	ctx := context.Background()
+	base := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	backing := NewMemory()
+	c := NewCache(backing)
+	c.SetRetention(Retention{MaxRuns: 2})
+	fill(t, c, base, 5)
+
+	if n := c.Evict(base, nil); n != 3 || c.Resident() != 3 || backing.Resident() != 6 {
+		t.Fatalf("expected 3 evicted from memory only, got %d (%d resident, %d stored)", n, c.Resident(), backing.Resident())
+	}
+	run, err := c.Get(ctx, "run-0")
+	if err != nil || run.ID != "run-0" {
+		t.Errorf("expected an evicted run read from the store, got %+v %v", run, err)
+	}
+	if calls, err := c.LLMCalls(ctx, "run-0"); err != nil || len(calls) != 1 {
+		t.Errorf("expected an evicted run's calls from the store, got %v %v", calls, err)
+	}
+	if runs, err := c.List(ctx, ListOptions{}); err != nil || len(runs) != 6 {
+		t.Errorf("expected every stored run listed, got %d %v", len(runs), err)
+	}
+	if c.Resident() != 3 {
+		t.Errorf("reads must not bring evicted runs back, got %d resident", c.Resident())
+	}
+}
+
+func TestSQLite(t *testing.T) {
+	testStore(t, func(t *testing.T) RunStore {
+		s, err := OpenSQLite(context.Background(), filepath.Join(t.TempDir(), "runs.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	})
+}
+
+func TestSQLiteSurvivesReopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "runs.db")
+	s, err := OpenSQLite(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = s.Save(ctx, types.RunRecord{ID: "run-1", Goal: "g", Status: "completed", StartedAt: time.Now().UTC()})
+	s.Close()
+
+	s, err = OpenSQLite(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if run, err := s.Get(ctx, "run-1"); err != nil || run.Goal != "g" {
+		t.Errorf("expected the run after reopening, got %+v %v", run, err)
+	}
+}
+
+func schemaVersion(t *testing.T, s *SQLite) int {
+	var v int
+	if err := s.db.QueryRow(`PRAGMA user_version`).Scan(&v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestSQLiteMigratesEmptyDatabase(t *testing.T) {
+	s, err := OpenSQLite(context.Background(), filepath.Join(t.TempDir(), "runs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if v := schemaVersion(t, s); v != len(migrations) {
+		t.Errorf("expected schema v%d, got v%d", len(migrations), v)
+	}
+	var mode string
+	_ = s.db.QueryRow(`PRAGMA journal_mode`).Scan(&mode)
+	if mode != "wal" {
+		t.Errorf("expected WAL mode, got %q", mode)
+	}
+}
+
+// A database written by the first schema keeps its runs, which gain empty
+// embeddings.
+func TestSQLiteMigratesV1Database(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "runs.db")
+	v1, err := openAt(ctx, path, migrations[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v1.db.Exec(`INSERT INTO runs (id, goal, status, started_at) VALUES ('run-1', 'g', 'completed', 1)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v1.db.Exec(`INSERT INTO llm_calls (run_id, phase, time, record) VALUES ('run-1', 'plan', 1, '{"run_id":"run-1","phase":"plan"}')`); err != nil {
+		t.Fatal(err)
+	}
+	v1.Close()
+
+	s, err := OpenSQLite(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if v := schemaVersion(t, s); v != len(migrations) {
+		t.Errorf("expected schema v%d, got v%d", len(migrations), v)
+	}
+	run, err := s.Get(ctx, "run-1")
+	if err != nil || run.Goal != "g" || run.GoalEmbedding != nil || run.EmbeddingSpace != "" {
+		t.Errorf("expected the v1 run without an embedding, got %+v %v", run, err)
+	}
+	if calls, err := s.LLMCalls(ctx, "run-1"); err != nil || len(calls) != 1 {
+		t.Errorf("expected the v1 audit record, got %v %v", calls, err)
+	}
+}
+
+func TestSQLiteRejectsNewerSchema(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "runs.db")
+	s, err := OpenSQLite(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = s.db.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, len(migrations)+1))
+	s.Close()
+	if _, err := OpenSQLite(ctx, path); err == nil {
+		t.Error("expected an error opening a database from a newer build")
+	}
+}
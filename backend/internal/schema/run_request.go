@@ -0,0 +1,59 @@
+package schema
+
+import (
+	_ "embed"
+	"encoding/json"
+	"slices"
+	"strings"
+)
+
+//go:embed run-request.json
+var runRequestJSON []byte
+
+// RunRequest is the schema for types.RunRequest, served at
+// /api/schemas/run-request.json.
+var RunRequest = mustParse(runRequestJSON)
+
+// ValidateRunRequest checks a POST /api/run body. Unknown top-level fields
+// are errors when strict and are returned as warnings otherwise. An optional
+// field sent as null is taken as absent, as decoding the body does.
+func ValidateRunRequest(body []byte, strict bool) (warnings []string, err error) {
+	var errs []FieldError
+	for _, fe := range RunRequest.Validate(body) {
+		if fe.Keyword == "type" && optionalNull(body, fe.Pointer) {
+			continue
+		}
+		if !strict && fe.Keyword == "additionalProperties" && strings.Count(fe.Pointer, "/") == 1 {
+			warnings = append(warnings, "ignoring unknown field "+strings.TrimPrefix(fe.Pointer, "/"))
+			continue
+		}
+		errs = append(errs, fe)
+	}
+	if len(errs) > 0 {
+		return warnings, &ValidationError{Errors: errs}
+	}
+	return warnings, nil
+}
+
+// optionalNull reports whether pointer names a top-level field of body that
+// isn't required and was sent as null.
+func optionalNull(body []byte, pointer string) bool {
+	name, top := strings.CutPrefix(pointer, "/")
+	if !top || strings.Contains(name, "/") || slices.Contains(stringList(RunRequest.root["required"]), name) {
+		return false
+	}
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(body, &fields) != nil {
+		return false
+	}
+	raw, ok := fields[strings.NewReplacer("~1", "/", "~0", "~").Replace(name)]
+	return ok && string(raw) == "null"
+}
+
+func mustParse(b []byte) *Schema {
+	s, err := Parse(b)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
@@ -0,0 +1,256 @@
+// Package schema publishes the JSON Schemas for API request bodies and
+// validates bodies against them. The validator covers the subset of draft
+// 2020-12 the published schemas use: type, properties, required,
+// additionalProperties, maxProperties, items, enum, minimum/maximum,
+// minLength/maxLength and minItems/maxItems.
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// FieldError is one violation, located by a JSON Pointer (RFC 6901) into the
+// validated document.
+type FieldError struct {
+	Pointer string `json:"pointer"`
+	Keyword string `json:"keyword"`
+	Message string `json:"message"`
+	// Where the violation is in the document as sent, when it was sent as
+	// YAML
+	Line   int `json:"line,omitempty"`
+	Column int `json:"column,omitempty"`
+}
+
+func (e FieldError) Error() string {
+	pointer := e.Pointer
+	if pointer == "" {
+		pointer = "/"
+	}
+	if e.Line > 0 {
+		pointer += fmt.Sprintf(" (line %d, column %d)", e.Line, e.Column)
+	}
+	return pointer + ": " + e.Message
+}
+
+// ValidationError lists every violation found in a document.
+type ValidationError struct {
+	Errors []FieldError `json:"errors"`
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		msgs[i] = fe.Error()
+	}
+	return "schema validation failed: " + strings.Join(msgs, "; ")
+}
+
+// Schema is a parsed JSON Schema document.
+type Schema struct {
+	raw  []byte
+	root map[string]any
+}
+
+// Parse reads a schema document.
+func Parse(b []byte) (*Schema, error) {
+	var root map[string]any
+	if err := json.Unmarshal(b, &root); err != nil {
+		return nil, fmt.Errorf("schema: %w", err)
+	}
+	return &Schema{raw: b, root: root}, nil
+}
+
+// JSON returns the schema document as published.
+func (s *Schema) JSON() []byte {
+	return s.raw
+}
+
+// Validate checks doc (raw JSON) against the schema and returns every
+// violation, or nil.
+func (s *Schema) Validate(doc []byte) []FieldError {
+	var v any
+	if err := json.Unmarshal(doc, &v); err != nil {
+		return []FieldError{{Keyword: "json", Message: "invalid JSON: " + err.Error()}}
+	}
+	var errs []FieldError
+	validate(s.root, v, "", &errs)
+	return errs
+}
+
+func validate(schema map[string]any, v any, ptr string, errs *[]FieldError) {
+	fail := func(keyword, format string, args ...any) {
+		*errs = append(*errs, FieldError{Pointer: ptr, Keyword: keyword, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if t, ok := schema["type"]; ok && !matchesType(t, v) {
+		fail("type", "expected %s, got %s", typeNames(t), typeOf(v))
+		return
+	}
+	if enum, ok := schema["enum"].([]any); ok && !inEnum(enum, v) {
+		fail("enum", "must be one of %v", enum)
+	}
+
+	switch val := v.(type) {
+	case map[string]any:
+		props, _ := schema["properties"].(map[string]any)
+		if n, ok := number(schema["maxProperties"]); ok && float64(len(val)) > n {
+			fail("maxProperties", "must have at most %v properties", n)
+		}
+		for _, name := range stringList(schema["required"]) {
+			if _, ok := val[name]; !ok {
+				fail("required", "missing required property %q", name)
+			}
+		}
+		for _, name := range sortedKeys(val) {
+			child := ptr + "/" + escape(name)
+			if sub, ok := props[name].(map[string]any); ok {
+				validate(sub, val[name], child, errs)
+				continue
+			}
+			switch extra := schema["additionalProperties"].(type) {
+			case bool:
+				if !extra {
+					*errs = append(*errs, FieldError{Pointer: child, Keyword: "additionalProperties", Message: "unknown property"})
+				}
+			case map[string]any:
+				validate(extra, val[name], child, errs)
+			}
+		}
+	case []any:
+		if n, ok := number(schema["minItems"]); ok && float64(len(val)) < n {
+			fail("minItems", "must have at least %v items", n)
+		}
+		if n, ok := number(schema["maxItems"]); ok && float64(len(val)) > n {
+			fail("maxItems", "must have at most %v items", n)
+		}
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range val {
+				validate(items, item, fmt.Sprintf("%s/%d", ptr, i), errs)
+			}
+		}
+	case string:
+		if n, ok := number(schema["minLength"]); ok && float64(len([]rune(val))) < n {
+			fail("minLength", "must be at least %v characters", n)
+		}
+		if n, ok := number(schema["maxLength"]); ok && float64(len([]rune(val))) > n {
+			fail("maxLength", "must be at most %v characters", n)
+		}
+	case float64:
+		if n, ok := number(schema["minimum"]); ok && val < n {
+			fail("minimum", "must be >= %v, got %v", n, val)
+		}
+		if n, ok := number(schema["maximum"]); ok && val > n {
+			fail("maximum", "must be <= %v, got %v", n, val)
+		}
+	}
+}
+
+func matchesType(t, v any) bool {
+	for _, name := range stringList(t) {
+		switch name {
+		case "object":
+			if _, ok := v.(map[string]any); ok {
+				return true
+			}
+		case "array":
+			if _, ok := v.([]any); ok {
+				return true
+			}
+		case "string":
+			if _, ok := v.(string); ok {
+				return true
+			}
+		case "boolean":
+			if _, ok := v.(bool); ok {
+				return true
+			}
+		case "number":
+			if _, ok := v.(float64); ok {
+				return true
+			}
+		case "integer":
+			if f, ok := v.(float64); ok && f == math.Trunc(f) {
+				return true
+			}
+		case "null":
+			if v == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func typeOf(v any) string {
+	switch val := v.(type) {
+	case nil:
+		return "null"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		if val == math.Trunc(val) {
+			return "integer"
+		}
+		return "number"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func typeNames(t any) string {
+	return strings.Join(stringList(t), " or ")
+}
+
+// stringList accepts a string or an array of strings.
+func stringList(v any) []string {
+	switch val := v.(type) {
+	case string:
+		return []string{val}
+	case []any:
+		out := make([]string, 0, len(val))
+		for _, item := range val {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func inEnum(enum []any, v any) bool {
+	for _, e := range enum {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+func number(v any) (float64, bool) {
+	f, ok := v.(float64)
+	return f, ok
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// escape encodes a property name as a JSON Pointer reference token.
+func escape(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~", "~0"), "/", "~1")
+}
@@ -0,0 +1,141 @@
+package schema
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"simstack/internal/types"
+)
+
+// A RunRequest with every field set must validate and survive a round trip,
+// and the schema must list exactly the struct's fields.
+func TestRunRequestSchemaMatchesStruct(t *testing.T) {
+	plannerTemp, criticTemp := 0.0, 0.0
+	seed := int64(42)
+	maximal := types.RunRequest{
+		Goal:                 "Cut ER wait by 20%",
+		Constraints:          map[string]any{"budget": 100000.0, "max_staff": 30.0, "shifts": []any{"day", "night"}},
+		Parameters:           map[string]any{"arrival_rate": 10.0, "service_rate": 12.0, "density": 0.5, "staff": 20.0, "shifts": []any{"day"}},
+		Debug:                true,
+		Model:                "llama3.1-8b",
+		PlannerModel:         "llama3.1-8b",
+		CriticModel:          "gpt-oss-120b",
+		PlannerTemperature:   &plannerTemp,
+		CriticTemperature:    &criticTemp,
+		Offline:              true,
+		LLMTimeBudgetSeconds: 90,
+		LLMTokenBudget:       4000,
+		Reproducible:         true,
+		Seed:                 &seed,
+		OrderedResults:       true,
+		Notify:               &types.NotifyTargets{SlackWebhookURL: "https://hooks.slack.test/x", DiscordWebhookURL: "https://discord.test/api/webhooks/y"},
+	}
+	b, _ := json.Marshal(maximal)
+	if errs := RunRequest.Validate(b); len(errs) != 0 {
+		t.Fatalf("maximal example rejected: %v", errs)
+	}
+	var back types.RunRequest
+	if err := json.Unmarshal(b, &back); err != nil || !reflect.DeepEqual(back, maximal) {
+		t.Errorf("round trip changed the request: %+v (%v)", back, err)
+	}
+
+	var fields []string
+	rt := reflect.TypeOf(types.RunRequest{})
+	for i := 0; i < rt.NumField(); i++ {
+		name, _, _ := strings.Cut(rt.Field(i).Tag.Get("json"), ",")
+		fields = append(fields, name)
+	}
+	var props []string
+	for name := range RunRequest.root["properties"].(map[string]any) {
+		props = append(props, name)
+	}
+	sort.Strings(fields)
+	sort.Strings(props)
+	if !reflect.DeepEqual(fields, props) {
+		t.Errorf("schema properties %v out of sync with RunRequest fields %v", props, fields)
+	}
+}
+
+func TestValidateRunRequestPointers(t *testing.T) {
+	body := `{"goal": "", "critic_temperature": 3, "parameters": {"density": "high", "staff": 2.5}, "llm_token_budget": -1}`
+	_, err := ValidateRunRequest([]byte(body), false)
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("expected a ValidationError, got %v", err)
+	}
+	want := map[string]string{
+		"/goal":               "minLength",
+		"/critic_temperature": "maximum",
+		"/parameters/density": "type",
+		"/parameters/staff":   "type",
+		"/llm_token_budget":   "minimum",
+	}
+	got := map[string]string{}
+	for _, fe := range verr.Errors {
+		got[fe.Pointer] = fe.Keyword
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected errors %v", verr.Errors)
+	}
+
+	if _, err := ValidateRunRequest([]byte(`{"constraints": {}}`), false); err == nil || !strings.Contains(err.Error(), `missing required property "goal"`) {
+		t.Errorf("expected missing goal, got %v", err)
+	}
+}
+
+func TestUnknownFieldsStrictOrWarn(t *testing.T) {
+	body := []byte(`{"goal": "g", "variant_count": 5, "constraints": {"x": 1}}`)
+	warnings, err := ValidateRunRequest(body, false)
+	if err != nil || len(warnings) != 1 || !strings.Contains(warnings[0], "variant_count") {
+		t.Errorf("expected a warning for variant_count, got %v %v", warnings, err)
+	}
+	if _, err := ValidateRunRequest(body, true); err == nil || !strings.Contains(err.Error(), "/variant_count: unknown property") {
+		t.Errorf("expected strict mode to reject variant_count, got %v", err)
+	}
+}
+
+func TestRunRequestNullOptionalFields(t *testing.T) {
+	body := []byte(`{"goal": "g", "seed": null, "notify": null, "critic_temperature": null, "parameters": {"density": null}}`)
+	_, err := ValidateRunRequest(body, true)
+	verr, ok := err.(*ValidationError)
+	if !ok || len(verr.Errors) != 1 || verr.Errors[0].Pointer != "/parameters/density" {
+		t.Errorf("expected only the nested null rejected, got %v", err)
+	}
+	if _, err := ValidateRunRequest([]byte(`{"goal": null}`), false); err == nil || !strings.Contains(err.Error(), "/goal: expected string, got null") {
+		t.Errorf("expected a null goal rejected, got %v", err)
+	}
+}
+
+func TestRunRequestLimits(t *testing.T) {
+	many := map[string]any{}
+	for i := 0; i < 65; i++ {
+		many[strings.Repeat("k", i+1)] = 1.0
+	}
+	body, _ := json.Marshal(map[string]any{
+		"goal":        strings.Repeat("g", 10001),
+		"constraints": map[string]any{"nested": map[string]any{"x": 1.0}, "list": []any{map[string]any{}}, "long": strings.Repeat("c", 2001)},
+		"parameters":  many,
+	})
+	_, err := ValidateRunRequest(body, false)
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("expected a ValidationError, got %v", err)
+	}
+	want := map[string]string{
+		"/goal":               "maxLength",
+		"/constraints/nested": "type",
+		"/constraints/list/0": "type",
+		"/constraints/long":   "maxLength",
+		"/parameters":         "maxProperties",
+	}
+	got := map[string]string{}
+	for _, fe := range verr.Errors {
+		got[fe.Pointer] = fe.Keyword
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected errors %v", verr.Errors)
+	}
+}
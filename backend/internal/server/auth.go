@@ -0,0 +1,90 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsAuthProtocol is the subprotocol a browser offers, alongside its key,
+// to authenticate a WebSocket; browsers can't set headers on the upgrade.
+const wsAuthProtocol = "bearer"
+
+// requireKey passes requests for /api and /ws on to next only when they
+// carry one of the keys returned for each request, and everything else
+// always. With no keys, nothing is checked. A key is sent as a bearer token
+// or an X-API-Key header, or for /ws as ?token= or a subprotocol offered
+// with wsAuthProtocol. Keys are never logged.
+func requireKey(next http.Handler, keys func() []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accepted := keys()
+		if len(accepted) == 0 || !guarded(r.URL.Path) || keyAccepted(accepted, presentedKeys(r)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="simstack"`)
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, "missing or invalid API key")
+	})
+}
+
+// guarded reports whether path needs a key: the API and the event stream,
+// but not health checks, /metrics or the UI.
+func guarded(path string) bool {
+	return path == "/ws" || strings.HasPrefix(path, "/api/")
+}
+
+// presentedKeys returns every key r offers.
+func presentedKeys(r *http.Request) []string {
+	var keys []string
+	if scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		keys = append(keys, strings.TrimSpace(token))
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		keys = append(keys, key)
+	}
+	if r.URL.Path == "/ws" {
+		if token := r.URL.Query().Get("token"); token != "" {
+			keys = append(keys, token)
+		}
+		for _, p := range websocket.Subprotocols(r) {
+			if p != wsAuthProtocol {
+				keys = append(keys, p)
+			}
+		}
+	}
+	return keys
+}
+
+// caller names whoever sent r by the API key it was let in with, hashed so
+// that nothing holding the name holds the key. It is empty when no keys are
+// configured, as every caller is then the same.
+func (s *Server) caller(r *http.Request) string {
+	accepted := s.apiKeys()
+	if len(accepted) == 0 {
+		return ""
+	}
+	for _, key := range presentedKeys(r) {
+		if keyAccepted(accepted, []string{key}) {
+			sum := sha256.Sum256([]byte(key))
+			return "key:" + hex.EncodeToString(sum[:8])
+		}
+	}
+	return ""
+}
+
+// keyAccepted reports whether any of presented is one of accepted,
+// comparing in constant time.
+func keyAccepted(accepted, presented []string) bool {
+	for _, p := range presented {
+		for _, a := range accepted {
+			if subtle.ConstantTimeCompare([]byte(p), []byte(a)) == 1 {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,246 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// compressMinBytes is the smallest body worth compressing; below it the
+// encoding's framing can outweigh the savings.
+const compressMinBytes = 1024
+
+// withCompression gzips, or deflates, /api responses the client accepts
+// that way, when they are JSON, YAML or text of at least compressMinBytes.
+// WebSocket upgrades pass straight through, and so does anything the
+// handler encoded or ranged itself, like the gzipped tar export. Streams
+// still reach the client as each handler flushes them.
+func withCompression(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") || r.Method == http.MethodHead || isUpgrade(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// isUpgrade reports whether r asks to switch protocols, as a WebSocket
+// handshake does.
+func isUpgrade(r *http.Request) bool {
+	for _, v := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// negotiateEncoding picks gzip or deflate, whichever Accept-Encoding
+// prefers, gzip on a tie, or "" for neither.
+func negotiateEncoding(accept string) string {
+	q := map[string]float64{}
+	for _, part := range strings.Split(accept, ",") {
+		coding, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		weight := 1.0
+		if v, ok := params["q"]; ok {
+			if weight, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		q[coding] = weight
+	}
+	for _, coding := range []string{"gzip", "deflate"} {
+		if _, ok := q[coding]; !ok {
+			if star, ok := q["*"]; ok {
+				q[coding] = star
+			}
+		}
+	}
+	best, bestQ := "", 0.0
+	for _, coding := range []string{"gzip", "deflate"} {
+		if q[coding] > bestQ {
+			best, bestQ = coding, q[coding]
+		}
+	}
+	return best
+}
+
+// compressibleTypes are the media types withCompression encodes, besides
+// text/* and *+json.
+var compressibleTypes = map[string]bool{
+	"application/json":     true,
+	"application/x-ndjson": true,
+	"application/yaml":     true,
+	"application/x-yaml":   true,
+	"application/x-sh":     true,
+}
+
+func compressible(h http.Header) bool {
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return compressibleTypes[mediaType] || strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "+json")
+}
+
+var (
+	gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(io.Discard) }}
+	zlibWriters = sync.Pool{New: func() any { return zlib.NewWriter(io.Discard) }}
+)
+
+// compressWriter holds back the status and the first compressMinBytes of
+// the body, until it knows whether compressing them is worth it.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+
+	status  int
+	decided bool
+	buf     bytes.Buffer
+	// Set once the body is being compressed
+	enc interface {
+		io.WriteCloser
+		Flush() error
+		Reset(io.Writer)
+	}
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.decided || cw.status != 0 {
+		return
+	}
+	// Informational answers go out at once and don't end the headers
+	if status < 200 && status != http.StatusSwitchingProtocols {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	cw.status = status
+	if status == http.StatusNoContent || status == http.StatusNotModified || status == http.StatusSwitchingProtocols {
+		cw.decide(false)
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.decided {
+		if cw.enc != nil {
+			return cw.enc.Write(p)
+		}
+		return cw.ResponseWriter.Write(p)
+	}
+	if cw.encoding == "" || !compressible(cw.Header()) {
+		cw.decide(false)
+		return cw.ResponseWriter.Write(p)
+	}
+	cw.buf.Write(p)
+	if cw.buf.Len() >= compressMinBytes {
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// decide sends the headers, compressed or not, and then whatever body was
+// held back.
+func (cw *compressWriter) decide(compress bool) error {
+	if cw.decided {
+		return nil
+	}
+	cw.decided = true
+	h := cw.Header()
+	if compressible(h) {
+		// Whether compressed this time or not, the body depends on the header
+		h.Add("Vary", "Accept-Encoding")
+	}
+	if compress {
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		if cw.encoding == "gzip" {
+			cw.enc = gzipWriters.Get().(*gzip.Writer)
+		} else {
+			cw.enc = zlibWriters.Get().(*zlib.Writer)
+		}
+		cw.enc.Reset(cw.ResponseWriter)
+	}
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+	if cw.buf.Len() == 0 {
+		return nil
+	}
+	var err error
+	if cw.enc != nil {
+		_, err = cw.enc.Write(cw.buf.Bytes())
+	} else {
+		_, err = cw.ResponseWriter.Write(cw.buf.Bytes())
+	}
+	cw.buf.Reset()
+	return err
+}
+
+// Flush sends what is held back, so that streaming handlers keep
+// streaming. A flushed response is taken to be a stream, worth compressing
+// however little of it there is so far.
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+		_ = cw.decide(cw.encoding != "" && compressible(cw.Header()))
+	}
+	if cw.enc != nil {
+		_ = cw.enc.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Close finishes the response: what is still held back goes out, and the
+// compressed stream is terminated.
+func (cw *compressWriter) Close() error {
+	if !cw.decided && cw.status != 0 {
+		if err := cw.decide(cw.buf.Len() >= compressMinBytes); err != nil {
+			return err
+		}
+	}
+	if cw.enc == nil {
+		return nil
+	}
+	err := cw.enc.Close()
+	if gz, ok := cw.enc.(*gzip.Writer); ok {
+		gzipWriters.Put(gz)
+	} else {
+		zlibWriters.Put(cw.enc)
+	}
+	cw.enc = nil
+	return err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
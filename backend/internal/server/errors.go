@@ -0,0 +1,52 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Codes in the error envelope, for clients to branch on instead of matching
+// messages.
+const (
+	codeInvalidRequest      = "invalid_request"
+	codeMethodNotAllowed    = "method_not_allowed"
+	codeUnauthorized        = "unauthorized"
+	codeForbidden           = "forbidden"
+	codeNotFound            = "not_found"
+	codeRunNotFound         = "run_not_found"
+	codeConflict            = "conflict"
+	codeIdempotencyConflict = "idempotency_conflict"
+	codeRunFinished         = "run_finished"
+	codeNoWinner            = "no_winner"
+	codeArtifactExpired     = "artifact_expired"
+	codeBodyTooLarge        = "body_too_large"
+	codeInvalidConfig       = "invalid_config"
+	codeRateLimited         = "rate_limited"
+	codeQueueFull           = "queue_full"
+	codeInternal            = "internal"
+	codeUpstream            = "upstream_error"
+	codeShuttingDown        = "shutting_down"
+)
+
+// apiError is the body of every error response, under "error".
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// More about the error where there is any, e.g. the fields that failed
+	// validation
+	Details any `json:"details,omitempty"`
+}
+
+// writeError answers status with the JSON error envelope,
+// {"error": {"code": code, "message": msg}}.
+func writeError(w http.ResponseWriter, status int, code, msg string) {
+	writeErrorDetails(w, status, code, msg, nil)
+}
+
+// writeErrorDetails is writeError with details in the envelope.
+func writeErrorDetails(w http.ResponseWriter, status int, code, msg string, details any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(errorBody{Error: apiError{Code: code, Message: msg, Details: details}})
+}
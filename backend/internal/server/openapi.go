@@ -0,0 +1,240 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"simstack/internal/archive"
+	"simstack/internal/cerebras"
+	"simstack/internal/config"
+	"simstack/internal/openapi"
+	"simstack/internal/schema"
+	"simstack/internal/types"
+)
+
+// Responses that aren't a type of their own elsewhere, named so that the
+// OpenAPI document describes what the handlers send.
+type (
+	// runStarted answers a run or replay request: started, or queued at
+	// Position.
+	runStarted struct {
+		Status   string `json:"status"`
+		RunID    string `json:"run_id"`
+		Position int    `json:"position"`
+		// Of a replay, the plan run again and the run it came from
+		PlanID   string `json:"plan_id,omitempty"`
+		ReplayOf string `json:"replay_of,omitempty"`
+		// Unknown run request fields that were ignored
+		Warnings []string `json:"warnings,omitempty"`
+	}
+	runCancel struct {
+		Status string `json:"status"`
+		RunID  string `json:"run_id"`
+	}
+	annotationRequest struct {
+		Author    string   `json:"author"`
+		Text      string   `json:"text"`
+		VariantID string   `json:"variant_id"`
+		Tags      []string `json:"tags"`
+	}
+	annotationsResponse struct {
+		Annotations []types.Annotation `json:"annotations"`
+	}
+	llmCallsResponse struct {
+		LLMCalls []types.LLMAuditRecord `json:"llm_calls"`
+	}
+	importResponse struct {
+		Report archive.Report `json:"report"`
+	}
+	modelsResponse struct {
+		Models []cerebras.ModelInfo `json:"models"`
+	}
+	simulatorsResponse struct {
+		Window     string                  `json:"window"`
+		Simulators []types.SimulatorStats  `json:"simulators"`
+		Health     []types.SimulatorHealth `json:"health"`
+	}
+	readiness struct {
+		Ready bool `json:"ready"`
+		// Why not, when not ready
+		Reasons    []string                `json:"reasons,omitempty"`
+		Simulators []types.SimulatorHealth `json:"simulators"`
+	}
+	errorBody struct {
+		Error apiError `json:"error"`
+	}
+	// route is an entry of GET /api/routes.
+	route struct {
+		Method  string `json:"method"`
+		Path    string `json:"path"`
+		Summary string `json:"summary"`
+		// Served without an API key
+		Public bool `json:"public,omitempty"`
+	}
+)
+
+// Statuses most run-scoped reads answer with an error
+var runReadErrors = []int{http.StatusNotFound, http.StatusInternalServerError}
+
+// apiOperations is every operation the router serves. TestOpenAPICoversRoutes
+// holds it to the routes NewServer registers.
+var apiOperations = []openapi.Operation{
+	{Method: "POST", Path: "/api/run", Summary: "Start a run, or queue it behind the runs in flight",
+		Body: types.RunRequest{}, BodyTypes: []string{"application/x-yaml"}, Response: runStarted{},
+		Errors: []int{400, 405, 409, 413, 429, 500, 503}},
+	{Method: "POST", Path: "/api/replay", Summary: "Run a stored or given plan again without planning",
+		Body: types.ReplayRequest{}, Response: runStarted{}, Errors: []int{400, 404, 413, 429, 500, 503}},
+	{Method: "POST", Path: "/api/export", Summary: "Export a run's winning variant as a compose file, env file, JSON or script",
+		Query: []openapi.Param{{Name: "format", Type: "string", Description: "compose, env, json-params or script; otherwise chosen by Accept"}},
+		Body:  types.ExportRequest{}, BodyTypes: []string{"application/x-yaml"},
+		ResponseTypes: []string{"application/x-yaml", "text/plain", "application/json", "application/x-sh"},
+		Errors:        []int{400, 404, 405, 409, 413, 429, 500}},
+	{Method: "GET", Path: "/api/runs", Summary: "List runs newest first, or those with goals like a run's",
+		Query: []openapi.Param{
+			{Name: "status", Type: "string"},
+			{Name: "limit", Type: "integer"},
+			{Name: "offset", Type: "integer"},
+			{Name: "similar_to", Type: "string", Description: "A run ID; lists the runs with the most similar goals"},
+		},
+		Response: openapi.OneOf{[]types.RunSummary{}, []types.SimilarRun{}}, Errors: []int{400, 404, 405, 500}},
+	{Method: "GET", Path: "/api/runs/{id}", Summary: "Get a run's record", Response: types.RunRecord{}, Errors: runReadErrors},
+	{Method: "POST", Path: "/api/run/{id}/cancel", Summary: "Cancel a queued run (200) or one in flight (202)",
+		Status: http.StatusAccepted, Also: []int{http.StatusOK}, Response: runCancel{}, Errors: []int{404, 409}},
+	{Method: "GET", Path: "/api/run/{id}/status", Summary: "Poll a run's phase and progress", Response: types.RunProgress{}, Errors: runReadErrors},
+	{Method: "GET", Path: "/api/run/{id}/results", Summary: "Get a run's plan, results and analysis, so far while it runs",
+		Response: types.RunResults{}, Errors: runReadErrors},
+	{Method: "GET", Path: "/api/runs/{id}/results.ndjson", Summary: "Stream a run's results one JSON object per line",
+		Query:         []openapi.Param{{Name: "follow", Type: "boolean", Description: "Keep an active run's stream open until it is done"}},
+		ResponseTypes: []string{"application/x-ndjson"}, Errors: []int{400, 404, 500}},
+	{Method: "GET", Path: "/api/runs/{id}/llm-calls", Summary: "Get the audit trail of a run's LLM calls", Response: llmCallsResponse{}, Errors: runReadErrors},
+	{Method: "GET", Path: "/api/runs/{id}/annotations", Summary: "List a run's annotations", Response: annotationsResponse{}, Errors: runReadErrors},
+	{Method: "POST", Path: "/api/runs/{id}/annotations", Summary: "Annotate a run",
+		Body: annotationRequest{}, Status: http.StatusCreated, Response: types.Annotation{}, Errors: []int{400, 404, 500}},
+	{Method: "DELETE", Path: "/api/runs/{id}/annotations/{annotation}", Summary: "Remove an annotation; only the API key that added it may",
+		Status: http.StatusNoContent, Errors: []int{400, 403, 404, 500}},
+	{Method: "GET", Path: "/api/runs/{id}/artifacts/{variant}/{name}", Summary: "Download an artifact kept from a variant's simulators",
+		ResponseTypes: []string{"application/octet-stream"}, Errors: []int{404, 410, 500}},
+	{Method: "GET", Path: "/api/runs/{id}/metrics", Summary: "Get a run's timing and token metrics", Response: types.RunMetrics{}, Errors: []int{404}},
+	{Method: "GET", Path: "/api/runs/{id}/grafana", Summary: "Download a Grafana dashboard of a run",
+		ResponseTypes: []string{"application/json"}, Errors: runReadErrors},
+	{Method: "GET", Path: "/api/compare/{a}/{b}/narrative", Summary: "Explain in Markdown how run b differs from run a",
+		Response: types.RunNarrative{}, Errors: runReadErrors},
+	{Method: "GET", Path: "/api/admin/export", Summary: "Export run history as an archive",
+		Query: []openapi.Param{
+			{Name: "format", Type: "string", Description: "tar (default) or jsonl"},
+			{Name: "status", Type: "string"},
+			{Name: "since", Type: "string", Description: "RFC 3339"},
+			{Name: "until", Type: "string", Description: "RFC 3339"},
+			{Name: "artifacts", Type: "boolean", Description: "Include artifacts; needs format=tar"},
+		},
+		ResponseTypes: []string{"application/gzip", "application/x-ndjson"}, Errors: []int{400}},
+	{Method: "POST", Path: "/api/admin/import", Summary: "Import an archive of run history",
+		Query: []openapi.Param{
+			{Name: "on_conflict", Type: "string", Description: "skip (default) or remap"},
+			{Name: "dry_run", Type: "boolean"},
+		},
+		BodyTypes: []string{"application/gzip", "application/x-ndjson"}, Response: importResponse{}, Errors: []int{400, 500}},
+	{Method: "POST", Path: "/api/admin/reload", Summary: "Reload the configuration", Response: config.Report{}, Errors: []int{422}},
+	{Method: "GET", Path: "/api/config", Summary: "Get the configuration in effect, secrets hidden", Response: configResponse{}},
+	{Method: "GET", Path: "/api/models", Summary: "List the LLM provider's models", Response: modelsResponse{}, Errors: []int{405, 502}},
+	{Method: "GET", Path: "/api/metrics", Summary: "Get performance metrics and the last runs'",
+		Query:    []openapi.Param{{Name: "runs", Type: "integer", Description: "How many recent runs to include (20)"}},
+		Response: types.MetricsSnapshot{}, Errors: []int{400}},
+	{Method: "GET", Path: "/api/simulators", Summary: "Get each simulator's latency, errors and health", Response: simulatorsResponse{}},
+	{Method: "GET", Path: "/api/schemas/run-request.json", Summary: "Get the JSON Schema of run requests", ResponseTypes: []string{"application/schema+json"}},
+	{Method: "GET", Path: "/api/openapi.json", Summary: "Get this document", ResponseTypes: []string{"application/json"}},
+	{Method: "GET", Path: "/api/version", Summary: "Get the running build and the features it has on", Response: types.BuildInfo{}},
+	{Method: "GET", Path: "/api/routes", Summary: "List every operation's method and path", Response: []route{}},
+	{Method: "GET", Path: "/ws", Summary: "Subscribe to events; each message is a WSEvent",
+		Query: []openapi.Param{
+			{Name: "v", Type: "integer", Description: "Envelope version; 1 for the legacy envelope"},
+			{Name: "run", Type: "string", Description: "Only the events of this run"},
+			{Name: "token", Type: "string", Description: "API key, for clients that can't set headers"},
+		},
+		Status: http.StatusSwitchingProtocols, Response: types.WSEvent{}},
+	{Method: "GET", Path: "/metrics", Summary: "Scrape counters, gauges and summaries in the Prometheus exposition format",
+		ResponseTypes: []string{"text/plain", "application/openmetrics-text"}, Public: true},
+	{Method: "GET", Path: "/livez", Summary: "Liveness: 503 when the event hub has stopped answering",
+		ResponseTypes: []string{"text/plain"}, Also: []int{http.StatusServiceUnavailable}, Public: true},
+	{Method: "GET", Path: "/healthz", Summary: "Liveness, as /livez", ResponseTypes: []string{"text/plain"}, Also: []int{http.StatusServiceUnavailable}, Public: true},
+	{Method: "GET", Path: "/healthz/deep", Summary: "Probe the simulators and the LLM provider: 503 when no simulator answers",
+		Response: types.DeepHealth{}, Also: []int{http.StatusServiceUnavailable}, Public: true},
+	{Method: "GET", Path: "/readyz", Summary: "Readiness: 503 while shutting down, or the hub, run store or a simulator is down",
+		Response: readiness{}, Also: []int{http.StatusServiceUnavailable}, Public: true},
+}
+
+// openAPIDocument is the OpenAPI document of apiOperations, built once.
+var openAPIDocument = sync.OnceValue(func() []byte {
+	g := openapi.New("simstack/internal/types", "simstack/internal/server")
+	if err := g.Define(types.RunRequest{}, schema.RunRequest.JSON()); err != nil {
+		panic(err)
+	}
+	g.Name(errorBody{}, "Error")
+	g.Name(apiError{}, "ErrorDetail")
+	g.Name(llmCallsResponse{}, "LLMCallsResponse")
+	// WSEvent stands for the union of the event types, each the envelope
+	// with its own payload, told apart by type
+	g.Name(types.WSEvent{}, "WSEvent")
+	envelope := g.Component("EventEnvelope", g.Schema(struct{ types.WSEvent }{}))
+	payloads := types.EventPayloads()
+	typs := make([]string, 0, len(payloads))
+	for typ := range payloads {
+		typs = append(typs, typ)
+	}
+	sort.Strings(typs)
+	var variants []any
+	mapping := map[string]any{}
+	for _, typ := range typs {
+		name := "Event" + camel(typ)
+		ref := g.Component(name, map[string]any{"allOf": []any{envelope, map[string]any{
+			"type":       "object",
+			"required":   []string{"type"},
+			"properties": map[string]any{"type": map[string]any{"const": typ}, "payload": g.Schema(payloads[typ])},
+		}}})
+		variants = append(variants, ref)
+		mapping[typ] = ref["$ref"]
+	}
+	g.Component("WSEvent", map[string]any{
+		"oneOf":         variants,
+		"discriminator": map[string]any{"propertyName": "type", "mapping": mapping},
+	})
+
+	doc := g.Document(openapi.Info{
+		Title:       "SimStack API",
+		Version:     "1",
+		Description: "Every error answers with the Error envelope.",
+	}, apiOperations, errorBody{})
+	b, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	return b
+})
+
+// camel turns an event type such as sim_start into SimStart.
+func camel(s string) string {
+	parts := strings.Split(s, "_")
+	for i, p := range parts {
+		parts[i] = strings.ToUpper(p[:1]) + p[1:]
+	}
+	return strings.Join(parts, "")
+}
+
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(openAPIDocument())
+}
+
+// handleRoutes lists every operation, for clients and tools that want the
+// routes without reading the OpenAPI document.
+func (s *Server) handleRoutes(w http.ResponseWriter, r *http.Request) {
+	routes := make([]route, len(apiOperations))
+	for i, op := range apiOperations {
+		routes[i] = route{Method: op.Method, Path: op.Path, Summary: op.Summary, Public: op.Public}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(routes)
+}
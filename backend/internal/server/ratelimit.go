@@ -0,0 +1,59 @@
+package server
+
+import (
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// limited spends one of the client's tokens before h runs, answering 429
+// when it has none. Either way the response carries the client's quota in
+// X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset, the
+// seconds until its bucket is full. A limiter that fails lets the request
+// through.
+func (s *Server) limited(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.limiter == nil {
+			h(w, r)
+			return
+		}
+		d, err := s.limiter.Allow(r.Context(), s.clientKey(r))
+		if err != nil {
+			slog.WarnContext(r.Context(), "rate limit unavailable", "err", err)
+			h(w, r)
+			return
+		}
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(d.Limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(d.Remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.Itoa(seconds(d.Reset)))
+		if !d.Allowed {
+			wait := max(seconds(d.RetryAfter), 1)
+			w.Header().Set("Retry-After", strconv.Itoa(wait))
+			writeError(w, http.StatusTooManyRequests, codeRateLimited, "rate limit exceeded; retry in "+strconv.Itoa(wait)+"s")
+			return
+		}
+		h(w, r)
+	}
+}
+
+// clientKey names whoever sent r for rate limiting: the API key it was
+// let in with, hashed so that limiter state never holds keys, or else its
+// address. Clients behind one proxy share an address, and so a limit.
+func (s *Server) clientKey(r *http.Request) string {
+	if caller := s.caller(r); caller != "" {
+		return caller
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// seconds rounds d up to whole seconds.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// RedirectHTTPS answers every request with a permanent redirect to the same
+// path on the HTTPS listener at httpsAddr, on the host the client asked
+// for. It is served on SIMSTACK_TLS_REDIRECT_ADDR, typically :80.
+func RedirectHTTPS(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		} else {
+			// A bracketed IPv6 address without a port, e.g. [::1]
+			host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		}
+		if host == "" {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "missing Host header")
+			return
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		// 308 keeps the method and body, so a POST isn't turned into a GET
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,356 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// relayChannel is the Redis channel replicas share their events on.
+	relayChannel = "simstack:events"
+	// relayQueue is how many events may wait to be published before the
+	// relay drops some.
+	relayQueue = 1024
+	// Bounds on how long a relay waits to subscribe again after losing Redis
+	relayMinBackoff = time.Second
+	relayMaxBackoff = 30 * time.Second
+	// How often a subscription pings Redis, and how long it waits for any
+	// reply before taking the connection for dead
+	relayPing        = 30 * time.Second
+	relayReadTimeout = 2 * relayPing
+	// Bounds on a reply, so a bad peer can't make the relay allocate at will
+	relayMaxBulk  = 8 << 20
+	relayMaxArray = 1024
+	relayMaxDepth = 4
+)
+
+// relayed is a hub frame as replicas pass it through Redis, tagged with the
+// replica it came from.
+type relayed struct {
+	Origin  string          `json:"origin"`
+	RunID   string          `json:"run_id,omitempty"`
+	Current json.RawMessage `json:"current"`
+	Legacy  json.RawMessage `json:"legacy"`
+}
+
+// relay shares a hub's broadcasts with the other replicas subscribed to
+// the same Redis, and hands theirs to the hub's clients. Redis also delivers
+// a replica's own messages back to it; those are dropped, since the hub's
+// clients already have them.
+type relay struct {
+	hub    *Hub
+	origin string
+
+	addr     string
+	tls      bool
+	user     string
+	password string
+
+	outbox chan []byte
+}
+
+// newRelay returns a relay for hub through the Redis at rawURL, a redis://
+// or rediss:// URL that may carry a user and password.
+func newRelay(rawURL string, hub *Hub) (*relay, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "redis" && u.Scheme != "rediss") || u.Hostname() == "" {
+		return nil, errors.New("expected a redis:// or rediss:// URL with a host")
+	}
+	r := &relay{
+		hub:    hub,
+		origin: relayOrigin(),
+		addr:   u.Host,
+		tls:    u.Scheme == "rediss",
+		outbox: make(chan []byte, relayQueue),
+	}
+	if u.Port() == "" {
+		r.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		r.user = u.User.Username()
+		r.password, _ = u.User.Password()
+	}
+	return r, nil
+}
+
+// publish queues f for the other replicas, dropping it if Redis has fallen
+// that far behind.
+func (r *relay) publish(f frame) {
+	msg, err := json.Marshal(relayed{Origin: r.origin, RunID: f.runID, Current: f.current, Legacy: f.legacy})
+	if err != nil {
+		return
+	}
+	select {
+	case r.outbox <- msg:
+	default:
+	}
+}
+
+// run publishes and subscribes until ctx is done, dialing Redis again
+// whenever it goes away, and returns once both have stopped.
+func (r *relay) run(ctx context.Context) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		r.publishLoop(ctx)
+	}()
+	r.subscribeLoop(ctx)
+	wg.Wait()
+}
+
+func (r *relay) publishLoop(ctx context.Context) {
+	var conn *redisConn
+	var retryAt time.Time
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	for {
+		var msg []byte
+		select {
+		case <-ctx.Done():
+			return
+		case msg = <-r.outbox:
+		}
+		// Events raised while Redis is down are dropped rather than held
+		if conn == nil {
+			if time.Now().Before(retryAt) {
+				continue
+			}
+			var err error
+			if conn, err = r.dial(ctx); err != nil {
+				slog.Warn("relay: publish", "err", err)
+				retryAt = time.Now().Add(relayMinBackoff)
+				continue
+			}
+		}
+		_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+		if _, err := conn.do("PUBLISH", relayChannel, string(msg)); err != nil {
+			slog.Warn("relay: publish", "err", err)
+			conn.Close()
+			conn = nil
+		}
+	}
+}
+
+func (r *relay) subscribeLoop(ctx context.Context) {
+	backoff := relayMinBackoff
+	for ctx.Err() == nil {
+		subscribed, err := r.subscribe(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		slog.Warn("relay: subscribe", "err", err)
+		if subscribed {
+			backoff = relayMinBackoff
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, relayMaxBackoff)
+	}
+}
+
+// subscribe hands other replicas' events to the hub until the connection
+// fails, reporting whether it got as far as subscribing. It pings Redis
+// every relayPing, so a connection that stops answering is noticed.
+func (r *relay) subscribe(ctx context.Context) (bool, error) {
+	conn, err := r.dial(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := conn.do("SUBSCRIBE", relayChannel); err != nil {
+		return false, err
+	}
+	pinged := make(chan struct{})
+	defer close(pinged)
+	go func() {
+		ticker := time.NewTicker(relayPing)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-pinged:
+				return
+			case <-ticker.C:
+				_ = conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+				if conn.send("PING") != nil {
+					return
+				}
+			}
+		}
+	}()
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(relayReadTimeout))
+		reply, err := conn.read()
+		if err != nil {
+			return true, err
+		}
+		// Pushes are ["message", channel, payload]
+		push, _ := reply.([]any)
+		if len(push) != 3 || push[0] != "message" {
+			continue
+		}
+		payload, _ := push[2].(string)
+		var m relayed
+		if json.Unmarshal([]byte(payload), &m) != nil || m.Origin == r.origin || len(m.Current) == 0 {
+			continue
+		}
+		if len(m.Legacy) == 0 {
+			m.Legacy = m.Current
+		}
+		select {
+		case r.hub.broadcast <- frame{current: m.Current, legacy: m.Legacy, runID: m.RunID}:
+		case <-ctx.Done():
+			return true, ctx.Err()
+		}
+	}
+}
+
+// dial connects to Redis and authenticates if the URL had a password.
+func (r *relay) dial(ctx context.Context) (*redisConn, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	var conn net.Conn
+	var err error
+	if r.tls {
+		conn, err = (&tls.Dialer{}).DialContext(ctx, "tcp", r.addr)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", r.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &redisConn{Conn: conn, r: bufio.NewReader(conn)}
+	if r.password != "" {
+		args := []string{"AUTH", r.password}
+		if r.user != "" {
+			args = []string{"AUTH", r.user, r.password}
+		}
+		_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+		_, err := c.do(args...)
+		_ = conn.SetDeadline(time.Time{})
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// relayOrigin returns an ID for this replica's messages.
+func relayOrigin() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// redisConn speaks the little of the Redis protocol (RESP) a relay needs.
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// do sends a command and reads its reply.
+func (c *redisConn) do(args ...string) (any, error) {
+	if err := c.send(args...); err != nil {
+		return nil, err
+	}
+	return c.read()
+}
+
+// send sends a command without waiting for its reply.
+func (c *redisConn) send(args ...string) error {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	_, err := c.Write(b.Bytes())
+	return err
+}
+
+// read reads one reply: a string, an int64, a []any, or nil for a null.
+// An error reply is returned as an error.
+func (c *redisConn) read() (any, error) {
+	return readReply(c.r)
+}
+
+// readReply reads a reply of at most relayMaxBulk bytes a string,
+// relayMaxArray items an array and relayMaxDepth arrays deep; a line longer
+// than r's buffer is refused too.
+func readReply(r *bufio.Reader) (any, error) {
+	return readReplyDepth(r, 0)
+}
+
+func readReplyDepth(r *bufio.Reader, depth int) (any, error) {
+	b, err := r.ReadSlice('\n')
+	if err != nil {
+		return nil, err
+	}
+	line := strings.TrimSuffix(string(b), "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, errors.New("redis: " + line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		if n > relayMaxBulk {
+			return nil, fmt.Errorf("redis: %d byte reply is over the %d byte limit", n, relayMaxBulk)
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		if n > relayMaxArray || depth >= relayMaxDepth {
+			return nil, fmt.Errorf("redis: %d item reply at depth %d is over the limit", n, depth)
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = readReplyDepth(r, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
@@ -0,0 +1,22 @@
+package server
+
+import (
+	"net/http"
+
+	"simstack/internal/logging"
+)
+
+// withRequestID gives every request an ID, the client's X-Request-ID when
+// it is usable and a new one otherwise, which is sent back as X-Request-ID
+// and carried in the request's context into logs and the events of any run
+// it starts.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !logging.ValidRequestID(id) {
+			id = logging.NewRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+	})
+}
@@ -0,0 +1,269 @@
+package server
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"simstack/internal/orchestrator"
+	"simstack/internal/types"
+)
+
+// errQueueFull is returned by reserve when every slot is taken and the queue
+// is as long as it may get.
+var errQueueFull = errors.New("too many runs waiting; try again later")
+
+// Retry-After when no run has finished yet to go by
+const defaultRetryAfter = 30 * time.Second
+
+// How many finished runs the Retry-After estimate averages
+const retryHistory = 10
+
+// scheduler bounds the runs in flight, queueing the rest in arrival order.
+// A run is reserved first, so its place is held while the request is
+// checked further, and given its work with start. A nil scheduler starts
+// everything at once.
+type scheduler struct {
+	max      int
+	maxQueue int
+	// Receives queued and started events
+	publish func(any)
+
+	mu sync.Mutex
+	// Slots held, by runs in flight and by reservations given a slot but
+	// not yet their work
+	running int
+	queue   []*ticket
+	tickets map[string]*ticket
+	// Durations of the last runs to finish, oldest first
+	recent []time.Duration
+	// Set by close; reserve refuses everything from then on
+	closed bool
+}
+
+type ticket struct {
+	id string
+	// The goal of the run, once given its work
+	goal string
+	// Given a func that frees the run's slot, which the run may call before
+	// it returns
+	run      func(release func())
+	slot     bool
+	reserved time.Time
+}
+
+func newScheduler(max, maxQueue int, publish func(any)) *scheduler {
+	return &scheduler{max: max, maxQueue: maxQueue, publish: publish, tickets: map[string]*ticket{}}
+}
+
+// reserve holds a place for run id: a slot, giving position 0, or the
+// given place in the queue, 1 being next. It fails with errQueueFull when
+// the queue is full, and with orchestrator.ErrShuttingDown once closed.
+func (s *scheduler) reserve(id string) (int, error) {
+	if s == nil {
+		return 0, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return 0, orchestrator.ErrShuttingDown
+	}
+	t := &ticket{id: id, reserved: time.Now()}
+	if s.running < s.max {
+		s.running++
+		t.slot = true
+		s.tickets[id] = t
+		return 0, nil
+	}
+	if len(s.queue) >= s.maxQueue {
+		return 0, errQueueFull
+	}
+	s.queue = append(s.queue, t)
+	s.tickets[id] = t
+	return len(s.queue), nil
+}
+
+// start gives reserved run id, which pursues goal, its work, which runs now
+// if the run has a slot and once one frees up otherwise. A queued run is announced with a
+// queued event. run's slot is freed when it returns, or sooner if it calls
+// the release func it is given.
+func (s *scheduler) start(id, goal string, run func(release func())) {
+	if s == nil {
+		go run(func() {})
+		return
+	}
+	s.mu.Lock()
+	t := s.tickets[id]
+	if t == nil {
+		// Released, i.e. cancelled, in the meantime
+		s.mu.Unlock()
+		return
+	}
+	t.run, t.goal = run, goal
+	if t.slot {
+		s.launch(t)
+		s.mu.Unlock()
+		return
+	}
+	pos := s.position(id)
+	s.mu.Unlock()
+	s.emit(types.EventQueued, types.QueuedEvent{RunID: id, Position: pos}, id)
+}
+
+// release gives up run id's place if it hasn't started, reporting whether
+// it had one: a run cancelled while queued, or a reservation its request
+// turned out not to need.
+func (s *scheduler) release(id string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	t := s.tickets[id]
+	if t == nil {
+		s.mu.Unlock()
+		return false
+	}
+	delete(s.tickets, id)
+	if t.slot {
+		s.running--
+	} else {
+		for i, q := range s.queue {
+			if q == t {
+				s.queue = append(s.queue[:i], s.queue[i+1:]...)
+				break
+			}
+		}
+	}
+	moved := s.promote()
+	s.mu.Unlock()
+	s.announce(moved)
+	return true
+}
+
+// droppedRun is a run close dropped before it started.
+type droppedRun struct {
+	id, goal string
+}
+
+// close refuses runs from now on and drops every run not yet started,
+// returning them in ID order. Runs in flight carry on.
+func (s *scheduler) close() []droppedRun {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	dropped := make([]droppedRun, 0, len(s.tickets))
+	for id, t := range s.tickets {
+		if t.slot {
+			s.running--
+		}
+		dropped = append(dropped, droppedRun{id: id, goal: t.goal})
+	}
+	sort.Slice(dropped, func(i, j int) bool { return dropped[i].id < dropped[j].id })
+	s.tickets = map[string]*ticket{}
+	s.queue = nil
+	return dropped
+}
+
+// queued reports run id's place in the queue, or 0 if it isn't waiting.
+func (s *scheduler) queued(id string) int {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.position(id)
+}
+
+// retryAfter estimates how long until the queue has room: the time for
+// one of the runs in flight to finish, going by recent runs.
+func (s *scheduler) retryAfter() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.recent) == 0 {
+		return defaultRetryAfter
+	}
+	var total time.Duration
+	for _, d := range s.recent {
+		total += d
+	}
+	wait := total / time.Duration(len(s.recent)*s.max)
+	return max(wait, time.Second)
+}
+
+// position is id's place in the queue, 1-based, or 0. Callers hold mu.
+func (s *scheduler) position(id string) int {
+	for i, t := range s.queue {
+		if t.id == id {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// launch runs t, which holds a slot, in the background. Callers hold mu.
+func (s *scheduler) launch(t *ticket) {
+	delete(s.tickets, t.id)
+	waited := time.Since(t.reserved)
+	go func() {
+		s.emit(types.EventStarted, types.StartedEvent{RunID: t.id, QueuedMs: waited.Milliseconds()}, t.id)
+		began := time.Now()
+		release := sync.OnceFunc(func() { s.finish(time.Since(began)) })
+		t.run(release)
+		release()
+	}()
+}
+
+// finish frees the slot of a run that took took and starts the next.
+func (s *scheduler) finish(took time.Duration) {
+	s.mu.Lock()
+	s.running--
+	s.recent = append(s.recent, took)
+	if len(s.recent) > retryHistory {
+		s.recent = s.recent[1:]
+	}
+	moved := s.promote()
+	s.mu.Unlock()
+	s.announce(moved)
+}
+
+// promote hands free slots to the head of the queue, launching runs that
+// have their work, and returns the runs still waiting, whose places have
+// moved. Callers hold mu.
+func (s *scheduler) promote() []types.QueuedEvent {
+	for s.running < s.max && len(s.queue) > 0 {
+		t := s.queue[0]
+		s.queue = s.queue[1:]
+		s.running++
+		t.slot = true
+		if t.run != nil {
+			s.launch(t)
+		}
+	}
+	var moved []types.QueuedEvent
+	for i, t := range s.queue {
+		if t.run != nil {
+			moved = append(moved, types.QueuedEvent{RunID: t.id, Position: i + 1})
+		}
+	}
+	return moved
+}
+
+// announce sends each run's new place in the queue.
+func (s *scheduler) announce(moved []types.QueuedEvent) {
+	for _, q := range moved {
+		s.emit(types.EventQueued, q, q.RunID)
+	}
+}
+
+func (s *scheduler) emit(typ string, payload any, runID string) {
+	if s.publish == nil {
+		return
+	}
+	ev := types.NewEvent(typ, payload)
+	ev.RunID = runID
+	s.publish(ev)
+}
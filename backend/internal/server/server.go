@@ -1,50 +1,217 @@
 package server
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"simstack/internal/archive"
+	"simstack/internal/artifacts"
+	"simstack/internal/config"
+	"simstack/internal/eventbus"
+	"simstack/internal/logging"
 	"simstack/internal/orchestrator"
+	"simstack/internal/prometheus"
+	"simstack/internal/ratelimit"
+	"simstack/internal/runstore"
+	"simstack/internal/schema"
 	"simstack/internal/types"
+	"simstack/internal/version"
+	"simstack/internal/webui"
 )
 
 type Server struct {
 	Router *http.ServeMux
 	hub    *Hub
-	orch   *orchestrator.Engine
+	// Carries the engine's events to the hub and any other sink
+	bus  *eventbus.Bus
+	orch *orchestrator.Engine
+	// Collects the engine's instrumentation for GET /metrics
+	prom *prometheus.Sink
+	// Bounds the runs in flight (SIMSTACK_MAX_RUNS); nil starts every run
+	// at once
+	runs *scheduler
+	// Limits how often each client may start runs and exports
+	// (SIMSTACK_RATE_LIMIT_RPM); nil doesn't limit them
+	limiter ratelimit.Limiter
+
+	// Reject unknown top-level request fields instead of warning
+	// (SIMSTACK_STRICT_REQUESTS)
+	strictRequests bool
+	// Largest run, replay or export body taken (SIMSTACK_MAX_BODY_BYTES);
+	// 0 takes any
+	maxBody int64
+	// Leave /api and /ws open whatever the keys (SIMSTACK_AUTH_DISABLED)
+	authDisabled bool
+
+	// Reads the configuration again for Reload; config.Load outside tests
+	loadConfig func() (config.Config, error)
+
+	// Stops the Redis relay (SIMSTACK_REDIS_URL) and the engine's watch on
+	// the shared run store at shutdown
+	stopBackground context.CancelFunc
+
+	// Set once shutdown begins, failing readiness
+	unready atomic.Bool
 }
 
-func NewServer() *Server {
+// probeTimeout bounds each check behind /livez and /readyz, so a stuck
+// dependency fails the probe rather than hanging it.
+const probeTimeout = time.Second
+
+// NewServer wires the hub, engine and routes from cfg, normally the
+// validated result of config.Load. opts add to the engine's configuration,
+// e.g. a persistent run store.
+func NewServer(cfg config.Config, opts ...orchestrator.Option) *Server {
 	mux := http.NewServeMux()
-	hub := NewHub()
+	bus := eventbus.New()
+	hub := NewHub(cfg.CORSOrigins...)
 	go hub.run()
+	background, stopBackground := context.WithCancel(context.Background())
+	if cfg.RedisURL != "" {
+		relay, err := newRelay(cfg.RedisURL, hub)
+		if err != nil {
+			slog.Error("relay: SIMSTACK_REDIS_URL", "err", err)
+		} else {
+			hub.relay = relay
+			go relay.run(background)
+		}
+	}
+	bus.Subscribe("websocket", hubQueue, hub.broadcastJSON)
+	prom := prometheus.New()
 
 	s := &Server{
 		Router: mux,
 		hub:    hub,
-		orch:   orchestrator.NewEngine(hub.broadcastJSON),
+		bus:    bus,
+		orch:   orchestrator.NewEngine(bus, append([]orchestrator.Option{orchestrator.WithConfig(cfg), orchestrator.WithMetricsSink(prom)}, opts...)...),
+		prom:   prom,
+
+		strictRequests: cfg.StrictRequests,
+		maxBody:        cfg.MaxBodyBytes,
+		authDisabled:   cfg.AuthDisabled,
+		loadConfig:     config.Load,
+		stopBackground: stopBackground,
+	}
+	go s.orch.WatchCompletions(background)
+	switch {
+	case cfg.AuthDisabled:
+		slog.Warn("auth: disabled by SIMSTACK_AUTH_DISABLED; /api and /ws are open")
+	case len(cfg.APIKeys) == 0:
+		slog.Warn("auth: no SIMSTACK_API_KEYS; /api and /ws are open to anyone who can reach the server", "addr", cfg.Addr)
+	}
+
+	hub.hello = func() types.WSEvent {
+		return types.NewEvent(types.EventHello, types.HelloEvent{BuildInfo: s.buildInfo(), ServerTime: time.Now().UTC()})
+	}
+	prom.Gauge("ws_clients", "WebSocket clients connected.", func() float64 { return float64(hub.Clients()) })
+	prom.Gauge("runs_active", "Runs in flight.", func() float64 { return float64(s.orch.InFlight()) })
+
+	if cfg.MaxRuns > 0 {
+		s.runs = newScheduler(cfg.MaxRuns, cfg.RunQueueDepth, bus.Publish)
+	}
+	if cfg.RateLimitRPM > 0 {
+		s.limiter = ratelimit.NewMemory(cfg.RateLimitRPM, cfg.RateLimitBurst)
 	}
 
 	mux.HandleFunc("/healthz", s.handleHealth)
+	mux.HandleFunc("GET /livez", s.handleHealth)
+	mux.HandleFunc("GET /readyz", s.handleReady)
+	mux.HandleFunc("GET /healthz/deep", s.handleDeepHealth)
 	mux.HandleFunc("/ws", s.handleWS)
-	mux.HandleFunc("/api/run", s.handleRun)
-	mux.HandleFunc("/api/export", s.handleExport)
-	mux.HandleFunc("/metrics", s.handleMetrics)
+	mux.HandleFunc("/api/run", s.limited(s.handleRun))
+	mux.HandleFunc("POST /api/replay", s.limited(s.handleReplay))
+	mux.HandleFunc("/api/export", s.limited(s.handleExport))
+	mux.HandleFunc("/api/runs", s.handleRuns)
+	mux.HandleFunc("GET /api/runs/{id}", s.handleGetRun)
+	mux.HandleFunc("POST /api/run/{id}/cancel", s.handleCancelRun)
+	mux.HandleFunc("GET /api/run/{id}/status", s.handleRunStatus)
+	mux.HandleFunc("GET /api/run/{id}/results", s.handleRunResults)
+	mux.HandleFunc("GET /api/runs/{id}/llm-calls", s.handleRunLLMCalls)
+	mux.HandleFunc("GET /api/runs/{id}/annotations", s.handleAnnotations)
+	mux.HandleFunc("POST /api/runs/{id}/annotations", s.handleAnnotate)
+	mux.HandleFunc("DELETE /api/runs/{id}/annotations/{annotation}", s.handleDeleteAnnotation)
+	mux.HandleFunc("GET /api/runs/{id}/artifacts/{variant}/{name}", s.handleArtifact)
+	mux.HandleFunc("GET /api/runs/{id}/metrics", s.handleRunMetrics)
+	mux.HandleFunc("GET /api/runs/{id}/grafana", s.handleRunGrafana)
+	mux.HandleFunc("GET /api/runs/{id}/results.ndjson", s.handleRunResultsNDJSON)
+	mux.HandleFunc("GET /api/compare/{a}/{b}/narrative", s.limited(s.handleCompareNarrative))
+	mux.HandleFunc("GET /api/admin/export", s.handleAdminExport)
+	mux.HandleFunc("POST /api/admin/import", s.handleAdminImport)
+	mux.HandleFunc("POST /api/admin/reload", s.handleAdminReload)
+	mux.HandleFunc("GET /api/config", s.handleConfig)
+	mux.HandleFunc("/api/models", s.handleModels)
+	mux.HandleFunc("GET /api/schemas/run-request.json", s.handleRunRequestSchema)
+	mux.HandleFunc("GET /api/openapi.json", s.handleOpenAPI)
+	mux.HandleFunc("GET /api/routes", s.handleRoutes)
+	mux.HandleFunc("GET /api/version", s.handleVersion)
+	mux.HandleFunc("GET /api/metrics", s.handleMetrics)
+	mux.HandleFunc("GET /metrics", s.prom.Handler().ServeHTTP)
+	mux.HandleFunc("GET /api/simulators", s.handleSimulators)
+	// Every more specific route above takes precedence over the UI
+	mux.Handle("/", webui.Handler())
 
-	// CORS for local dev: wrap mux
+	// CORS outside the key check, so that preflights, which carry no key,
+	// are answered, and browsers can read a 401; every response, a 401
+	// included, has a request ID. Compression inside CORS never sees a
+	// preflight, and keeps CORS's Vary: Origin beside its own
 	s.Router = http.NewServeMux()
-	s.Router.Handle("/", withCORS(mux))
+	s.Router.Handle("/", withRequestID(withCORS(withCompression(requireKey(mux, s.apiKeys)), hub.allowedOrigins)))
 	return s
 }
 
-func withCORS(next http.Handler) http.Handler {
+// apiKeys returns the keys /api and /ws accept, none when they are open.
+func (s *Server) apiKeys() []string {
+	if s.authDisabled {
+		return nil
+	}
+	return s.orch.Config().APIKeys
+}
+
+// withCORS answers cross-origin requests from the origins returned for
+// each request, echoing the caller's origin so that credentialed fetches
+// work, and preflights for whatever method and headers they ask for.
+// Other origins get no CORS headers at all, so browsers refuse them.
+func withCORS(next http.Handler, allowed func() []string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		origins := allowed()
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if !contains(origins, "*") {
+			w.Header().Add("Vary", "Origin")
+		}
+		switch {
+		case origin == "" || !originAllowed(origins, origin):
+		case contains(origins, "*"):
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		default:
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+		if !preflight && w.Header().Get("Access-Control-Allow-Origin") != "" {
+			w.Header().Set("Access-Control-Expose-Headers", exposedHeaders)
+		}
+		if preflight {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			if w.Header().Get("Access-Control-Allow-Origin") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", r.Header.Get("Access-Control-Request-Method"))
+				if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+					w.Header().Set("Access-Control-Allow-Headers", headers)
+				}
+				w.Header().Set("Access-Control-Max-Age", "600")
+			}
+		}
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusNoContent)
 			return
@@ -53,62 +220,916 @@ func withCORS(next http.Handler) http.Handler {
 	})
 }
 
+// Response headers cross-origin scripts may read besides the safelisted ones
+const exposedHeaders = "Retry-After, Idempotent-Replayed, X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset, X-Request-ID"
+
+// originAllowed reports whether origin is one of allowed: "*", an exact
+// origin, or a "*.example.com" pattern, optionally with a scheme, matching
+// any subdomain.
+func originAllowed(allowed []string, origin string) bool {
+	for _, a := range allowed {
+		if a == "*" || a == origin {
+			return true
+		}
+		scheme, pattern, found := strings.Cut(a, "://")
+		if !found {
+			scheme, pattern = "", a
+		}
+		domain, ok := strings.CutPrefix(pattern, "*")
+		if !ok || !strings.HasPrefix(domain, ".") {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || u.Host == "" || (scheme != "" && u.Scheme != scheme) {
+			continue
+		}
+		if strings.HasSuffix(u.Host, domain) && len(u.Host) > len(domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reload reads the configuration again and applies what can change while
+// the server runs: simulator URLs and timeouts, LLM rate and concurrency
+// limits, CORS and WebSocket origins, API keys, and export limits. The report lists
+// every changed setting, including those that need a restart. An invalid
+// configuration changes nothing.
+func (s *Server) Reload() (config.Report, error) {
+	cfg, err := s.loadConfig()
+	if err != nil {
+		return config.Report{}, err
+	}
+	report := s.orch.Reload(cfg)
+	s.hub.SetOrigins(s.orch.Config().CORSOrigins...)
+	return report, nil
+}
+
+// Shutdown winds the server down before the process exits. It tells the
+// WebSocket clients, drops the queued runs and waits for the runs in
+// flight, which start no more variants, until ctx is done; runs still
+// going then are canceled. Last it closes the clients' connections, once
+// they have the runs' final events.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.Unready()
+	var grace time.Duration
+	if deadline, ok := ctx.Deadline(); ok {
+		grace = time.Until(deadline)
+	}
+	s.bus.Publish(types.NewEvent(types.EventShutdown, types.ShutdownEvent{GraceMs: grace.Milliseconds(), Runs: s.orch.InFlight()}))
+	// Runs that never left the queue are kept as cancelled, so they don't
+	// vanish from the history
+	for _, run := range s.runs.close() {
+		if err := s.orch.SaveUnstarted(context.WithoutCancel(ctx), run.id, run.goal); err != nil {
+			slog.ErrorContext(ctx, "saving a queued run dropped at shutdown", "run_id", run.id, "err", err)
+		}
+		ev := types.NewEvent(types.EventCancelled, types.CancelledEvent{RunID: run.id})
+		ev.RunID = run.id
+		s.bus.Publish(ev)
+	}
+	err := s.orch.Drain(ctx)
+
+	// The runs' last events may still be on their way to the clients
+	closeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), closeWait)
+	defer cancel()
+	_ = s.bus.Flush(closeCtx)
+	s.hub.shutdown(closeCtx)
+	if s.stopBackground != nil {
+		s.stopBackground()
+	}
+	return err
+}
+
+// Unready fails /readyz from now on, so that load balancers stop sending
+// requests before the listener closes.
+func (s *Server) Unready() {
+	s.unready.Store(true)
+}
+
+// PollSimulators probes the simulators' health until ctx is done.
+func (s *Server) PollSimulators(ctx context.Context) {
+	s.orch.PollSimulators(ctx)
+}
+
+// CheckModel validates the configured model against the provider's list.
+func (s *Server) CheckModel(ctx context.Context, strict bool) error {
+	return s.orch.CheckModel(ctx, strict)
+}
+
+// handleHealth is the liveness probe: the process is up and the event hub
+// answers. A hub that stopped would leave every client without events, and
+// only a restart brings it back.
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), probeTimeout)
+	defer cancel()
+	if !s.hub.Alive(ctx) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("event hub not answering"))
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 	_, _ = w.Write([]byte("ok"))
 }
 
+// buildInfo is the running build and the features the current
+// configuration turns on.
+func (s *Server) buildInfo() types.BuildInfo {
+	cfg := s.orch.Config()
+	return types.BuildInfo{
+		Build:    version.Get(),
+		Features: types.Features{Auth: len(s.apiKeys()) > 0, RunStore: cfg.RunStore, Offline: cfg.Offline},
+	}
+}
+
+// handleVersion reports the running build, so operations can tell which
+// one is deployed.
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(s.buildInfo())
+}
+
 func (s *Server) handleWS(w http.ResponseWriter, r *http.Request) {
 	serveWS(s.hub, w, r)
 }
 
 func (s *Server) handleRun(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	body, ok := s.readBody(w, r)
+	if !ok {
+		return
+	}
+	var err error
+	// YAML comes from scenario files, so a misspelled field is always an
+	// error rather than a warning
+	strict := s.strictRequests
+	var doc yamlDoc
+	if isYAML(r) {
+		if body, doc, err = decodeYAML(body); err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "invalid yaml: "+err.Error())
+			return
+		}
+		strict = true
+	}
+	warnings, err := schema.ValidateRunRequest(body, strict)
+	var invalid *schema.ValidationError
+	if errors.As(err, &invalid) {
+		doc.locateErrors(invalid)
+		writeErrorDetails(w, http.StatusBadRequest, codeInvalidRequest, invalid.Error(), invalid.Errors)
 		return
 	}
+	for _, warning := range warnings {
+		slog.WarnContext(r.Context(), "run request", "warning", warning)
+	}
 	var req types.RunRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid json", http.StatusBadRequest)
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "invalid json")
+		return
+	}
+	if err := s.orch.ValidateRequest(r.Context(), req); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+	// A retried request gets the run its first attempt started, even while
+	// the queue is full
+	key := r.Header.Get("Idempotency-Key")
+	if key != "" {
+		first, err := s.orch.IdempotentRun(r.Context(), key, body)
+		if !idempotentReplay(w, first, "", err) {
+			return
+		}
+	}
+	runID := orchestrator.NewRunID()
+	position, ok := s.reserve(w, runID)
+	if !ok {
 		return
 	}
-	go func() {
+	// Claimed only now, so a run refused a place holds no key; a retry
+	// racing this one may have claimed it in between
+	if key != "" {
+		first, err := s.orch.ClaimIdempotencyKey(r.Context(), key, body, runID)
+		if err != nil || first != runID {
+			s.runs.release(runID)
+		}
+		if !idempotentReplay(w, first, runID, err) {
+			return
+		}
+	}
+	s.start(r.Context(), runID, req.Goal, func(ctx context.Context) error {
+		return s.orch.RunWithID(ctx, runID, req)
+	})
+	w.Header().Set("Content-Type", "application/json")
+	resp := startedResponse(runID, position)
+	resp.Warnings = warnings
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// idempotentReplay answers a request whose Idempotency-Key names run first,
+// an earlier run than runID, or failed with err, and reports whether the
+// request should go on to start runID.
+func idempotentReplay(w http.ResponseWriter, first, runID string, err error) bool {
+	switch {
+	case errors.Is(err, orchestrator.ErrInvalidRequest):
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return false
+	case errors.Is(err, orchestrator.ErrIdempotencyConflict):
+		writeError(w, http.StatusConflict, codeIdempotencyConflict, err.Error())
+		return false
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return false
+	case first != runID:
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Idempotent-Replayed", "true")
+		_ = json.NewEncoder(w).Encode(runStarted{Status: "started", RunID: first})
+		return false
+	}
+	return true
+}
+
+// readBody reads r's body, up to s.maxBody bytes. A longer body is
+// answered 413 and an unreadable one 400, and readBody reports false.
+func (s *Server) readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	if s.maxBody > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, s.maxBody)
+	}
+	body, err := io.ReadAll(r.Body)
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		writeError(w, http.StatusRequestEntityTooLarge, codeBodyTooLarge, "request body larger than "+strconv.FormatInt(tooLarge.Limit, 10)+" bytes")
+		return nil, false
+	case err != nil:
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "unreadable body: "+err.Error())
+		return nil, false
+	}
+	return body, true
+}
+
+// reserve holds a place for run runID among the runs in flight or queued,
+// returning its place in the queue (0 = starts now). When the queue is
+// full it answers 429 with a Retry-After hint, and 503 while shutting
+// down, and reports false.
+func (s *Server) reserve(w http.ResponseWriter, runID string) (int, bool) {
+	position, err := s.runs.reserve(runID)
+	if errors.Is(err, orchestrator.ErrShuttingDown) {
+		writeError(w, http.StatusServiceUnavailable, codeShuttingDown, err.Error())
+		return 0, false
+	}
+	if errors.Is(err, errQueueFull) {
+		wait := s.runs.retryAfter()
+		w.Header().Set("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+		writeError(w, http.StatusTooManyRequests, codeQueueFull, err.Error())
+		return 0, false
+	}
+	return position, true
+}
+
+// startedResponse is the answer to a request that started, or queued, run
+// runID.
+func startedResponse(runID string, position int) runStarted {
+	if position > 0 {
+		return runStarted{Status: "queued", RunID: runID, Position: position}
+	}
+	return runStarted{Status: "started", RunID: runID}
+}
+
+// start runs run, as run runID, in the background once the scheduler gives
+// it a slot; runID must be reserved. reqCtx is the context of the request
+// starting it, whose request ID the run keeps. A run that fails outright is
+// reported to its watchers as an error event. A run the watchdog fails gives
+// up its slot then, without waiting for it to return.
+func (s *Server) start(reqCtx context.Context, runID, goal string, run func(ctx context.Context) error) {
+	s.runs.start(runID, goal, func(release func()) {
 		// Use background context with generous timeout so it doesn't get canceled when HTTP response is sent
-		// This timeout should be longer than all internal operation timeouts combined
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		// The configured timeout is longer than all internal operation timeouts combined
+		ctx, cancel := context.WithTimeout(logging.Detach(reqCtx), s.orch.Config().RunTimeout)
 		defer cancel()
+		ctx = orchestrator.WithRelease(ctx, release)
+
+		if err := run(ctx); errors.Is(err, orchestrator.ErrRunStalled) {
+			slog.WarnContext(ctx, "run returned after the watchdog failed it", "run_id", runID)
+		} else if errors.Is(err, orchestrator.ErrRunCanceled) {
+			slog.InfoContext(ctx, "run canceled", "run_id", runID)
+		} else if err != nil {
+			slog.ErrorContext(ctx, "run error", "run_id", runID, "err", err)
+			ev := types.NewEvent(types.EventError, types.ErrorEvent{Error: err.Error()})
+			ev.RunID = runID
+			ev.RequestID = logging.RequestID(ctx)
+			s.bus.Publish(ev)
+		}
+	})
+}
 
-		if err := s.orch.Run(ctx, req); err != nil {
-			log.Printf("run error: %v", err)
-			s.hub.broadcastJSON(types.WSEvent{Type: "error", Payload: map[string]any{"error": err.Error()}, Timestamp: time.Now().UTC().Format(time.RFC3339Nano)})
+// handleReplay runs a plan again without planning: a stored run's, named by
+// run_id, or one given inline as plan or as the whole body.
+func (s *Server) handleReplay(w http.ResponseWriter, r *http.Request) {
+	body, ok := s.readBody(w, r)
+	if !ok {
+		return
+	}
+	var req types.ReplayRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "invalid json")
+		return
+	}
+	if req.RunID == "" && req.Plan == nil {
+		var plan types.SimulationPlan
+		if json.Unmarshal(body, &plan) == nil && plan.Variants != nil {
+			req.Plan = &plan
 		}
-	}()
+	}
+	replay, err := s.orch.PrepareReplay(r.Context(), req)
+	switch {
+	case errors.Is(err, runstore.ErrNotFound):
+		writeError(w, http.StatusNotFound, codeRunNotFound, "run not found")
+		return
+	case errors.Is(err, orchestrator.ErrInvalidRequest):
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	runID := orchestrator.NewRunID()
+	position, ok := s.reserve(w, runID)
+	if !ok {
+		return
+	}
+	s.start(r.Context(), runID, replay.Request.Goal, func(ctx context.Context) error {
+		return s.orch.ReplayWithID(ctx, runID, replay)
+	})
 	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(map[string]string{"status": "started"})
+	resp := startedResponse(runID, position)
+	resp.PlanID, resp.ReplayOf = replay.Plan.PlanID, replay.ReplayOf
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) handleRunRequestSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/schema+json")
+	_, _ = w.Write(schema.RunRequest.JSON())
 }
 
 func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	body, ok := s.readBody(w, r)
+	if !ok {
 		return
 	}
 	var req types.ExportRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid json", http.StatusBadRequest)
+	if isYAML(r) {
+		body, doc, err := decodeYAML(body)
+		if err == nil {
+			err = doc.decodeStrict(body, &req)
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "invalid yaml: "+err.Error())
+			return
+		}
+	} else if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "invalid json")
+		return
+	}
+	if f := r.URL.Query().Get("format"); f != "" {
+		req.Format = f
+	}
+	if req.Format == "" {
+		req.Format = negotiateExport(r.Header.Get("Accept"))
+	}
+	file, err := s.orch.Export(r.Context(), req)
+	switch {
+	case errors.Is(err, orchestrator.ErrInvalidRequest):
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	case errors.Is(err, runstore.ErrNotFound):
+		writeError(w, http.StatusNotFound, codeRunNotFound, "run not found")
+		return
+	case errors.Is(err, orchestrator.ErrNoWinner):
+		writeError(w, http.StatusConflict, codeNoWinner, err.Error())
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", file.ContentType)
+	w.Header().Set("Content-Disposition", "attachment; filename="+file.Filename)
+	_, _ = w.Write(file.Content)
+}
+
+// exportMediaTypes are the Accept types that choose an export format.
+var exportMediaTypes = map[string]string{
+	"application/x-yaml": orchestrator.FormatCompose,
+	"application/yaml":   orchestrator.FormatCompose,
+	"text/yaml":          orchestrator.FormatCompose,
+	"text/plain":         orchestrator.FormatEnv,
+	"application/json":   orchestrator.FormatJSONParams,
+	"application/x-sh":   orchestrator.FormatScript,
+	"text/x-shellscript": orchestrator.FormatScript,
+}
+
+// negotiateExport picks the export format an Accept header prefers,
+// compose when it names none of them.
+func negotiateExport(accept string) string {
+	best, bestQ := orchestrator.FormatCompose, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if format, ok := exportMediaTypes[mediaType]; ok && q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+	return best
+}
+
+func (s *Server) handleRuns(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	q := r.URL.Query()
+	var page [2]int
+	for i, name := range []string{"limit", "offset"} {
+		if v := q.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, name+" must be a non-negative integer")
+				return
+			}
+			page[i] = n
+		}
+	}
+	limit, offset := page[0], page[1]
+
+	var out any
+	var err error
+	if id := q.Get("similar_to"); id != "" {
+		out, err = s.orch.SimilarRuns(r.Context(), id, limit)
+	} else {
+		out, err = s.orch.Runs(r.Context(), runstore.ListOptions{Limit: limit, Offset: offset, Status: q.Get("status")})
+	}
+	if errors.Is(err, runstore.ErrNotFound) {
+		writeError(w, http.StatusNotFound, codeRunNotFound, "run not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+// handleGetRun returns a run's record: its status while in flight, and the
+// plan, results and analysis once finished.
+func (s *Server) handleGetRun(w http.ResponseWriter, r *http.Request) {
+	run, err := s.orch.GetRun(r.Context(), r.PathValue("id"))
+	if errors.Is(err, runstore.ErrNotFound) {
+		writeError(w, http.StatusNotFound, codeRunNotFound, "run not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(run)
+}
+
+// handleCancelRun stops an in-flight run, or drops a queued one; finished
+// runs answer 409.
+func (s *Server) handleCancelRun(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if s.runs.release(id) {
+		ev := types.NewEvent(types.EventCancelled, types.CancelledEvent{RunID: id})
+		ev.RunID = id
+		s.bus.Publish(ev)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(runCancel{Status: "cancelled", RunID: id})
+		return
+	}
+	if s.orch.Cancel(id) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(runCancel{Status: "cancelling", RunID: id})
+		return
+	}
+	if _, err := s.orch.GetRun(r.Context(), id); err == nil {
+		writeError(w, http.StatusConflict, codeRunFinished, "run already finished")
+		return
+	}
+	writeError(w, http.StatusNotFound, codeRunNotFound, "run not found")
+}
+
+// handleRunStatus reports a run's phase and progress, for clients polling
+// instead of holding a WebSocket open.
+func (s *Server) handleRunStatus(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	progress, err := s.orch.RunProgress(r.Context(), id)
+	if position := s.runs.queued(id); position > 0 {
+		progress, err = types.RunProgress{RunID: id, Phase: types.PhaseQueued, QueuePosition: position}, nil
+	}
+	if errors.Is(err, runstore.ErrNotFound) {
+		writeError(w, http.StatusNotFound, codeRunNotFound, "run not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(progress)
+}
+
+// handleRunResults returns a run's plan, results and analysis in one
+// document; a run in flight gives the results so far, not complete.
+func (s *Server) handleRunResults(w http.ResponseWriter, r *http.Request) {
+	results, err := s.orch.RunResults(r.Context(), r.PathValue("id"))
+	if errors.Is(err, runstore.ErrNotFound) {
+		writeError(w, http.StatusNotFound, codeRunNotFound, "run not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !results.Complete {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+	_ = json.NewEncoder(w).Encode(results)
+}
+
+// handleRunResultsNDJSON streams a run's results one JSON object per line,
+// flushing each; follow=true keeps an active run's stream open until it is
+// done.
+func (s *Server) handleRunResultsNDJSON(w http.ResponseWriter, r *http.Request) {
+	var follow bool
+	if v := r.URL.Query().Get("follow"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "follow must be true or false")
+			return
+		}
+		follow = b
+	}
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	started := false
+	err := s.orch.StreamResults(r.Context(), r.PathValue("id"), follow, func(line types.ResultsLine) error {
+		if !started {
+			started = true
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+		if err := enc.Encode(line); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	switch {
+	case err == nil:
+	case !started && errors.Is(err, runstore.ErrNotFound):
+		writeError(w, http.StatusNotFound, codeRunNotFound, "run not found")
+	case !started:
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+	case r.Context().Err() == nil:
+		// The status is already sent; the missing summary line tells the
+		// client the stream was cut short
+		slog.WarnContext(r.Context(), "results stream stopped", "run_id", r.PathValue("id"), "err", err)
+	}
+}
+
+// handleRunGrafana returns a Grafana dashboard of the run, ready to import.
+func (s *Server) handleRunGrafana(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	dashboard, err := s.orch.GrafanaDashboard(r.Context(), id)
+	if errors.Is(err, runstore.ErrNotFound) {
+		writeError(w, http.StatusNotFound, codeRunNotFound, "run not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", "attachment; filename=simstack-"+artifacts.SanitizeName(id)+"-grafana.json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(dashboard)
+}
+
+// handleCompareNarrative describes in Markdown how run b differs from run a,
+// with the numeric comparison attached.
+func (s *Server) handleCompareNarrative(w http.ResponseWriter, r *http.Request) {
+	n, err := s.orch.CompareNarrative(r.Context(), r.PathValue("a"), r.PathValue("b"))
+	if errors.Is(err, runstore.ErrNotFound) {
+		writeError(w, http.StatusNotFound, codeRunNotFound, "run not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(n)
+}
+
+func (s *Server) handleRunLLMCalls(w http.ResponseWriter, r *http.Request) {
+	calls, err := s.orch.LLMCalls(r.Context(), r.PathValue("id"))
+	if errors.Is(err, runstore.ErrNotFound) {
+		writeError(w, http.StatusNotFound, codeRunNotFound, "run not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(llmCallsResponse{LLMCalls: calls})
+}
+
+func (s *Server) handleAnnotations(w http.ResponseWriter, r *http.Request) {
+	annotations, err := s.orch.Annotations(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeAnnotationError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(annotationsResponse{Annotations: annotations})
+}
+
+// handleAnnotate adds a note to a run, answering 201 with it as stored.
+func (s *Server) handleAnnotate(w http.ResponseWriter, r *http.Request) {
+	body, ok := s.readBody(w, r)
+	if !ok {
+		return
+	}
+	var req annotationRequest
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "invalid json: "+err.Error())
 		return
 	}
-	yml, filename, err := s.orch.ExportCompose(r.Context(), req)
+	a, err := s.orch.Annotate(r.Context(), r.PathValue("id"), types.Annotation{
+		Author: req.Author, Owner: s.caller(r), Text: req.Text, VariantID: req.VariantID, Tags: req.Tags,
+	})
+	if err != nil {
+		writeAnnotationError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(a)
+}
+
+// handleDeleteAnnotation removes a note for the caller who added it, known
+// by the API key the request carries.
+func (s *Server) handleDeleteAnnotation(w http.ResponseWriter, r *http.Request) {
+	err := s.orch.DeleteAnnotation(r.Context(), r.PathValue("id"), r.PathValue("annotation"), s.caller(r))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeAnnotationError(w, err)
 		return
 	}
-	w.Header().Set("Content-Type", "application/x-yaml")
-	w.Header().Set("Content-Disposition", "attachment; filename="+filename)
-	_, _ = w.Write([]byte(yml))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeAnnotationError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, runstore.ErrNotFound):
+		writeError(w, http.StatusNotFound, codeRunNotFound, "run not found")
+	case errors.Is(err, orchestrator.ErrAnnotationNotFound):
+		writeError(w, http.StatusNotFound, codeNotFound, err.Error())
+	case errors.Is(err, orchestrator.ErrNotAuthor):
+		writeError(w, http.StatusForbidden, codeForbidden, err.Error())
+	case errors.Is(err, orchestrator.ErrInvalidAnnotation), errors.Is(err, orchestrator.ErrUnknownVariant):
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+	}
 }
 
+// handleAdminExport streams the run history as ?format=tar (tar.gz, the
+// default, with artifact content if ?artifacts=true) or jsonl, filtered by
+// ?status= and RFC 3339 ?since= and ?until=.
+func (s *Server) handleAdminExport(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	opts := archive.ExportOptions{Format: q.Get("format"), Filter: archive.Filter{Status: q.Get("status")}}
+	if opts.Format == "" {
+		opts.Format = archive.FormatTar
+	}
+	if opts.Format != archive.FormatTar && opts.Format != archive.FormatJSONL {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "format must be tar or jsonl")
+		return
+	}
+	if v := q.Get("artifacts"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil || (b && opts.Format != archive.FormatTar) {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "artifacts must be true or false, and needs format=tar")
+			return
+		}
+		opts.Artifacts = b
+	}
+	for _, f := range []struct {
+		name string
+		into *time.Time
+	}{{"since", &opts.Filter.Since}, {"until", &opts.Filter.Until}} {
+		if v := q.Get(f.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, f.name+" must be an RFC 3339 time")
+				return
+			}
+			*f.into = t
+		}
+	}
+
+	name := "simstack-runs-" + time.Now().UTC().Format("20060102T150405Z")
+	if opts.Format == archive.FormatTar {
+		w.Header().Set("Content-Type", "application/gzip")
+		name += ".tar.gz"
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		name += ".jsonl"
+	}
+	w.Header().Set("Content-Disposition", "attachment; filename="+name)
+	// The status is already sent; a broken archive fails to import
+	if n, err := s.orch.ExportRuns(r.Context(), w, opts); err != nil {
+		slog.WarnContext(r.Context(), "export stopped", "runs", n, "err", err)
+	}
+}
+
+// handleAdminImport reads an archive from the request body. ?on_conflict=
+// skip (default) or remap decides what happens to runs whose ID is taken;
+// ?dry_run=true only reports what would be imported.
+func (s *Server) handleAdminImport(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	opts := archive.ImportOptions{OnConflict: q.Get("on_conflict")}
+	if opts.OnConflict != "" && opts.OnConflict != archive.OnConflictSkip && opts.OnConflict != archive.OnConflictRemap {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "on_conflict must be skip or remap")
+		return
+	}
+	if v := q.Get("dry_run"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "dry_run must be true or false")
+			return
+		}
+		opts.DryRun = b
+	}
+
+	report, err := s.orch.ImportRuns(r.Context(), r.Body, opts)
+	// Runs read before an error stay imported; the report lists them
+	switch {
+	case errors.Is(err, archive.ErrFormat), errors.Is(err, archive.ErrVersion):
+		writeErrorDetails(w, http.StatusBadRequest, codeInvalidRequest, err.Error(), importResponse{Report: report})
+		return
+	case err != nil:
+		writeErrorDetails(w, http.StatusInternalServerError, codeInternal, err.Error(), importResponse{Report: report})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(importResponse{Report: report})
+}
+
+// handleAdminReload is Reload over HTTP, for deployments that can't send
+// SIGHUP. An invalid configuration is a 422 listing its problems.
+func (s *Server) handleAdminReload(w http.ResponseWriter, r *http.Request) {
+	report, err := s.Reload()
+	var cfgErr *config.Error
+	if errors.As(err, &cfgErr) {
+		writeErrorDetails(w, http.StatusUnprocessableEntity, codeInvalidConfig, "invalid configuration", cfgErr.Problems)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, codeInvalidConfig, err.Error())
+		return
+	}
+	slog.InfoContext(r.Context(), "configuration reloaded", "changes", report.String())
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(report)
+}
+
+// configResponse is the configuration in effect, secrets hidden.
+type configResponse struct {
+	Settings []config.Setting `json:"settings"`
+}
+
+// handleConfig shows the settings in effect, wherever each came from, for
+// checking what the environment and config file added up to.
+func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(configResponse{Settings: config.Settings(s.orch.Config())})
+}
+
+func (s *Server) handleModels(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	models, err := s.orch.Models(r.Context())
+	if err != nil {
+		writeError(w, http.StatusBadGateway, codeUpstream, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(modelsResponse{Models: models})
+}
+
+// handleMetrics serves the JSON metrics snapshot the dashboard reads, with
+// the latest ?runs= records of the run history (default 20).
 func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
-	m := s.orch.Metrics()
+	recent := 20
+	if v := r.URL.Query().Get("runs"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "runs must be a positive integer")
+			return
+		}
+		recent = n
+	}
+	m := s.orch.Metrics(recent)
+	m.Counters.WSClients = s.hub.Clients()
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(m)
+}
+
+// handleDeepHealth probes the simulators and the LLM provider, or answers
+// from a check a few seconds old, and answers 503 when the backend can't run
+// anything. A degraded backend still answers 200.
+func (s *Server) handleDeepHealth(w http.ResponseWriter, r *http.Request) {
+	h := s.orch.DeepHealth(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if h.Status == types.Unhealthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(h)
+}
+
+// handleSimulators reports each simulator's latency percentiles, error rate
+// and breaker state, accumulated since process start, and its health as of
+// the latest background probe.
+func (s *Server) handleSimulators(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(simulatorsResponse{Window: types.StatsSinceStart, Simulators: s.orch.SimulatorStats(), Health: s.orch.SimulatorHealth()})
+}
+
+// handleReady answers 503 once shutdown has begun, while the event hub
+// doesn't answer or the run store's database can't be reached, and while
+// any simulator's latest probe found it down. Simulators' status is the
+// cached one rather than probed, so it stays cheap for load balancers to
+// poll; simulators not probed yet don't hold it back.
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), probeTimeout)
+	defer cancel()
+	var reasons []string
+	if s.unready.Load() || s.orch.Draining() {
+		reasons = append(reasons, "shutting down")
+	}
+	if !s.hub.Alive(ctx) {
+		reasons = append(reasons, "event hub not answering")
+	}
+	if err := s.orch.PingStore(ctx); err != nil {
+		reasons = append(reasons, "run store unreachable: "+err.Error())
+	}
+	health := s.orch.SimulatorHealth()
+	for _, h := range health {
+		if h.Status == types.HealthDown {
+			reasons = append(reasons, "simulator "+h.Tool+" down")
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if len(reasons) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(readiness{Ready: len(reasons) == 0, Reasons: reasons, Simulators: health})
+}
+
+func (s *Server) handleRunMetrics(w http.ResponseWriter, r *http.Request) {
+	m, ok := s.orch.RunMetrics(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, codeRunNotFound, "no metrics for run")
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(m)
 }
@@ -117,3 +1138,37 @@ func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
 func nowISO() string {
 	return time.Now().UTC().Format(time.RFC3339Nano)
 }
+
+// handleArtifact serves an artifact's content with its recorded content type;
+// X-Content-SHA256 lets clients check what they received.
+func (s *Server) handleArtifact(w http.ResponseWriter, r *http.Request) {
+	a, data, err := s.orch.Artifact(r.Context(), r.PathValue("id"), r.PathValue("variant"), r.PathValue("name"))
+	if errors.Is(err, artifacts.ErrNotFound) {
+		writeError(w, http.StatusNotFound, codeNotFound, "artifact not found")
+		return
+	}
+	if errors.Is(err, artifacts.ErrExpired) {
+		// Deleted by retention or the disk budget; it will not come back
+		writeError(w, http.StatusGone, codeArtifactExpired, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", a.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("Content-Disposition", "attachment; filename="+a.Name)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("X-Content-SHA256", a.SHA256)
+	_, _ = w.Write(data)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,2011 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"gopkg.in/yaml.v3"
+
+	"simstack/internal/artifacts"
+	"simstack/internal/compose"
+	"simstack/internal/config"
+	"simstack/internal/eventbus"
+	"simstack/internal/orchestrator"
+	"simstack/internal/ratelimit"
+	"simstack/internal/runstore"
+	"simstack/internal/schema"
+	"simstack/internal/testsupport"
+	"simstack/internal/types"
+	"simstack/internal/version"
+)
+
+func TestHandleRunRejectsInvalidOverrides(t *testing.T) {
+	t.Setenv("LLM_ALLOWED_MODELS", "llama3.1-8b, llama-3.3-70b")
+	fake := testsupport.NewFakeChat()
+	s := &Server{hub: NewHub(), orch: orchestrator.NewEngine(nil, orchestrator.WithChatClient(fake, "llama3.1-8b"))}
+
+	for _, body := range []string{
+		`{"goal": "g", "model": "gpt-huge"}`,
+		`{"goal": "g", "critic_temperature": 3}`,
+		`{"goal": "g", "planner_temperature": -0.1}`,
+	} {
+		rec := httptest.NewRecorder()
+		s.handleRun(rec, httptest.NewRequest(http.MethodPost, "/api/run", strings.NewReader(body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d (%s)", body, rec.Code, rec.Body.String())
+		}
+	}
+	if fake.Calls() != 0 {
+		t.Errorf("rejected runs must not reach the LLM, got %d calls", fake.Calls())
+	}
+}
+
+func TestRequestBodyLimit(t *testing.T) {
+	fake := testsupport.NewFakeChat()
+	s := &Server{hub: NewHub(), orch: orchestrator.NewEngine(nil, orchestrator.WithChatClient(fake, "m")), maxBody: 64}
+	body := `{"goal": "` + strings.Repeat("g", 100) + `"}`
+	for name, handle := range map[string]http.HandlerFunc{"run": s.handleRun, "replay": s.handleReplay, "export": s.handleExport, "runs/run-1/annotations": s.handleAnnotate} {
+		rec := httptest.NewRecorder()
+		handle(rec, httptest.NewRequest(http.MethodPost, "/api/"+name, strings.NewReader(body)))
+		if e := decodeError(rec); rec.Code != http.StatusRequestEntityTooLarge || e.Code != codeBodyTooLarge || e.Message != "request body larger than 64 bytes" {
+			t.Errorf("%s: expected 413, got %d %s", name, rec.Code, rec.Body)
+		}
+	}
+	if fake.Calls() != 0 {
+		t.Errorf("oversized runs must not reach the LLM, got %d calls", fake.Calls())
+	}
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	s := &Server{hub: NewHub(), orch: orchestrator.NewEngine(nil, orchestrator.WithChatClient(testsupport.NewFakeChat(), "m"))}
+	rec := httptest.NewRecorder()
+	s.handleRun(rec, httptest.NewRequest(http.MethodGet, "/api/run", nil))
+	if e := decodeError(rec); rec.Code != http.StatusMethodNotAllowed || e.Code != codeMethodNotAllowed || rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("expected a method_not_allowed envelope, got %d %s", rec.Code, rec.Body)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/run/missing/results", nil)
+	req.SetPathValue("id", "missing")
+	rec = httptest.NewRecorder()
+	s.handleRunResults(rec, req)
+	if e := decodeError(rec); rec.Code != http.StatusNotFound || e.Code != codeRunNotFound || e.Message != "run not found" {
+		t.Errorf("expected a run_not_found envelope, got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestHandleRunIdempotencyKey(t *testing.T) {
+	s := &Server{hub: NewHub(), bus: eventbus.Direct(func(any) {}), orch: orchestrator.NewEngine(nil, orchestrator.WithChatClient(testsupport.NewFakeChat(), "m"))}
+	post := func(key, body string) (*httptest.ResponseRecorder, string) {
+		req := httptest.NewRequest(http.MethodPost, "/api/run", strings.NewReader(body))
+		req.Header.Set("Idempotency-Key", key)
+		rec := httptest.NewRecorder()
+		s.handleRun(rec, req)
+		var resp struct {
+			RunID string `json:"run_id"`
+		}
+		_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec, resp.RunID
+	}
+
+	first, runID := post("retry-1", `{"goal": "g", "offline": true}`)
+	if first.Code != http.StatusOK || runID == "" {
+		t.Fatalf("expected the run started, got %d %s", first.Code, first.Body.String())
+	}
+	retry, retryID := post("retry-1", `{"goal": "g", "offline": true}`)
+	if retry.Code != http.StatusOK || retryID != runID || retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("expected the retry to get run %s, got %d %s", runID, retry.Code, retry.Body.String())
+	}
+	if rec, _ := post("retry-1", `{"goal": "other", "offline": true}`); rec.Code != http.StatusConflict {
+		t.Errorf("expected a different body with the key refused, got %d %s", rec.Code, rec.Body.String())
+	}
+	if _, otherID := post("retry-2", `{"goal": "g", "offline": true}`); otherID == "" || otherID == runID {
+		t.Errorf("expected another key to start another run, got %q", otherID)
+	}
+
+	// A full queue still answers a retry with its run, and a request it
+	// turns away holds no key
+	s.runs = newScheduler(1, 1, nil)
+	_, _ = s.runs.reserve("busy")
+	_, _ = s.runs.reserve("waiting")
+	if rec, id := post("retry-1", `{"goal": "g", "offline": true}`); rec.Code != http.StatusOK || id != runID {
+		t.Errorf("expected the retry to get run %s past a full queue, got %d %s", runID, rec.Code, rec.Body.String())
+	}
+	if rec, _ := post("retry-3", `{"goal": "g", "offline": true}`); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("expected a new run refused by the full queue, got %d %s", rec.Code, rec.Body.String())
+	}
+	s.runs.release("waiting")
+	if rec, id := post("retry-3", `{"goal": "g", "offline": true}`); rec.Code != http.StatusOK || id == "" || rec.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("expected the refused key free to start a run, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+// Past SIMSTACK_MAX_RUNS runs wait their turn, and past the queue depth
+// they are turned away until there's room.
+func TestHandleRunQueuesBeyondMaxRuns(t *testing.T) {
+	var mu sync.Mutex
+	var events []types.WSEvent
+	bus := eventbus.Direct(func(v any) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, v.(types.WSEvent))
+	})
+	s := &Server{hub: NewHub(), bus: bus, runs: newScheduler(1, 1, bus.Publish), orch: orchestrator.NewEngine(nil, orchestrator.WithChatClient(testsupport.NewFakeChat(), "m"))}
+	// Hold the only slot
+	if pos, err := s.runs.reserve("busy"); pos != 0 || err != nil {
+		t.Fatalf("expected the slot, got %d %v", pos, err)
+	}
+	post := func() (*httptest.ResponseRecorder, map[string]any) {
+		rec := httptest.NewRecorder()
+		s.handleRun(rec, httptest.NewRequest(http.MethodPost, "/api/run", strings.NewReader(`{"goal": "g", "offline": true}`)))
+		var resp map[string]any
+		_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec, resp
+	}
+
+	rec, queued := post()
+	runID, _ := queued["run_id"].(string)
+	if rec.Code != http.StatusOK || queued["status"] != "queued" || queued["position"] != 1.0 {
+		t.Fatalf("expected the run queued first, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec, _ := post(); rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "30" {
+		t.Errorf("expected a full queue refused with a hint, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/run/"+runID+"/status", nil)
+	req.SetPathValue("id", runID)
+	rec = httptest.NewRecorder()
+	s.handleRunStatus(rec, req)
+	var progress types.RunProgress
+	if json.Unmarshal(rec.Body.Bytes(), &progress) != nil || progress.Phase != types.PhaseQueued || progress.QueuePosition != 1 {
+		t.Errorf("expected the status to say queued, got %s", rec.Body.String())
+	}
+
+	s.runs.release("busy")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		var got []string
+		for _, ev := range events {
+			if ev.RunID == runID {
+				got = append(got, ev.Type)
+			}
+		}
+		mu.Unlock()
+		if len(got) >= 2 && got[0] == types.EventQueued && got[1] == types.EventStarted {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected queued then started, got %v", got)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCancelQueuedRun(t *testing.T) {
+	s := &Server{hub: NewHub(), bus: eventbus.Direct(func(any) {}), runs: newScheduler(1, 2, nil), orch: orchestrator.NewEngine(nil, orchestrator.WithChatClient(testsupport.NewFakeChat(), "m"))}
+	_, _ = s.runs.reserve("busy")
+	_, _ = s.runs.reserve("run-1")
+	if pos, _ := s.runs.reserve("run-2"); pos != 2 {
+		t.Fatalf("expected second in the queue, got %d", pos)
+	}
+	ran := make(chan bool, 1)
+	s.runs.start("run-1", "g", func(func()) { ran <- true })
+
+	req := httptest.NewRequest(http.MethodPost, "/api/run/run-1/cancel", nil)
+	req.SetPathValue("id", "run-1")
+	rec := httptest.NewRecorder()
+	s.handleCancelRun(rec, req)
+	if rec.Code != http.StatusOK || s.runs.queued("run-2") != 1 {
+		t.Errorf("expected the queued run dropped, got %d %s", rec.Code, rec.Body.String())
+	}
+	s.runs.release("busy")
+	select {
+	case <-ran:
+		t.Error("a cancelled run must never start")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// A run that gives up its slot early, as one the watchdog failed does,
+// lets the next start while it is still stuck, and frees nothing twice.
+func TestSchedulerReleaseFreesSlotEarly(t *testing.T) {
+	runs := newScheduler(1, 1, nil)
+	stuck, ran := make(chan struct{}), make(chan bool, 1)
+	_, _ = runs.reserve("run-1")
+	runs.start("run-1", "g", func(release func()) {
+		release()
+		release()
+		<-stuck
+	})
+	if pos, err := runs.reserve("run-2"); err != nil {
+		t.Fatalf("expected run-2 given a place, got %d %v", pos, err)
+	}
+	runs.start("run-2", "g", func(func()) { ran <- true })
+	select {
+	case <-ran:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected run-2 started while run-1 is stuck")
+	}
+	close(stuck)
+	held := func() int {
+		runs.mu.Lock()
+		defer runs.mu.Unlock()
+		return runs.running
+	}
+	for deadline := time.Now().Add(5 * time.Second); held() != 0 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+	}
+	if n := held(); n != 0 {
+		t.Errorf("expected no slots held, got %d", n)
+	}
+}
+
+// Shutting down tells the WebSocket clients, refuses new runs and closes
+// the connections with a going-away frame.
+func TestShutdown(t *testing.T) {
+	cfg, _ := config.Load()
+	s := NewServer(cfg, orchestrator.WithChatClient(testsupport.NewFakeChat(), "m"))
+	api := httptest.NewServer(s.Router)
+	defer api.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(api.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for s.hub.Clients() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, msg, err := conn.ReadMessage(); err != nil || !strings.Contains(string(msg), `"type":"hello"`) {
+		t.Fatalf("expected the hello event on connecting, got %s %v", msg, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	_, msg, err := conn.ReadMessage()
+	ev, decodeErr := types.DecodeEvent(msg)
+	if err != nil || decodeErr != nil || ev.Type != types.EventShutdown || ev.Payload.(types.ShutdownEvent).GraceMs <= 0 {
+		t.Fatalf("expected the shutdown event first, got %s %v", msg, err)
+	}
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("expected a going-away close frame, got %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	s.handleRun(rec, httptest.NewRequest(http.MethodPost, "/api/run", strings.NewReader(`{"goal": "g", "offline": true}`)))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected new runs refused, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+// A run still queued at shutdown is saved as canceled rather than lost.
+func TestShutdownSavesQueuedRuns(t *testing.T) {
+	cfg, _ := config.Load()
+	s := NewServer(cfg, orchestrator.WithChatClient(testsupport.NewFakeChat(), "m"))
+	s.runs = newScheduler(1, 1, nil)
+	_, _ = s.runs.reserve("busy")
+	if pos, _ := s.runs.reserve("run-1"); pos != 1 {
+		t.Fatalf("expected run-1 queued, got %d", pos)
+	}
+	s.runs.start("run-1", "reduce queue wait", func(func()) { t.Error("a run dropped at shutdown must never start") })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	run, err := s.orch.GetRun(ctx, "run-1")
+	if err != nil || run.Status != "canceled" || run.Goal != "reduce queue wait" || run.FinishedAt == nil {
+		t.Errorf("expected the queued run saved as canceled, got %+v (%v)", run, err)
+	}
+}
+
+func TestHandleRunLLMCalls(t *testing.T) {
+	store := runstore.NewMemory()
+	ctx := context.Background()
+	_ = store.Save(ctx, types.RunRecord{ID: "run-1"})
+	_ = store.AppendLLMCall(ctx, types.LLMAuditRecord{RunID: "run-1", Phase: "plan", PromptHash: "sha256:abc"})
+	s := &Server{hub: NewHub(), orch: orchestrator.NewEngine(nil, orchestrator.WithChatClient(testsupport.NewFakeChat(), "m"), orchestrator.WithRunStore(store))}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/runs/run-1/llm-calls", nil)
+	req.SetPathValue("id", "run-1")
+	rec := httptest.NewRecorder()
+	s.handleRunLLMCalls(rec, req)
+	var body struct {
+		LLMCalls []types.LLMAuditRecord `json:"llm_calls"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || len(body.LLMCalls) != 1 || body.LLMCalls[0].Phase != "plan" {
+		t.Errorf("unexpected response %d %+v (%v)", rec.Code, body, err)
+	}
+
+	req.SetPathValue("id", "missing")
+	rec = httptest.NewRecorder()
+	s.handleRunLLMCalls(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown run, got %d", rec.Code)
+	}
+}
+
+func TestGetAndCancelRun(t *testing.T) {
+	store := runstore.NewMemory()
+	fake := testsupport.NewFakeChat(testsupport.Slow(testsupport.Content("{}"), time.Minute))
+	s := &Server{hub: NewHub(), orch: orchestrator.NewEngine(nil, orchestrator.WithChatClient(fake, "m"), orchestrator.WithRunStore(store))}
+	call := func(handler http.HandlerFunc, method, id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/runs/"+id, nil)
+		req.SetPathValue("id", id)
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	finished := make(chan error, 1)
+	go func() { finished <- s.orch.RunWithID(context.Background(), "run-1", types.RunRequest{Goal: "g"}) }()
+	for deadline := time.Now().Add(5 * time.Second); fake.Calls() == 0; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the run never reached the planner")
+		}
+	}
+	if rec := call(s.handleCancelRun, http.MethodPost, "run-1"); rec.Code != http.StatusAccepted || !strings.Contains(rec.Body.String(), "cancelling") {
+		t.Fatalf("expected the run cancelled, got %d %s", rec.Code, rec.Body.String())
+	}
+	select {
+	case <-finished:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the cancelled run did not stop")
+	}
+
+	var run types.RunRecord
+	if rec := call(s.handleGetRun, http.MethodGet, "run-1"); rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&run) != nil || run.ID != "run-1" || run.Goal != "g" {
+		t.Errorf("unexpected run %d %+v", rec.Code, run)
+	}
+	if rec := call(s.handleCancelRun, http.MethodPost, "run-1"); rec.Code != http.StatusConflict {
+		t.Errorf("expected a finished run refused, got %d", rec.Code)
+	}
+	var progress types.RunProgress
+	if rec := call(s.handleRunStatus, http.MethodGet, "run-1"); rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&progress) != nil || progress.Phase != types.PhaseCancelled {
+		t.Errorf("expected the run's status cancelled, got %d %+v", rec.Code, progress)
+	}
+	var results types.RunResults
+	if rec := call(s.handleRunResults, http.MethodGet, "run-1"); rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&results) != nil || !results.Complete || results.Status != "canceled" {
+		t.Errorf("expected the cancelled run's results complete, got %d %+v", rec.Code, results)
+	}
+	for _, h := range []http.HandlerFunc{s.handleGetRun, s.handleCancelRun, s.handleRunStatus, s.handleRunResults} {
+		if rec := call(h, http.MethodGet, "missing"); rec.Code != http.StatusNotFound {
+			t.Errorf("expected 404 for an unknown run, got %d", rec.Code)
+		}
+	}
+}
+
+func TestHandleReplay(t *testing.T) {
+	store := runstore.NewMemory()
+	plan := types.SimulationPlan{PlanID: "plan-1", Variants: []types.Variant{{VariantID: "plan-1-v1", Parameters: map[string]any{"staff": 20}}}}
+	_ = store.Save(context.Background(), types.RunRecord{ID: "run-1", Goal: "g", Status: "completed", Plan: &plan})
+	_ = store.Save(context.Background(), types.RunRecord{ID: "run-2", Goal: "g", Status: "running"})
+	fake := testsupport.NewFakeChat()
+	s := &Server{hub: NewHub(), bus: eventbus.Direct(func(any) {}), orch: orchestrator.NewEngine(nil, orchestrator.WithChatClient(fake, "m"), orchestrator.WithRunStore(store))}
+	replay := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.handleReplay(rec, httptest.NewRequest(http.MethodPost, "/api/replay", strings.NewReader(body)))
+		return rec
+	}
+
+	for body, want := range map[string]int{
+		`{"run_id": "missing"}`: http.StatusNotFound,
+		`{"run_id": "run-2"}`:   http.StatusBadRequest,
+		`{}`:                    http.StatusBadRequest,
+		`not json`:              http.StatusBadRequest,
+		`{"plan_id": "p", "variants": [{"variant_id": "v", "parameters": {"weather": {"rain": 1}}}]}`: http.StatusBadRequest,
+	} {
+		if rec := replay(body); rec.Code != want {
+			t.Errorf("%s: expected %d, got %d %s", body, want, rec.Code, rec.Body.String())
+		}
+	}
+	var resp map[string]any
+	if rec := replay(`{"run_id": "run-1", "offline": true}`); rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&resp) != nil || resp["replay_of"] != "run-1" || resp["plan_id"] != "plan-1" || resp["run_id"] == "" {
+		t.Errorf("expected the stored plan replayed, got %d %v", rec.Code, resp)
+	}
+	// A bare plan is replayed as given
+	resp = nil
+	if rec := replay(`{"plan_id": "plan-9", "variants": [{"variant_id": "plan-9-v1", "parameters": {"staff": 30}}]}`); rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&resp) != nil || resp["plan_id"] != "plan-9" || resp["replay_of"] != nil {
+		t.Errorf("expected the inline plan replayed, got %d %v", rec.Code, resp)
+	}
+}
+
+func TestRunAnnotations(t *testing.T) {
+	store := runstore.NewMemory()
+	_ = store.Save(context.Background(), types.RunRecord{ID: "run-1", Goal: "g", Status: "completed",
+		Plan: &types.SimulationPlan{PlanID: "plan-1", Variants: []types.Variant{{VariantID: "plan-1-v1"}}}})
+	hub := NewHub()
+	c := &Client{hub: hub, send: make(chan []byte, 16), version: types.EventVersion}
+	go hub.run()
+	hub.register <- c
+	cfg, _ := config.Load()
+	cfg.APIKeys = []string{"dana-key", "sam-key"}
+	s := &Server{hub: hub, orch: orchestrator.NewEngine(eventbus.Direct(hub.broadcastJSON), orchestrator.WithConfig(cfg), orchestrator.WithChatClient(testsupport.NewFakeChat(), "m"), orchestrator.WithRunStore(store))}
+	call := func(handler http.HandlerFunc, method, id, annotation, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/runs/"+id+"/annotations", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer dana-key")
+		req.SetPathValue("id", id)
+		req.SetPathValue("annotation", annotation)
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	rec := call(s.handleAnnotate, http.MethodPost, "run-1", "", `{"author": "dana", "text": "approved for pilot", "variant_id": "plan-1-v1", "tags": ["decision"]}`)
+	var note types.Annotation
+	if rec.Code != http.StatusCreated || json.NewDecoder(rec.Body).Decode(&note) != nil || note.ID == "" || note.CreatedAt.IsZero() || !strings.HasPrefix(note.Owner, "key:") {
+		t.Fatalf("expected the note created, got %d %+v", rec.Code, note)
+	}
+	select {
+	case b := <-c.send:
+		ev, err := types.DecodeEvent(b)
+		if err != nil || ev.Type != types.EventAnnotation || ev.RunID != "run-1" || ev.Payload.(types.AnnotationEvent).Text != "approved for pilot" {
+			t.Errorf("expected the note sent live, got %s (%v)", b, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected an annotation event")
+	}
+
+	var list struct {
+		Annotations []types.Annotation `json:"annotations"`
+	}
+	if rec := call(s.handleAnnotations, http.MethodGet, "run-1", "", ""); json.NewDecoder(rec.Body).Decode(&list) != nil || len(list.Annotations) != 1 || list.Annotations[0].ID != note.ID {
+		t.Errorf("expected the note listed, got %d %+v", rec.Code, list)
+	}
+
+	for name, c := range map[string]struct {
+		rec  *httptest.ResponseRecorder
+		code int
+	}{
+		"unknown run":     {call(s.handleAnnotate, http.MethodPost, "missing", "", `{"author": "dana", "text": "x"}`), http.StatusNotFound},
+		"unknown variant": {call(s.handleAnnotate, http.MethodPost, "run-1", "", `{"author": "dana", "text": "x", "variant_id": "plan-1-v9"}`), http.StatusBadRequest},
+		"empty text":      {call(s.handleAnnotate, http.MethodPost, "run-1", "", `{"author": "dana", "text": " "}`), http.StatusBadRequest},
+		"unknown field":   {call(s.handleAnnotate, http.MethodPost, "run-1", "", `{"author": "dana", "txt": "x"}`), http.StatusBadRequest},
+		"list unknown":    {call(s.handleAnnotations, http.MethodGet, "missing", "", ""), http.StatusNotFound},
+	} {
+		if c.rec.Code != c.code || decodeError(c.rec).Message == "" {
+			t.Errorf("%s: expected %d with an error envelope, got %d", name, c.code, c.rec.Code)
+		}
+	}
+
+	// Only the key that added the note may remove it, whoever it names
+	del := func(key, author string) int {
+		req := httptest.NewRequest(http.MethodDelete, "/api/runs/run-1/annotations/"+note.ID+"?author="+author, nil)
+		req.Header.Set("X-API-Key", key)
+		req.SetPathValue("id", "run-1")
+		req.SetPathValue("annotation", note.ID)
+		rec := httptest.NewRecorder()
+		s.handleDeleteAnnotation(rec, req)
+		return rec.Code
+	}
+	if code := del("sam-key", "dana"); code != http.StatusForbidden {
+		t.Errorf("expected another key's delete refused, even naming the author, got %d", code)
+	}
+	if code := del("dana-key", "sam"); code != http.StatusNoContent {
+		t.Errorf("expected the adding key's delete accepted, got %d", code)
+	}
+	if code := del("dana-key", ""); code != http.StatusNotFound {
+		t.Errorf("expected a deleted note gone, got %d", code)
+	}
+}
+
+func TestHandleRuns(t *testing.T) {
+	store := runstore.NewMemory()
+	base := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i, status := range []string{"completed", "failed", "completed", "running"} {
+		run := types.RunRecord{ID: fmt.Sprintf("run-%d", i+1), Goal: "g", Status: status, StartedAt: base.Add(time.Duration(i) * time.Minute)}
+		if status == "completed" {
+			run.Winner = "v1"
+		}
+		_ = store.Save(context.Background(), run)
+	}
+	s := &Server{hub: NewHub(), orch: orchestrator.NewEngine(nil, orchestrator.WithChatClient(testsupport.NewFakeChat(), "m"), orchestrator.WithRunStore(store))}
+	list := func(query string) (int, []map[string]any) {
+		rec := httptest.NewRecorder()
+		s.handleRuns(rec, httptest.NewRequest(http.MethodGet, "/api/runs?"+query, nil))
+		var runs []map[string]any
+		_ = json.NewDecoder(rec.Body).Decode(&runs)
+		return rec.Code, runs
+	}
+
+	if code, runs := list(""); code != http.StatusOK || len(runs) != 4 || runs[0]["id"] != "run-4" || runs[0]["status"] != "running" || runs[0]["winner"] != nil {
+		t.Errorf("expected every run newest first, the running one without a winner, got %d %v", code, runs)
+	}
+	if _, runs := list("limit=2&offset=1"); len(runs) != 2 || runs[0]["id"] != "run-3" || runs[1]["id"] != "run-2" {
+		t.Errorf("expected the second page of two, got %v", runs)
+	}
+	if _, runs := list("status=completed"); len(runs) != 2 || runs[0]["winner"] != "v1" {
+		t.Errorf("expected the completed runs with their winner, got %v", runs)
+	}
+	for _, query := range []string{"limit=ten", "offset=-1"} {
+		if code, _ := list(query); code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, code)
+		}
+	}
+}
+
+func TestHandleRunGrafana(t *testing.T) {
+	store := runstore.NewMemory()
+	_ = store.Save(context.Background(), types.RunRecord{ID: "run-1", Goal: "g", Status: "completed", Results: []types.SimulationResult{{VariantID: "v1", Metrics: map[string]float64{"queue_avg_wait_time_min": 3}}}})
+	s := &Server{hub: NewHub(), orch: orchestrator.NewEngine(nil, orchestrator.WithChatClient(testsupport.NewFakeChat(), "m"), orchestrator.WithRunStore(store))}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/runs/run-1/grafana", nil)
+	req.SetPathValue("id", "run-1")
+	rec := httptest.NewRecorder()
+	s.handleRunGrafana(rec, req)
+	var dashboard struct {
+		UID    string           `json:"uid"`
+		Panels []map[string]any `json:"panels"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&dashboard); err != nil || dashboard.UID != "simstack-run-1" || len(dashboard.Panels) != 4 {
+		t.Errorf("unexpected dashboard %d %+v (%v)", rec.Code, dashboard, err)
+	}
+	if !strings.Contains(rec.Header().Get("Content-Disposition"), "simstack-run-1-grafana.json") {
+		t.Errorf("expected a download, got %q", rec.Header().Get("Content-Disposition"))
+	}
+
+	req.SetPathValue("id", "missing")
+	rec = httptest.NewRecorder()
+	s.handleRunGrafana(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown run, got %d", rec.Code)
+	}
+}
+
+func TestHandleCompareNarrative(t *testing.T) {
+	store := runstore.NewMemory()
+	for _, id := range []string{"run-1", "run-2"} {
+		_ = store.Save(context.Background(), types.RunRecord{ID: id, Goal: "g", Status: "completed", Winner: "v1", Results: []types.SimulationResult{{VariantID: "v1", Metrics: map[string]float64{"queue_avg_wait_time_min": 3}}}})
+	}
+	s := &Server{hub: NewHub(), orch: orchestrator.NewEngine(nil, orchestrator.WithChatClient(testsupport.NewFakeChat(), "m"), orchestrator.WithRunStore(store))}
+
+	call := func(a, b string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/compare/"+a+"/"+b+"/narrative", nil)
+		req.SetPathValue("a", a)
+		req.SetPathValue("b", b)
+		rec := httptest.NewRecorder()
+		s.handleCompareNarrative(rec, req)
+		return rec
+	}
+	var n types.RunNarrative
+	if rec := call("run-1", "run-2"); rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&n) != nil || n.Markdown == "" || len(n.Comparison.Metrics) != 1 {
+		t.Errorf("unexpected narrative %d %+v", rec.Code, n)
+	}
+	if rec := call("run-1", "missing"); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown run, got %d", rec.Code)
+	}
+}
+
+// readLines decodes a results stream until its summary or EOF.
+func readLines(t *testing.T, body *bufio.Reader) []types.ResultsLine {
+	t.Helper()
+	var lines []types.ResultsLine
+	for {
+		raw, err := body.ReadBytes('\n')
+		if len(raw) > 0 {
+			var line types.ResultsLine
+			if err := json.Unmarshal(raw, &line); err != nil {
+				t.Fatalf("bad line %q: %v", raw, err)
+			}
+			lines = append(lines, line)
+			if line.Summary != nil {
+				return lines
+			}
+		}
+		if err != nil {
+			return lines
+		}
+	}
+}
+
+func TestResultsNDJSONCompletedRun(t *testing.T) {
+	store := runstore.NewMemory()
+	finished := time.Now().UTC()
+	_ = store.Save(context.Background(), types.RunRecord{ID: "run-1", Goal: "g", Status: "completed", FinishedAt: &finished, PlanID: "plan-1", Winner: "v2",
+		Results: []types.SimulationResult{{VariantID: "v1", Metrics: map[string]float64{"queue_utilization": 0.5}}, {VariantID: "v2", Metrics: map[string]float64{"queue_utilization": 0.9}}}})
+	s := &Server{hub: NewHub(), orch: orchestrator.NewEngine(nil, orchestrator.WithChatClient(testsupport.NewFakeChat(), "m"), orchestrator.WithRunStore(store))}
+
+	for _, query := range []string{"", "?follow=true"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/runs/run-1/results.ndjson"+query, nil)
+		req.SetPathValue("id", "run-1")
+		rec := httptest.NewRecorder()
+		s.handleRunResultsNDJSON(rec, req)
+		if rec.Header().Get("Content-Type") != "application/x-ndjson" {
+			t.Errorf("%q: unexpected content type %q", query, rec.Header().Get("Content-Type"))
+		}
+		lines := readLines(t, bufio.NewReader(rec.Body))
+		if len(lines) != 4 || lines[0].Header == nil || lines[0].Header.PlanID != "plan-1" || len(lines[0].Header.Metrics) == 0 {
+			t.Fatalf("%q: expected a header, two results and a summary, got %+v", query, lines)
+		}
+		if lines[1].Result.VariantID != "v1" || lines[2].Result.VariantID != "v2" || lines[3].Summary.Winner != "v2" || lines[3].Summary.Results != 2 || lines[3].Summary.Status != "completed" {
+			t.Errorf("%q: unexpected stream %+v", query, lines)
+		}
+	}
+
+	for query, want := range map[string]int{"": http.StatusOK, "?follow=maybe": http.StatusBadRequest} {
+		req := httptest.NewRequest(http.MethodGet, "/api/runs/missing/results.ndjson"+query, nil)
+		req.SetPathValue("id", "missing")
+		rec := httptest.NewRecorder()
+		s.handleRunResultsNDJSON(rec, req)
+		if want == http.StatusOK {
+			want = http.StatusNotFound
+		}
+		if rec.Code != want {
+			t.Errorf("%q: expected %d, got %d", query, want, rec.Code)
+		}
+	}
+}
+
+func TestResultsNDJSONFollowsActiveRun(t *testing.T) {
+	release := make(chan struct{})
+	sim := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		_, _ = w.Write([]byte(`{"metrics": {"avg_wait": 2.5}}`))
+	}))
+	defer sim.Close()
+	defer func() {
+		select {
+		case <-release:
+		default:
+			close(release)
+		}
+	}()
+	for _, key := range []string{"QUEUE_SIMULATOR_URL", "TRAFFIC_SIMULATOR_URL", "RESOURCE_SIMULATOR_URL"} {
+		t.Setenv(key, sim.URL)
+	}
+	s := &Server{hub: NewHub(), orch: orchestrator.NewEngine(nil, orchestrator.WithChatClient(testsupport.NewFakeChat(), "m"))}
+	handlerDone := make(chan struct{}, 4)
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.SetPathValue("id", "run-1")
+		s.handleRunResultsNDJSON(w, r)
+		handlerDone <- struct{}{}
+	}))
+	defer api.Close()
+	open := func(ctx context.Context, query string) *bufio.Reader {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, api.URL+query, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return bufio.NewReader(resp.Body)
+	}
+
+	finished := make(chan error, 1)
+	go func() {
+		finished <- s.orch.RunWithID(context.Background(), "run-1", types.RunRequest{Goal: "g", Offline: true})
+	}()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+		if _, err := s.orch.GetRun(context.Background(), "run-1"); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the run never started")
+		}
+	}
+
+	// Without follow, an active run's stream ends at the results so far
+	lines := readLines(t, open(context.Background(), ""))
+	if len(lines) != 2 || lines[0].Header.Status != "running" || lines[1].Summary == nil || lines[1].Summary.Status != "running" || lines[1].Summary.Results != 0 {
+		t.Fatalf("expected a header and a running summary, got %+v", lines)
+	}
+	<-handlerDone
+
+	// A follower that disconnects stops following
+	ctx, cancel := context.WithCancel(context.Background())
+	gone := open(ctx, "?follow=true")
+	if line, err := gone.ReadBytes('\n'); err != nil || !strings.Contains(string(line), `"header"`) {
+		t.Fatalf("expected the header first, got %q %v", line, err)
+	}
+	cancel()
+	select {
+	case <-handlerDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stream kept following after the client left")
+	}
+
+	follower := open(context.Background(), "?follow=true")
+	if line, err := follower.ReadBytes('\n'); err != nil || !strings.Contains(string(line), `"header"`) {
+		t.Fatalf("expected the header first, got %q %v", line, err)
+	}
+	close(release)
+	lines = readLines(t, follower)
+	if err := <-finished; err != nil {
+		t.Fatal(err)
+	}
+	run, _ := s.orch.GetRun(context.Background(), "run-1")
+	if len(lines) != len(run.Results)+1 || len(run.Results) == 0 {
+		t.Fatalf("expected every result then a summary, got %d lines for %d results", len(lines), len(run.Results))
+	}
+	for _, line := range lines[:len(lines)-1] {
+		if line.Result == nil || line.Result.VariantID == "" {
+			t.Errorf("expected a result, got %+v", line)
+		}
+	}
+	if summary := lines[len(lines)-1].Summary; summary == nil || summary.Status != "completed" || summary.Results != len(run.Results) || summary.FinishedAt == nil {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+}
+
+func TestHandleExportFormats(t *testing.T) {
+	store := runstore.NewMemory()
+	plan := &types.SimulationPlan{PlanID: "plan-1", Variants: []types.Variant{
+		{VariantID: "plan-1-v1", Parameters: map[string]any{"arrival_rate": 8.0, "staff": 20.0}},
+		{VariantID: "plan-1-v2", Parameters: map[string]any{"arrival_rate": 12.0, "service_rate": 16.0, "staff": 24.0, "utilization": 0.75}},
+	}}
+	_ = store.Save(context.Background(), types.RunRecord{ID: "run-1", Goal: "g", Status: "completed", Plan: plan, Winner: "plan-1-v2"})
+	_ = store.Save(context.Background(), types.RunRecord{ID: "run-2", Goal: "g", Status: "completed", Plan: plan})
+	s := &Server{hub: NewHub(), orch: orchestrator.NewEngine(nil, orchestrator.WithChatClient(testsupport.NewFakeChat(), "m"), orchestrator.WithRunStore(store))}
+	export := func(query, accept, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/export"+query, strings.NewReader(body))
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rec := httptest.NewRecorder()
+		s.handleExport(rec, req)
+		return rec
+	}
+
+	rec := export("?format=env", "", `{"run_id": "run-1"}`)
+	env := rec.Body.String()
+	if rec.Code != http.StatusOK || !strings.Contains(env, "# run: run-1\n# variant: plan-1-v2\n") || !strings.Contains(env, "QUEUE_ARRIVAL_RATE=12\n") ||
+		!strings.Contains(env, "RESOURCE_STAFF=24\n") || !strings.Contains(env, "UTILIZATION=0.75\n") || !strings.Contains(rec.Header().Get("Content-Disposition"), "simstack-plan-1-v2.env") {
+		t.Errorf("unexpected env export %d %v:\n%s", rec.Code, rec.Header(), env)
+	}
+
+	rec = export("", "text/html, application/json;q=0.9, */*;q=0.1", `{"run_id": "run-1", "variant_id": "plan-1-v1", "parameters": {"staff": 21}}`)
+	var doc struct {
+		VariantID string                    `json:"variant_id"`
+		Tools     map[string]map[string]any `json:"tools"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&doc); err != nil || rec.Header().Get("Content-Type") != "application/json" || doc.VariantID != "plan-1-v1" ||
+		doc.Tools["queue"]["arrival_rate"] != 8.0 || doc.Tools["resource"]["staff"] != 21.0 {
+		t.Errorf("unexpected json-params export %d %+v (%v)", rec.Code, doc, err)
+	}
+
+	// Compose stays the default, with or without a run
+	for _, body := range []string{`{"goal": "g", "parameters": {"staff": 25}}`, `{"run_id": "run-1"}`} {
+		if rec := export("", "*/*", body); rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/x-yaml" {
+			t.Errorf("%s: expected compose, got %d %q", body, rec.Code, rec.Header().Get("Content-Type"))
+		}
+	}
+
+	for body, want := range map[string]int{
+		`{"run_id": "missing"}`:                            http.StatusNotFound,
+		`{"run_id": "run-2"}`:                              http.StatusConflict,
+		`{"run_id": "run-1", "variant_id": "plan-1-v9"}`:   http.StatusBadRequest,
+		`{"variant_id": "plan-1-v1"}`:                      http.StatusBadRequest,
+		`{"run_id": "run-1", "format": "k8s"}`:             http.StatusBadRequest,
+		`{"run_id": "run-2", "variant_id": "plan-1-v1"}`:   http.StatusOK,
+		`{"profiles": true}`:                               http.StatusBadRequest,
+		`{"run_id": "run-1", "top_k": -1}`:                 http.StatusBadRequest,
+		`{"run_id": "run-1", "top_k": 2, "format": "env"}`: http.StatusBadRequest,
+		`{"run_id": "run-2", "profiles": true}`:            http.StatusConflict,
+	} {
+		if rec := export("", "", body); rec.Code != want {
+			t.Errorf("%s: expected %d, got %d (%s)", body, want, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestHandleExportProfiles(t *testing.T) {
+	store := runstore.NewMemory()
+	plan := &types.SimulationPlan{PlanID: "plan-1", Variants: []types.Variant{
+		{VariantID: "plan-1-v1", Parameters: map[string]any{"arrival_rate": 8.0, "service_rate": 10.0}},
+		{VariantID: "plan-1-v2", Parameters: map[string]any{"arrival_rate": 12.0, "service_rate": 16.0}},
+		{VariantID: "plan-1-v3", Parameters: map[string]any{"arrival_rate": 12.0, "service_rate": 14.0}},
+	}}
+	_ = store.Save(context.Background(), types.RunRecord{ID: "run-1", Goal: "g", Status: "completed", Plan: plan, PlanID: "plan-1", Winner: "plan-1-v2", Results: []types.SimulationResult{
+		{VariantID: "plan-1-v1", Status: types.ResultComplete, Metrics: map[string]float64{"queue_avg_wait_time_min": 4, "queue_utilization": 0.8}},
+		{VariantID: "plan-1-v2", Status: types.ResultComplete, Metrics: map[string]float64{"queue_avg_wait_time_min": 2, "queue_utilization": 0.75}},
+		{VariantID: "plan-1-v3", Status: types.ResultComplete, Metrics: map[string]float64{"queue_avg_wait_time_min": 3, "queue_utilization": 0.7}},
+	}})
+	s := &Server{hub: NewHub(), orch: orchestrator.NewEngine(nil, orchestrator.WithChatClient(testsupport.NewFakeChat(), "m"), orchestrator.WithRunStore(store))}
+	profiles := func(body string) map[string][]string {
+		rec := httptest.NewRecorder()
+		s.handleExport(rec, httptest.NewRequest(http.MethodPost, "/api/export", strings.NewReader(body)))
+		var f compose.File
+		if err := yaml.Unmarshal(rec.Body.Bytes(), &f); rec.Code != http.StatusOK || err != nil {
+			t.Fatalf("%s: unexpected export %d %v: %s", body, rec.Code, err, rec.Body.String())
+		}
+		out := map[string][]string{}
+		for name, svc := range f.Services {
+			out[name] = svc.Profiles
+		}
+		return out
+	}
+
+	// v3 is dominated by v2, so the front is v1 and v2
+	got := profiles(`{"run_id": "run-1", "profiles": true}`)
+	if len(got) != 6 || !reflect.DeepEqual(got["queue-v1"], []string{"v1"}) || !reflect.DeepEqual(got["queue-v2"], []string{"v2"}) {
+		t.Errorf("expected the Pareto front, got %v", got)
+	}
+	got = profiles(`{"run_id": "run-1", "top_k": 1, "include_backend": true}`)
+	if len(got) != 4 || !reflect.DeepEqual(got["backend"], []string{compose.BaseProfile, "v2"}) {
+		t.Errorf("expected the winner alone, got %v", got)
+	}
+}
+
+func TestHandleExportScript(t *testing.T) {
+	store := runstore.NewMemory()
+	seed := int64(7)
+	plan := &types.SimulationPlan{PlanID: "plan-1", Variants: []types.Variant{{VariantID: "plan-1-v1", Parameters: map[string]any{"arrival_rate": 8.0, "service_rate": 10.0, "staff": 20.0, "seed": 3.0}}}}
+	_ = store.Save(context.Background(), types.RunRecord{ID: "run-1", Goal: "g", Status: "completed", Plan: plan, Winner: "plan-1-v1", Manifest: &types.RunManifest{Seed: &seed}, Results: []types.SimulationResult{
+		{VariantID: "plan-1-v1", Status: types.ResultComplete, Metrics: map[string]float64{"queue_avg_wait_time_min": 24, "queue_novel_metric": 1, "resource_coverage_units": 16}},
+	}})
+	_ = store.Save(context.Background(), types.RunRecord{ID: "run-2", Goal: "g", Status: "completed", Plan: plan, Winner: "plan-1-v1"})
+	s := &Server{hub: NewHub(), orch: orchestrator.NewEngine(nil, orchestrator.WithChatClient(testsupport.NewFakeChat(), "m"), orchestrator.WithRunStore(store))}
+	export := func(accept, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/export", strings.NewReader(body))
+		req.Header.Set("Accept", accept)
+		rec := httptest.NewRecorder()
+		s.handleExport(rec, req)
+		return rec
+	}
+
+	rec := export("application/x-sh", `{"run_id": "run-1", "tolerance": 0.1}`)
+	script := rec.Body.String()
+	if rec.Code != http.StatusOK || !strings.HasPrefix(script, "#!/bin/sh\n") || !strings.Contains(rec.Header().Get("Content-Disposition"), "reproduce-simstack-plan-1-v1.sh") {
+		t.Fatalf("unexpected script export %d %v:\n%s", rec.Code, rec.Header(), script)
+	}
+	for _, want := range []string{"# seed: 3", "# llm seed: 7", "${SIMSTACK_TOLERANCE:-0.1}", `'{"arrival_rate":8,"seed":3,"service_rate":10}'`, "compare 'queue_avg_wait_time_min' 24 ", "compare 'queue_novel_metric' 1 ", "compare 'resource_coverage_units' 16 "} {
+		if !strings.Contains(script, want) {
+			t.Errorf("expected %q in:\n%s", want, script)
+		}
+	}
+	if !strings.Contains(script, "/p')\" 1\n\tcompare 'queue_novel_metric'") || !strings.Contains(script, "/p')\" 0\nelse") {
+		t.Errorf("expected only catalog metrics to be headline:\n%s", script)
+	}
+
+	for body, want := range map[string]int{
+		`{"format": "script", "goal": "g"}`:                                   http.StatusBadRequest,
+		`{"format": "script", "run_id": "run-1", "parameters": {"staff": 1}}`: http.StatusBadRequest,
+		`{"format": "script", "run_id": "run-1", "tolerance": -1}`:            http.StatusBadRequest,
+		`{"format": "script", "run_id": "run-2"}`:                             http.StatusBadRequest,
+	} {
+		if rec := export("", body); rec.Code != want {
+			t.Errorf("%s: expected %d, got %d (%s)", body, want, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestAdminExportImport(t *testing.T) {
+	ctx := context.Background()
+	src := runstore.NewMemory()
+	_ = src.Save(ctx, types.RunRecord{ID: "run-1", Goal: "g", Status: "completed", StartedAt: time.Now().Add(-time.Hour).UTC()})
+	_ = src.AppendLLMCall(ctx, types.LLMAuditRecord{RunID: "run-1", Phase: "plan"})
+	newServer := func(store runstore.RunStore) *Server {
+		return &Server{hub: NewHub(), orch: orchestrator.NewEngine(nil, orchestrator.WithChatClient(testsupport.NewFakeChat(), "m"), orchestrator.WithRunStore(store))}
+	}
+
+	rec := httptest.NewRecorder()
+	newServer(src).handleAdminExport(rec, httptest.NewRequest(http.MethodGet, "/api/admin/export?format=jsonl&status=completed", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/x-ndjson" || !strings.Contains(rec.Header().Get("Content-Disposition"), ".jsonl") {
+		t.Fatalf("unexpected export %d %v", rec.Code, rec.Header())
+	}
+	archived := rec.Body.String()
+
+	dst := runstore.NewMemory()
+	s := newServer(dst)
+	importRuns := func(query, body string) (int, map[string]any) {
+		rec := httptest.NewRecorder()
+		s.handleAdminImport(rec, httptest.NewRequest(http.MethodPost, "/api/admin/import"+query, strings.NewReader(body)))
+		var resp map[string]any
+		_ = json.NewDecoder(rec.Body).Decode(&resp)
+		return rec.Code, resp
+	}
+	if code, resp := importRuns("?dry_run=true", archived); code != http.StatusOK || resp["report"].(map[string]any)["imported"] != 1.0 {
+		t.Errorf("unexpected dry run %d %v", code, resp)
+	}
+	if runs, _ := dst.List(ctx, runstore.ListOptions{}); len(runs) != 0 {
+		t.Errorf("dry run imported %d runs", len(runs))
+	}
+	if code, _ := importRuns("", archived); code != http.StatusOK {
+		t.Errorf("unexpected import status %d", code)
+	}
+	if calls, err := dst.LLMCalls(ctx, "run-1"); err != nil || len(calls) != 1 {
+		t.Errorf("expected the run and its audit trail imported, got %v %v", calls, err)
+	}
+	if code, resp := importRuns("", strings.Replace(archived, `"schema_version":1`, `"schema_version":99`, 1)); code != http.StatusBadRequest || resp["error"] == nil {
+		t.Errorf("expected a newer archive refused, got %d %v", code, resp)
+	}
+	if code, _ := importRuns("?on_conflict=overwrite", archived); code != http.StatusBadRequest {
+		t.Errorf("expected an unknown policy refused, got %d", code)
+	}
+	for _, q := range []string{"?format=zip", "?format=jsonl&artifacts=true", "?since=yesterday"} {
+		rec := httptest.NewRecorder()
+		s.handleAdminExport(rec, httptest.NewRequest(http.MethodGet, "/api/admin/export"+q, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", q, rec.Code)
+		}
+	}
+}
+
+func TestHandleRunValidatesAgainstSchema(t *testing.T) {
+	fake := testsupport.NewFakeChat()
+	s := &Server{hub: NewHub(), orch: orchestrator.NewEngine(nil, orchestrator.WithChatClient(fake, "m"))}
+
+	rec := httptest.NewRecorder()
+	s.handleRun(rec, httptest.NewRequest(http.MethodPost, "/api/run", strings.NewReader(`{"goal": "g", "parameters": {"density": 4}}`)))
+	var details []schema.FieldError
+	e := decodeError(rec, &details)
+	if rec.Code != http.StatusBadRequest || e.Code != codeInvalidRequest || len(details) != 1 || details[0].Pointer != "/parameters/density" {
+		t.Errorf("expected 400 pointing at /parameters/density, got %d %+v %+v", rec.Code, e, details)
+	}
+
+	s.strictRequests = true
+	rec = httptest.NewRecorder()
+	s.handleRun(rec, httptest.NewRequest(http.MethodPost, "/api/run", strings.NewReader(`{"goal": "g", "weights": {}}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("strict mode should reject unknown fields, got %d", rec.Code)
+	}
+	if fake.Calls() != 0 {
+		t.Errorf("rejected runs must not reach the LLM, got %d calls", fake.Calls())
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleRunRequestSchema(rec, httptest.NewRequest(http.MethodGet, "/api/schemas/run-request.json", nil))
+	var doc map[string]any
+	if err := json.NewDecoder(rec.Body).Decode(&doc); err != nil || doc["title"] != "RunRequest" {
+		t.Errorf("unexpected schema response %v (%v)", doc, err)
+	}
+}
+
+func TestHandleRunAcceptsYAML(t *testing.T) {
+	sim := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"metrics": {"utilization": 0.5}}`)
+	}))
+	defer sim.Close()
+	cfg, _ := config.Load()
+	cfg.SimulatorURLs = map[string]string{"queue": sim.URL}
+	s := &Server{hub: NewHub(), orch: orchestrator.NewEngine(nil, orchestrator.WithConfig(cfg), orchestrator.WithChatClient(testsupport.NewFakeChat(), "m"))}
+	post := func(contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/run", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		s.handleRun(rec, req)
+		return rec
+	}
+	finished := func(rec *httptest.ResponseRecorder) types.RunRecord {
+		t.Helper()
+		var started struct {
+			RunID string `json:"run_id"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&started); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("expected the run started, got %d (%v)", rec.Code, err)
+		}
+		for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if run, err := s.orch.GetRun(context.Background(), started.RunID); err == nil && run.Status != "running" {
+				return run
+			}
+		}
+		t.Fatalf("run %s never finished", started.RunID)
+		return types.RunRecord{}
+	}
+
+	fromJSON := finished(post("application/json", `{"goal": "cut waits", "offline": true, "reproducible": true, "seed": 7, "parameters": {"staff": 12}}`))
+	fromYAML := finished(post("application/x-yaml; charset=utf-8", "# Lunch rush\ngoal: cut waits\noffline: true\nreproducible: true\nseed: 7\nparameters:\n  staff: 12\n"))
+	describe := func(run types.RunRecord) string {
+		m := run.Manifest
+		if m == nil || m.Seed == nil {
+			return "no seed recorded"
+		}
+		variants := make([]map[string]any, len(run.Plan.Variants))
+		for i, v := range run.Plan.Variants {
+			variants[i] = v.Parameters
+		}
+		return fmt.Sprint(run.Goal, run.Status, m.Offline, m.Reproducible, *m.Seed, variants)
+	}
+	if got, want := describe(fromYAML), describe(fromJSON); got != want || want == "no seed recorded" {
+		t.Errorf("expected the same run from JSON and YAML, got\n%s\nwant\n%s", got, want)
+	}
+
+	rec := post("text/yaml", "goal: [cut waits\n")
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "invalid yaml: line 1") {
+		t.Errorf("expected malformed YAML refused with its line, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	// Unknown fields are errors in YAML even when JSON only warns of them
+	rec = post("application/yaml", "goal: cut waits\nparameters:\n  staff: 12\nweights: {}\n")
+	var details []schema.FieldError
+	decodeError(rec, &details)
+	if rec.Code != http.StatusBadRequest || len(details) != 1 || details[0].Pointer != "/weights" || details[0].Line != 4 || details[0].Column != 1 {
+		t.Errorf("expected 400 for weights at line 4, column 1, got %d %+v", rec.Code, details)
+	}
+}
+
+func TestHandleExportAcceptsYAML(t *testing.T) {
+	store := runstore.NewMemory()
+	plan := &types.SimulationPlan{PlanID: "plan-1", Variants: []types.Variant{{VariantID: "plan-1-v1", Parameters: map[string]any{"staff": 20.0}}}}
+	_ = store.Save(context.Background(), types.RunRecord{ID: "run-1", Goal: "g", Status: "completed", Plan: plan, Winner: "plan-1-v1"})
+	s := &Server{hub: NewHub(), orch: orchestrator.NewEngine(nil, orchestrator.WithChatClient(testsupport.NewFakeChat(), "m"), orchestrator.WithRunStore(store))}
+	export := func(contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/export", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		s.handleExport(rec, req)
+		return rec
+	}
+
+	fromJSON := export("application/json", `{"run_id": "run-1", "format": "env", "parameters": {"staff": 21}}`)
+	fromYAML := export("application/x-yaml", "run_id: run-1\nformat: env\nparameters:\n  staff: 21\n")
+	if fromYAML.Code != http.StatusOK || fromYAML.Body.String() != fromJSON.Body.String() {
+		t.Errorf("expected the same export from JSON and YAML, got %d:\n%s\nwant:\n%s", fromYAML.Code, fromYAML.Body, fromJSON.Body)
+	}
+
+	for body, want := range map[string]string{
+		"run_id: run-1\nvariant: plan-1-v1\n": "invalid yaml: line 2, column 1: unknown field variant",
+		"run_id: run-1\ntop_k: many\n":        "invalid yaml: line 2, column 1: top_k must be int, not string",
+		"run_id: [run-1\n":                    "invalid yaml: line 1: did not find expected",
+		"- run-1\n":                           "invalid yaml: line 1: expected a mapping",
+	} {
+		if rec := export("text/yaml", body); rec.Code != http.StatusBadRequest || !strings.HasPrefix(decodeError(rec).Message, want) {
+			t.Errorf("%q: expected 400 %q, got %d %s", body, want, rec.Code, rec.Body.String())
+		}
+	}
+
+	// Each level of aliases multiplies the nodes the last expands to by ten
+	bomb := "a: &a [x, x, x, x, x, x, x, x, x, x]\n"
+	for level, prev := range []string{"a", "b", "c", "d", "e"} {
+		name := string(rune('b' + level))
+		bomb += fmt.Sprintf("%s: &%s [*%s, *%s, *%s, *%s, *%s, *%s, *%s, *%s, *%s, *%s]\n", name, name, prev, prev, prev, prev, prev, prev, prev, prev, prev, prev)
+	}
+	start := time.Now()
+	if rec := export("text/yaml", "run_id: run-1\nparameters:\n  "+strings.ReplaceAll(strings.TrimSpace(bomb), "\n", "\n  ")+"\n"); rec.Code != http.StatusBadRequest || !strings.Contains(decodeError(rec).Message, "expands to more than") {
+		t.Errorf("expected an alias bomb refused, got %d %s", rec.Code, rec.Body.String())
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected an alias bomb refused quickly, took %s", elapsed)
+	}
+}
+
+func TestHubSendsNegotiatedEnvelope(t *testing.T) {
+	h := NewHub()
+	go h.run()
+	legacy := &Client{hub: h, send: make(chan []byte, 1)}
+	current := &Client{hub: h, send: make(chan []byte, 1), version: types.EventVersion}
+	h.register <- legacy
+	h.register <- current
+
+	h.broadcastJSON(types.NewEvent(types.EventDone, types.DoneEvent{RunID: "run-1"}))
+	var old, cur map[string]any
+	_ = json.Unmarshal(<-legacy.send, &old)
+	_ = json.Unmarshal(<-current.send, &cur)
+	if _, ok := old["v"]; ok || old["type"] != "done" {
+		t.Errorf("legacy clients must get the unversioned envelope, got %v", old)
+	}
+	if cur["v"] != float64(types.EventVersion) {
+		t.Errorf("negotiating clients must get v=%d, got %v", types.EventVersion, cur)
+	}
+	if !reflect.DeepEqual(old["payload"], cur["payload"]) {
+		t.Errorf("payloads differ between versions: %v vs %v", old["payload"], cur["payload"])
+	}
+}
+
+// Two engines running at once share the hub; every event names its run,
+// and a client subscribed to one run gets only that run's events.
+func TestHubAttributesConcurrentRuns(t *testing.T) {
+	h := NewHub()
+	go h.run()
+	all := &Client{hub: h, send: make(chan []byte, 1024), version: types.EventVersion}
+	onlyA := &Client{hub: h, send: make(chan []byte, 1024), version: types.EventVersion, runID: "run-a"}
+	h.register <- all
+	h.register <- onlyA
+
+	utilization := map[string]float64{"run-a": 0.1, "run-b": 0.9}
+	var wg sync.WaitGroup
+	for runID, u := range utilization {
+		sim := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `{"metrics": {"utilization": %g}}`, u)
+		}))
+		defer sim.Close()
+		cfg, _ := config.Load()
+		cfg.Offline = true
+		cfg.SimulatorURLs = map[string]string{"queue": sim.URL, "traffic": sim.URL, "resource": sim.URL}
+		e := orchestrator.NewEngine(eventbus.Direct(h.broadcastJSON), orchestrator.WithConfig(cfg), orchestrator.WithChatClient(testsupport.NewFakeChat(), "m"))
+		wg.Add(1)
+		go func(runID string) {
+			defer wg.Done()
+			if err := e.RunWithID(context.Background(), runID, types.RunRequest{Goal: "reduce wait"}); err != nil {
+				t.Error(err)
+			}
+		}(runID)
+	}
+	wg.Wait()
+	// Outside any run, so every client gets it; it arrives after the runs'
+	h.broadcastJSON(types.NewEvent(types.EventSimulatorStatus, types.SimulatorStatusEvent{}))
+
+	drain := func(c *Client) []types.WSEvent {
+		var events []types.WSEvent
+		for msg := range c.send {
+			ev, err := types.DecodeEvent(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ev.Type == types.EventSimulatorStatus {
+				return events
+			}
+			events = append(events, ev)
+		}
+		return events
+	}
+
+	plans := map[string]string{}
+	counts := map[string]int{}
+	for _, ev := range drain(all) {
+		counts[ev.RunID]++
+		switch p := ev.Payload.(type) {
+		case types.PlanEvent:
+			plans[ev.RunID] = p.PlanID
+		case types.ResultEvent:
+			for name, v := range p.Metrics {
+				if strings.HasSuffix(name, "_utilization") && v != utilization[ev.RunID] {
+					t.Errorf("%s event of %s carries %s=%g from the other run", ev.Type, ev.RunID, name, v)
+				}
+			}
+		case types.DoneEvent:
+			if p.RunID != ev.RunID {
+				t.Errorf("done event of %s tagged %s", p.RunID, ev.RunID)
+			}
+		}
+		if ev.PlanID != "" && ev.PlanID != plans[ev.RunID] {
+			t.Errorf("%s event of %s tagged with plan %s, want %s", ev.Type, ev.RunID, ev.PlanID, plans[ev.RunID])
+		}
+	}
+	if len(counts) != 2 || counts["run-a"] == 0 || counts["run-b"] == 0 || plans["run-a"] == "" || plans["run-b"] == "" {
+		t.Fatalf("expected events from both runs and only them, got %v (plans %v)", counts, plans)
+	}
+
+	a := drain(onlyA)
+	for _, ev := range a {
+		if ev.RunID != "run-a" {
+			t.Errorf("a client subscribed to run-a got %s from %q", ev.Type, ev.RunID)
+		}
+	}
+	if len(a) != counts["run-a"] {
+		t.Errorf("expected all %d events of run-a, got %d", counts["run-a"], len(a))
+	}
+}
+
+// A sink that stops keeping up must not hold up the WebSocket clients.
+func TestSlowSinkDoesNotDelayWebSocket(t *testing.T) {
+	cfg, _ := config.Load()
+	s := NewServer(cfg)
+	c := &Client{hub: s.hub, send: make(chan []byte, 256), version: types.EventVersion}
+	s.hub.register <- c
+	block := make(chan struct{})
+	defer close(block)
+	s.bus.Subscribe("slow", 1, func(any) { <-block })
+
+	for i := 0; i < 100; i++ {
+		s.bus.Publish(types.NewEvent(types.EventSimulatorStatus, types.SimulatorStatusEvent{}))
+	}
+	for i := 0; i < 100; i++ {
+		select {
+		case <-c.send:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected every event to reach the client, got %d", i)
+		}
+	}
+	if s.bus.Dropped("slow") == 0 || s.bus.Dropped("websocket") != 0 {
+		t.Errorf("expected only the slow sink to drop events, dropped %d and %d", s.bus.Dropped("slow"), s.bus.Dropped("websocket"))
+	}
+}
+
+func TestHandleArtifact(t *testing.T) {
+	ctx := context.Background()
+	store := artifacts.NewMemory(0)
+	data := []byte(`{"metrics": {}}`)
+	a, _ := artifacts.New(types.ArtifactOrigin{RunID: "run-1", VariantID: "p-v1", Tool: "queue"}, "queue-response.json", "application/json", data)
+	a, _ = store.Put(ctx, a, data)
+	s := &Server{hub: NewHub(), orch: orchestrator.NewEngine(nil, orchestrator.WithChatClient(testsupport.NewFakeChat(), "m"), orchestrator.WithArtifactStore(store))}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/runs/{id}/artifacts/{variant}/{name}", s.handleArtifact)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, a.StorageRef, nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != string(data) {
+		t.Fatalf("unexpected response %d %q", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("Content-Type") != "application/json" || rec.Header().Get("X-Content-SHA256") != a.SHA256 {
+		t.Errorf("unexpected headers %v", rec.Header())
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/runs/run-1/artifacts/p-v1/missing.json", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown artifact, got %d", rec.Code)
+	}
+}
+
+// Artifacts the disk store collected answer 410, not 404.
+func TestHandleExpiredArtifact(t *testing.T) {
+	store, err := artifacts.NewDisk(t.TempDir(), artifacts.DiskOptions{Retention: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("log")
+	a, _ := artifacts.New(types.ArtifactOrigin{RunID: "run-1"}, "run.log", "text/plain", data)
+	a, _ = store.Put(context.Background(), a, data)
+	store.Collect(time.Now().Add(2 * time.Hour))
+	s := &Server{hub: NewHub(), orch: orchestrator.NewEngine(nil, orchestrator.WithChatClient(testsupport.NewFakeChat(), "m"), orchestrator.WithArtifactStore(store))}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/runs/{id}/artifacts/{variant}/{name}", s.handleArtifact)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, a.StorageRef, nil))
+	if rec.Code != http.StatusGone || !strings.Contains(rec.Body.String(), "retention") {
+		t.Errorf("expected 410 naming the reason, got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestAllowedOrigins(t *testing.T) {
+	h := NewHub("https://app.example.com")
+	handler := withCORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), h.allowedOrigins)
+	for origin, allowed := range map[string]bool{"https://app.example.com": true, "https://evil.example": false} {
+		req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+		req.Header.Set("Origin", origin)
+		if h.checkOrigin(req) != allowed {
+			t.Errorf("%s: expected websocket allowed=%v", origin, allowed)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if got := rec.Header().Get("Access-Control-Allow-Origin") == origin; got != allowed {
+			t.Errorf("%s: expected CORS allowed=%v, got %q", origin, allowed, rec.Header().Get("Access-Control-Allow-Origin"))
+		}
+	}
+	if !h.checkOrigin(httptest.NewRequest(http.MethodGet, "/ws", nil)) {
+		t.Error("clients without an Origin header must be admitted")
+	}
+	if NewHub().checkOrigin(&http.Request{Host: "backend:8080", Header: http.Header{"Origin": {"https://any.example"}}}) {
+		t.Error("a hub without origins must refuse other origins")
+	}
+	if !NewHub().checkOrigin(&http.Request{Host: "backend:8080", Header: http.Header{"Origin": {"http://backend:8080"}}}) {
+		t.Error("a hub without origins must admit its own")
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	const key = "key-0123456789abcdef"
+	cfg, _ := config.Load()
+	cfg.APIKeys = []string{"other-0123456789abcdef", key}
+	cfg.CORSOrigins = []string{"*"}
+	s := NewServer(cfg, orchestrator.WithChatClient(testsupport.NewFakeChat(), "m"))
+	api := httptest.NewServer(s.Router)
+	defer api.Close()
+
+	for name, c := range map[string]struct {
+		path   string
+		header http.Header
+		want   int
+	}{
+		"no key":         {"/api/runs", nil, http.StatusUnauthorized},
+		"wrong key":      {"/api/runs", http.Header{"Authorization": {"Bearer nope"}}, http.StatusUnauthorized},
+		"bearer":         {"/api/runs", http.Header{"Authorization": {"bearer " + key}}, http.StatusOK},
+		"x-api-key":      {"/api/runs", http.Header{"X-Api-Key": {key}}, http.StatusOK},
+		"query on api":   {"/api/runs?token=" + key, nil, http.StatusUnauthorized},
+		"healthz":        {"/healthz", nil, http.StatusOK},
+		"preflight":      {"/api/run", http.Header{"Origin": {"http://a.example"}, "Access-Control-Request-Method": {"POST"}}, http.StatusNoContent},
+		"ws without key": {"/ws", nil, http.StatusUnauthorized},
+	} {
+		method := http.MethodGet
+		if name == "preflight" {
+			method = http.MethodOptions
+		}
+		req, _ := http.NewRequest(method, api.URL+c.path, nil)
+		for k, v := range c.header {
+			req.Header[k] = v
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != c.want {
+			t.Errorf("%s: expected %d, got %d %s", name, c.want, resp.StatusCode, body)
+		}
+		if c.want == http.StatusUnauthorized && (!strings.Contains(string(body), `"error"`) || strings.Contains(string(body), key)) {
+			t.Errorf("%s: expected a JSON error without the key, got %s", name, body)
+		}
+	}
+
+	ws := "ws" + strings.TrimPrefix(api.URL, "http") + "/ws"
+	conn, _, err := websocket.DefaultDialer.Dial(ws+"?token="+key, nil)
+	if err != nil {
+		t.Fatalf("expected ?token= to authenticate the upgrade: %v", err)
+	}
+	conn.Close()
+	dialer := websocket.Dialer{Subprotocols: []string{wsAuthProtocol, key}}
+	conn, _, err = dialer.Dial(ws, nil)
+	if err != nil || conn.Subprotocol() != wsAuthProtocol {
+		t.Fatalf("expected the key accepted as a subprotocol and %q chosen: %v", wsAuthProtocol, err)
+	}
+	conn.Close()
+
+	s.authDisabled = true
+	resp, err := http.Get(api.URL + "/api/runs")
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("expected no check with auth disabled, got %v %v", resp.StatusCode, err)
+	}
+	resp.Body.Close()
+}
+
+func TestRateLimitedSubmissions(t *testing.T) {
+	cfg, _ := config.Load()
+	cfg.APIKeys = []string{"key-a-0123456789abcdef", "key-b-0123456789abcdef"}
+	s := &Server{
+		orch:    orchestrator.NewEngine(nil, orchestrator.WithConfig(cfg), orchestrator.WithChatClient(testsupport.NewFakeChat(), "m")),
+		limiter: ratelimit.NewMemory(1, 2),
+	}
+	h := s.limited(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusAccepted) })
+	submit := func(key, addr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/run", nil)
+		req.RemoteAddr = addr
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := submit(cfg.APIKeys[0], "10.0.0.1:1234"); rec.Code != http.StatusAccepted || rec.Header().Get("X-RateLimit-Remaining") != strconv.Itoa(1-i) {
+			t.Fatalf("request %d: expected it through with quota, got %d %v", i, rec.Code, rec.Header())
+		}
+	}
+	rec := submit(cfg.APIKeys[0], "10.0.0.2:1234")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "60" || rec.Header().Get("X-RateLimit-Limit") != "2" ||
+		!strings.Contains(rec.Body.String(), `"error"`) {
+		t.Errorf("expected the key limited from any address, got %d %v %s", rec.Code, rec.Header(), rec.Body)
+	}
+	if rec := submit(cfg.APIKeys[1], "10.0.0.1:1234"); rec.Code != http.StatusAccepted {
+		t.Errorf("expected another key its own quota, got %d", rec.Code)
+	}
+
+	s.authDisabled = true
+	submit("", "10.0.0.3:1")
+	submit("", "10.0.0.3:2")
+	if rec := submit("", "10.0.0.3:3"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("expected an address limited without auth, got %d", rec.Code)
+	}
+	if rec := submit("", "10.0.0.4:1"); rec.Code != http.StatusAccepted {
+		t.Errorf("expected another address its own quota, got %d", rec.Code)
+	}
+}
+
+func TestCORSPatternsAndPreflight(t *testing.T) {
+	for origin, allowed := range map[string]bool{
+		"https://app.example.com":   true,
+		"https://a.b.example.com":   true,
+		"http://app.example.com":    false,
+		"https://example.com":       false,
+		"https://evilexample.com":   false,
+		"https://app.example.com.x": false,
+		"http://dev.example.org":    true,
+		"https://localhost:5173":    false,
+		"http://localhost:5173":     true,
+	} {
+		if originAllowed([]string{"https://*.example.com", "*.example.org", "http://localhost:5173"}, origin) != allowed {
+			t.Errorf("%s: expected allowed=%v", origin, allowed)
+		}
+	}
+
+	called := false
+	handler := withCORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true }),
+		func() []string { return []string{"https://*.example.com"} })
+	req := httptest.NewRequest(http.MethodOptions, "/api/run", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	req.Header.Set("Access-Control-Request-Headers", "content-type, idempotency-key")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	h := rec.Header()
+	if rec.Code != http.StatusNoContent || called || h.Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		h.Get("Access-Control-Allow-Methods") != "POST" || h.Get("Access-Control-Allow-Headers") != "content-type, idempotency-key" ||
+		h.Get("Access-Control-Allow-Credentials") != "true" || !strings.Contains(strings.Join(h.Values("Vary"), ","), "Origin") {
+		t.Errorf("unexpected preflight answer %d %v", rec.Code, h)
+	}
+
+	req.Header.Set("Origin", "https://evil.example")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	for key := range rec.Header() {
+		if strings.HasPrefix(key, "Access-Control-") {
+			t.Errorf("expected no CORS headers for a refused origin, got %s", key)
+		}
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/runs", nil)
+	req.Header.Set("Origin", "https://evil.example")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if !called || rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("expected the request served without CORS headers, got %v", rec.Header())
+	}
+}
+
+func TestMetricsEndpoints(t *testing.T) {
+	s := &Server{hub: NewHub(), orch: orchestrator.NewEngine(nil, orchestrator.WithChatClient(testsupport.NewFakeChat(), "m"))}
+
+	rec := httptest.NewRecorder()
+	s.handleMetrics(rec, httptest.NewRequest(http.MethodGet, "/api/metrics?runs=0", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for runs=0, got %d", rec.Code)
+	}
+	go s.hub.run()
+	s.hub.register <- &Client{hub: s.hub, send: make(chan []byte, 1)}
+	// The hub has finished the registration once it takes the next message
+	s.hub.unregister <- &Client{}
+	rec = httptest.NewRecorder()
+	s.handleMetrics(rec, httptest.NewRequest(http.MethodGet, "/api/metrics?runs=5", nil))
+	var snap types.MetricsSnapshot
+	if err := json.NewDecoder(rec.Body).Decode(&snap); err != nil || rec.Code != http.StatusOK || snap.Runs != nil {
+		t.Errorf("expected an empty history, got %d %+v (%v)", rec.Code, snap, err)
+	}
+	if snap.Counters.WSClients != 1 || snap.Counters.RunsStarted != 0 || snap.Counters.Since.IsZero() {
+		t.Errorf("expected one connected client and no runs, got %+v", snap.Counters)
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleSimulators(rec, httptest.NewRequest(http.MethodGet, "/api/simulators", nil))
+	var sims map[string]any
+	if err := json.NewDecoder(rec.Body).Decode(&sims); err != nil || sims["window"] != types.StatsSinceStart {
+		t.Errorf("expected the stats window in the payload, got %v (%v)", sims, err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/runs/missing/metrics", nil)
+	req.SetPathValue("id", "missing")
+	rec = httptest.NewRecorder()
+	s.handleRunMetrics(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown run, got %d", rec.Code)
+	}
+}
+
+func TestUIDoesNotShadowBackend(t *testing.T) {
+	s := NewServer(config.Config{}, orchestrator.WithChatClient(testsupport.NewFakeChat(), "m"))
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.Router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+	if rec := get("/healthz"); rec.Body.String() != "ok" {
+		t.Errorf("expected the health check, got %q", rec.Body.String())
+	}
+	if rec := get("/api/simulators"); rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("expected the simulators API, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if rec := get("/api/nope"); rec.Code != http.StatusNotFound || strings.Contains(rec.Body.String(), "<html") {
+		t.Errorf("expected an unknown API path to 404, got %d", rec.Code)
+	}
+	if rec := get("/runs/run-1"); rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("expected a client-side route to get the UI, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+}
+
+func TestHandleAdminReload(t *testing.T) {
+	cfg, _ := config.Load()
+	s := &Server{hub: NewHub(cfg.CORSOrigins...), orch: orchestrator.NewEngine(nil, orchestrator.WithConfig(cfg), orchestrator.WithChatClient(testsupport.NewFakeChat(), "m"))}
+	next := cfg
+	next.CORSOrigins = []string{"https://app.example.com"}
+	next.RunStore = "sqlite"
+	s.loadConfig = func() (config.Config, error) { return next, nil }
+
+	rec := httptest.NewRecorder()
+	s.handleAdminReload(rec, httptest.NewRequest(http.MethodPost, "/api/admin/reload", nil))
+	var report config.Report
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("unexpected response %d %v", rec.Code, err)
+	}
+	if len(report.Applied) != 1 || report.Applied[0].Setting != "CORSOrigins" || len(report.Rejected) != 1 || report.Rejected[0].Setting != "RunStore" {
+		t.Errorf("unexpected report %+v", report)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	req.Header.Set("Origin", "https://evil.example")
+	if s.hub.checkOrigin(req) {
+		t.Error("expected the reloaded origins to apply to new connections")
+	}
+
+	s.loadConfig = func() (config.Config, error) {
+		return config.Config{}, &config.Error{Problems: []string{"SIMULATOR_TIMEOUT must be positive"}}
+	}
+	rec = httptest.NewRecorder()
+	s.handleAdminReload(rec, httptest.NewRequest(http.MethodPost, "/api/admin/reload", nil))
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "SIMULATOR_TIMEOUT") {
+		t.Errorf("expected the problems reported, got %d %q", rec.Code, rec.Body.String())
+	}
+	if got := s.orch.Config().CORSOrigins; !reflect.DeepEqual(got, next.CORSOrigins) {
+		t.Errorf("an invalid configuration must change nothing, got %v", got)
+	}
+}
+
+func TestHandleReady(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	cfg, _ := config.Load()
+	cfg.SimulatorURLs = map[string]string{"queue": down.URL}
+	cfg.HealthInterval, cfg.HealthDownAfter = 5*time.Millisecond, 1
+	s := &Server{hub: NewHub(), orch: orchestrator.NewEngine(nil, orchestrator.WithConfig(cfg), orchestrator.WithChatClient(testsupport.NewFakeChat(), "m"))}
+	go s.hub.run()
+
+	// Not probed yet: unknown doesn't hold readiness back
+	rec := httptest.NewRecorder()
+	s.handleReady(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected ready before any probe, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.PollSimulators(ctx)
+	deadline := time.Now().Add(5 * time.Second)
+	for s.orch.SimulatorHealth()[0].Status != types.HealthDown {
+		if time.Now().After(deadline) {
+			t.Fatal("the simulator was never marked down")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	rec = httptest.NewRecorder()
+	s.handleReady(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	var body struct {
+		Ready      bool                    `json:"ready"`
+		Simulators []types.SimulatorHealth `json:"simulators"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || rec.Code != http.StatusServiceUnavailable || body.Ready || len(body.Simulators) != 1 || body.Simulators[0].Tool != "queue" {
+		t.Errorf("expected not ready with the down simulator, got %d %+v (%v)", rec.Code, body, err)
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleSimulators(rec, httptest.NewRequest(http.MethodGet, "/api/simulators", nil))
+	if !strings.Contains(rec.Body.String(), `"health":[{"tool":"queue"`) {
+		t.Errorf("expected the cached health in the simulators API, got %s", rec.Body.String())
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	cfg, _ := config.Load()
+	cfg.Offline = true
+	cfg.SimulatorWarmup = false
+	s := NewServer(cfg, orchestrator.WithChatClient(testsupport.NewFakeChat(), "m"))
+	events := make(chan types.WSEvent, 256)
+	s.bus.Subscribe("test", 256, func(v any) {
+		if ev, ok := v.(types.WSEvent); ok {
+			events <- ev
+		}
+	})
+	post := func(id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/run", strings.NewReader(`{"goal": "reduce wait"}`))
+		if id != "" {
+			req.Header.Set("X-Request-ID", id)
+		}
+		rec := httptest.NewRecorder()
+		s.Router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := post("client-req-1")
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Request-ID") != "client-req-1" {
+		t.Fatalf("expected the client's request ID echoed, got %d %v", rec.Code, rec.Header())
+	}
+	var started map[string]any
+	_ = json.Unmarshal(rec.Body.Bytes(), &started)
+	timeout := time.After(5 * time.Second)
+	for found := false; !found; {
+		select {
+		case ev := <-events:
+			if ev.RunID == started["run_id"] && ev.Type == types.EventPlan {
+				found = true
+				if ev.RequestID != "client-req-1" {
+					t.Errorf("expected the run's events to carry the request ID, got %+v", ev)
+				}
+			}
+		case <-timeout:
+			t.Fatal("expected a plan event for the run")
+		}
+	}
+
+	if id := post("bad id\n").Header().Get("X-Request-ID"); len(id) != 16 {
+		t.Errorf("expected an unusable request ID replaced, got %q", id)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	rec = httptest.NewRecorder()
+	s.Router.ServeHTTP(rec, req)
+	if rec.Header().Get("X-Request-ID") == "" {
+		t.Errorf("expected every response to carry a request ID, got %v", rec.Header())
+	}
+}
+
+func TestOpenAPICoversRoutes(t *testing.T) {
+	src, err := os.ReadFile("server.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	documented := map[string]bool{}
+	for _, op := range apiOperations {
+		documented[op.Pattern()] = true
+		documented[op.Path] = true
+	}
+	registered := map[string]bool{}
+	for _, m := range regexp.MustCompile(`mux\.HandleFunc\("([^"]+)"`).FindAllStringSubmatch(string(src), -1) {
+		pattern := m[1]
+		registered[pattern] = true
+		// A pattern without a method is documented under any
+		if !documented[pattern] {
+			t.Errorf("route %s is missing from apiOperations", pattern)
+		}
+	}
+	for _, op := range apiOperations {
+		if !registered[op.Pattern()] && !registered[op.Path] {
+			t.Errorf("%s is documented but not routed", op.Pattern())
+		}
+	}
+
+	s := &Server{hub: NewHub(), orch: orchestrator.NewEngine(nil, orchestrator.WithChatClient(testsupport.NewFakeChat(), "m"))}
+	rec := httptest.NewRecorder()
+	s.handleOpenAPI(rec, httptest.NewRequest(http.MethodGet, "/api/openapi.json", nil))
+	var doc struct {
+		OpenAPI    string                               `json:"openapi"`
+		Paths      map[string]map[string]map[string]any `json:"paths"`
+		Components struct {
+			Schemas map[string]map[string]any `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil || doc.OpenAPI != "3.1.0" {
+		t.Fatalf("expected an OpenAPI 3.1 document, got %v: %.200s", err, rec.Body)
+	}
+	for _, ref := range regexp.MustCompile(`"#/components/schemas/([^"]+)"`).FindAllStringSubmatch(rec.Body.String(), -1) {
+		if doc.Components.Schemas[ref[1]] == nil {
+			t.Errorf("reference to missing component %s", ref[1])
+		}
+	}
+	if doc.Paths["/api/run"]["post"] == nil || doc.Components.Schemas["RunRequest"]["$id"] != nil ||
+		doc.Components.Schemas["RunRequest"]["properties"].(map[string]any)["goal"].(map[string]any)["maxLength"] != 10000.0 {
+		t.Errorf("expected /api/run to take the published run request schema, got %v", doc.Components.Schemas["RunRequest"])
+	}
+	mapping := doc.Components.Schemas["WSEvent"]["discriminator"].(map[string]any)["mapping"].(map[string]any)
+	for typ := range types.EventPayloads() {
+		if mapping[typ] == nil {
+			t.Errorf("expected event %s in the WSEvent union", typ)
+		}
+	}
+	done := doc.Components.Schemas["EventDone"]["allOf"].([]any)[1].(map[string]any)["properties"].(map[string]any)
+	if done["payload"].(map[string]any)["$ref"] != "#/components/schemas/DoneEvent" {
+		t.Errorf("expected done events to carry a DoneEvent, got %v", done)
+	}
+	record := doc.Components.Schemas["RunRecord"]["properties"].(map[string]any)
+	for _, f := range []string{"id", "goal", "status", "results"} {
+		if record[f] == nil {
+			t.Errorf("expected RunRecord to have %s, got %v", f, record)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleRoutes(rec, httptest.NewRequest(http.MethodGet, "/api/routes", nil))
+	var routes []route
+	if err := json.Unmarshal(rec.Body.Bytes(), &routes); err != nil || len(routes) != len(apiOperations) || routes[0].Path != "/api/run" {
+		t.Errorf("expected every operation listed, got %v %s", err, rec.Body)
+	}
+}
+
+func TestPrometheusMetrics(t *testing.T) {
+	cfg, _ := config.Load()
+	cfg.Offline = true
+	cfg.SimulatorWarmup = false
+	cfg.APIKeys = []string{"k"}
+	s := NewServer(cfg, orchestrator.WithChatClient(testsupport.NewFakeChat(), "m"))
+	if err := s.orch.Run(context.Background(), types.RunRequest{Goal: "reduce wait"}); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	s.Router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("expected the exposition served without a key, got %d %v", rec.Code, rec.Header())
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		"simstack_runs_started_total 1\n",
+		"simstack_runs_active 0\n",
+		"simstack_ws_clients 0\n",
+		"simstack_planner_calls_total 1\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in:\n%s", want, body)
+		}
+	}
+
+	// The JSON snapshot moved under /api, behind the key
+	rec = httptest.NewRecorder()
+	s.Router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/metrics", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected /api/metrics to need a key, got %d", rec.Code)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/metrics", nil)
+	req.Header.Set("X-API-Key", "k")
+	rec = httptest.NewRecorder()
+	s.Router.ServeHTTP(rec, req)
+	var snap types.MetricsSnapshot
+	if err := json.NewDecoder(rec.Body).Decode(&snap); err != nil || snap.Counters.RunsStarted != 1 {
+		t.Errorf("expected the JSON snapshot at /api/metrics, got %d %+v (%v)", rec.Code, snap.Counters, err)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	var agent atomic.Value
+	sim := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agent.Store(r.Header.Get("User-Agent"))
+		fmt.Fprint(w, `{"metrics": {"utilization": 0.5}}`)
+	}))
+	defer sim.Close()
+	cfg, _ := config.Load()
+	cfg.Offline = true
+	cfg.SimulatorWarmup = false
+	cfg.SimulatorURLs = map[string]string{"queue": sim.URL}
+	cfg.APIKeys = []string{"k"}
+	s := NewServer(cfg, orchestrator.WithChatClient(testsupport.NewFakeChat(), "m"))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/version", nil)
+	req.Header.Set("X-API-Key", "k")
+	rec := httptest.NewRecorder()
+	s.Router.ServeHTTP(rec, req)
+	var info types.BuildInfo
+	if err := json.NewDecoder(rec.Body).Decode(&info); err != nil || info.Version != version.Version || info.GoVersion == "" {
+		t.Fatalf("expected the build, got %d %+v (%v)", rec.Code, info, err)
+	}
+	if want := (types.Features{Auth: true, RunStore: "memory", Offline: true}); info.Features != want {
+		t.Errorf("expected features %+v, got %+v", want, info.Features)
+	}
+
+	api := httptest.NewServer(s.Router)
+	defer api.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(api.URL, "http")+"/ws?v=2&token=k", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, msg, err := conn.ReadMessage()
+	ev, decodeErr := types.DecodeEvent(msg)
+	if err != nil || decodeErr != nil || ev.Type != types.EventHello {
+		t.Fatalf("expected the hello event first, got %s %v %v", msg, err, decodeErr)
+	}
+	hello := ev.Payload.(types.HelloEvent)
+	if hello.BuildInfo != info || time.Since(hello.ServerTime) > time.Minute {
+		t.Errorf("expected the build and the server time, got %+v", hello)
+	}
+
+	if err := s.orch.Run(context.Background(), types.RunRequest{Goal: "reduce wait", Offline: true}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := agent.Load().(string); got != version.UserAgent() {
+		t.Errorf("expected simulator requests from %q, got %q", version.UserAgent(), got)
+	}
+}
+
+func TestDeepHealthEndpoint(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	down.Close()
+	cfg, _ := config.Load()
+	cfg.Offline = true
+	cfg.SimulatorURLs = map[string]string{"queue": down.URL}
+	cfg.APIKeys = []string{"k"}
+	s := NewServer(cfg, orchestrator.WithChatClient(testsupport.NewFakeChat(), "m"))
+
+	rec := httptest.NewRecorder()
+	s.Router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz/deep", nil))
+	var h types.DeepHealth
+	if err := json.NewDecoder(rec.Body).Decode(&h); err != nil || rec.Code != http.StatusServiceUnavailable || h.Status != types.Unhealthy {
+		t.Errorf("expected 503 without a key while no simulator answers, got %d %+v (%v)", rec.Code, h, err)
+	}
+}
+
+// unreachableStore is a run store whose database is down.
+type unreachableStore struct {
+	*runstore.Memory
+}
+
+func (unreachableStore) Ping(context.Context) error {
+	return errors.New("connection refused")
+}
+
+func TestLivenessAndReadiness(t *testing.T) {
+	cfg, _ := config.Load()
+	cfg.SimulatorURLs = map[string]string{}
+	s := &Server{hub: NewHub(), orch: orchestrator.NewEngine(nil, orchestrator.WithConfig(cfg), orchestrator.WithChatClient(testsupport.NewFakeChat(), "m"))}
+	probe := func(handler http.HandlerFunc, path string) *httptest.ResponseRecorder {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, path, nil).WithContext(ctx))
+		return rec
+	}
+
+	// A hub whose loop isn't running answers neither probe
+	if rec := probe(s.handleHealth, "/livez"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected not live without the hub, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := probe(s.handleReady, "/readyz"); rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "event hub") {
+		t.Errorf("expected not ready without the hub, got %d %s", rec.Code, rec.Body.String())
+	}
+	go s.hub.run()
+	if rec := probe(s.handleHealth, "/livez"); rec.Code != http.StatusOK {
+		t.Errorf("expected live, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := probe(s.handleReady, "/readyz"); rec.Code != http.StatusOK {
+		t.Errorf("expected ready, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	s.Unready()
+	rec := probe(s.handleReady, "/readyz")
+	var body readiness
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || rec.Code != http.StatusServiceUnavailable || body.Ready || len(body.Reasons) != 1 || body.Reasons[0] != "shutting down" {
+		t.Errorf("expected not ready once shutting down, got %d %+v (%v)", rec.Code, body, err)
+	}
+	if rec := probe(s.handleHealth, "/livez"); rec.Code != http.StatusOK {
+		t.Errorf("expected still live while shutting down, got %d", rec.Code)
+	}
+
+	store := unreachableStore{runstore.NewMemory()}
+	s.orch = orchestrator.NewEngine(nil, orchestrator.WithConfig(cfg), orchestrator.WithChatClient(testsupport.NewFakeChat(), "m"), orchestrator.WithRunStore(store))
+	s.unready.Store(false)
+	if rec := probe(s.handleReady, "/readyz"); rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "run store unreachable: connection refused") {
+		t.Errorf("expected not ready without the database, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRedirectHTTPS(t *testing.T) {
+	for _, tc := range []struct {
+		addr, host, want string
+	}{
+		{":443", "app.example.com", "https://app.example.com/api/runs?limit=5"},
+		{":8443", "app.example.com:80", "https://app.example.com:8443/api/runs?limit=5"},
+		{"0.0.0.0:443", "[::1]:80", "https://[::1]/api/runs?limit=5"},
+		{":443", "[::1]", "https://[::1]/api/runs?limit=5"},
+		{":8443", "[::1]", "https://[::1]:8443/api/runs?limit=5"},
+	} {
+		r := httptest.NewRequest("POST", "http://x/api/runs?limit=5", nil)
+		r.Host = tc.host
+		rec := httptest.NewRecorder()
+		RedirectHTTPS(tc.addr).ServeHTTP(rec, r)
+		if rec.Code != http.StatusPermanentRedirect || rec.Header().Get("Location") != tc.want {
+			t.Errorf("%s via %s: expected 308 to %s, got %d %q", tc.host, tc.addr, tc.want, rec.Code, rec.Header().Get("Location"))
+		}
+	}
+}
+
+func TestCompression(t *testing.T) {
+	cfg, _ := config.Load()
+	cfg.Offline = true
+	cfg.CORSOrigins = []string{"https://app.example.com"}
+	s := NewServer(cfg, orchestrator.WithChatClient(testsupport.NewFakeChat(), "m"))
+	get := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		rec := httptest.NewRecorder()
+		s.Router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	plain := get("/api/openapi.json", "")
+	if plain.Header().Get("Content-Encoding") != "" || !json.Valid(plain.Body.Bytes()) {
+		t.Fatalf("expected identity without Accept-Encoding, got %q", plain.Header().Get("Content-Encoding"))
+	}
+	for _, tc := range []struct {
+		accept, want string
+		open         func(io.Reader) (io.Reader, error)
+	}{
+		{"gzip, deflate, br", "gzip", func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+		{"deflate, gzip;q=0.5", "deflate", func(r io.Reader) (io.Reader, error) { return zlib.NewReader(r) }},
+	} {
+		rec := get("/api/openapi.json", tc.accept)
+		if rec.Header().Get("Content-Encoding") != tc.want {
+			t.Fatalf("%s: expected %s, got %q", tc.accept, tc.want, rec.Header().Get("Content-Encoding"))
+		}
+		if vary := rec.Header().Values("Vary"); !contains(vary, "Origin") || !contains(vary, "Accept-Encoding") {
+			t.Errorf("expected Vary on both Origin and Accept-Encoding, got %v", vary)
+		}
+		if rec.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+			t.Errorf("expected CORS headers kept, got %v", rec.Header())
+		}
+		body, err := tc.open(rec.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(body)
+		if err != nil || !bytes.Equal(got, plain.Body.Bytes()) {
+			t.Errorf("%s: expected the same document decompressed (%v)", tc.accept, err)
+		}
+	}
+	if rec := get("/api/version", "gzip"); rec.Header().Get("Content-Encoding") != "" {
+		t.Errorf("expected a small body left alone, got %q", rec.Header().Get("Content-Encoding"))
+	}
+	if rec := get("/api/openapi.json", "gzip;q=0, identity"); rec.Header().Get("Content-Encoding") != "" {
+		t.Errorf("expected a refused encoding not used, got %q", rec.Header().Get("Content-Encoding"))
+	}
+
+	// The upgrade reaches the WebSocket handler as is, and its frames flow
+	api := httptest.NewServer(s.Router)
+	defer api.Close()
+	header := http.Header{"Accept-Encoding": {"gzip, deflate"}}
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(api.URL, "http")+"/ws", header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if resp.Header.Get("Content-Encoding") != "" || contains(resp.Header.Values("Vary"), "Accept-Encoding") {
+		t.Errorf("expected the handshake untouched, got %v", resp.Header)
+	}
+	var hello types.WSEvent
+	if err := conn.ReadJSON(&hello); err != nil || hello.Type != types.EventHello {
+		t.Errorf("expected the hello event over the socket, got %+v %v", hello, err)
+	}
+}
+
+func TestConfigEndpoint(t *testing.T) {
+	cfg, _ := config.Load()
+	cfg.LLM.APIKey = "sk-secret"
+	cfg.MaxRuns = 7
+	s := NewServer(cfg, orchestrator.WithChatClient(testsupport.NewFakeChat(), "m"))
+	rec := httptest.NewRecorder()
+	s.Router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/config", nil))
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "sk-secret") {
+		t.Fatalf("expected the settings without the key, got %d %s", rec.Code, rec.Body)
+	}
+	var resp configResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"LLM.APIKey": "(hidden)", "MaxRuns": "7"}
+	for _, s := range resp.Settings {
+		if v, ok := want[s.Setting]; ok && v != s.Value {
+			t.Errorf("expected %s = %s, got %s", s.Setting, v, s.Value)
+		}
+		delete(want, s.Setting)
+	}
+	if len(want) > 0 {
+		t.Errorf("expected settings %v listed", want)
+	}
+}
+
+// decodeError reads the error envelope of rec, decoding its details into
+// details when given.
+func decodeError(rec *httptest.ResponseRecorder, details ...any) apiError {
+	var envelope struct {
+		Error struct {
+			apiError
+			Details json.RawMessage `json:"details"`
+		} `json:"error"`
+	}
+	_ = json.Unmarshal(rec.Body.Bytes(), &envelope)
+	if len(details) > 0 && envelope.Error.Details != nil {
+		_ = json.Unmarshal(envelope.Error.Details, details[0])
+	}
+	return envelope.Error.apiError
+}
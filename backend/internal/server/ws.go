@@ -1,33 +1,105 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
+
+	"simstack/internal/types"
 )
 
+// hubQueue is how many events the hub may fall behind the engine by before
+// it misses some.
+const hubQueue = 1024
+
+// How long a connection closed at shutdown waits to send its close frame
+const closeWait = 5 * time.Second
+
 type Hub struct {
 	register   chan *Client
 	unregister chan *Client
 	clients    map[*Client]bool
-	broadcast  chan []byte
+	broadcast  chan frame
+	// len(clients), readable outside run
+	connected atomic.Int64
+
+	// Browser origins allowed to connect and by CORS; "*" allows any
+	origins atomic.Pointer[[]string]
+
+	// Shares broadcasts with other replicas (SIMSTACK_REDIS_URL); nil keeps
+	// them in this process
+	relay *relay
+	// The event each new connection gets first, to it alone; nil sends none
+	hello func() types.WSEvent
+
+	// Asks run to close every connection, answering with the clients it
+	// closed; closing is set from then on
+	stop    chan chan []*Client
+	closing atomic.Bool
+
+	// run answers each ping between messages
+	ping chan chan struct{}
+}
+
+// frame is one broadcast message encoded for each envelope version.
+type frame struct {
+	current []byte
+	legacy  []byte
+	// The run the message belongs to; empty for messages to every client
+	runID string
 }
 
 type Client struct {
 	hub  *Hub
 	conn *websocket.Conn
 	send chan []byte
+
+	// Envelope version negotiated with ?v= on connect; 1 is the legacy shape
+	version int
+	// With ?run= on connect, the one run whose events the client gets;
+	// events outside any run still reach it
+	runID string
+	// Closed once writePump has finished with the connection
+	done chan struct{}
+}
+
+// wants reports whether f is for c.
+func (c *Client) wants(f frame) bool {
+	return c.runID == "" || f.runID == "" || f.runID == c.runID
 }
 
-func NewHub() *Hub {
-	return &Hub{
+// NewHub returns a hub accepting connections from origins, as allowed by
+// originAllowed, besides its own; with none, only same-origin pages may
+// connect.
+func NewHub(origins ...string) *Hub {
+	h := &Hub{
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
 		clients:    make(map[*Client]bool),
-		broadcast:  make(chan []byte, 256),
+		broadcast:  make(chan frame, 256),
+		stop:       make(chan chan []*Client),
+		ping:       make(chan chan struct{}),
 	}
+	h.SetOrigins(origins...)
+	return h
+}
+
+// SetOrigins replaces the allowed origins; with none, only same-origin
+// pages may connect. Connections already open stay open.
+func (h *Hub) SetOrigins(origins ...string) {
+	h.origins.Store(&origins)
+}
+
+func (h *Hub) allowedOrigins() []string {
+	return *h.origins.Load()
 }
 
 func (h *Hub) run() {
@@ -40,33 +112,162 @@ func (h *Hub) run() {
 				delete(h.clients, c)
 				close(c.send)
 			}
-		case msg := <-h.broadcast:
-			for c := range h.clients {
-				select {
-				case c.send <- msg:
-				default:
-					delete(h.clients, c)
-					close(c.send)
-				}
-			}
+		case f := <-h.broadcast:
+			h.deliver(f)
+		case reply := <-h.stop:
+			h.closeAll(reply)
+		case reply := <-h.ping:
+			close(reply)
+		}
+		h.connected.Store(int64(len(h.clients)))
+	}
+}
+
+func (h *Hub) deliver(f frame) {
+	for c := range h.clients {
+		if !c.wants(f) {
+			continue
+		}
+		select {
+		case c.send <- c.message(f):
+		default:
+			delete(h.clients, c)
+			close(c.send)
 		}
 	}
 }
 
+// message is f encoded for c's envelope version.
+func (c *Client) message(f frame) []byte {
+	if c.version >= types.EventVersion {
+		return f.current
+	}
+	return f.legacy
+}
+
+// closeAll delivers the broadcasts already waiting, then ends every
+// client's connection and replies with the clients.
+func (h *Hub) closeAll(reply chan []*Client) {
+	for pending := true; pending; {
+		select {
+		case f := <-h.broadcast:
+			h.deliver(f)
+		default:
+			pending = false
+		}
+	}
+	closed := make([]*Client, 0, len(h.clients))
+	for c := range h.clients {
+		delete(h.clients, c)
+		close(c.send)
+		closed = append(closed, c)
+	}
+	reply <- closed
+}
+
+// shutdown closes every connection with a going-away close frame, once
+// its client has the events broadcast before, and waits until they are
+// closed or ctx is done. Connections made afterwards are refused.
+func (h *Hub) shutdown(ctx context.Context) {
+	h.closing.Store(true)
+	reply := make(chan []*Client, 1)
+	select {
+	case h.stop <- reply:
+	case <-ctx.Done():
+		return
+	}
+	for _, c := range <-reply {
+		if c.done == nil {
+			continue
+		}
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Alive reports whether the hub's loop answers before ctx is done, which
+// a hub not started, or stuck, would not.
+func (h *Hub) Alive(ctx context.Context) bool {
+	reply := make(chan struct{})
+	select {
+	case h.ping <- reply:
+	case <-ctx.Done():
+		return false
+	}
+	select {
+	case <-reply:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Clients returns how many WebSocket clients are connected.
+func (h *Hub) Clients() int64 {
+	return h.connected.Load()
+}
+
 func (h *Hub) broadcastJSON(v any) {
-	b, _ := json.Marshal(v)
-	h.broadcast <- b
+	f := encodeFrame(v)
+	h.broadcast <- f
+	// A replica's shutdown is news only to its own clients
+	if ev, ok := v.(types.WSEvent); ok && ev.Type == types.EventShutdown {
+		return
+	}
+	if h.relay != nil {
+		h.relay.publish(f)
+	}
 }
 
-var upgrader = websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+// encodeFrame encodes v for every envelope version.
+func encodeFrame(v any) frame {
+	var f frame
+	f.current, _ = json.Marshal(v)
+	f.legacy = f.current
+	if ev, ok := v.(types.WSEvent); ok {
+		f.runID = ev.RunID
+		if ev.Version != 0 {
+			f.legacy, _ = json.Marshal(ev.Legacy())
+		}
+	}
+	return f
+}
+
+// checkOrigin admits non-browser clients, which send no Origin, and browsers
+// on the backend's own origin or an allowed one.
+func (h *Hub) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	return originAllowed(h.allowedOrigins(), origin)
+}
 
 func serveWS(h *Hub, w http.ResponseWriter, r *http.Request) {
+	if h.closing.Load() {
+		writeError(w, http.StatusServiceUnavailable, codeShuttingDown, "shutting down")
+		return
+	}
+	// Browsers drop a socket whose server picks none of the subprotocols
+	// they offered, so one authenticating with its key gets wsAuthProtocol
+	upgrader := websocket.Upgrader{CheckOrigin: h.checkOrigin, Subprotocols: []string{wsAuthProtocol}}
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("ws upgrade: %v", err)
+		slog.WarnContext(r.Context(), "ws upgrade", "err", err)
 		return
 	}
-	client := &Client{hub: h, conn: conn, send: make(chan []byte, 256)}
+	version, _ := strconv.Atoi(r.URL.Query().Get("v"))
+	client := &Client{hub: h, conn: conn, send: make(chan []byte, 256), version: version, runID: r.URL.Query().Get("run"), done: make(chan struct{})}
+	// Queued before the client is registered, so nothing overtakes it
+	if h.hello != nil {
+		client.send <- client.message(encodeFrame(h.hello()))
+	}
 	h.register <- client
 
 	go client.writePump()
@@ -76,10 +277,15 @@ func (c *Client) writePump() {
 	defer func() {
 		c.hub.unregister <- c
 		_ = c.conn.Close()
+		close(c.done)
 	}()
 	for msg := range c.send {
 		if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
 			return
 		}
 	}
+	if c.hub.closing.Load() {
+		bye := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+		_ = c.conn.WriteControl(websocket.CloseMessage, bye, time.Now().Add(closeWait))
+	}
 }
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"simstack/internal/schema"
+)
+
+// yamlMediaTypes are the Content-Types of request bodies sent as YAML.
+var yamlMediaTypes = map[string]bool{
+	"application/x-yaml": true,
+	"application/yaml":   true,
+	"text/yaml":          true,
+}
+
+// isYAML reports whether r's body is YAML.
+func isYAML(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && yamlMediaTypes[mediaType]
+}
+
+// maxYAMLNodes bounds the nodes a YAML body may expand to. Aliases are
+// expanded where they are used, so a small body can otherwise nest them into
+// billions of nodes.
+const maxYAMLNodes = 10000
+
+// yamlDoc is a YAML request body, kept to locate what the JSON it converts
+// to is faulted for.
+type yamlDoc struct {
+	root *yaml.Node
+}
+
+// decodeYAML converts a YAML body to the JSON the handlers validate and
+// decode. Its errors carry the line of the fault.
+func decodeYAML(body []byte) ([]byte, yamlDoc, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(body, &doc); err != nil {
+		return nil, yamlDoc{}, errors.New(strings.TrimPrefix(err.Error(), "yaml: "))
+	}
+	if doc.Kind == 0 {
+		return nil, yamlDoc{}, errors.New("empty document")
+	}
+	d := yamlDoc{root: &doc}
+	if doc.Kind == yaml.DocumentNode && len(doc.Content) == 1 {
+		d.root = doc.Content[0]
+	}
+	if d.root.Kind != yaml.MappingNode {
+		return nil, d, fmt.Errorf("line %d: expected a mapping at the top level", d.root.Line)
+	}
+	budget := maxYAMLNodes
+	v, err := yamlValue(d.root, &budget)
+	if err != nil {
+		return nil, d, err
+	}
+	b, err := json.Marshal(v)
+	return b, d, err
+}
+
+// yamlValue returns n as the value JSON would decode it to, spending one of
+// budget's nodes on each it expands.
+func yamlValue(n *yaml.Node, budget *int) (any, error) {
+	if *budget--; *budget < 0 {
+		return nil, fmt.Errorf("line %d, column %d: document expands to more than %d nodes", n.Line, n.Column, maxYAMLNodes)
+	}
+	switch n.Kind {
+	case yaml.AliasNode:
+		return yamlValue(n.Alias, budget)
+	case yaml.MappingNode:
+		m := make(map[string]any, len(n.Content)/2)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, v := n.Content[i], n.Content[i+1]
+			if k.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("line %d, column %d: keys must be strings", k.Line, k.Column)
+			}
+			if _, dup := m[k.Value]; dup {
+				return nil, fmt.Errorf("line %d, column %d: field %s given twice", k.Line, k.Column, k.Value)
+			}
+			val, err := yamlValue(v, budget)
+			if err != nil {
+				return nil, err
+			}
+			m[k.Value] = val
+		}
+		return m, nil
+	case yaml.SequenceNode:
+		s := make([]any, len(n.Content))
+		for i, item := range n.Content {
+			val, err := yamlValue(item, budget)
+			if err != nil {
+				return nil, err
+			}
+			s[i] = val
+		}
+		return s, nil
+	case yaml.ScalarNode:
+		if n.Tag == "!!timestamp" {
+			return n.Value, nil
+		}
+		var v any
+		if err := n.Decode(&v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+	return nil, fmt.Errorf("line %d, column %d: unsupported YAML node", n.Line, n.Column)
+}
+
+// locate returns the line and column of the value at JSON Pointer pointer,
+// or of its key when the value is a mapping's; zero when it isn't there.
+func (d yamlDoc) locate(pointer string) (line, column int) {
+	n := d.root
+	if n == nil {
+		return 0, 0
+	}
+	at := n
+	for _, token := range strings.Split(pointer, "/")[1:] {
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		for n.Kind == yaml.AliasNode {
+			n = n.Alias
+		}
+		switch n.Kind {
+		case yaml.MappingNode:
+			found := false
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				if n.Content[i].Value == token {
+					at, n, found = n.Content[i], n.Content[i+1], true
+					break
+				}
+			}
+			if !found {
+				return at.Line, at.Column
+			}
+		case yaml.SequenceNode:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(n.Content) {
+				return at.Line, at.Column
+			}
+			at, n = n.Content[i], n.Content[i]
+		default:
+			return at.Line, at.Column
+		}
+	}
+	return at.Line, at.Column
+}
+
+// locateErrors places each schema violation in the YAML.
+func (d yamlDoc) locateErrors(invalid *schema.ValidationError) {
+	for i := range invalid.Errors {
+		invalid.Errors[i].Line, invalid.Errors[i].Column = d.locate(invalid.Errors[i].Pointer)
+	}
+}
+
+// decodeStrict decodes the JSON a YAML body converted to into v, rejecting
+// fields v has no place for with where they are in the YAML.
+func (d yamlDoc) decodeStrict(body []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if err == nil {
+		return nil
+	}
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		field, _ = strconv.Unquote(field)
+		line, column := d.locate("/" + strings.NewReplacer("~", "~0", "/", "~1").Replace(field))
+		return fmt.Errorf("line %d, column %d: unknown field %s", line, column, field)
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		line, column := d.locate("/" + strings.ReplaceAll(typeErr.Field, ".", "/"))
+		return fmt.Errorf("line %d, column %d: %s must be %s, not %s", line, column, typeErr.Field, typeErr.Type, typeErr.Value)
+	}
+	return err
+}
@@ -0,0 +1,136 @@
+// Package simcache keeps simulator responses by the call that produced them,
+// so a parameter set already measured, in this run or an earlier one, is not
+// simulated again.
+package simcache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Key identifies a simulator call: the simulator, where it was reached, a
+// canonical hash of its parameters and the seed of a stochastic call.
+type Key struct {
+	Tool string
+	URL  string
+	// Hex SHA-256 of the parameters as JSON, keys sorted
+	Params string
+	// Empty for deterministic simulators, whose answer doesn't depend on it
+	Seed string
+}
+
+// NewKey returns the key of a call to tool at url with params. seed is nil
+// for deterministic simulators.
+func NewKey(tool, url string, params map[string]any, seed any) Key {
+	// encoding/json sorts map keys, so equal parameter sets hash alike
+	body, _ := json.Marshal(params)
+	sum := sha256.Sum256(body)
+	k := Key{Tool: tool, URL: url, Params: hex.EncodeToString(sum[:])}
+	if seed != nil {
+		k.Seed = fmt.Sprint(seed)
+	}
+	return k
+}
+
+// Entry is a cached simulator response.
+type Entry struct {
+	Metrics map[string]float64
+	// The response body, kept for artifacts
+	Raw []byte
+}
+
+// Cache holds up to a bounded number of entries, evicting the least
+// recently used. It is safe for concurrent use.
+type Cache struct {
+	ttl time.Duration
+	max int
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[Key]*list.Element
+	// Most recently used first
+	order *list.List
+}
+
+type item struct {
+	key   Key
+	entry Entry
+	// Zero for entries that never expire
+	expires time.Time
+}
+
+// New returns a cache of at most max entries whose expiring entries live for
+// ttl. A max below 1 disables it: nothing is kept.
+func New(ttl time.Duration, max int) *Cache {
+	return &Cache{ttl: ttl, max: max, now: time.Now, entries: map[Key]*list.Element{}, order: list.New()}
+}
+
+// Enabled reports whether the cache keeps anything.
+func (c *Cache) Enabled() bool {
+	return c != nil && c.max > 0
+}
+
+// Get returns the entry kept for k, unless it has expired.
+func (c *Cache) Get(k Key) (Entry, bool) {
+	if !c.Enabled() {
+		return Entry{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[k]
+	if !ok {
+		return Entry{}, false
+	}
+	it := el.Value.(*item)
+	if !it.expires.IsZero() && !c.now().Before(it.expires) {
+		c.order.Remove(el)
+		delete(c.entries, k)
+		return Entry{}, false
+	}
+	c.order.MoveToFront(el)
+	return it.entry, true
+}
+
+// Put keeps e for k, for the cache's TTL unless forever is set.
+func (c *Cache) Put(k Key, e Entry, forever bool) {
+	if !c.Enabled() {
+		return
+	}
+	it := &item{key: k, entry: e}
+	if !forever {
+		if c.ttl <= 0 {
+			return
+		}
+		it.expires = c.now().Add(c.ttl)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[k]; ok {
+		el.Value = it
+		c.order.MoveToFront(el)
+		return
+	}
+	// Room is made first, so the entry being written is never the one to go
+	for c.order.Len() >= c.max {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*item).key)
+	}
+	c.entries[k] = c.order.PushFront(it)
+}
+
+// Len returns the number of entries kept, expired ones included until they
+// are next looked up or evicted.
+func (c *Cache) Len() int {
+	if !c.Enabled() {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
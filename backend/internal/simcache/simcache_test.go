@@ -0,0 +1,76 @@
+package simcache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeyIsCanonical(t *testing.T) {
+	a := NewKey("queue", "http://q", map[string]any{"arrival_rate": 4.0, "service_rate": 5.0}, nil)
+	b := NewKey("queue", "http://q", map[string]any{"service_rate": 5, "arrival_rate": 4}, nil)
+	if a != b {
+		t.Errorf("expected equal parameter sets to share a key, got %+v and %+v", a, b)
+	}
+	if c := NewKey("queue", "http://q", map[string]any{"arrival_rate": 4.5, "service_rate": 5.0}, nil); c == a {
+		t.Error("expected different parameters to get a different key")
+	}
+	if c := NewKey("queue", "http://other", map[string]any{"arrival_rate": 4.0, "service_rate": 5.0}, nil); c == a {
+		t.Error("expected a repointed simulator to get a different key")
+	}
+	if s1, s2 := NewKey("queue", "http://q", nil, 1), NewKey("queue", "http://q", nil, 2); s1 == s2 || s1.Seed != "1" {
+		t.Errorf("expected the seed in the key, got %+v and %+v", s1, s2)
+	}
+}
+
+func TestExpiryAndBound(t *testing.T) {
+	c := New(time.Minute, 2)
+	clock := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	c.now = func() time.Time { return clock }
+	stochastic, deterministic, other := Key{Tool: "s", Seed: "1"}, Key{Tool: "d"}, Key{Tool: "o"}
+
+	c.Put(stochastic, Entry{Metrics: map[string]float64{"x": 1}}, false)
+	c.Put(deterministic, Entry{Metrics: map[string]float64{"x": 2}}, true)
+	clock = clock.Add(time.Hour)
+	if _, ok := c.Get(stochastic); ok {
+		t.Error("expected the stochastic entry expired")
+	}
+	if e, ok := c.Get(deterministic); !ok || e.Metrics["x"] != 2 {
+		t.Errorf("expected the deterministic entry kept, got %+v", e)
+	}
+
+	c.Put(stochastic, Entry{}, false)
+	c.Get(deterministic)
+	c.Put(other, Entry{}, false)
+	if _, ok := c.Get(stochastic); ok || c.Len() != 2 {
+		t.Errorf("expected the least recently used entry evicted, %d kept", c.Len())
+	}
+
+	off := New(time.Minute, 0)
+	off.Put(other, Entry{}, true)
+	if _, ok := off.Get(other); ok || off.Enabled() {
+		t.Error("expected a zero-entry cache to keep nothing")
+	}
+}
+
+// A full cache makes room before it writes, so the entry just put is
+// always there, down to a cache of one.
+func TestPutKeepsNewEntryWhenFull(t *testing.T) {
+	for _, max := range []int{1, 3} {
+		c := New(time.Minute, max)
+		var keys []Key
+		for i := range 5 {
+			k := Key{Tool: "t", Params: string(rune('a' + i))}
+			keys = append(keys, k)
+			c.Put(k, Entry{Metrics: map[string]float64{"i": float64(i)}}, false)
+			if e, ok := c.Get(k); !ok || e.Metrics["i"] != float64(i) {
+				t.Fatalf("max %d: expected entry %d kept once put, got %+v %v", max, i, e, ok)
+			}
+			if c.Len() > max {
+				t.Fatalf("max %d: expected at most %d entries, got %d", max, max, c.Len())
+			}
+		}
+		if _, ok := c.Get(keys[0]); ok {
+			t.Errorf("max %d: expected the oldest entry evicted", max)
+		}
+	}
+}
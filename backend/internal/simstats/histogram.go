@@ -0,0 +1,99 @@
+// Package simstats tracks simulator calls: latency percentiles, call and
+// error counts, and a circuit breaker per tool. Recording is lock-free so it
+// can sit on every call.
+package simstats
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// Histogram buckets are powers of gamma in microseconds, so any value is
+// reported within (gamma-1)/2 = 1% of itself. 1100 buckets reach ~48 minutes;
+// the last one collects anything longer and reports the maximum.
+const (
+	gamma      = 1.02
+	numBuckets = 1100
+)
+
+var logGamma = math.Log(gamma)
+
+// Histogram is a streaming latency estimator: a log-bucketed histogram with
+// atomic counters. The zero value is ready to use.
+type Histogram struct {
+	buckets [numBuckets]atomic.Uint64
+	count   atomic.Uint64
+	maxUs   atomic.Int64
+}
+
+// Observe records one duration.
+func (h *Histogram) Observe(d time.Duration) {
+	us := d.Microseconds()
+	h.buckets[bucketOf(us)].Add(1)
+	h.count.Add(1)
+	for {
+		cur := h.maxUs.Load()
+		if us <= cur || h.maxUs.CompareAndSwap(cur, us) {
+			break
+		}
+	}
+}
+
+// Count returns how many durations were observed.
+func (h *Histogram) Count() uint64 {
+	return h.count.Load()
+}
+
+// Quantile estimates the q-quantile (0 < q <= 1) by nearest rank, or 0 when
+// nothing was observed. Concurrent Observe calls may or may not be counted.
+func (h *Histogram) Quantile(q float64) time.Duration {
+	total := h.count.Load()
+	if total == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(total)))
+	if rank < 1 {
+		rank = 1
+	}
+	var seen uint64
+	for i := range h.buckets {
+		seen += h.buckets[i].Load()
+		if seen >= rank && i < numBuckets-1 {
+			return h.clamp(valueOf(i))
+		}
+	}
+	return time.Duration(h.maxUs.Load()) * time.Microsecond
+}
+
+// clamp keeps an estimate from exceeding the largest observed value.
+func (h *Histogram) clamp(d time.Duration) time.Duration {
+	if limit := time.Duration(h.maxUs.Load()) * time.Microsecond; d > limit {
+		return limit
+	}
+	return d
+}
+
+// bucketOf maps a duration in microseconds to the bucket whose range
+// (gamma^(i-1), gamma^i] holds it; sub-microsecond values share bucket 0.
+func bucketOf(us int64) int {
+	if us <= 1 {
+		return 0
+	}
+	i := int(math.Ceil(math.Log(float64(us)) / logGamma))
+	if i >= numBuckets {
+		return numBuckets - 1
+	}
+	return i
+}
+
+// valueOf is the midpoint of bucket i's range, the estimate minimizing the
+// worst relative error.
+func valueOf(i int) time.Duration {
+	if i == 0 {
+		return time.Microsecond
+	}
+	upper := math.Pow(gamma, float64(i))
+	us := upper * 2 / (1 + gamma)
+	return time.Duration(us * float64(time.Microsecond))
+}
@@ -0,0 +1,163 @@
+package simstats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"simstack/internal/types"
+)
+
+// The estimator must stay within its 1% bound (plus a microsecond of
+// truncation) of exact nearest-rank percentiles.
+func TestHistogramMatchesExactPercentiles(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	distributions := map[string]func() time.Duration{
+		"uniform":     func() time.Duration { return time.Duration(rng.Float64() * float64(2*time.Second)) },
+		"exponential": func() time.Duration { return time.Duration(rng.ExpFloat64() * float64(80*time.Millisecond)) },
+		"lognormal": func() time.Duration {
+			return time.Duration(math.Exp(rng.NormFloat64()*1.2+3) * float64(time.Millisecond))
+		},
+		"bimodal": func() time.Duration {
+			if rng.Intn(10) == 0 {
+				return time.Duration(5+rng.Float64()) * time.Second
+			}
+			return time.Duration(20+rng.Float64()*10) * time.Millisecond
+		},
+	}
+	for name, sample := range distributions {
+		var h Histogram
+		values := make([]time.Duration, 50000)
+		for i := range values {
+			values[i] = sample()
+			h.Observe(values[i])
+		}
+		sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+		for _, q := range []float64{0.5, 0.95, 0.99} {
+			exact := values[int(math.Ceil(q*float64(len(values))))-1]
+			got := h.Quantile(q)
+			if diff := math.Abs(float64(got - exact)); diff > 0.01*float64(exact)+float64(time.Microsecond) {
+				t.Errorf("%s p%.0f: estimated %s, exact %s", name, q*100, got, exact)
+			}
+		}
+		if h.Count() != uint64(len(values)) {
+			t.Errorf("%s: expected %d observations, got %d", name, len(values), h.Count())
+		}
+	}
+}
+
+func TestHistogramEdges(t *testing.T) {
+	var h Histogram
+	if h.Quantile(0.5) != 0 {
+		t.Error("an empty histogram should report 0")
+	}
+	h.Observe(0)
+	h.Observe(10 * time.Hour) // past the last bucket
+	if got := h.Quantile(1); got != 10*time.Hour {
+		t.Errorf("the maximum should be reported exactly, got %s", got)
+	}
+	if got := h.Quantile(0.5); got > time.Microsecond {
+		t.Errorf("expected the sub-microsecond value, got %s", got)
+	}
+}
+
+func TestHistogramConcurrentObserve(t *testing.T) {
+	var h Histogram
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				h.Observe(time.Millisecond)
+			}
+		}()
+	}
+	wg.Wait()
+	if h.Count() != 8000 {
+		t.Errorf("expected 8000 observations, got %d", h.Count())
+	}
+}
+
+func TestBreakerTransitions(t *testing.T) {
+	now := time.Unix(1000, 0)
+	tr := NewTracker(3, 30*time.Second)
+	tr.now = func() time.Time { return now }
+	boom := errors.New("boom")
+	state := func() types.BreakerState { return tr.Snapshot()[0].Breaker }
+
+	tr.Record("traffic", time.Millisecond, boom)
+	tr.Record("traffic", time.Millisecond, boom)
+	if !tr.Allow("traffic") || state() != types.BreakerClosed {
+		t.Fatal("two failures should not open a breaker with threshold 3")
+	}
+	tr.Record("traffic", time.Millisecond, boom)
+	if tr.Allow("traffic") || state() != types.BreakerOpen {
+		t.Fatal("the third consecutive failure should open the breaker")
+	}
+
+	now = now.Add(31 * time.Second)
+	if state() != types.BreakerHalfOpen {
+		t.Errorf("expected half-open after the cooldown, got %s", state())
+	}
+	if !tr.Allow("traffic") || tr.Allow("traffic") {
+		t.Fatal("half-open should let exactly one trial call through")
+	}
+	tr.Record("traffic", time.Millisecond, boom)
+	if tr.Allow("traffic") || state() != types.BreakerOpen {
+		t.Fatal("a failed trial should reopen the breaker")
+	}
+
+	now = now.Add(31 * time.Second)
+	tr.Allow("traffic")
+	tr.Record("traffic", time.Millisecond, nil)
+	if !tr.Allow("traffic") || state() != types.BreakerClosed {
+		t.Fatal("a successful trial should close the breaker")
+	}
+
+	s := tr.Snapshot()[0]
+	if s.Calls != 5 || s.Errors != 4 || s.ErrorRate != 0.8 || s.Rejected != 3 || s.Window != types.StatsSinceStart {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
+
+func TestBreakerIgnoresCanceledCalls(t *testing.T) {
+	now := time.Unix(1000, 0)
+	tr := NewTracker(2, 30*time.Second)
+	tr.now = func() time.Time { return now }
+	canceled := fmt.Errorf("post: %w", context.Canceled)
+	for i := 0; i < 5; i++ {
+		tr.Record("queue", time.Millisecond, canceled)
+	}
+	if s := tr.Snapshot()[0]; !tr.Allow("queue") || s.Calls != 0 || s.Errors != 0 || s.Breaker != types.BreakerClosed {
+		t.Fatalf("expected canceled calls left out, got %+v", s)
+	}
+
+	tr.Record("queue", time.Millisecond, errors.New("boom"))
+	tr.Record("queue", time.Millisecond, errors.New("boom"))
+	now = now.Add(31 * time.Second)
+	if !tr.Allow("queue") {
+		t.Fatal("expected the trial call let through")
+	}
+	// The trial's caller gave up; the next call may try instead
+	tr.Record("queue", time.Millisecond, canceled)
+	if !tr.Allow("queue") {
+		t.Error("expected a canceled trial to free the trial call")
+	}
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	tr := NewTracker(0, time.Second)
+	for i := 0; i < 100; i++ {
+		tr.Record("queue", time.Millisecond, errors.New("boom"))
+	}
+	if !tr.Allow("queue") {
+		t.Error("threshold 0 must never open the breaker")
+	}
+}
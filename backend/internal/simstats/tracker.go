@@ -0,0 +1,146 @@
+package simstats
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"simstack/internal/types"
+)
+
+// ErrCircuitOpen is returned for calls skipped while a tool's breaker is open.
+var ErrCircuitOpen = errors.New("simulator circuit breaker open")
+
+// Tracker keeps per-tool call statistics since it was created. A tool's
+// breaker opens after threshold consecutive failures (never if threshold is
+// 0), rejects calls for cooldown, then lets one trial call through: success
+// closes it, failure opens it again.
+type Tracker struct {
+	since     time.Time
+	threshold int64
+	cooldown  time.Duration
+	tools     sync.Map // tool name -> *toolStats
+	now       func() time.Time
+}
+
+type toolStats struct {
+	latency     Histogram
+	calls       atomic.Uint64
+	errors      atomic.Uint64
+	rejected    atomic.Uint64
+	consecutive atomic.Int64
+	openedAt    atomic.Int64 // unix nanoseconds; 0 when closed
+	trial       atomic.Bool  // a half-open trial call is in flight
+}
+
+func NewTracker(threshold int, cooldown time.Duration) *Tracker {
+	return &Tracker{since: time.Now().UTC(), threshold: int64(threshold), cooldown: cooldown, now: time.Now}
+}
+
+func (t *Tracker) stats(tool string) *toolStats {
+	if s, ok := t.tools.Load(tool); ok {
+		return s.(*toolStats)
+	}
+	s, _ := t.tools.LoadOrStore(tool, &toolStats{})
+	return s.(*toolStats)
+}
+
+// Allow reports whether a call to tool may go ahead. Every allowed call must
+// be followed by Record.
+func (t *Tracker) Allow(tool string) bool {
+	s := t.stats(tool)
+	opened := s.openedAt.Load()
+	if opened == 0 {
+		return true
+	}
+	if t.now().UnixNano() >= opened+int64(t.cooldown) && s.trial.CompareAndSwap(false, true) {
+		return true
+	}
+	s.rejected.Add(1)
+	return false
+}
+
+// Closed reports whether tool's breaker is closed. Unlike Allow, it never
+// takes the trial call of a half-open breaker.
+func (t *Tracker) Closed(tool string) bool {
+	s, ok := t.tools.Load(tool)
+	return !ok || s.(*toolStats).openedAt.Load() == 0
+}
+
+// Record adds one call's duration and outcome. A call its caller canceled
+// says nothing about the tool: it is left out, and only frees the trial
+// call it may have held.
+func (t *Tracker) Record(tool string, d time.Duration, err error) {
+	s := t.stats(tool)
+	if errors.Is(err, context.Canceled) {
+		s.trial.Store(false)
+		return
+	}
+	s.latency.Observe(d)
+	s.calls.Add(1)
+	defer s.trial.Store(false)
+	if err == nil {
+		s.consecutive.Store(0)
+		s.openedAt.Store(0)
+		return
+	}
+	s.errors.Add(1)
+	failures := s.consecutive.Add(1)
+	if t.threshold > 0 && (failures >= t.threshold || s.openedAt.Load() != 0) {
+		s.openedAt.Store(t.now().UnixNano())
+	}
+}
+
+// Reset closes tool's breaker, as a successful call would, without
+// counting a call.
+func (t *Tracker) Reset(tool string) {
+	s := t.stats(tool)
+	s.consecutive.Store(0)
+	s.openedAt.Store(0)
+}
+
+// Snapshot returns every tool's statistics, sorted by tool.
+func (t *Tracker) Snapshot() []types.SimulatorStats {
+	var out []types.SimulatorStats
+	t.tools.Range(func(key, value any) bool {
+		s := value.(*toolStats)
+		stats := types.SimulatorStats{
+			Tool:     key.(string),
+			Calls:    s.calls.Load(),
+			Errors:   s.errors.Load(),
+			Rejected: s.rejected.Load(),
+			P50Ms:    ms(s.latency.Quantile(0.50)),
+			P95Ms:    ms(s.latency.Quantile(0.95)),
+			P99Ms:    ms(s.latency.Quantile(0.99)),
+			Breaker:  t.state(s),
+			Window:   types.StatsSinceStart,
+			Since:    t.since,
+		}
+		if stats.Calls > 0 {
+			stats.ErrorRate = float64(stats.Errors) / float64(stats.Calls)
+		}
+		out = append(out, stats)
+		return true
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].Tool < out[j].Tool })
+	return out
+}
+
+func (t *Tracker) state(s *toolStats) types.BreakerState {
+	opened := s.openedAt.Load()
+	switch {
+	case opened == 0:
+		return types.BreakerClosed
+	case t.now().UnixNano() >= opened+int64(t.cooldown):
+		return types.BreakerHalfOpen
+	default:
+		return types.BreakerOpen
+	}
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
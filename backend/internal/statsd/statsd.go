@@ -0,0 +1,165 @@
+// Package statsd pushes counters and timings to a StatsD or DogStatsD agent
+// over UDP. Metrics are buffered and sent a packet at a time, when a packet
+// fills or on a flush interval. A nil *Client drops everything, so callers
+// need not check whether one is configured.
+package statsd
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config is where and how to send metrics.
+type Config struct {
+	// Agent address, host:port; empty sends nothing
+	Addr string
+	// Prepended to every metric name, e.g. "simstack."
+	Prefix string
+	// Send DogStatsD tags; plain StatsD agents don't take them
+	Tags bool
+	// Buffered metrics are sent at least this often
+	FlushInterval time.Duration
+	// Largest packet sent; the default suits an Ethernet MTU
+	MaxPacketBytes int
+}
+
+// DefaultMaxPacketBytes fits a packet in a 1500 byte MTU after IP and UDP
+// headers.
+const DefaultMaxPacketBytes = 1432
+
+// Client buffers metrics and sends them to an agent. It is safe for
+// concurrent use.
+type Client struct {
+	conn   net.Conn
+	prefix string
+	tags   bool
+	max    int
+
+	mu  sync.Mutex
+	buf []byte
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New connects to cfg's agent and starts flushing on its interval. It
+// returns nil, and no error, when cfg has no address.
+func New(cfg Config) (*Client, error) {
+	if cfg.Addr == "" {
+		return nil, nil
+	}
+	conn, err := net.Dial("udp", cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		conn:   conn,
+		prefix: cfg.Prefix,
+		tags:   cfg.Tags,
+		max:    cfg.MaxPacketBytes,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if c.max <= 0 {
+		c.max = DefaultMaxPacketBytes
+	}
+	interval := cfg.FlushInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	go c.loop(interval)
+	return c, nil
+}
+
+func (c *Client) loop(interval time.Duration) {
+	defer close(c.done)
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			c.Flush()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// Count adds n to the counter name. Tags are "key:value" pairs.
+func (c *Client) Count(name string, n int64, tags ...string) {
+	c.add(name, strconv.FormatInt(n, 10), "c", tags)
+}
+
+// Timing records a duration of name, in milliseconds.
+func (c *Client) Timing(name string, d time.Duration, tags ...string) {
+	c.add(name, strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', -1, 64), "ms", tags)
+}
+
+// Characters that would break a line of the protocol
+// Observe records a sample of name as a histogram.
+func (c *Client) Observe(name string, v float64, tags ...string) {
+	c.add(name, strconv.FormatFloat(v, 'f', -1, 64), "h", tags)
+}
+
+var (
+	nameReplacer = strings.NewReplacer(":", "_", "|", "_", "@", "_", "#", "_", ",", "_", "\n", "_")
+	tagReplacer  = strings.NewReplacer("|", "_", "@", "_", "#", "_", ",", "_", "\n", "_")
+)
+
+func (c *Client) add(name, value, typ string, tags []string) {
+	if c == nil {
+		return
+	}
+	line := c.prefix + nameReplacer.Replace(name) + ":" + value + "|" + typ
+	if c.tags && len(tags) > 0 {
+		clean := make([]string, len(tags))
+		for i, t := range tags {
+			clean[i] = tagReplacer.Replace(t)
+		}
+		line += "|#" + strings.Join(clean, ",")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.buf) > 0 && len(c.buf)+1+len(line) > c.max {
+		c.flushLocked()
+	}
+	if len(c.buf) > 0 {
+		c.buf = append(c.buf, '\n')
+	}
+	c.buf = append(c.buf, line...)
+}
+
+// Flush sends whatever is buffered.
+func (c *Client) Flush() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.flushLocked()
+}
+
+func (c *Client) flushLocked() {
+	if len(c.buf) == 0 {
+		return
+	}
+	// Metrics are best effort: an absent agent must not disturb anything
+	_, _ = c.conn.Write(c.buf)
+	c.buf = c.buf[:0]
+}
+
+// Close stops the flush loop, sends what is buffered and closes the
+// connection.
+func (c *Client) Close() error {
+	if c == nil {
+		return nil
+	}
+	close(c.stop)
+	<-c.done
+	c.Flush()
+	return c.conn.Close()
+}
@@ -0,0 +1,97 @@
+package statsd
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listen opens a loopback UDP listener standing in for the agent.
+func listen(t *testing.T) net.PacketConn {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	return pc
+}
+
+// packets reads the datagrams sent to pc until none arrives for a moment.
+func packets(t *testing.T, pc net.PacketConn) []string {
+	t.Helper()
+	var out []string
+	buf := make([]byte, 65536)
+	for {
+		pc.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			return out
+		}
+		out = append(out, string(buf[:n]))
+	}
+}
+
+func TestClientSendsDogStatsDLines(t *testing.T) {
+	pc := listen(t)
+	c, err := New(Config{Addr: pc.LocalAddr().String(), Prefix: "simstack.", Tags: true, FlushInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Count("run.completed", 1, "status:failed")
+	c.Timing("simulator.call.duration", 1500*time.Microsecond, "tool:queue", "outcome:success")
+	c.Count("odd|name:here", 2, "goal:a,b")
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "simstack.run.completed:1|c|#status:failed\n" +
+		"simstack.simulator.call.duration:1.5|ms|#tool:queue,outcome:success\n" +
+		"simstack.odd_name_here:2|c|#goal:a_b"
+	if got := packets(t, pc); len(got) != 1 || got[0] != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestClientWithoutTags(t *testing.T) {
+	pc := listen(t)
+	c, _ := New(Config{Addr: pc.LocalAddr().String(), FlushInterval: time.Hour})
+	c.Count("run.started", 1, "status:completed")
+	c.Close()
+	if got := packets(t, pc); len(got) != 1 || got[0] != "run.started:1|c" {
+		t.Errorf("expected a plain StatsD line, got %q", got)
+	}
+}
+
+func TestClientSplitsPacketsAndFlushesOnInterval(t *testing.T) {
+	pc := listen(t)
+	c, _ := New(Config{Addr: pc.LocalAddr().String(), FlushInterval: 20 * time.Millisecond, MaxPacketBytes: 64})
+	defer c.Close()
+	// 29 bytes a line, so two fit in a packet
+	for i := 0; i < 5; i++ {
+		c.Count("llm.tokens.abcdefghijkl", 100)
+	}
+	got := packets(t, pc)
+	if len(got) != 3 {
+		t.Fatalf("expected 3 packets, got %q", got)
+	}
+	for _, p := range got {
+		if len(p) > 64 || !strings.HasPrefix(p, "llm.tokens.abcdefghijkl:100|c") {
+			t.Errorf("unexpected packet %q", p)
+		}
+	}
+}
+
+func TestUnconfiguredClientIsNoOp(t *testing.T) {
+	c, err := New(Config{})
+	if c != nil || err != nil {
+		t.Fatalf("expected no client, got %v, %v", c, err)
+	}
+	c.Count("run.started", 1)
+	c.Timing("planner.latency", time.Second)
+	c.Flush()
+	if err := c.Close(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,30 @@
+package testsupport
+
+import (
+	"testing"
+
+	"simstack/internal/cassette"
+	"simstack/internal/cerebras"
+)
+
+// CassetteClient returns a client that replays the cassette at path. Given
+// live, a client for a real provider, it instead records fresh interactions
+// through that.
+func CassetteClient(t testing.TB, path string, live *cerebras.Client) *cerebras.Client {
+	t.Helper()
+	mode := cassette.ModeReplay
+	if live != nil {
+		mode = cassette.ModeRecord
+	}
+	rec, err := cassette.New(path, mode, nil)
+	if err != nil {
+		t.Fatalf("cassette: %v", err)
+	}
+
+	c := cerebras.NewClient("http://cassette.invalid/v1", "test")
+	if live != nil {
+		c = live
+	}
+	c.SetTransport(rec)
+	return c
+}
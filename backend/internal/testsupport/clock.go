@@ -0,0 +1,153 @@
+package testsupport
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"simstack/internal/clock"
+)
+
+// FakeClock is a clock.Clock that only moves when a test advances it. Its
+// timers and tickers fire as Advance passes their time.
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*fakeTimer
+	// Closed and replaced whenever a timer is added, to wake BlockUntil
+	changed chan struct{}
+}
+
+// NewFakeClock returns a fake clock reading now.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now, changed: make(chan struct{})}
+}
+
+type fakeTimer struct {
+	clock  *FakeClock
+	c      chan time.Time
+	f      func()
+	at     time.Time
+	period time.Duration
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) NewTimer(d time.Duration) clock.Timer {
+	return c.add(&fakeTimer{c: make(chan time.Time, 1)}, d)
+}
+
+func (c *FakeClock) NewTicker(d time.Duration) clock.Ticker {
+	if d <= 0 {
+		panic("testsupport: non-positive interval for NewTicker")
+	}
+	return fakeTicker{c.add(&fakeTimer{c: make(chan time.Time, 1), period: d}, d)}
+}
+
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) clock.Timer {
+	return c.add(&fakeTimer{f: f}, d)
+}
+
+func (c *FakeClock) add(t *fakeTimer, d time.Duration) *fakeTimer {
+	t.clock = c
+	t.Reset(d)
+	return t
+}
+
+// Advance moves the clock d forward, firing every timer and tick due on the
+// way in order.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	end := c.now.Add(d)
+	for len(c.waiters) > 0 && !c.waiters[0].at.After(end) {
+		t := c.waiters[0]
+		c.now = t.at
+		if t.period > 0 {
+			t.at = t.at.Add(t.period)
+			c.sort()
+		} else {
+			c.waiters = c.waiters[1:]
+		}
+		t.fire(c.now)
+	}
+	c.now = end
+}
+
+// BlockUntil waits until n timers and tickers are waiting on the clock, so a
+// test advances it only once the code under test is waiting.
+func (c *FakeClock) BlockUntil(n int) {
+	for {
+		c.mu.Lock()
+		waiting, changed := len(c.waiters), c.changed
+		c.mu.Unlock()
+		if waiting >= n {
+			return
+		}
+		<-changed
+	}
+}
+
+func (c *FakeClock) sort() {
+	sort.SliceStable(c.waiters, func(i, j int) bool { return c.waiters[i].at.Before(c.waiters[j].at) })
+}
+
+// remove takes t off the clock, reporting whether it was waiting. c.mu
+// must be held.
+func (c *FakeClock) remove(t *fakeTimer) bool {
+	for i, w := range c.waiters {
+		if w == t {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (t *fakeTimer) fire(now time.Time) {
+	if t.f != nil {
+		go t.f()
+		return
+	}
+	select {
+	case t.c <- now:
+	default: // Like a real ticker, drop ticks nobody reads
+	}
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	return t.clock.remove(t)
+}
+
+type fakeTicker struct{ *fakeTimer }
+
+func (t fakeTicker) Stop() {
+	t.fakeTimer.Stop()
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	active := c.remove(t)
+	t.at = c.now.Add(d)
+	c.waiters = append(c.waiters, t)
+	c.sort()
+	close(c.changed)
+	c.changed = make(chan struct{})
+	return active
+}
@@ -0,0 +1,101 @@
+// Package testsupport holds fakes shared by package tests.
+package testsupport
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"simstack/internal/cerebras"
+	"simstack/internal/clock"
+)
+
+// Reply is one scripted answer: either a response or an error, optionally
+// after a delay that ends early if the call's context is done.
+type Reply struct {
+	Response map[string]any
+	Err      error
+	Delay    time.Duration
+}
+
+// FakeChat is a scriptable llm.ChatClient. Each call consumes the next reply
+// in order; running out of replies is an error so tests notice extra calls.
+type FakeChat struct {
+	mu       sync.Mutex
+	replies  []Reply
+	Requests []cerebras.OpenAIChatRequest
+	// Measures reply delays; the wall clock when nil
+	Clock clock.Clock
+}
+
+func NewFakeChat(replies ...Reply) *FakeChat {
+	return &FakeChat{replies: replies}
+}
+
+func (f *FakeChat) Name() string {
+	return "fake"
+}
+
+func (f *FakeChat) Chat(ctx context.Context, req cerebras.OpenAIChatRequest) (map[string]any, error) {
+	f.mu.Lock()
+	f.Requests = append(f.Requests, req)
+	if len(f.replies) == 0 {
+		n := len(f.Requests)
+		f.mu.Unlock()
+		return nil, fmt.Errorf("testsupport: unexpected chat call %d", n)
+	}
+	r := f.replies[0]
+	f.replies = f.replies[1:]
+	f.mu.Unlock()
+
+	if r.Delay > 0 {
+		select {
+		case <-clock.Or(f.Clock).After(r.Delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return r.Response, r.Err
+}
+
+// Calls returns how many chat calls have been made.
+func (f *FakeChat) Calls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.Requests)
+}
+
+// Content scripts a successful reply with the given message content.
+func Content(content string) Reply {
+	return Reply{Response: ChatResponse(content)}
+}
+
+// Truncated scripts a reply cut off by max_tokens (finish_reason "length").
+func Truncated(content string) Reply {
+	resp := ChatResponse(content)
+	resp["choices"].([]any)[0].(map[string]any)["finish_reason"] = "length"
+	return Reply{Response: resp}
+}
+
+// Slow delays a scripted reply by d.
+func Slow(r Reply, d time.Duration) Reply {
+	r.Delay = d
+	return r
+}
+
+// Error scripts a failed call.
+func Error(err error) Reply {
+	return Reply{Err: err}
+}
+
+// ChatResponse builds an OpenAI-shaped chat response with a usage block.
+func ChatResponse(content string) map[string]any {
+	return map[string]any{
+		"choices": []any{map[string]any{
+			"message":       map[string]any{"role": "assistant", "content": content},
+			"finish_reason": "stop",
+		}},
+		"usage": map[string]any{"prompt_tokens": 100.0, "completion_tokens": 50.0, "total_tokens": 150.0},
+	}
+}
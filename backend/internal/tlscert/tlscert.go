@@ -0,0 +1,113 @@
+// Package tlscert serves HTTPS with a certificate read from files that may
+// be replaced while the server runs, as Let's Encrypt renewals do. Reload
+// reads the files again; Watch does so whenever they change on disk.
+package tlscert
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Certificate is a key pair loaded from CertFile and KeyFile. It is safe for
+// concurrent use; handshakes always see a complete pair.
+type Certificate struct {
+	CertFile string
+	KeyFile  string
+
+	pair atomic.Pointer[tls.Certificate]
+	// Serializes reloads, and the files' modification times they read
+	mu              sync.Mutex
+	certMod, keyMod time.Time
+}
+
+// Load reads the key pair in certFile and keyFile, PEM-encoded; certFile
+// may hold intermediates after the leaf.
+func Load(certFile, keyFile string) (*Certificate, error) {
+	c := &Certificate{CertFile: certFile, KeyFile: keyFile}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Reload reads the files again. On an error the previous pair stays in use.
+func (c *Certificate) Reload() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	certMod, keyMod := modTime(c.CertFile), modTime(c.KeyFile)
+	pair, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return fmt.Errorf("tls: %w", err)
+	}
+	c.pair.Store(&pair)
+	c.certMod, c.keyMod = certMod, keyMod
+	return nil
+}
+
+// Watch reloads the pair every interval in which either file's modification
+// time changed, until ctx is done. Renewals that write the two files one
+// after the other may fail to load in between; the next tick loads them.
+func (c *Certificate) Watch(ctx context.Context, interval time.Duration) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+		if !c.changed() {
+			continue
+		}
+		if err := c.Reload(); err != nil {
+			slog.Warn("tls: certificate changed but didn't load; keeping the previous one", "err", err)
+			continue
+		}
+		slog.Info("tls: certificate reloaded", "cert", c.CertFile)
+	}
+}
+
+func (c *Certificate) changed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return !modTime(c.CertFile).Equal(c.certMod) || !modTime(c.KeyFile).Equal(c.keyMod)
+}
+
+// GetCertificate is for tls.Config.GetCertificate.
+func (c *Certificate) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.pair.Load(), nil
+}
+
+// Config returns a server configuration serving c: TLS 1.2 or later, and on
+// 1.2 only forward-secret AEAD suites. TLS 1.3 suites aren't configurable
+// and are all sound.
+func Config(c *Certificate) *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: c.GetCertificate,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+	}
+}
+
+// modTime is path's modification time, or zero when it can't be read.
+func modTime(path string) time.Time {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
@@ -0,0 +1,110 @@
+// Package tracing sets up OpenTelemetry for the backend. Without an exporter
+// the global provider stays the no-op default, so spans cost next to nothing.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Name is the instrumentation scope of every span the backend creates.
+const Name = "simstack"
+
+// Config selects the exporter. An empty Endpoint keeps tracing off.
+type Config struct {
+	// OTLP/HTTP collector URL, e.g. http://localhost:4318
+	Endpoint    string
+	ServiceName string
+	// Fraction of runs traced, 0 to 1
+	SampleRatio float64
+	// Exporters OTEL_TRACES_EXPORTER asks for that the backend doesn't
+	// have, such as console; Setup warns about them and goes on without
+	Unsupported []string
+}
+
+// Setup installs an OTLP exporter as the global tracer provider, and W3C
+// trace context as the propagator either way. The returned function flushes
+// and stops the exporter.
+func Setup(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	for _, name := range cfg.Unsupported {
+		slog.Warn("tracing: unsupported trace exporter ignored; only otlp is available", "exporter", name)
+	}
+	if cfg.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("tracing: OTLP exporter: %w", err)
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName(cfg.ServiceName)))
+	if err != nil {
+		return nil, fmt.Errorf("tracing: resource: %w", err)
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// Transport injects the trace context of each request's context into its
+// headers (traceparent), so the receiving service's spans join the trace.
+func Transport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &propagating{next: next}
+}
+
+type propagating struct {
+	next http.RoundTripper
+}
+
+func (p *propagating) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !trace.SpanContextFromContext(req.Context()).IsValid() {
+		return p.next.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	otel.GetTextMapPropagator().Inject(req.Context(), propagation.HeaderCarrier(req.Header))
+	return p.next.RoundTrip(req)
+}
+
+// Off is a span that does nothing. Work under a span that doesn't record
+// gets it rather than a child of its own: under the parent-based sampler
+// the child wouldn't record either, and building it and its attributes is
+// most of what a traced call allocates. The parent's trace context still
+// reaches outgoing requests.
+var Off = trace.SpanFromContext(context.Background())
+
+// GoalHash identifies a goal in span attributes without recording its text.
+func GoalHash(goal string) attribute.KeyValue {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(goal))
+	return attribute.String("simstack.goal_hash", fmt.Sprintf("%016x", h.Sum64()))
+}
+
+// RecordError marks span failed with err, if any.
+func RecordError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
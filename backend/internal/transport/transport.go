@@ -0,0 +1,78 @@
+// Package transport builds the shared, tuned HTTP transports used for LLM
+// and simulator traffic. Each is constructed once and reused so concurrent
+// runs keep connections (and TLS sessions) alive instead of churning them.
+package transport
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// NewLLM returns a transport for a handful of long-lived HTTPS connections to
+// the LLM provider, with HTTP/2 enabled.
+func NewLLM() *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          32,
+		MaxIdleConnsPerHost:   16,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+// NewSimulator returns a transport for many short requests to a few local
+// simulator hosts. maxPerHost should match how many variants run at once so
+// every worker can keep its connection idle between calls.
+func NewSimulator(maxPerHost int) *http.Transport {
+	if maxPerHost <= 0 {
+		maxPerHost = 64
+	}
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   5 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          maxPerHost * 4,
+		MaxIdleConnsPerHost:   maxPerHost,
+		IdleConnTimeout:       90 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+var llmTransport = NewLLM()
+
+// SharedLLM is the process-wide LLM transport used when none is injected.
+func SharedLLM() *http.Transport {
+	return llmTransport
+}
+
+// UserAgent sets the User-Agent header of each request that has none to ua.
+func UserAgent(next http.RoundTripper, ua string) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &userAgent{next: next, ua: ua}
+}
+
+type userAgent struct {
+	next http.RoundTripper
+	ua   string
+}
+
+func (u *userAgent) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") != "" {
+		return u.next.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", u.ua)
+	return u.next.RoundTrip(req)
+}
@@ -0,0 +1,76 @@
+package types
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// Artifact is a file produced during a run: a simulator's raw response, a
+// log, a report. Build them with artifacts.New so names are sanitized, sizes
+// capped and the hash filled in.
+type Artifact struct {
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	SizeBytes   int64  `json:"size_bytes"`
+	// Hex SHA-256 of the content, for checking downloads
+	SHA256 string         `json:"sha256"`
+	Origin ArtifactOrigin `json:"origin"`
+	// Where to fetch the content; set once the artifact is stored
+	StorageRef string    `json:"storage_ref,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// ArtifactOrigin says which run, variant and tool produced an artifact.
+// Artifacts of the run as a whole have no variant or tool.
+type ArtifactOrigin struct {
+	RunID     string `json:"run_id,omitempty"`
+	VariantID string `json:"variant_id,omitempty"`
+	Tool      string `json:"tool,omitempty"`
+}
+
+// Artifacts is a list of artifacts. It also decodes the legacy shape, an
+// object mapping names to storage refs, so old events and records still load.
+type Artifacts []Artifact
+
+func (a *Artifacts) UnmarshalJSON(data []byte) error {
+	var legacy map[string]string
+	if json.Unmarshal(data, &legacy) == nil {
+		*a = artifactsFromLegacy(legacy)
+		return nil
+	}
+	var list []Artifact
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// Legacy returns the version 1 serialization: name -> storage ref.
+func (a Artifacts) Legacy() map[string]string {
+	if len(a) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(a))
+	for _, art := range a {
+		m[art.Name] = art.StorageRef
+	}
+	return m
+}
+
+func artifactsFromLegacy(m map[string]string) Artifacts {
+	if len(m) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	out := make(Artifacts, 0, len(m))
+	for _, name := range names {
+		out = append(out, Artifact{Name: name, StorageRef: m[name]})
+	}
+	return out
+}
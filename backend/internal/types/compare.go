@@ -0,0 +1,56 @@
+package types
+
+// RunComparison is the numeric difference between two runs, A and B: what
+// went in and how their winners came out.
+type RunComparison struct {
+	A RunSide `json:"a"`
+	B RunSide `json:"b"`
+	// Inputs that differ, by name
+	Inputs []InputChange `json:"inputs"`
+	// Metrics both winners report, B against A
+	Metrics []MetricChange `json:"metrics"`
+}
+
+// RunSide is one run of a comparison.
+type RunSide struct {
+	RunID          string   `json:"run_id"`
+	Goal           string   `json:"goal"`
+	Status         string   `json:"status"`
+	Winner         string   `json:"winner,omitempty"`
+	Recommendation string   `json:"recommendation,omitempty"`
+	Variants       int      `json:"variants"`
+	FailedVariants int      `json:"failed_variants"`
+	CostUSD        *float64 `json:"cost_usd"`
+}
+
+// InputChange is an input, a run setting or a winner's parameter, that
+// differs between the runs. A side without it is null.
+type InputChange struct {
+	Name string `json:"name"`
+	A    any    `json:"a"`
+	B    any    `json:"b"`
+}
+
+// MetricChange is how a winner's metric moved from run A to run B.
+type MetricChange struct {
+	Metric    string          `json:"metric"`
+	Unit      string          `json:"unit,omitempty"`
+	Direction MetricDirection `json:"direction"`
+	A         float64         `json:"a"`
+	B         float64         `json:"b"`
+	Delta     float64         `json:"delta"`
+	// Delta relative to A; null when A is zero
+	DeltaPct *float64 `json:"delta_pct"`
+	// better, worse or unchanged, by the metric's direction
+	Change string `json:"change"`
+}
+
+// RunNarrative is a written comparison of two runs, in Markdown, with the
+// numbers it was written from.
+type RunNarrative struct {
+	Markdown string `json:"markdown"`
+	// Whether the critic model wrote it, or the built-in template
+	LLM        bool          `json:"llm"`
+	Model      string        `json:"model,omitempty"`
+	Comparison RunComparison `json:"comparison"`
+}
@@ -0,0 +1,298 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"simstack/internal/version"
+)
+
+// EventVersion is the current WSEvent envelope version. Version 1 is the
+// legacy envelope with no "v" field; payloads are the same in both.
+const EventVersion = 2
+
+// Event types, and the payload each carries (see payloadTypes).
+const (
+	EventPlan            = "plan"                 // PlanEvent
+	EventSimStart        = "sim_start"            // ProgressEvent
+	EventSimComplete     = "sim_complete"         // ResultEvent
+	EventResult          = "result"               // ResultEvent
+	EventResultGap       = "result_gap"           // ResultGapEvent
+	EventAnalysis        = "analysis"             // AnalysisEvent
+	EventManifest        = "manifest"             // ManifestEvent
+	EventDone            = "done"                 // DoneEvent
+	EventFallback        = "fallback"             // FallbackEvent
+	EventBudgetExhausted = "llm_budget_exhausted" // BudgetExhaustedEvent
+	EventError           = "error"                // ErrorEvent
+	EventSimulatorStatus = "simulator_status"     // SimulatorStatusEvent
+	EventFailover        = "simulator_failover"   // FailoverEvent
+	EventPlanningSlow    = "planning_slow"        // PlanningSlowEvent
+	EventDistribution    = "metric_distribution"  // DistributionEvent
+	EventAnnotation      = "annotation"           // AnnotationEvent
+	EventCancelled       = "cancelled"            // CancelledEvent
+	EventQueued          = "queued"               // QueuedEvent
+	EventStarted         = "started"              // StartedEvent
+	EventShutdown        = "shutdown"             // ShutdownEvent
+	EventHello           = "hello"                // HelloEvent
+)
+
+// PlanEvent, ResultEvent, ManifestEvent and DistributionEvent are the run's
+// own records.
+type (
+	PlanEvent         = SimulationPlan
+	ResultEvent       = SimulationResult
+	ManifestEvent     = RunManifest
+	DistributionEvent = MetricDistribution
+)
+
+// payloadTypes is the payload type of each event type.
+var payloadTypes = map[string]reflect.Type{
+	EventPlan:            reflect.TypeOf(PlanEvent{}),
+	EventSimStart:        reflect.TypeOf(ProgressEvent{}),
+	EventSimComplete:     reflect.TypeOf(ResultEvent{}),
+	EventResult:          reflect.TypeOf(ResultEvent{}),
+	EventResultGap:       reflect.TypeOf(ResultGapEvent{}),
+	EventAnalysis:        reflect.TypeOf(AnalysisEvent{}),
+	EventManifest:        reflect.TypeOf(ManifestEvent{}),
+	EventDone:            reflect.TypeOf(DoneEvent{}),
+	EventFallback:        reflect.TypeOf(FallbackEvent{}),
+	EventBudgetExhausted: reflect.TypeOf(BudgetExhaustedEvent{}),
+	EventError:           reflect.TypeOf(ErrorEvent{}),
+	EventSimulatorStatus: reflect.TypeOf(SimulatorStatusEvent{}),
+	EventFailover:        reflect.TypeOf(FailoverEvent{}),
+	EventPlanningSlow:    reflect.TypeOf(PlanningSlowEvent{}),
+	EventDistribution:    reflect.TypeOf(DistributionEvent{}),
+	EventAnnotation:      reflect.TypeOf(AnnotationEvent{}),
+	EventCancelled:       reflect.TypeOf(CancelledEvent{}),
+	EventQueued:          reflect.TypeOf(QueuedEvent{}),
+	EventStarted:         reflect.TypeOf(StartedEvent{}),
+	EventShutdown:        reflect.TypeOf(ShutdownEvent{}),
+	EventHello:           reflect.TypeOf(HelloEvent{}),
+}
+
+// EventPayloads returns a zero payload of each event type, by type, for
+// documenting them.
+func EventPayloads() map[string]any {
+	out := make(map[string]any, len(payloadTypes))
+	for typ, t := range payloadTypes {
+		out[typ] = reflect.Zero(t).Interface()
+	}
+	return out
+}
+
+// AnnotationEvent is a note added to a run, or removed from it when Deleted.
+type AnnotationEvent struct {
+	Annotation
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// ProgressEvent marks a variant's simulators starting.
+type ProgressEvent struct {
+	VariantID string `json:"variant_id"`
+}
+
+// AnalysisEvent is the critic's verdict, or the heuristic one when the LLM
+// was unavailable (LLM false).
+type AnalysisEvent struct {
+	Winner          string             `json:"winner,omitempty"`
+	Recommendation  string             `json:"recommendation"`
+	Confidence      float64            `json:"confidence"`
+	TradeOffs       []string           `json:"trade_offs"`
+	Counterfactuals []string           `json:"counterfactuals,omitempty"`
+	KeyMetrics      map[string]float64 `json:"key_metrics,omitempty"`
+	LLM             bool               `json:"llm"`
+	Model           string             `json:"model,omitempty"`
+	Repaired        bool               `json:"repaired,omitempty"`
+	// How long the winner's simulation took, for weighing its runtime cost
+	WinnerDurationMs int64 `json:"winner_duration_ms,omitempty"`
+	// Estimated LLM cost of producing this recommendation; absent if unknown
+	CostUSD *float64 `json:"cost_usd,omitempty"`
+}
+
+// DoneEvent ends a run.
+type DoneEvent struct {
+	PlanID  string `json:"plan_id"`
+	RunID   string `json:"run_id"`
+	LLM     bool   `json:"llm"`
+	Offline bool   `json:"offline"`
+	// The run whose plan this one replayed
+	ReplayOf string `json:"replay_of,omitempty"`
+}
+
+// CancelledEvent ends a run stopped by a cancel request. Completed is how
+// many variants finished before it; their results are kept on the run.
+type CancelledEvent struct {
+	PlanID    string `json:"plan_id"`
+	RunID     string `json:"run_id"`
+	Completed int    `json:"completed"`
+}
+
+// QueuedEvent says a run is waiting for one of the runs in flight to
+// finish. Position 1 is next to start.
+type QueuedEvent struct {
+	RunID    string `json:"run_id"`
+	Position int    `json:"position"`
+}
+
+// StartedEvent says a run has begun, after QueuedMs waiting for a slot.
+type StartedEvent struct {
+	RunID    string `json:"run_id"`
+	QueuedMs int64  `json:"queued_ms"`
+}
+
+// ShutdownEvent says the backend is shutting down: it takes no new runs
+// and gives the Runs in flight up to GraceMs to finish, canceling any
+// still going after that. The connection closes once they are done.
+type ShutdownEvent struct {
+	GraceMs int64 `json:"grace_ms"`
+	Runs    int   `json:"runs"`
+}
+
+// HelloEvent is the first message on every connection, to that client
+// alone. It carries the build the client is talking to, so that it can
+// notice version skew, and the server's clock, so that it can notice drift.
+type HelloEvent struct {
+	BuildInfo
+	ServerTime time.Time `json:"server_time"`
+}
+
+// BuildInfo is the running build and the features it has on, as
+// GET /api/version answers.
+type BuildInfo struct {
+	version.Build
+	Features Features `json:"features"`
+}
+
+// Features are the deployment choices a client may need to know about.
+type Features struct {
+	// Whether /api and /ws need an API key
+	Auth bool `json:"auth"`
+	// Where run history is kept: memory, sqlite or postgres
+	RunStore string `json:"run_store"`
+	// Whether runs use the built-in heuristics instead of the LLM
+	Offline bool `json:"offline"`
+}
+
+// FallbackEvent says an LLM stage fell back to the built-in heuristics.
+// Category is a cerebras error category, "invalid_output", or "slow" when
+// the fallback won a race against a planner past its soft deadline.
+type FallbackEvent struct {
+	Stage    string `json:"stage"`
+	Category string `json:"category"`
+	Error    string `json:"error,omitempty"`
+}
+
+// BudgetExhaustedEvent says a stage skipped the LLM because the run's budget
+// was used up.
+type BudgetExhaustedEvent struct {
+	Stage        string `json:"stage"`
+	SpentMs      int64  `json:"spent_ms"`
+	Tokens       int    `json:"tokens"`
+	TimeBudgetMs int64  `json:"time_budget_ms"`
+	TokenBudget  int    `json:"token_budget"`
+}
+
+// PlanningSlowEvent says the planner LLM missed its soft deadline; the
+// fallback grid now races it and the plan follows whichever is ready first.
+type PlanningSlowEvent struct {
+	SoftDeadlineMs int64 `json:"soft_deadline_ms"`
+}
+
+// ResultGapEvent stands in for a variant's result in a run with ordered
+// results, when the variant outlived its timeout; the results after it are
+// released behind it. Index is the variant's position in the plan, from 0,
+// and WaitedMs how long the results were held for it.
+type ResultGapEvent struct {
+	VariantID string `json:"variant_id"`
+	Index     int    `json:"index"`
+	WaitedMs  int64  `json:"waited_ms"`
+}
+
+// ErrorEvent reports a run that failed outright.
+type ErrorEvent struct {
+	Error string `json:"error"`
+}
+
+// SimulatorStatusEvent reports a simulator's health changing from Previous.
+// It belongs to no run.
+type SimulatorStatusEvent struct {
+	SimulatorHealth
+	Previous HealthStatus `json:"previous"`
+}
+
+// FailoverEvent says calls to a simulator moved from one instance to
+// another: to a backup when the primary's breaker opened, or back to the
+// primary once it answers again.
+type FailoverEvent struct {
+	Tool string `json:"tool"`
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Whether To is the primary
+	Primary bool `json:"primary"`
+}
+
+// NewEvent wraps payload in a current-version envelope stamped with the
+// current time.
+func NewEvent(typ string, payload any) WSEvent {
+	return NewEventAt(time.Now(), typ, payload)
+}
+
+// NewEventAt is NewEvent stamped with t, for events timed on a clock other
+// than the wall clock.
+func NewEventAt(t time.Time, typ string, payload any) WSEvent {
+	return WSEvent{Version: EventVersion, Type: typ, Timestamp: t.UTC().Format(time.RFC3339Nano), Payload: payload}
+}
+
+// Legacy returns ev in the version 1 envelope: no "v" field, and result
+// artifacts in their old name -> storage ref form.
+func (ev WSEvent) Legacy() WSEvent {
+	ev.Version = 0
+	if r, ok := ev.Payload.(SimulationResult); ok && len(r.Artifacts) > 0 {
+		ev.Payload = legacyResult{SimulationResult: r, Artifacts: r.Artifacts.Legacy()}
+	}
+	return ev
+}
+
+// legacyResult shadows SimulationResult.Artifacts with the version 1 map.
+type legacyResult struct {
+	SimulationResult
+	Artifacts map[string]string `json:"artifacts,omitempty"`
+}
+
+// DecodeEvent parses an envelope of either version, decoding the payload into
+// the concrete type for its event type (a value, e.g. DoneEvent). Unknown
+// types keep the payload as a map.
+func DecodeEvent(b []byte) (WSEvent, error) {
+	var raw struct {
+		Version   int             `json:"v"`
+		Type      string          `json:"type"`
+		Timestamp string          `json:"ts"`
+		RunID     string          `json:"run_id"`
+		PlanID    string          `json:"plan_id"`
+		RequestID string          `json:"request_id"`
+		Payload   json.RawMessage `json:"payload"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return WSEvent{}, err
+	}
+	ev := WSEvent{Version: raw.Version, Type: raw.Type, Timestamp: raw.Timestamp, RunID: raw.RunID, PlanID: raw.PlanID, RequestID: raw.RequestID}
+	if ev.Version == 0 {
+		ev.Version = 1
+	}
+	if len(raw.Payload) == 0 {
+		return ev, nil
+	}
+
+	t, ok := payloadTypes[raw.Type]
+	if !ok {
+		t = reflect.TypeOf(map[string]any{})
+	}
+	payload := reflect.New(t)
+	if err := json.Unmarshal(raw.Payload, payload.Interface()); err != nil {
+		return WSEvent{}, fmt.Errorf("decode %s payload: %w", raw.Type, err)
+	}
+	ev.Payload = payload.Elem().Interface()
+	return ev, nil
+}
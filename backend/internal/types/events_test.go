@@ -0,0 +1,233 @@
+package types
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"simstack/internal/version"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files")
+
+// Stamped on every golden event
+var goldenTime = time.Date(2026, 1, 2, 3, 4, 5, 6, time.UTC)
+
+var (
+	simStarted   = time.Date(2026, 1, 2, 3, 4, 0, 0, time.UTC)
+	simCompleted = simStarted.Add(1500 * time.Millisecond)
+)
+
+var sampleArtifact = Artifact{
+	Name: "queue-response.json", ContentType: "application/json", SizeBytes: 37,
+	SHA256:     "9f2c1e0d4b6a8c7e5f3a1b2d4c6e8f0a9b7c5d3e1f2a4b6c8d0e2f4a6b8c0d2e",
+	Origin:     ArtifactOrigin{RunID: "run-1", VariantID: "plan-1-v1", Tool: "queue"},
+	StorageRef: "/api/runs/run-1/artifacts/plan-1-v1/queue-response.json",
+	CreatedAt:  simCompleted,
+}
+
+// One sample payload per event type; the golden files pin their wire shape.
+var eventSamples = map[string]any{
+	EventPlan: PlanEvent{
+		PlanID:      "plan-1",
+		Model:       "llama3.1-8b",
+		Steps:       []PlanStep{{Name: "Queue", Description: "Queueing simulation", Tool: "queue", InputSchema: map[string]any{"arrival_rate": "number"}}},
+		Variants:    []Variant{{VariantID: "plan-1-v1", Parameters: map[string]any{"arrival_rate": 10.0, "staff": 20.0}}},
+		Temperature: 0.7,
+		LLM:         true,
+		Sources:     &PlanSources{Winner: PlanSourceLLM, LLMMs: 900},
+	},
+	EventSimStart: ProgressEvent{VariantID: "plan-1-v1"},
+	EventSimComplete: ResultEvent{
+		VariantID: "plan-1-v1", Tool: "composite", Metrics: map[string]float64{"queue_avg_wait_time_min": 4.2},
+		Status: ResultPartial, StartedAt: &simStarted, CompletedAt: &simCompleted, DurationMs: 1500,
+		ToolDurations: map[string]int64{"queue": 1200, "traffic": 300},
+		Timing: &VariantTiming{QueueMs: 20, Calls: []ToolTiming{
+			{Tool: "queue", DurationMs: 1200, Attempts: 1},
+			{Tool: "traffic", DurationMs: 300, Attempts: 1, Failed: true},
+		}, WallMs: 1520},
+		Artifacts: Artifacts{sampleArtifact},
+	},
+	EventResult: ResultEvent{VariantID: "plan-1-v1", Tool: "composite", Metrics: map[string]float64{"queue_avg_wait_time_min": 4.2}},
+	EventAnalysis: AnalysisEvent{
+		Winner:          "plan-1-v1",
+		Recommendation:  "Staff 20 during the peak",
+		Confidence:      0.8,
+		TradeOffs:       []string{"cost vs wait"},
+		Counterfactuals: []string{"one more server halves the wait"},
+		KeyMetrics:      map[string]float64{"queue_avg_wait_time_min": 4.2},
+		LLM:             true,
+		Model:           "llama3.1-8b",
+	},
+	EventManifest: ManifestEvent{
+		RunID: "run-1", PlanID: "plan-1", Goal: "g", Model: "llama3.1-8b",
+		PlannerTemperature: 0.7, CriticTemperature: 0.3,
+		LLMCalls:     []LLMCallRecord{{Purpose: "plan", Provider: "cerebras", Model: "llama3.1-8b", LatencyMs: 120, Tokens: 400}},
+		LLM:          true,
+		PhaseTimings: PhaseTimings{PlannerMs: 450, SimulatorWarmupMs: 30, SimulationPhaseMs: 1200, AnalysisMs: 300, TotalMs: 2000},
+		SimulationMs: 1200,
+	},
+	EventDone:      DoneEvent{PlanID: "plan-1", RunID: "run-1", LLM: true},
+	EventCancelled: CancelledEvent{PlanID: "plan-1", RunID: "run-1", Completed: 2},
+	EventQueued:    QueuedEvent{RunID: "run-1", Position: 2},
+	EventStarted:   StartedEvent{RunID: "run-1", QueuedMs: 4200},
+	EventShutdown:  ShutdownEvent{GraceMs: 30000, Runs: 2},
+	EventHello: HelloEvent{
+		BuildInfo: BuildInfo{
+			Build:    version.Build{Version: "v1.4.0", Commit: "0123456789abcdef0123456789abcdef01234567", BuildTime: "2026-01-02T00:00:00Z", GoVersion: "go1.22.5"},
+			Features: Features{Auth: true, RunStore: "sqlite"},
+		},
+		ServerTime: goldenTime,
+	},
+	EventFallback:        FallbackEvent{Stage: "plan", Category: "timeout", Error: "context deadline exceeded"},
+	EventBudgetExhausted: BudgetExhaustedEvent{Stage: "analysis", SpentMs: 120000, Tokens: 5000, TimeBudgetMs: 120000, TokenBudget: 4000},
+	EventError:           ErrorEvent{Error: "simulators unreachable"},
+	EventPlanningSlow:    PlanningSlowEvent{SoftDeadlineMs: 15000},
+	EventResultGap:       ResultGapEvent{VariantID: "plan-1-v2", Index: 1, WaitedMs: 185000},
+	EventFailover:        FailoverEvent{Tool: "queue", From: "http://queue:8000", To: "http://queue-b:8000"},
+	EventAnnotation: AnnotationEvent{Annotation: Annotation{
+		ID: "ann-1", Author: "dana", Text: "approved for pilot", VariantID: "plan-1-v2", Tags: []string{"decision"}, CreatedAt: goldenTime,
+	}},
+	EventDistribution: DistributionEvent{
+		Metric: "queue_avg_wait_time_min", Unit: "min", Direction: LowerIsBetter, Count: 3,
+		Min: 2, Max: 6, Mean: 3.5, Median: 2.5, P95: 5.65, Binning: "freedman_diaconis",
+		Buckets:  []HistogramBucket{{Lower: 2, Upper: 4, Count: 2}, {Lower: 4, Upper: 6, Count: 1}},
+		Variants: []VariantPosition{{VariantID: "plan-1-v1", Value: 2, Bucket: 0, Percentile: 0}, {VariantID: "plan-1-v2", Value: 2.5, Bucket: 0, Percentile: 50}, {VariantID: "plan-1-v3", Value: 6, Bucket: 1, Percentile: 100}},
+	},
+	EventSimulatorStatus: SimulatorStatusEvent{
+		SimulatorHealth: SimulatorHealth{
+			Tool: "queue", URL: "http://queue:8000", Status: HealthDown, ConsecutiveFailures: 3, LatencyMs: 2000, Error: "context deadline exceeded",
+			CheckedAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), Since: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		},
+		Previous: HealthDegraded,
+	},
+}
+
+func TestEventPayloads(t *testing.T) {
+	payloads := EventPayloads()
+	if len(payloads) != len(eventSamples) {
+		t.Errorf("expected a payload type for each of the %d sampled events, got %d", len(eventSamples), len(payloads))
+	}
+	for typ, sample := range eventSamples {
+		if reflect.TypeOf(payloads[typ]) != reflect.TypeOf(sample) {
+			t.Errorf("%s: expected a %T payload, got %T", typ, sample, payloads[typ])
+		}
+	}
+}
+
+func TestEventGoldens(t *testing.T) {
+	for typ, payload := range eventSamples {
+		t.Run(typ, func(t *testing.T) {
+			ev := NewEventAt(goldenTime, typ, payload)
+			// Run events carry their run, and their plan once there is one;
+			// simulator and shutdown events belong to none
+			if typ != EventSimulatorStatus && typ != EventFailover && typ != EventShutdown {
+				ev.RunID = "run-1"
+			}
+			if ev.RunID != "" && typ != EventFallback && typ != EventPlanningSlow && typ != EventError && typ != EventQueued && typ != EventStarted {
+				ev.PlanID = "plan-1"
+			}
+			got, err := json.MarshalIndent(ev, "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, '\n')
+
+			path := filepath.Join("testdata", "events", typ+".json")
+			if *update {
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("%v (run with -update to create)", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s changed shape:\n got: %s\nwant: %s", typ, got, want)
+			}
+
+			decoded, err := DecodeEvent(want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if decoded.Version != EventVersion || decoded.Type != typ || decoded.RunID != ev.RunID || decoded.PlanID != ev.PlanID || !reflect.DeepEqual(decoded.Payload, payload) {
+				t.Errorf("decode mismatch: %+v", decoded)
+			}
+		})
+	}
+}
+
+func TestDecodeLegacyEvent(t *testing.T) {
+	ev, err := DecodeEvent([]byte(`{"type": "done", "ts": "x", "payload": {"plan_id": "p", "run_id": "r", "llm": false, "offline": true}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if done, ok := ev.Payload.(DoneEvent); ev.Version != 1 || !ok || !done.Offline || done.RunID != "r" {
+		t.Errorf("unexpected legacy decode %+v", ev)
+	}
+
+	ev, err = DecodeEvent([]byte(`{"v": 2, "type": "custom", "request_id": "req-1", "payload": {"k": 1}}`))
+	if m, ok := ev.Payload.(map[string]any); err != nil || !ok || m["k"] != 1.0 || ev.RequestID != "req-1" {
+		t.Errorf("unknown types should decode to a map, got %+v (%v)", ev, err)
+	}
+}
+
+// Results stored or sent before timing fields existed must decode unchanged,
+// and results without timings must keep the old wire shape.
+func TestSimulationResultBackwardCompatible(t *testing.T) {
+	old := `{"variant_id":"p-v1","tool":"composite","metrics":{"queue_utilization":0.8}}`
+	var r SimulationResult
+	if err := json.Unmarshal([]byte(old), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.VariantID != "p-v1" || r.Metrics["queue_utilization"] != 0.8 || r.Status != "" || r.StartedAt != nil {
+		t.Errorf("unexpected decode %+v", r)
+	}
+	b, _ := json.Marshal(r)
+	if string(b) != old {
+		t.Errorf("old shape changed:\n got %s\nwant %s", b, old)
+	}
+}
+
+// Version 1 clients get result artifacts as the old name -> ref map, and
+// that shape still decodes.
+func TestLegacyResultArtifacts(t *testing.T) {
+	ev := NewEvent(EventSimComplete, eventSamples[EventSimComplete])
+	b, _ := json.Marshal(ev.Legacy())
+	var wire struct {
+		Version int            `json:"v"`
+		Payload map[string]any `json:"payload"`
+	}
+	if err := json.Unmarshal(b, &wire); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{"queue-response.json": sampleArtifact.StorageRef}
+	if wire.Version != 0 || !reflect.DeepEqual(wire.Payload["artifacts"], want) || wire.Payload["variant_id"] != "plan-1-v1" {
+		t.Errorf("unexpected legacy payload %s", b)
+	}
+
+	decoded, err := DecodeEvent(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := decoded.Payload.(ResultEvent)
+	if len(r.Artifacts) != 1 || r.Artifacts[0].Name != "queue-response.json" || r.Artifacts[0].StorageRef != sampleArtifact.StorageRef {
+		t.Errorf("legacy artifacts not decoded: %+v", r.Artifacts)
+	}
+
+	// Current clients get the typed list back intact
+	b, _ = json.Marshal(ev)
+	decoded, _ = DecodeEvent(b)
+	if r := decoded.Payload.(ResultEvent); len(r.Artifacts) != 1 || r.Artifacts[0] != sampleArtifact {
+		t.Errorf("typed artifacts not round-tripped: %+v", r.Artifacts)
+	}
+}
@@ -0,0 +1,237 @@
+package types
+
+import "time"
+
+// MetricDirection says which way a metric improves.
+type MetricDirection string
+
+const (
+	HigherIsBetter MetricDirection = "higher"
+	LowerIsBetter  MetricDirection = "lower"
+)
+
+// MetricDef describes a metric as it appears in SimulationResult.Metrics,
+// i.e. prefixed with the simulator's tool name.
+type MetricDef struct {
+	Name        string          `json:"name"`
+	Unit        string          `json:"unit,omitempty"`
+	Direction   MetricDirection `json:"direction"`
+	Description string          `json:"description,omitempty"`
+}
+
+// MetricDistribution is how one metric spread across a run's variants,
+// ready to chart: a histogram, summary statistics and where each variant
+// fell.
+type MetricDistribution struct {
+	Metric    string          `json:"metric"`
+	Unit      string          `json:"unit,omitempty"`
+	Direction MetricDirection `json:"direction,omitempty"`
+	Count     int             `json:"count"`
+	Min       float64         `json:"min"`
+	Max       float64         `json:"max"`
+	Mean      float64         `json:"mean"`
+	Median    float64         `json:"median"`
+	P95       float64         `json:"p95"`
+	// freedman_diaconis, sturges or fixed
+	Binning string            `json:"binning"`
+	Buckets []HistogramBucket `json:"buckets"`
+	// In the order the results were given
+	Variants []VariantPosition `json:"variants"`
+}
+
+// HistogramBucket counts the values from Lower up to Upper; only the last
+// bucket includes its upper edge.
+type HistogramBucket struct {
+	Lower float64 `json:"lower"`
+	Upper float64 `json:"upper"`
+	Count int     `json:"count"`
+}
+
+// VariantPosition places one variant's value in a distribution.
+type VariantPosition struct {
+	VariantID string  `json:"variant_id"`
+	Value     float64 `json:"value"`
+	// Index into Buckets
+	Bucket int `json:"bucket"`
+	// Share of the other variants with a lower value, 0 to 100
+	Percentile float64 `json:"percentile"`
+}
+
+// PhaseTimings is the wall time of each phase of a run: planning, the
+// warm-up pings to the simulators, running the variants and the critic's
+// analysis. TotalMs covers the whole run, including the bookkeeping between
+// phases.
+type PhaseTimings struct {
+	PlannerMs         int64 `json:"planner_ms"`
+	SimulatorWarmupMs int64 `json:"simulator_warmup_ms"`
+	SimulationPhaseMs int64 `json:"simulation_phase_ms"`
+	AnalysisMs        int64 `json:"analysis_ms"`
+	TotalMs           int64 `json:"total_ms"`
+}
+
+// RunMetrics is the performance record of one completed run.
+type RunMetrics struct {
+	RunID       string    `json:"run_id"`
+	StartedAt   time.Time `json:"started_at"`
+	CompletedAt time.Time `json:"completed_at"`
+
+	// Wall time of each phase and of the whole run
+	PhaseTimings
+	// Deprecated: SimulationPhaseMs under its old name. Removed in the next
+	// release.
+	SimulationMs int64 `json:"simulation_ms"`
+
+	// LLM usage across the run's calls; TokensPerSecond is total tokens over
+	// total call latency
+	LLMCalls        int     `json:"llm_calls"`
+	FailedLLMCalls  int     `json:"failed_llm_calls"`
+	PromptTokens    int     `json:"prompt_tokens"`
+	TotalTokens     int     `json:"total_tokens"`
+	TokensPerSecond float64 `json:"tokens_per_second"`
+	// Estimated dollar cost; null when a call's model had no price
+	CostUSD *float64 `json:"cost_usd"`
+
+	Variants        int `json:"variants"`
+	PartialVariants int `json:"partial_variants"`
+	FailedVariants  int `json:"failed_variants"`
+	// Mean wait before a variant was dispatched, and by simulator the mean
+	// duration of a call and the time spent in calls across variants
+	AvgQueueMs  float64            `json:"avg_queue_ms"`
+	ToolAvgMs   map[string]float64 `json:"tool_avg_ms,omitempty"`
+	ToolTotalMs map[string]int64   `json:"tool_total_ms,omitempty"`
+
+	Offline bool `json:"offline"`
+}
+
+// MetricsAggregates summarizes the runs in the metrics history.
+type MetricsAggregates struct {
+	Runs               int     `json:"runs"`
+	AvgPlannerMs       float64 `json:"avg_planner_ms"`
+	P95PlannerMs       int64   `json:"p95_planner_ms"`
+	AvgSimulationMs    float64 `json:"avg_simulation_ms"`
+	AvgAnalysisMs      float64 `json:"avg_analysis_ms"`
+	AvgTotalMs         float64 `json:"avg_total_ms"`
+	AvgTokensPerSecond float64 `json:"avg_tokens_per_second"`
+	TotalTokens        int     `json:"total_tokens"`
+	FailedLLMCalls     int     `json:"failed_llm_calls"`
+	FailedVariants     int     `json:"failed_variants"`
+	// Cost summed over runs with a known cost, and runs whose cost is unknown
+	TotalCostUSD float64 `json:"total_cost_usd"`
+	UnpricedRuns int     `json:"unpriced_runs"`
+}
+
+// BreakerState is a simulator's circuit breaker state. Open skips calls until
+// a cooldown passes; half-open lets one trial call decide.
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half_open"
+)
+
+// StatsSinceStart is the only SimulatorStats window: figures accumulate from
+// Since, the process start, and are never reset.
+const StatsSinceStart = "since_start"
+
+// SimulatorStats describes one simulator's calls. Percentiles are estimated
+// to within about 1%.
+type SimulatorStats struct {
+	Tool      string  `json:"tool"`
+	Calls     uint64  `json:"calls"`
+	Errors    uint64  `json:"errors"`
+	ErrorRate float64 `json:"error_rate"`
+	// Calls skipped because the breaker was open
+	Rejected uint64       `json:"rejected_calls"`
+	P50Ms    float64      `json:"p50_ms"`
+	P95Ms    float64      `json:"p95_ms"`
+	P99Ms    float64      `json:"p99_ms"`
+	Breaker  BreakerState `json:"breaker"`
+	Window   string       `json:"window"`
+	Since    time.Time    `json:"since"`
+}
+
+// HealthStatus is a simulator's health as its background probes see it.
+// Degraded is a slow answer, or failures not yet numerous enough to call it
+// down.
+type HealthStatus string
+
+const (
+	HealthUnknown  HealthStatus = "unknown"
+	HealthUp       HealthStatus = "up"
+	HealthDegraded HealthStatus = "degraded"
+	HealthDown     HealthStatus = "down"
+)
+
+// DeepHealth is what probing every dependency at once found: Healthy when
+// all are up, Unhealthy when no simulator is, Degraded otherwise.
+type DeepHealth struct {
+	Status    DeepHealthStatus `json:"status"`
+	CheckedAt time.Time        `json:"checked_at"`
+	// Answered from an earlier check rather than by probing
+	Cached       bool               `json:"cached"`
+	Dependencies []DependencyHealth `json:"dependencies"`
+}
+
+// DeepHealthStatus classifies the backend as a whole.
+type DeepHealthStatus string
+
+const (
+	Healthy   DeepHealthStatus = "healthy"
+	Degraded  DeepHealthStatus = "degraded"
+	Unhealthy DeepHealthStatus = "unhealthy"
+)
+
+// DependencyHealth is the outcome of probing one dependency: a simulator,
+// by tool name, or the LLM provider. Unknown is a dependency that wasn't
+// probed, such as the LLM while offline.
+type DependencyHealth struct {
+	Name      string       `json:"name"`
+	Kind      string       `json:"kind"` // simulator or llm
+	Status    HealthStatus `json:"status"`
+	LatencyMs int64        `json:"latency_ms"`
+	Error     string       `json:"error,omitempty"`
+}
+
+// SimulatorHealth is the outcome of a simulator's latest health probe.
+type SimulatorHealth struct {
+	Tool   string       `json:"tool"`
+	URL    string       `json:"url"`
+	Status HealthStatus `json:"status"`
+	// Failed probes in a row, and how long the latest one took
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+	LatencyMs           int64  `json:"latency_ms"`
+	Error               string `json:"error,omitempty"`
+	// Of the latest probe, and of the latest change of Status
+	CheckedAt time.Time `json:"checked_at"`
+	Since     time.Time `json:"since"`
+}
+
+// RunCounters counts run lifecycle events since Since, the process start.
+type RunCounters struct {
+	Since         time.Time `json:"since"`
+	RunsStarted   int64     `json:"runs_started"`
+	RunsCompleted int64     `json:"runs_completed"`
+	// Runs in which no variant produced metrics, and runs whose context was
+	// canceled before they finished
+	RunsFailed   int64 `json:"runs_failed"`
+	RunsCanceled int64 `json:"runs_canceled"`
+	// Runs that wanted LLM planning but used the built-in grid
+	PlanningFallbacks int64            `json:"planning_fallbacks"`
+	VariantsExecuted  int64            `json:"variants_executed"`
+	SimulatorFailures map[string]int64 `json:"simulator_failures"`
+	// Simulator calls answered from the response cache, and cacheable calls
+	// that had to be made
+	SimulatorCacheHits   int64 `json:"simulator_cache_hits"`
+	SimulatorCacheMisses int64 `json:"simulator_cache_misses"`
+	// Estimated cost of every priced LLM call, and calls to unpriced models
+	CostUSD          float64 `json:"cost_usd"`
+	UnpricedLLMCalls int64   `json:"unpriced_llm_calls"`
+	// WebSocket clients connected right now
+	WSClients int64 `json:"ws_clients"`
+	// Runs held in memory right now, finished runs evicted from memory, and
+	// live results buffers released after their grace period
+	RunsResident          int64 `json:"runs_resident"`
+	RunsEvicted           int64 `json:"runs_evicted"`
+	ReplayBuffersReleased int64 `json:"replay_buffers_released"`
+}
@@ -1,26 +1,147 @@
 package types
 
+import "time"
+
 type RunRequest struct {
 	Goal        string         `json:"goal"`
 	Constraints map[string]any `json:"constraints,omitempty"`
 	Parameters  map[string]any `json:"parameters,omitempty"`
+
+	// Debug logs this run's LLM traffic (sanitized) for prompt debugging
+	Debug bool `json:"debug,omitempty"`
+
+	// Per-run overrides of the configured model and sampling temperatures.
+	// PlannerModel and CriticModel override Model for their phase
+	Model              string   `json:"model,omitempty"`
+	PlannerModel       string   `json:"planner_model,omitempty"`
+	CriticModel        string   `json:"critic_model,omitempty"`
+	PlannerTemperature *float64 `json:"planner_temperature,omitempty"`
+	CriticTemperature  *float64 `json:"critic_temperature,omitempty"`
+
+	// Offline never contacts the LLM; planning and analysis use the
+	// deterministic fallbacks
+	Offline bool `json:"offline,omitempty"`
+
+	// Shared LLM allowance across the run's calls; zero uses server defaults
+	LLMTimeBudgetSeconds float64 `json:"llm_time_budget_seconds,omitempty"`
+	LLMTokenBudget       int     `json:"llm_token_budget,omitempty"`
+
+	// Reproducible pins both temperatures to 0 and sends Seed (derived from
+	// the goal when unset) so providers sample as deterministically as they can
+	Reproducible bool   `json:"reproducible,omitempty"`
+	Seed         *int64 `json:"seed,omitempty"`
+
+	// NoCache simulates every variant afresh, neither reading nor filling
+	// the simulator response cache
+	NoCache bool `json:"no_cache,omitempty"`
+
+	// OrderedResults sends result events in variant order as each variant
+	// and all before it finish, rather than all at once when the last does
+	OrderedResults bool `json:"ordered_results,omitempty"`
+
+	// Notify names chat webhooks told when this run finishes, besides the
+	// configured ones
+	Notify *NotifyTargets `json:"notify,omitempty"`
+}
+
+// NotifyTargets are a run's own notification webhooks.
+type NotifyTargets struct {
+	SlackWebhookURL   string `json:"slack_webhook_url,omitempty"`
+	DiscordWebhookURL string `json:"discord_webhook_url,omitempty"`
+}
+
+// ReplayRequest runs a plan again without planning: the plan of the stored
+// run RunID, or Plan as given. Goal is what the critic weighs the results
+// against; it defaults to the original run's goal.
+type ReplayRequest struct {
+	RunID   string          `json:"run_id,omitempty"`
+	Plan    *SimulationPlan `json:"plan,omitempty"`
+	Goal    string          `json:"goal,omitempty"`
+	Offline bool            `json:"offline,omitempty"`
 }
 
 type ExportRequest struct {
 	Goal       string         `json:"goal"`
 	Parameters map[string]any `json:"parameters,omitempty"`
+	// compose (default), env, json-params or script
+	Format string `json:"format,omitempty"`
+	// Export a run's variant, its winner unless VariantID is set; Parameters
+	// then override the variant's
+	RunID     string `json:"run_id,omitempty"`
+	VariantID string `json:"variant_id,omitempty"`
+	// Compose only: also run the backend against the exported simulators
+	IncludeBackend bool `json:"include_backend,omitempty"`
+	// Compose of a run only: export several variants, each under its own
+	// profile; the TopK best, or the Pareto front when TopK is 0
+	Profiles bool `json:"profiles,omitempty"`
+	TopK     int  `json:"top_k,omitempty"`
+	// Script only: relative difference a headline metric may show (0.05 when
+	// 0)
+	Tolerance float64 `json:"tolerance,omitempty"`
 }
 
+// WSEvent is the envelope of every event sent to clients. Version is
+// EventVersion on events built with NewEvent; clients that haven't negotiated
+// it get the legacy shape, without the field. See events.go for the payloads.
 type WSEvent struct {
-	Type      string      `json:"type"`
-	Timestamp string      `json:"ts,omitempty"`
+	Version   int    `json:"v,omitempty"`
+	Type      string `json:"type"`
+	Timestamp string `json:"ts,omitempty"`
+	// The run the event belongs to, and its plan once planning is done;
+	// empty on events outside any run, like simulator_status
+	RunID  string `json:"run_id,omitempty"`
+	PlanID string `json:"plan_id,omitempty"`
+	// The X-Request-ID of the request that started the run
+	RequestID string      `json:"request_id,omitempty"`
 	Payload   interface{} `json:"payload,omitempty"`
 }
 
 type SimulationPlan struct {
 	PlanID   string     `json:"plan_id"`
+	Model    string     `json:"model,omitempty"`
 	Steps    []PlanStep `json:"steps"`
 	Variants []Variant  `json:"variants"`
+	// Effective planner temperature for this run
+	Temperature float64 `json:"temperature"`
+	// Follow-up calls made to finish a planner reply cut off by max_tokens
+	Continuations int `json:"continuations,omitempty"`
+	// Whether the variants came from the model rather than the fallback grid
+	LLM bool `json:"llm"`
+	// Planner output only parsed after JSON repair
+	Repaired bool `json:"repaired,omitempty"`
+	// Where the variants came from and how long each source took
+	Sources *PlanSources `json:"sources,omitempty"`
+	// What was cut or stripped from the goal and constraints before they
+	// went into the planner's prompt; nil when they went in whole
+	Sanitized *InputSanitation `json:"sanitized,omitempty"`
+}
+
+// Plan sources
+const (
+	PlanSourceLLM      = "llm"
+	PlanSourceFallback = "fallback"
+)
+
+// PlanSources records how planning went: which source's variants the plan
+// uses and how long each source ran, until it finished or was canceled for
+// losing. A source that never ran has no time.
+type PlanSources struct {
+	Winner     string `json:"winner"`
+	LLMMs      int64  `json:"llm_ms,omitempty"`
+	FallbackMs int64  `json:"fallback_ms,omitempty"`
+	// The LLM missed the soft deadline, so the fallback grid raced it
+	Raced bool `json:"raced,omitempty"`
+}
+
+// InputSanitation records what was taken out of a run's goal and
+// constraints before they went into a prompt.
+type InputSanitation struct {
+	// Fields cut to their length cap: "goal", "constraints"
+	Truncated []string `json:"truncated,omitempty"`
+	// Control and invisible formatting characters removed
+	ControlChars int `json:"control_chars,omitempty"`
+	// Prompt-injection phrases removed, as they appeared
+	Injections []string `json:"injections,omitempty"`
 }
 
 type PlanStep struct {
@@ -39,11 +160,331 @@ type SimulationResult struct {
 	VariantID string             `json:"variant_id"`
 	Tool      string             `json:"tool"`
 	Metrics   map[string]float64 `json:"metrics"`
-	Artifacts map[string]string  `json:"artifacts,omitempty"`
+	Artifacts Artifacts          `json:"artifacts,omitempty"`
+
+	// Timing and outcome of the variant's simulator calls; all optional so
+	// results recorded before they existed still decode
+	Status        ResultStatus     `json:"status,omitempty"`
+	StartedAt     *time.Time       `json:"started_at,omitempty"`
+	CompletedAt   *time.Time       `json:"completed_at,omitempty"`
+	DurationMs    int64            `json:"duration_ms,omitempty"`
+	ToolDurations map[string]int64 `json:"tool_durations_ms,omitempty"`
+	// Where the variant's time went
+	Timing *VariantTiming `json:"timing,omitempty"`
+	// Parameter values changed to the types the simulators expect, such as
+	// a staff of 20.0 cast to the integer 20
+	Coercions []string `json:"coercions,omitempty"`
+}
+
+// VariantTiming breaks down a variant's wall time: QueueMs from the start of
+// the simulation phase until the variant was dispatched, then each simulator
+// call in the order made, then OverheadMs for everything else (parameter
+// extraction, artifact capture). Together they add up to WallMs.
+type VariantTiming struct {
+	QueueMs    int64        `json:"queue_ms"`
+	Calls      []ToolTiming `json:"calls,omitempty"`
+	OverheadMs int64        `json:"overhead_ms"`
+	WallMs     int64        `json:"wall_ms"`
 }
 
+// ToolTiming is one simulator call of a variant.
+type ToolTiming struct {
+	Tool string `json:"tool"`
+	// Every attempt included
+	DurationMs int64 `json:"duration_ms"`
+	Attempts   int   `json:"attempts"`
+	Failed     bool  `json:"failed,omitempty"`
+	// Why it failed; a call refused for parameters the simulator can't
+	// take fails with no attempt
+	Error string `json:"error,omitempty"`
+	// Answered from the simulator response cache, without a call
+	Cached bool `json:"cached,omitempty"`
+	// Base URL of the instance that answered, or that was tried last
+	Endpoint string `json:"endpoint,omitempty"`
+}
+
+// ResultStatus says how much of a variant's simulation succeeded.
+type ResultStatus string
+
+const (
+	// Every simulator the variant had parameters for answered
+	ResultComplete ResultStatus = "complete"
+	// Some simulators failed; Metrics holds the rest
+	ResultPartial ResultStatus = "partial"
+	// No simulator answered
+	ResultFailed ResultStatus = "failed"
+	// Metrics were estimated rather than simulated
+	ResultEstimated ResultStatus = "estimated"
+)
+
 type MetricsSnapshot struct {
-	PlannerMs           int64   `json:"planner_ms"`
-	SimulationStartupMs int64   `json:"simulation_startup_ms"`
-	TokensPerSecond     float64 `json:"tokens_per_second"`
+	// Phases of the last completed run
+	PhaseTimings
+	// Deprecated: the simulation phase under its old, misleading name; read
+	// SimulationPhaseMs. Removed in the next release.
+	SimulationStartupMs int64 `json:"simulation_startup_ms"`
+
+	TokensPerSecond  float64 `json:"tokens_per_second"`
+	LLMDelayedCalls  int64   `json:"llm_delayed_calls"`
+	LLMRejectedCalls int64   `json:"llm_rejected_calls"`
+	// Mean call duration per simulator over the last run
+	SimulatorLatencyMs map[string]float64 `json:"simulator_latency_ms,omitempty"`
+	// How long each simulator took to answer the last run's warm-up ping
+	SimulatorStartupMs map[string]int64 `json:"simulator_startup_ms,omitempty"`
+
+	// Per-simulator call statistics since startup
+	Simulators []SimulatorStats `json:"simulators,omitempty"`
+
+	// Run lifecycle counters since startup
+	Counters RunCounters `json:"counters"`
+
+	// Latest completed runs, newest first, and aggregates over the history
+	Runs       []RunMetrics       `json:"runs,omitempty"`
+	Aggregates *MetricsAggregates `json:"aggregates,omitempty"`
+}
+
+type RunManifest struct {
+	RunID  string `json:"run_id"`
+	PlanID string `json:"plan_id"`
+	Goal   string `json:"goal"`
+	// Effective model and temperatures after per-run overrides; each phase
+	// asks for its own model, which Model, the run-wide one, only defaults
+	Model              string          `json:"model"`
+	PlannerModel       string          `json:"planner_model,omitempty"`
+	CriticModel        string          `json:"critic_model,omitempty"`
+	PlannerTemperature float64         `json:"planner_temperature"`
+	CriticTemperature  float64         `json:"critic_temperature"`
+	LLMCalls           []LLMCallRecord `json:"llm_calls"`
+	// Estimated dollar cost of the run's LLM calls; null when any call used
+	// a model without a price, which UnpricedCalls counts
+	CostUSD       *float64 `json:"cost_usd"`
+	UnpricedCalls int      `json:"unpriced_calls,omitempty"`
+	// The same accounting for each phase that called the LLM
+	PhaseUsage []PhaseUsage `json:"phase_usage,omitempty"`
+	// Offline runs never contact the LLM; LLM reports whether any stage's
+	// output was model-assisted
+	Offline bool `json:"offline"`
+	LLM     bool `json:"llm"`
+	// Seed sent with every LLM call of a reproducible run
+	Reproducible bool   `json:"reproducible"`
+	Seed         *int64 `json:"seed,omitempty"`
+	// Wall time of each phase, and of each variant within the simulation
+	// phase
+	PhaseTimings
+	VariantDurationsMs map[string]int64 `json:"variant_durations_ms,omitempty"`
+	// Deprecated: SimulationPhaseMs under its old name. Removed in the next
+	// release.
+	SimulationMs int64 `json:"simulation_ms,omitempty"`
+	// How long each simulator took to answer the warm-up ping sent before
+	// the variants were dispatched
+	SimulatorStartupMs map[string]int64 `json:"simulator_startup_ms,omitempty"`
+	// Every artifact the run kept, across variants
+	Artifacts []Artifact `json:"artifacts,omitempty"`
+	// The ID the run had in the history it was imported from, when the
+	// import gave it a new one
+	OriginalID string `json:"original_id,omitempty"`
+	// The run whose plan this run replayed, without planning
+	ReplayOf string `json:"replay_of,omitempty"`
+	// The run's annotations as of when the record was last saved
+	Annotations []Annotation `json:"annotations,omitempty"`
+}
+
+// PhaseUsage is what one phase of a run, plan or analysis, spent on the
+// LLM. CostUSD is null when any of its calls used a model without a price.
+type PhaseUsage struct {
+	Phase   string   `json:"phase"`
+	Model   string   `json:"model"`
+	Calls   int      `json:"calls"`
+	Tokens  int      `json:"tokens"`
+	CostUSD *float64 `json:"cost_usd"`
+}
+
+type LLMCallRecord struct {
+	Purpose   string `json:"purpose"`
+	Provider  string `json:"provider"`
+	Model     string `json:"model"`
+	LatencyMs int64  `json:"latency_ms"`
+	Tokens    int    `json:"tokens,omitempty"`
+	Error     string `json:"error,omitempty"`
+
+	// Pre-call estimate next to the provider's reported prompt usage, for
+	// tracking estimator error
+	EstimatedPromptTokens int `json:"estimated_prompt_tokens,omitempty"`
+	PromptTokens          int `json:"prompt_tokens,omitempty"`
+	CompletionTokens      int `json:"completion_tokens,omitempty"`
+	// The response carried no usage (e.g. a stream without a usage chunk), so
+	// the token counts are estimates
+	UsageEstimated bool `json:"usage_estimated,omitempty"`
+	// Estimated dollar cost; absent when the model has no price
+	CostUSD *float64 `json:"cost_usd,omitempty"`
+
+	// timeout, canceled, connection or api; see cerebras.Classify
+	ErrorCategory string `json:"error_category,omitempty"`
+
+	// Models earlier in the fallback chain that failed before Model answered
+	FailedModels []string `json:"failed_models,omitempty"`
+
+	// Provider backend build; seeded calls only repeat while it is unchanged
+	SystemFingerprint string `json:"system_fingerprint,omitempty"`
+}
+
+// RunRecord is everything kept about a run once it has been started.
+type RunRecord struct {
+	ID         string             `json:"id"`
+	Goal       string             `json:"goal"`
+	Status     string             `json:"status"`
+	Reason     string             `json:"reason,omitempty"`
+	StartedAt  time.Time          `json:"started_at"`
+	FinishedAt *time.Time         `json:"finished_at,omitempty"`
+	PlanID     string             `json:"plan_id,omitempty"`
+	Winner     string             `json:"winner,omitempty"`
+	Plan       *SimulationPlan    `json:"plan,omitempty"`
+	Results    []SimulationResult `json:"results,omitempty"`
+	Analysis   map[string]any     `json:"analysis,omitempty"`
+	Manifest   *RunManifest       `json:"manifest,omitempty"`
+	// Notes people left on the run, oldest first
+	Annotations []Annotation `json:"annotations,omitempty"`
+
+	// Goal embedding for similarity search; EmbeddingSpace names the embedder
+	// that produced it so vectors from different spaces are never compared
+	GoalEmbedding  []float64 `json:"-"`
+	EmbeddingSpace string    `json:"-"`
+}
+
+// Annotation is a note someone left on a run after reviewing it, such as
+// the decision taken on it; VariantID is set when it is about one variant.
+type Annotation struct {
+	ID     string `json:"id"`
+	Author string `json:"author"`
+	// The API key the note was added with, hashed; only a caller with the
+	// same key may delete it. Empty when the API takes no keys.
+	Owner     string    `json:"owner,omitempty"`
+	Text      string    `json:"text"`
+	VariantID string    `json:"variant_id,omitempty"`
+	Tags      []string  `json:"tags,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LLMAuditRecord is the compliance record of one call to the LLM provider.
+// Prompt holds the sanitized messages only when prompt retention is enabled;
+// the hashes are always over the full, unredacted text.
+type LLMAuditRecord struct {
+	RunID            string         `json:"run_id"`
+	Phase            string         `json:"phase"`
+	Provider         string         `json:"provider"`
+	Model            string         `json:"model"`
+	Time             time.Time      `json:"time"`
+	PromptHash       string         `json:"prompt_hash"`
+	Prompt           []AuditMessage `json:"prompt,omitempty"`
+	ResponseHash     string         `json:"response_hash,omitempty"`
+	PromptTokens     int            `json:"prompt_tokens"`
+	CompletionTokens int            `json:"completion_tokens"`
+	TotalTokens      int            `json:"total_tokens"`
+	LatencyMs        int64          `json:"latency_ms"`
+	Error            string         `json:"error,omitempty"`
+}
+
+type AuditMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type RunSummary struct {
+	ID        string    `json:"id"`
+	Goal      string    `json:"goal"`
+	Status    string    `json:"status"`
+	StartedAt time.Time `json:"started_at"`
+	Winner    *string   `json:"winner"`
+}
+
+func (r RunRecord) Summary() RunSummary {
+	s := RunSummary{ID: r.ID, Goal: r.Goal, Status: r.Status, StartedAt: r.StartedAt}
+	if r.Winner != "" {
+		winner := r.Winner
+		s.Winner = &winner
+	}
+	return s
+}
+
+type SimilarRun struct {
+	RunSummary
+	Similarity float64 `json:"similarity"`
+}
+
+// ResultsLine is one line of a run's results.ndjson stream: a header, then
+// one result per line, then a summary. Exactly one field is set.
+type ResultsLine struct {
+	Header  *ResultsHeader    `json:"header,omitempty"`
+	Result  *SimulationResult `json:"result,omitempty"`
+	Summary *ResultsSummary   `json:"summary,omitempty"`
+}
+
+type ResultsHeader struct {
+	RunID     string    `json:"run_id"`
+	PlanID    string    `json:"plan_id,omitempty"`
+	Goal      string    `json:"goal"`
+	Status    string    `json:"status"`
+	StartedAt time.Time `json:"started_at"`
+	// Planned variant count, once the plan is made
+	Variants int         `json:"variants,omitempty"`
+	Metrics  []MetricDef `json:"metrics"`
+}
+
+// ResultsSummary closes the stream; a stream without one was cut short.
+type ResultsSummary struct {
+	RunID      string     `json:"run_id"`
+	Status     string     `json:"status"`
+	Winner     string     `json:"winner,omitempty"`
+	Results    int        `json:"results"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// How the key metrics spread across the variants, once the run is done
+	Distributions []MetricDistribution `json:"distributions,omitempty"`
+}
+
+// RunResults is a run's result set in one document. Complete is false
+// while the run is in flight, when Results holds the variants finished so
+// far and there is no analysis yet.
+type RunResults struct {
+	RunID    string             `json:"run_id"`
+	PlanID   string             `json:"plan_id,omitempty"`
+	Status   string             `json:"status"`
+	Complete bool               `json:"complete"`
+	Plan     *SimulationPlan    `json:"plan,omitempty"`
+	Results  []SimulationResult `json:"results"`
+	Analysis map[string]any     `json:"analysis,omitempty"`
+	// How the key metrics spread across the variants, once the run is done
+	Distributions []MetricDistribution `json:"distributions,omitempty"`
+}
+
+// RunPhase is the stage a run has reached.
+type RunPhase string
+
+const (
+	// Waiting for one of the runs in flight to finish
+	PhaseQueued     RunPhase = "queued"
+	PhasePlanning   RunPhase = "planning"
+	PhaseSimulating RunPhase = "simulating"
+	PhaseAnalyzing  RunPhase = "analyzing"
+	PhaseDone       RunPhase = "done"
+	PhaseFailed     RunPhase = "failed"
+	PhaseCancelled  RunPhase = "cancelled"
+	// The record says the run is in flight, but not in this process, so its
+	// phase is unknown here
+	PhaseRunning RunPhase = "running"
+)
+
+// RunProgress is where a run has got to, for clients that poll rather than
+// follow its events.
+type RunProgress struct {
+	RunID  string   `json:"run_id"`
+	PlanID string   `json:"plan_id,omitempty"`
+	Phase  RunPhase `json:"phase"`
+	// Place in the queue of runs waiting for a slot while queued; 1 is next
+	QueuePosition int `json:"queue_position,omitempty"`
+	// Variants finished so far, of those planned; zero until the plan is made
+	VariantsCompleted int        `json:"variants_completed"`
+	VariantsTotal     int        `json:"variants_total"`
+	StartedAt         time.Time  `json:"started_at"`
+	FinishedAt        *time.Time `json:"finished_at,omitempty"`
+	ElapsedMs         int64      `json:"elapsed_ms"`
 }
@@ -0,0 +1,69 @@
+// Package version identifies the running build. Release builds set it with
+// the linker:
+//
+//	go build -ldflags "-X simstack/internal/version.Version=v1.4.0 \
+//	  -X simstack/internal/version.Commit=$(git rev-parse HEAD) \
+//	  -X simstack/internal/version.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Without them the commit and build time come from the VCS stamp Go records
+// when building inside a checkout, if any.
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+// Set with -ldflags -X; see the package comment.
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildTime = ""
+)
+
+// Build describes the running binary.
+type Build struct {
+	Version string `json:"version"`
+	// Git commit SHA, when known
+	Commit string `json:"commit,omitempty"`
+	// RFC 3339, when known
+	BuildTime string `json:"build_time,omitempty"`
+	GoVersion string `json:"go_version"`
+	// Built from a checkout with uncommitted changes
+	Modified bool `json:"modified,omitempty"`
+}
+
+var current = sync.OnceValue(func() Build {
+	b := Build{Version: Version, Commit: Commit, BuildTime: BuildTime, GoVersion: runtime.Version()}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return b
+	}
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			if b.Commit == "" {
+				b.Commit = s.Value
+			}
+		case "vcs.time":
+			if b.BuildTime == "" {
+				b.BuildTime = s.Value
+			}
+		case "vcs.modified":
+			b.Modified = s.Value == "true"
+		}
+	}
+	return b
+})
+
+// Get returns the running build.
+func Get() Build {
+	return current()
+}
+
+// UserAgent is the User-Agent of the backend's outgoing requests, e.g.
+// "simstack/v1.4.0".
+func UserAgent() string {
+	return "simstack/" + Version
+}
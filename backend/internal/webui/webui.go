@@ -0,0 +1,102 @@
+// Package webui serves the frontend build bundled into the binary. Copy a
+// `vite build` output into dist/ before building the backend; without it
+// the server still builds and answers / with a short "not bundled" page.
+package webui
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+//go:embed all:dist
+var bundled embed.FS
+
+// Paths (and everything under them) the UI must never answer, even when no
+// handler claims them
+var reserved = []string{"/api", "/ws", "/metrics", "/healthz"}
+
+const (
+	// Vite fingerprints everything under assets/, so those never change
+	immutable = "public, max-age=31536000, immutable"
+	// index.html and unhashed files are revalidated on every load
+	revalidate = "no-cache"
+)
+
+const notBundled = `<!doctype html>
+<html><head><meta charset="utf-8"><title>SimStack</title></head>
+<body><h1>SimStack</h1><p>The frontend is not bundled into this build. The API is at <code>/api</code>; see the README to embed the UI.</p></body></html>
+`
+
+// Handler serves the bundled frontend.
+func Handler() http.Handler {
+	sub, err := fs.Sub(bundled, "dist")
+	if err != nil {
+		panic(err)
+	}
+	return New(sub)
+}
+
+// New serves a frontend build from fsys: files by path, and index.html for
+// any other route so client-side routing works.
+func New(fsys fs.FS) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if isReserved(r.URL.Path) {
+			http.NotFound(w, r)
+			return
+		}
+
+		name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+		if name != "" && name != "index.html" {
+			data, err := fs.ReadFile(fsys, name)
+			if err == nil {
+				cache := revalidate
+				if strings.HasPrefix(name, "assets/") {
+					cache = immutable
+				}
+				serve(w, r, name, cache, data)
+				return
+			}
+			// A missing file is a 404, not the app: a stale asset link
+			// must not get HTML back
+			if !errors.Is(err, fs.ErrNotExist) || path.Ext(name) != "" {
+				http.NotFound(w, r)
+				return
+			}
+		}
+
+		index, err := fs.ReadFile(fsys, "index.html")
+		if err != nil {
+			serve(w, r, "index.html", revalidate, []byte(notBundled))
+			return
+		}
+		serve(w, r, "index.html", revalidate, index)
+	})
+}
+
+func isReserved(p string) bool {
+	for _, prefix := range reserved {
+		if p == prefix || strings.HasPrefix(p, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// serve writes data with the content type of name's extension.
+func serve(w http.ResponseWriter, r *http.Request, name, cache string, data []byte) {
+	w.Header().Set("Cache-Control", cache)
+	// Embedded files carry no modification time; ServeContent picks the
+	// content type from name and handles ranges and HEAD
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(data))
+}
@@ -0,0 +1,73 @@
+package webui
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+var build = fstest.MapFS{
+	"index.html":                {Data: []byte("<!doctype html><div id=root></div>")},
+	"favicon.svg":               {Data: []byte("<svg/>")},
+	"assets/index-D1wrgTda.js":  {Data: []byte("console.log(1)")},
+	"assets/index-Bx93kQ0c.css": {Data: []byte("body{}")},
+}
+
+func get(h http.Handler, path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec
+}
+
+func TestServesFilesWithCaching(t *testing.T) {
+	h := New(build)
+	for path, want := range map[string]struct{ contentType, cache string }{
+		"/assets/index-D1wrgTda.js":  {"text/javascript; charset=utf-8", immutable},
+		"/assets/index-Bx93kQ0c.css": {"text/css; charset=utf-8", immutable},
+		"/favicon.svg":               {"image/svg+xml", revalidate},
+		"/":                          {"text/html; charset=utf-8", revalidate},
+		"/index.html":                {"text/html; charset=utf-8", revalidate},
+	} {
+		rec := get(h, path)
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != want.contentType || rec.Header().Get("Cache-Control") != want.cache {
+			t.Errorf("%s: got %d %q %q", path, rec.Code, rec.Header().Get("Content-Type"), rec.Header().Get("Cache-Control"))
+		}
+	}
+}
+
+func TestSPAFallback(t *testing.T) {
+	h := New(build)
+	for _, path := range []string{"/runs", "/runs/run-1/results", "/settings/"} {
+		rec := get(h, path)
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "id=root") || rec.Header().Get("Cache-Control") != revalidate {
+			t.Errorf("%s: expected index.html, got %d %q", path, rec.Code, rec.Body.String())
+		}
+	}
+	// Missing files and backend paths are never answered with the app
+	for _, path := range []string{"/assets/index-old.js", "/robots.txt", "/api/nope", "/api", "/ws", "/metrics/x", "/healthz"} {
+		if rec := get(h, path); rec.Code != http.StatusNotFound {
+			t.Errorf("%s: expected 404, got %d %q", path, rec.Code, rec.Body.String())
+		}
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/runs", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected POST refused, got %d", rec.Code)
+	}
+}
+
+func TestNotBundled(t *testing.T) {
+	h := New(fstest.MapFS{".gitkeep": {}})
+	for _, path := range []string{"/", "/runs/run-1"} {
+		rec := get(h, path)
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "not bundled") {
+			t.Errorf("%s: expected the placeholder page, got %d %q", path, rec.Code, rec.Body.String())
+		}
+	}
+	// The checked-in dist/ holds only the placeholder unless a build was copied in
+	if rec := get(Handler(), "/"); rec.Code != http.StatusOK {
+		t.Errorf("expected the embedded build to serve /, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,102 @@
+// Package simsign signs SimStack's calls to a simulator's /simulate endpoint
+// and verifies them, so a simulator sharing a secret with its SimStack
+// backend can tell that a request came from it and was sent recently.
+//
+// A signed request carries two headers. X-SimStack-Timestamp is the Unix
+// time of signing in seconds. X-SimStack-Signature is "v1=" and the hex
+// HMAC-SHA256, keyed with the secret, of the timestamp, the method and the
+// URL path, each followed by a newline, then the body.
+package simsign
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TimestampHeader = "X-SimStack-Timestamp"
+	SignatureHeader = "X-SimStack-Signature"
+
+	// DefaultMaxSkew is how far from the verifier's clock a timestamp may
+	// be, either way, for Handler.
+	DefaultMaxSkew = 5 * time.Minute
+
+	version = "v1="
+)
+
+var (
+	// ErrUnsigned means a request lacks either signature header.
+	ErrUnsigned = errors.New("simsign: request is not signed")
+	// ErrBadSignature means the signature doesn't match the request, or the
+	// secret is a different one.
+	ErrBadSignature = errors.New("simsign: signature does not match the request")
+	// ErrSkew means the timestamp is too far from now: a delayed or replayed
+	// request, or clocks out of sync.
+	ErrSkew = errors.New("simsign: timestamp outside the allowed window")
+)
+
+// Signature returns the signature header value for a request signed at
+// timestamp.
+func Signature(secret []byte, timestamp, method, path string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	io.WriteString(mac, timestamp+"\n"+method+"\n"+path+"\n")
+	mac.Write(body)
+	return version + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sign sets h's signature headers for a request with body, signed at now.
+func Sign(h http.Header, secret []byte, now time.Time, method, path string, body []byte) {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	h.Set(TimestampHeader, timestamp)
+	h.Set(SignatureHeader, Signature(secret, timestamp, method, path, body))
+}
+
+// Verify checks h's signature headers against a request with body, and that
+// they were set no more than maxSkew from now.
+func Verify(h http.Header, secret []byte, now time.Time, maxSkew time.Duration, method, path string, body []byte) error {
+	timestamp, signature := h.Get(TimestampHeader), h.Get(SignatureHeader)
+	if timestamp == "" || signature == "" {
+		return ErrUnsigned
+	}
+	if !strings.HasPrefix(signature, version) {
+		return ErrBadSignature
+	}
+	// Compared before the timestamp is trusted for anything
+	if !hmac.Equal([]byte(signature), []byte(Signature(secret, timestamp, method, path, body))) {
+		return ErrBadSignature
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrBadSignature
+	}
+	if skew := now.Sub(time.Unix(unix, 0)); skew > maxSkew || skew < -maxSkew {
+		return ErrSkew
+	}
+	return nil
+}
+
+// Handler passes only requests signed with secret within DefaultMaxSkew on
+// to next, and answers the rest 401 without saying why.
+func Handler(secret []byte, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "unreadable body", http.StatusBadRequest)
+			return
+		}
+		if Verify(r.Header, secret, time.Now(), DefaultMaxSkew, r.Method, r.URL.Path, body) != nil {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,105 @@
+package simsign
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	secret = []byte("0123456789abcdef")
+	signed = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	body   = []byte(`{"arrival_rate":12,"staff":20}`)
+)
+
+func TestVerify(t *testing.T) {
+	h := http.Header{}
+	Sign(h, secret, signed, http.MethodPost, "/simulate", body)
+	if got := h.Get(TimestampHeader); got != "1767323045" {
+		t.Errorf("expected the Unix timestamp, got %s", got)
+	}
+
+	cases := []struct {
+		name   string
+		secret []byte
+		at     time.Time
+		method string
+		path   string
+		body   []byte
+		want   error
+	}{
+		{name: "valid", want: nil},
+		{name: "tampered body", body: []byte(`{"arrival_rate":12,"staff":90}`), want: ErrBadSignature},
+		{name: "other path", path: "/healthz", want: ErrBadSignature},
+		{name: "other method", method: http.MethodPut, want: ErrBadSignature},
+		{name: "other secret", secret: []byte("fedcba9876543210"), want: ErrBadSignature},
+		{name: "within the window", at: signed.Add(5 * time.Minute), want: nil},
+		{name: "replayed later", at: signed.Add(5*time.Minute + time.Second), want: ErrSkew},
+		{name: "from the future", at: signed.Add(-6 * time.Minute), want: ErrSkew},
+	}
+	for _, c := range cases {
+		if c.secret == nil {
+			c.secret = secret
+		}
+		if c.at.IsZero() {
+			c.at = signed
+		}
+		if c.method == "" {
+			c.method = http.MethodPost
+		}
+		if c.path == "" {
+			c.path = "/simulate"
+		}
+		if c.body == nil {
+			c.body = body
+		}
+		if err := Verify(h, c.secret, c.at, 5*time.Minute, c.method, c.path, c.body); !errors.Is(err, c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, err, c.want)
+		}
+	}
+
+	// A timestamp moved into the window invalidates the signature
+	moved := h.Clone()
+	moved.Set(TimestampHeader, "1767323999")
+	if err := Verify(moved, secret, signed, 5*time.Minute, http.MethodPost, "/simulate", body); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("expected an edited timestamp refused, got %v", err)
+	}
+	if err := Verify(http.Header{}, secret, signed, 5*time.Minute, http.MethodPost, "/simulate", body); !errors.Is(err, ErrUnsigned) {
+		t.Errorf("expected an unsigned request refused, got %v", err)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(Handler(secret, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got = string(b)
+	})))
+	defer srv.Close()
+	post := func(sign bool, payload string) int {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/simulate", strings.NewReader(payload))
+		if sign {
+			Sign(req.Header, secret, time.Now(), http.MethodPost, "/simulate", body)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := post(true, string(body)); code != http.StatusOK || got != string(body) {
+		t.Errorf("expected a signed request passed on with its body, got %d %q", code, got)
+	}
+	got = ""
+	for name, code := range map[string]int{"tampered": post(true, `{"staff":90}`), "unsigned": post(false, string(body))} {
+		if code != http.StatusUnauthorized || got != "" {
+			t.Errorf("%s: expected 401 without reaching the handler, got %d", name, code)
+		}
+	}
+}
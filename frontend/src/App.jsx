@@ -11,25 +11,42 @@ function App() {
   const [analysis, setAnalysis] = useState(null)
   const [isRunning, setIsRunning] = useState(false)
   const [exportData, setExportData] = useState(null)
+  // Set when the backend announces it is shutting down
+  const [shutdown, setShutdown] = useState(null)
+  // The backend's build and how far its clock is from ours, from its hello
+  const [backend, setBackend] = useState(null)
   const wsRef = useRef(null)
+  // The run this view follows; other runs' events are ignored
+  const runIdRef = useRef(null)
   const backendUrl = useMemo(() => (import.meta.env.VITE_BACKEND_URL || 'http://localhost:8080'), [])
+  // Sent to /api and /ws when the backend has SIMSTACK_API_KEYS
+  const apiKey = import.meta.env.VITE_SIMSTACK_API_KEY || ''
+  const authHeaders = useMemo(() => (apiKey ? { Authorization: 'Bearer ' + apiKey } : {}), [apiKey])
 
   const fetchMetrics = useCallback(async () => {
     try {
-      const res = await fetch(backendUrl + '/metrics')
+      const res = await fetch(backendUrl + '/api/metrics', { headers: authHeaders })
       const data = await res.json()
       setMetrics(data)
     } catch (error) {
       console.error('Failed to fetch metrics:', error)
     }
-  }, [backendUrl])
+  }, [backendUrl, authHeaders])
 
   useEffect(() => {
-    const ws = new WebSocket(backendUrl.replace('http', 'ws') + '/ws')
+    // Browsers can't set headers on the upgrade, so the key goes as a subprotocol
+    const ws = new WebSocket(backendUrl.replace('http', 'ws') + '/ws', apiKey ? ['bearer', apiKey] : undefined)
     wsRef.current = ws
     ws.onmessage = (ev) => {
       try {
         const msg = JSON.parse(ev.data)
+        if (msg.type === 'hello') {
+          setBackend({ ...msg.payload, driftMs: Date.now() - Date.parse(msg.payload.server_time) })
+          return
+        }
+        if (msg.run_id && runIdRef.current && msg.run_id !== runIdRef.current) {
+          return
+        }
         setEvents((prev) => [...prev, msg])
         
         // Process different event types
@@ -39,9 +56,11 @@ function App() {
           setResults((prev) => [...prev, msg.payload])
         } else if (msg.type === 'analysis') {
           setAnalysis(msg.payload)
-        } else if (msg.type === 'done') {
+        } else if (msg.type === 'done' || msg.type === 'cancelled') {
           setIsRunning(false)
           fetchMetrics()
+        } else if (msg.type === 'shutdown') {
+          setShutdown(msg.payload)
         }
       } catch {
         console.log('WebSocket message:', ev.data)
@@ -51,7 +70,7 @@ function App() {
       wsRef.current = null
     }
     return () => ws.close()
-  }, [backendUrl, fetchMetrics])
+  }, [backendUrl, apiKey, fetchMetrics])
 
   async function startRun() {
     setIsRunning(true)
@@ -61,14 +80,18 @@ function App() {
     setAnalysis(null)
     setMetrics(null)
     setExportData(null)
+    runIdRef.current = null
     
     try {
       const parsedConstraints = JSON.parse(constraints || '{}')
-    await fetch(backendUrl + '/api/run', {
+    const res = await fetch(backendUrl + '/api/run', {
       method: 'POST',
-      headers: { 'Content-Type': 'application/json' },
+      headers: { 'Content-Type': 'application/json', ...authHeaders },
         body: JSON.stringify({ goal, constraints: parsedConstraints }),
       })
+      const started = await res.json()
+      if (!res.ok) throw new Error(`${started.error?.code}: ${started.error?.message}`)
+      runIdRef.current = started.run_id || null
     } catch (e) {
       console.error('Failed to start run:', e)
       setIsRunning(false)
@@ -80,9 +103,13 @@ function App() {
     try {
       const res = await fetch(backendUrl + '/api/export', {
         method: 'POST',
-        headers: { 'Content-Type': 'application/json' },
+        headers: { 'Content-Type': 'application/json', ...authHeaders },
         body: JSON.stringify({ parameters: plan.variants[0]?.parameters || {} }),
       })
+      if (!res.ok) {
+        const { error } = await res.json()
+        throw new Error(`${error?.code}: ${error?.message}`)
+      }
       const blob = await res.blob()
       const text = await blob.text()
       setExportData(text)
@@ -144,29 +171,45 @@ function App() {
               <span>AI is planning and executing simulations...</span>
             </div>
           )}
+          {backend && Math.abs(backend.driftMs) > 5000 && (
+            <div className="status-message">
+              <span>
+                🕒 This machine's clock is {Math.round(Math.abs(backend.driftMs) / 1000)}s{' '}
+                {backend.driftMs > 0 ? 'ahead of' : 'behind'} the backend ({backend.version}); event times may look off.
+              </span>
+            </div>
+          )}
+          {shutdown && (
+            <div className="status-message">
+              <span>
+                🛑 The backend is shutting down. Runs in flight have {Math.round(shutdown.grace_ms / 1000)}s to
+                finish before they are cancelled.
+              </span>
+            </div>
+          )}
         </div>
 
         {/* Performance Metrics */}
-        {metrics && metrics.PlannerMs !== undefined && (
+        {metrics && metrics.planner_ms !== undefined && (
           <div className="card metrics-card">
             <h2>⚡ Performance Metrics</h2>
             <div className="metrics-grid">
               <div className="metric">
                 <div className="metric-label">Planning Time</div>
                 <div className="metric-value">
-                  {metrics.PlannerMs > 0 ? `${metrics.PlannerMs}ms` : '<1ms'}
+                  {metrics.planner_ms > 0 ? `${metrics.planner_ms}ms` : '<1ms'}
                 </div>
               </div>
               <div className="metric">
                 <div className="metric-label">Simulation Time</div>
                 <div className="metric-value">
-                  {metrics.SimulationStartupMs > 0 ? `${metrics.SimulationStartupMs}ms` : '<1ms'}
+                  {metrics.simulation_phase_ms > 0 ? `${metrics.simulation_phase_ms}ms` : '<1ms'}
                 </div>
               </div>
-              {metrics.TokensPerSecond > 0 && (
+              {metrics.tokens_per_second > 0 && (
                 <div className="metric cerebras-speed">
                   <div className="metric-label">Cerebras Speed</div>
-                  <div className="metric-value">{Math.round(metrics.TokensPerSecond)} tokens/s</div>
+                  <div className="metric-value">{Math.round(metrics.tokens_per_second)} tokens/s</div>
                   <div className="metric-sublabel">🔥 Lightning Fast</div>
                 </div>
               )}
@@ -1,19 +1,49 @@
-from fastapi import FastAPI
+from fastapi import FastAPI, HTTPException
 from pydantic import BaseModel
 import math
+import os
+
+import simsign
 
 app = FastAPI(title="Queue Simulator")
+# Calls are signed when the backend shares QUEUE_SIMULATOR_SECRET with
+# this simulator as SIMSTACK_SIGNING_SECRET; see simsign
+if secret := os.environ.get("SIMSTACK_SIGNING_SECRET", ""):
+    app.add_middleware(simsign.Middleware, secret=secret.encode())
 
 
 class QueueInput(BaseModel):
-    arrival_rate: float
-    service_rate: float
+    arrival_rate: float | None = None
+    service_rate: float | None = None
+
+
+def setting(value, name, parse):
+    """value as sent, else the QUEUE_<NAME> environment variable a compose
+    export sets to the run's parameter."""
+    if value is not None:
+        return value
+    key = "QUEUE_" + name.upper()
+    raw = os.environ.get(key, "")
+    if raw == "":
+        raise HTTPException(422, f"{name} is required unless {key} is set")
+    try:
+        return parse(raw)
+    except ValueError:
+        raise HTTPException(500, f"{key}={raw!r} is not a valid {name}")
+
+
+@app.get("/healthz")
+def healthz():
+    return {"status": "ok"}
 
 
 @app.post("/simulate")
 def simulate(inp: QueueInput):
+    arrival_rate = setting(inp.arrival_rate, "arrival_rate", float)
+    service_rate = setting(inp.service_rate, "service_rate", float)
+
     # M/M/1 wait time approximation
-    rho = inp.arrival_rate / inp.service_rate if inp.service_rate > 0 else 0.0
+    rho = arrival_rate / service_rate if service_rate > 0 else 0.0
     
     # Handle different system states
     if rho >= 0.95:
@@ -25,7 +55,7 @@ def simulate(inp: QueueInput):
         queue_length = 0.0
     else:
         # Normal M/M/1 formulas
-        wait = rho / (inp.service_rate * (1 - rho))  # Wait in hours
+        wait = rho / (service_rate * (1 - rho))  # Wait in hours
         queue_length = rho / (1 - rho)  # Average queue length
     
     # Convert wait to minutes and ensure reasonable bounds
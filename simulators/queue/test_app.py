@@ -0,0 +1,39 @@
+import os
+import unittest
+from unittest import mock
+
+from fastapi import HTTPException
+
+from app import QueueInput, simulate
+
+
+class SimulateTest(unittest.TestCase):
+    def test_env_fills_missing_parameters(self):
+        env = {"QUEUE_ARRIVAL_RATE": "4.5", "QUEUE_SERVICE_RATE": "6"}
+        with mock.patch.dict(os.environ, env):
+            from_env = simulate(QueueInput())
+        sent = simulate(QueueInput(arrival_rate=4.5, service_rate=6))
+        self.assertEqual(from_env, sent)
+
+    def test_sent_parameters_win(self):
+        with mock.patch.dict(os.environ, {"QUEUE_ARRIVAL_RATE": "100", "QUEUE_SERVICE_RATE": "6"}):
+            got = simulate(QueueInput(arrival_rate=3))
+        self.assertEqual(got, simulate(QueueInput(arrival_rate=3, service_rate=6)))
+
+    def test_missing_parameter(self):
+        with mock.patch.dict(os.environ, {"QUEUE_SERVICE_RATE": "6"}):
+            os.environ.pop("QUEUE_ARRIVAL_RATE", None)
+            with self.assertRaises(HTTPException) as ctx:
+                simulate(QueueInput())
+        self.assertEqual(ctx.exception.status_code, 422)
+        self.assertIn("QUEUE_ARRIVAL_RATE", ctx.exception.detail)
+
+    def test_invalid_env(self):
+        with mock.patch.dict(os.environ, {"QUEUE_ARRIVAL_RATE": "fast", "QUEUE_SERVICE_RATE": "6"}):
+            with self.assertRaises(HTTPException) as ctx:
+                simulate(QueueInput())
+        self.assertEqual(ctx.exception.status_code, 500)
+
+
+if __name__ == "__main__":
+    unittest.main()
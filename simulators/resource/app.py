@@ -1,18 +1,46 @@
-from fastapi import FastAPI
+from fastapi import FastAPI, HTTPException
 from pydantic import BaseModel
+import os
+
+import simsign
 
 app = FastAPI(title="Resource Allocation Simulator")
+# Calls are signed when the backend shares RESOURCE_SIMULATOR_SECRET with
+# this simulator as SIMSTACK_SIGNING_SECRET; see simsign
+if secret := os.environ.get("SIMSTACK_SIGNING_SECRET", ""):
+    app.add_middleware(simsign.Middleware, secret=secret.encode())
 
 
 class ResourceInput(BaseModel):
-    staff: int
+    staff: int | None = None
     shifts: list[str] | None = None
 
 
+def setting(value, name, parse):
+    """value as sent, else the RESOURCE_<NAME> environment variable a compose
+    export sets to the run's parameter."""
+    if value is not None:
+        return value
+    key = "RESOURCE_" + name.upper()
+    raw = os.environ.get(key, "")
+    if raw == "":
+        raise HTTPException(422, f"{name} is required unless {key} is set")
+    try:
+        return parse(raw)
+    except ValueError:
+        raise HTTPException(500, f"{key}={raw!r} is not a valid {name}")
+
+
+@app.get("/healthz")
+def healthz():
+    return {"status": "ok"}
+
+
 @app.post("/simulate")
 def simulate(inp: ResourceInput):
-    coverage = inp.staff * 0.8
-    satisfaction = min(1.0, 0.5 + (inp.staff / 100.0))
+    staff = setting(inp.staff, "staff", int)
+    coverage = staff * 0.8
+    satisfaction = min(1.0, 0.5 + (staff / 100.0))
     return {
         "metrics": {
             "coverage_units": coverage,
@@ -0,0 +1,30 @@
+import os
+import unittest
+from unittest import mock
+
+from fastapi import HTTPException
+
+from app import ResourceInput, simulate
+
+
+class SimulateTest(unittest.TestCase):
+    def test_env_fills_missing_parameters(self):
+        with mock.patch.dict(os.environ, {"RESOURCE_STAFF": "25"}):
+            got = simulate(ResourceInput())
+        self.assertEqual(got, simulate(ResourceInput(staff=25)))
+
+    def test_sent_parameters_win(self):
+        with mock.patch.dict(os.environ, {"RESOURCE_STAFF": "25"}):
+            got = simulate(ResourceInput(staff=10))
+        self.assertEqual(got, simulate(ResourceInput(staff=10)))
+
+    def test_missing_parameter(self):
+        with mock.patch.dict(os.environ):
+            os.environ.pop("RESOURCE_STAFF", None)
+            with self.assertRaises(HTTPException) as ctx:
+                simulate(ResourceInput())
+        self.assertEqual(ctx.exception.status_code, 422)
+
+
+if __name__ == "__main__":
+    unittest.main()
@@ -0,0 +1,122 @@
+"""Verifies SimStack's signed calls to a simulator's /simulate endpoint, as
+backend/simsign does for Go simulators, so a simulator sharing a secret
+with its SimStack backend can tell that a request came from it and was
+sent recently.
+
+A signed request carries two headers. X-SimStack-Timestamp is the Unix time
+of signing in seconds. X-SimStack-Signature is "v1=" and the hex
+HMAC-SHA256, keyed with the secret, of the timestamp, the method and the URL
+path, each followed by a newline, then the body.
+"""
+
+import hashlib
+import hmac
+import time
+
+TIMESTAMP_HEADER = "X-SimStack-Timestamp"
+SIGNATURE_HEADER = "X-SimStack-Signature"
+
+# How far from the verifier's clock a timestamp may be, either way, in
+# seconds
+DEFAULT_MAX_SKEW = 5 * 60
+
+_VERSION = "v1="
+
+
+class SignatureError(Exception):
+    """A request that fails verification."""
+
+
+class Unsigned(SignatureError):
+    """The request lacks either signature header."""
+
+
+class BadSignature(SignatureError):
+    """The signature doesn't match the request, or the secret is a
+    different one."""
+
+
+class Skew(SignatureError):
+    """The timestamp is too far from now: a delayed or replayed request, or
+    clocks out of sync."""
+
+
+def signature(secret: bytes, timestamp: str, method: str, path: str, body: bytes) -> str:
+    """The signature header value for a request signed at timestamp."""
+    mac = hmac.new(secret, f"{timestamp}\n{method}\n{path}\n".encode(), hashlib.sha256)
+    mac.update(body)
+    return _VERSION + mac.hexdigest()
+
+
+def verify(headers, secret: bytes, method: str, path: str, body: bytes,
+           now: float | None = None, max_skew: float = DEFAULT_MAX_SKEW) -> None:
+    """Checks the signature headers in headers, a mapping by header name,
+    against a request with body, and that they were set no more than
+    max_skew seconds from now. Raises a SignatureError saying why not."""
+    timestamp, sig = _header(headers, TIMESTAMP_HEADER), _header(headers, SIGNATURE_HEADER)
+    if not timestamp or not sig:
+        raise Unsigned("request is not signed")
+    if not sig.startswith(_VERSION):
+        raise BadSignature("signature does not match the request")
+    # Compared before the timestamp is trusted for anything
+    if not hmac.compare_digest(sig.encode(), signature(secret, timestamp, method, path, body).encode()):
+        raise BadSignature("signature does not match the request")
+    try:
+        unix = int(timestamp)
+    except ValueError:
+        raise BadSignature("signature does not match the request") from None
+    if now is None:
+        now = time.time()
+    if abs(now - unix) > max_skew:
+        raise Skew("timestamp outside the allowed window")
+
+
+def _header(headers, name):
+    value = headers.get(name)
+    return value if value is not None else headers.get(name.lower())
+
+
+class Middleware:
+    """ASGI middleware passing only requests to paths signed with secret
+    within max_skew on to app, and answering the rest 401 without saying
+    why. Other paths, such as /healthz, pass unchecked.
+
+    With FastAPI: app.add_middleware(simsign.Middleware, secret=b"...")
+    """
+
+    def __init__(self, app, secret: bytes, max_skew: float = DEFAULT_MAX_SKEW, paths=("/simulate",)):
+        self.app, self.secret, self.max_skew, self.paths = app, secret, max_skew, paths
+
+    async def __call__(self, scope, receive, send):
+        if scope["type"] != "http" or scope["path"] not in self.paths:
+            await self.app(scope, receive, send)
+            return
+
+        chunks, more = [], True
+        while more:
+            message = await receive()
+            if message["type"] == "http.disconnect":
+                return
+            chunks.append(message.get("body", b""))
+            more = message.get("more_body", False)
+        body = b"".join(chunks)
+
+        headers = {k.decode("latin-1").lower(): v.decode("latin-1") for k, v in scope["headers"]}
+        try:
+            verify(headers, self.secret, scope["method"], scope["path"], body, max_skew=self.max_skew)
+        except SignatureError:
+            await send({"type": "http.response.start", "status": 401,
+                        "headers": [(b"content-type", b"text/plain; charset=utf-8")]})
+            await send({"type": "http.response.body", "body": b"unauthorized\n"})
+            return
+
+        replayed = False
+
+        async def replay():
+            nonlocal replayed
+            if replayed:
+                return await receive()
+            replayed = True
+            return {"type": "http.request", "body": body, "more_body": False}
+
+        await self.app(scope, replay, send)
@@ -0,0 +1,88 @@
+import asyncio
+import unittest
+
+import simsign
+
+SECRET = b"0123456789abcdef"
+NOW = 1767323045
+BODY = b'{"staff":12}'
+
+
+def signed(timestamp=NOW, body=BODY, secret=SECRET):
+    ts = str(timestamp)
+    return {simsign.TIMESTAMP_HEADER: ts, simsign.SIGNATURE_HEADER: simsign.signature(secret, ts, "POST", "/simulate", body)}
+
+
+class VerifyTest(unittest.TestCase):
+    def test_matches_go(self):
+        # backend/simsign.Signature for the same inputs
+        self.assertEqual(
+            simsign.signature(SECRET, "1767323045", "POST", "/simulate", BODY),
+            "v1=85e57bf5dc63d1cc84593bd0bafe4b64cd27fe2dab5254d2b41c3f1b4bfbd9b5",
+        )
+
+    def test_valid(self):
+        simsign.verify(signed(), SECRET, "POST", "/simulate", BODY, now=NOW + 10)
+
+    def test_lower_case_headers(self):
+        headers = {k.lower(): v for k, v in signed().items()}
+        simsign.verify(headers, SECRET, "POST", "/simulate", BODY, now=NOW)
+
+    def test_failures(self):
+        cases = {
+            "unsigned": ({}, BODY, SECRET, simsign.Unsigned),
+            "tampered body": (signed(), b'{"staff":99}', SECRET, simsign.BadSignature),
+            "other secret": (signed(), BODY, b"fedcba9876543210", simsign.BadSignature),
+            "no version": ({**signed(), simsign.SIGNATURE_HEADER: "abc"}, BODY, SECRET, simsign.BadSignature),
+        }
+        for name, (headers, body, secret, error) in cases.items():
+            with self.subTest(name):
+                with self.assertRaises(error):
+                    simsign.verify(headers, secret, "POST", "/simulate", body, now=NOW)
+
+    def test_skew_window(self):
+        simsign.verify(signed(), SECRET, "POST", "/simulate", BODY, now=NOW - 60, max_skew=60)
+        with self.assertRaises(simsign.Skew):
+            simsign.verify(signed(), SECRET, "POST", "/simulate", BODY, now=NOW + 61, max_skew=60)
+        with self.assertRaises(simsign.Skew):
+            simsign.verify(signed(), SECRET, "POST", "/simulate", BODY, now=NOW + simsign.DEFAULT_MAX_SKEW + 1)
+
+
+class MiddlewareTest(unittest.TestCase):
+    def call(self, path, headers, body=BODY):
+        seen = {}
+
+        async def app(scope, receive, send):
+            seen["body"] = (await receive())["body"]
+            await send({"type": "http.response.start", "status": 200, "headers": []})
+
+        messages = [{"type": "http.request", "body": body[:4], "more_body": True},
+                    {"type": "http.request", "body": body[4:], "more_body": False}]
+
+        async def receive():
+            return messages.pop(0)
+
+        sent = []
+
+        async def send(message):
+            sent.append(message)
+
+        scope = {"type": "http", "method": "POST", "path": path,
+                 "headers": [(k.lower().encode(), v.encode()) for k, v in headers.items()]}
+        middleware = simsign.Middleware(app, secret=SECRET, max_skew=float("inf"))
+        asyncio.run(middleware(scope, receive, send))
+        return sent[0]["status"], seen.get("body")
+
+    def test_passes_signed_requests_with_their_body(self):
+        self.assertEqual(self.call("/simulate", signed()), (200, BODY))
+
+    def test_refuses_unsigned_and_tampered_requests(self):
+        self.assertEqual(self.call("/simulate", {}), (401, None))
+        self.assertEqual(self.call("/simulate", signed(), body=b'{"staff":99}'), (401, None))
+
+    def test_other_paths_unchecked(self):
+        self.assertEqual(self.call("/healthz", {})[0], 200)
+
+
+if __name__ == "__main__":
+    unittest.main()
@@ -1,17 +1,45 @@
-from fastapi import FastAPI
+from fastapi import FastAPI, HTTPException
 from pydantic import BaseModel
+import os
+
+import simsign
 
 app = FastAPI(title="Traffic Simulator")
+# Calls are signed when the backend shares TRAFFIC_SIMULATOR_SECRET with
+# this simulator as SIMSTACK_SIGNING_SECRET; see simsign
+if secret := os.environ.get("SIMSTACK_SIGNING_SECRET", ""):
+    app.add_middleware(simsign.Middleware, secret=secret.encode())
 
 
 class TrafficInput(BaseModel):
-    density: float
+    density: float | None = None
     signal_timing: float | None = None
 
 
+def setting(value, name, parse):
+    """value as sent, else the TRAFFIC_<NAME> environment variable a compose
+    export sets to the run's parameter."""
+    if value is not None:
+        return value
+    key = "TRAFFIC_" + name.upper()
+    raw = os.environ.get(key, "")
+    if raw == "":
+        raise HTTPException(422, f"{name} is required unless {key} is set")
+    try:
+        return parse(raw)
+    except ValueError:
+        raise HTTPException(500, f"{key}={raw!r} is not a valid {name}")
+
+
+@app.get("/healthz")
+def healthz():
+    return {"status": "ok"}
+
+
 @app.post("/simulate")
 def simulate(inp: TrafficInput):
-    speed = max(5.0, 60.0 * (1.0 - min(1.0, max(0.0, inp.density))))
+    density = setting(inp.density, "density", float)
+    speed = max(5.0, 60.0 * (1.0 - min(1.0, max(0.0, density))))
     throughput = speed * 10.0
     return {
         "metrics": {
@@ -0,0 +1,30 @@
+import os
+import unittest
+from unittest import mock
+
+from fastapi import HTTPException
+
+from app import TrafficInput, simulate
+
+
+class SimulateTest(unittest.TestCase):
+    def test_env_fills_missing_parameters(self):
+        with mock.patch.dict(os.environ, {"TRAFFIC_DENSITY": "0.4"}):
+            got = simulate(TrafficInput())
+        self.assertEqual(got, simulate(TrafficInput(density=0.4)))
+
+    def test_sent_parameters_win(self):
+        with mock.patch.dict(os.environ, {"TRAFFIC_DENSITY": "0.9"}):
+            got = simulate(TrafficInput(density=0.1))
+        self.assertEqual(got, simulate(TrafficInput(density=0.1)))
+
+    def test_missing_parameter(self):
+        with mock.patch.dict(os.environ):
+            os.environ.pop("TRAFFIC_DENSITY", None)
+            with self.assertRaises(HTTPException) as ctx:
+                simulate(TrafficInput())
+        self.assertEqual(ctx.exception.status_code, 422)
+
+
+if __name__ == "__main__":
+    unittest.main()